@@ -16,6 +16,20 @@ import (
 
 func main() {
 	cfg := config.FromEnv()
+	secretsCtx, cancelSecrets := context.WithTimeout(context.Background(), 30*time.Second)
+	err := cfg.ResolveSecrets(secretsCtx)
+	cancelSecrets()
+	if err != nil {
+		log.Fatalf("load secrets failed: %v", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "encrypt-secrets" {
+		n, err := app.EncryptSecrets(cfg)
+		if err != nil {
+			log.Fatalf("encrypt secrets failed: %v", err)
+		}
+		log.Printf("encrypted %d stored secrets", n)
+		return
+	}
 	if cfg.JWTSecret == "" {
 		log.Println("warning: JWT_SECRET is empty")
 	}
@@ -16,11 +16,16 @@ import (
 type App struct {
 	cfg    config.Config
 	server *http.Server
-	repo   *sqlite.Repository
-	h      *handler.Handler
+	// nodeServer is the mutual TLS listener for node agents, nil when
+	// MTLS_ADDR is not set.
+	nodeServer *http.Server
+	repo       *sqlite.Repository
+	h          *handler.Handler
 }
 
-func New(cfg config.Config) (*App, error) {
+// OpenRepository opens the database cfg points at, with field encryption
+// enabled when a master key is set.
+func OpenRepository(cfg config.Config) (*sqlite.Repository, error) {
 	var (
 		repo *sqlite.Repository
 		err  error
@@ -41,8 +46,38 @@ func New(cfg config.Config) (*App, error) {
 		return nil, fmt.Errorf("unsupported DB_TYPE %q", cfg.DBType)
 	}
 
+	if cfg.MasterKey != "" {
+		if err := repo.EnableFieldEncryption(cfg.MasterKey); err != nil {
+			_ = repo.Close()
+			return nil, fmt.Errorf("field encryption: %w", err)
+		}
+	}
+	return repo, nil
+}
+
+// EncryptSecrets encrypts the node secrets and share tokens still stored in
+// plaintext and returns how many values were rewritten.
+func EncryptSecrets(cfg config.Config) (int, error) {
+	if cfg.MasterKey == "" {
+		return 0, fmt.Errorf("DATA_MASTER_KEY is not set")
+	}
+	repo, err := OpenRepository(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer repo.Close()
+	return repo.EncryptStoredSecrets()
+}
+
+func New(cfg config.Config) (*App, error) {
+	repo, err := OpenRepository(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	h := handler.New(repo, cfg.JWTSecret)
-	router := httpserver.NewRouter(h, cfg.JWTSecret)
+	h.SetSecrets(cfg.Secrets)
+	router := httpserver.NewRouter(h)
 
 	s := &http.Server{
 		Addr:              cfg.Addr,
@@ -53,14 +88,40 @@ func New(cfg config.Config) (*App, error) {
 		IdleTimeout:       60 * time.Second,
 	}
 
-	return &App{cfg: cfg, server: s, repo: repo, h: h}, nil
+	a := &App{cfg: cfg, server: s, repo: repo, h: h}
+	if cfg.MTLSAddr != "" {
+		tlsConfig, err := h.NodeTLSConfig(cfg.MTLSServerNames)
+		if err != nil {
+			return nil, fmt.Errorf("node mtls: %w", err)
+		}
+		// Only the node agent endpoints are served here.
+		nodeMux := http.NewServeMux()
+		nodeMux.Handle("/system-info", router)
+		nodeMux.Handle("/flow/", router)
+		a.nodeServer = &http.Server{
+			Addr:              cfg.MTLSAddr,
+			Handler:           nodeMux,
+			TLSConfig:         tlsConfig,
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+		}
+	}
+	return a, nil
 }
 
 func (a *App) Run() error {
 	if a.h != nil {
 		a.h.StartBackgroundJobs()
 	}
-	return a.server.ListenAndServe()
+	if a.nodeServer == nil {
+		return a.server.ListenAndServe()
+	}
+	errCh := make(chan error, 2)
+	go func() { errCh <- a.server.ListenAndServe() }()
+	go func() { errCh <- a.nodeServer.ListenAndServeTLS("", "") }()
+	return <-errCh
 }
 
 func (a *App) Shutdown(ctx context.Context) error {
@@ -68,6 +129,11 @@ func (a *App) Shutdown(ctx context.Context) error {
 		a.h.StopBackgroundJobs()
 	}
 	shutdownErr := a.server.Shutdown(ctx)
+	if a.nodeServer != nil {
+		if err := a.nodeServer.Shutdown(ctx); err != nil && shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
 	closeErr := a.repo.Close()
 	if shutdownErr != nil {
 		return shutdownErr
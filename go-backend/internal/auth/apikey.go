@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// APIKeyHeader carries an API key in place of the Authorization token.
+	APIKeyHeader = "X-Api-Key"
+
+	apiKeyPrefix = "flvx_"
+)
+
+// GenerateAPIKey returns a new API key and the short prefix shown in key
+// lists so users can tell their keys apart.
+func GenerateAPIKey() (key string, prefix string, err error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + hex.EncodeToString(buf)
+	return key, key[:len(apiKeyPrefix)+8], nil
+}
+
+// HashAPIKey is the form API keys are stored and looked up in.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(key)))
+	return hex.EncodeToString(sum[:])
+}
@@ -13,6 +13,20 @@ import (
 const (
 	algorithm  = "HmacSHA256"
 	expireTime = 90 * 24 * time.Hour
+
+	// AccessTokenTTL is the lifetime of tokens issued at login and refresh.
+	// Clients keep the session alive through /user/refresh.
+	AccessTokenTTL = 30 * time.Minute
+	// RefreshTokenTTL is how long a refresh token stays usable without being
+	// exchanged; every exchange starts the window again.
+	RefreshTokenTTL = 30 * 24 * time.Hour
+	// ImpersonationTokenTTL is the lifetime of tokens an admin obtains for
+	// another user. They cannot be refreshed.
+	ImpersonationTokenTTL = 15 * time.Minute
+
+	// DefaultAudience is the aud claim of panel tokens when jwt_audience is
+	// not configured.
+	DefaultAudience = "flvx-panel"
 )
 
 type Claims struct {
@@ -22,24 +36,83 @@ type Claims struct {
 	User   string `json:"user"`
 	Name   string `json:"name"`
 	RoleID int    `json:"role_id"`
+	// Aud names the service the token was issued for. Tokens issued before
+	// audiences were introduced have none.
+	Aud string `json:"aud,omitempty"`
+	// Permissions is the user's permission_mask at login time.
+	Permissions int64 `json:"permissions,omitempty"`
+	// Sid is the refresh_token row the token was issued under. Revoking the
+	// row invalidates the token before it expires. Only impersonation tokens
+	// are issued outside a session; the panel refuses any other token
+	// without one.
+	Sid int64 `json:"sid,omitempty"`
+	// Act is the admin who obtained this token to act as the user. Tokens
+	// the user got by logging in have none.
+	Act int64 `json:"act,omitempty"`
 }
 
 type tokenHeader struct {
 	Alg string `json:"alg"`
 	Typ string `json:"typ"`
+	// Kid names the rotated key the token was signed with. Tokens signed
+	// with the static JWT_SECRET have none.
+	Kid string `json:"kid,omitempty"`
 }
 
 func GenerateToken(userID int64, username string, roleID int, secret string) (string, error) {
-	now := time.Now()
-	header := tokenHeader{Alg: algorithm, Typ: "JWT"}
-	claims := Claims{
-		Sub:    strconv.FormatInt(userID, 10),
-		Iat:    now.Unix(),
-		Exp:    now.Add(expireTime).Unix(),
-		User:   username,
-		Name:   username,
-		RoleID: roleID,
+	return GenerateTokenWithPermissions(userID, username, roleID, 0, secret)
+}
+
+// GenerateTokenWithPermissions issues a token that also carries the user's
+// delegated permission bits.
+func GenerateTokenWithPermissions(userID int64, username string, roleID int, permissions int64, secret string) (string, error) {
+	return GenerateTokenForAudience(userID, username, roleID, permissions, DefaultAudience, secret)
+}
+
+// GenerateTokenForAudience issues a token whose aud claim is audience. An
+// empty audience leaves the claim out.
+func GenerateTokenForAudience(userID int64, username string, roleID int, permissions int64, audience string, secret string) (string, error) {
+	return generateToken(newClaims(userID, username, roleID, permissions, audience), expireTime, SigningKey{Secret: secret})
+}
+
+// GenerateAccessToken issues a short-lived token bound to the refresh session
+// sessionID.
+func GenerateAccessToken(userID int64, username string, roleID int, permissions int64, audience string, sessionID int64, secret string) (string, error) {
+	return GenerateAccessTokenWithKey(userID, username, roleID, permissions, audience, sessionID, SigningKey{Secret: secret})
+}
+
+// GenerateAccessTokenWithKey is GenerateAccessToken signed with key, whose
+// kid is put in the token header.
+func GenerateAccessTokenWithKey(userID int64, username string, roleID int, permissions int64, audience string, sessionID int64, key SigningKey) (string, error) {
+	claims := newClaims(userID, username, roleID, permissions, audience)
+	claims.Sid = sessionID
+	return generateToken(claims, AccessTokenTTL, key)
+}
+
+// GenerateImpersonationTokenWithKey issues a short-lived token for the user
+// that records actorID as the admin acting on their behalf.
+func GenerateImpersonationTokenWithKey(userID int64, username string, roleID int, permissions int64, audience string, actorID int64, key SigningKey) (string, error) {
+	claims := newClaims(userID, username, roleID, permissions, audience)
+	claims.Act = actorID
+	return generateToken(claims, ImpersonationTokenTTL, key)
+}
+
+func newClaims(userID int64, username string, roleID int, permissions int64, audience string) Claims {
+	return Claims{
+		Sub:         strconv.FormatInt(userID, 10),
+		User:        username,
+		Name:        username,
+		RoleID:      roleID,
+		Permissions: permissions,
+		Aud:         audience,
 	}
+}
+
+func generateToken(claims Claims, ttl time.Duration, key SigningKey) (string, error) {
+	now := time.Now()
+	header := tokenHeader{Alg: algorithm, Typ: "JWT", Kid: key.Kid}
+	claims.Iat = now.Unix()
+	claims.Exp = now.Add(ttl).Unix()
 
 	headerPart, err := encodeJSON(header)
 	if err != nil {
@@ -49,7 +122,7 @@ func GenerateToken(userID int64, username string, roleID int, secret string) (st
 	if err != nil {
 		return "", err
 	}
-	sig := sign(headerPart+"."+payloadPart, secret)
+	sig := sign(headerPart+"."+payloadPart, key.Secret)
 
 	return headerPart + "." + payloadPart + "." + sig, nil
 }
@@ -67,7 +140,10 @@ func ParseClaims(token, secret string) (Claims, error) {
 	if len(parts) != 3 {
 		return Claims{}, errors.New("invalid token")
 	}
+	return parseClaims(parts, secret)
+}
 
+func parseClaims(parts []string, secret string) (Claims, error) {
 	signedContent := parts[0] + "." + parts[1]
 	expected := sign(signedContent, secret)
 	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
@@ -91,6 +167,15 @@ func ParseClaims(token, secret string) (Claims, error) {
 	return claims, nil
 }
 
+// AcceptsAudience reports whether the token was issued for audience. Tokens
+// without an aud claim are accepted only when allowLegacy is set.
+func (c Claims) AcceptsAudience(audience string, allowLegacy bool) bool {
+	if c.Aud == "" {
+		return allowLegacy
+	}
+	return c.Aud == audience
+}
+
 func splitToken(token string) []string {
 	parts := make([]string, 0, 3)
 	current := ""
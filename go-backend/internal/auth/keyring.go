@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// SigningKey is one HMAC secret tokens are signed with, named by Kid in the
+// token header. ExpiresAt (unix seconds, 0 for never) is when tokens signed
+// with it stop being accepted.
+type SigningKey struct {
+	Kid       string
+	Secret    string
+	ExpiresAt int64
+}
+
+func (k SigningKey) activeAt(now int64) bool {
+	return k.ExpiresAt == 0 || k.ExpiresAt > now
+}
+
+// Keyring holds the keys tokens are verified against. New tokens are signed
+// with the newest rotated key, or with the static secret (JWT_SECRET) until
+// the first rotation. Retired keys keep verifying the tokens they signed
+// until they expire, so a rotation does not log anybody out.
+type Keyring struct {
+	mu      sync.RWMutex
+	static  SigningKey
+	current SigningKey
+	keys    map[string]SigningKey
+}
+
+// NewKeyring returns a keyring that signs and verifies with secret alone.
+func NewKeyring(secret string) *Keyring {
+	static := SigningKey{Secret: secret}
+	return &Keyring{static: static, current: static, keys: map[string]SigningKey{}}
+}
+
+// SetKeys replaces the rotated keys, given oldest first; the last one signs
+// new tokens. The static secret keeps verifying tokens until staticExpiresAt
+// (unix seconds, 0 for never).
+func (k *Keyring) SetKeys(keys []SigningKey, staticExpiresAt int64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.static.ExpiresAt = staticExpiresAt
+	k.current = k.static
+	k.keys = make(map[string]SigningKey, len(keys))
+	for _, key := range keys {
+		k.keys[key.Kid] = key
+		k.current = key
+	}
+}
+
+// Current is the key new tokens are signed with.
+func (k *Keyring) Current() SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+func (k *Keyring) lookup(kid string, now int64) (SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key := k.static
+	if kid != "" {
+		var ok bool
+		if key, ok = k.keys[kid]; !ok {
+			return SigningKey{}, false
+		}
+	}
+	return key, key.activeAt(now)
+}
+
+// ParseClaimsWithKeyring verifies token against the key its header names.
+func ParseClaimsWithKeyring(token string, keys *Keyring) (Claims, error) {
+	parts := splitToken(token)
+	if len(parts) != 3 {
+		return Claims{}, errors.New("invalid token")
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Claims{}, err
+	}
+	var header tokenHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return Claims{}, err
+	}
+	key, ok := keys.lookup(header.Kid, time.Now().Unix())
+	if !ok {
+		return Claims{}, errors.New("unknown or expired signing key")
+	}
+	return parseClaims(parts, key.Secret)
+}
+
+// ValidateTokenWithKeyring is ValidateToken for a keyring.
+func ValidateTokenWithKeyring(token string, keys *Keyring) (Claims, bool) {
+	claims, err := ParseClaimsWithKeyring(token, keys)
+	if err != nil {
+		return Claims{}, false
+	}
+	return claims, true
+}
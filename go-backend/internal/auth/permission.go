@@ -0,0 +1,28 @@
+package auth
+
+// Permission is a capability bit that can be delegated to a non-admin user
+// through the user's permission_mask. Full admins (role_id 0) hold every bit.
+type Permission int64
+
+const (
+	PermManageUsers Permission = 1 << iota
+	PermManageNodes
+	PermManageTunnels
+	PermViewLogs
+	PermManageConfig
+
+	PermAll = PermManageUsers | PermManageNodes | PermManageTunnels | PermViewLogs | PermManageConfig
+)
+
+// EffectivePermissions returns the permission bits carried by the token.
+func (c Claims) EffectivePermissions() Permission {
+	if c.RoleID == 0 {
+		return PermAll
+	}
+	return Permission(c.Permissions) & PermAll
+}
+
+// HasPermission reports whether the token holds every bit of perm.
+func (c Claims) HasPermission(perm Permission) bool {
+	return c.EffectivePermissions()&perm == perm
+}
@@ -0,0 +1,86 @@
+package auth
+
+// Action is a named operation a role can be granted, such as "node:write".
+// Full admins (role_id 0) may perform every action; other roles get the
+// actions stored for them in role_permission, plus whatever their delegated
+// permission_mask bits imply.
+type Action string
+
+const (
+	ActionUserRead         Action = "user:read"
+	ActionUserWrite        Action = "user:write"
+	ActionNodeRead         Action = "node:read"
+	ActionNodeWrite        Action = "node:write"
+	ActionTunnelRead       Action = "tunnel:read"
+	ActionTunnelWrite      Action = "tunnel:write"
+	ActionConfigWrite      Action = "config:write"
+	ActionGroupManage      Action = "group:manage"
+	ActionFederationManage Action = "federation:manage"
+	ActionLogRead          Action = "log:read"
+)
+
+// AllActions lists every action a custom role can be granted, in display
+// order.
+var AllActions = []Action{
+	ActionUserRead,
+	ActionUserWrite,
+	ActionNodeRead,
+	ActionNodeWrite,
+	ActionTunnelRead,
+	ActionTunnelWrite,
+	ActionConfigWrite,
+	ActionGroupManage,
+	ActionFederationManage,
+	ActionLogRead,
+}
+
+// Built-in role IDs. Custom roles are numbered after them.
+const (
+	RoleAdmin = 0
+	RoleUser  = 1
+)
+
+// permissionActions maps each permission_mask bit to the actions it has
+// always allowed, so delegated masks keep working next to roles.
+var permissionActions = map[Permission][]Action{
+	PermManageUsers:   {ActionUserRead, ActionUserWrite},
+	PermManageNodes:   {ActionNodeRead, ActionNodeWrite},
+	PermManageTunnels: {ActionTunnelRead, ActionTunnelWrite},
+	PermViewLogs:      {ActionLogRead},
+	PermManageConfig:  {ActionConfigWrite},
+}
+
+// ValidAction reports whether s names a known action.
+func ValidAction(s string) bool {
+	for _, a := range AllActions {
+		if string(a) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the token may perform action. roleActions are the
+// actions stored for the token's role; they are ignored for admins.
+func (c Claims) Can(action Action, roleActions []string) bool {
+	if c.RoleID == RoleAdmin {
+		return true
+	}
+	perms := c.EffectivePermissions()
+	for bit, actions := range permissionActions {
+		if perms&bit == 0 {
+			continue
+		}
+		for _, a := range actions {
+			if a == action {
+				return true
+			}
+		}
+	}
+	for _, a := range roleActions {
+		if a == string(action) {
+			return true
+		}
+	}
+	return false
+}
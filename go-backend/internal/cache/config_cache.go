@@ -0,0 +1,69 @@
+// Package cache holds small in-process caches shared by the panel.
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultConfigTTL is how long a config value stays cached when neither the
+// caller nor ConfigCache.TTL says otherwise.
+const DefaultConfigTTL = 60 * time.Second
+
+// ConfigCache is a read-through cache of config values keyed by name.
+// Entries expire after their TTL; writers call Invalidate so the next read
+// goes back to the database.
+type ConfigCache struct {
+	// TTL applies to Set calls that pass a non-positive ttl.
+	TTL time.Duration
+
+	entries sync.Map
+}
+
+type configEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+func NewConfigCache() *ConfigCache {
+	return &ConfigCache{TTL: DefaultConfigTTL}
+}
+
+// Get returns the cached value of key, if present and not expired.
+func (c *ConfigCache) Get(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	raw, ok := c.entries.Load(key)
+	if !ok {
+		return "", false
+	}
+	entry := raw.(configEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.entries.CompareAndDelete(key, raw)
+		return "", false
+	}
+	return entry.value, true
+}
+
+// Set caches value under key for ttl, or for c.TTL when ttl is not positive.
+func (c *ConfigCache) Set(key, value string, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	if ttl <= 0 {
+		ttl = c.TTL
+	}
+	if ttl <= 0 {
+		ttl = DefaultConfigTTL
+	}
+	c.entries.Store(key, configEntry{value: value, expiresAt: time.Now().Add(ttl)})
+}
+
+// Invalidate drops the cached value of key.
+func (c *ConfigCache) Invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.entries.Delete(key)
+}
@@ -0,0 +1,74 @@
+package captcha
+
+import (
+	"context"
+	"encoding/base64"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSiteVerifyPostsSecretAndToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		if r.PostForm.Get("secret") != "hc-secret" || r.PostForm.Get("remoteip") != "203.0.113.7" {
+			t.Errorf("unexpected form %v", r.PostForm)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"success":` + map[bool]string{true: "true", false: "false"}[r.PostForm.Get("response") == "good"] + `}`))
+	}))
+	defer server.Close()
+
+	p := NewHCaptcha("hc-secret").WithEndpoint(server.URL)
+	if ok, err := p.Verify(context.Background(), "good", "203.0.113.7"); err != nil || !ok {
+		t.Fatalf("expected a good token to pass, got %v %v", ok, err)
+	}
+	if ok, _ := p.Verify(context.Background(), "bad", "203.0.113.7"); ok {
+		t.Fatalf("expected a bad token to fail")
+	}
+	if ok, _ := NewTurnstile("").WithEndpoint(server.URL).Verify(context.Background(), "good", ""); ok {
+		t.Fatalf("expected a provider without a secret to fail")
+	}
+}
+
+func TestImageCaptchaIsSingleUse(t *testing.T) {
+	c := NewImage()
+	id, dataURL, err := c.Generate(time.Now())
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(dataURL, "data:image/png;base64,"))
+	if err != nil {
+		t.Fatalf("decode data url: %v", err)
+	}
+	if _, err := png.Decode(strings.NewReader(string(raw))); err != nil {
+		t.Fatalf("decode png: %v", err)
+	}
+
+	code := c.pending[id].code
+	if ok, _ := c.Verify(context.Background(), id+":"+code, ""); !ok {
+		t.Fatalf("expected the right answer to pass")
+	}
+	if ok, _ := c.Verify(context.Background(), id+":"+code, ""); ok {
+		t.Fatalf("expected a challenge to be usable once")
+	}
+
+	id, _, _ = c.Generate(time.Now())
+	code = c.pending[id].code
+	if ok, _ := c.Verify(context.Background(), id+":00000x", ""); ok {
+		t.Fatalf("expected a wrong answer to fail")
+	}
+	if ok, _ := c.Verify(context.Background(), id+":"+code, ""); ok {
+		t.Fatalf("expected a wrong answer to spend the challenge")
+	}
+
+	id, _, _ = c.Generate(time.Now().Add(-imageTTL))
+	if ok, _ := c.Verify(context.Background(), id+":"+c.pending[id].code, ""); ok {
+		t.Fatalf("expected an expired challenge to fail")
+	}
+}
@@ -0,0 +1,202 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/png"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	imageCodeLength = 5
+	imageTTL        = 5 * time.Minute
+	imageScale      = 5
+	imageWidth      = 150
+	imageHeight     = 50
+	// imageMaxPending bounds the challenges kept in memory; the oldest are
+	// dropped first when it is reached.
+	imageMaxPending = 10000
+)
+
+// digitGlyphs is a 3x5 bitmap font for 0-9, one row per string.
+var digitGlyphs = [10][5]string{
+	{"###", "#.#", "#.#", "#.#", "###"},
+	{".#.", "##.", ".#.", ".#.", "###"},
+	{"###", "..#", "###", "#..", "###"},
+	{"###", "..#", "###", "..#", "###"},
+	{"#.#", "#.#", "###", "..#", "..#"},
+	{"###", "#..", "###", "..#", "###"},
+	{"###", "#..", "###", "#.#", "###"},
+	{"###", "..#", ".#.", ".#.", ".#."},
+	{"###", "#.#", "###", "#.#", "###"},
+	{"###", "#.#", "###", "..#", "###"},
+}
+
+// Image is the built-in captcha: a PNG of five distorted digits. A challenge
+// can be answered once, within five minutes.
+type Image struct {
+	mu      sync.Mutex
+	pending map[string]imageChallenge
+}
+
+type imageChallenge struct {
+	code      string
+	expiresAt time.Time
+}
+
+// NewImage returns an empty challenge store.
+func NewImage() *Image {
+	return &Image{pending: make(map[string]imageChallenge)}
+}
+
+func (c *Image) Name() string { return ProviderImage }
+
+// Generate creates a challenge and returns its ID and the PNG as a data URL.
+func (c *Image) Generate(now time.Time) (id, dataURL string, err error) {
+	code := make([]byte, imageCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	id = hex.EncodeToString(raw)
+
+	img, err := renderDigits(string(code))
+	if err != nil {
+		return "", "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(now)
+	if len(c.pending) >= imageMaxPending {
+		var oldestID string
+		var oldest time.Time
+		for k, v := range c.pending {
+			if oldestID == "" || v.expiresAt.Before(oldest) {
+				oldestID, oldest = k, v.expiresAt
+			}
+		}
+		delete(c.pending, oldestID)
+	}
+	c.pending[id] = imageChallenge{code: string(code), expiresAt: now.Add(imageTTL)}
+	return id, "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Verify checks a token of the form "<id>:<answer>". The challenge is spent
+// whether or not the answer is right.
+func (c *Image) Verify(_ context.Context, token, _ string) (bool, error) {
+	id, answer, ok := strings.Cut(strings.TrimSpace(token), ":")
+	if !ok || id == "" {
+		return false, nil
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	challenge, found := c.pending[id]
+	delete(c.pending, id)
+	if !found || !now.Before(challenge.expiresAt) {
+		return false, nil
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(answer)), []byte(challenge.code)) == 1, nil
+}
+
+func (c *Image) prune(now time.Time) {
+	for k, v := range c.pending {
+		if !now.Before(v.expiresAt) {
+			delete(c.pending, k)
+		}
+	}
+}
+
+func renderDigits(code string) (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, imageWidth, imageHeight))
+	background := color.RGBA{R: 245, G: 245, B: 245, A: 255}
+	for y := 0; y < imageHeight; y++ {
+		for x := 0; x < imageWidth; x++ {
+			img.Set(x, y, background)
+		}
+	}
+
+	slot := imageWidth / len(code)
+	for i, ch := range code {
+		glyph := digitGlyphs[ch-'0']
+		dx, err := randInt(slot - 3*imageScale - 4)
+		if err != nil {
+			return nil, err
+		}
+		dy, err := randInt(imageHeight - 5*imageScale)
+		if err != nil {
+			return nil, err
+		}
+		shade, err := randInt(120)
+		if err != nil {
+			return nil, err
+		}
+		ink := color.RGBA{R: uint8(shade), G: uint8(shade / 2), B: uint8(120 - shade), A: 255}
+		originX, originY := i*slot+dx, dy
+		for row, line := range glyph {
+			for col, bit := range line {
+				if bit != '#' {
+					continue
+				}
+				for py := 0; py < imageScale; py++ {
+					for px := 0; px < imageScale; px++ {
+						// Shear each glyph a little so it is not a clean grid.
+						x := originX + col*imageScale + px + (row*imageScale+py)/6
+						img.Set(x, originY+row*imageScale+py, ink)
+					}
+				}
+			}
+		}
+	}
+
+	// Speckle noise.
+	for i := 0; i < imageWidth*imageHeight/12; i++ {
+		x, err := randInt(imageWidth)
+		if err != nil {
+			return nil, err
+		}
+		y, err := randInt(imageHeight)
+		if err != nil {
+			return nil, err
+		}
+		v, err := randInt(200)
+		if err != nil {
+			return nil, err
+		}
+		img.Set(x, y, color.RGBA{R: uint8(v), G: uint8(v), B: uint8(v), A: 255})
+	}
+	return img, nil
+}
+
+func randInt(n int) (int, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
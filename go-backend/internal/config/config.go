@@ -1,6 +1,12 @@
 package config
 
-import "os"
+import (
+	"context"
+	"os"
+	"strings"
+
+	"go-backend/internal/secrets"
+)
 
 type Config struct {
 	Addr        string
@@ -9,6 +15,18 @@ type Config struct {
 	DatabaseURL string
 	JWTSecret   string
 	LogDir      string
+	// MasterKey wraps the key that encrypts node secrets and share tokens
+	// in the database. Empty leaves them in plaintext.
+	MasterKey string
+	// MTLSAddr is the address of the mutual TLS listener for node agents.
+	// Empty disables it.
+	MTLSAddr string
+	// MTLSServerNames are the host names and IPs nodes reach MTLSAddr by,
+	// put in the listener's certificate.
+	MTLSServerNames []string
+	// Secrets is where JWTSecret, MasterKey and the SMTP credentials come
+	// from, set by ResolveSecrets.
+	Secrets secrets.Provider
 }
 
 func FromEnv() Config {
@@ -19,11 +37,36 @@ func FromEnv() Config {
 		DatabaseURL: getEnv("DATABASE_URL", ""),
 		JWTSecret:   getEnv("JWT_SECRET", ""),
 		LogDir:      getEnv("LOG_DIR", "/app/logs"),
+		MasterKey:   getEnv("DATA_MASTER_KEY", ""),
+		MTLSAddr:    getEnv("MTLS_ADDR", ""),
+	}
+	for _, name := range strings.Split(getEnv("MTLS_SERVER_NAMES", "localhost,127.0.0.1"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			cfg.MTLSServerNames = append(cfg.MTLSServerNames, name)
+		}
 	}
 
 	return cfg
 }
 
+// ResolveSecrets builds the provider SECRETS_PROVIDER selects and loads
+// JWTSecret and MasterKey through it. Values the provider does not have
+// keep what the environment set.
+func (c *Config) ResolveSecrets(ctx context.Context) error {
+	provider, err := secrets.FromEnv()
+	if err != nil {
+		return err
+	}
+	if c.JWTSecret, err = secrets.Lookup(ctx, provider, secrets.JWTSecret, c.JWTSecret); err != nil {
+		return err
+	}
+	if c.MasterKey, err = secrets.Lookup(ctx, provider, secrets.DataMasterKey, c.MasterKey); err != nil {
+		return err
+	}
+	c.Secrets = provider
+	return nil
+}
+
 func getEnv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
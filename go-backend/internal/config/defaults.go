@@ -0,0 +1,45 @@
+package config
+
+// DefaultConfigs lists the panel config keys that have a factory default,
+// with that default. The repository seeds them into vite_config when a
+// database is opened, without touching keys that are already set, so a
+// fresh panel reports the same values it would otherwise fall back to.
+//
+// Keys without a meaningful default (URLs, secrets, allowlists) are left
+// out: an empty row would read differently from an unset one.
+var DefaultConfigs = map[string]string{
+	"auth_cookie_enabled":            "false",
+	"backup_download_enabled":        "false",
+	"captcha_enabled":                "false",
+	"captcha_provider":               "turnstile",
+	"cors_allowed_headers":           "*",
+	"cors_allowed_methods":           "GET, POST, DELETE, PUT, OPTIONS",
+	"cors_allowed_origins":           "*",
+	"db_backup_timeout_sec":          "60",
+	"expiry_warning_days":            "7",
+	"federation_allow_port_conflict": "false",
+	"federation_signature_required":  "false",
+	"forward_batch_max":              "50",
+	"influx_enabled":                 "false",
+	"ip_ban_duration_minutes":        "30",
+	"ip_ban_threshold":               "10",
+	"ip_ban_window_minutes":          "15",
+	"jwt_audience":                   "flvx-panel",
+	"jwt_legacy_aud_compat":          "true",
+	"node_message_max_skew_sec":      "300",
+	"node_mtls_required":             "false",
+	"node_payload_strict":            "false",
+	"node_selection_strategy":        "least_loaded",
+	"node_upload_rate_limit_per_min": "120",
+	"node_upload_signature_required": "false",
+	"password_min_char_classes":      "1",
+	"password_min_length":            "6",
+	"password_reject_common":         "true",
+	"password_reset_ttl_minutes":     "30",
+	"response_field_case":            "camel",
+	"smtp_port":                      "587",
+	"webhook_allow_private":          "false",
+	"ws_keepalive_interval_sec":      "20",
+	"ws_keepalive_timeout_sec":       "5",
+	"ws_max_multiplexed_channels":    "4",
+}
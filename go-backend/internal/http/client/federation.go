@@ -10,7 +10,9 @@ import (
 )
 
 type FederationClient struct {
-	client *http.Client
+	client      *http.Client
+	consumerID  string
+	callbackURL string
 }
 
 type RemoteNodeInfo struct {
@@ -27,6 +29,20 @@ type RemoteNodeInfo struct {
 	PortRangeEnd   int    `json:"portRangeEnd"`
 }
 
+// RemoteShareStatus is the share metadata a provider reports before import.
+type RemoteShareStatus struct {
+	ShareID        int64  `json:"shareId"`
+	ShareName      string `json:"shareName"`
+	NodeName       string `json:"nodeName"`
+	ServerIP       string `json:"serverIp"`
+	MaxBandwidth   int64  `json:"maxBandwidth"`
+	CurrentFlow    int64  `json:"currentFlow"`
+	ExpiryTime     int64  `json:"expiryTime"`
+	PortRangeStart int    `json:"portRangeStart"`
+	PortRangeEnd   int    `json:"portRangeEnd"`
+	UsedPorts      []int  `json:"usedPorts"`
+}
+
 type RemoteTunnelResponse struct {
 	TunnelID int64 `json:"tunnelId"`
 }
@@ -82,6 +98,14 @@ type RuntimeNodeCommandRequest struct {
 	Data        interface{} `json:"data"`
 }
 
+// ShareDeactivatedEvent is posted by a provider to each consumer's callback
+// URL when a share is deleted or disabled.
+type ShareDeactivatedEvent struct {
+	Event      string   `json:"event"`
+	ShareID    int64    `json:"shareId"`
+	BindingIDs []string `json:"bindingIds"`
+}
+
 type RuntimeNodeCommandResponse struct {
 	Type    string                 `json:"type"`
 	Success bool                   `json:"success"`
@@ -105,17 +129,42 @@ func NewFederationClientWithTimeout(timeout time.Duration) *FederationClient {
 	}
 }
 
+// WithConsumerID sets the panel identity sent as X-Consumer-ID, which lets a
+// provider keep runtime rows of different consumers of one share apart.
+func (c *FederationClient) WithConsumerID(consumerID string) *FederationClient {
+	c.consumerID = strings.TrimSpace(consumerID)
+	return c
+}
+
+// WithCallbackURL sets the URL sent as X-Consumer-Callback, where the provider
+// posts share lifecycle events for this consumer.
+func (c *FederationClient) WithCallbackURL(callbackURL string) *FederationClient {
+	c.callbackURL = strings.TrimSpace(callbackURL)
+	return c
+}
+
+func (c *FederationClient) setHeaders(req *http.Request, token, localDomain string) {
+	req.Header.Set("Authorization", "Bearer "+token)
+	if localDomain != "" {
+		req.Header.Set("X-Panel-Domain", localDomain)
+	}
+	if c.consumerID != "" {
+		req.Header.Set("X-Consumer-ID", c.consumerID)
+	}
+	if c.callbackURL != "" {
+		req.Header.Set("X-Consumer-Callback", c.callbackURL)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signPeerRequest(req, token)
+}
+
 func (c *FederationClient) Connect(url, token, localDomain string) (*RemoteNodeInfo, error) {
 	url = strings.TrimSuffix(url, "/")
 	req, err := http.NewRequest("POST", url+"/api/v1/federation/connect", nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	if localDomain != "" {
-		req.Header.Set("X-Panel-Domain", localDomain)
-	}
-	req.Header.Set("Content-Type", "application/json")
+	c.setHeaders(req, token, localDomain)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -143,6 +192,42 @@ func (c *FederationClient) Connect(url, token, localDomain string) (*RemoteNodeI
 	return &res.Data, nil
 }
 
+// ShareStatus reads the share metadata from the provider without
+// registering this panel as a consumer.
+func (c *FederationClient) ShareStatus(url, token, localDomain string) (*RemoteShareStatus, error) {
+	url = strings.TrimSuffix(url, "/")
+	req, err := http.NewRequest("POST", url+"/api/v1/federation/share/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	c.setHeaders(req, token, localDomain)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("remote error %d: %s", resp.StatusCode, string(body))
+	}
+
+	var res struct {
+		Code int               `json:"code"`
+		Msg  string            `json:"msg"`
+		Data RemoteShareStatus `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	if res.Code != 0 {
+		return nil, fmt.Errorf("remote api error: %s", res.Msg)
+	}
+
+	return &res.Data, nil
+}
+
 func (c *FederationClient) CreateTunnel(url, token, localDomain, protocol string, remotePort int, target string) (*RemoteTunnelResponse, error) {
 	url = strings.TrimSuffix(url, "/")
 	payload := map[string]interface{}{
@@ -155,11 +240,7 @@ func (c *FederationClient) CreateTunnel(url, token, localDomain, protocol string
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	if localDomain != "" {
-		req.Header.Set("X-Panel-Domain", localDomain)
-	}
-	req.Header.Set("Content-Type", "application/json")
+	c.setHeaders(req, token, localDomain)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -194,11 +275,7 @@ func (c *FederationClient) ReservePort(url, token, localDomain string, reqData R
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	if localDomain != "" {
-		req.Header.Set("X-Panel-Domain", localDomain)
-	}
-	req.Header.Set("Content-Type", "application/json")
+	c.setHeaders(req, token, localDomain)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -233,11 +310,7 @@ func (c *FederationClient) ApplyRole(url, token, localDomain string, reqData Run
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	if localDomain != "" {
-		req.Header.Set("X-Panel-Domain", localDomain)
-	}
-	req.Header.Set("Content-Type", "application/json")
+	c.setHeaders(req, token, localDomain)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -272,11 +345,7 @@ func (c *FederationClient) ReleaseRole(url, token, localDomain string, reqData R
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	if localDomain != "" {
-		req.Header.Set("X-Panel-Domain", localDomain)
-	}
-	req.Header.Set("Content-Type", "application/json")
+	c.setHeaders(req, token, localDomain)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -310,11 +379,7 @@ func (c *FederationClient) Diagnose(url, token, localDomain string, reqData Runt
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	if localDomain != "" {
-		req.Header.Set("X-Panel-Domain", localDomain)
-	}
-	req.Header.Set("Content-Type", "application/json")
+	c.setHeaders(req, token, localDomain)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -353,11 +418,7 @@ func (c *FederationClient) Command(url, token, localDomain string, reqData Runti
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	if localDomain != "" {
-		req.Header.Set("X-Panel-Domain", localDomain)
-	}
-	req.Header.Set("Content-Type", "application/json")
+	c.setHeaders(req, token, localDomain)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -384,3 +445,37 @@ func (c *FederationClient) Command(url, token, localDomain string, reqData Runti
 
 	return &res.Data, nil
 }
+
+// NotifyShareDeactivated posts a share_deactivated event to a consumer's
+// callback URL, authenticated with the share token.
+func (c *FederationClient) NotifyShareDeactivated(callbackURL, token string, event ShareDeactivatedEvent) error {
+	bodyBytes, _ := json.Marshal(event)
+	req, err := http.NewRequest("POST", callbackURL, strings.NewReader(string(bodyBytes)))
+	if err != nil {
+		return err
+	}
+	c.setHeaders(req, token, "")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("remote error %d: %s", resp.StatusCode, string(body))
+	}
+
+	var res struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return err
+	}
+	if res.Code != 0 {
+		return fmt.Errorf("remote api error: %s", res.Msg)
+	}
+	return nil
+}
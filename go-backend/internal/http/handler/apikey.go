@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/middleware"
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+const maxAPIKeyNameLength = 50
+
+type apiKeyCreateRequest struct {
+	Name string `json:"name"`
+}
+
+type apiKeyRevokeRequest struct {
+	ID int64 `json:"id"`
+}
+
+// userAPIKeyCreate mints an API key for the caller. The key acts with the
+// caller's role and permissions and is returned only in this response.
+func (h *Handler) userAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	var req apiKeyCreateRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		response.WriteJSON(w, response.ErrDefault("名称不能为空"))
+		return
+	}
+	if len([]rune(name)) > maxAPIKeyNameLength {
+		response.WriteJSON(w, response.ErrDefault("名称过长"))
+		return
+	}
+
+	key, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	apiKey := &sqlite.APIKey{
+		UserID:      userID,
+		Name:        name,
+		KeyPrefix:   prefix,
+		KeyHash:     auth.HashAPIKey(key),
+		CreatedTime: time.Now().UnixMilli(),
+	}
+	if apiKey.ID, err = h.repo.CreateAPIKey(apiKey); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	claims, _ := r.Context().Value(middleware.ClaimsContextKey).(auth.Claims)
+	h.notifySecurityEvent(r, userID, claims.User, eventSecurityAPIKeyCreated, name+" ("+prefix+")")
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"id":          apiKey.ID,
+		"name":        apiKey.Name,
+		"keyPrefix":   apiKey.KeyPrefix,
+		"key":         key,
+		"createdTime": apiKey.CreatedTime,
+	}))
+}
+
+func (h *Handler) userAPIKeyList(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	keys, err := h.repo.ListAPIKeys(userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(keys))
+}
+
+func (h *Handler) userAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	var req apiKeyRevokeRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	revoked, err := h.repo.RevokeAPIKey(req.ID, userID, time.Now().UnixMilli())
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if !revoked {
+		response.WriteJSON(w, response.ErrDefault("API Key不存在"))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
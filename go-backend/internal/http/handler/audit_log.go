@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/middleware"
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+type auditLogListRequest struct {
+	Action   string `json:"action"`
+	Page     int    `json:"page"`
+	PageSize int    `json:"pageSize"`
+}
+
+func (h *Handler) auditLogList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req auditLogListRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+
+	items, total, err := h.repo.ListAuditLogs(req.Action, req.Page, req.PageSize)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"page":  req.Page,
+		"total": total,
+		"list":  items,
+	}))
+}
+
+// writeAuditLog records an admin action, attributing it to the caller in r.
+// Failures are ignored so auditing never blocks the action itself. Actions
+// taken with an impersonation token name the admin behind it.
+func (h *Handler) writeAuditLog(r *http.Request, action, targetType string, targetID int64, detail string) {
+	entry := &sqlite.AuditLog{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Detail:     detail,
+	}
+	if claims, ok := r.Context().Value(middleware.ClaimsContextKey).(auth.Claims); ok {
+		entry.UserID, _ = parseUserID(claims.Sub)
+		entry.Username = claims.User
+		if claims.Act != 0 {
+			entry.Detail = strings.TrimSpace(fmt.Sprintf("%s (impersonated by user %d)", detail, claims.Act))
+		}
+	}
+	_ = h.repo.CreateAuditLog(entry)
+}
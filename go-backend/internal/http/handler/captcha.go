@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/internal/captcha"
+	"go-backend/internal/http/response"
+	"go-backend/internal/network"
+)
+
+const captchaProviderConfigKey = "captcha_provider"
+
+// captchaProvider returns the provider captcha_provider selects, or nil when
+// that provider needs a secret key that is not configured.
+func (h *Handler) captchaProvider() captcha.Provider {
+	switch strings.ToLower(strings.TrimSpace(h.configValue(captchaProviderConfigKey))) {
+	case captcha.ProviderImage:
+		return h.imageCaptcha
+	case captcha.ProviderHCaptcha:
+		if secret := strings.TrimSpace(h.configValue("hcaptcha_secret_key")); secret != "" {
+			return captcha.NewHCaptcha(secret)
+		}
+	default:
+		if secret := strings.TrimSpace(h.configValue("cloudflare_secret_key")); secret != "" {
+			return captcha.NewTurnstile(secret)
+		}
+	}
+	return nil
+}
+
+// verifyCaptcha checks token with the configured provider.
+func (h *Handler) verifyCaptcha(r *http.Request, token string) bool {
+	provider := h.captchaProvider()
+	if provider == nil {
+		return false
+	}
+	remoteIP := ""
+	if ip := network.ClientIP(r); ip != nil {
+		remoteIP = ip.String()
+	}
+	ok, err := provider.Verify(r.Context(), token, remoteIP)
+	return err == nil && ok
+}
+
+// captchaGenerate issues a built-in image challenge. The login form answers
+// it with captchaId "<id>:<digits>".
+func (h *Handler) captchaGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	if strings.ToLower(strings.TrimSpace(h.configValue(captchaProviderConfigKey))) != captcha.ProviderImage {
+		response.WriteJSON(w, response.ErrDefault("未启用图形验证码"))
+		return
+	}
+	id, image, err := h.imageCaptcha.Generate(time.Now())
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{"id": id, "image": image}))
+}
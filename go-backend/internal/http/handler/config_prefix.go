@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/middleware"
+	"go-backend/internal/http/response"
+)
+
+// protectedConfigPrefixesConfigKey holds a comma-separated list of config
+// name prefixes that only full admins may write. The key itself is always
+// protected so a delegated user cannot lift the protection.
+const protectedConfigPrefixesConfigKey = "protected_config_prefixes"
+
+type configPrefixRequest struct {
+	Prefix string `json:"prefix"`
+}
+
+func (h *Handler) getConfigsByPrefix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req configPrefixRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	prefix := strings.TrimSpace(req.Prefix)
+	if prefix == "" {
+		response.WriteJSON(w, response.ErrDefault("配置前缀不能为空"))
+		return
+	}
+
+	cfgMap, err := h.repo.ListConfigsByPrefix(prefix)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	for name := range cfgMap {
+		if secretConfigNames[name] {
+			delete(cfgMap, name)
+		}
+	}
+	response.WriteJSON(w, response.OK(cfgMap))
+}
+
+// protectedConfigPrefixes returns the configured protected prefixes.
+func (h *Handler) protectedConfigPrefixes() []string {
+	cfg, err := h.repo.GetConfigByName(protectedConfigPrefixesConfigKey)
+	if err != nil || cfg == nil {
+		return nil
+	}
+	prefixes := make([]string, 0)
+	for _, p := range strings.Split(cfg.Value, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// canWriteConfigKey reports whether the caller may write key. Config routes
+// already require auth.PermManageConfig; protected keys additionally need
+// the full admin role.
+func (h *Handler) canWriteConfigKey(r *http.Request, key string, protected []string) bool {
+	claims, ok := r.Context().Value(middleware.ClaimsContextKey).(auth.Claims)
+	if ok && claims.RoleID == 0 {
+		return true
+	}
+	if key == protectedConfigPrefixesConfigKey {
+		return false
+	}
+	for _, p := range protected {
+		if strings.HasPrefix(key, p) {
+			return false
+		}
+	}
+	return true
+}
@@ -18,14 +18,21 @@ import (
 var errForwardNotFound = errors.New("forward not found")
 
 type forwardRecord struct {
-	ID         int64
-	UserID     int64
-	UserName   string
-	Name       string
-	TunnelID   int64
-	RemoteAddr string
-	Strategy   string
-	Status     int
+	ID             int64
+	UserID         int64
+	UserName       string
+	Name           string
+	TunnelID       int64
+	RemoteAddr     string
+	Strategy       string
+	Protocol       string
+	DNSServer      string
+	IdleTimeoutSec int
+	Status         int
+	CreatedTime    int64
+	// ServiceRevision counts the AddService rounds that changed the
+	// forward's services; replays resend the current value.
+	ServiceRevision int64
 }
 
 type tunnelRecord struct {
@@ -34,6 +41,7 @@ type tunnelRecord struct {
 	Status       int
 	Flow         int64
 	TrafficRatio float64
+	DSCPMark     int
 }
 
 type forwardPortRecord struct {
@@ -112,17 +120,16 @@ func (h *Handler) ensureTunnelPermission(userID int64, roleID int, tunnelID int6
 	return nil
 }
 
-func (h *Handler) getForwardRecord(forwardID int64) (*forwardRecord, error) {
-	row := h.repo.DB().QueryRow(`
-		SELECT id, user_id, user_name, name, tunnel_id, remote_addr, COALESCE(strategy, 'fifo'), status
-		FROM forward WHERE id = ? LIMIT 1
-	`, forwardID)
+// forwardRecordColumns is the column list scanForwardRecord expects.
+const forwardRecordColumns = `id, user_id, user_name, name, tunnel_id, remote_addr, COALESCE(strategy, 'fifo'), COALESCE(protocol, 'tcp'), COALESCE(dns_server, ''), COALESCE(idle_timeout_sec, 0), status, created_time, COALESCE(service_revision, 0)`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanForwardRecord(row rowScanner) (*forwardRecord, error) {
 	var fr forwardRecord
-	err := row.Scan(&fr.ID, &fr.UserID, &fr.UserName, &fr.Name, &fr.TunnelID, &fr.RemoteAddr, &fr.Strategy, &fr.Status)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errForwardNotFound
-		}
+	if err := row.Scan(&fr.ID, &fr.UserID, &fr.UserName, &fr.Name, &fr.TunnelID, &fr.RemoteAddr, &fr.Strategy, &fr.Protocol, &fr.DNSServer, &fr.IdleTimeoutSec, &fr.Status, &fr.CreatedTime, &fr.ServiceRevision); err != nil {
 		return nil, err
 	}
 	if strings.TrimSpace(fr.Strategy) == "" {
@@ -131,10 +138,22 @@ func (h *Handler) getForwardRecord(forwardID int64) (*forwardRecord, error) {
 	return &fr, nil
 }
 
+func (h *Handler) getForwardRecord(forwardID int64) (*forwardRecord, error) {
+	row := h.repo.DB().QueryRow(`SELECT `+forwardRecordColumns+` FROM forward WHERE id = ? LIMIT 1`, forwardID)
+	fr, err := scanForwardRecord(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errForwardNotFound
+		}
+		return nil, err
+	}
+	return fr, nil
+}
+
 func (h *Handler) getTunnelRecord(tunnelID int64) (*tunnelRecord, error) {
-	row := h.repo.DB().QueryRow(`SELECT id, type, status, flow, traffic_ratio FROM tunnel WHERE id = ? LIMIT 1`, tunnelID)
+	row := h.repo.DB().QueryRow(`SELECT id, type, status, flow, traffic_ratio, COALESCE(dscp_mark, 0) FROM tunnel WHERE id = ? LIMIT 1`, tunnelID)
 	var tr tunnelRecord
-	err := row.Scan(&tr.ID, &tr.Type, &tr.Status, &tr.Flow, &tr.TrafficRatio)
+	err := row.Scan(&tr.ID, &tr.Type, &tr.Status, &tr.Flow, &tr.TrafficRatio, &tr.DSCPMark)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.New("隧道不存在")
@@ -152,7 +171,7 @@ func (h *Handler) getTunnelRecord(tunnelID int64) (*tunnelRecord, error) {
 
 func (h *Handler) listForwardsByTunnel(tunnelID int64) ([]forwardRecord, error) {
 	rows, err := h.repo.DB().Query(`
-		SELECT id, user_id, user_name, name, tunnel_id, remote_addr, COALESCE(strategy, 'fifo'), status
+		SELECT `+forwardRecordColumns+`
 		FROM forward
 		WHERE tunnel_id = ?
 		ORDER BY id ASC
@@ -162,21 +181,7 @@ func (h *Handler) listForwardsByTunnel(tunnelID int64) ([]forwardRecord, error)
 	}
 	defer rows.Close()
 
-	result := make([]forwardRecord, 0)
-	for rows.Next() {
-		var fr forwardRecord
-		if err := rows.Scan(&fr.ID, &fr.UserID, &fr.UserName, &fr.Name, &fr.TunnelID, &fr.RemoteAddr, &fr.Strategy, &fr.Status); err != nil {
-			return nil, err
-		}
-		if strings.TrimSpace(fr.Strategy) == "" {
-			fr.Strategy = "fifo"
-		}
-		result = append(result, fr)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return result, nil
+	return scanForwardRecords(rows)
 }
 
 func (h *Handler) listForwardPorts(forwardID int64) ([]forwardPortRecord, error) {
@@ -251,7 +256,9 @@ func (h *Handler) getNodeRecord(nodeID int64) (*nodeRecord, error) {
 	n.UDPListenAddr = strings.TrimSpace(udpListen.String)
 	n.InterfaceName = strings.TrimSpace(iface.String)
 	n.RemoteURL = strings.TrimSpace(remoteURL.String)
-	n.RemoteToken = strings.TrimSpace(remoteToken.String)
+	if n.RemoteToken, err = h.repo.OpenSecret(strings.TrimSpace(remoteToken.String)); err != nil {
+		return nil, err
+	}
 	n.RemoteConfig = strings.TrimSpace(remoteConfig.String)
 	if n.TCPListenAddr == "" {
 		n.TCPListenAddr = "[::]"
@@ -269,7 +276,7 @@ func (h *Handler) resolveUserTunnelAndLimiter(userID, tunnelID int64) (int64, *i
 	row := h.repo.DB().QueryRow(`
 		SELECT ut.id, sl.id, sl.speed
 		FROM user_tunnel ut
-		LEFT JOIN speed_limit sl ON sl.id = ut.speed_id
+		LEFT JOIN speed_limit sl ON sl.id = ut.speed_id AND sl.status = 1
 		WHERE ut.user_id = ? AND ut.tunnel_id = ?
 		ORDER BY ut.id ASC
 		LIMIT 1
@@ -349,28 +356,66 @@ func (h *Handler) syncForwardServices(forward *forwardRecord, method string, all
 		return errors.New("invalid forward sync context")
 	}
 
-	tunnel, err := h.getTunnelRecord(forward.TunnelID)
+	configs, err := h.buildForwardNodeServices(forward)
 	if err != nil {
 		return err
 	}
+	for _, cfg := range configs {
+		_, err = h.sendNodeCommand(cfg.Node.ID, method, cfg.Services, true, false)
+		if err != nil && allowFallbackAdd && method == "UpdateService" {
+			_, err = h.sendNodeCommand(cfg.Node.ID, "AddService", cfg.Services, true, false)
+		}
+		if err != nil {
+			return fmt.Errorf("节点 %s 下发失败: %w", cfg.Node.Name, err)
+		}
+	}
+	return nil
+}
+
+// forwardNodeServices is the service config set a forward needs on one
+// entry node.
+type forwardNodeServices struct {
+	Node     *nodeRecord
+	Services []map[string]interface{}
+}
+
+// buildForwardNodeServices resolves the service configs of a forward for
+// each of its entry nodes, making sure the user's limiter exists there.
+func (h *Handler) buildForwardNodeServices(forward *forwardRecord) ([]forwardNodeServices, error) {
+	tunnel, err := h.getTunnelRecord(forward.TunnelID)
+	if err != nil {
+		return nil, err
+	}
 	ports, err := h.listForwardPorts(forward.ID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(ports) == 0 {
-		return errors.New("转发入口端口不存在")
+		return nil, errors.New("转发入口端口不存在")
 	}
 
 	userTunnelID, limiterID, speed, err := h.resolveUserTunnelAndLimiter(forward.UserID, forward.TunnelID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	serviceBase := buildForwardServiceBase(forward.ID, forward.UserID, userTunnelID)
 	tunnelTLSProtocol, err := h.isTunnelSelectedTLSProtocol(forward.TunnelID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	idle, err := h.repo.GetForwardWithIdleTimeout(forward.ID)
+	if err != nil {
+		return nil, err
+	}
+	if idle != nil {
+		// Send the effective timeout without touching the caller's record,
+		// which may be written back verbatim on rollback.
+		resolved := *forward
+		resolved.IdleTimeoutSec = idle.EffectiveIdleTimeoutSec
+		forward = &resolved
 	}
 
+	out := make([]forwardNodeServices, 0, len(ports))
 	for _, fp := range ports {
 		if limiterID != nil && speed != nil {
 			h.ensureLimiterOnNode(fp.NodeID, *limiterID, *speed)
@@ -378,18 +423,14 @@ func (h *Handler) syncForwardServices(forward *forwardRecord, method string, all
 
 		node, err := h.getNodeRecord(fp.NodeID)
 		if err != nil {
-			return err
-		}
-		services := buildForwardServiceConfigs(serviceBase, forward, tunnel, node, fp.Port, limiterID, tunnelTLSProtocol)
-		_, err = h.sendNodeCommand(node.ID, method, services, true, false)
-		if err != nil && allowFallbackAdd && method == "UpdateService" {
-			_, err = h.sendNodeCommand(node.ID, "AddService", services, true, false)
-		}
-		if err != nil {
-			return fmt.Errorf("节点 %s 下发失败: %w", node.Name, err)
+			return nil, err
 		}
+		out = append(out, forwardNodeServices{
+			Node:     node,
+			Services: buildForwardServiceConfigs(serviceBase, forward, tunnel, node, fp.Port, limiterID, tunnelTLSProtocol),
+		})
 	}
-	return nil
+	return out, nil
 }
 
 func (h *Handler) controlForwardServices(forward *forwardRecord, commandType string, tolerateNotFound bool) error {
@@ -430,8 +471,8 @@ func (h *Handler) controlForwardServices(forward *forwardRecord, commandType str
 		nodeHandled := false
 
 		for _, base := range bases {
-			variants := []string{base + "_tcp", base + "_udp"}
-			if shouldTryLegacySingleService(commandType) || strings.EqualFold(strings.TrimSpace(commandType), "DeleteService") {
+			variants := buildForwardControlServiceNames(base, commandType, forward.Protocol)
+			if shouldTryLegacySingleService(commandType, forward.Protocol) {
 				variants = append(variants, base)
 			}
 
@@ -519,7 +560,7 @@ func (h *Handler) sendRemoteNodeCommand(node *nodeRecord, commandType string, da
 		return ws.CommandResult{}, errors.New("远程节点缺少共享配置")
 	}
 
-	fc := client.NewFederationClient()
+	fc := client.NewFederationClient().WithConsumerID(h.federationConsumerID())
 	res, err := fc.Command(remoteURL, remoteToken, h.federationLocalDomain(), client.RuntimeNodeCommandRequest{
 		CommandType: commandType,
 		Data:        data,
@@ -755,7 +796,7 @@ func (h *Handler) diagnoseTunnelRuntime(tunnelID int64) (map[string]interface{},
 
 	payload := map[string]interface{}{
 		"tunnelName": tunnelName,
-		"tunnelType": map[bool]string{true: "端口转发", false: "隧道转发"}[tunnel.Type == 1],
+		"tunnelType": tunnelTypeLabel(tunnel.Type),
 		"timestamp":  time.Now().UnixMilli(),
 		"results":    results,
 	}
@@ -770,7 +811,7 @@ func splitChainNodeGroups(rows []chainNodeRecord) ([]chainNodeRecord, [][]chainN
 
 	for _, row := range rows {
 		switch row.ChainType {
-		case 1:
+		case 0, 1:
 			inNodes = append(inNodes, row)
 		case 2:
 			if _, ok := chainByInx[row.Inx]; !ok {
@@ -1022,7 +1063,7 @@ func (h *Handler) tcpPingViaRemoteNode(node *nodeRecord, ip string, port int) (m
 		return nil, errors.New("远程节点缺少共享配置")
 	}
 
-	fc := client.NewFederationClient()
+	fc := client.NewFederationClient().WithConsumerID(h.federationConsumerID())
 	return fc.Diagnose(remoteURL, remoteToken, h.federationLocalDomain(), client.RuntimeDiagnoseRequest{
 		IP:      strings.TrimSpace(ip),
 		Port:    port,
@@ -1098,17 +1139,56 @@ func buildForwardServiceBaseCandidates(forwardID, userID, preferredUserTunnelID
 	return bases
 }
 
-func buildForwardControlServiceNames(base, commandType string) []string {
-	names := []string{base + "_tcp", base + "_udp"}
+func buildForwardControlServiceNames(base, commandType, protocol string) []string {
+	protocols := forwardListenProtocols(protocol)
+	names := make([]string, 0, len(protocols)+1)
 	if strings.EqualFold(strings.TrimSpace(commandType), "DeleteService") {
-		return append([]string{base}, names...)
+		names = append(names, base)
+	}
+	for _, p := range protocols {
+		names = append(names, base+"_"+p)
 	}
 	return names
 }
 
-func shouldTryLegacySingleService(commandType string) bool {
+// shouldTryLegacySingleService reports whether pause/resume should also try the
+// unsuffixed service name used before forwards were split per protocol. Those
+// legacy services were TCP only.
+func shouldTryLegacySingleService(commandType, protocol string) bool {
 	cmd := strings.ToLower(strings.TrimSpace(commandType))
-	return cmd == "pauseservice" || cmd == "resumeservice"
+	if cmd != "pauseservice" && cmd != "resumeservice" {
+		return false
+	}
+	return normalizeForwardProtocol(protocol) == "tcp"
+}
+
+// maxForwardIdleTimeoutSec bounds the per-forward idle timeout to one day.
+const maxForwardIdleTimeoutSec = 86400
+
+// normalizeForwardProtocol returns "tcp", "udp" or "both", or "" when the
+// value is not a supported forward protocol.
+func normalizeForwardProtocol(protocol string) string {
+	switch strings.ToLower(strings.TrimSpace(protocol)) {
+	case "", "tcp":
+		return "tcp"
+	case "udp":
+		return "udp"
+	case "both", "tcp+udp", "all":
+		return "both"
+	default:
+		return ""
+	}
+}
+
+func forwardListenProtocols(protocol string) []string {
+	switch normalizeForwardProtocol(protocol) {
+	case "udp":
+		return []string{"udp"}
+	case "both":
+		return []string{"tcp", "udp"}
+	default:
+		return []string{"tcp"}
+	}
 }
 
 func isNotFoundError(err error) bool {
@@ -1120,8 +1200,8 @@ func isNotFoundError(err error) bool {
 }
 
 func buildForwardServiceConfigs(baseName string, forward *forwardRecord, tunnel *tunnelRecord, node *nodeRecord, port int, limiterID *int64, tunnelTLSProtocol bool) []map[string]interface{} {
-	protocols := []string{"tcp", "udp"}
-	services := make([]map[string]interface{}, 0, 2)
+	protocols := forwardListenProtocols(forward.Protocol)
+	services := make([]map[string]interface{}, 0, len(protocols))
 	targets := splitRemoteTargets(forward.RemoteAddr)
 	strategy := strings.TrimSpace(forward.Strategy)
 	if strategy == "" {
@@ -1134,8 +1214,9 @@ func buildForwardServiceConfigs(baseName string, forward *forwardRecord, tunnel
 			listenerAddr = node.UDPListenAddr
 		}
 		service := map[string]interface{}{
-			"name": fmt.Sprintf("%s_%s", baseName, protocol),
-			"addr": fmt.Sprintf("%s:%d", listenerAddr, port),
+			"name":     fmt.Sprintf("%s_%s", baseName, protocol),
+			"addr":     fmt.Sprintf("%s:%d", listenerAddr, port),
+			"protocol": protocol,
 			"handler": map[string]interface{}{
 				"type": protocol,
 			},
@@ -1161,12 +1242,23 @@ func buildForwardServiceConfigs(baseName string, forward *forwardRecord, tunnel
 		if tunnel != nil && tunnel.Type == 2 {
 			service["handler"].(map[string]interface{})["chain"] = fmt.Sprintf("chains_%d", forward.TunnelID)
 		}
-		if tunnel != nil && tunnel.Type == 1 && strings.TrimSpace(node.InterfaceName) != "" {
+		if tunnel != nil && (tunnel.Type == 1 || tunnel.Type == tunnelTypeDirect) && strings.TrimSpace(node.InterfaceName) != "" {
 			service["metadata"] = map[string]interface{}{"interface": node.InterfaceName}
 		}
 		if limiterID != nil && *limiterID > 0 {
 			service["limiter"] = strconv.FormatInt(*limiterID, 10)
 		}
+		if dns := strings.TrimSpace(forward.DNSServer); dns != "" {
+			service["dnsServer"] = dns
+		}
+		if forward.IdleTimeoutSec > 0 {
+			service["idleTimeoutSec"] = forward.IdleTimeoutSec
+		}
+		if tunnel != nil && tunnel.DSCPMark > 0 {
+			service["dscpMark"] = tunnel.DSCPMark
+		}
+		service["createdAt"] = forward.CreatedTime
+		service["revision"] = forward.ServiceRevision
 		services = append(services, service)
 	}
 
@@ -1229,48 +1321,17 @@ func asBool(v interface{}, def bool) bool {
 	}
 }
 
-func (h *Handler) sendLimiterConfig(limiterID int64, speedMbps int, tunnelID int64) error {
-	rate := float64(speedMbps) / 8.0
-	limitStr := fmt.Sprintf("$ %.1fMB %.1fMB", rate, rate)
-
-	payload := map[string]interface{}{
-		"name":   strconv.FormatInt(limiterID, 10),
-		"limits": []string{limitStr},
-	}
-
-	nodes, err := h.tunnelEntryNodeIDs(tunnelID)
-	if err != nil {
-		return err
-	}
-
-	for _, nodeID := range nodes {
-		_, _ = h.sendNodeCommand(nodeID, "AddLimiters", payload, false, false)
-	}
-	return nil
-}
-
-func (h *Handler) sendDeleteLimiterConfig(limiterID int64, tunnelID int64) error {
-	payload := map[string]interface{}{
-		"limiter": strconv.FormatInt(limiterID, 10),
-	}
-
-	nodes, err := h.tunnelEntryNodeIDs(tunnelID)
-	if err != nil {
-		return err
-	}
-
-	for _, nodeID := range nodes {
-		_, _ = h.sendNodeCommand(nodeID, "DeleteLimiters", payload, false, true)
-	}
-	return nil
+func (h *Handler) ensureLimiterOnNode(nodeID int64, limiterID int64, speed int) {
+	_, _ = h.sendNodeCommand(nodeID, "AddLimiters", limiterConfig(limiterID, speed), false, false)
 }
 
-func (h *Handler) ensureLimiterOnNode(nodeID int64, limiterID int64, speed int) {
-	rate := float64(speed) / 8.0
+// limiterConfig is the agent limiter for a speed limit rule of speedMbps,
+// named after the rule id.
+func limiterConfig(limiterID int64, speedMbps int) map[string]interface{} {
+	rate := float64(speedMbps) / 8.0
 	limitStr := fmt.Sprintf("$ %.1fMB %.1fMB", rate, rate)
-	payload := map[string]interface{}{
+	return map[string]interface{}{
 		"name":   strconv.FormatInt(limiterID, 10),
 		"limits": []string{limitStr},
 	}
-	_, _ = h.sendNodeCommand(nodeID, "AddLimiters", payload, false, false)
 }
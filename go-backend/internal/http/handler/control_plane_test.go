@@ -7,22 +7,56 @@ import (
 
 func TestBuildForwardControlServiceNamesPauseResume(t *testing.T) {
 	base := "12_34_56"
-	want := []string{base + "_tcp", base + "_udp"}
+	cases := map[string][]string{
+		"tcp":  {base + "_tcp"},
+		"udp":  {base + "_udp"},
+		"both": {base + "_tcp", base + "_udp"},
+	}
 
-	for _, command := range []string{"PauseService", "ResumeService"} {
-		got := buildForwardControlServiceNames(base, command)
-		if !reflect.DeepEqual(got, want) {
-			t.Fatalf("command %s expected %v, got %v", command, want, got)
+	for protocol, want := range cases {
+		for _, command := range []string{"PauseService", "ResumeService"} {
+			got := buildForwardControlServiceNames(base, command, protocol)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("command %s protocol %s expected %v, got %v", command, protocol, want, got)
+			}
 		}
 	}
 }
 
 func TestBuildForwardControlServiceNamesDelete(t *testing.T) {
 	base := "12_34_56"
-	want := []string{base, base + "_tcp", base + "_udp"}
-	got := buildForwardControlServiceNames(base, " DeleteService ")
-	if !reflect.DeepEqual(got, want) {
-		t.Fatalf("expected %v, got %v", want, got)
+	cases := map[string][]string{
+		"tcp":  {base, base + "_tcp"},
+		"udp":  {base, base + "_udp"},
+		"both": {base, base + "_tcp", base + "_udp"},
+	}
+
+	for protocol, want := range cases {
+		got := buildForwardControlServiceNames(base, " DeleteService ", protocol)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("protocol %s expected %v, got %v", protocol, want, got)
+		}
+	}
+}
+
+func TestBuildForwardServiceConfigsFollowsProtocol(t *testing.T) {
+	node := &nodeRecord{ID: 1, TCPListenAddr: "[::]", UDPListenAddr: "[::]"}
+	cases := map[string][]string{
+		"tcp":  {"tcp"},
+		"udp":  {"udp"},
+		"both": {"tcp", "udp"},
+	}
+
+	for protocol, want := range cases {
+		forward := &forwardRecord{ID: 12, UserID: 34, TunnelID: 1, RemoteAddr: "1.1.1.1:443", Protocol: protocol}
+		services := buildForwardServiceConfigs("12_34_56", forward, &tunnelRecord{ID: 1, Type: 1}, node, 20000, nil, false)
+		got := make([]string, 0, len(services))
+		for _, svc := range services {
+			got = append(got, svc["protocol"].(string))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("protocol %s expected services %v, got %v", protocol, want, got)
+		}
 	}
 }
 
@@ -43,13 +77,19 @@ func TestBuildForwardServiceBaseCandidatesWithZeroPreferred(t *testing.T) {
 }
 
 func TestShouldTryLegacySingleService(t *testing.T) {
-	if !shouldTryLegacySingleService("PauseService") {
-		t.Fatalf("PauseService should require legacy fallback")
+	if !shouldTryLegacySingleService("PauseService", "tcp") {
+		t.Fatalf("PauseService should require legacy fallback for tcp forwards")
+	}
+	if !shouldTryLegacySingleService("resumeService", "tcp") {
+		t.Fatalf("ResumeService should require legacy fallback for tcp forwards")
+	}
+	if shouldTryLegacySingleService("PauseService", "udp") {
+		t.Fatalf("udp forwards should not require legacy fallback")
 	}
-	if !shouldTryLegacySingleService("resumeService") {
-		t.Fatalf("ResumeService should require legacy fallback")
+	if shouldTryLegacySingleService("PauseService", "both") {
+		t.Fatalf("tcp+udp forwards should not require legacy fallback")
 	}
-	if shouldTryLegacySingleService("DeleteService") {
+	if shouldTryLegacySingleService("DeleteService", "tcp") {
 		t.Fatalf("DeleteService should not require legacy fallback")
 	}
 }
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+const (
+	backupDownloadEnabledConfigKey = "backup_download_enabled"
+	dbBackupTimeoutConfigKey       = "db_backup_timeout_sec"
+	defaultDBBackupTimeout         = 60 * time.Second
+)
+
+// adminDBBackup snapshots the SQLite database with the online backup API
+// and sends it as a download. It is off unless backup_download_enabled is
+// set, since the file holds every secret the panel knows.
+func (h *Handler) adminDBBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	cfg, err := h.repo.GetConfigByName(backupDownloadEnabledConfigKey)
+	if err != nil || cfg == nil || !strings.EqualFold(strings.TrimSpace(cfg.Value), "true") {
+		response.WriteJSON(w, response.ErrDefault("数据库备份下载未开启"))
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "flvx-backup-")
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backup.db")
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.dbBackupTimeout())
+	defer cancel()
+	if err := h.repo.OnlineBackupContext(ctx, path); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			response.WriteJSON(w, response.Err(-2, "数据库备份超时"))
+			return
+		}
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	now := time.Now()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=flvx_%s.db", now.Format("20060102_150405")))
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("X-Backup-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	_, _ = io.Copy(w, f)
+}
+
+func (h *Handler) dbBackupTimeout() time.Duration {
+	cfg, err := h.repo.GetConfigByName(dbBackupTimeoutConfigKey)
+	if err != nil || cfg == nil {
+		return defaultDBBackupTimeout
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(cfg.Value))
+	if err != nil || n <= 0 {
+		return defaultDBBackupTimeout
+	}
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+const (
+	eventUserTunnelExpiryWarning = "user_tunnel_expiry_warning"
+	eventFlowAnomaly             = "flow_anomaly"
+
+	eventSecurityLogin           = "security_login"
+	eventSecurityLoginFailed     = "security_login_failed"
+	eventSecurityPasswordChanged = "security_password_changed"
+	eventSecurityAPIKeyCreated   = "security_apikey_created"
+
+	eventSubscriberBuffer = 32
+	eventKeepAlive        = 30 * time.Second
+)
+
+// panelEvent is a server-sent notification. UserID scopes delivery: users
+// only receive their own events, admins receive everything.
+type panelEvent struct {
+	Type   string
+	UserID int64
+	Data   interface{}
+}
+
+type eventSubscriber struct {
+	userID int64
+	admin  bool
+	ch     chan panelEvent
+}
+
+// eventBus fans panel events out to SSE subscribers. Slow subscribers drop
+// events instead of blocking publishers.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[*eventSubscriber]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[*eventSubscriber]struct{})}
+}
+
+func (b *eventBus) subscribe(userID int64, admin bool) *eventSubscriber {
+	sub := &eventSubscriber{userID: userID, admin: admin, ch: make(chan panelEvent, eventSubscriberBuffer)}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *eventBus) unsubscribe(sub *eventSubscriber) {
+	b.mu.Lock()
+	delete(b.subs, sub)
+	b.mu.Unlock()
+}
+
+func (b *eventBus) publish(ev panelEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if !sub.admin && sub.userID != ev.UserID {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+		}
+	}
+}
+
+func (h *Handler) userEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	userID, roleID, err := userRoleFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		response.WriteJSON(w, response.ErrDefault("不支持事件流"))
+		return
+	}
+
+	sub := h.events.subscribe(userID, roleID == 0)
+	defer h.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev := <-sub.ch:
+			payload, err := json.Marshal(ev.Data)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, payload); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+const (
+	expiryWarningDaysConfigKey = "expiry_warning_days"
+	defaultExpiryWarningDays   = 7
+	millisPerDay               = int64(24 * time.Hour / time.Millisecond)
+)
+
+type userNotificationPrefRequest struct {
+	ExpiryWarningEnabled *bool   `json:"expiryWarningEnabled"`
+	SecurityEmailEnabled *bool   `json:"securityEmailEnabled"`
+	SecurityWebhookURL   *string `json:"securityWebhookUrl"`
+}
+
+// warnedTunnels remembers which user tunnels were already warned today so a
+// job re-run does not repeat the warning. The set is reset when the day
+// changes.
+type warnedTunnels struct {
+	mu  sync.Mutex
+	day string
+	ids map[int64]struct{}
+}
+
+func newWarnedTunnels() *warnedTunnels {
+	return &warnedTunnels{ids: make(map[int64]struct{})}
+}
+
+// markOnce records userTunnelID for the day of now and reports whether it
+// had not been recorded yet.
+func (s *warnedTunnels) markOnce(userTunnelID int64, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if day := now.Format("2006-01-02"); day != s.day {
+		s.day = day
+		s.ids = make(map[int64]struct{})
+	}
+	if _, ok := s.ids[userTunnelID]; ok {
+		return false
+	}
+	s.ids[userTunnelID] = struct{}{}
+	return true
+}
+
+func (h *Handler) expiryWarningDays() int {
+	cfg, err := h.repo.GetConfigByName(expiryWarningDaysConfigKey)
+	if err != nil || cfg == nil {
+		return defaultExpiryWarningDays
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(cfg.Value))
+	if err != nil || n < 0 {
+		return defaultExpiryWarningDays
+	}
+	return n
+}
+
+// warnExpiringUserTunnels publishes an expiry warning for every active user
+// tunnel expiring within expiry_warning_days, unless the owner opted out.
+func (h *Handler) warnExpiringUserTunnels(now time.Time) {
+	days := h.expiryWarningDays()
+	if days <= 0 {
+		return
+	}
+	nowMs := now.UnixMilli()
+	items, err := h.repo.ListExpiringUserTunnels(nowMs, nowMs+int64(days)*millisPerDay)
+	if err != nil {
+		return
+	}
+
+	prefs := make(map[int64]*sqlite.UserNotificationPref)
+	for _, item := range items {
+		pref, ok := prefs[item.UserID]
+		if !ok {
+			pref, err = h.repo.GetUserNotificationPref(item.UserID)
+			if err != nil {
+				continue
+			}
+			prefs[item.UserID] = pref
+		}
+		if !pref.ExpiryWarningEnabled {
+			continue
+		}
+		if !h.warnedTunnels.markOnce(item.UserTunnelID, now) {
+			continue
+		}
+		h.events.publish(panelEvent{
+			Type:   eventUserTunnelExpiryWarning,
+			UserID: item.UserID,
+			Data: map[string]interface{}{
+				"userId":        item.UserID,
+				"userTunnelId":  item.UserTunnelID,
+				"tunnelName":    item.TunnelName,
+				"expiresInDays": float64(item.ExpTime-nowMs) / float64(millisPerDay),
+			},
+		})
+	}
+}
+
+func (h *Handler) userNotificationPrefGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	userID, _, err := userRoleFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	pref, err := h.repo.GetUserNotificationPref(userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(pref))
+}
+
+func (h *Handler) userNotificationPrefUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req userNotificationPrefRequest
+	// Permissive so new preference toggles do not break older servers.
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	userID, _, err := userRoleFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	pref, err := h.repo.GetUserNotificationPref(userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if req.ExpiryWarningEnabled != nil {
+		pref.ExpiryWarningEnabled = *req.ExpiryWarningEnabled
+	}
+	if req.SecurityEmailEnabled != nil {
+		pref.SecurityEmailEnabled = *req.SecurityEmailEnabled
+	}
+	if req.SecurityWebhookURL != nil {
+		webhook := strings.TrimSpace(*req.SecurityWebhookURL)
+		if webhook != "" && !validSecurityWebhookURL(webhook) {
+			response.WriteJSON(w, response.ErrDefault("Webhook地址无效"))
+			return
+		}
+		pref.SecurityWebhookURL = webhook
+	}
+	if err := h.repo.UpsertUserNotificationPref(*pref, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(pref))
+}
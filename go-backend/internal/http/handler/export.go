@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+type exportUserFlowRequest struct {
+	UserID int64  `json:"userId"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+var userFlowCSVHeader = []string{"date", "tunnel_name", "forward_name", "in_bytes", "out_bytes", "total_bytes"}
+
+func (h *Handler) adminExportUserFlow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req exportUserFlowRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.UserID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("用户ID不能为空"))
+		return
+	}
+	from, to := strings.TrimSpace(req.From), strings.TrimSpace(req.To)
+	fromDate, errFrom := time.Parse("2006-01-02", from)
+	toDate, errTo := time.Parse("2006-01-02", to)
+	if errFrom != nil || errTo != nil {
+		response.WriteJSON(w, response.ErrDefault("日期格式错误，应为 YYYY-MM-DD"))
+		return
+	}
+	if toDate.Before(fromDate) {
+		response.WriteJSON(w, response.ErrDefault("结束日期不能早于开始日期"))
+		return
+	}
+
+	// Rows go out as they are scanned. Headers are only sent with the first
+	// row, so a range that is too large can still be answered with an error.
+	var cw *csv.Writer
+	begin := func() {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=flow_%d_%s_%s.csv", req.UserID, from, to))
+		cw = csv.NewWriter(w)
+		_ = cw.Write(userFlowCSVHeader)
+	}
+	err := h.repo.ScanUserFlowForExport(req.UserID, from, to, func(row sqlite.FlowExportRow) error {
+		if cw == nil {
+			begin()
+		}
+		return cw.Write([]string{
+			row.Date,
+			row.TunnelName,
+			row.ForwardName,
+			strconv.FormatInt(row.InBytes, 10),
+			strconv.FormatInt(row.OutBytes, 10),
+			strconv.FormatInt(row.TotalBytes, 10),
+		})
+	})
+	if err != nil && cw == nil {
+		if errors.Is(err, sqlite.ErrFlowExportTooLarge) {
+			response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("导出数据超过 %d 行，请缩小日期范围", sqlite.MaxFlowExportRows)))
+			return
+		}
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err != nil {
+		// Part of the file is already out; the error can only be logged.
+		log.Printf("export user %d flow: %v", req.UserID, err)
+	}
+	if cw == nil {
+		begin()
+	}
+	cw.Flush()
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -13,6 +14,8 @@ import (
 
 	"go-backend/internal/http/client"
 	"go-backend/internal/http/response"
+	"go-backend/internal/network"
+	"go-backend/internal/store"
 	"go-backend/internal/store/sqlite"
 )
 
@@ -50,11 +53,13 @@ type updatePeerShareRequest struct {
 	PortRangeEnd   int    `json:"portRangeEnd"`
 	AllowedDomains string `json:"allowedDomains"`
 	AllowedIPs     string `json:"allowedIps"`
+	IsActive       *int   `json:"isActive"`
 }
 
 type nodeImportRequest struct {
-	RemoteURL string `json:"remoteUrl"`
-	Token     string `json:"token"`
+	RemoteURL   string `json:"remoteUrl"`
+	Token       string `json:"token"`
+	CallbackURL string `json:"callbackUrl"`
 }
 
 type federationRuntimeReservePortRequest struct {
@@ -126,19 +131,20 @@ type remoteUsageBindingItem struct {
 }
 
 type remoteUsageNodeItem struct {
-	NodeID           int64                    `json:"nodeId"`
-	NodeName         string                   `json:"nodeName"`
-	RemoteURL        string                   `json:"remoteUrl"`
-	ShareID          int64                    `json:"shareId"`
-	PortRangeStart   int                      `json:"portRangeStart"`
-	PortRangeEnd     int                      `json:"portRangeEnd"`
-	MaxBandwidth     int64                    `json:"maxBandwidth"`
-	CurrentFlow      int64                    `json:"currentFlow"`
-	ExpiryTime       int64                    `json:"expiryTime"`
-	UsedPorts        []int                    `json:"usedPorts"`
-	Bindings         []remoteUsageBindingItem `json:"bindings"`
-	ActiveBindingNum int                      `json:"activeBindingNum"`
-	SyncError        string                   `json:"syncError,omitempty"`
+	NodeID            int64                    `json:"nodeId"`
+	NodeName          string                   `json:"nodeName"`
+	RemoteURL         string                   `json:"remoteUrl"`
+	ShareID           int64                    `json:"shareId"`
+	PortRangeStart    int                      `json:"portRangeStart"`
+	PortRangeEnd      int                      `json:"portRangeEnd"`
+	MaxBandwidth      int64                    `json:"maxBandwidth"`
+	CurrentFlow       int64                    `json:"currentFlow"`
+	ExpiryTime        int64                    `json:"expiryTime"`
+	UsedPorts         []int                    `json:"usedPorts"`
+	Bindings          []remoteUsageBindingItem `json:"bindings"`
+	ActiveBindingNum  int                      `json:"activeBindingNum"`
+	RemoteAllocations []sqlite.PeerAllocation  `json:"remoteAllocations"`
+	SyncError         string                   `json:"syncError,omitempty"`
 }
 
 func (h *Handler) federationShareList(w http.ResponseWriter, r *http.Request) {
@@ -259,6 +265,9 @@ func (h *Handler) federationShareCreate(w http.ResponseWriter, r *http.Request)
 		response.WriteJSON(w, response.ErrDefault("Only local nodes can be shared"))
 		return
 	}
+	if !h.checkPeerSharePortConflict(w, req.NodeID, req.PortRangeStart, req.PortRangeEnd, 0) {
+		return
+	}
 
 	now := time.Now().UnixMilli()
 	token := randomToken(32)
@@ -298,6 +307,16 @@ func (h *Handler) federationShareDelete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	share, err := h.repo.GetPeerShare(req.ID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	var targets []sqlite.PeerShareConsumerCallback
+	if share != nil {
+		targets, _ = h.repo.ListPeerShareRuntimeConsumerURLs(share.ID)
+	}
+
 	h.cleanupPeerShareRuntimes(req.ID)
 
 	if err := h.repo.DeletePeerShare(req.ID); err != nil {
@@ -305,6 +324,7 @@ func (h *Handler) federationShareDelete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	h.notifyPeerShareDeactivated(share, targets)
 	response.WriteJSON(w, response.OKEmpty())
 }
 
@@ -399,6 +419,23 @@ func (h *Handler) federationShareUpdate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if req.IsActive != nil && *req.IsActive != 0 && *req.IsActive != 1 {
+		response.WriteJSON(w, response.ErrDefault("Invalid active state"))
+		return
+	}
+	if (req.IsActive == nil && share.IsActive == 1) || (req.IsActive != nil && *req.IsActive == 1) {
+		if !h.checkPeerSharePortConflict(w, share.NodeID, req.PortRangeStart, req.PortRangeEnd, share.ID) {
+			return
+		}
+	}
+	var targets []sqlite.PeerShareConsumerCallback
+	if req.IsActive != nil {
+		if share.IsActive == 1 && *req.IsActive == 0 {
+			targets, _ = h.repo.ListPeerShareRuntimeConsumerURLs(share.ID)
+		}
+		share.IsActive = *req.IsActive
+	}
+
 	share.Name = req.Name
 	share.MaxBandwidth = req.MaxBandwidth
 	share.ExpiryTime = req.ExpiryTime
@@ -413,9 +450,25 @@ func (h *Handler) federationShareUpdate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	h.notifyPeerShareDeactivated(share, targets)
 	response.WriteJSON(w, response.OKEmpty())
 }
 
+// checkPeerSharePortConflict writes an error and returns false when the
+// range overlaps another active share on the node.
+func (h *Handler) checkPeerSharePortConflict(w http.ResponseWriter, nodeID int64, portStart, portEnd int, excludeShareID int64) bool {
+	conflict, err := h.repo.FindPeerSharePortConflict(nodeID, portStart, portEnd, excludeShareID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return false
+	}
+	if conflict != nil {
+		response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("Port range %d-%d overlaps share %q (%d-%d)", portStart, portEnd, conflict.Name, conflict.PortRangeStart, conflict.PortRangeEnd)))
+		return false
+	}
+	return true
+}
+
 func (h *Handler) federationRemoteUsageList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		response.WriteJSON(w, response.ErrDefault("Invalid method"))
@@ -434,7 +487,7 @@ func (h *Handler) federationRemoteUsageList(w http.ResponseWriter, r *http.Reque
 	}
 	defer rows.Close()
 
-	fc := client.NewFederationClient()
+	fc := client.NewFederationClient().WithConsumerID(h.federationConsumerID())
 	localDomain := h.federationLocalDomain()
 
 	items := make([]remoteUsageNodeItem, 0)
@@ -455,7 +508,7 @@ func (h *Handler) federationRemoteUsageList(w http.ResponseWriter, r *http.Reque
 
 		var syncError string
 		url := strings.TrimSpace(remoteURL.String)
-		token := strings.TrimSpace(remoteToken.String)
+		token, _ := h.repo.OpenSecret(strings.TrimSpace(remoteToken.String))
 		if url != "" && token != "" {
 			info, connectErr := fc.Connect(url, token, localDomain)
 			if connectErr != nil {
@@ -519,20 +572,27 @@ func (h *Handler) federationRemoteUsageList(w http.ResponseWriter, r *http.Reque
 		}
 		sort.Ints(usedPorts)
 
+		allocations, err := h.repo.GetRemoteNodeAllocations(nodeID)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+
 		items = append(items, remoteUsageNodeItem{
-			NodeID:           nodeID,
-			NodeName:         nodeName,
-			RemoteURL:        url,
-			ShareID:          shareID,
-			PortRangeStart:   portRangeStart,
-			PortRangeEnd:     portRangeEnd,
-			MaxBandwidth:     maxBandwidth,
-			CurrentFlow:      currentFlow,
-			ExpiryTime:       expiryTime,
-			UsedPorts:        usedPorts,
-			Bindings:         bindings,
-			ActiveBindingNum: len(bindings),
-			SyncError:        syncError,
+			NodeID:            nodeID,
+			NodeName:          nodeName,
+			RemoteURL:         url,
+			ShareID:           shareID,
+			PortRangeStart:    portRangeStart,
+			PortRangeEnd:      portRangeEnd,
+			MaxBandwidth:      maxBandwidth,
+			CurrentFlow:       currentFlow,
+			ExpiryTime:        expiryTime,
+			UsedPorts:         usedPorts,
+			Bindings:          bindings,
+			ActiveBindingNum:  len(bindings),
+			RemoteAllocations: allocations,
+			SyncError:         syncError,
 		})
 	}
 	if err := rows.Err(); err != nil {
@@ -580,13 +640,29 @@ func (h *Handler) nodeImport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	callbackURL, err := normalizeFederationCallbackURL(req.CallbackURL)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+
+	preview, err := h.previewFederationShare(req.RemoteURL, req.Token)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, "Failed to connect: "+err.Error()))
+		return
+	}
+	if preview.HasPortConflict && !h.federationPortConflictAllowed() {
+		response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("Share port range %s on %s overlaps local node(s): %s", preview.PortRange, preview.ServerIP, strings.Join(preview.ConflictNodes, ", "))))
+		return
+	}
+
 	domainCfg, _ := h.repo.GetConfigByName("panel_domain")
 	localDomain := ""
 	if domainCfg != nil {
 		localDomain = domainCfg.Value
 	}
 
-	fc := client.NewFederationClient()
+	fc := client.NewFederationClient().WithConsumerID(h.federationConsumerID()).WithCallbackURL(callbackURL)
 	info, err := fc.Connect(req.RemoteURL, req.Token, localDomain)
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, "Failed to connect: "+err.Error()))
@@ -614,8 +690,8 @@ func (h *Handler) nodeImport(w http.ResponseWriter, r *http.Request) {
 	now := time.Now().UnixMilli()
 
 	_, err = db.Exec(`
-		INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx, is_remote, remote_url, remote_token, remote_config)
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?, 0, 0, 0, ?, ?, ?, ?, ?, ?, 1, ?, ?, ?)
+		INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx, is_remote, remote_url, remote_token, remote_config, callback_url)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, 0, 0, 0, ?, ?, ?, ?, ?, ?, 1, ?, ?, ?, ?)
 	`,
 		fmt.Sprintf("%s (Remote)", info.NodeName),
 		randomToken(16), // Dummy secret
@@ -629,8 +705,9 @@ func (h *Handler) nodeImport(w http.ResponseWriter, r *http.Request) {
 		"[::]", "[::]",
 		inx,
 		req.RemoteURL,
-		req.Token,
+		h.repo.SealSecret(req.Token),
 		string(configBytes),
+		callbackURL,
 	)
 
 	if err != nil {
@@ -665,6 +742,10 @@ func (h *Handler) authPeer(next http.HandlerFunc) http.HandlerFunc {
 			response.WriteJSON(w, response.Err(401, "Invalid token"))
 			return
 		}
+		if err := h.verifyPeerSignature(r, token); err != nil {
+			response.WriteJSON(w, response.Err(401, "Invalid signature"))
+			return
+		}
 
 		if share.IsActive == 0 {
 			response.WriteJSON(w, response.Err(403, "Share is disabled"))
@@ -677,7 +758,7 @@ func (h *Handler) authPeer(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		if strings.TrimSpace(share.AllowedIPs) != "" {
-			clientIP := resolvePeerClientIP(r)
+			clientIP := network.ClientIP(r)
 			if clientIP == nil {
 				response.WriteJSON(w, response.Err(403, "Unable to determine client IP"))
 				return
@@ -735,6 +816,26 @@ func (h *Handler) federationConnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.registerPeerShareConsumer(share, r)
+
+	runtimes, err := h.repo.ListPeerShareRuntimes(share.ID, peerConsumerID(r))
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	runtimeItems := make([]map[string]interface{}, 0, len(runtimes))
+	for _, rt := range runtimes {
+		runtimeItems = append(runtimeItems, map[string]interface{}{
+			"reservationId": rt.ReservationID,
+			"bindingId":     rt.BindingID,
+			"role":          rt.Role,
+			"protocol":      rt.Protocol,
+			"port":          rt.Port,
+			"applied":       rt.Applied,
+			"status":        rt.Status,
+		})
+	}
+
 	response.WriteJSON(w, response.OK(map[string]interface{}{
 		"shareId":        share.ID,
 		"shareName":      share.Name,
@@ -747,6 +848,7 @@ func (h *Handler) federationConnect(w http.ResponseWriter, r *http.Request) {
 		"expiryTime":     share.ExpiryTime,
 		"portRangeStart": share.PortRangeStart,
 		"portRangeEnd":   share.PortRangeEnd,
+		"runtimes":       runtimeItems,
 	}))
 }
 
@@ -851,7 +953,9 @@ func (h *Handler) federationRuntimeReservePort(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	existing, err := h.repo.GetPeerShareRuntimeByResourceKey(share.ID, req.ResourceKey)
+	consumerID := peerConsumerID(r)
+	resourceKey := peerRuntimeResourceKey(consumerID, req.ResourceKey)
+	existing, err := h.repo.GetPeerShareRuntimeByResourceKey(share.ID, consumerID, resourceKey)
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
@@ -869,7 +973,22 @@ func (h *Handler) federationRuntimeReservePort(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	allocatedPort, err := h.pickPeerSharePort(share, req.RequestedPort)
+	// Hold the share lock from picking the port until the runtime row that
+	// claims it is committed, so concurrent reservations cannot pick the
+	// same port.
+	tx, err := h.repo.DB().Begin()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+	ctx := store.WithTx(r.Context(), tx)
+	if share, err = h.repo.LockPeerShareTx(ctx, share.ID); err != nil || share == nil {
+		response.WriteJSON(w, response.Err(401, "Unauthorized"))
+		return
+	}
+
+	allocatedPort, err := h.pickPeerSharePort(ctx, share, req.RequestedPort)
 	if err != nil {
 		response.WriteJSON(w, response.ErrDefault(err.Error()))
 		return
@@ -888,7 +1007,11 @@ func (h *Handler) federationRuntimeReservePort(w http.ResponseWriter, r *http.Re
 		existing.Applied = 0
 		existing.Status = 1
 		existing.UpdatedTime = now
-		if err := h.repo.UpdatePeerShareRuntime(existing); err != nil {
+		if err := h.repo.UpdatePeerShareRuntimeTx(ctx, existing); err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		if err := tx.Commit(); err != nil {
 			response.WriteJSON(w, response.Err(-2, err.Error()))
 			return
 		}
@@ -903,8 +1026,9 @@ func (h *Handler) federationRuntimeReservePort(w http.ResponseWriter, r *http.Re
 	runtime := &sqlite.PeerShareRuntime{
 		ShareID:       share.ID,
 		NodeID:        share.NodeID,
+		ConsumerID:    consumerID,
 		ReservationID: randomToken(24),
-		ResourceKey:   req.ResourceKey,
+		ResourceKey:   resourceKey,
 		BindingID:     "",
 		Role:          "",
 		ChainName:     "",
@@ -918,7 +1042,11 @@ func (h *Handler) federationRuntimeReservePort(w http.ResponseWriter, r *http.Re
 		CreatedTime:   now,
 		UpdatedTime:   now,
 	}
-	if err := h.repo.CreatePeerShareRuntime(runtime); err != nil {
+	if err := h.repo.CreatePeerShareRuntimeTx(ctx, runtime); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err := tx.Commit(); err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
@@ -954,11 +1082,12 @@ func (h *Handler) federationRuntimeApplyRole(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	consumerID := peerConsumerID(r)
 	var runtime *sqlite.PeerShareRuntime
 	if strings.TrimSpace(req.ReservationID) != "" {
-		runtime, err = h.repo.GetPeerShareRuntimeByReservationID(share.ID, strings.TrimSpace(req.ReservationID))
+		runtime, err = h.repo.GetPeerShareRuntimeByReservationID(share.ID, consumerID, strings.TrimSpace(req.ReservationID))
 	} else {
-		runtime, err = h.repo.GetPeerShareRuntimeByResourceKey(share.ID, strings.TrimSpace(req.ResourceKey))
+		runtime, err = h.repo.GetPeerShareRuntimeByResourceKey(share.ID, consumerID, peerRuntimeResourceKey(consumerID, strings.TrimSpace(req.ResourceKey)))
 	}
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
@@ -1074,6 +1203,7 @@ func (h *Handler) federationRuntimeApplyRole(w http.ResponseWriter, r *http.Requ
 	}
 
 	targetBytes, _ := json.Marshal(req.Targets)
+	runtime.ConsumerID = consumerID
 	runtime.BindingID = fmt.Sprintf("%d", runtime.ID)
 	runtime.Role = req.Role
 	runtime.ChainName = ""
@@ -1118,13 +1248,14 @@ func (h *Handler) federationRuntimeReleaseRole(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	consumerID := peerConsumerID(r)
 	var runtime *sqlite.PeerShareRuntime
 	if strings.TrimSpace(req.BindingID) != "" {
-		runtime, err = h.repo.GetPeerShareRuntimeByBindingID(share.ID, strings.TrimSpace(req.BindingID))
+		runtime, err = h.repo.GetPeerShareRuntimeByBindingID(share.ID, consumerID, strings.TrimSpace(req.BindingID))
 	} else if strings.TrimSpace(req.ReservationID) != "" {
-		runtime, err = h.repo.GetPeerShareRuntimeByReservationID(share.ID, strings.TrimSpace(req.ReservationID))
+		runtime, err = h.repo.GetPeerShareRuntimeByReservationID(share.ID, consumerID, strings.TrimSpace(req.ReservationID))
 	} else if strings.TrimSpace(req.ResourceKey) != "" {
-		runtime, err = h.repo.GetPeerShareRuntimeByResourceKey(share.ID, strings.TrimSpace(req.ResourceKey))
+		runtime, err = h.repo.GetPeerShareRuntimeByResourceKey(share.ID, consumerID, peerRuntimeResourceKey(consumerID, strings.TrimSpace(req.ResourceKey)))
 	} else {
 		response.WriteJSON(w, response.ErrDefault("bindingId or reservationId or resourceKey is required"))
 		return
@@ -1249,7 +1380,10 @@ func isFederationRuntimeCommandAllowed(commandType string) bool {
 	}
 }
 
-func (h *Handler) pickPeerSharePort(share *sqlite.PeerShare, requestedPort int) (int, error) {
+// pickPeerSharePort chooses a port for a new runtime of share. It reads
+// through the transaction in ctx, which should hold the share lock from
+// LockPeerShareTx until the runtime row is written.
+func (h *Handler) pickPeerSharePort(ctx context.Context, share *sqlite.PeerShare, requestedPort int) (int, error) {
 	if share == nil {
 		return 0, fmt.Errorf("share not found")
 	}
@@ -1257,52 +1391,31 @@ func (h *Handler) pickPeerSharePort(share *sqlite.PeerShare, requestedPort int)
 		return 0, fmt.Errorf("No available port")
 	}
 
-	used := make(map[int]struct{})
-
-	rows, err := h.repo.DB().Query(`SELECT port FROM chain_tunnel WHERE node_id = ? AND port IS NOT NULL AND port > 0`, share.NodeID)
+	used, err := h.repo.PeerSharePortsInUseTx(ctx, share)
 	if err != nil {
 		return 0, err
 	}
-	for rows.Next() {
-		var p sql.NullInt64
-		if scanErr := rows.Scan(&p); scanErr == nil && p.Valid && p.Int64 > 0 {
-			used[int(p.Int64)] = struct{}{}
-		}
-	}
-	_ = rows.Close()
-
-	rows, err = h.repo.DB().Query(`SELECT port FROM forward_port WHERE node_id = ? AND port > 0`, share.NodeID)
-	if err != nil {
-		return 0, err
-	}
-	for rows.Next() {
-		var p sql.NullInt64
-		if scanErr := rows.Scan(&p); scanErr == nil && p.Valid && p.Int64 > 0 {
-			used[int(p.Int64)] = struct{}{}
-		}
-	}
-	_ = rows.Close()
-
-	ports, err := h.repo.ListActivePeerShareRuntimePorts(share.ID, share.NodeID)
-	if err != nil {
-		return 0, err
-	}
-	for _, p := range ports {
-		if p > 0 {
-			used[p] = struct{}{}
-		}
-	}
 
 	if requestedPort > 0 {
 		if requestedPort < share.PortRangeStart || requestedPort > share.PortRangeEnd {
 			return 0, fmt.Errorf("Port out of range")
 		}
+		reserved, err := h.repo.FindPortReservation(share.NodeID, requestedPort)
+		if err != nil {
+			return 0, err
+		}
+		if reserved != nil {
+			return 0, fmt.Errorf("Port %d is reserved: %s", requestedPort, reserved.Reason)
+		}
 		if _, ok := used[requestedPort]; ok {
 			return 0, fmt.Errorf("No available port")
 		}
 		return requestedPort, nil
 	}
 
+	if err := markReservedPorts(h.repo.ExecerFromCtx(ctx), share.NodeID, used); err != nil {
+		return 0, err
+	}
 	for p := share.PortRangeStart; p <= share.PortRangeEnd; p++ {
 		if _, ok := used[p]; ok {
 			continue
@@ -1313,6 +1426,22 @@ func (h *Handler) pickPeerSharePort(share *sqlite.PeerShare, requestedPort int)
 	return 0, fmt.Errorf("No available port")
 }
 
+// peerConsumerID identifies the consumer panel behind a federation request.
+// Panels that predate X-Consumer-ID share the empty ID.
+func peerConsumerID(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get("X-Consumer-ID"))
+}
+
+// peerRuntimeResourceKey namespaces a consumer's resource key; resource keys
+// are derived from consumer-local IDs and would otherwise collide between
+// consumers of the same provider.
+func peerRuntimeResourceKey(consumerID, resourceKey string) string {
+	if consumerID == "" {
+		return resourceKey
+	}
+	return consumerID + ":" + resourceKey
+}
+
 func extractBearerToken(r *http.Request) string {
 	authHeader := r.Header.Get("Authorization")
 	parts := strings.Split(authHeader, " ")
@@ -1355,7 +1484,7 @@ func normalizePeerShareAllowedIPs(raw string) (string, error) {
 			}
 			item = network.String()
 		} else {
-			ip := parseIPLiteral(item)
+			ip := network.ParseIPLiteral(item)
 			if ip == nil {
 				return "", fmt.Errorf("Invalid allowed IP or CIDR: %s", item)
 			}
@@ -1372,101 +1501,8 @@ func normalizePeerShareAllowedIPs(raw string) (string, error) {
 	return strings.Join(normalized, ","), nil
 }
 
-func resolvePeerClientIP(r *http.Request) net.IP {
-	if r == nil {
-		return nil
-	}
-
-	remoteIP := parseIPLiteral(r.RemoteAddr)
-	if isTrustedProxyIP(remoteIP) {
-		if ip := parseForwardedFor(r.Header.Get("X-Forwarded-For")); ip != nil {
-			return ip
-		}
-		if ip := parseIPLiteral(r.Header.Get("X-Real-IP")); ip != nil {
-			return ip
-		}
-	}
-
-	return remoteIP
-}
-
-func parseForwardedFor(raw string) net.IP {
-	for _, part := range strings.Split(raw, ",") {
-		if ip := parseIPLiteral(part); ip != nil {
-			return ip
-		}
-	}
-	return nil
-}
-
-func parseIPLiteral(raw string) net.IP {
-	value := strings.Trim(strings.TrimSpace(raw), "\"")
-	if value == "" {
-		return nil
-	}
-
-	if ip := net.ParseIP(value); ip != nil {
-		return normalizeIPAddress(ip)
-	}
-
-	host, _, err := net.SplitHostPort(value)
-	if err != nil {
-		return nil
-	}
-
-	host = strings.Trim(strings.TrimSpace(host), "[]")
-	if host == "" {
-		return nil
-	}
-	return normalizeIPAddress(net.ParseIP(host))
-}
-
-func normalizeIPAddress(ip net.IP) net.IP {
-	if ip == nil {
-		return nil
-	}
-	if v4 := ip.To4(); v4 != nil {
-		return v4
-	}
-	return ip.To16()
-}
-
-func isTrustedProxyIP(ip net.IP) bool {
-	if ip == nil {
-		return false
-	}
-	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
-}
-
 func isPeerIPAllowed(clientIP net.IP, whitelist string) bool {
-	if clientIP == nil {
-		return false
-	}
-
-	for _, part := range strings.Split(whitelist, ",") {
-		entry := strings.TrimSpace(part)
-		if entry == "" {
-			continue
-		}
-
-		if strings.Contains(entry, "/") {
-			_, network, err := net.ParseCIDR(entry)
-			if err != nil {
-				continue
-			}
-			if network.Contains(clientIP) {
-				return true
-			}
-			continue
-		}
-
-		allowedIP := parseIPLiteral(entry)
-		if allowedIP != nil && allowedIP.Equal(clientIP) {
-			return true
-		}
-	}
-
-	return false
+	return network.AllowListContains(network.ParseAllowList(whitelist), clientIP)
 }
 
 func (h *Handler) syncRemoteNodeStatuses(items []map[string]interface{}) {
@@ -1496,7 +1532,7 @@ func (h *Handler) syncRemoteNodeStatuses(items []map[string]interface{}) {
 	}
 
 	localDomain := h.federationLocalDomain()
-	fc := client.NewFederationClientWithTimeout(5 * time.Second)
+	fc := client.NewFederationClientWithTimeout(5 * time.Second).WithConsumerID(h.federationConsumerID())
 
 	type syncResult struct {
 		index     int
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go-backend/internal/http/client"
+	"go-backend/internal/http/response"
+)
+
+// federationSharePreview is what a consumer learns about a share before
+// importing it.
+type federationSharePreview struct {
+	ShareID         int64    `json:"shareId"`
+	ShareName       string   `json:"shareName"`
+	NodeName        string   `json:"nodeName"`
+	ServerIP        string   `json:"serverIp"`
+	PortRange       string   `json:"portRange"`
+	MaxBandwidth    int64    `json:"maxBandwidth"`
+	CurrentFlow     int64    `json:"currentFlow"`
+	ExpiryTime      int64    `json:"expiryTime"`
+	UsedPorts       []int    `json:"usedPorts"`
+	HasPortConflict bool     `json:"hasPortConflict"`
+	ConflictNodes   []string `json:"conflictNodes"`
+}
+
+// federationShareStatus reports share metadata to a consumer that is about
+// to import the share. Unlike federationConnect it does not register the
+// caller as a consumer.
+func (h *Handler) federationShareStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("Invalid method"))
+		return
+	}
+
+	token := extractBearerToken(r)
+	share, err := h.repo.GetPeerShareByToken(token)
+	if err != nil || share == nil {
+		response.WriteJSON(w, response.Err(401, "Unauthorized"))
+		return
+	}
+
+	var nodeName, serverIP string
+	if err := h.repo.DB().QueryRow("SELECT name, server_ip FROM node WHERE id = ?", share.NodeID).Scan(&nodeName, &serverIP); err != nil {
+		response.WriteJSON(w, response.Err(-2, "Node not found"))
+		return
+	}
+	usedPorts, err := h.repo.ListActivePeerShareRuntimePorts(share.ID, share.NodeID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	response.WriteJSON(w, response.OK(client.RemoteShareStatus{
+		ShareID:        share.ID,
+		ShareName:      share.Name,
+		NodeName:       nodeName,
+		ServerIP:       serverIP,
+		MaxBandwidth:   share.MaxBandwidth,
+		CurrentFlow:    share.CurrentFlow,
+		ExpiryTime:     share.ExpiryTime,
+		PortRangeStart: share.PortRangeStart,
+		PortRangeEnd:   share.PortRangeEnd,
+		UsedPorts:      usedPorts,
+	}))
+}
+
+func (h *Handler) federationSharePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("Invalid method"))
+		return
+	}
+
+	var req nodeImportRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("Invalid JSON"))
+		return
+	}
+	if req.RemoteURL == "" || req.Token == "" {
+		response.WriteJSON(w, response.ErrDefault("Remote URL and Token are required"))
+		return
+	}
+
+	preview, err := h.previewFederationShare(req.RemoteURL, req.Token)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, "Failed to connect: "+err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(preview))
+}
+
+// previewFederationShare fetches the share status from the provider and
+// checks its port range against local nodes on the same server address.
+func (h *Handler) previewFederationShare(remoteURL, token string) (*federationSharePreview, error) {
+	domainCfg, _ := h.repo.GetConfigByName("panel_domain")
+	localDomain := ""
+	if domainCfg != nil {
+		localDomain = domainCfg.Value
+	}
+
+	status, err := client.NewFederationClient().WithConsumerID(h.federationConsumerID()).ShareStatus(remoteURL, token, localDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &federationSharePreview{
+		ShareID:       status.ShareID,
+		ShareName:     status.ShareName,
+		NodeName:      status.NodeName,
+		ServerIP:      status.ServerIP,
+		PortRange:     "0",
+		MaxBandwidth:  status.MaxBandwidth,
+		CurrentFlow:   status.CurrentFlow,
+		ExpiryTime:    status.ExpiryTime,
+		UsedPorts:     status.UsedPorts,
+		ConflictNodes: make([]string, 0),
+	}
+	if preview.UsedPorts == nil {
+		preview.UsedPorts = make([]int, 0)
+	}
+	if status.PortRangeStart > 0 && status.PortRangeEnd >= status.PortRangeStart {
+		preview.PortRange = fmt.Sprintf("%d-%d", status.PortRangeStart, status.PortRangeEnd)
+		conflicts, err := h.localPortRangeConflicts(status.ServerIP, status.PortRangeStart, status.PortRangeEnd)
+		if err != nil {
+			return nil, err
+		}
+		preview.ConflictNodes = conflicts
+		preview.HasPortConflict = len(conflicts) > 0
+	}
+	return preview, nil
+}
+
+// localPortRangeConflicts returns the local nodes on serverIP whose port
+// range overlaps start-end. Nodes on other addresses never conflict, since
+// their ports live on a different host.
+func (h *Handler) localPortRangeConflicts(serverIP string, start, end int) ([]string, error) {
+	conflicts := make([]string, 0)
+	serverIP = strings.TrimSpace(serverIP)
+	if serverIP == "" {
+		return conflicts, nil
+	}
+	rows, err := h.repo.DB().Query(`
+		SELECT name, port FROM node
+		WHERE COALESCE(is_remote, 0) = 0 AND (server_ip = ? OR server_ip_v4 = ? OR server_ip_v6 = ?)
+		ORDER BY id ASC
+	`, serverIP, serverIP, serverIP)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, portRange string
+		if err := rows.Scan(&name, &portRange); err != nil {
+			return nil, err
+		}
+		for _, p := range parsePortRangeSpec(portRange) {
+			if p >= start && p <= end {
+				conflicts = append(conflicts, name)
+				break
+			}
+		}
+	}
+	return conflicts, rows.Err()
+}
+
+func (h *Handler) federationPortConflictAllowed() bool {
+	cfg, err := h.repo.GetConfigByName("federation_allow_port_conflict")
+	if err != nil || cfg == nil {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(cfg.Value), "true")
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"go-backend/internal/http/response"
+	"go-backend/internal/store"
 	"go-backend/internal/store/sqlite"
 )
 
@@ -43,7 +45,7 @@ func TestPickPeerSharePortUsesRuntimeReservations(t *testing.T) {
 		PortRangeEnd:   3004,
 	}
 
-	port, err := h.pickPeerSharePort(share, 0)
+	port, err := h.pickPeerSharePort(context.Background(), share, 0)
 	if err != nil {
 		t.Fatalf("pick auto port: %v", err)
 	}
@@ -51,7 +53,7 @@ func TestPickPeerSharePortUsesRuntimeReservations(t *testing.T) {
 		t.Fatalf("expected port 3003, got %d", port)
 	}
 
-	if _, err := h.pickPeerSharePort(share, 3001); err == nil {
+	if _, err := h.pickPeerSharePort(context.Background(), share, 3001); err == nil {
 		t.Fatalf("expected requested busy port to fail")
 	}
 }
@@ -140,7 +142,7 @@ func TestPrepareTunnelCreateStateRemoteAutoPortDefersToFederation(t *testing.T)
 		"chainNodes": []interface{}{},
 	}
 
-	state, err := h.prepareTunnelCreateState(tx, req, 2, 0)
+	state, err := h.prepareTunnelCreateState(store.WithTx(context.Background(), tx), req, 2, 0)
 	if err != nil {
 		t.Fatalf("prepare state should not fail for remote auto-port: %v", err)
 	}
@@ -202,7 +204,7 @@ func TestPrepareTunnelCreateStateAllowsOfflineRemoteMiddleNode(t *testing.T) {
 		},
 	}
 
-	state, err := h.prepareTunnelCreateState(tx, req, 2, 0)
+	state, err := h.prepareTunnelCreateState(store.WithTx(context.Background(), tx), req, 2, 0)
 	if err != nil {
 		t.Fatalf("prepare state should allow offline remote middle node: %v", err)
 	}
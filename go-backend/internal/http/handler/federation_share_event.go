@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/client"
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+const shareDeactivatedEvent = "share_deactivated"
+
+type shareDeactivatedRequest struct {
+	Event      string   `json:"event"`
+	ShareID    int64    `json:"shareId"`
+	BindingIDs []string `json:"bindingIds"`
+}
+
+// normalizeFederationCallbackURL accepts an empty value (no callback) or an
+// absolute http(s) URL.
+func normalizeFederationCallbackURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("Invalid callback URL")
+	}
+	return raw, nil
+}
+
+// registerPeerShareConsumer stores the callback URL a consumer sent with its
+// connect request.
+func (h *Handler) registerPeerShareConsumer(share *sqlite.PeerShare, r *http.Request) {
+	consumerID := peerConsumerID(r)
+	if share == nil || consumerID == "" {
+		return
+	}
+	callbackURL, err := normalizeFederationCallbackURL(r.Header.Get("X-Consumer-Callback"))
+	if err != nil || callbackURL == "" {
+		return
+	}
+	_ = h.repo.UpsertPeerShareConsumer(share.ID, consumerID, callbackURL, time.Now().UnixMilli())
+}
+
+// notifyPeerShareDeactivated tells every registered consumer that a share is
+// no longer usable. Targets must be collected before the share's runtimes are
+// released, otherwise the binding IDs are already gone.
+func (h *Handler) notifyPeerShareDeactivated(share *sqlite.PeerShare, targets []sqlite.PeerShareConsumerCallback) {
+	if share == nil || len(targets) == 0 {
+		return
+	}
+	fc := client.NewFederationClientWithTimeout(5 * time.Second)
+	for _, target := range targets {
+		go func(target sqlite.PeerShareConsumerCallback) {
+			_ = fc.NotifyShareDeactivated(target.CallbackURL, share.Token, client.ShareDeactivatedEvent{
+				Event:      shareDeactivatedEvent,
+				ShareID:    share.ID,
+				BindingIDs: target.BindingIDs,
+			})
+		}(target)
+	}
+}
+
+// federationShareDeactivated runs on the consumer. It deactivates the bindings
+// on the remote node imported with the calling share's token and pauses the
+// forwards of the affected tunnels.
+func (h *Handler) federationShareDeactivated(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("Invalid method"))
+		return
+	}
+
+	token := extractBearerToken(r)
+	if token == "" {
+		response.WriteJSON(w, response.Err(401, "Unauthorized"))
+		return
+	}
+	if err := h.verifyPeerSignature(r, token); err != nil {
+		response.WriteJSON(w, response.Err(401, "Invalid signature"))
+		return
+	}
+
+	var req shareDeactivatedRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("Invalid JSON"))
+		return
+	}
+	if req.Event != shareDeactivatedEvent || req.ShareID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("Invalid event"))
+		return
+	}
+
+	rows, err := h.repo.DB().Query(`SELECT id, remote_config FROM node WHERE is_remote = 1 AND remote_token IN (?, ?)`, h.repo.SealSecret(token), token)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	nodeIDs := make([]int64, 0)
+	for rows.Next() {
+		var nodeID int64
+		var remoteConfig sql.NullString
+		if err := rows.Scan(&nodeID, &remoteConfig); err != nil {
+			_ = rows.Close()
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		if remoteShareIDFromConfig(remoteConfig.String) == req.ShareID {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+	}
+	_ = rows.Close()
+	if len(nodeIDs) == 0 {
+		response.WriteJSON(w, response.Err(401, "Unauthorized"))
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	paused := 0
+	for _, nodeID := range nodeIDs {
+		tunnelIDs, err := h.repo.DeactivateFederationTunnelBindings(nodeID, req.BindingIDs, now)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		for _, tunnelID := range tunnelIDs {
+			forwards, err := h.listActiveForwardsByTunnel(tunnelID)
+			if err != nil {
+				continue
+			}
+			h.pauseForwardRecords(forwards, now)
+			paused += len(forwards)
+		}
+	}
+
+	response.WriteJSON(w, response.OK(map[string]interface{}{"pausedForwards": paused}))
+}
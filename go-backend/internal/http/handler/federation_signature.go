@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/client"
+)
+
+const (
+	// federationSignatureRequiredConfigKey refuses unsigned peer calls. It
+	// stays off while consumers still run panels that do not sign.
+	federationSignatureRequiredConfigKey = "federation_signature_required"
+
+	peerSignatureMaxSkew   = 5 * time.Minute
+	peerSignatureNonceSize = 64
+)
+
+var errPeerSignature = errors.New("invalid peer signature")
+
+// verifyPeerSignature checks the signature headers of a peer call made with
+// token. The body is read and put back for the handler. Unsigned calls pass
+// unless federation_signature_required is "true".
+func (h *Handler) verifyPeerSignature(r *http.Request, token string) error {
+	signature := strings.TrimSpace(r.Header.Get(client.PeerSignatureHeader))
+	if signature == "" {
+		if h.configValue(federationSignatureRequiredConfigKey) == "true" {
+			return errPeerSignature
+		}
+		return nil
+	}
+	timestamp := strings.TrimSpace(r.Header.Get(client.PeerTimestampHeader))
+	nonce := strings.TrimSpace(r.Header.Get(client.PeerNonceHeader))
+	if nonce == "" || len(nonce) > peerSignatureNonceSize {
+		return errPeerSignature
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errPeerSignature
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(ts, 0)); skew > peerSignatureMaxSkew || skew < -peerSignatureMaxSkew {
+		return errPeerSignature
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	expected := client.PeerSignature(token, r.Method, r.URL.Path, timestamp, nonce, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errPeerSignature
+	}
+	if !h.peerNonces.add(token+":"+nonce, now) {
+		return errPeerSignature
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/network"
+	"go-backend/internal/security"
+)
+
+const (
+	// flowEnvelopeVersion is the newest encrypted envelope nodes send:
+	// {"encrypted":true,"v":2,"data":...,"timestamp":<unix seconds>}, with
+	// the version and timestamp authenticated as AES-GCM additional data.
+	// Every envelope's timestamp must lie within node_message_max_skew_sec
+	// of the panel clock.
+	flowEnvelopeVersion = 2
+
+	nodePayloadStrictConfigKey = "node_payload_strict"
+
+	// Signed reports carry an HMAC-SHA256, keyed with the node secret, over
+	// the timestamp, nonce and raw body in these headers.
+	flowSignatureHeader = "X-Flvx-Signature"
+	flowTimestampHeader = "X-Flvx-Timestamp"
+	flowNonceHeader     = "X-Flvx-Nonce"
+	flowNonceMaxLen     = 64
+
+	nodeUploadSignatureRequiredConfigKey = "node_upload_signature_required"
+)
+
+var errFlowPayloadRejected = errors.New("flow payload rejected")
+
+type flowEnvelope struct {
+	Encrypted bool   `json:"encrypted"`
+	Version   int    `json:"v"`
+	Data      string `json:"data"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// flowEnvelopeAAD is the additional data a version 2 envelope is sealed
+// with, so the timestamp cannot be changed to replay an old report.
+func flowEnvelopeAAD(timestamp int64) []byte {
+	return []byte("flvx-flow:v" + strconv.Itoa(flowEnvelopeVersion) + ":" + strconv.FormatInt(timestamp, 10))
+}
+
+// flowSignature is the hex HMAC-SHA256 a node sends in flowSignatureHeader.
+func flowSignature(secret, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyFlowSignature checks the signature headers of a node report against
+// its raw body. The timestamp must be fresh and the nonce unseen, so a
+// captured report cannot be counted twice. Unsigned reports from older
+// nodes pass unless node_upload_signature_required is "true".
+func (h *Handler) verifyFlowSignature(r *http.Request, secret string, body []byte) error {
+	signature := strings.TrimSpace(r.Header.Get(flowSignatureHeader))
+	if signature == "" {
+		if h.configValue(nodeUploadSignatureRequiredConfigKey) == "true" {
+			return errFlowPayloadRejected
+		}
+		return nil
+	}
+	timestamp := strings.TrimSpace(r.Header.Get(flowTimestampHeader))
+	nonce := strings.TrimSpace(r.Header.Get(flowNonceHeader))
+	if nonce == "" || len(nonce) > flowNonceMaxLen {
+		return errFlowPayloadRejected
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errFlowPayloadRejected
+	}
+	now := time.Now()
+	if skew := security.TimestampSkew(ts, now); skew > h.nodeMessageMaxSkew() {
+		h.logStaleNodeMessage(r, secret, skew)
+		return errFlowPayloadRejected
+	}
+	if !hmac.Equal([]byte(signature), []byte(flowSignature(secret, timestamp, nonce, body))) {
+		return errFlowPayloadRejected
+	}
+	if !h.flowNonces.add(secret+":sig:"+nonce, now) {
+		return errFlowPayloadRejected
+	}
+	return nil
+}
+
+// nodeMessageMaxSkew is the configured node_message_max_skew_sec window.
+func (h *Handler) nodeMessageMaxSkew() time.Duration {
+	return security.ParseNodeMessageMaxSkew(h.configValue(security.NodeMessageMaxSkewConfigKey))
+}
+
+// logStaleNodeMessage names the node behind a rejected report, whose clock
+// is most likely off.
+func (h *Handler) logStaleNodeMessage(r *http.Request, secret string, skew time.Duration) {
+	node := "unknown node"
+	if n, err := h.repo.GetNodeBySecret(secret); err == nil && n != nil {
+		node = fmt.Sprintf("node %d (%s)", n.ID, n.Name)
+	}
+	ip := ""
+	if addr := network.ClientIP(r); addr != nil {
+		ip = addr.String()
+	}
+	log.Printf("rejected report from %s at %s: timestamp %s off the panel clock", node, ip, skew.Round(time.Second))
+}
+
+// seenNonces remembers recently accepted nonces so a captured report
+// cannot be replayed while its timestamp is still fresh.
+type seenNonces struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newSeenNonces(ttl time.Duration) *seenNonces {
+	return &seenNonces{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// add records nonce and reports false when it was already seen.
+func (s *seenNonces) add(nonce string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastPrune) >= time.Minute {
+		for k, expiresAt := range s.seen {
+			if !expiresAt.After(now) {
+				delete(s.seen, k)
+			}
+		}
+		s.lastPrune = now
+	}
+	if expiresAt, ok := s.seen[nonce]; ok && expiresAt.After(now) {
+		return false
+	}
+	s.seen[nonce] = now.Add(s.ttl)
+	return true
+}
@@ -39,8 +39,12 @@ func (h *Handler) processFlowItem(item flowItem) {
 
 	forwardID, userID, userTunnelID, ok := parseFlowServiceIDs(serviceName)
 	if ok {
-		inFlow, outFlow := h.scaleFlowByTunnel(forwardID, item.D, item.U)
+		inFlow, outFlow, tunnelID := h.scaleFlowByTunnel(forwardID, item.D, item.U)
 		_ = h.repo.AddFlow(forwardID, userID, userTunnelID, inFlow, outFlow)
+		h.tunnelMetrics.connectionsOpened(tunnelID, item.O)
+		h.tunnelMetrics.connectionsClosed(tunnelID, item.C)
+		h.tunnelMetrics.addBytes(tunnelID, item.U+item.D)
+		h.checkFlowAnomaly(forwardID, userID, item.U+item.D)
 
 		if userTunnelID > 0 {
 			h.enforceFlowPolicies(userID, userTunnelID)
@@ -55,6 +59,20 @@ func (h *Handler) processFlowItem(item flowItem) {
 	h.processPeerShareFlow(runtimeID, item)
 }
 
+// checkFlowAnomaly updates the forward's traffic baseline and notifies the
+// owner and admins when the upload is far above it.
+func (h *Handler) checkFlowAnomaly(forwardID, userID, bytes int64) {
+	anomaly, isAnomaly, err := h.repo.UpdateForwardBaseline(forwardID, bytes)
+	if err != nil || !isAnomaly {
+		return
+	}
+	h.events.publish(panelEvent{
+		Type:   eventFlowAnomaly,
+		UserID: userID,
+		Data:   anomaly,
+	})
+}
+
 func parseFlowServiceIDs(serviceName string) (int64, int64, int64, bool) {
 	parts := strings.Split(serviceName, "_")
 	if len(parts) < 3 {
@@ -137,20 +155,20 @@ func (h *Handler) enforcePeerShareFlowLimit(shareID int64) {
 	}
 }
 
-func (h *Handler) scaleFlowByTunnel(forwardID int64, inFlow int64, outFlow int64) (int64, int64) {
+func (h *Handler) scaleFlowByTunnel(forwardID int64, inFlow int64, outFlow int64) (int64, int64, int64) {
 	forward, err := h.getForwardRecord(forwardID)
 	if err != nil || forward == nil {
-		return inFlow, outFlow
+		return inFlow, outFlow, 0
 	}
 
 	tunnel, err := h.getTunnelRecord(forward.TunnelID)
 	if err != nil || tunnel == nil {
-		return inFlow, outFlow
+		return inFlow, outFlow, forward.TunnelID
 	}
 
 	scaledIn := int64(float64(inFlow)*tunnel.TrafficRatio) * tunnel.Flow
 	scaledOut := int64(float64(outFlow)*tunnel.TrafficRatio) * tunnel.Flow
-	return scaledIn, scaledOut
+	return scaledIn, scaledOut, tunnel.ID
 }
 
 func (h *Handler) enforceFlowPolicies(userID int64, userTunnelID int64) {
@@ -251,7 +269,7 @@ func (h *Handler) pauseForwardRecords(forwards []forwardRecord, now int64) {
 
 func (h *Handler) listActiveForwardsByUser(userID int64) ([]forwardRecord, error) {
 	rows, err := h.repo.DB().Query(`
-		SELECT id, user_id, user_name, name, tunnel_id, remote_addr, COALESCE(strategy, 'fifo'), status
+		SELECT `+forwardRecordColumns+`
 		FROM forward
 		WHERE user_id = ? AND status = 1
 		ORDER BY id ASC
@@ -266,7 +284,7 @@ func (h *Handler) listActiveForwardsByUser(userID int64) ([]forwardRecord, error
 
 func (h *Handler) listActiveForwardsByUserTunnel(userID int64, tunnelID int64) ([]forwardRecord, error) {
 	rows, err := h.repo.DB().Query(`
-		SELECT id, user_id, user_name, name, tunnel_id, remote_addr, COALESCE(strategy, 'fifo'), status
+		SELECT `+forwardRecordColumns+`
 		FROM forward
 		WHERE user_id = ? AND tunnel_id = ? AND status = 1
 		ORDER BY id ASC
@@ -279,17 +297,29 @@ func (h *Handler) listActiveForwardsByUserTunnel(userID int64, tunnelID int64) (
 	return scanForwardRecords(rows)
 }
 
+func (h *Handler) listActiveForwardsByTunnel(tunnelID int64) ([]forwardRecord, error) {
+	rows, err := h.repo.DB().Query(`
+		SELECT `+forwardRecordColumns+`
+		FROM forward
+		WHERE tunnel_id = ? AND status = 1
+		ORDER BY id ASC
+	`, tunnelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanForwardRecords(rows)
+}
+
 func scanForwardRecords(rows *sql.Rows) ([]forwardRecord, error) {
 	out := make([]forwardRecord, 0)
 	for rows.Next() {
-		var record forwardRecord
-		if err := rows.Scan(&record.ID, &record.UserID, &record.UserName, &record.Name, &record.TunnelID, &record.RemoteAddr, &record.Strategy, &record.Status); err != nil {
+		record, err := scanForwardRecord(rows)
+		if err != nil {
 			return nil, err
 		}
-		if strings.TrimSpace(record.Strategy) == "" {
-			record.Strategy = "fifo"
-		}
-		out = append(out, record)
+		out = append(out, *record)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
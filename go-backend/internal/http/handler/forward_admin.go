@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+type forwardRepairPortConflictsRequest struct {
+	DryRun bool `json:"dryRun"`
+}
+
+// forwardPortConflict is an entry port claimed by more than one forward on
+// the same node.
+type forwardPortConflict struct {
+	NodeID     int64   `json:"nodeId"`
+	Port       int     `json:"port"`
+	Count      int     `json:"count"`
+	ForwardIDs []int64 `json:"forwardIds"`
+}
+
+type forwardPortRemap struct {
+	ForwardID int64  `json:"forwardId"`
+	NodeID    int64  `json:"nodeId"`
+	OldPort   int    `json:"oldPort"`
+	NewPort   int    `json:"newPort"`
+	Error     string `json:"error,omitempty"`
+}
+
+// adminForwardStatusList lists forwards whose service a node reported as
+// failed to start, e.g. because the port was taken by another process.
+func (h *Handler) adminForwardStatusList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	items, err := h.repo.ListForwardsByStatus(sqlite.ForwardStatusError)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+// adminRepairPortConflicts finds entry ports shared by several forwards on
+// one node. Outside dry run, the forward with the lowest ID keeps the port
+// and the others are moved to free ports and redeployed on that node.
+func (h *Handler) adminRepairPortConflicts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req forwardRepairPortConflictsRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+
+	if req.DryRun {
+		conflicts, err := listForwardPortConflicts(h.repo.DB())
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		response.WriteJSON(w, response.OK(map[string]interface{}{
+			"dryRun":    true,
+			"conflicts": conflicts,
+		}))
+		return
+	}
+
+	conflicts, remaps, err := h.repairForwardPortConflicts()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	for i := range remaps {
+		item := &remaps[i]
+		if item.NewPort <= 0 {
+			continue
+		}
+		detail := fmt.Sprintf("nodeId=%d port=%d->%d", item.NodeID, item.OldPort, item.NewPort)
+		if err := h.redeployForwardOnNode(item.ForwardID, item.NodeID); err != nil {
+			item.Error = err.Error()
+			detail += " redeploy=" + err.Error()
+		}
+		h.writeAuditLog(r, "forward_port_remap", "forward", item.ForwardID, detail)
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"dryRun":    false,
+		"conflicts": conflicts,
+		"remapped":  remaps,
+	}))
+}
+
+// repairForwardPortConflicts reassigns every conflicting forward port except
+// the one owned by the lowest forward ID. Forwards that cannot get a free
+// port are reported with an error and left unchanged.
+func (h *Handler) repairForwardPortConflicts() ([]forwardPortConflict, []forwardPortRemap, error) {
+	tx, err := h.repo.DB().Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	conflicts, err := listForwardPortConflicts(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	remaps := make([]forwardPortRemap, 0)
+	usedByNode := make(map[int64]map[int]struct{})
+	candidatesByNode := make(map[int64][]int)
+	for _, conflict := range conflicts {
+		used, ok := usedByNode[conflict.NodeID]
+		if !ok {
+			used, err = usedNodePorts(tx, conflict.NodeID)
+			if err != nil {
+				return nil, nil, err
+			}
+			usedByNode[conflict.NodeID] = used
+			var portRange string
+			if err := tx.QueryRow(`SELECT port FROM node WHERE id = ?`, conflict.NodeID).Scan(&portRange); err != nil {
+				return nil, nil, err
+			}
+			candidatesByNode[conflict.NodeID] = parsePortRangeSpec(portRange)
+		}
+
+		for _, forwardID := range conflict.ForwardIDs[1:] {
+			item := forwardPortRemap{ForwardID: forwardID, NodeID: conflict.NodeID, OldPort: conflict.Port}
+			for _, p := range candidatesByNode[conflict.NodeID] {
+				if _, taken := used[p]; !taken {
+					item.NewPort = p
+					break
+				}
+			}
+			if item.NewPort <= 0 {
+				item.Error = "节点端口已满，无可用端口"
+				remaps = append(remaps, item)
+				continue
+			}
+			if _, err := tx.Exec(`UPDATE forward_port SET port = ? WHERE forward_id = ? AND node_id = ? AND port = ?`,
+				item.NewPort, forwardID, conflict.NodeID, conflict.Port); err != nil {
+				return nil, nil, err
+			}
+			used[item.NewPort] = struct{}{}
+			remaps = append(remaps, item)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return conflicts, remaps, nil
+}
+
+// redeployForwardOnNode replaces the services of a forward on one node after
+// its entry port there changed.
+func (h *Handler) redeployForwardOnNode(forwardID, nodeID int64) error {
+	if _, err := h.repo.IncrementForwardRevision(forwardID); err != nil {
+		return err
+	}
+	forward, err := h.getForwardRecord(forwardID)
+	if err != nil {
+		return err
+	}
+	h.deleteForwardServicesOnNode(forward, nodeID)
+	configs, err := h.buildForwardNodeServices(forward)
+	if err != nil {
+		return err
+	}
+	for _, cfg := range configs {
+		if cfg.Node.ID != nodeID {
+			continue
+		}
+		if _, err := h.sendNodeCommand(nodeID, "AddService", cfg.Services, true, false); err != nil {
+			return fmt.Errorf("节点 %s 下发失败: %w", cfg.Node.Name, err)
+		}
+	}
+	return nil
+}
+
+func listForwardPortConflicts(db sqlite.Execer) ([]forwardPortConflict, error) {
+	rows, err := db.Query(`
+		SELECT node_id, port, COUNT(*)
+		FROM forward_port
+		GROUP BY node_id, port
+		HAVING COUNT(*) > 1
+		ORDER BY node_id ASC, port ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	conflicts := make([]forwardPortConflict, 0)
+	for rows.Next() {
+		var item forwardPortConflict
+		if err := rows.Scan(&item.NodeID, &item.Port, &item.Count); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		conflicts = append(conflicts, item)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	for i := range conflicts {
+		ids, err := queryForwardIDsOnPort(db, conflicts[i].NodeID, conflicts[i].Port)
+		if err != nil {
+			return nil, err
+		}
+		conflicts[i].ForwardIDs = ids
+	}
+	return conflicts, nil
+}
+
+func queryForwardIDsOnPort(db sqlite.Execer, nodeID int64, port int) ([]int64, error) {
+	rows, err := db.Query(`SELECT forward_id FROM forward_port WHERE node_id = ? AND port = ? ORDER BY forward_id ASC`, nodeID, port)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,345 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+const (
+	forwardBatchMaxConfigKey = "forward_batch_max"
+	defaultForwardBatchMax   = 50
+)
+
+type forwardBatchCreateRequest struct {
+	Forwards []map[string]interface{} `json:"forwards"`
+}
+
+type forwardBatchItemError struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// forwardBatchResult is the outcome of one entry of a batch request.
+type forwardBatchResult struct {
+	Index   int    `json:"index"`
+	ID      int64  `json:"id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	InPort  int    `json:"inPort,omitempty"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// forwardBatchItem is a validated batch entry with its allocated port.
+type forwardBatchItem struct {
+	input      *forwardCreateInput
+	userName   string
+	port       int
+	entryNodes []int64
+}
+
+func (h *Handler) adminForwardBatchCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req forwardBatchCreateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	actorUserID, _, err := userRoleFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	if len(req.Forwards) == 0 {
+		response.WriteJSON(w, response.ErrDefault("转发列表不能为空"))
+		return
+	}
+	if limit := h.forwardBatchMax(); len(req.Forwards) > limit {
+		response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("单次最多创建 %d 条转发", limit)))
+		return
+	}
+
+	items, itemErrs := h.prepareForwardBatch(req.Forwards, actorUserID)
+	if len(itemErrs) > 0 {
+		resp := response.ErrDefault("批量创建转发失败")
+		resp.Data = map[string]interface{}{"errors": itemErrs}
+		response.WriteJSON(w, resp)
+		return
+	}
+
+	forwardIDs, err := h.insertForwardBatch(items)
+	if errors.Is(err, errGroupTunnelQuotaExceeded) {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err := h.dispatchForwardBatch(forwardIDs); err != nil {
+		for _, id := range forwardIDs {
+			_ = h.deleteForwardByID(id)
+		}
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	results := make([]forwardBatchResult, 0, len(items))
+	for i, item := range items {
+		results = append(results, forwardBatchResult{Index: i, ID: forwardIDs[i], Name: item.input.Name, InPort: item.port, Success: true})
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{"ids": forwardIDs, "results": results}))
+}
+
+// forwardBatchDelete deletes up to forwardBatchMax forwards. Each forward's
+// services are removed from its nodes first; the rows of every forward that
+// got that far are then deleted in one transaction. The response reports the
+// outcome per id.
+func (h *Handler) forwardBatchDelete(w http.ResponseWriter, r *http.Request) {
+	ids := idsFromBody(r, w)
+	if ids == nil {
+		return
+	}
+	actorUserID, actorRole, err := userRoleFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	if limit := h.forwardBatchMax(); len(ids) > limit {
+		response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("单次最多删除 %d 条转发", limit)))
+		return
+	}
+
+	results := make([]forwardBatchResult, len(ids))
+	forwards := make([]*forwardRecord, 0, len(ids))
+	deletable := make([]int64, 0, len(ids))
+	for i, id := range ids {
+		results[i] = forwardBatchResult{Index: i, ID: id}
+		forward, err := h.ensureForwardAccessByActor(actorUserID, actorRole, id)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Name = forward.Name
+		if err := h.controlForwardServices(forward, "DeleteService", true); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		forwards = append(forwards, forward)
+		deletable = append(deletable, id)
+	}
+
+	if err := h.repo.DeleteForwards(deletable); err != nil {
+		// The rows are still there: bring their services back.
+		for _, forward := range forwards {
+			if forward.Status == 1 {
+				_ = h.syncForwardServices(forward, "AddService", false)
+			}
+		}
+		for i := range results {
+			if results[i].Error == "" {
+				results[i].Error = err.Error()
+			}
+		}
+	} else {
+		for i := range results {
+			results[i].Success = results[i].Error == ""
+		}
+	}
+
+	s := 0
+	for _, result := range results {
+		if result.Success {
+			s++
+		}
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{"successCount": s, "failCount": len(results) - s, "results": results}))
+}
+
+func (h *Handler) forwardBatchMax() int {
+	cfg, err := h.repo.GetConfigByName(forwardBatchMaxConfigKey)
+	if err != nil || cfg == nil {
+		return defaultForwardBatchMax
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(cfg.Value))
+	if err != nil || n <= 0 {
+		return defaultForwardBatchMax
+	}
+	return n
+}
+
+// prepareForwardBatch validates every entry and allocates its port, keeping
+// track of ports claimed earlier in the same batch. Items default to the
+// calling admin as owner.
+func (h *Handler) prepareForwardBatch(raw []map[string]interface{}, actorUserID int64) ([]forwardBatchItem, []forwardBatchItemError) {
+	items := make([]forwardBatchItem, 0, len(raw))
+	itemErrs := make([]forwardBatchItemError, 0)
+	taken := make(map[int64]map[int]bool)
+	fail := func(index int, req map[string]interface{}, err error) {
+		itemErrs = append(itemErrs, forwardBatchItemError{Index: index, Name: asString(req["name"]), Error: err.Error()})
+	}
+
+	for i, req := range raw {
+		ownerID := asInt64(req["userId"], actorUserID)
+		ownerRole, err := h.forwardBatchOwnerRole(ownerID)
+		if err != nil {
+			fail(i, req, err)
+			continue
+		}
+		in, err := h.parseForwardCreateInput(req, ownerID, ownerRole)
+		if err != nil {
+			fail(i, req, err)
+			continue
+		}
+		entryNodes, err := h.tunnelEntryNodeIDs(in.TunnelID)
+		if err != nil {
+			fail(i, req, err)
+			continue
+		}
+		if len(entryNodes) == 0 {
+			fail(i, req, errors.New("隧道没有入口节点"))
+			continue
+		}
+
+		port := in.InPort
+		if port > 0 {
+			if err := h.checkPortReservation(entryNodes, port); err != nil {
+				fail(i, req, err)
+				continue
+			}
+			if err := h.checkForwardBatchPortFree(entryNodes, port, taken); err != nil {
+				fail(i, req, err)
+				continue
+			}
+		} else {
+			port = h.pickTunnelPortExcluding(in.TunnelID, taken)
+			if port <= 0 {
+				fail(i, req, errors.New("入口节点没有可用端口"))
+				continue
+			}
+		}
+
+		for _, nodeID := range entryNodes {
+			if taken[nodeID] == nil {
+				taken[nodeID] = make(map[int]bool)
+			}
+			taken[nodeID][port] = true
+		}
+		items = append(items, forwardBatchItem{
+			input:      in,
+			userName:   h.forwardOwnerName(ownerID),
+			port:       port,
+			entryNodes: entryNodes,
+		})
+	}
+	return items, itemErrs
+}
+
+func (h *Handler) forwardBatchOwnerRole(userID int64) (int, error) {
+	var roleID int
+	err := h.repo.DB().QueryRow(`SELECT role_id FROM user WHERE id = ?`, userID).Scan(&roleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("用户不存在")
+	}
+	if err != nil {
+		return 0, err
+	}
+	return roleID, nil
+}
+
+func (h *Handler) checkForwardBatchPortFree(nodeIDs []int64, port int, taken map[int64]map[int]bool) error {
+	for _, nodeID := range nodeIDs {
+		if taken[nodeID][port] {
+			return fmt.Errorf("端口 %d 在本批次中重复", port)
+		}
+		used, err := h.getUsedPorts(nodeID)
+		if err != nil {
+			return err
+		}
+		if used[port] {
+			return fmt.Errorf("端口 %d 已被占用", port)
+		}
+	}
+	return nil
+}
+
+// insertForwardBatch writes all forwards and their entry ports in one
+// transaction so a failed insert leaves no partial batch behind. The batch is
+// rejected as a whole when it would take a group past its tunnel quota.
+func (h *Handler) insertForwardBatch(items []forwardBatchItem) ([]int64, error) {
+	if err := h.checkForwardBatchQuota(items); err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	inx := nextIndex(h.repo.DB(), "forward")
+	tx, err := h.repo.DB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	ids := make([]int64, 0, len(items))
+	for i, item := range items {
+		forwardID, err := insertForwardTx(tx, item.input, item.userName, inx+i, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, nodeID := range item.entryNodes {
+			if _, err := tx.Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, ?)`, forwardID, nodeID, item.port); err != nil {
+				return nil, err
+			}
+		}
+		ids = append(ids, forwardID)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// dispatchForwardBatch sends one AddService per entry node carrying the
+// services of every new forward on that node. On failure the services that
+// may already be running are removed again.
+func (h *Handler) dispatchForwardBatch(forwardIDs []int64) error {
+	forwards := make([]*forwardRecord, 0, len(forwardIDs))
+	nodeOrder := make([]int64, 0)
+	nodeNames := make(map[int64]string)
+	servicesByNode := make(map[int64][]map[string]interface{})
+	for _, id := range forwardIDs {
+		forward, err := h.getForwardRecord(id)
+		if err != nil {
+			return err
+		}
+		forwards = append(forwards, forward)
+		configs, err := h.buildForwardNodeServices(forward)
+		if err != nil {
+			return err
+		}
+		for _, cfg := range configs {
+			if _, ok := servicesByNode[cfg.Node.ID]; !ok {
+				nodeOrder = append(nodeOrder, cfg.Node.ID)
+				nodeNames[cfg.Node.ID] = cfg.Node.Name
+			}
+			servicesByNode[cfg.Node.ID] = append(servicesByNode[cfg.Node.ID], cfg.Services...)
+		}
+	}
+
+	for _, nodeID := range nodeOrder {
+		if _, err := h.sendNodeCommand(nodeID, "AddService", servicesByNode[nodeID], true, false); err != nil {
+			for _, forward := range forwards {
+				_ = h.controlForwardServices(forward, "DeleteService", true)
+			}
+			return fmt.Errorf("节点 %s 下发失败: %w", nodeNames[nodeID], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+var errGroupTunnelQuotaExceeded = errors.New("group tunnel quota exceeded")
+
+type groupTunnelQuotaRequest struct {
+	GroupID     int64 `json:"groupId"`
+	TunnelID    int64 `json:"tunnelId"`
+	MaxForwards int   `json:"maxForwards"`
+}
+
+// adminGroupTunnelQuotaSet caps how many forwards a user group may have on
+// a tunnel. maxForwards 0 removes the cap.
+func (h *Handler) adminGroupTunnelQuotaSet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req groupTunnelQuotaRequest
+	if err := decodeJSON(r.Body, &req); err != nil || req.GroupID <= 0 || req.TunnelID <= 0 || req.MaxForwards < 0 {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if err := h.repo.SetGroupTunnelQuota(req.GroupID, req.TunnelID, req.MaxForwards, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.writeAuditLog(r, "group_tunnel_quota_set", "user_group", req.GroupID,
+		fmt.Sprintf("tunnel %d max forwards %d", req.TunnelID, req.MaxForwards))
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// checkGroupTunnelQuota rejects a new forward on tunnelID when the user's
+// group already holds its quota of forwards there. Moving a forward onto the
+// tunnel counts as a new forward.
+func (h *Handler) checkGroupTunnelQuota(userID, tunnelID int64) error {
+	groupID, err := h.repo.GetUserGroupID(userID)
+	if err != nil {
+		return err
+	}
+	return h.checkGroupTunnelQuotaAdd(groupID, tunnelID, 1)
+}
+
+// checkForwardBatchQuota is checkGroupTunnelQuota for a whole batch, so
+// forwards earlier in the batch count against the quota of later ones.
+func (h *Handler) checkForwardBatchQuota(items []forwardBatchItem) error {
+	type groupTunnel struct{ groupID, tunnelID int64 }
+	added := make(map[groupTunnel]int)
+	order := make([]groupTunnel, 0)
+	for _, item := range items {
+		groupID, err := h.repo.GetUserGroupID(item.input.UserID)
+		if err != nil {
+			return err
+		}
+		key := groupTunnel{groupID: groupID, tunnelID: item.input.TunnelID}
+		if _, ok := added[key]; !ok {
+			order = append(order, key)
+		}
+		added[key]++
+	}
+	for _, key := range order {
+		if err := h.checkGroupTunnelQuotaAdd(key.groupID, key.tunnelID, added[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) checkGroupTunnelQuotaAdd(groupID, tunnelID int64, n int) error {
+	if groupID <= 0 {
+		return nil
+	}
+	current, max, err := h.repo.CheckGroupForwardQuota(groupID, tunnelID)
+	if err != nil {
+		return err
+	}
+	if max > 0 && current+n > max {
+		return errGroupTunnelQuotaExceeded
+	}
+	return nil
+}
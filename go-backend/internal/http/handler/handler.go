@@ -4,10 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -15,9 +16,14 @@ import (
 	"time"
 
 	"go-backend/internal/auth"
+	"go-backend/internal/captcha"
 	"go-backend/internal/http/middleware"
 	"go-backend/internal/http/response"
+	"go-backend/internal/metrics"
+	"go-backend/internal/pki"
+	"go-backend/internal/secrets"
 	"go-backend/internal/security"
+	"go-backend/internal/store"
 	"go-backend/internal/store/sqlite"
 	"go-backend/internal/ws"
 )
@@ -25,10 +31,39 @@ import (
 type Handler struct {
 	repo      *sqlite.Repository
 	jwtSecret string
+	keys      *auth.Keyring
 	wsServer  *ws.Server
 
+	tunnelMetrics  *tunnelMetrics
+	dashboardCache *userDashboardCache
+	events         *eventBus
+	warnedTunnels  *warnedTunnels
+	nodeSelector   nodeSelector
+	influx         *metrics.InfluxExporter
+	oidcStates     *oidcStates
+	passkeys       *passkeyCeremonies
+	flowNonces     *seenNonces
+	peerNonces     *seenNonces
+	resetThrottle  *userThrottle
+	// loginFailNotices limits failed-login notifications to one per user
+	// and loginFailedNoticeInterval.
+	loginFailNotices *userThrottle
+	// secretRotations exempts the secrets of node secret rotations in flight
+	// from brute-force tracking.
+	secretRotations *secretRotations
+
+	caMu sync.Mutex
+	ca   *pki.CA
+
 	captchaMu     sync.Mutex
 	captchaTokens map[string]int64
+	imageCaptcha  *captcha.Image
+
+	bans         *middleware.IPBans
+	uploadLimits *middleware.NodeUploadLimits
+
+	// secrets supplies the SMTP credentials ahead of the config table.
+	secrets secrets.Provider
 
 	jobsMu      sync.Mutex
 	jobsCancel  context.CancelFunc
@@ -40,6 +75,9 @@ type loginRequest struct {
 	Username  string `json:"username"`
 	Password  string `json:"password"`
 	CaptchaID string `json:"captchaId"`
+	// TotpCode is the second step for accounts with TOTP enabled: the
+	// current code or one of the recovery codes.
+	TotpCode string `json:"totpCode"`
 }
 
 type captchaVerifyRequest struct {
@@ -61,127 +99,291 @@ type changePasswordRequest struct {
 	CurrentPassword string `json:"currentPassword"`
 	NewPassword     string `json:"newPassword"`
 	ConfirmPassword string `json:"confirmPassword"`
+	TotpCode        string `json:"totpCode"`
 }
 
 type flowItem struct {
 	N string `json:"n"`
 	U int64  `json:"u"`
 	D int64  `json:"d"`
+	// O and C count connections opened and closed since the previous report.
+	O int64 `json:"o,omitempty"`
+	C int64 `json:"c,omitempty"`
 }
 
 func New(repo *sqlite.Repository, jwtSecret string) *Handler {
-	return &Handler{
-		repo:          repo,
-		jwtSecret:     jwtSecret,
-		wsServer:      ws.NewServer(repo, jwtSecret),
-		captchaTokens: make(map[string]int64),
-	}
+	keys := auth.NewKeyring(jwtSecret)
+	h := &Handler{
+		repo:             repo,
+		jwtSecret:        jwtSecret,
+		keys:             keys,
+		wsServer:         ws.NewServer(repo, keys),
+		tunnelMetrics:    newTunnelMetrics(),
+		dashboardCache:   &userDashboardCache{},
+		events:           newEventBus(),
+		warnedTunnels:    newWarnedTunnels(),
+		nodeSelector:     loadNodeSelector(repo),
+		influx:           metrics.NewInfluxExporter(repo),
+		oidcStates:       newOIDCStates(),
+		passkeys:         newPasskeyCeremonies(),
+		flowNonces:       newSeenNonces(security.MaxNodeMessageSkew * 2),
+		peerNonces:       newSeenNonces(peerSignatureMaxSkew * 2),
+		resetThrottle:    newUserThrottle(passwordResetInterval),
+		loginFailNotices: newUserThrottle(loginFailedNoticeInterval),
+		secretRotations:  newSecretRotations(),
+		captchaTokens:    make(map[string]int64),
+		imageCaptcha:     captcha.NewImage(),
+		bans:             middleware.NewIPBans(repo),
+		uploadLimits:     middleware.NewNodeUploadLimits(defaultNodeUploadRateLimitPerMin, repo),
+	}
+	if err := h.loadSigningKeys(); err != nil {
+		log.Printf("load jwt signing keys: %v", err)
+	}
+	h.wsServer.SetNodeConnectedHook(h.redispatchNodeServices)
+	h.wsServer.SetAuthFailureHook(h.recordNodeAuthFailure)
+	h.wsServer.SetTokenValidator(middleware.TokenValidator(keys, repo))
+	return h
+}
+
+// SetSecrets sets the provider SMTP credentials are read from first.
+func (h *Handler) SetSecrets(p secrets.Provider) {
+	h.secrets = p
 }
 
 func (h *Handler) WebSocketHandler() http.Handler {
 	return h.wsServer
 }
 
+// IPBans exposes the brute-force ban list to router-level middleware.
+func (h *Handler) IPBans() *middleware.IPBans {
+	return h.bans
+}
+
+// Repo exposes the repository to router-level middleware.
+func (h *Handler) Repo() *sqlite.Repository {
+	return h.repo
+}
+
 func (h *Handler) Register(mux *http.ServeMux) {
-	mux.HandleFunc("/api/v1/user/login", h.login)
-	mux.HandleFunc("/api/v1/user/list", h.userList)
-	mux.HandleFunc("/api/v1/user/create", h.userCreate)
-	mux.HandleFunc("/api/v1/user/update", h.userUpdate)
-	mux.HandleFunc("/api/v1/user/delete", h.userDelete)
-	mux.HandleFunc("/api/v1/user/reset", h.userResetFlow)
-	mux.HandleFunc("/api/v1/config/get", h.getConfigByName)
-	mux.HandleFunc("/api/v1/config/list", h.getConfigs)
-	mux.HandleFunc("/api/v1/config/update", h.updateConfigs)
-	mux.HandleFunc("/api/v1/config/update-single", h.updateSingleConfig)
-	mux.HandleFunc("/api/v1/backup/export", h.backupExport)
-	mux.HandleFunc("/api/v1/backup/import", h.backupImport)
-	mux.HandleFunc("/api/v1/backup/restore", h.backupImport)
-	mux.HandleFunc("/api/v1/api/v1/backup/export", h.backupExport)
-	mux.HandleFunc("/api/v1/api/v1/backup/import", h.backupImport)
-	mux.HandleFunc("/api/v1/api/v1/backup/restore", h.backupImport)
-	mux.HandleFunc("/api/v1/captcha/check", h.checkCaptcha)
-	mux.HandleFunc("/api/v1/captcha/verify", h.captchaVerify)
-	mux.HandleFunc("/api/v1/user/package", h.userPackage)
-	mux.HandleFunc("/api/v1/user/updatePassword", h.updatePassword)
-	mux.HandleFunc("/api/v1/node/list", h.nodeList)
-	mux.HandleFunc("/api/v1/node/create", h.nodeCreate)
-	mux.HandleFunc("/api/v1/node/update", h.nodeUpdate)
-	mux.HandleFunc("/api/v1/node/delete", h.nodeDelete)
-	mux.HandleFunc("/api/v1/node/install", h.nodeInstall)
-	mux.HandleFunc("/api/v1/node/update-order", h.nodeUpdateOrder)
-	mux.HandleFunc("/api/v1/node/batch-delete", h.nodeBatchDelete)
-	mux.HandleFunc("/api/v1/node/check-status", h.nodeCheckStatus)
-	mux.HandleFunc("/api/v1/node/upgrade", h.nodeUpgrade)
-	mux.HandleFunc("/api/v1/node/batch-upgrade", h.nodeBatchUpgrade)
-	mux.HandleFunc("/api/v1/node/rollback", h.nodeRollback)
-	mux.HandleFunc("/api/v1/node/releases", h.listReleases)
-	mux.HandleFunc("/api/v1/tunnel/list", h.tunnelList)
-	mux.HandleFunc("/api/v1/tunnel/create", h.tunnelCreate)
-	mux.HandleFunc("/api/v1/tunnel/get", h.tunnelGet)
-	mux.HandleFunc("/api/v1/tunnel/update", h.tunnelUpdate)
-	mux.HandleFunc("/api/v1/tunnel/delete", h.tunnelDelete)
-	mux.HandleFunc("/api/v1/tunnel/diagnose", h.tunnelDiagnose)
-	mux.HandleFunc("/api/v1/tunnel/update-order", h.tunnelUpdateOrder)
-	mux.HandleFunc("/api/v1/tunnel/batch-delete", h.tunnelBatchDelete)
-	mux.HandleFunc("/api/v1/tunnel/batch-redeploy", h.tunnelBatchRedeploy)
-	mux.HandleFunc("/api/v1/tunnel/user/assign", h.userTunnelAssign)
-	mux.HandleFunc("/api/v1/tunnel/user/batch-assign", h.userTunnelBatchAssign)
-	mux.HandleFunc("/api/v1/tunnel/user/remove", h.userTunnelRemove)
-	mux.HandleFunc("/api/v1/tunnel/user/update", h.userTunnelUpdate)
-	mux.HandleFunc("/api/v1/forward/list", h.forwardList)
-	mux.HandleFunc("/api/v1/forward/create", h.forwardCreate)
-	mux.HandleFunc("/api/v1/forward/update", h.forwardUpdate)
-	mux.HandleFunc("/api/v1/forward/delete", h.forwardDelete)
-	mux.HandleFunc("/api/v1/forward/force-delete", h.forwardForceDelete)
-	mux.HandleFunc("/api/v1/forward/pause", h.forwardPause)
-	mux.HandleFunc("/api/v1/forward/resume", h.forwardResume)
-	mux.HandleFunc("/api/v1/forward/diagnose", h.forwardDiagnose)
-	mux.HandleFunc("/api/v1/forward/update-order", h.forwardUpdateOrder)
-	mux.HandleFunc("/api/v1/forward/batch-delete", h.forwardBatchDelete)
-	mux.HandleFunc("/api/v1/forward/batch-pause", h.forwardBatchPause)
-	mux.HandleFunc("/api/v1/forward/batch-resume", h.forwardBatchResume)
-	mux.HandleFunc("/api/v1/forward/batch-redeploy", h.forwardBatchRedeploy)
-	mux.HandleFunc("/api/v1/forward/batch-change-tunnel", h.forwardBatchChangeTunnel)
-	mux.HandleFunc("/api/v1/speed-limit/list", h.speedLimitList)
-	mux.HandleFunc("/api/v1/speed-limit/create", h.speedLimitCreate)
-	mux.HandleFunc("/api/v1/speed-limit/update", h.speedLimitUpdate)
-	mux.HandleFunc("/api/v1/speed-limit/delete", h.speedLimitDelete)
-	mux.HandleFunc("/api/v1/speed-limit/tunnels", h.tunnelList)
-	mux.HandleFunc("/api/v1/tunnel/user/tunnel", h.userTunnelVisibleList)
-	mux.HandleFunc("/api/v1/tunnel/user/list", h.userTunnelList)
-	mux.HandleFunc("/api/v1/group/tunnel/list", h.tunnelGroupList)
-	mux.HandleFunc("/api/v1/group/tunnel/create", h.groupTunnelCreate)
-	mux.HandleFunc("/api/v1/group/tunnel/update", h.groupTunnelUpdate)
-	mux.HandleFunc("/api/v1/group/tunnel/delete", h.groupTunnelDelete)
-	mux.HandleFunc("/api/v1/group/tunnel/assign", h.groupTunnelAssign)
-	mux.HandleFunc("/api/v1/group/user/list", h.userGroupList)
-	mux.HandleFunc("/api/v1/group/user/create", h.groupUserCreate)
-	mux.HandleFunc("/api/v1/group/user/update", h.groupUserUpdate)
-	mux.HandleFunc("/api/v1/group/user/delete", h.groupUserDelete)
-	mux.HandleFunc("/api/v1/group/user/assign", h.groupUserAssign)
-	mux.HandleFunc("/api/v1/group/permission/list", h.groupPermissionList)
-	mux.HandleFunc("/api/v1/group/permission/assign", h.groupPermissionAssign)
-	mux.HandleFunc("/api/v1/group/permission/remove", h.groupPermissionRemove)
-	mux.HandleFunc("/api/v1/open_api/sub_store", h.openAPISubStore)
-	mux.HandleFunc("/api/v1/federation/share/list", h.federationShareList)
-	mux.HandleFunc("/api/v1/federation/share/create", h.federationShareCreate)
-	mux.HandleFunc("/api/v1/federation/share/update", h.federationShareUpdate)
-	mux.HandleFunc("/api/v1/federation/share/delete", h.federationShareDelete)
-	mux.HandleFunc("/api/v1/federation/share/reset-flow", h.federationShareResetFlow)
-	mux.HandleFunc("/api/v1/federation/share/remote-usage/list", h.federationRemoteUsageList)
-	mux.HandleFunc("/api/v1/federation/connect", h.authPeer(h.federationConnect))
-	mux.HandleFunc("/api/v1/federation/tunnel/create", h.authPeer(h.federationTunnelCreate))
-	mux.HandleFunc("/api/v1/federation/runtime/reserve-port", h.authPeer(h.federationRuntimeReservePort))
-	mux.HandleFunc("/api/v1/federation/runtime/apply-role", h.authPeer(h.federationRuntimeApplyRole))
-	mux.HandleFunc("/api/v1/federation/runtime/release-role", h.authPeer(h.federationRuntimeReleaseRole))
-	mux.HandleFunc("/api/v1/federation/runtime/diagnose", h.authPeer(h.federationRuntimeDiagnose))
-	mux.HandleFunc("/api/v1/federation/runtime/command", h.authPeer(h.federationRuntimeCommand))
-	mux.HandleFunc("/api/v1/federation/node/import", h.nodeImport)
-
-	mux.HandleFunc("/flow/test", h.flowTest)
-	mux.HandleFunc("/flow/config", h.flowConfig)
-	mux.HandleFunc("/flow/upload", h.flowUpload)
-	mux.HandleFunc("/error", h.errorPage)
+	requireJWT := middleware.RequireJWTWithKeyring(h.keys, h.repo)
+
+	root := NewRouteGroup(mux, "")
+	public := root.Group("/api/v1")
+	api := root.Group("/api/v1", requireJWT, middleware.ResponseFieldCase(h.repo))
+	adminIP := middleware.AdminIPAllowlist(h.repo)
+	admin := api.Group("", adminIP, middleware.RequireAdmin)
+	account := api.Group("", middleware.RejectImpersonation)
+	adminAPI := api.Group("/admin", adminIP, middleware.RequireAdmin)
+	can := func(action auth.Action) func(http.Handler) http.Handler {
+		requireAction := middleware.RequireAction(h.repo, action)
+		return func(next http.Handler) http.Handler {
+			return adminIP(requireAction(next))
+		}
+	}
+	userReaders := api.Group("", can(auth.ActionUserRead))
+	users := api.Group("", can(auth.ActionUserWrite))
+	nodeReaders := api.Group("", can(auth.ActionNodeRead))
+	nodeReadersAPI := nodeReaders.Group("/admin")
+	nodes := api.Group("", can(auth.ActionNodeWrite))
+	nodesAPI := nodes.Group("/admin")
+	tunnelReaders := api.Group("", can(auth.ActionTunnelRead))
+	tunnelReadersAPI := tunnelReaders.Group("/admin")
+	tunnels := api.Group("", can(auth.ActionTunnelWrite))
+	tunnelsAPI := tunnels.Group("/admin")
+	configs := api.Group("", can(auth.ActionConfigWrite))
+	groups := api.Group("", can(auth.ActionGroupManage))
+	groupsAPI := groups.Group("/admin")
+	federation := api.Group("", can(auth.ActionFederationManage))
+	federationAPI := federation.Group("/admin")
+	logsAPI := api.Group("/admin", can(auth.ActionLogRead))
+
+	public.HandleFunc("/user/login", h.login)
+	public.HandleFunc("/user/refresh", h.userRefresh)
+	public.HandleFunc("/user/password-reset/request", h.userPasswordResetRequest)
+	public.HandleFunc("/user/password-reset/confirm", h.userPasswordResetConfirm)
+	public.HandleFunc("/user/oidc/login", h.oidcLogin)
+	public.HandleFunc("/user/oidc/callback", h.oidcCallback)
+	public.HandleFunc("/user/passkey/login/begin", h.passkeyLoginBegin)
+	public.HandleFunc("/user/passkey/login/finish", h.passkeyLoginFinish)
+	public.HandleFunc("/config/get", h.getConfigByName)
+	public.HandleFunc("/captcha/check", h.checkCaptcha)
+	public.HandleFunc("/captcha/verify", h.captchaVerify)
+	public.HandleFunc("/captcha/generate", h.captchaGenerate)
+	public.HandleFunc("/open_api/sub_store", h.openAPISubStore)
+	public.HandleFunc("/federation/share/deactivated", h.federationShareDeactivated)
+	public.HandleFunc("/federation/connect", h.authPeer(h.federationConnect))
+	public.HandleFunc("/federation/share/status", h.authPeer(h.federationShareStatus))
+	public.HandleFunc("/federation/tunnel/create", h.authPeer(h.federationTunnelCreate))
+	public.HandleFunc("/federation/runtime/reserve-port", h.authPeer(h.federationRuntimeReservePort))
+	public.HandleFunc("/federation/runtime/apply-role", h.authPeer(h.federationRuntimeApplyRole))
+	public.HandleFunc("/federation/runtime/release-role", h.authPeer(h.federationRuntimeReleaseRole))
+	public.HandleFunc("/federation/runtime/diagnose", h.authPeer(h.federationRuntimeDiagnose))
+	public.HandleFunc("/federation/runtime/command", h.authPeer(h.federationRuntimeCommand))
+
+	api.HandleFunc("/config/list", h.getConfigs)
+	api.HandleFunc("/user/package", h.userPackage)
+	api.HandleFunc("/user/dashboard", h.userDashboard)
+	account.HandleFunc("/user/updatePassword", h.updatePassword)
+	api.HandleFunc("/user/logout", h.userLogout)
+	account.HandleFunc("/user/logout-all", h.userLogoutAll)
+	api.HandleFunc("/user/session/list", h.userSessionList)
+	account.HandleFunc("/user/session/revoke", h.userSessionRevoke)
+	account.HandleFunc("/user/email/update", h.userEmailUpdate)
+	api.HandleFunc("/user/totp/status", h.userTOTPStatus)
+	api.HandleFunc("/user/email/get", h.userEmailGet)
+	account.HandleFunc("/user/totp/setup", h.userTOTPSetup)
+	account.HandleFunc("/user/totp/confirm", h.userTOTPConfirm)
+	account.HandleFunc("/user/totp/disable", h.userTOTPDisable)
+	account.HandleFunc("/user/apikey/create", h.userAPIKeyCreate)
+	api.HandleFunc("/user/apikey/list", h.userAPIKeyList)
+	account.HandleFunc("/user/apikey/revoke", h.userAPIKeyRevoke)
+	account.HandleFunc("/user/passkey/register/begin", h.passkeyRegisterBegin)
+	account.HandleFunc("/user/passkey/register/finish", h.passkeyRegisterFinish)
+	api.HandleFunc("/user/passkey/list", h.passkeyList)
+	account.HandleFunc("/user/passkey/delete", h.passkeyDelete)
+	api.HandleFunc("/user/events", h.userEvents)
+	api.HandleFunc("/user/notification-pref/get", h.userNotificationPrefGet)
+	api.HandleFunc("/user/notification-pref/update", h.userNotificationPrefUpdate)
+	api.Handle("/forward/list", middleware.ConditionalGet(http.HandlerFunc(h.forwardList)))
+	api.HandleFunc("/forward/create", h.forwardCreate)
+	api.HandleFunc("/forward/update", h.forwardUpdate)
+	api.HandleFunc("/forward/delete", h.forwardDelete)
+	api.HandleFunc("/forward/force-delete", h.forwardForceDelete)
+	api.HandleFunc("/forward/pause", h.forwardPause)
+	api.HandleFunc("/forward/resume", h.forwardResume)
+	api.HandleFunc("/forward/diagnose", h.forwardDiagnose)
+	api.HandleFunc("/forward/update-order", h.forwardUpdateOrder)
+	api.HandleFunc("/forward/batch-delete", h.forwardBatchDelete)
+	api.HandleFunc("/forward/batch-pause", h.forwardBatchPause)
+	api.HandleFunc("/forward/batch-resume", h.forwardBatchResume)
+	api.HandleFunc("/forward/batch-redeploy", h.forwardBatchRedeploy)
+	api.HandleFunc("/forward/batch-change-tunnel", h.forwardBatchChangeTunnel)
+	api.HandleFunc("/tunnel/user/tunnel", h.userTunnelVisibleList)
+
+	userReaders.Handle("/user/list", middleware.ConditionalGet(http.HandlerFunc(h.userList)))
+	users.HandleFunc("/user/create", h.userCreate)
+	users.HandleFunc("/user/update", h.userUpdate)
+	users.HandleFunc("/user/delete", h.userDelete)
+	users.HandleFunc("/user/reset", h.userResetFlow)
+	users.HandleFunc("/user/reset-password", h.userResetPassword)
+	users.HandleFunc("/user/toggle-status", h.userToggleStatus)
+	users.HandleFunc("/user/import", h.userImport)
+	configs.HandleFunc("/config/list-by-prefix", h.getConfigsByPrefix)
+	configs.HandleFunc("/config/update", h.updateConfigs)
+	configs.HandleFunc("/config/update-single", h.updateSingleConfig)
+	admin.HandleFunc("/forward/batch-create", h.adminForwardBatchCreate)
+	admin.HandleFunc("/backup/export", h.backupExport)
+	admin.HandleFunc("/backup/import", h.backupImport)
+	admin.HandleFunc("/backup/restore", h.backupImport)
+	admin.HandleFunc("/api/v1/backup/export", h.backupExport)
+	admin.HandleFunc("/api/v1/backup/import", h.backupImport)
+	admin.HandleFunc("/api/v1/backup/restore", h.backupImport)
+	admin.HandleFunc("/system/backup", h.systemBackup)
+	nodeReaders.Handle("/node/list", middleware.ConditionalGet(http.HandlerFunc(h.nodeList)))
+	nodes.HandleFunc("/node/create", h.nodeCreate)
+	nodes.HandleFunc("/node/update", h.nodeUpdate)
+	nodes.HandleFunc("/node/delete", h.nodeDelete)
+	nodes.HandleFunc("/node/install", h.nodeInstall)
+	nodes.HandleFunc("/node/update-order", h.nodeUpdateOrder)
+	nodes.HandleFunc("/node/batch-delete", h.nodeBatchDelete)
+	nodes.HandleFunc("/node/check-status", h.nodeCheckStatus)
+	nodes.HandleFunc("/node/upgrade", h.nodeUpgrade)
+	nodes.HandleFunc("/node/batch-upgrade", h.nodeBatchUpgrade)
+	nodes.HandleFunc("/node/rollback", h.nodeRollback)
+	nodes.HandleFunc("/node/rotate-secret", h.nodeRotateSecret)
+	nodeReaders.HandleFunc("/node/releases", h.listReleases)
+	nodes.HandleFunc("/node/cert/issue", h.nodeCertIssue)
+	nodes.HandleFunc("/node/cert/renew", h.nodeCertRenew)
+	tunnelReaders.Handle("/tunnel/list", middleware.ConditionalGet(http.HandlerFunc(h.tunnelList)))
+	tunnels.HandleFunc("/tunnel/create", h.tunnelCreate)
+	tunnelReaders.HandleFunc("/tunnel/get", h.tunnelGet)
+	tunnels.HandleFunc("/tunnel/update", h.tunnelUpdate)
+	tunnels.HandleFunc("/tunnel/delete", h.tunnelDelete)
+	tunnels.HandleFunc("/tunnel/diagnose", h.tunnelDiagnose)
+	tunnels.HandleFunc("/tunnel/update-order", h.tunnelUpdateOrder)
+	tunnels.HandleFunc("/tunnel/batch-delete", h.tunnelBatchDelete)
+	tunnels.HandleFunc("/tunnel/batch-redeploy", h.tunnelBatchRedeploy)
+	tunnels.HandleFunc("/tunnel/user/assign", h.userTunnelAssign)
+	tunnels.HandleFunc("/tunnel/user/batch-assign", h.userTunnelBatchAssign)
+	tunnels.HandleFunc("/tunnel/user/remove", h.userTunnelRemove)
+	tunnels.HandleFunc("/tunnel/user/update", h.userTunnelUpdate)
+	tunnelReaders.HandleFunc("/speed-limit/list", h.speedLimitList)
+	tunnels.HandleFunc("/speed-limit/create", h.speedLimitCreate)
+	tunnels.HandleFunc("/speed-limit/update", h.speedLimitUpdate)
+	tunnels.HandleFunc("/speed-limit/delete", h.speedLimitDelete)
+	tunnelReaders.HandleFunc("/speed-limit/tunnels", h.tunnelList)
+	tunnelReaders.HandleFunc("/tunnel/user/list", h.userTunnelList)
+	admin.HandleFunc("/user/impersonate", h.userImpersonate)
+	admin.HandleFunc("/security/ban/list", h.securityBanList)
+	admin.HandleFunc("/security/ban/remove", h.securityBanRemove)
+	admin.HandleFunc("/role/list", h.roleList)
+	admin.HandleFunc("/role/actions", h.roleActions)
+	admin.HandleFunc("/role/create", h.roleCreate)
+	admin.HandleFunc("/role/update", h.roleUpdate)
+	admin.HandleFunc("/role/delete", h.roleDelete)
+	admin.HandleFunc("/role/assign", h.roleAssign)
+	groups.HandleFunc("/group/tunnel/list", h.tunnelGroupList)
+	groups.HandleFunc("/group/tunnel/create", h.groupTunnelCreate)
+	groups.HandleFunc("/group/tunnel/update", h.groupTunnelUpdate)
+	groups.HandleFunc("/group/tunnel/delete", h.groupTunnelDelete)
+	groups.HandleFunc("/group/tunnel/assign", h.groupTunnelAssign)
+	groups.HandleFunc("/group/user/list", h.userGroupList)
+	groups.HandleFunc("/group/user/create", h.groupUserCreate)
+	groups.HandleFunc("/group/user/update", h.groupUserUpdate)
+	groups.HandleFunc("/group/user/delete", h.groupUserDelete)
+	groups.HandleFunc("/group/user/assign", h.groupUserAssign)
+	groups.HandleFunc("/group/permission/list", h.groupPermissionList)
+	groups.HandleFunc("/group/permission/assign", h.groupPermissionAssign)
+	groups.HandleFunc("/group/permission/remove", h.groupPermissionRemove)
+	federation.HandleFunc("/federation/share/list", h.federationShareList)
+	federation.HandleFunc("/federation/share/create", h.federationShareCreate)
+	federation.HandleFunc("/federation/share/update", h.federationShareUpdate)
+	federation.HandleFunc("/federation/share/delete", h.federationShareDelete)
+	federation.HandleFunc("/federation/share/reset-flow", h.federationShareResetFlow)
+	federation.HandleFunc("/federation/share/remote-usage/list", h.federationRemoteUsageList)
+	federation.HandleFunc("/federation/node/import", h.nodeImport)
+	federation.HandleFunc("/federation/node/preview", h.federationSharePreview)
+
+	adminAPI.HandleFunc("/search", h.adminSearchAll)
+	adminAPI.HandleFunc("/user/permissions", h.adminUserSetPermissions)
+	adminAPI.HandleFunc("/maintenance/run", h.adminRunMaintenance)
+	adminAPI.HandleFunc("/db/backup", h.adminDBBackup)
+	adminAPI.HandleFunc("/jwt/rotate", h.adminJWTRotate)
+	nodesAPI.HandleFunc("/node/migrate-forwards", h.adminNodeMigrateForwards)
+	nodeReadersAPI.HandleFunc("/node/connection-history", h.adminNodeConnectionHistory)
+	nodeReadersAPI.HandleFunc("/ws/sessions", h.adminWSSessions)
+	nodesAPI.HandleFunc("/node/disconnect", h.adminNodeDisconnect)
+	nodeReadersAPI.HandleFunc("/node/online-list", h.adminNodeOnlineList)
+	tunnelReadersAPI.HandleFunc("/tunnel/metrics", h.adminTunnelMetrics)
+	tunnelReadersAPI.HandleFunc("/tunnel/user-assignments", h.adminTunnelUserAssignments)
+	tunnelsAPI.HandleFunc("/tunnel/bulk-extend", h.adminTunnelBulkExtend)
+	tunnelReadersAPI.HandleFunc("/tunnel/hop-stats", h.adminTunnelHopStats)
+	nodesAPI.HandleFunc("/node/generate-secret", h.adminNodeGenerateSecret)
+	nodesAPI.HandleFunc("/node/rotate-secret", h.adminNodeRotateSecret)
+	nodesAPI.HandleFunc("/node/expand-port-range", h.adminNodeExpandPortRange)
+	nodeReadersAPI.HandleFunc("/node/latency-matrix", h.adminNodeLatencyMatrix)
+	nodesAPI.HandleFunc("/node/test", h.adminNodeConnectivityTest)
+	tunnelReadersAPI.HandleFunc("/tunnel/optimal-path", h.adminTunnelOptimalPath)
+	nodeReadersAPI.HandleFunc("/node/reserved-ports/list", h.adminReservedPortList)
+	nodesAPI.HandleFunc("/node/reserved-ports/create", h.adminReservedPortCreate)
+	nodesAPI.HandleFunc("/node/reserved-ports/delete", h.adminReservedPortDelete)
+	adminAPI.HandleFunc("/export/user-flow", h.adminExportUserFlow)
+	adminAPI.HandleFunc("/forward/status-list", h.adminForwardStatusList)
+	adminAPI.HandleFunc("/forward/repair-port-conflicts", h.adminRepairPortConflicts)
+	adminAPI.HandleFunc("/forward/batch-create", h.adminForwardBatchCreate)
+	groupsAPI.HandleFunc("/group/tunnel-quota", h.adminGroupTunnelQuotaSet)
+	federationAPI.HandleFunc("/federation/share/export", h.adminPeerShareExport)
+	federationAPI.HandleFunc("/federation/share/import", h.adminPeerShareImport)
+	federationAPI.HandleFunc("/federation/runtime/create", h.adminPeerShareRuntimeCreate)
+	federationAPI.HandleFunc("/federation/runtime/delete", h.adminPeerShareRuntimeDelete)
+	federationAPI.HandleFunc("/federation/runtime/list", h.adminPeerShareRuntimeList)
+	logsAPI.HandleFunc("/audit-log/list", h.auditLogList)
+
+	root.HandleFunc("/flow/test", h.flowTest)
+	root.HandleFunc("/flow/config", h.flowConfig)
+	root.HandleFunc("/flow/cert/renew", h.flowCertRenew)
+	root.Handle("/flow/upload", middleware.NodeUploadLimit(h.uploadLimits)(http.HandlerFunc(h.flowUpload)))
+	root.HandleFunc("/error", h.errorPage)
 }
 
 func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
@@ -191,10 +393,15 @@ func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req loginRequest
-	if err := decodeJSON(r.Body, &req); err != nil {
+	// Permissive: a login form from a newer frontend may carry extra fields.
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
 		response.WriteJSON(w, response.Err(500, "请求参数错误"))
 		return
 	}
+	if h.passwordLoginDisabled() {
+		response.WriteJSON(w, response.ErrDefault("已禁用密码登录，请使用单点登录"))
+		return
+	}
 
 	if strings.TrimSpace(req.Username) == "" {
 		response.WriteJSON(w, response.Err(500, "用户名不能为空"))
@@ -217,53 +424,111 @@ func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if !h.consumeCaptchaToken(captchaID) {
-			secretCfg, err := h.repo.GetConfigByName("cloudflare_secret_key")
-			if err != nil || secretCfg == nil || strings.TrimSpace(secretCfg.Value) == "" {
-				response.WriteJSON(w, response.ErrDefault("验证码校验失败"))
-				return
-			}
-
-			if !h.verifyCloudflareTurnstile(captchaID, strings.TrimSpace(secretCfg.Value)) {
-				response.WriteJSON(w, response.ErrDefault("验证码校验失败"))
-				return
-			}
+		if !h.consumeCaptchaToken(captchaID) && !h.verifyCaptcha(r, captchaID) {
+			response.WriteJSON(w, response.ErrDefault("验证码校验失败"))
+			return
 		}
 	}
 
-	user, err := h.repo.GetUserByUsername(req.Username)
-	if err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
-		return
+	// The directory is tried first; local passwords still work when it
+	// rejects the credentials or is unreachable, so admin_user remains a
+	// way in.
+	var user *sqlite.User
+	needsRehash := false
+	if ldapCfg := h.loadLDAPConfig(); ldapCfg != nil {
+		if user, err = h.ldapLogin(r.Context(), ldapCfg, req.Username, req.Password); err != nil {
+			log.Printf("ldap login for %q: %v", req.Username, err)
+		}
 	}
 	if user == nil {
-		response.WriteJSON(w, response.ErrDefault("账号或密码错误"))
-		return
-	}
-	if user.Pwd != security.MD5(req.Password) {
-		response.WriteJSON(w, response.ErrDefault("账号或密码错误"))
-		return
+		user, err = h.repo.GetUserByUsername(req.Username)
+		if store.IsNotFound(err) {
+			security.VerifyDummyPassword(req.Password)
+			h.recordAuthFailure(r, "login")
+			response.WriteJSON(w, response.ErrDefault("账号或密码错误"))
+			return
+		}
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		var passwordOK bool
+		passwordOK, needsRehash = security.VerifyPassword(user.Pwd, req.Password)
+		if !passwordOK {
+			h.recordAuthFailure(r, "login")
+			h.notifySecurityEvent(r, user.ID, user.User, eventSecurityLoginFailed, "密码错误")
+			response.WriteJSON(w, response.ErrDefault("账号或密码错误"))
+			return
+		}
 	}
 	if user.Status == 0 {
 		response.WriteJSON(w, response.ErrDefault("账号被停用"))
 		return
 	}
-
-	token, err := auth.GenerateToken(user.ID, user.User, user.RoleID, h.jwtSecret)
+	totpEnabled, totpSecret, err := h.repo.GetUserTOTP(user.ID)
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
+	if totpEnabled {
+		if strings.TrimSpace(req.TotpCode) == "" {
+			response.WriteJSON(w, response.R{
+				Code: 403,
+				Msg:  "请输入TOTP验证码",
+				TS:   time.Now().UnixMilli(),
+				Data: map[string]interface{}{"totpRequired": true},
+			})
+			return
+		}
+		if !h.verifySecondFactor(user.ID, totpSecret, req.TotpCode) {
+			h.recordAuthFailure(r, "login totp")
+			h.notifySecurityEvent(r, user.ID, user.User, eventSecurityLoginFailed, "TOTP验证失败")
+			response.WriteJSON(w, response.Err(403, "TOTP验证失败"))
+			return
+		}
+	}
+	if needsRehash {
+		h.rehashUserPassword(user.ID, req.Password)
+	}
 
 	requirePasswordChange := req.Username == "admin_user" || req.Password == "admin_user"
+	h.writeLoginSuccess(w, r, user, requirePasswordChange)
+}
+
+// writeLoginSuccess opens a session for a user who just proved who they are
+// and answers with the tokens, whichever way they logged in.
+func (h *Handler) writeLoginSuccess(w http.ResponseWriter, r *http.Request, user *sqlite.User, requirePasswordChange bool) {
+	tokens, err := h.startSession(r, user)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	h.setSessionCookies(w, r, tokens)
+	h.notifySecurityEvent(r, user.ID, user.User, eventSecurityLogin, "")
 	response.WriteJSON(w, response.OK(map[string]interface{}{
-		"token":                 token,
+		"token":                 tokens.AccessToken,
+		"refreshToken":          tokens.RefreshToken,
+		"expiresIn":             int64(auth.AccessTokenTTL / time.Second),
 		"name":                  user.User,
 		"role_id":               user.RoleID,
 		"requirePasswordChange": requirePasswordChange,
 	}))
 }
 
+// secretConfigNames are never served by the unauthenticated /config/get
+// or by /config/list-by-prefix.
+var secretConfigNames = map[string]bool{
+	"cloudflare_secret_key":      true,
+	"hcaptcha_secret_key":        true,
+	"influx_auth_token":          true,
+	oidcClientSecretConfigKey:    true,
+	ldapBindPasswordConfigKey:    true,
+	mtlsCAKeyConfigKey:           true,
+	smtpPasswordConfigKey:        true,
+	sqlite.FieldDataKeyConfigKey: true,
+}
+
 func (h *Handler) getConfigByName(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		response.WriteJSON(w, response.ErrDefault("请求失败"))
@@ -280,6 +545,11 @@ func (h *Handler) getConfigByName(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if secretConfigNames[req.Name] {
+		response.WriteJSON(w, response.ErrDefault("配置不存在"))
+		return
+	}
+
 	cfg, err := h.repo.GetConfigByName(req.Name)
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
@@ -314,28 +584,67 @@ func (h *Handler) userList(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
+		listRequest
 		Current int    `json:"current"`
 		Size    int    `json:"size"`
 		Keyword string `json:"keyword"`
+		Search  string `json:"search"`
+	}
+	if !decodeListRequest(w, r, &req) {
+		return
 	}
-	if err := decodeJSON(r.Body, &req); err != nil && err != io.EOF {
+	if req.Status != nil && *req.Status != 0 && *req.Status != 1 {
 		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
 		return
 	}
 
+	// Requests using search, paging or filters get a paged result; older
+	// callers keep receiving the plain list.
+	if req.Search != "" || req.paged() {
+		filter := req.listFilter()
+		if strings.TrimSpace(filter.Name) == "" {
+			filter.Name = req.Search
+		}
+		page, err := h.repo.SearchUsers(filter, req.pageRequest())
+		writeListPage(w, page, err)
+		return
+	}
+
+	keyword := strings.ToLower(strings.TrimSpace(req.Keyword))
+	matches := func(item map[string]interface{}) bool {
+		if keyword == "" {
+			return true
+		}
+		username := strings.ToLower(strings.TrimSpace(fmt.Sprint(item["user"])))
+		displayName := strings.ToLower(strings.TrimSpace(fmt.Sprint(item["name"])))
+		return strings.Contains(username, keyword) || strings.Contains(displayName, keyword)
+	}
+
+	if response.WantsNDJSON(r) {
+		stream := response.NewNDJSONWriter(w)
+		err := h.repo.ScanUsers(func(u *sqlite.User) error {
+			item := sqlite.UserListItem(u)
+			if !matches(item) {
+				return nil
+			}
+			return stream.Write(item)
+		})
+		if err != nil {
+			_ = stream.Write(response.Err(-2, err.Error()))
+		}
+		return
+	}
+
 	users, err := h.repo.ListUsers()
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
 
-	keyword := strings.ToLower(strings.TrimSpace(req.Keyword))
 	if keyword != "" {
 		filtered := make([]map[string]interface{}, 0, len(users))
 		for _, item := range users {
-			username := strings.ToLower(strings.TrimSpace(fmt.Sprint(item["user"])))
-			displayName := strings.ToLower(strings.TrimSpace(fmt.Sprint(item["name"])))
-			if strings.Contains(username, keyword) || strings.Contains(displayName, keyword) {
+			if matches(item) {
 				filtered = append(filtered, item)
 			}
 		}
@@ -350,6 +659,30 @@ func (h *Handler) nodeList(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("请求失败"))
 		return
 	}
+	var req listRequest
+	if !decodeListRequest(w, r, &req) {
+		return
+	}
+	if req.paged() {
+		page, err := h.repo.ListNodesPage(req.listFilter(), req.pageRequest())
+		if err == nil {
+			h.syncRemoteNodeStatuses(page.Items)
+		}
+		writeListPage(w, page, err)
+		return
+	}
+
+	if response.WantsNDJSON(r) {
+		stream := response.NewNDJSONWriter(w)
+		err := h.repo.ScanNodes(func(item map[string]interface{}) error {
+			h.syncRemoteNodeStatuses([]map[string]interface{}{item})
+			return stream.Write(item)
+		})
+		if err != nil {
+			_ = stream.Write(response.Err(-2, err.Error()))
+		}
+		return
+	}
 
 	items, err := h.repo.ListNodes()
 	if err != nil {
@@ -367,6 +700,15 @@ func (h *Handler) tunnelList(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("请求失败"))
 		return
 	}
+	var req listRequest
+	if !decodeListRequest(w, r, &req) {
+		return
+	}
+	if req.paged() {
+		page, err := h.repo.ListTunnelsPage(req.listFilter(), req.pageRequest())
+		writeListPage(w, page, err)
+		return
+	}
 
 	items, err := h.repo.ListTunnels()
 	if err != nil {
@@ -387,6 +729,19 @@ func (h *Handler) forwardList(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
 		return
 	}
+	var req listRequest
+	if !decodeListRequest(w, r, &req) {
+		return
+	}
+	if req.paged() {
+		filter := req.listFilter()
+		if roleID != 0 {
+			filter.UserID = userID
+		}
+		page, err := h.repo.ListForwardsPage(filter, req.pageRequest())
+		writeListPage(w, page, err)
+		return
+	}
 
 	items, err := h.repo.ListForwards()
 	if err != nil {
@@ -410,6 +765,15 @@ func (h *Handler) speedLimitList(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("请求失败"))
 		return
 	}
+	var req listPageRequest
+	if !decodeListRequest(w, r, &req) {
+		return
+	}
+	if req.paged() {
+		page, err := h.repo.ListSpeedLimitsPage(req.pageRequest())
+		writeListPage(w, page, err)
+		return
+	}
 
 	items, err := h.repo.ListSpeedLimits()
 	if err != nil {
@@ -446,11 +810,15 @@ func (h *Handler) openAPISubStore(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user, err := h.repo.GetUserByUsername(username)
-	if err != nil {
+	if err != nil && !store.IsNotFound(err) {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
-	if user == nil || user.Pwd != security.MD5(password) {
+	if user == nil {
+		response.WriteJSON(w, response.ErrDefault("鉴权失败"))
+		return
+	}
+	if ok, _ := security.VerifyPassword(user.Pwd, password); !ok {
 		response.WriteJSON(w, response.ErrDefault("鉴权失败"))
 		return
 	}
@@ -662,9 +1030,8 @@ func (h *Handler) captchaVerify(w http.ResponseWriter, r *http.Request) {
 	}
 
 	verified := false
-	secretCfg, err := h.repo.GetConfigByName("cloudflare_secret_key")
-	if err == nil && secretCfg != nil && strings.TrimSpace(secretCfg.Value) != "" {
-		verified = h.verifyCloudflareTurnstile(data, strings.TrimSpace(secretCfg.Value))
+	if h.captchaProvider() != nil {
+		verified = h.verifyCaptcha(r, data)
 	} else {
 		verified = data == "ok"
 	}
@@ -682,38 +1049,75 @@ func (h *Handler) flowTest(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte("test"))
 }
 
+// flowConfig takes the node's periodic config report, drops services the
+// panel no longer knows about, and answers with the configuration the panel
+// expects the node to run, encrypted with the node secret.
 func (h *Handler) flowConfig(w http.ResponseWriter, r *http.Request) {
 	secret := r.URL.Query().Get("secret")
-	node, err := h.repo.GetNodeBySecret(secret)
-	if err != nil || node == nil {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		_, _ = w.Write([]byte("ok"))
+	cfg, err := h.repo.GetNodeFullConfig(secret)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(response.Err(-2, err.Error()))
+		return
+	}
+	if cfg == nil {
+		h.recordNodeAuthFailure(r)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(response.Err(403, "节点不存在"))
 		return
 	}
 
-	rawData, err := readAndDecryptFlowBody(r.Body, secret)
+	rawData, err := h.readAndDecryptFlowBody(r, secret)
+	if errors.Is(err, errFlowPayloadRejected) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
 	if err == nil && strings.TrimSpace(rawData) != "" {
-		h.cleanNodeConfigs(node.ID, rawData)
+		h.cleanNodeConfigs(cfg.NodeID, rawData)
 	}
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	_, _ = w.Write([]byte("ok"))
+
+	envelope, err := encryptFlowPayload(cfg, secret)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(response.Err(-2, err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(envelope)
 }
 
+// defaultNodeUploadRateLimitPerMin caps /flow/upload calls per node secret
+// when node_upload_rate_limit_per_min is not configured.
+const defaultNodeUploadRateLimitPerMin = 120
+
 func (h *Handler) flowUpload(w http.ResponseWriter, r *http.Request) {
 	secret := r.URL.Query().Get("secret")
-	if ok, _ := h.repo.NodeExistsBySecret(secret); !ok {
+	if ok, err := h.repo.NodeExistsBySecret(secret); !ok {
+		if err == nil {
+			h.recordNodeAuthFailure(r)
+		}
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		_, _ = w.Write([]byte("ok"))
 		return
 	}
 
-	raw, err := readAndDecryptFlowBody(r.Body, secret)
+	raw, err := h.readAndDecryptFlowBody(r, secret)
+	if errors.Is(err, errFlowPayloadRejected) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
 	if err == nil && strings.TrimSpace(raw) != "" {
 		var items []flowItem
 		if json.Unmarshal([]byte(raw), &items) == nil {
 			for _, item := range items {
 				h.processFlowItem(item)
 			}
+			if samples := forwardFlowSamples(items); len(samples) > 0 && h.influx != nil {
+				go h.influx.Export(samples)
+			}
 		}
 	}
 
@@ -721,6 +1125,20 @@ func (h *Handler) flowUpload(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("ok"))
 }
 
+// forwardFlowSamples keeps the forward services of a flow upload for the
+// InfluxDB exporter, with the traffic as the node reported it.
+func forwardFlowSamples(items []flowItem) []metrics.FlowSample {
+	samples := make([]metrics.FlowSample, 0, len(items))
+	for _, item := range items {
+		forwardID, userID, _, ok := parseFlowServiceIDs(strings.TrimSpace(item.N))
+		if !ok {
+			continue
+		}
+		samples = append(samples, metrics.FlowSample{ForwardID: forwardID, UserID: userID, Upload: item.U, Download: item.D})
+	}
+	return samples
+}
+
 func (h *Handler) updateConfigs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		response.WriteJSON(w, response.ErrDefault("请求失败"))
@@ -737,6 +1155,14 @@ func (h *Handler) updateConfigs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	protected := h.protectedConfigPrefixes()
+	for k := range payload {
+		if key := strings.TrimSpace(k); key != "" && !h.canWriteConfigKey(r, key, protected) {
+			response.WriteJSON(w, response.Err(403, "配置 "+key+" 受保护，仅管理员可修改"))
+			return
+		}
+	}
+
 	now := time.Now().UnixMilli()
 	for k, v := range payload {
 		key := strings.TrimSpace(k)
@@ -771,6 +1197,10 @@ func (h *Handler) updateSingleConfig(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("配置值不能为空"))
 		return
 	}
+	if !h.canWriteConfigKey(r, strings.TrimSpace(req.Name), h.protectedConfigPrefixes()) {
+		response.WriteJSON(w, response.Err(403, "配置 "+strings.TrimSpace(req.Name)+" 受保护，仅管理员可修改"))
+		return
+	}
 
 	if err := h.repo.UpsertConfig(strings.TrimSpace(req.Name), req.Value, time.Now().UnixMilli()); err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
@@ -799,12 +1229,12 @@ func (h *Handler) userPackage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user, err := h.repo.GetUserByID(userID)
-	if err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
+	if store.IsNotFound(err) {
+		response.WriteJSON(w, response.ErrDefault("用户不存在"))
 		return
 	}
-	if user == nil {
-		response.WriteJSON(w, response.ErrDefault("用户不存在"))
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
 
@@ -921,7 +1351,8 @@ func (h *Handler) updatePassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req changePasswordRequest
-	if err := decodeJSON(r.Body, &req); err != nil {
+	// Permissive: the account form may submit profile fields this endpoint ignores.
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
 		response.WriteJSON(w, response.ErrDefault("修改账号密码时发生错误"))
 		return
 	}
@@ -946,19 +1377,33 @@ func (h *Handler) updatePassword(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("新密码和确认密码不匹配"))
 		return
 	}
+	if err := h.passwordPolicy().Check(req.NewPassword); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
 
 	user, err := h.repo.GetUserByID(userID)
+	if store.IsNotFound(err) {
+		response.WriteJSON(w, response.ErrDefault("用户不存在"))
+		return
+	}
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
-	if user == nil {
-		response.WriteJSON(w, response.ErrDefault("用户不存在"))
+
+	if ok, _ := security.VerifyPassword(user.Pwd, req.CurrentPassword); !ok {
+		response.WriteJSON(w, response.ErrDefault("当前密码错误"))
 		return
 	}
 
-	if user.Pwd != security.MD5(req.CurrentPassword) {
-		response.WriteJSON(w, response.ErrDefault("当前密码错误"))
+	totpEnabled, totpSecret, err := h.repo.GetUserTOTP(userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if totpEnabled && !h.verifyTOTPCode(totpSecret, strings.TrimSpace(req.TotpCode)) {
+		response.WriteJSON(w, response.Err(403, "TOTP验证失败"))
 		return
 	}
 
@@ -972,14 +1417,34 @@ func (h *Handler) updatePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.repo.UpdateUserNameAndPassword(userID, req.NewUsername, security.MD5(req.NewPassword), time.Now().UnixMilli()); err != nil {
+	passwordHash, err := security.HashPassword(req.NewPassword)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err := h.repo.UpdateUserNameAndPassword(userID, req.NewUsername, passwordHash, time.Now().UnixMilli()); err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
+	h.revokeUserSessions(userID)
+	h.notifySecurityEvent(r, userID, req.NewUsername, eventSecurityPasswordChanged, "")
 
 	response.WriteJSON(w, response.OKEmpty())
 }
 
+// rehashUserPassword replaces a legacy MD5 hash once the password has been
+// verified. Failure only means the migration is retried on the next login.
+func (h *Handler) rehashUserPassword(userID int64, password string) {
+	hash, err := security.HashPassword(password)
+	if err != nil {
+		log.Printf("rehash password for user %d: %v", userID, err)
+		return
+	}
+	if err := h.repo.UpdateUserPasswordHash(userID, hash); err != nil {
+		log.Printf("rehash password for user %d: %v", userID, err)
+	}
+}
+
 func (h *Handler) captchaEnabled() (bool, error) {
 	cfg, err := h.repo.GetConfigByName("captcha_enabled")
 	if err != nil {
@@ -1054,6 +1519,8 @@ func (h *Handler) writeCaptchaVerifyResult(w http.ResponseWriter, success bool,
 	_ = json.NewEncoder(w).Encode(payload)
 }
 
+// decodeJSON decodes a request body and rejects fields that out does not
+// declare. Admin endpoints use it so that typos in a payload fail loudly.
 func decodeJSON(body io.ReadCloser, out interface{}) error {
 	defer body.Close()
 	decoder := json.NewDecoder(body)
@@ -1061,6 +1528,14 @@ func decodeJSON(body io.ReadCloser, out interface{}) error {
 	return decoder.Decode(out)
 }
 
+// decodeJSONPermissive is decodeJSON without the unknown field check, for
+// user-facing endpoints that must keep working when a client is newer than
+// the server and sends fields it does not know yet.
+func decodeJSONPermissive(body io.ReadCloser, out interface{}) error {
+	defer body.Close()
+	return json.NewDecoder(body).Decode(out)
+}
+
 func parseUserID(sub string) (int64, error) {
 	id, err := strconv.ParseInt(sub, 10, 64)
 	if err != nil || id <= 0 {
@@ -1096,56 +1571,93 @@ func nullableNullInt64(v sql.NullInt64) interface{} {
 	return nil
 }
 
-func readAndDecryptFlowBody(body io.ReadCloser, secret string) (string, error) {
-	defer body.Close()
-	raw, err := io.ReadAll(body)
+// readAndDecryptFlowBody reads a node report. Encrypted envelopes must carry
+// a timestamp within node_message_max_skew_sec. Version 2 envelopes bind it
+// into the AES-GCM tag and must also not be replayed. Plaintext bodies and
+// version 1 envelopes, whose timestamp is not authenticated, are still
+// accepted from older nodes unless strict is set.
+// A body that claims to be encrypted but does not decrypt is always an
+// error; it is never read as plaintext. Signature headers, when present,
+// are checked against the raw body first.
+func (h *Handler) readAndDecryptFlowBody(r *http.Request, secret string) (string, error) {
+	defer r.Body.Close()
+	raw, err := io.ReadAll(r.Body)
 	if err != nil {
 		return "", err
 	}
+	if err := h.verifyFlowSignature(r, secret, raw); err != nil {
+		return "", err
+	}
 	text := strings.TrimSpace(string(raw))
 	if text == "" {
 		return "", nil
 	}
+	strict := h.configValue(nodePayloadStrictConfigKey) == "true"
 
-	var wrap struct {
-		Encrypted bool   `json:"encrypted"`
-		Data      string `json:"data"`
-		Timestamp int64  `json:"timestamp"`
-	}
-	if err := json.Unmarshal(raw, &wrap); err != nil || !wrap.Encrypted || strings.TrimSpace(wrap.Data) == "" {
+	var wrap flowEnvelope
+	if err := json.Unmarshal(raw, &wrap); err != nil || !wrap.Encrypted {
+		if strict {
+			return "", errFlowPayloadRejected
+		}
 		return text, nil
 	}
+	if strings.TrimSpace(wrap.Data) == "" {
+		return "", errFlowPayloadRejected
+	}
+	now := time.Now()
+	if skew := security.TimestampSkew(wrap.Timestamp, now); skew > h.nodeMessageMaxSkew() {
+		h.logStaleNodeMessage(r, secret, skew)
+		return "", errFlowPayloadRejected
+	}
 
 	crypto, err := security.NewAESCrypto(secret)
 	if err != nil {
-		return text, nil
+		return "", err
 	}
-	plain, err := crypto.Decrypt(wrap.Data)
-	if err != nil {
-		return text, nil
+	switch wrap.Version {
+	case 0, 1:
+		if strict {
+			return "", errFlowPayloadRejected
+		}
+		plain, err := crypto.Decrypt(wrap.Data)
+		if err != nil {
+			return "", errFlowPayloadRejected
+		}
+		return string(plain), nil
+	case flowEnvelopeVersion:
+		plain, err := crypto.DecryptWithAAD(wrap.Data, flowEnvelopeAAD(wrap.Timestamp))
+		if err != nil {
+			return "", errFlowPayloadRejected
+		}
+		// The first 16 base64 characters are exactly the 12-byte nonce.
+		if !h.flowNonces.add(secret+":"+wrap.Data[:16], now) {
+			return "", errFlowPayloadRejected
+		}
+		return string(plain), nil
 	}
-	return string(plain), nil
+	return "", errFlowPayloadRejected
 }
 
-func (h *Handler) verifyCloudflareTurnstile(token, secretKey string) bool {
-	if token == "" || secretKey == "" {
-		return false
-	}
-	resp, err := http.PostForm("https://challenges.cloudflare.com/turnstile/v0/siteverify", url.Values{
-		"secret":   {secretKey},
-		"response": {token},
-	})
+// encryptFlowPayload marshals v and wraps it in the encrypted envelope that
+// nodes also use for their own reports.
+func encryptFlowPayload(v interface{}, secret string) ([]byte, error) {
+	raw, err := json.Marshal(v)
 	if err != nil {
-		return false
+		return nil, err
 	}
-	defer resp.Body.Close()
-	var body struct {
-		Success bool `json:"success"`
+	crypto, err := security.NewAESCrypto(secret)
+	if err != nil {
+		return nil, err
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
-		return false
+	data, err := crypto.Encrypt(raw)
+	if err != nil {
+		return nil, err
 	}
-	return body.Success
+	return json.Marshal(map[string]interface{}{
+		"encrypted": true,
+		"data":      data,
+		"timestamp": time.Now().UnixMilli(),
+	})
 }
 
 type backupExportRequest struct {
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/middleware"
+	"go-backend/internal/http/response"
+	"go-backend/internal/store"
+)
+
+type userImpersonateRequest struct {
+	ID int64 `json:"id"`
+}
+
+// userImpersonate gives an admin a short-lived token for another user, so
+// support can see the panel as that user does. The token has no refresh
+// session and cannot change the user's credentials.
+func (h *Handler) userImpersonate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req userImpersonateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.ID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("用户ID不能为空"))
+		return
+	}
+	claims, ok := r.Context().Value(middleware.ClaimsContextKey).(auth.Claims)
+	if !ok {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	adminID, err := parseUserID(claims.Sub)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+
+	user, err := h.repo.GetUserByID(req.ID)
+	if store.IsNotFound(err) {
+		response.WriteJSON(w, response.ErrDefault("用户不存在"))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if user.RoleID == auth.RoleAdmin {
+		response.WriteJSON(w, response.ErrDefault("不能模拟管理员账号"))
+		return
+	}
+
+	audience, _ := middleware.LoadJWTAudience(h.repo)
+	token, err := auth.GenerateImpersonationTokenWithKey(user.ID, user.User, user.RoleID, user.PermissionMask, audience, adminID, h.keys.Current())
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.writeAuditLog(r, "user_impersonate", "user", user.ID, user.User)
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"token":     token,
+		"expiresIn": int64(auth.ImpersonationTokenTTL / time.Second),
+		"name":      user.User,
+		"role_id":   user.RoleID,
+	}))
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/network"
+)
+
+type securityBanRemoveRequest struct {
+	IP string `json:"ip"`
+}
+
+// recordAuthFailure counts a failed login or node authentication against
+// the caller's IP, which is banned once it fails too often.
+func (h *Handler) recordAuthFailure(r *http.Request, reason string) {
+	ip := network.ClientIP(r)
+	if h.bans.RecordFailure(ip, reason) {
+		log.Printf("banned %s after repeated %s failures", ip, reason)
+	}
+}
+
+// recordNodeAuthFailure is recordAuthFailure for an unknown node secret.
+// Secrets of a rotation in flight are not counted.
+func (h *Handler) recordNodeAuthFailure(r *http.Request) {
+	if h.secretRotations.inFlight(r.URL.Query().Get("secret"), time.Now()) {
+		return
+	}
+	h.recordAuthFailure(r, "node secret")
+}
+
+func (h *Handler) securityBanList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	bans, err := h.repo.ListActiveIPBans(time.Now().UnixMilli())
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(bans))
+}
+
+func (h *Handler) securityBanRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req securityBanRemoveRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	ip := strings.TrimSpace(req.IP)
+	if ip == "" {
+		response.WriteJSON(w, response.ErrDefault("IP不能为空"))
+		return
+	}
+	removed, err := h.bans.Unban(ip)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if !removed {
+		response.WriteJSON(w, response.ErrDefault("该IP未被封禁"))
+		return
+	}
+	h.writeAuditLog(r, "ip_unban", "ip", 0, ip)
+	response.WriteJSON(w, response.OKEmpty())
+}
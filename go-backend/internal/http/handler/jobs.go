@@ -19,11 +19,14 @@ func (h *Handler) StartBackgroundJobs() {
 	ctx, cancel := context.WithCancel(context.Background())
 	h.jobsCancel = cancel
 	h.jobsStarted = true
-	h.jobsWG.Add(2)
+	h.jobsWG.Add(5)
 	h.jobsMu.Unlock()
 
 	go h.runHourlyStatsLoop(ctx)
 	go h.runDailyMaintenanceLoop(ctx)
+	go h.runTunnelMetricsDecayLoop(ctx)
+	go h.runNodeLatencyProbeLoop(ctx)
+	go h.runNodeUploadEvictLoop(ctx)
 }
 
 func (h *Handler) StopBackgroundJobs() {
@@ -83,6 +86,51 @@ func (h *Handler) runDailyMaintenanceLoop(ctx context.Context) {
 	}
 }
 
+func (h *Handler) runTunnelMetricsDecayLoop(ctx context.Context) {
+	defer h.jobsWG.Done()
+
+	ticker := time.NewTicker(tunnelMetricsDecayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.tunnelMetrics.decay(tunnelMetricsDecayInterval)
+		}
+	}
+}
+
+func (h *Handler) runNodeLatencyProbeLoop(ctx context.Context) {
+	defer h.jobsWG.Done()
+
+	ticker := time.NewTicker(nodeLatencyProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.wsServer.ProbeLatency()
+		}
+	}
+}
+
+func (h *Handler) runNodeUploadEvictLoop(ctx context.Context) {
+	defer h.jobsWG.Done()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.uploadLimits.EvictIdle()
+		}
+	}
+}
+
 func durationUntilNextHour(now time.Time) time.Duration {
 	next := now.Truncate(time.Hour).Add(time.Hour)
 	return next.Sub(now)
@@ -160,6 +208,15 @@ func (h *Handler) runResetAndExpiryJob(now time.Time) {
 	h.resetMonthlyFlow(now)
 	h.disableExpiredUsers(now.UnixMilli())
 	h.disableExpiredUserTunnels(now.UnixMilli())
+	h.warnExpiringUserTunnels(now)
+	h.pruneNodeConnectionLog(now)
+	_, _ = h.repo.PruneIPBans(now.UnixMilli())
+	_, _ = h.repo.CleanOrphanedFederationBindings()
+}
+
+func (h *Handler) pruneNodeConnectionLog(now time.Time) {
+	cutoffMs := now.Add(-30 * 24 * time.Hour).UnixMilli()
+	_, _ = h.repo.DB().Exec(`DELETE FROM node_connection_log WHERE created_time < ?`, cutoffMs)
 }
 
 func (h *Handler) resetMonthlyFlow(now time.Time) {
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
@@ -126,3 +127,84 @@ func TestRunResetAndExpiryJobResetsFlowAndDisablesExpiredRecords(t *testing.T) {
 		t.Fatalf("expected forward status=0 after expiry handling, got %d", forwardStatus)
 	}
 }
+
+func TestRunResetAndExpiryJobPublishesExpiryWarning(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "jobs-expiry-warning.db")
+	repo, err := sqlite.Open(dbPath)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	h := New(repo, "secret")
+	now := time.Date(2026, 3, 15, 0, 0, 5, 0, time.UTC)
+	nowMs := now.UnixMilli()
+	expMs := now.Add(3 * 24 * time.Hour).UnixMilli()
+
+	if _, err := repo.DB().Exec(`
+		INSERT INTO tunnel(id, name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES(1, 'warn-tunnel', 1.0, 1, 'tls', 1, ?, ?, 1, NULL, 0)
+	`, nowMs, nowMs); err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	for _, userID := range []int64{2, 3} {
+		if _, err := repo.DB().Exec(`
+			INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+			VALUES(?, ?, 'x', 1, ?, 100, 0, 0, 0, 1, ?, ?, 1)
+		`, userID, "warn_user_"+strconv.FormatInt(userID, 10), expMs+millisPerDay*30, nowMs, nowMs); err != nil {
+			t.Fatalf("insert user: %v", err)
+		}
+		if _, err := repo.DB().Exec(`
+			INSERT INTO user_tunnel(id, user_id, tunnel_id, speed_id, num, flow, in_flow, out_flow, flow_reset_time, exp_time, status)
+			VALUES(?, ?, 1, NULL, 1, 1, 0, 0, 0, ?, 1)
+		`, userID*10, userID, expMs); err != nil {
+			t.Fatalf("insert user_tunnel: %v", err)
+		}
+	}
+	if err := repo.UpsertUserNotificationPref(sqlite.UserNotificationPref{UserID: 3, ExpiryWarningEnabled: false}, nowMs); err != nil {
+		t.Fatalf("opt out user 3: %v", err)
+	}
+
+	sub := h.events.subscribe(0, true)
+	defer h.events.unsubscribe(sub)
+
+	h.runResetAndExpiryJob(now)
+
+	select {
+	case ev := <-sub.ch:
+		if ev.Type != eventUserTunnelExpiryWarning || ev.UserID != 2 {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+		data := ev.Data.(map[string]interface{})
+		if data["userId"] != int64(2) || data["userTunnelId"] != int64(20) || data["tunnelName"] != "warn-tunnel" {
+			t.Fatalf("unexpected payload %v", data)
+		}
+		if days := data["expiresInDays"].(float64); days != 3 {
+			t.Fatalf("expected expiresInDays 3, got %v", days)
+		}
+	default:
+		t.Fatalf("expected an expiry warning event")
+	}
+	select {
+	case ev := <-sub.ch:
+		t.Fatalf("expected opted-out user to get no warning, got %+v", ev)
+	default:
+	}
+
+	h.runResetAndExpiryJob(now.Add(time.Hour))
+	select {
+	case ev := <-sub.ch:
+		t.Fatalf("expected warning to be sent once per day, got %+v", ev)
+	default:
+	}
+
+	h.runResetAndExpiryJob(now.Add(24 * time.Hour))
+	select {
+	case ev := <-sub.ch:
+		if ev.UserID != 2 {
+			t.Fatalf("unexpected next-day event %+v", ev)
+		}
+	default:
+		t.Fatalf("expected warning to repeat on the next day")
+	}
+}
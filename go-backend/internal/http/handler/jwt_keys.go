@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+)
+
+// loadSigningKeys fills the keyring from jwt_signing_key. A retired key, and
+// the static JWT_SECRET once the first rotation happened, keep verifying
+// tokens for one access-token lifetime, by which time everything they
+// signed has expired or been refreshed under the new key.
+func (h *Handler) loadSigningKeys() error {
+	rows, err := h.repo.ListJWTSigningKeys()
+	if err != nil {
+		return err
+	}
+	crypto, err := security.NewAESCrypto(h.jwtSecret)
+	if err != nil {
+		return err
+	}
+	grace := int64(auth.AccessTokenTTL / time.Second)
+	keys := make([]auth.SigningKey, 0, len(rows))
+	for _, row := range rows {
+		secret, err := crypto.Decrypt(row.Secret)
+		if err != nil {
+			return err
+		}
+		key := auth.SigningKey{Kid: row.Kid, Secret: string(secret)}
+		if row.RetiredTime > 0 {
+			key.ExpiresAt = row.RetiredTime/1000 + grace
+		}
+		keys = append(keys, key)
+	}
+	staticExpiresAt := int64(0)
+	if len(rows) > 0 {
+		staticExpiresAt = rows[0].CreatedTime/1000 + grace
+	}
+	h.keys.SetKeys(keys, staticExpiresAt)
+	return nil
+}
+
+// adminJWTRotate replaces the token signing key. Tokens signed with the old
+// key stay valid until they expire, so nobody is logged out.
+func (h *Handler) adminJWTRotate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	crypto, err := security.NewAESCrypto(h.jwtSecret)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	kid := randomToken(8)
+	secret, err := crypto.Encrypt([]byte(randomToken(32)))
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	now := time.Now().UnixMilli()
+	if err := h.repo.RotateJWTSigningKey(kid, secret, now); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err := h.loadSigningKeys(); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"kid":         kid,
+		"createdTime": now,
+	}))
+}
@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-backend/internal/ldap"
+	"go-backend/internal/security"
+	"go-backend/internal/store"
+	"go-backend/internal/store/sqlite"
+)
+
+const (
+	ldapEnabledConfigKey        = "ldap_enabled"
+	ldapURLConfigKey            = "ldap_url"
+	ldapUserDNTemplateConfigKey = "ldap_user_dn_template"
+	ldapBindDNConfigKey         = "ldap_bind_dn"
+	ldapBindPasswordConfigKey   = "ldap_bind_password"
+	ldapBaseDNConfigKey         = "ldap_base_dn"
+	ldapUserAttributeConfigKey  = "ldap_user_attribute"
+	ldapAdminGroupDNConfigKey   = "ldap_admin_group_dn"
+	ldapLinkByUsernameConfigKey = "ldap_link_by_username"
+
+	ldapTimeout = 10 * time.Second
+)
+
+// ldapConfig is the directory configuration read from vite_config. Users
+// are located either by substituting {username} in UserDNTemplate, or,
+// when no template is set, by searching BaseDN for UserAttribute with the
+// service account BindDN (the usual Active Directory setup, with
+// sAMAccountName as the attribute).
+type ldapConfig struct {
+	URL            string
+	UserDNTemplate string
+	BindDN         string
+	BindPassword   string
+	BaseDN         string
+	UserAttribute  string
+	AdminGroupDN   string
+	LinkByUsername bool
+}
+
+// loadLDAPConfig returns nil when LDAP is disabled or not fully configured.
+func (h *Handler) loadLDAPConfig() *ldapConfig {
+	if h.configValue(ldapEnabledConfigKey) != "true" {
+		return nil
+	}
+	cfg := &ldapConfig{
+		URL:            h.configValue(ldapURLConfigKey),
+		UserDNTemplate: h.configValue(ldapUserDNTemplateConfigKey),
+		BindDN:         h.configValue(ldapBindDNConfigKey),
+		BindPassword:   h.configValue(ldapBindPasswordConfigKey),
+		BaseDN:         h.configValue(ldapBaseDNConfigKey),
+		UserAttribute:  defaultString(h.configValue(ldapUserAttributeConfigKey), "uid"),
+		AdminGroupDN:   h.configValue(ldapAdminGroupDNConfigKey),
+		LinkByUsername: h.configValue(ldapLinkByUsernameConfigKey) == "true",
+	}
+	if cfg.URL == "" || (cfg.UserDNTemplate == "" && cfg.BaseDN == "") {
+		return nil
+	}
+	return cfg
+}
+
+// ldapAuthenticate verifies the credentials against the directory. It
+// returns the user's DN and whether they belong to the admin group, or
+// ldap.ErrInvalidCredentials when the directory rejects them.
+func ldapAuthenticate(ctx context.Context, cfg *ldapConfig, username, password string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, ldapTimeout)
+	defer cancel()
+	conn, err := ldap.Dial(ctx, cfg.URL)
+	if err != nil {
+		return "", false, err
+	}
+	defer conn.Close()
+
+	var userDN string
+	if cfg.UserDNTemplate != "" {
+		userDN = strings.ReplaceAll(cfg.UserDNTemplate, "{username}", ldap.EscapeDN(username))
+	} else {
+		if cfg.BindDN != "" {
+			if err := conn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+				return "", false, fmt.Errorf("service bind: %w", err)
+			}
+		}
+		entries, err := conn.Search(cfg.BaseDN, ldap.ScopeWholeSubtree, cfg.UserAttribute, username)
+		if err != nil {
+			return "", false, err
+		}
+		if len(entries) != 1 {
+			return "", false, ldap.ErrInvalidCredentials
+		}
+		userDN = entries[0].DN
+	}
+	if err := conn.Bind(userDN, password); err != nil {
+		if errors.Is(err, ldap.ErrEmptyPassword) {
+			return "", false, ldap.ErrInvalidCredentials
+		}
+		return "", false, err
+	}
+
+	if cfg.AdminGroupDN == "" {
+		return userDN, false, nil
+	}
+	// Searched as the user, so the group must be readable by its members.
+	groups, err := conn.Search(cfg.AdminGroupDN, ldap.ScopeBaseObject, "member", userDN)
+	if err != nil {
+		return "", false, err
+	}
+	return userDN, len(groups) > 0, nil
+}
+
+// ldapIdentityIssuer namespaces directory accounts in the external
+// identity links shared with OIDC, keyed by the user's DN.
+func ldapIdentityIssuer(cfg *ldapConfig) string {
+	return "ldap:" + cfg.URL
+}
+
+// ldapLogin returns the local user for credentials the directory accepts.
+// A directory account maps to the local user it was linked to; failing
+// that, to a local user with the same name when ldap_link_by_username is
+// on (never a full admin), or to a user provisioned on first login. With
+// an admin group configured, the admin role follows group membership on
+// every login. It returns nil, nil when the directory rejects the
+// credentials so the caller can try the local password.
+func (h *Handler) ldapLogin(ctx context.Context, cfg *ldapConfig, username, password string) (*sqlite.User, error) {
+	userDN, isAdmin, err := ldapAuthenticate(ctx, cfg, username, password)
+	if errors.Is(err, ldap.ErrInvalidCredentials) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	issuer := ldapIdentityIssuer(cfg)
+	now := time.Now().UnixMilli()
+
+	userID, err := h.repo.GetUserIDByOIDCIdentity(issuer, userDN)
+	if err != nil {
+		return nil, err
+	}
+	if userID > 0 {
+		user, err := h.repo.GetUserByID(userID)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.applyLDAPAdminGroup(cfg, user, isAdmin, now); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	existing, err := h.repo.GetUserByUsername(username)
+	if err != nil && !store.IsNotFound(err) {
+		return nil, err
+	}
+	if existing != nil {
+		if !cfg.LinkByUsername {
+			return nil, fmt.Errorf("directory account %q is not linked to the local user", userDN)
+		}
+		if existing.RoleID == 0 {
+			return nil, fmt.Errorf("directory account %q may not be linked to an admin account", userDN)
+		}
+		if err := h.repo.LinkOIDCIdentity(existing.ID, issuer, userDN, now); err != nil {
+			return nil, err
+		}
+		if err := h.applyLDAPAdminGroup(cfg, existing, isAdmin, now); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	roleID := 1
+	if isAdmin {
+		roleID = 0
+	}
+	// The local password is random: provisioned users sign in through the
+	// directory until an admin resets it.
+	passwordHash, err := security.HashPassword(randomToken(24))
+	if err != nil {
+		return nil, err
+	}
+	user := &sqlite.User{
+		User:          username,
+		Pwd:           passwordHash,
+		RoleID:        roleID,
+		ExpTime:       time.Now().Add(365 * 24 * time.Hour).UnixMilli(),
+		Flow:          100,
+		FlowResetTime: 1,
+		Num:           10,
+		CreatedTime:   now,
+		UpdatedTime:   sql.NullInt64{Int64: now, Valid: true},
+		Status:        1,
+	}
+	if user.ID, err = h.repo.CreateUser(user); err != nil {
+		return nil, err
+	}
+	if err := h.repo.LinkOIDCIdentity(user.ID, issuer, userDN, now); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// applyLDAPAdminGroup grants or withdraws the admin role of a linked user
+// to match the admin group, so removing someone from the group takes
+// effect at their next login. Without an admin group the role is left to
+// the panel.
+func (h *Handler) applyLDAPAdminGroup(cfg *ldapConfig, user *sqlite.User, isAdmin bool, now int64) error {
+	if cfg.AdminGroupDN == "" {
+		return nil
+	}
+	roleID := user.RoleID
+	switch {
+	case isAdmin && roleID != 0:
+		roleID = 0
+	case !isAdmin && roleID == 0:
+		roleID = 1
+	default:
+		return nil
+	}
+	if err := h.repo.SetUserRole(user.ID, int64(roleID), now); err != nil {
+		return err
+	}
+	if roleID != 0 {
+		// Sessions issued while the user was an admin still carry role 0.
+		h.revokeUserSessions(user.ID)
+	}
+	user.RoleID = roleID
+	return nil
+}
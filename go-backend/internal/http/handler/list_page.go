@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+// listPageRequest holds the paging fields the list endpoints accept. A
+// request without any of them gets the whole list as a plain array, as
+// older clients expect.
+type listPageRequest struct {
+	Page     int    `json:"page"`
+	PageSize int    `json:"pageSize"`
+	Cursor   string `json:"cursor"`
+}
+
+func (p listPageRequest) paged() bool {
+	return p.Page > 0 || p.PageSize > 0 || p.Cursor != ""
+}
+
+func (p listPageRequest) pageRequest() sqlite.PageRequest {
+	return sqlite.PageRequest{Page: p.Page, PageSize: p.PageSize, Cursor: p.Cursor}
+}
+
+// listFilterRequest holds the filters the list endpoints accept. Each list
+// applies the ones that make sense for it; see sqlite.ListFilter.
+type listFilterRequest struct {
+	Name        string `json:"name"`
+	Status      *int   `json:"status"`
+	NodeID      int64  `json:"nodeId"`
+	TunnelID    int64  `json:"tunnelId"`
+	UserID      int64  `json:"userId"`
+	CreatedFrom int64  `json:"createdFrom"`
+	CreatedTo   int64  `json:"createdTo"`
+}
+
+func (f listFilterRequest) filtered() bool {
+	return strings.TrimSpace(f.Name) != "" || f.Status != nil || f.NodeID > 0 || f.TunnelID > 0 || f.UserID > 0 || f.CreatedFrom > 0 || f.CreatedTo > 0
+}
+
+func (f listFilterRequest) valid() bool {
+	return f.NodeID >= 0 && f.TunnelID >= 0 && f.UserID >= 0 && f.CreatedFrom >= 0 && f.CreatedTo >= 0 &&
+		(f.CreatedTo == 0 || f.CreatedFrom <= f.CreatedTo)
+}
+
+func (f listFilterRequest) listFilter() sqlite.ListFilter {
+	return sqlite.ListFilter{
+		Name:        f.Name,
+		Status:      f.Status,
+		NodeID:      f.NodeID,
+		TunnelID:    f.TunnelID,
+		UserID:      f.UserID,
+		CreatedFrom: f.CreatedFrom,
+		CreatedTo:   f.CreatedTo,
+	}
+}
+
+// listRequest is the body of the list endpoints. Filtered or paged
+// requests get a paged result.
+type listRequest struct {
+	listPageRequest
+	listFilterRequest
+}
+
+func (l listRequest) paged() bool {
+	return l.listPageRequest.paged() || l.filtered()
+}
+
+// decodeListRequest reads a list request body into req. An empty body is
+// an unpaged request. On failure the error response is already written.
+func decodeListRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	// Permissive: the list filters grow over time and older servers should
+	// ignore filters they do not support rather than fail.
+	if err := decodeJSONPermissive(r.Body, req); err != nil && err != io.EOF {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return false
+	}
+	if f, ok := req.(interface{ valid() bool }); ok && !f.valid() {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return false
+	}
+	return true
+}
+
+// writeListPage writes page in the paged list envelope.
+func writeListPage(w http.ResponseWriter, page sqlite.ListPage, err error) {
+	if errors.Is(err, sqlite.ErrInvalidCursor) {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"total":      page.Total,
+		"page":       page.Page,
+		"pageSize":   page.PageSize,
+		"nextCursor": page.NextCursor,
+		"items":      page.Items,
+	}))
+}
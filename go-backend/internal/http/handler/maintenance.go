@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"net/http"
+
+	"go-backend/internal/http/response"
+)
+
+// adminRunMaintenance runs the orphan cleanup from the daily maintenance job
+// on demand and reports how many rows it removed.
+func (h *Handler) adminRunMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	removed, err := h.repo.CleanOrphanedFederationBindings()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"orphanedFederationRows": removed,
+	}))
+}
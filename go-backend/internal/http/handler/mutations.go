@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
@@ -17,6 +18,7 @@ import (
 
 	"go-backend/internal/http/client"
 	"go-backend/internal/http/response"
+	"go-backend/internal/network"
 	"go-backend/internal/security"
 	"go-backend/internal/store"
 	"go-backend/internal/store/sqlite"
@@ -28,7 +30,7 @@ func (h *Handler) userCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req map[string]interface{}
-	if err := decodeJSON(r.Body, &req); err != nil {
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
 		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
 		return
 	}
@@ -39,6 +41,10 @@ func (h *Handler) userCreate(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("用户名或密码不能为空"))
 		return
 	}
+	if err := h.passwordPolicy().Check(pwd); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
 
 	db := h.repo.DB()
 	if db == nil {
@@ -64,10 +70,27 @@ func (h *Handler) userCreate(w http.ResponseWriter, r *http.Request) {
 	roleID := 1
 	now := time.Now().UnixMilli()
 
-	_, err := db.Exec(`
-		INSERT INTO user(user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
-		VALUES(?, ?, ?, ?, ?, 0, 0, ?, ?, ?, ?, ?)
-	`, username, security.MD5(pwd), roleID, expTime, flow, flowResetTime, num, now, now, status)
+	passwordHash, err := security.HashPassword(pwd)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	_, err = h.repo.CreateUser(&sqlite.User{
+		User:          username,
+		Pwd:           passwordHash,
+		RoleID:        roleID,
+		ExpTime:       expTime,
+		Flow:          flow,
+		FlowResetTime: flowResetTime,
+		Num:           num,
+		CreatedTime:   now,
+		UpdatedTime:   sql.NullInt64{Int64: now, Valid: true},
+		Status:        status,
+	})
+	if store.IsConflict(err) {
+		response.WriteJSON(w, response.ErrDefault("用户名已存在"))
+		return
+	}
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
@@ -81,7 +104,7 @@ func (h *Handler) userUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req map[string]interface{}
-	if err := decodeJSON(r.Body, &req); err != nil {
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
 		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
 		return
 	}
@@ -103,7 +126,8 @@ func (h *Handler) userUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var roleID int
-	if err := db.QueryRow(`SELECT role_id FROM user WHERE id = ?`, id).Scan(&roleID); err != nil {
+	var permissionMask int64
+	if err := db.QueryRow(`SELECT role_id, COALESCE(permission_mask, 0) FROM user WHERE id = ?`, id).Scan(&roleID, &permissionMask); err != nil {
 		if err == sql.ErrNoRows {
 			response.WriteJSON(w, response.ErrDefault("用户不存在"))
 			return
@@ -115,6 +139,10 @@ func (h *Handler) userUpdate(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("请不要作死"))
 		return
 	}
+	if err := h.checkManageableUser(r, roleID, permissionMask); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
 
 	var cnt int
 	if err := db.QueryRow(`SELECT COUNT(1) FROM user WHERE user = ? AND id != ?`, username, id).Scan(&cnt); err != nil {
@@ -132,6 +160,9 @@ func (h *Handler) userUpdate(w http.ResponseWriter, r *http.Request) {
 	flowResetTime := asInt64(req["flowResetTime"], 1)
 	status := asInt(req["status"], 1)
 	now := time.Now().UnixMilli()
+	if status == 0 {
+		h.pauseUserForwards(id, now)
+	}
 
 	pwd := asString(req["pwd"])
 	if strings.TrimSpace(pwd) == "" {
@@ -145,15 +176,28 @@ func (h *Handler) userUpdate(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
-		_, err := db.Exec(`
+		if err := h.passwordPolicy().Check(pwd); err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+		passwordHash, err := security.HashPassword(pwd)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		_, err = db.Exec(`
 			UPDATE user
 			SET user = ?, pwd = ?, flow = ?, num = ?, exp_time = ?, flow_reset_time = ?, status = ?, updated_time = ?
 			WHERE id = ?
-		`, username, security.MD5(pwd), flow, num, expTime, flowResetTime, status, now, id)
+		`, username, passwordHash, flow, num, expTime, flowResetTime, status, now, id)
 		if err != nil {
 			response.WriteJSON(w, response.Err(-2, err.Error()))
 			return
 		}
+		h.revokeUserSessions(id)
+	}
+	if status == 0 {
+		h.revokeUserSessions(id)
 	}
 
 	_, _ = db.Exec(`UPDATE user_tunnel SET flow = ?, num = ?, exp_time = ?, flow_reset_time = ? WHERE user_id = ?`, flow, num, expTime, flowResetTime, id)
@@ -171,7 +215,8 @@ func (h *Handler) userDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var roleID int
-	if err := h.repo.DB().QueryRow(`SELECT role_id FROM user WHERE id = ?`, id).Scan(&roleID); err != nil {
+	var permissionMask int64
+	if err := h.repo.DB().QueryRow(`SELECT role_id, COALESCE(permission_mask, 0) FROM user WHERE id = ?`, id).Scan(&roleID, &permissionMask); err != nil {
 		if err == sql.ErrNoRows {
 			response.WriteJSON(w, response.ErrDefault("用户不存在"))
 			return
@@ -183,48 +228,17 @@ func (h *Handler) userDelete(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("请不要作死"))
 		return
 	}
-
-	db := h.repo.DB()
-	tx, err := db.Begin()
-	if err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
-		return
-	}
-	defer func() { _ = tx.Rollback() }()
-
-	if _, err = tx.Exec(`DELETE FROM forward_port WHERE forward_id IN (SELECT id FROM forward WHERE user_id = ?)`, id); err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
-		return
-	}
-	if _, err = tx.Exec(`DELETE FROM forward WHERE user_id = ?`, id); err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
-		return
-	}
-	if _, err = tx.Exec(`DELETE FROM group_permission_grant WHERE user_tunnel_id IN (SELECT id FROM user_tunnel WHERE user_id = ?)`, id); err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
-		return
-	}
-	if _, err = tx.Exec(`DELETE FROM user_tunnel WHERE user_id = ?`, id); err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
-		return
-	}
-	if _, err = tx.Exec(`DELETE FROM user_group_user WHERE user_id = ?`, id); err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
-		return
-	}
-	if _, err = tx.Exec(`DELETE FROM statistics_flow WHERE user_id = ?`, id); err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
-		return
-	}
-	if _, err = tx.Exec(`DELETE FROM user WHERE id = ?`, id); err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
+	if err := h.checkManageableUser(r, roleID, permissionMask); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
 		return
 	}
 
-	if err = tx.Commit(); err != nil {
+	h.deleteUserForwardServices(id)
+	if err := h.repo.DeleteUserCascade(id); err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
+	h.writeAuditLog(r, "user_delete", "user", id, "")
 	response.WriteJSON(w, response.OKEmpty())
 }
 
@@ -234,7 +248,7 @@ func (h *Handler) userResetFlow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req map[string]interface{}
-	if err := decodeJSON(r.Body, &req); err != nil {
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
 		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
 		return
 	}
@@ -246,6 +260,26 @@ func (h *Handler) userResetFlow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	db := h.repo.DB()
+	// type 1 resets a user, type 2 a single user_tunnel row.
+	owner := `SELECT role_id, COALESCE(permission_mask, 0) FROM user WHERE id = ?`
+	if typeVal == 2 {
+		owner = `SELECT u.role_id, COALESCE(u.permission_mask, 0) FROM user_tunnel ut JOIN user u ON u.id = ut.user_id WHERE ut.id = ?`
+	}
+	var roleID int
+	var permissionMask int64
+	if err := db.QueryRow(owner, id).Scan(&roleID, &permissionMask); err != nil {
+		if err == sql.ErrNoRows {
+			response.WriteJSON(w, response.ErrDefault("用户不存在"))
+			return
+		}
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err := h.checkManageableUser(r, roleID, permissionMask); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+
 	if typeVal == 1 {
 		_, _ = db.Exec(`UPDATE user SET in_flow = 0, out_flow = 0, updated_time = ? WHERE id = ?`, time.Now().UnixMilli(), id)
 		_, _ = db.Exec(`UPDATE user_tunnel SET in_flow = 0, out_flow = 0 WHERE user_id = ?`, id)
@@ -271,16 +305,32 @@ func (h *Handler) nodeCreate(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("节点名称和地址不能为空"))
 		return
 	}
+	if err := validateNodeInput(req); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	secret, _ := req["secret"].(string)
+	if secret == "" {
+		generated, err := security.GenerateNodeSecret()
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		secret = generated
+	} else if err := security.ValidateNodeSecret(secret); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
 
 	db := h.repo.DB()
 	now := time.Now().UnixMilli()
 	inx := nextIndex(db, "node")
-	_, err := db.Exec(`
+	id, err := db.ExecReturningID(`
 		INSERT INTO node(name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx, is_remote, remote_url, remote_token, remote_config)
 		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		name,
-		randomToken(16),
+		h.repo.SealSecret(secret),
 		serverIP,
 		nullableText(asString(req["serverIpV4"])),
 		nullableText(asString(req["serverIpV6"])),
@@ -298,14 +348,20 @@ func (h *Handler) nodeCreate(w http.ResponseWriter, r *http.Request) {
 		inx,
 		asInt(req["isRemote"], 0),
 		nullableText(asString(req["remoteUrl"])),
-		nullableText(asString(req["remoteToken"])),
+		nullableText(h.repo.SealSecret(asString(req["remoteToken"]))),
 		nullableText(asString(req["remoteConfig"])),
 	)
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
-	response.WriteJSON(w, response.OKEmpty())
+	// The install command carries the secret, so the node can be set up
+	// without a second call. It is left out until the panel address is set.
+	data := map[string]interface{}{"id": id}
+	if cmd, err := h.nodeInstallCommand(secret); err == nil {
+		data["installCommand"] = cmd
+	}
+	response.WriteJSON(w, response.OK(data))
 }
 
 func (h *Handler) nodeUpdate(w http.ResponseWriter, r *http.Request) {
@@ -323,6 +379,10 @@ func (h *Handler) nodeUpdate(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
 		return
 	}
+	if err := validateNodeInput(req); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
 
 	var currentStatus int
 	var currentHTTP int
@@ -337,6 +397,14 @@ func (h *Handler) nodeUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	newSecret, _ := req["secret"].(string)
+	if newSecret != "" {
+		if err := security.ValidateNodeSecret(newSecret); err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+	}
+
 	newHTTP := asInt(req["http"], currentHTTP)
 	newTLS := asInt(req["tls"], currentTLS)
 	newSocks := asInt(req["socks"], currentSocks)
@@ -371,6 +439,12 @@ func (h *Handler) nodeUpdate(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
+	if newSecret != "" {
+		if err := h.updateNodeSecret(id, newSecret); err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+	}
 	response.WriteJSON(w, response.OKEmpty())
 }
 
@@ -383,6 +457,15 @@ func (h *Handler) nodeDelete(w http.ResponseWriter, r *http.Request) {
 	if id <= 0 {
 		return
 	}
+	var exists int
+	if err := h.repo.DB().QueryRow(`SELECT COUNT(1) FROM node WHERE id = ?`, id).Scan(&exists); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if exists == 0 {
+		response.WriteJSON(w, response.ErrDefault("节点不存在"))
+		return
+	}
 	if err := h.deleteNodeByID(id); err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
@@ -405,16 +488,20 @@ func (h *Handler) nodeInstall(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("节点不存在"))
 		return
 	}
-	var panelAddr string
-	if err := db.QueryRow(`SELECT value FROM vite_config WHERE name = 'ip' LIMIT 1`).Scan(&panelAddr); err != nil {
-		if err == sql.ErrNoRows {
-			response.WriteJSON(w, response.ErrDefault("请先前往网站配置中设置ip"))
-			return
-		}
+	secret, err := h.repo.OpenSecret(secret)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	cmd, err := h.nodeInstallCommand(secret)
+	if errors.Is(err, errNoPanelAddress) {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
-	cmd := fmt.Sprintf("curl -L https://gcode.hostcentral.cc/https://github.com/Sagit-chu/flvx/releases/latest/download/install.sh -o ./install.sh && chmod +x ./install.sh && ./install.sh -a %s -s %s", processServerAddress(panelAddr), secret)
 	response.WriteJSON(w, response.OK(cmd))
 }
 
@@ -497,6 +584,11 @@ func (h *Handler) tunnelCreate(w http.ResponseWriter, r *http.Request) {
 	status := asInt(req["status"], 1)
 	trafficRatio := asFloat(req["trafficRatio"], 1.0)
 	inIP := asString(req["inIp"])
+	dscpMark := asInt(req["dscpMark"], 0)
+	if err := network.ValidateDSCP(dscpMark); err != nil {
+		response.WriteJSON(w, response.ErrDefault("DSCP标记必须在0-63之间"))
+		return
+	}
 	now := time.Now().UnixMilli()
 	inx := nextIndex(h.repo.DB(), "tunnel")
 
@@ -506,8 +598,9 @@ func (h *Handler) tunnelCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer func() { _ = tx.Rollback() }()
+	ctx := store.WithTx(r.Context(), tx)
 
-	runtimeState, err := h.prepareTunnelCreateState(tx, req, typeVal, 0)
+	runtimeState, err := h.prepareTunnelCreateState(ctx, req, typeVal, 0)
 	if err != nil {
 		response.WriteJSON(w, response.ErrDefault(err.Error()))
 		return
@@ -521,7 +614,8 @@ func (h *Handler) tunnelCreate(w http.ResponseWriter, r *http.Request) {
 		var isRemote int
 		var rUrl, rToken sql.NullString
 		if err := h.repo.DB().QueryRow("SELECT is_remote, remote_url, remote_token FROM node WHERE id = ?", firstNodeID).Scan(&isRemote, &rUrl, &rToken); err == nil && isRemote == 1 {
-			fc := client.NewFederationClient()
+			rToken.String, _ = h.repo.OpenSecret(rToken.String)
+			fc := client.NewFederationClient().WithConsumerID(h.federationConsumerID())
 
 			targetProto := "tcp"
 			targetPort := 0
@@ -560,8 +654,8 @@ func (h *Handler) tunnelCreate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	tunnelID, err := tx.ExecReturningID(`INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		name, trafficRatio, typeVal, "tls", flow, now, now, status, nullableText(inIP), inx)
+	tunnelID, err := tx.ExecReturningID(`INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx, dscp_mark) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		name, trafficRatio, typeVal, "tls", flow, now, now, status, nullableText(inIP), inx, dscpMark)
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
@@ -577,12 +671,12 @@ func (h *Handler) tunnelCreate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	applyTunnelPortsToRequest(req, runtimeState)
-	if err := replaceTunnelChainsTx(tx, tunnelID, req); err != nil {
+	if err := replaceTunnelChainsTx(tx, tunnelID, typeVal, req); err != nil {
 		h.releaseFederationRuntimeRefs(federationReleaseRefs)
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
-	if err := replaceFederationTunnelBindingsTx(tx, tunnelID, federationBindings); err != nil {
+	if err := h.replaceFederationTunnelBindings(ctx, tunnelID, federationBindings); err != nil {
 		h.releaseFederationRuntimeRefs(federationReleaseRefs)
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
@@ -668,9 +762,26 @@ func (h *Handler) tunnelUpdate(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("隧道ID不能为空"))
 		return
 	}
+	tunnel, err := h.getTunnelRecord(id)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("隧道不存在"))
+		return
+	}
+	// Clients that do not know about DSCP leave the stored mark alone.
+	dscpMark := asInt(req["dscpMark"], tunnel.DSCPMark)
+	if err := network.ValidateDSCP(dscpMark); err != nil {
+		response.WriteJSON(w, response.ErrDefault("DSCP标记必须在0-63之间"))
+		return
+	}
+	dscpChanged := dscpMark != tunnel.DSCPMark
 
-	h.cleanupTunnelRuntime(id)
-	h.cleanupFederationRuntime(id)
+	// Compare against the deployed layout so hops that stay the same keep
+	// running. Without it, fall back to redeploying the whole tunnel.
+	oldState, err := h.reconstructTunnelState(id)
+	if err != nil {
+		h.cleanupTunnelRuntime(id)
+		oldState = nil
+	}
 
 	now := time.Now().UnixMilli()
 	typeVal := asInt(req["type"], 1)
@@ -681,8 +792,9 @@ func (h *Handler) tunnelUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer func() { _ = tx.Rollback() }()
+	ctx := store.WithTx(r.Context(), tx)
 
-	runtimeState, err := h.prepareTunnelCreateState(tx, req, typeVal, id)
+	runtimeState, err := h.prepareTunnelCreateState(ctx, req, typeVal, id)
 	if err != nil {
 		response.WriteJSON(w, response.ErrDefault(err.Error()))
 		return
@@ -690,20 +802,26 @@ func (h *Handler) tunnelUpdate(w http.ResponseWriter, r *http.Request) {
 	runtimeState.TunnelID = id
 
 	inIp := buildTunnelInIP(runtimeState.InNodes, runtimeState.Nodes)
+	diff := diffTunnelRuntime(oldState, runtimeState)
+	entryChanged := tunnelEntryNodesChanged(oldState, runtimeState)
+	layoutChanged := entryChanged || !diff.empty()
 
 	var federationBindings []sqlite.FederationTunnelBinding
 	var federationReleaseRefs []federationRuntimeReleaseRef
-	if typeVal == 2 {
-		federationBindings, federationReleaseRefs, err = h.applyFederationRuntime(runtimeState)
-		if err != nil {
-			response.WriteJSON(w, response.ErrDefault(err.Error()))
-			return
+	if layoutChanged {
+		h.cleanupFederationRuntime(id)
+		if typeVal == 2 {
+			federationBindings, federationReleaseRefs, err = h.applyFederationRuntime(runtimeState)
+			if err != nil {
+				response.WriteJSON(w, response.ErrDefault(err.Error()))
+				return
+			}
 		}
 	}
 	applyTunnelPortsToRequest(req, runtimeState)
 
-	_, err = tx.Exec(`UPDATE tunnel SET name=?, type=?, flow=?, traffic_ratio=?, status=?, in_ip=?, updated_time=? WHERE id=?`,
-		asString(req["name"]), typeVal, asInt64(req["flow"], 1), asFloat(req["trafficRatio"], 1.0), asInt(req["status"], 1), nullableText(inIp), now, id)
+	_, err = tx.Exec(`UPDATE tunnel SET name=?, type=?, flow=?, traffic_ratio=?, status=?, in_ip=?, dscp_mark=?, updated_time=? WHERE id=?`,
+		asString(req["name"]), typeVal, asInt64(req["flow"], 1), asFloat(req["trafficRatio"], 1.0), asInt(req["status"], 1), nullableText(inIp), dscpMark, now, id)
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
@@ -713,37 +831,64 @@ func (h *Handler) tunnelUpdate(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
-	if err := replaceTunnelChainsTx(tx, id, req); err != nil {
+	if err := replaceTunnelChainsTx(tx, id, typeVal, req); err != nil {
 		h.releaseFederationRuntimeRefs(federationReleaseRefs)
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
-	if err := replaceFederationTunnelBindingsTx(tx, id, federationBindings); err != nil {
-		h.releaseFederationRuntimeRefs(federationReleaseRefs)
-		response.WriteJSON(w, response.Err(-2, err.Error()))
-		return
+	if layoutChanged {
+		if err := h.replaceFederationTunnelBindings(ctx, id, federationBindings); err != nil {
+			h.releaseFederationRuntimeRefs(federationReleaseRefs)
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
 	}
 	if err := tx.Commit(); err != nil {
 		h.releaseFederationRuntimeRefs(federationReleaseRefs)
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
+	if !layoutChanged {
+		if dscpChanged {
+			// The mark is part of every forward service on the tunnel.
+			h.resendTunnelForwards(id, h.tunnelForwards(id))
+		}
+		response.WriteJSON(w, response.OKEmpty())
+		return
+	}
 
+	h.cleanupTunnelNodes(oldState, append(diff.Removed, diff.Changed...))
+	// Forwards listen on the entry nodes; when those change, move the
+	// forwards over with their ports and re-send them below.
+	var forwards []forwardRecord
+	if entryChanged {
+		forwards = h.detachTunnelForwards(id)
+	} else if dscpChanged {
+		forwards = h.tunnelForwards(id)
+	}
+
+	var applyErr error
 	if typeVal == 2 {
-		createdChains, createdServices, applyErr := h.applyTunnelRuntime(runtimeState)
+		var only map[int64]struct{}
+		if oldState != nil {
+			only = make(map[int64]struct{}, len(diff.Changed))
+			for _, nodeID := range diff.Changed {
+				only[nodeID] = struct{}{}
+			}
+		}
+		var createdChains, createdServices []int64
+		createdChains, createdServices, applyErr = h.applyTunnelRuntimeNodes(runtimeState, only)
 		if applyErr != nil {
 			h.rollbackTunnelRuntime(createdChains, createdServices, id)
 			h.releaseFederationRuntimeRefs(federationReleaseRefs)
 			_ = h.repo.DeleteFederationTunnelBindingsByTunnel(id)
-			if len(federationReleaseRefs) == 0 && shouldDeferTunnelRuntimeApplyError(applyErr) {
-				response.WriteJSON(w, response.OKEmpty())
-				return
-			}
-			response.WriteJSON(w, response.ErrDefault(applyErr.Error()))
-			return
 		}
 	}
-
+	if applyErr != nil && (len(federationReleaseRefs) > 0 || !shouldDeferTunnelRuntimeApplyError(applyErr)) {
+		response.WriteJSON(w, response.ErrDefault(applyErr.Error()))
+		return
+	}
+	h.resendTunnelForwards(id, forwards)
 	response.WriteJSON(w, response.OKEmpty())
 }
 
@@ -762,6 +907,8 @@ func (h *Handler) tunnelDelete(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
+	// Safety net for bindings left behind by rows deleted outside the API.
+	_, _ = h.repo.CleanOrphanedFederationBindings()
 	response.WriteJSON(w, response.OKEmpty())
 }
 
@@ -854,7 +1001,7 @@ func (h *Handler) reconstructTunnelState(tunnelID int64) (*tunnelCreateState, er
 			NodeID:    r.NodeID,
 			Protocol:  r.Protocol,
 			Strategy:  r.Strategy,
-			ChainType: 1,
+			ChainType: r.ChainType,
 		})
 		state.NodeIDList = append(state.NodeIDList, r.NodeID)
 	}
@@ -935,7 +1082,7 @@ func (h *Handler) tunnelBatchRedeploy(w http.ResponseWriter, r *http.Request) {
 				fail++
 				continue
 			}
-			if replaceErr := replaceFederationTunnelBindingsTx(tx, tunnelID, federationBindings); replaceErr != nil {
+			if replaceErr := h.replaceFederationTunnelBindings(store.WithTx(r.Context(), tx), tunnelID, federationBindings); replaceErr != nil {
 				_ = tx.Rollback()
 				h.releaseFederationRuntimeRefs(federationReleaseRefs)
 				fail++
@@ -1078,31 +1225,24 @@ func (h *Handler) forwardCreate(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
 		return
 	}
-	tunnelID := asInt64(req["tunnelId"], 0)
-	if tunnelID <= 0 {
-		response.WriteJSON(w, response.ErrDefault("隧道ID不能为空"))
-		return
-	}
-	if err := h.ensureTunnelPermission(userID, roleID, tunnelID); err != nil {
-		response.WriteJSON(w, response.ErrDefault(err.Error()))
-		return
-	}
-	tunnel, err := h.getTunnelRecord(tunnelID)
+	in, err := h.parseForwardCreateInput(req, userID, roleID)
 	if err != nil {
-		response.WriteJSON(w, response.ErrDefault("隧道不存在"))
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
 		return
 	}
-	if tunnel.Status != 1 {
-		response.WriteJSON(w, response.ErrDefault("隧道已禁用，无法创建转发"))
+	if err := h.checkGroupTunnelQuota(userID, in.TunnelID); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
 		return
 	}
-	name := asString(req["name"])
-	remoteAddr := asString(req["remoteAddr"])
-	if name == "" || remoteAddr == "" {
-		response.WriteJSON(w, response.ErrDefault("转发名称和目标地址不能为空"))
-		return
+	tunnelID := in.TunnelID
+	port := in.InPort
+	if port > 0 {
+		entryNodes, _ := h.tunnelEntryNodeIDs(tunnelID)
+		if err := h.checkPortReservation(entryNodes, port); err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
 	}
-	port := asInt(req["inPort"], 0)
 	if port <= 0 {
 		port = h.pickTunnelPort(tunnelID)
 	}
@@ -1111,21 +1251,14 @@ func (h *Handler) forwardCreate(w http.ResponseWriter, r *http.Request) {
 	}
 	now := time.Now().UnixMilli()
 	inx := nextIndex(h.repo.DB(), "forward")
-	var userName string
-	_ = h.repo.DB().QueryRow(`SELECT user FROM user WHERE id = ?`, userID).Scan(&userName)
-	if userName == "" {
-		userName = "user"
-	}
+	userName := h.forwardOwnerName(userID)
 	tx, err := h.repo.DB().Begin()
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
 	defer func() { _ = tx.Rollback() }()
-	forwardID, err := tx.ExecReturningID(`
-		INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
-		VALUES(?, ?, ?, ?, ?, ?, 0, 0, ?, ?, 1, ?)
-	`, userID, userName, name, tunnelID, remoteAddr, defaultString(asString(req["strategy"]), "fifo"), now, now, inx)
+	forwardID, err := insertForwardTx(tx, in, userName, inx, now)
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
@@ -1151,6 +1284,85 @@ func (h *Handler) forwardCreate(w http.ResponseWriter, r *http.Request) {
 	response.WriteJSON(w, response.OKEmpty())
 }
 
+// forwardCreateInput holds the validated fields of a forward create request.
+type forwardCreateInput struct {
+	UserID         int64
+	TunnelID       int64
+	Name           string
+	RemoteAddr     string
+	Strategy       string
+	Protocol       string
+	DNSServer      string
+	IdleTimeoutSec int
+	InPort         int
+}
+
+// parseForwardCreateInput validates a forward create request on behalf of
+// userID. It is shared by single and batch creation.
+func (h *Handler) parseForwardCreateInput(req map[string]interface{}, userID int64, roleID int) (*forwardCreateInput, error) {
+	tunnelID := asInt64(req["tunnelId"], 0)
+	if tunnelID <= 0 {
+		return nil, errors.New("隧道ID不能为空")
+	}
+	if err := h.ensureTunnelPermission(userID, roleID, tunnelID); err != nil {
+		return nil, err
+	}
+	tunnel, err := h.getTunnelRecord(tunnelID)
+	if err != nil {
+		return nil, errors.New("隧道不存在")
+	}
+	if tunnel.Status != 1 {
+		return nil, errors.New("隧道已禁用，无法创建转发")
+	}
+	name := asString(req["name"])
+	remoteAddr := asString(req["remoteAddr"])
+	if name == "" || remoteAddr == "" {
+		return nil, errors.New("转发名称和目标地址不能为空")
+	}
+	// Clients that predate the protocol field expect both TCP and UDP.
+	protocol := normalizeForwardProtocol(defaultString(asString(req["protocol"]), "both"))
+	if protocol == "" {
+		return nil, errors.New("转发协议仅支持 tcp、udp 或 both")
+	}
+	dnsServer := asString(req["dnsServer"])
+	if dnsServer != "" {
+		if err := network.ValidateDNSAddress(dnsServer); err != nil {
+			return nil, errors.New("DNS服务器地址格式错误，应为 ip:port 或 [ipv6]:port")
+		}
+	}
+	idleTimeoutSec := asInt(req["idleTimeoutSec"], 0)
+	if idleTimeoutSec < 0 || idleTimeoutSec > maxForwardIdleTimeoutSec {
+		return nil, errors.New("空闲超时需在 0 到 86400 秒之间")
+	}
+	return &forwardCreateInput{
+		UserID:         userID,
+		TunnelID:       tunnelID,
+		Name:           name,
+		RemoteAddr:     remoteAddr,
+		Strategy:       defaultString(asString(req["strategy"]), "fifo"),
+		Protocol:       protocol,
+		DNSServer:      dnsServer,
+		IdleTimeoutSec: idleTimeoutSec,
+		InPort:         asInt(req["inPort"], 0),
+	}, nil
+}
+
+func (h *Handler) forwardOwnerName(userID int64) string {
+	var userName string
+	_ = h.repo.DB().QueryRow(`SELECT user FROM user WHERE id = ?`, userID).Scan(&userName)
+	if userName == "" {
+		userName = "user"
+	}
+	return userName
+}
+
+func insertForwardTx(tx *store.Tx, in *forwardCreateInput, userName string, inx int, now int64) (int64, error) {
+	return tx.ExecReturningID(`
+		INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, protocol, dns_server, idle_timeout_sec, in_flow, out_flow, created_time, updated_time, status, inx, service_revision)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0, ?, ?, 1, ?, 1)
+	`, in.UserID, userName, in.Name, in.TunnelID, in.RemoteAddr, in.Strategy, in.Protocol, nullableText(in.DNSServer), in.IdleTimeoutSec, now, now, inx)
+}
+
 func (h *Handler) forwardUpdate(w http.ResponseWriter, r *http.Request) {
 	var req map[string]interface{}
 	if err := decodeJSON(r.Body, &req); err != nil {
@@ -1195,6 +1407,12 @@ func (h *Handler) forwardUpdate(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.ErrDefault("隧道已禁用，无法更新转发"))
 		return
 	}
+	if tunnelID != forward.TunnelID {
+		if err := h.checkGroupTunnelQuota(forward.UserID, tunnelID); err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+	}
 
 	name := strings.TrimSpace(asString(req["name"]))
 	if name == "" {
@@ -1208,11 +1426,39 @@ func (h *Handler) forwardUpdate(w http.ResponseWriter, r *http.Request) {
 	if strategy == "" {
 		strategy = forward.Strategy
 	}
+	protocol := normalizeForwardProtocol(defaultString(asString(req["protocol"]), forward.Protocol))
+	if protocol == "" {
+		response.WriteJSON(w, response.ErrDefault("转发协议仅支持 tcp、udp 或 both"))
+		return
+	}
+	dnsServer := forward.DNSServer
+	if _, ok := req["dnsServer"]; ok {
+		dnsServer = asString(req["dnsServer"])
+		if dnsServer != "" {
+			if err := network.ValidateDNSAddress(dnsServer); err != nil {
+				response.WriteJSON(w, response.ErrDefault("DNS服务器地址格式错误，应为 ip:port 或 [ipv6]:port"))
+				return
+			}
+		}
+	}
+
+	idleTimeoutSec := asInt(req["idleTimeoutSec"], forward.IdleTimeoutSec)
+	if idleTimeoutSec < 0 || idleTimeoutSec > maxForwardIdleTimeoutSec {
+		response.WriteJSON(w, response.ErrDefault("空闲超时需在 0 到 86400 秒之间"))
+		return
+	}
 
+	var minPort sql.NullInt64
+	_ = h.repo.DB().QueryRow(`SELECT MIN(port) FROM forward_port WHERE forward_id = ?`, id).Scan(&minPort)
 	port := asInt(req["inPort"], 0)
+	if port > 0 && (!minPort.Valid || int(minPort.Int64) != port || tunnelID != forward.TunnelID) {
+		entryNodes, _ := h.tunnelEntryNodeIDs(tunnelID)
+		if err := h.checkPortReservation(entryNodes, port); err != nil {
+			response.WriteJSON(w, response.ErrDefault(err.Error()))
+			return
+		}
+	}
 	if port <= 0 {
-		var minPort sql.NullInt64
-		_ = h.repo.DB().QueryRow(`SELECT MIN(port) FROM forward_port WHERE forward_id = ?`, id).Scan(&minPort)
 		if minPort.Valid {
 			port = int(minPort.Int64)
 		}
@@ -1222,8 +1468,8 @@ func (h *Handler) forwardUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 	now := time.Now().UnixMilli()
 	_, err = h.repo.DB().Exec(`
-		UPDATE forward SET name = ?, tunnel_id = ?, remote_addr = ?, strategy = ?, updated_time = ? WHERE id = ?
-	`, name, tunnelID, remoteAddr, strategy, now, id)
+		UPDATE forward SET name = ?, tunnel_id = ?, remote_addr = ?, strategy = ?, protocol = ?, dns_server = ?, idle_timeout_sec = ?, updated_time = ? WHERE id = ?
+	`, name, tunnelID, remoteAddr, strategy, protocol, nullableText(dnsServer), idleTimeoutSec, now, id)
 	if err != nil {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
@@ -1239,6 +1485,11 @@ func (h *Handler) forwardUpdate(w http.ResponseWriter, r *http.Request) {
 		response.WriteJSON(w, response.Err(-2, err.Error()))
 		return
 	}
+	if normalizeForwardProtocol(forward.Protocol) != protocol {
+		// Listeners for a protocol that is no longer served would otherwise
+		// linger on the node, so drop the old set before re-adding.
+		_ = h.controlForwardServices(forward, "DeleteService", true)
+	}
 	if err := h.syncForwardServices(updatedForward, "UpdateService", true); err != nil {
 		h.rollbackForwardMutation(forward, oldPorts)
 		response.WriteJSON(w, response.ErrDefault(err.Error()))
@@ -1363,37 +1614,6 @@ func (h *Handler) forwardUpdateOrder(w http.ResponseWriter, r *http.Request) {
 	response.WriteJSON(w, response.OKEmpty())
 }
 
-func (h *Handler) forwardBatchDelete(w http.ResponseWriter, r *http.Request) {
-	ids := idsFromBody(r, w)
-	if ids == nil {
-		return
-	}
-	actorUserID, actorRole, err := userRoleFromRequest(r)
-	if err != nil {
-		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
-		return
-	}
-	s := 0
-	f := 0
-	for _, id := range ids {
-		forward, accessErr := h.ensureForwardAccessByActor(actorUserID, actorRole, id)
-		if accessErr != nil {
-			f++
-			continue
-		}
-		if err := h.controlForwardServices(forward, "DeleteService", true); err != nil {
-			f++
-			continue
-		}
-		if err := h.deleteForwardByID(id); err != nil {
-			f++
-		} else {
-			s++
-		}
-	}
-	response.WriteJSON(w, response.OK(map[string]interface{}{"successCount": s, "failCount": f}))
-}
-
 func (h *Handler) forwardBatchPause(w http.ResponseWriter, r *http.Request) {
 	ids := idsFromBody(r, w)
 	if ids == nil {
@@ -1525,6 +1745,10 @@ func (h *Handler) forwardBatchChangeTunnel(w http.ResponseWriter, r *http.Reques
 			fail++
 			continue
 		}
+		if err := h.checkGroupTunnelQuota(forward.UserID, req.TargetTunnelID); err != nil {
+			fail++
+			continue
+		}
 		oldPorts, listPortsErr := h.listForwardPorts(id)
 		if listPortsErr != nil {
 			fail++
@@ -1565,88 +1789,6 @@ func (h *Handler) forwardBatchChangeTunnel(w http.ResponseWriter, r *http.Reques
 	response.WriteJSON(w, response.OK(map[string]interface{}{"successCount": success, "failCount": fail}))
 }
 
-func (h *Handler) speedLimitCreate(w http.ResponseWriter, r *http.Request) {
-	var req map[string]interface{}
-	if err := decodeJSON(r.Body, &req); err != nil {
-		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
-		return
-	}
-	tunnelID := asInt64(req["tunnelId"], 0)
-	if tunnelID <= 0 {
-		response.WriteJSON(w, response.ErrDefault("隧道ID不能为空"))
-		return
-	}
-	name := asString(req["name"])
-	if name == "" {
-		response.WriteJSON(w, response.ErrDefault("名称不能为空"))
-		return
-	}
-	var tunnelName string
-	_ = h.repo.DB().QueryRow(`SELECT name FROM tunnel WHERE id = ?`, tunnelID).Scan(&tunnelName)
-	if tunnelName == "" {
-		response.WriteJSON(w, response.ErrDefault("隧道不存在"))
-		return
-	}
-	now := time.Now().UnixMilli()
-	speed := asInt(req["speed"], 100)
-	id, err := h.repo.DB().ExecReturningID(`INSERT INTO speed_limit(name, speed, tunnel_id, tunnel_name, created_time, updated_time, status) VALUES(?, ?, ?, ?, ?, ?, ?)`,
-		name, speed, tunnelID, tunnelName, now, now, asInt(req["status"], 1))
-	if err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
-		return
-	}
-	_ = h.sendLimiterConfig(id, speed, tunnelID)
-	response.WriteJSON(w, response.OKEmpty())
-}
-
-func (h *Handler) speedLimitUpdate(w http.ResponseWriter, r *http.Request) {
-	var req map[string]interface{}
-	if err := decodeJSON(r.Body, &req); err != nil {
-		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
-		return
-	}
-	id := asInt64(req["id"], 0)
-	tunnelID := asInt64(req["tunnelId"], 0)
-	if id <= 0 || tunnelID <= 0 {
-		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
-		return
-	}
-	var tunnelName string
-	_ = h.repo.DB().QueryRow(`SELECT name FROM tunnel WHERE id = ?`, tunnelID).Scan(&tunnelName)
-	if tunnelName == "" {
-		response.WriteJSON(w, response.ErrDefault("隧道不存在"))
-		return
-	}
-	speed := asInt(req["speed"], 100)
-	_, err := h.repo.DB().Exec(`UPDATE speed_limit SET name=?, speed=?, tunnel_id=?, tunnel_name=?, status=?, updated_time=? WHERE id=?`,
-		asString(req["name"]), speed, tunnelID, tunnelName, asInt(req["status"], 1), time.Now().UnixMilli(), id)
-	if err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
-		return
-	}
-	_ = h.sendLimiterConfig(id, speed, tunnelID)
-	response.WriteJSON(w, response.OKEmpty())
-}
-
-func (h *Handler) speedLimitDelete(w http.ResponseWriter, r *http.Request) {
-	id := idFromBody(r, w)
-	if id <= 0 {
-		return
-	}
-	var tunnelID int64
-	_ = h.repo.DB().QueryRow(`SELECT tunnel_id FROM speed_limit WHERE id = ?`, id).Scan(&tunnelID)
-
-	_, err := h.repo.DB().Exec(`DELETE FROM speed_limit WHERE id = ?`, id)
-	if err != nil {
-		response.WriteJSON(w, response.Err(-2, err.Error()))
-		return
-	}
-	if tunnelID > 0 {
-		_ = h.sendDeleteLimiterConfig(id, tunnelID)
-	}
-	response.WriteJSON(w, response.OKEmpty())
-}
-
 func (h *Handler) groupTunnelCreate(w http.ResponseWriter, r *http.Request) {
 	h.groupCreate(w, r, "tunnel_group")
 }
@@ -1847,6 +1989,7 @@ func (h *Handler) groupDelete(w http.ResponseWriter, r *http.Request, table stri
 		_, _ = tx.Exec(`DELETE FROM user_group_user WHERE user_group_id = ?`, id)
 		_, _ = tx.Exec(`DELETE FROM group_permission WHERE user_group_id = ?`, id)
 		_, _ = tx.Exec(`DELETE FROM group_permission_grant WHERE user_group_id = ?`, id)
+		_, _ = tx.Exec(`DELETE FROM group_tunnel_quota WHERE group_id = ?`, id)
 	}
 	_, _ = tx.Exec(`DELETE FROM `+table+` WHERE id = ?`, id)
 	if err := tx.Commit(); err != nil {
@@ -2116,7 +2259,46 @@ type tunnelCreateState struct {
 	NodeIDList []int64
 }
 
-func (h *Handler) prepareTunnelCreateState(tx *store.Tx, req map[string]interface{}, tunnelType int, excludeTunnelID int64) (*tunnelCreateState, error) {
+const (
+	// tunnelTypeDirect is a tunnel whose single node is both entry and exit.
+	tunnelTypeDirect = 3
+	// chainTypeDirect marks the chain_tunnel row of a direct tunnel's node.
+	chainTypeDirect = 0
+)
+
+func tunnelTypeLabel(tunnelType int) string {
+	switch tunnelType {
+	case 1:
+		return "端口转发"
+	case tunnelTypeDirect:
+		return "直连转发"
+	default:
+		return "隧道转发"
+	}
+}
+
+// validateDirectTunnelRequest checks that a direct tunnel names exactly one
+// node, optionally repeated as its exit, and no chain hops.
+func validateDirectTunnelRequest(req map[string]interface{}, inNodes []tunnelRuntimeNode) error {
+	if len(inNodes) != 1 {
+		return errors.New("直连隧道只能选择一个节点")
+	}
+	for _, item := range asMapSlice(req["outNodeId"]) {
+		if nodeID := asInt64(item["nodeId"], 0); nodeID > 0 && nodeID != inNodes[0].NodeID {
+			return errors.New("直连隧道的出口必须与入口相同")
+		}
+	}
+	for _, hop := range asAnySlice(req["chainNodes"]) {
+		for _, item := range asMapSlice(hop) {
+			if asInt64(item["nodeId"], 0) > 0 {
+				return errors.New("直连隧道不支持转发链")
+			}
+		}
+	}
+	return nil
+}
+
+func (h *Handler) prepareTunnelCreateState(ctx context.Context, req map[string]interface{}, tunnelType int, excludeTunnelID int64) (*tunnelCreateState, error) {
 	state := &tunnelCreateState{
 		Type:      tunnelType,
 		InNodes:   make([]tunnelRuntimeNode, 0),
@@ -2124,8 +2306,13 @@ func (h *Handler) prepareTunnelCreateState(tx *store.Tx, req map[string]interfac
 		OutNodes:  make([]tunnelRuntimeNode, 0),
 		Nodes:     make(map[int64]*nodeRecord),
 	}
+	db := h.repo.ExecerFromCtx(ctx)
 	nodeIDs := make([]int64, 0)
 
+	inChainType := 1
+	if tunnelType == tunnelTypeDirect {
+		inChainType = chainTypeDirect
+	}
 	for _, item := range asMapSlice(req["inNodeId"]) {
 		nodeID := asInt64(item["nodeId"], 0)
 		if nodeID <= 0 {
@@ -2136,35 +2323,48 @@ func (h *Handler) prepareTunnelCreateState(tx *store.Tx, req map[string]interfac
 			NodeID:    nodeID,
 			Protocol:  defaultString(asString(item["protocol"]), "tls"),
 			Strategy:  defaultString(asString(item["strategy"]), "round"),
-			ChainType: 1,
+			ChainType: inChainType,
 		})
 	}
 	if len(state.InNodes) == 0 {
 		return nil, errors.New("入口不能为空")
 	}
 
+	if tunnelType == tunnelTypeDirect {
+		if err := validateDirectTunnelRequest(req, state.InNodes); err != nil {
+			return nil, err
+		}
+	}
+
 	if tunnelType == 2 {
 		outNodesRaw := asMapSlice(req["outNodeId"])
 		if len(outNodesRaw) == 0 {
 			return nil, errors.New("出口不能为空")
 		}
 
-		allocated := map[int64]int{}
+		// Hops that stay in an updated tunnel keep their port.
+		allocated, err := currentTunnelHopPortsTx(db, excludeTunnelID)
+		if err != nil {
+			return nil, err
+		}
 		for _, item := range outNodesRaw {
-			nodeID := asInt64(item["nodeId"], 0)
+			nodeID, err := h.resolveTunnelHopNodeID(db, item, nodeIDs, excludeTunnelID)
+			if err != nil {
+				return nil, err
+			}
 			if nodeID <= 0 {
 				continue
 			}
 			nodeIDs = append(nodeIDs, nodeID)
 			port := asInt(item["port"], 0)
 			if port <= 0 {
-				isRemote, remoteErr := isRemoteNodeTx(tx, nodeID)
+				isRemote, remoteErr := isRemoteNodeTx(db, nodeID)
 				if remoteErr != nil {
 					return nil, remoteErr
 				}
 				if !isRemote {
 					var err error
-					port, err = pickNodePortTx(tx, nodeID, allocated, excludeTunnelID)
+					port, err = pickNodePortTx(db, nodeID, allocated, excludeTunnelID)
 					if err != nil {
 						return nil, err
 					}
@@ -2185,20 +2385,23 @@ func (h *Handler) prepareTunnelCreateState(tx *store.Tx, req map[string]interfac
 		for hopIdx, hopRaw := range asAnySlice(req["chainNodes"]) {
 			hop := make([]tunnelRuntimeNode, 0)
 			for _, item := range asMapSlice(hopRaw) {
-				nodeID := asInt64(item["nodeId"], 0)
+				nodeID, err := h.resolveTunnelHopNodeID(db, item, nodeIDs, excludeTunnelID)
+				if err != nil {
+					return nil, err
+				}
 				if nodeID <= 0 {
 					continue
 				}
 				nodeIDs = append(nodeIDs, nodeID)
 				port := asInt(item["port"], 0)
 				if port <= 0 {
-					isRemote, remoteErr := isRemoteNodeTx(tx, nodeID)
+					isRemote, remoteErr := isRemoteNodeTx(db, nodeID)
 					if remoteErr != nil {
 						return nil, remoteErr
 					}
 					if !isRemote {
 						var err error
-						port, err = pickNodePortTx(tx, nodeID, allocated, excludeTunnelID)
+						port, err = pickNodePortTx(db, nodeID, allocated, excludeTunnelID)
 						if err != nil {
 							return nil, err
 						}
@@ -2236,6 +2439,9 @@ func (h *Handler) prepareTunnelCreateState(tx *store.Tx, req map[string]interfac
 		if node.IsRemote != 1 && node.Status != 1 {
 			return nil, errors.New("部分节点不在线")
 		}
+		if tunnelType == tunnelTypeDirect && node.IsRemote == 1 {
+			return nil, errors.New("直连隧道不支持远程节点")
+		}
 		state.Nodes[nodeID] = node
 	}
 
@@ -2337,13 +2543,21 @@ func (h *Handler) federationLocalDomain() string {
 	return strings.TrimSpace(cfg.Value)
 }
 
+func (h *Handler) federationConsumerID() string {
+	cfg, _ := h.repo.GetConfigByName(sqlite.PanelIDConfigKey)
+	if cfg == nil {
+		return ""
+	}
+	return strings.TrimSpace(cfg.Value)
+}
+
 func (h *Handler) applyFederationRuntime(state *tunnelCreateState) ([]sqlite.FederationTunnelBinding, []federationRuntimeReleaseRef, error) {
 	bindings := make([]sqlite.FederationTunnelBinding, 0)
 	releaseRefs := make([]federationRuntimeReleaseRef, 0)
 	if h == nil || state == nil || state.Type != 2 {
 		return bindings, releaseRefs, nil
 	}
-	fc := client.NewFederationClient()
+	fc := client.NewFederationClient().WithConsumerID(h.federationConsumerID())
 	localDomain := h.federationLocalDomain()
 	now := time.Now().UnixMilli()
 
@@ -2526,7 +2740,7 @@ func (h *Handler) releaseFederationRuntimeRefs(refs []federationRuntimeReleaseRe
 	if h == nil || len(refs) == 0 {
 		return
 	}
-	fc := client.NewFederationClient()
+	fc := client.NewFederationClient().WithConsumerID(h.federationConsumerID())
 	localDomain := h.federationLocalDomain()
 	for i := len(refs) - 1; i >= 0; i-- {
 		ref := refs[i]
@@ -2551,7 +2765,7 @@ func (h *Handler) cleanupFederationRuntime(tunnelID int64) {
 		return
 	}
 
-	fc := client.NewFederationClient()
+	fc := client.NewFederationClient().WithConsumerID(h.federationConsumerID())
 	localDomain := h.federationLocalDomain()
 	for _, b := range bindings {
 		node, nodeErr := h.repo.GetNodeByID(b.NodeID)
@@ -2575,27 +2789,21 @@ func (h *Handler) cleanupFederationRuntime(tunnelID int64) {
 	_ = h.repo.DeleteFederationTunnelBindingsByTunnel(tunnelID)
 }
 
-func replaceFederationTunnelBindingsTx(tx *store.Tx, tunnelID int64, bindings []sqlite.FederationTunnelBinding) error {
-	if tx == nil {
-		return errors.New("database unavailable")
-	}
-	if _, err := tx.Exec(`DELETE FROM federation_tunnel_binding WHERE tunnel_id = ?`, tunnelID); err != nil {
+// replaceFederationTunnelBindings swaps the federation bindings of a tunnel
+// for bindings, inside the transaction carried by ctx when there is one.
+func (h *Handler) replaceFederationTunnelBindings(ctx context.Context, tunnelID int64, bindings []sqlite.FederationTunnelBinding) error {
+	if err := h.repo.DeleteFederationTunnelBindingsByTunnelTx(ctx, tunnelID); err != nil {
 		return err
 	}
 	for _, b := range bindings {
-		created := b.CreatedTime
-		if created <= 0 {
-			created = time.Now().UnixMilli()
-		}
-		updated := b.UpdatedTime
-		if updated <= 0 {
-			updated = created
-		}
-		_, err := tx.Exec(`
-			INSERT INTO federation_tunnel_binding(tunnel_id, node_id, chain_type, hop_inx, remote_url, resource_key, remote_binding_id, allocated_port, status, created_time, updated_time)
-			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, tunnelID, b.NodeID, b.ChainType, b.HopInx, b.RemoteURL, b.ResourceKey, b.RemoteBindingID, b.AllocatedPort, b.Status, created, updated)
-		if err != nil {
+		b.TunnelID = tunnelID
+		if b.CreatedTime <= 0 {
+			b.CreatedTime = time.Now().UnixMilli()
+		}
+		if b.UpdatedTime <= 0 {
+			b.UpdatedTime = b.CreatedTime
+		}
+		if err := h.repo.UpsertFederationTunnelBindingTx(ctx, &b); err != nil {
 			return err
 		}
 	}
@@ -2603,16 +2811,34 @@ func replaceFederationTunnelBindingsTx(tx *store.Tx, tunnelID int64, bindings []
 }
 
 func (h *Handler) applyTunnelRuntime(state *tunnelCreateState) ([]int64, []int64, error) {
+	return h.applyTunnelRuntimeNodes(state, nil)
+}
+
+// applyTunnelRuntimeNodes deploys the tunnel's chains and relay services on
+// the nodes in only, or on every node when only is nil.
+func (h *Handler) applyTunnelRuntimeNodes(state *tunnelCreateState, only map[int64]struct{}) ([]int64, []int64, error) {
 	if h == nil || state == nil {
 		return nil, nil, errors.New("invalid tunnel runtime state")
 	}
 	createdChains := make([]int64, 0)
 	createdServices := make([]int64, 0)
+	// Port-forward and direct tunnels have no chain: their forwards run as
+	// single-node services on the entry node, so there is nothing to deploy.
 	if state.Type != 2 {
 		return createdChains, createdServices, nil
 	}
+	skip := func(nodeID int64) bool {
+		if only == nil {
+			return false
+		}
+		_, ok := only[nodeID]
+		return !ok
+	}
 
 	for _, inNode := range state.InNodes {
+		if skip(inNode.NodeID) {
+			continue
+		}
 		node := state.Nodes[inNode.NodeID]
 		targets := state.OutNodes
 		if len(state.ChainHops) > 0 {
@@ -2637,6 +2863,9 @@ func (h *Handler) applyTunnelRuntime(state *tunnelCreateState) ([]int64, []int64
 			nextTargets = state.ChainHops[i+1]
 		}
 		for _, chainNode := range hop {
+			if skip(chainNode.NodeID) {
+				continue
+			}
 			if node := state.Nodes[chainNode.NodeID]; node != nil && node.IsRemote == 1 {
 				continue
 			}
@@ -2658,6 +2887,9 @@ func (h *Handler) applyTunnelRuntime(state *tunnelCreateState) ([]int64, []int64
 	}
 
 	for _, outNode := range state.OutNodes {
+		if skip(outNode.NodeID) {
+			continue
+		}
 		if node := state.Nodes[outNode.NodeID]; node != nil && node.IsRemote == 1 {
 			continue
 		}
@@ -2900,7 +3132,7 @@ func pickNodeAddressV6(node *nodeRecord) string {
 	return strings.TrimSpace(node.ServerIP)
 }
 
-func isRemoteNodeTx(tx *store.Tx, nodeID int64) (bool, error) {
+func isRemoteNodeTx(tx sqlite.Execer, nodeID int64) (bool, error) {
 	if tx == nil {
 		return false, errors.New("database unavailable")
 	}
@@ -2917,7 +3149,7 @@ func isRemoteNodeTx(tx *store.Tx, nodeID int64) (bool, error) {
 	return isRemote == 1, nil
 }
 
-func pickNodePortTx(tx *store.Tx, nodeID int64, allocated map[int64]int, excludeTunnelID int64) (int, error) {
+func pickNodePortTx(tx sqlite.Execer, nodeID int64, allocated map[int64]int, excludeTunnelID int64) (int, error) {
 	if tx == nil {
 		return 0, errors.New("database unavailable")
 	}
@@ -2970,6 +3202,9 @@ func pickNodePortTx(tx *store.Tx, nodeID int64, allocated map[int64]int, exclude
 		}
 	}
 	_ = forwardRows.Close()
+	if err := markReservedPorts(tx, nodeID, used); err != nil {
+		return 0, err
+	}
 
 	for _, candidate := range candidates {
 		if candidate <= 0 {
@@ -3028,20 +3263,28 @@ func parsePortRangeSpec(input string) []int {
 	return out
 }
 
-func replaceTunnelChainsTx(tx *store.Tx, tunnelID int64, req map[string]interface{}) error {
+func replaceTunnelChainsTx(tx *store.Tx, tunnelID int64, tunnelType int, req map[string]interface{}) error {
 	allocated := map[int64]int{}
 	inNodes := asMapSlice(req["inNodeId"])
+	inChainType := "1"
+	if tunnelType == tunnelTypeDirect {
+		inChainType = strconv.Itoa(chainTypeDirect)
+	}
 	for _, n := range inNodes {
 		nodeID := asInt64(n["nodeId"], 0)
 		if nodeID <= 0 {
 			continue
 		}
-		_, err := tx.Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, '1', ?, NULL, ?, 0, ?)`,
-			tunnelID, nodeID, defaultString(asString(n["strategy"]), "round"), defaultString(asString(n["protocol"]), "tls"))
+		_, err := tx.Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, ?, ?, NULL, ?, 0, ?)`,
+			tunnelID, inChainType, nodeID, defaultString(asString(n["strategy"]), "round"), defaultString(asString(n["protocol"]), "tls"))
 		if err != nil {
 			return err
 		}
 	}
+	if tunnelType == tunnelTypeDirect {
+		// The single node is both entry and exit; there are no hops to store.
+		return nil
+	}
 	for _, n := range asMapSlice(req["outNodeId"]) {
 		nodeID := asInt64(n["nodeId"], 0)
 		if nodeID <= 0 {
@@ -3094,6 +3337,8 @@ func (h *Handler) deleteNodeByID(id int64) error {
 	defer func() { _ = tx.Rollback() }()
 	_, _ = tx.Exec(`DELETE FROM forward_port WHERE node_id = ?`, id)
 	_, _ = tx.Exec(`DELETE FROM chain_tunnel WHERE node_id = ?`, id)
+	_, _ = tx.Exec(`DELETE FROM chain_hop_stats WHERE node_id = ?`, id)
+	_, _ = tx.Exec(`DELETE FROM reserved_port WHERE node_id = ?`, id)
 	_, _ = tx.Exec(`DELETE FROM federation_tunnel_binding WHERE node_id = ?`, id)
 	_, err = tx.Exec(`DELETE FROM node WHERE id = ?`, id)
 	if err != nil {
@@ -3109,10 +3354,13 @@ func (h *Handler) deleteTunnelByID(id int64) error {
 	}
 	defer func() { _ = tx.Rollback() }()
 	_, _ = tx.Exec(`DELETE FROM forward_port WHERE forward_id IN (SELECT id FROM forward WHERE tunnel_id = ?)`, id)
+	_, _ = tx.Exec(`DELETE FROM forward_baseline WHERE forward_id IN (SELECT id FROM forward WHERE tunnel_id = ?)`, id)
 	_, _ = tx.Exec(`DELETE FROM forward WHERE tunnel_id = ?`, id)
 	_, _ = tx.Exec(`DELETE FROM user_tunnel WHERE tunnel_id = ?`, id)
 	_, _ = tx.Exec(`DELETE FROM speed_limit WHERE tunnel_id = ?`, id)
 	_, _ = tx.Exec(`DELETE FROM chain_tunnel WHERE tunnel_id = ?`, id)
+	_, _ = tx.Exec(`DELETE FROM chain_hop_stats WHERE tunnel_id = ?`, id)
+	_, _ = tx.Exec(`DELETE FROM group_tunnel_quota WHERE tunnel_id = ?`, id)
 	_, _ = tx.Exec(`DELETE FROM federation_tunnel_binding WHERE tunnel_id = ?`, id)
 	_, err = tx.Exec(`DELETE FROM tunnel WHERE id = ?`, id)
 	if err != nil {
@@ -3128,6 +3376,7 @@ func (h *Handler) deleteForwardByID(id int64) error {
 	}
 	defer func() { _ = tx.Rollback() }()
 	_, _ = tx.Exec(`DELETE FROM forward_port WHERE forward_id = ?`, id)
+	_, _ = tx.Exec(`DELETE FROM forward_baseline WHERE forward_id = ?`, id)
 	_, err = tx.Exec(`DELETE FROM forward WHERE id = ?`, id)
 	if err != nil {
 		return err
@@ -3162,7 +3411,7 @@ func (h *Handler) batchForwardStatus(ids []int64, status int) (int, int) {
 }
 
 func (h *Handler) tunnelEntryNodeIDs(tunnelID int64) ([]int64, error) {
-	rows, err := h.repo.DB().Query(`SELECT node_id FROM chain_tunnel WHERE tunnel_id = ? AND chain_type = '1' ORDER BY inx ASC, id ASC`, tunnelID)
+	rows, err := h.repo.DB().Query(`SELECT node_id FROM chain_tunnel WHERE tunnel_id = ? AND chain_type IN ('0', '1') ORDER BY inx ASC, id ASC`, tunnelID)
 	if err != nil {
 		return nil, err
 	}
@@ -3178,9 +3427,19 @@ func (h *Handler) tunnelEntryNodeIDs(tunnelID int64) ([]int64, error) {
 }
 
 func (h *Handler) pickTunnelPort(tunnelID int64) int {
+	if port := h.pickTunnelPortExcluding(tunnelID, nil); port > 0 {
+		return port
+	}
+	return 10000
+}
+
+// pickTunnelPortExcluding picks a port free on every entry node of the
+// tunnel, also skipping ports in taken (keyed by node) that are not yet
+// persisted. It returns 0 when no common port is available.
+func (h *Handler) pickTunnelPortExcluding(tunnelID int64, taken map[int64]map[int]bool) int {
 	entryNodes, err := h.tunnelEntryNodeIDs(tunnelID)
 	if err != nil || len(entryNodes) == 0 {
-		return 10000
+		return 0
 	}
 
 	var commonAvailable []int
@@ -3207,7 +3466,7 @@ func (h *Handler) pickTunnelPort(tunnelID int64) int {
 
 		var available []int
 		for _, p := range nodePorts {
-			if !used[p] {
+			if !used[p] && !taken[nodeID][p] {
 				available = append(available, p)
 			}
 		}
@@ -3239,7 +3498,7 @@ func (h *Handler) pickTunnelPort(tunnelID int64) int {
 		return commonAvailable[idx.Int64()]
 	}
 
-	return 10000
+	return 0
 }
 
 func (h *Handler) getUsedPorts(nodeID int64) (map[int]bool, error) {
@@ -3266,6 +3525,15 @@ func (h *Handler) getUsedPorts(nodeID int64) (map[int]bool, error) {
 			used[p] = true
 		}
 	}
+	reserved, err := h.repo.ListReservedPorts(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range reserved {
+		for p := r.PortStart; p <= r.PortEnd; p++ {
+			used[p] = true
+		}
+	}
 	return used, nil
 }
 
@@ -3348,9 +3616,9 @@ func (h *Handler) rollbackForwardMutation(oldForward *forwardRecord, oldPorts []
 
 	_, _ = h.repo.DB().Exec(`
 		UPDATE forward
-		SET user_id = ?, user_name = ?, name = ?, tunnel_id = ?, remote_addr = ?, strategy = ?, status = ?, updated_time = ?
+		SET user_id = ?, user_name = ?, name = ?, tunnel_id = ?, remote_addr = ?, strategy = ?, protocol = ?, dns_server = ?, idle_timeout_sec = ?, status = ?, updated_time = ?
 		WHERE id = ?
-	`, oldForward.UserID, oldForward.UserName, oldForward.Name, oldForward.TunnelID, oldForward.RemoteAddr, oldForward.Strategy, oldForward.Status, time.Now().UnixMilli(), oldForward.ID)
+	`, oldForward.UserID, oldForward.UserName, oldForward.Name, oldForward.TunnelID, oldForward.RemoteAddr, oldForward.Strategy, defaultString(oldForward.Protocol, "tcp"), nullableText(oldForward.DNSServer), oldForward.IdleTimeoutSec, oldForward.Status, time.Now().UnixMilli(), oldForward.ID)
 
 	if err := h.replaceForwardPortsWithRecords(oldForward.ID, oldPorts); err != nil {
 		return
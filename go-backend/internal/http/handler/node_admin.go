@@ -0,0 +1,817 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+	"go-backend/internal/store/sqlite"
+	"go-backend/internal/ws"
+)
+
+type nodeMigrateForwardsRequest struct {
+	SourceNodeID int64 `json:"sourceNodeId"`
+	TargetNodeID int64 `json:"targetNodeId"`
+	DryRun       bool  `json:"dryRun"`
+}
+
+type nodeExpandPortRangeRequest struct {
+	NodeID            int64 `json:"nodeId"`
+	NewPortRangeStart int   `json:"newPortRangeStart"`
+	NewPortRangeEnd   int   `json:"newPortRangeEnd"`
+}
+
+type nodeConnectionHistoryRequest struct {
+	NodeID   int64 `json:"nodeId"`
+	Page     int   `json:"page"`
+	PageSize int   `json:"pageSize"`
+}
+
+type nodeRotateSecretRequest struct {
+	ID     int64  `json:"id"`
+	Secret string `json:"secret"`
+}
+
+type nodeDisconnectRequest struct {
+	NodeID int64  `json:"nodeId"`
+	Reason string `json:"reason"`
+}
+
+type forwardMigrationItem struct {
+	ForwardID  int64  `json:"forwardId"`
+	Name       string `json:"name"`
+	UserID     int64  `json:"userId"`
+	SourcePort int    `json:"sourcePort"`
+	TargetPort int    `json:"targetPort"`
+	Conflict   string `json:"conflict,omitempty"`
+}
+
+func (h *Handler) adminNodeMigrateForwards(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req nodeMigrateForwardsRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.SourceNodeID <= 0 || req.TargetNodeID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("源节点和目标节点不能为空"))
+		return
+	}
+	if req.SourceNodeID == req.TargetNodeID {
+		response.WriteJSON(w, response.ErrDefault("源节点和目标节点不能相同"))
+		return
+	}
+	if _, err := h.getNodeRecord(req.SourceNodeID); err != nil {
+		response.WriteJSON(w, response.ErrDefault("源节点不存在"))
+		return
+	}
+	target, err := h.getNodeRecord(req.TargetNodeID)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("目标节点不存在"))
+		return
+	}
+	if target.IsRemote == 1 {
+		response.WriteJSON(w, response.ErrDefault("不支持迁移到远程节点"))
+		return
+	}
+
+	if req.DryRun {
+		items, err := planForwardMigration(h.repo.DB(), req.SourceNodeID, target)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		response.WriteJSON(w, response.OK(map[string]interface{}{
+			"dryRun":    true,
+			"forwards":  items,
+			"conflicts": migrationConflicts(items),
+		}))
+		return
+	}
+
+	items, err := h.migrateNodeForwards(req.SourceNodeID, target)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"dryRun":   false,
+		"forwards": items,
+		"migrated": len(items),
+	}))
+}
+
+func (h *Handler) adminNodeConnectionHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req nodeConnectionHistoryRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.NodeID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
+		return
+	}
+	if _, err := h.getNodeRecord(req.NodeID); err != nil {
+		response.WriteJSON(w, response.ErrDefault("节点不存在"))
+		return
+	}
+
+	items, total, err := h.repo.GetNodeConnectionHistory(req.NodeID, req.Page, req.PageSize)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"nodeId": req.NodeID,
+		"page":   req.Page,
+		"total":  total,
+		"list":   items,
+	}))
+}
+
+// adminWSSessions lists the node WebSocket sessions that are currently open.
+func (h *Handler) adminWSSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	response.WriteJSON(w, response.OK(h.wsServer.SessionStats()))
+}
+
+// adminNodeOnlineList lists the nodes that currently hold a WebSocket
+// session, each with the time the session was opened.
+func (h *Handler) adminNodeOnlineList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	items, err := h.repo.ListNodesByIDs(h.wsServer.ConnectedNodeIDs())
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	for _, item := range items {
+		id, _ := item["id"].(int64)
+		if connectedAt, ok := h.wsServer.ConnectedAt(id); ok {
+			item["connectedAt"] = connectedAt.UnixMilli()
+		}
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+// adminNodeDisconnect drops the live WebSocket session of a node without
+// touching its configuration. The node is free to reconnect.
+func (h *Handler) adminNodeDisconnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req nodeDisconnectRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.NodeID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if err := h.wsServer.DisconnectNode(req.NodeID, reason); err != nil {
+		if errors.Is(err, ws.ErrNodeNotConnected) {
+			response.WriteJSON(w, response.Err(-1, err.Error()))
+			return
+		}
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	h.writeAuditLog(r, "node_force_disconnect", "node", req.NodeID, reason)
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func (h *Handler) adminNodeGenerateSecret(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	secret, err := security.GenerateNodeSecret()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{"secret": secret}))
+}
+
+// adminNodeRotateSecret replaces a node's secret with the supplied one, or a
+// freshly generated one when none is given. The node has to be reinstalled
+// with the new secret before it can reconnect.
+func (h *Handler) adminNodeRotateSecret(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req nodeRotateSecretRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.ID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
+		return
+	}
+	node, err := h.getNodeRecord(req.ID)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("节点不存在"))
+		return
+	}
+	if node.IsRemote == 1 {
+		response.WriteJSON(w, response.ErrDefault("远程节点不支持更换密钥"))
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		if secret, err = security.GenerateNodeSecret(); err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+	} else if err := security.ValidateNodeSecret(secret); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+
+	if err := h.updateNodeSecret(req.ID, secret); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{"secret": secret}))
+}
+
+// nodeRotateSecret re-keys a connected node in place: the new secret is
+// pushed to the agent first and only stored once the agent has switched to
+// it, so the node's flow reports keep authenticating throughout.
+func (h *Handler) nodeRotateSecret(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	node, err := h.getNodeRecord(id)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("节点不存在"))
+		return
+	}
+	if node.IsRemote == 1 {
+		response.WriteJSON(w, response.ErrDefault("远程节点不支持更换密钥"))
+		return
+	}
+	if node.Status != 1 {
+		response.WriteJSON(w, response.ErrDefault("节点不在线，无法在线更换密钥"))
+		return
+	}
+	var current string
+	if err := h.repo.DB().QueryRow(`SELECT secret FROM node WHERE id = ?`, id).Scan(&current); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if current, err = h.repo.OpenSecret(current); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	secret, err := security.GenerateNodeSecret()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	// Until the agent has switched and persisted the new secret its reports
+	// may carry either one; keep them out of the brute-force count.
+	h.secretRotations.hold(time.Now(), current, secret)
+	defer h.secretRotations.hold(time.Now(), current, secret)
+
+	if err := h.wsServer.RekeyNode(id, secret, 10*time.Second); err != nil {
+		response.WriteJSON(w, response.ErrDefault("节点未确认新密钥: "+err.Error()))
+		return
+	}
+	// The agent already runs on the new secret; hand it the old one back.
+	revertAgent := func() {
+		if err := h.wsServer.RekeyNode(id, current, 10*time.Second); err != nil {
+			_ = h.wsServer.DisconnectNode(id, "node secret rotation failed")
+		}
+	}
+	if _, err := h.repo.DB().Exec(`UPDATE node SET secret = ?, updated_time = ? WHERE id = ?`, h.repo.SealSecret(secret), time.Now().UnixMilli(), id); err != nil {
+		revertAgent()
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	// Only now may the agent write the new secret to its config; until it
+	// does, a restart brings it back on the old one, so restore that here.
+	if err := h.wsServer.CommitNodeSecret(id, secret, 10*time.Second); err != nil {
+		if _, dbErr := h.repo.DB().Exec(`UPDATE node SET secret = ?, updated_time = ? WHERE id = ?`, h.repo.SealSecret(current), time.Now().UnixMilli(), id); dbErr != nil {
+			log.Printf("node %d: restore secret after failed commit: %v", id, dbErr)
+		}
+		revertAgent()
+		response.WriteJSON(w, response.ErrDefault("节点未保存新密钥: "+err.Error()))
+		return
+	}
+	h.writeAuditLog(r, "node_secret_rotate", "node", id, node.Name)
+	response.WriteJSON(w, response.OK(map[string]interface{}{"secret": secret}))
+}
+
+// secretRotationGrace is how long after a secret rotation requests that
+// carry the old or the new secret are not counted as failed node logins.
+const secretRotationGrace = 2 * time.Minute
+
+// secretRotations remembers the secrets of rotations in flight. While the
+// agent switches over, its uploads and reconnects can present a secret the
+// node table does not hold at that moment.
+type secretRotations struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func newSecretRotations() *secretRotations {
+	return &secretRotations{until: make(map[string]time.Time)}
+}
+
+// hold exempts secrets from failure counting until secretRotationGrace
+// after now.
+func (s *secretRotations) hold(now time.Time, secrets ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, secret := range secrets {
+		if secret != "" {
+			s.until[secret] = now.Add(secretRotationGrace)
+		}
+	}
+}
+
+// inFlight reports whether secret belongs to a rotation in flight at now.
+func (s *secretRotations) inFlight(secret string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, until := range s.until {
+		if !now.Before(until) {
+			delete(s.until, k)
+		}
+	}
+	_, ok := s.until[secret]
+	return ok && secret != ""
+}
+
+// updateNodeSecret stores secret for nodeID and drops the node's live session,
+// which is still encrypted with the old secret.
+func (h *Handler) updateNodeSecret(nodeID int64, secret string) error {
+	var current string
+	if err := h.repo.DB().QueryRow(`SELECT secret FROM node WHERE id = ?`, nodeID).Scan(&current); err != nil {
+		return err
+	}
+	if current, err := h.repo.OpenSecret(current); err == nil && current == secret {
+		return nil
+	}
+	if _, err := h.repo.DB().Exec(`UPDATE node SET secret = ?, updated_time = ? WHERE id = ?`, h.repo.SealSecret(secret), time.Now().UnixMilli(), nodeID); err != nil {
+		return err
+	}
+	_ = h.wsServer.DisconnectNode(nodeID, "node secret rotated")
+	return nil
+}
+
+// migrateNodeForwards moves every forward entry on sourceNodeID to target.
+// In a single transaction the forwards' port rows move to the target and the
+// target takes the source's place as entry node of their tunnels, so later
+// edits, which derive ports from the tunnel entries, keep the migration. Chain
+// tunnels then get their chains deployed on the target before any forward
+// service is sent there. If the target rejects anything the database is
+// restored and whatever was added to the target is removed again. Services
+// and chains on the source node are only deleted after every forward is live
+// on the target.
+func (h *Handler) migrateNodeForwards(sourceNodeID int64, target *nodeRecord) ([]forwardMigrationItem, error) {
+	tx, err := h.repo.DB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	items, err := planForwardMigration(tx, sourceNodeID, target)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.TargetPort <= 0 {
+			return nil, fmt.Errorf("转发 %s 迁移失败: %s", item.Name, item.Conflict)
+		}
+	}
+
+	oldPorts := make(map[int64][]forwardPortRecord, len(items))
+	for _, item := range items {
+		ports, err := listForwardPortsTx(tx, item.ForwardID)
+		if err != nil {
+			return nil, err
+		}
+		oldPorts[item.ForwardID] = ports
+
+		if _, err := tx.Exec(`DELETE FROM forward_port WHERE forward_id = ? AND node_id = ?`, item.ForwardID, sourceNodeID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, ?)`, item.ForwardID, target.ID, item.TargetPort); err != nil {
+			return nil, err
+		}
+	}
+	entries, err := moveTunnelEntriesTx(tx, items, sourceNodeID, target.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	migration := &forwardMigration{sourceNodeID: sourceNodeID, targetNodeID: target.ID, oldPorts: oldPorts, entries: entries}
+	for _, entry := range entries {
+		state, err := h.reconstructTunnelState(entry.tunnelID)
+		if err == nil {
+			err = h.deployTunnelEntryChain(state, target.ID)
+		}
+		if err != nil {
+			h.rollbackForwardMigration(migration)
+			return nil, fmt.Errorf("隧道 %d 迁移失败: %w", entry.tunnelID, err)
+		}
+		if state.Type == 2 {
+			migration.chains = append(migration.chains, state)
+		}
+	}
+
+	for _, item := range items {
+		forward, err := h.getForwardRecord(item.ForwardID)
+		if err == nil {
+			err = h.syncForwardServices(forward, "AddService", false)
+		}
+		if err != nil {
+			if forward != nil {
+				migration.forwards = append(migration.forwards, forward)
+			}
+			h.rollbackForwardMigration(migration)
+			return nil, fmt.Errorf("转发 %s 迁移失败: %w", item.Name, err)
+		}
+		migration.forwards = append(migration.forwards, forward)
+	}
+
+	for _, forward := range migration.forwards {
+		h.deleteForwardServicesOnNode(forward, sourceNodeID)
+	}
+	for _, state := range migration.chains {
+		h.deleteTunnelEntryChain(state, sourceNodeID)
+	}
+	return items, nil
+}
+
+// movedTunnelEntry is a chain_tunnel entry row re-pointed from the source to
+// the target node by a forward migration.
+type movedTunnelEntry struct {
+	id       int64
+	tunnelID int64
+}
+
+// forwardMigration tracks what a live migration has changed so far, for
+// rollbackForwardMigration.
+type forwardMigration struct {
+	sourceNodeID int64
+	targetNodeID int64
+	oldPorts     map[int64][]forwardPortRecord
+	entries      []movedTunnelEntry
+	chains       []*tunnelCreateState
+	forwards     []*forwardRecord
+}
+
+// moveTunnelEntriesTx makes targetNodeID the entry node in place of
+// sourceNodeID for every tunnel the migrated forwards use.
+func moveTunnelEntriesTx(tx sqlite.Execer, items []forwardMigrationItem, sourceNodeID, targetNodeID int64) ([]movedTunnelEntry, error) {
+	seen := make(map[int64]struct{})
+	entries := make([]movedTunnelEntry, 0)
+	for _, item := range items {
+		var tunnelID int64
+		if err := tx.QueryRow(`SELECT tunnel_id FROM forward WHERE id = ?`, item.ForwardID).Scan(&tunnelID); err != nil {
+			return nil, err
+		}
+		if _, ok := seen[tunnelID]; ok {
+			continue
+		}
+		seen[tunnelID] = struct{}{}
+
+		rows, err := tx.Query(`SELECT id FROM chain_tunnel WHERE tunnel_id = ? AND chain_type = 1 AND node_id = ?`, tunnelID, sourceNodeID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			entry := movedTunnelEntry{tunnelID: tunnelID}
+			if err := rows.Scan(&entry.id); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range entries {
+		if _, err := tx.Exec(`UPDATE chain_tunnel SET node_id = ? WHERE id = ?`, targetNodeID, entry.id); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// deployTunnelEntryChain sends the tunnel's chain to nodeID, which has just
+// become one of its entry nodes. Only chain tunnels have one.
+func (h *Handler) deployTunnelEntryChain(state *tunnelCreateState, nodeID int64) error {
+	if state.Type != 2 {
+		return nil
+	}
+	targets := state.OutNodes
+	if len(state.ChainHops) > 0 {
+		targets = state.ChainHops[0]
+	}
+	chainData, err := buildTunnelChainConfig(state.TunnelID, nodeID, targets, state.Nodes)
+	if err != nil {
+		return err
+	}
+	_, err = h.sendNodeCommand(nodeID, "AddChains", chainData, true, false)
+	return err
+}
+
+// deleteTunnelEntryChain removes the tunnel's chain from a former entry node,
+// unless the node still needs it as a chain hop.
+func (h *Handler) deleteTunnelEntryChain(state *tunnelCreateState, nodeID int64) {
+	for _, hop := range state.ChainHops {
+		for _, chainNode := range hop {
+			if chainNode.NodeID == nodeID {
+				return
+			}
+		}
+	}
+	chainName := fmt.Sprintf("chains_%d", state.TunnelID)
+	_, _ = h.sendNodeCommand(nodeID, "DeleteChains", map[string]interface{}{"chain": chainName}, false, true)
+}
+
+func (h *Handler) rollbackForwardMigration(m *forwardMigration) {
+	for _, forward := range m.forwards {
+		h.deleteForwardServicesOnNode(forward, m.targetNodeID)
+	}
+	for _, state := range m.chains {
+		h.deleteTunnelEntryChain(state, m.targetNodeID)
+	}
+	for forwardID, ports := range m.oldPorts {
+		_ = h.replaceForwardPortsWithRecords(forwardID, ports)
+	}
+	for _, entry := range m.entries {
+		_, _ = h.repo.DB().Exec(`UPDATE chain_tunnel SET node_id = ? WHERE id = ?`, m.sourceNodeID, entry.id)
+	}
+}
+
+func (h *Handler) deleteForwardServicesOnNode(forward *forwardRecord, nodeID int64) {
+	if forward == nil || nodeID <= 0 {
+		return
+	}
+	userTunnelID, _, _, err := h.resolveUserTunnelAndLimiter(forward.UserID, forward.TunnelID)
+	if err != nil {
+		return
+	}
+	base := buildForwardServiceBase(forward.ID, forward.UserID, userTunnelID)
+	for _, name := range buildForwardControlServiceNames(base, "DeleteService", forward.Protocol) {
+		_, _ = h.sendNodeCommand(nodeID, "DeleteService", map[string]interface{}{"services": []string{name}}, false, true)
+	}
+}
+
+// planForwardMigration lists the forwards listening on sourceNodeID together
+// with the port each would use on target. The current port is kept when it is
+// free on the target; otherwise the lowest free port in the target range is
+// chosen and the reassignment is reported as a conflict.
+func planForwardMigration(db sqlite.Execer, sourceNodeID int64, target *nodeRecord) ([]forwardMigrationItem, error) {
+	rows, err := db.Query(`
+		SELECT f.id, f.name, f.user_id, fp.port,
+		       (SELECT COUNT(1) FROM forward_port x WHERE x.forward_id = f.id AND x.node_id = ?)
+		FROM forward_port fp
+		JOIN forward f ON f.id = fp.forward_id
+		WHERE fp.node_id = ?
+		ORDER BY f.inx ASC, f.id ASC
+	`, target.ID, sourceNodeID)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]forwardMigrationItem, 0)
+	for rows.Next() {
+		var item forwardMigrationItem
+		var onTarget int
+		if err := rows.Scan(&item.ForwardID, &item.Name, &item.UserID, &item.SourcePort, &onTarget); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		if onTarget > 0 {
+			item.Conflict = "转发已存在于目标节点"
+		}
+		items = append(items, item)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	used, err := usedNodePorts(db, target.ID)
+	if err != nil {
+		return nil, err
+	}
+	candidates := parsePortRangeSpec(target.PortRange)
+	inRange := make(map[int]struct{}, len(candidates))
+	for _, p := range candidates {
+		inRange[p] = struct{}{}
+	}
+
+	// Keep every port that is free on the target first, so a reassigned
+	// forward never takes a port another forward could have kept.
+	for i := range items {
+		item := &items[i]
+		if item.Conflict != "" {
+			continue
+		}
+		if _, ok := inRange[item.SourcePort]; !ok {
+			continue
+		}
+		if _, taken := used[item.SourcePort]; taken {
+			continue
+		}
+		item.TargetPort = item.SourcePort
+		used[item.TargetPort] = struct{}{}
+	}
+	for i := range items {
+		item := &items[i]
+		if item.Conflict != "" || item.TargetPort > 0 {
+			continue
+		}
+		for _, p := range candidates {
+			if _, taken := used[p]; !taken {
+				item.TargetPort = p
+				break
+			}
+		}
+		if item.TargetPort <= 0 {
+			item.Conflict = "目标节点端口已满，无可用端口"
+			continue
+		}
+		used[item.TargetPort] = struct{}{}
+		item.Conflict = fmt.Sprintf("端口 %d 在目标节点不可用，改用 %d", item.SourcePort, item.TargetPort)
+	}
+	return items, nil
+}
+
+func migrationConflicts(items []forwardMigrationItem) []forwardMigrationItem {
+	out := make([]forwardMigrationItem, 0)
+	for _, item := range items {
+		if strings.TrimSpace(item.Conflict) != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func usedNodePorts(db sqlite.Execer, nodeID int64) (map[int]struct{}, error) {
+	used := make(map[int]struct{})
+	for _, query := range []string{
+		`SELECT port FROM forward_port WHERE node_id = ?`,
+		`SELECT port FROM chain_tunnel WHERE node_id = ? AND port IS NOT NULL AND port > 0`,
+	} {
+		rows, err := db.Query(query, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var p sql.NullInt64
+			if scanErr := rows.Scan(&p); scanErr == nil && p.Valid && p.Int64 > 0 {
+				used[int(p.Int64)] = struct{}{}
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := markReservedPorts(db, nodeID, used); err != nil {
+		return nil, err
+	}
+	return used, nil
+}
+
+func listForwardPortsTx(db sqlite.Execer, forwardID int64) ([]forwardPortRecord, error) {
+	if db == nil {
+		return nil, errors.New("database unavailable")
+	}
+	rows, err := db.Query(`SELECT node_id, port FROM forward_port WHERE forward_id = ? ORDER BY id ASC`, forwardID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]forwardPortRecord, 0)
+	for rows.Next() {
+		var fp forwardPortRecord
+		if err := rows.Scan(&fp.NodeID, &fp.Port); err != nil {
+			return nil, err
+		}
+		out = append(out, fp)
+	}
+	return out, rows.Err()
+}
+
+// adminNodeExpandPortRange widens a node's port range in place. The new range
+// must contain every port of the current one so existing forwards stay valid.
+func (h *Handler) adminNodeExpandPortRange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req nodeExpandPortRangeRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.NodeID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
+		return
+	}
+	start, end := req.NewPortRangeStart, req.NewPortRangeEnd
+	if start < 1 || end > 65535 || start > end {
+		response.WriteJSON(w, response.ErrDefault("端口范围无效"))
+		return
+	}
+
+	node, err := h.getNodeRecord(req.NodeID)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("节点不存在"))
+		return
+	}
+	if node.IsRemote == 1 {
+		response.WriteJSON(w, response.ErrDefault("远程节点不支持修改端口范围"))
+		return
+	}
+	if node.Status != 1 {
+		response.WriteJSON(w, response.ErrDefault("节点不在线"))
+		return
+	}
+
+	current := parsePortRangeSpec(node.PortRange)
+	if len(current) == 0 {
+		response.WriteJSON(w, response.ErrDefault("节点当前端口范围无效"))
+		return
+	}
+	oldStart, oldEnd := current[0], current[len(current)-1]
+	if start > oldStart || end < oldEnd {
+		response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("新端口范围必须包含当前范围 %d-%d", oldStart, oldEnd)))
+		return
+	}
+	newRange := fmt.Sprintf("%d-%d", start, end)
+	if newRange == strings.TrimSpace(node.PortRange) {
+		response.WriteJSON(w, response.ErrDefault("新端口范围与当前范围相同"))
+		return
+	}
+
+	conflict, err := h.repo.FindNodePortConflict(node.ServerIP, node.ID, start, end)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if conflict != nil {
+		response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("端口范围与节点 %s (%s) 冲突", conflict.Name, conflict.PortRange)))
+		return
+	}
+
+	payload := map[string]interface{}{
+		"portRange": newRange,
+		"portStart": start,
+		"portEnd":   end,
+	}
+	if _, err := h.sendNodeCommand(node.ID, "UpdatePortRange", payload, false, false); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	if _, err := h.repo.DB().Exec(`UPDATE node SET port = ?, updated_time = ? WHERE id = ?`, newRange, time.Now().UnixMilli(), node.ID); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"nodeId":    node.ID,
+		"portRange": newRange,
+	}))
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+const (
+	nodeTestDefaultDurationMs = 1000
+	nodeTestMaxDurationMs     = 10000
+	nodeTestLatencySamples    = 5
+	nodeTestDefaultBytes      = 256 * 1024
+	nodeTestMaxBytes          = 4 * 1024 * 1024
+)
+
+type nodeConnectivityTestRequest struct {
+	NodeID     int64  `json:"nodeId"`
+	TestType   string `json:"testType"`
+	DurationMs int    `json:"durationMs"`
+	Bytes      int    `json:"bytes"`
+}
+
+// nodeProbe sends one test round trip to a node carrying payload bytes and
+// returns how long it took.
+type nodeProbe func(payload []byte) (time.Duration, error)
+
+// adminNodeConnectivityTest checks that a node answers before it is put into
+// a tunnel. A node with a WebSocket session is probed with the Ping command;
+// any other node through an HTTP request to /flow/test on its first port.
+// durationMs bounds each round trip.
+func (h *Handler) adminNodeConnectivityTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req nodeConnectivityTestRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.NodeID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
+		return
+	}
+	testType := strings.ToLower(strings.TrimSpace(req.TestType))
+	if testType == "" {
+		testType = "ping"
+	}
+	if testType != "ping" && testType != "latency" && testType != "throughput" {
+		response.WriteJSON(w, response.ErrDefault("测试类型无效"))
+		return
+	}
+	if req.DurationMs <= 0 {
+		req.DurationMs = nodeTestDefaultDurationMs
+	}
+	if req.DurationMs > nodeTestMaxDurationMs {
+		req.DurationMs = nodeTestMaxDurationMs
+	}
+	if req.Bytes <= 0 {
+		req.Bytes = nodeTestDefaultBytes
+	}
+	if req.Bytes > nodeTestMaxBytes {
+		response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("测试数据不能超过 %d 字节", nodeTestMaxBytes)))
+		return
+	}
+
+	node, err := h.getNodeRecord(req.NodeID)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("节点不存在"))
+		return
+	}
+	timeout := time.Duration(req.DurationMs) * time.Millisecond
+	probe, via, err := h.nodeConnectivityProbe(node, timeout)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+
+	result := map[string]interface{}{"via": via}
+	switch testType {
+	case "ping":
+		elapsed, err := probe(nil)
+		result["reachable"] = err == nil
+		result["latencyMs"] = durationMs(elapsed)
+		if err != nil {
+			result["latencyMs"] = 0.0
+			result["error"] = err.Error()
+		}
+	case "latency":
+		var samples []float64
+		var lastErr error
+		for i := 0; i < nodeTestLatencySamples; i++ {
+			elapsed, err := probe(nil)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			samples = append(samples, durationMs(elapsed))
+		}
+		result["reachable"] = len(samples) > 0
+		result["sent"] = nodeTestLatencySamples
+		result["received"] = len(samples)
+		minMs, maxMs, avgMs := summarizeLatency(samples)
+		result["minMs"], result["maxMs"], result["avgMs"] = minMs, maxMs, avgMs
+		if lastErr != nil {
+			result["error"] = lastErr.Error()
+		}
+	case "throughput":
+		elapsed, err := probe(bytes.Repeat([]byte{'x'}, req.Bytes))
+		result["reachable"] = err == nil
+		result["bytes"] = req.Bytes
+		result["elapsedMs"] = durationMs(elapsed)
+		result["bytesPerSec"] = 0.0
+		if err != nil {
+			result["error"] = err.Error()
+		} else if elapsed > 0 {
+			result["bytesPerSec"] = math.Round(float64(req.Bytes) / elapsed.Seconds())
+		}
+	}
+	response.WriteJSON(w, response.OK(result))
+}
+
+// nodeConnectivityProbe picks how to reach the node: its WebSocket session
+// when it has one, /flow/test otherwise.
+func (h *Handler) nodeConnectivityProbe(node *nodeRecord, timeout time.Duration) (nodeProbe, string, error) {
+	if node.IsRemote != 1 && h.wsServer != nil {
+		if _, online := h.wsServer.ConnectedAt(node.ID); online {
+			return func(payload []byte) (time.Duration, error) {
+				data := map[string]interface{}{}
+				if len(payload) > 0 {
+					data["payload"] = string(payload)
+				}
+				start := time.Now()
+				_, err := h.wsServer.SendCommand(node.ID, "Ping", data, timeout)
+				return time.Since(start), err
+			}, "ws", nil
+		}
+	}
+
+	ports := parsePortRangeSpec(node.PortRange)
+	host := strings.TrimSpace(node.ServerIP)
+	if host == "" || len(ports) == 0 {
+		return nil, "", errors.New("节点缺少地址或端口")
+	}
+	url := "http://" + net.JoinHostPort(host, strconv.Itoa(ports[0])) + "/flow/test"
+	client := &http.Client{Timeout: timeout}
+	return func(payload []byte) (time.Duration, error) {
+		start := time.Now()
+		res, err := client.Post(url, "application/octet-stream", bytes.NewReader(payload))
+		if err != nil {
+			return time.Since(start), err
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+		elapsed := time.Since(start)
+		if res.StatusCode != http.StatusOK {
+			return elapsed, fmt.Errorf("flow/test returned %d", res.StatusCode)
+		}
+		return elapsed, nil
+	}, "http", nil
+}
+
+func durationMs(d time.Duration) float64 {
+	return math.Round(float64(d.Microseconds())/10) / 100
+}
+
+func summarizeLatency(samples []float64) (minMs, maxMs, avgMs float64) {
+	if len(samples) == 0 {
+		return 0, 0, 0
+	}
+	minMs, maxMs = samples[0], samples[0]
+	var sum float64
+	for _, v := range samples {
+		minMs = math.Min(minMs, v)
+		maxMs = math.Max(maxMs, v)
+		sum += v
+	}
+	return minMs, maxMs, math.Round(sum/float64(len(samples))*100) / 100
+}
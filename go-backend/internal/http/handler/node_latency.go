@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+const nodeLatencyProbeInterval = 5 * time.Minute
+
+type tunnelOptimalPathRequest struct {
+	EntryNodeID   int64   `json:"entryNodeId"`
+	ExitNodeID    int64   `json:"exitNodeId"`
+	MiddleNodeIDs []int64 `json:"middleNodeIds"`
+}
+
+type tunnelOptimalPath struct {
+	Path      []int64 `json:"path"`
+	LatencyMs float64 `json:"latencyMs"`
+}
+
+func (h *Handler) adminNodeLatencyMatrix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	items, err := h.repo.ListNodeLatencies()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+func (h *Handler) adminTunnelOptimalPath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req tunnelOptimalPathRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.EntryNodeID <= 0 || req.ExitNodeID <= 0 || req.EntryNodeID == req.ExitNodeID {
+		response.WriteJSON(w, response.ErrDefault("入口和出口节点无效"))
+		return
+	}
+
+	latencies, err := h.repo.ListNodeLatencies()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	path, err := lowestLatencyPath(latencies, req.EntryNodeID, req.ExitNodeID, req.MiddleNodeIDs)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(path))
+}
+
+// lowestLatencyPath runs Dijkstra from entry to exit over the measured
+// latencies, only passing through the given middle nodes. A direct
+// entry-to-exit edge is a valid (zero-hop) chain.
+func lowestLatencyPath(latencies []sqlite.NodeLatency, entry, exit int64, middles []int64) (*tunnelOptimalPath, error) {
+	allowed := map[int64]struct{}{entry: {}, exit: {}}
+	for _, id := range middles {
+		if id > 0 {
+			allowed[id] = struct{}{}
+		}
+	}
+
+	edges := make(map[int64]map[int64]float64)
+	for _, l := range latencies {
+		if _, ok := allowed[l.From]; !ok {
+			continue
+		}
+		if _, ok := allowed[l.To]; !ok {
+			continue
+		}
+		if l.From == exit || l.To == entry {
+			continue
+		}
+		if edges[l.From] == nil {
+			edges[l.From] = make(map[int64]float64)
+		}
+		edges[l.From][l.To] = l.LatencyMs
+	}
+
+	dist := make(map[int64]float64, len(allowed))
+	prev := make(map[int64]int64, len(allowed))
+	done := make(map[int64]bool, len(allowed))
+	for id := range allowed {
+		dist[id] = math.Inf(1)
+	}
+	dist[entry] = 0
+
+	for {
+		current := int64(0)
+		best := math.Inf(1)
+		for id, d := range dist {
+			if !done[id] && (d < best || (d == best && current != 0 && id < current)) {
+				current, best = id, d
+			}
+		}
+		if current == 0 || current == exit {
+			break
+		}
+		done[current] = true
+		for next, cost := range edges[current] {
+			if done[next] {
+				continue
+			}
+			if alt := dist[current] + cost; alt < dist[next] {
+				dist[next] = alt
+				prev[next] = current
+			}
+		}
+	}
+
+	if math.IsInf(dist[exit], 1) {
+		return nil, errors.New("没有可用的延迟数据构成路径")
+	}
+	path := []int64{exit}
+	for id := exit; id != entry; {
+		id = prev[id]
+		path = append([]int64{id}, path...)
+	}
+	return &tunnelOptimalPath{Path: path, LatencyMs: dist[exit]}, nil
+}
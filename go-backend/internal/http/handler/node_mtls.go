@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/pki"
+	"go-backend/internal/security"
+	"go-backend/internal/store/sqlite"
+)
+
+const (
+	mtlsCACertConfigKey = "mtls_ca_cert"
+	mtlsCAKeyConfigKey  = "mtls_ca_key"
+
+	nodeClientCertTTL = 365 * 24 * time.Hour
+	mtlsServerCertTTL = 365 * 24 * time.Hour
+)
+
+type nodeCertRequest struct {
+	ID int64 `json:"id"`
+}
+
+// panelCA loads the CA that signs node client certificates, creating it on
+// first use. Its key is stored encrypted with the panel's JWT secret.
+func (h *Handler) panelCA() (*pki.CA, error) {
+	h.caMu.Lock()
+	defer h.caMu.Unlock()
+	if h.ca != nil {
+		return h.ca, nil
+	}
+	crypto, err := security.NewAESCrypto(h.jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM := h.configValue(mtlsCACertConfigKey)
+	encryptedKey := h.configValue(mtlsCAKeyConfigKey)
+	if certPEM == "" || encryptedKey == "" {
+		newCert, newKey, err := pki.NewCA("FLVX Node CA", time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if encryptedKey, err = crypto.Encrypt(newKey); err != nil {
+			return nil, err
+		}
+		certPEM = string(newCert)
+		now := time.Now().UnixMilli()
+		if err := h.repo.UpsertConfig(mtlsCACertConfigKey, certPEM, now); err != nil {
+			return nil, err
+		}
+		if err := h.repo.UpsertConfig(mtlsCAKeyConfigKey, encryptedKey, now); err != nil {
+			return nil, err
+		}
+	}
+
+	keyPEM, err := crypto.Decrypt(encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := pki.ParseCA([]byte(certPEM), keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	h.ca = ca
+	return ca, nil
+}
+
+// NodeTLSConfig is the TLS configuration of the dedicated node listener:
+// a server certificate for hosts signed by the panel CA, and a required
+// client certificate from the same CA. Which node a certificate belongs to
+// is checked per request by middleware.NodeMTLS.
+func (h *Handler) NodeTLSConfig(hosts []string) (*tls.Config, error) {
+	ca, err := h.panelCA()
+	if err != nil {
+		return nil, err
+	}
+	serverCert, err := ca.IssueServer(hosts, mtlsServerCertTTL, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.Pool(),
+	}, nil
+}
+
+// issueNodeCert signs a new client certificate for node. The previous one
+// stops being accepted immediately.
+func (h *Handler) issueNodeCert(node *sqlite.Node) (map[string]interface{}, error) {
+	ca, err := h.panelCA()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	issued, err := ca.IssueClient("node-"+strconv.FormatInt(node.ID, 10), nodeClientCertTTL, now)
+	if err != nil {
+		return nil, err
+	}
+	expireTime := issued.NotAfter.UnixMilli()
+	if err := h.repo.SetNodeClientCert(node.ID, string(issued.CertPEM), issued.Serial, expireTime, now.UnixMilli()); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"cert":       string(issued.CertPEM),
+		"key":        string(issued.KeyPEM),
+		"ca":         string(ca.CertPEM),
+		"serial":     issued.Serial,
+		"expireTime": expireTime,
+	}, nil
+}
+
+func (h *Handler) nodeCertIssue(w http.ResponseWriter, r *http.Request) {
+	h.handleNodeCert(w, r, false)
+}
+
+func (h *Handler) nodeCertRenew(w http.ResponseWriter, r *http.Request) {
+	h.handleNodeCert(w, r, true)
+}
+
+func (h *Handler) handleNodeCert(w http.ResponseWriter, r *http.Request, renew bool) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req nodeCertRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	node, err := h.repo.GetNodeByID(req.ID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if node == nil {
+		response.WriteJSON(w, response.ErrDefault("节点不存在"))
+		return
+	}
+	if renew {
+		current, err := h.repo.GetNodeClientCert(node.Secret)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		if current == nil || current.Serial == "" {
+			response.WriteJSON(w, response.ErrDefault("节点尚未签发证书"))
+			return
+		}
+	}
+	out, err := h.issueNodeCert(node)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(out))
+}
+
+// flowCertRenew lets a node replace its own certificate before it expires.
+// middleware.NodeMTLS only lets it through with the node's current
+// certificate.
+func (h *Handler) flowCertRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	node, err := h.repo.GetNodeBySecret(r.URL.Query().Get("secret"))
+	if err == nil && node == nil {
+		h.recordNodeAuthFailure(r)
+		err = errors.New("node not found")
+	}
+	if err != nil {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	out, err := h.issueNodeCert(node)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(out))
+}
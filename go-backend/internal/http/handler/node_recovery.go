@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"log"
+)
+
+// redispatchNodeServices restores the services a node should be running
+// after it opens a session, so a restarted agent does not stay empty until
+// the next edit. Tunnel chains are sent first because forward services on
+// entry nodes refer to them. Nodes already running a service tolerate the
+// duplicate AddService; forward services keep their revision, which marks
+// them as a replay.
+func (h *Handler) redispatchNodeServices(nodeID int64) {
+	if h == nil || h.repo == nil {
+		return
+	}
+	dispatched := 0
+
+	tunnelIDs, err := h.repo.ListActiveTunnelServicesByNodeID(nodeID)
+	if err != nil {
+		log.Printf("node %d: list tunnel services failed: %v", nodeID, err)
+	}
+	for _, tunnelID := range tunnelIDs {
+		state, err := h.reconstructTunnelState(tunnelID)
+		if err != nil {
+			log.Printf("node %d: load tunnel %d failed: %v", nodeID, tunnelID, err)
+			continue
+		}
+		n, err := h.redeployTunnelOnNode(state, nodeID)
+		dispatched += n
+		if err != nil {
+			log.Printf("node %d: re-dispatch tunnel %d failed: %v", nodeID, tunnelID, err)
+		}
+	}
+
+	forwardIDs, err := h.repo.ListActiveForwardsByNodeID(nodeID)
+	if err != nil {
+		log.Printf("node %d: list forwards failed: %v", nodeID, err)
+	}
+	for _, forwardID := range forwardIDs {
+		forward, err := h.getForwardRecord(forwardID)
+		if err != nil {
+			log.Printf("node %d: load forward %d failed: %v", nodeID, forwardID, err)
+			continue
+		}
+		configs, err := h.buildForwardNodeServices(forward)
+		if err != nil {
+			log.Printf("node %d: build forward %d services failed: %v", nodeID, forwardID, err)
+			continue
+		}
+		for _, cfg := range configs {
+			if cfg.Node == nil || cfg.Node.ID != nodeID {
+				continue
+			}
+			if _, err := h.sendNodeCommand(nodeID, "AddService", cfg.Services, true, false); err != nil {
+				log.Printf("node %d: re-dispatch forward %d failed: %v", nodeID, forwardID, err)
+				continue
+			}
+			dispatched++
+		}
+	}
+
+	log.Printf("node %d connected: re-dispatched %d services", nodeID, dispatched)
+}
+
+// redeployTunnelOnNode sends the chains and relay services nodeID runs for
+// the tunnel, mirroring applyTunnelRuntime for a single node. It returns the
+// number of services sent.
+func (h *Handler) redeployTunnelOnNode(state *tunnelCreateState, nodeID int64) (int, error) {
+	sent := 0
+	for _, inNode := range state.InNodes {
+		if inNode.NodeID != nodeID {
+			continue
+		}
+		targets := state.OutNodes
+		if len(state.ChainHops) > 0 {
+			targets = state.ChainHops[0]
+		}
+		chainData, err := buildTunnelChainConfig(state.TunnelID, nodeID, targets, state.Nodes)
+		if err != nil {
+			return sent, err
+		}
+		if _, err := h.sendNodeCommand(nodeID, "AddChains", chainData, true, false); err != nil {
+			return sent, err
+		}
+	}
+
+	for i, hop := range state.ChainHops {
+		nextTargets := state.OutNodes
+		if i+1 < len(state.ChainHops) {
+			nextTargets = state.ChainHops[i+1]
+		}
+		for _, chainNode := range hop {
+			if chainNode.NodeID != nodeID {
+				continue
+			}
+			chainData, err := buildTunnelChainConfig(state.TunnelID, nodeID, nextTargets, state.Nodes)
+			if err != nil {
+				return sent, err
+			}
+			if _, err := h.sendNodeCommand(nodeID, "AddChains", chainData, true, false); err != nil {
+				return sent, err
+			}
+			serviceData := buildTunnelChainServiceConfig(state.TunnelID, chainNode, state.Nodes[nodeID])
+			if _, err := h.sendNodeCommand(nodeID, "AddService", serviceData, true, false); err != nil {
+				return sent, err
+			}
+			sent++
+		}
+	}
+
+	for _, outNode := range state.OutNodes {
+		if outNode.NodeID != nodeID {
+			continue
+		}
+		serviceData := buildTunnelChainServiceConfig(state.TunnelID, outNode, state.Nodes[nodeID])
+		if _, err := h.sendNodeCommand(nodeID, "AddService", serviceData, true, false); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go-backend/internal/store/sqlite"
+)
+
+// nodeSelectionStrategyConfigKey picks how a tunnel hop given as a list of
+// candidate nodes is assigned to one of them: least_loaded (the default),
+// random or round_robin.
+const nodeSelectionStrategyConfigKey = "node_selection_strategy"
+
+const (
+	nodeSelectionRandom     = "random"
+	nodeSelectionRoundRobin = "round_robin"
+)
+
+var errNoNodeCandidates = errors.New("没有可用的候选节点")
+
+// nodeCandidate is an online local node a tunnel hop may be assigned to.
+type nodeCandidate struct {
+	NodeID    int64
+	FreePorts int
+}
+
+// nodeSelector assigns a tunnel hop to one of its candidate nodes.
+type nodeSelector interface {
+	Select(candidates []*nodeCandidate) (*nodeCandidate, error)
+}
+
+// newNodeSelector returns the selector for a node_selection_strategy value.
+// Unknown values keep the least_loaded default.
+func newNodeSelector(strategy string) nodeSelector {
+	switch strings.ToLower(strings.TrimSpace(strategy)) {
+	case nodeSelectionRandom:
+		return &randomNodeSelector{rnd: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))}
+	case nodeSelectionRoundRobin:
+		return &roundRobinNodeSelector{}
+	default:
+		return leastLoadedNodeSelector{}
+	}
+}
+
+// loadNodeSelector builds the selector configured in
+// node_selection_strategy.
+func loadNodeSelector(repo *sqlite.Repository) nodeSelector {
+	strategy := ""
+	if repo != nil {
+		if cfg, err := repo.GetConfigByName(nodeSelectionStrategyConfigKey); err == nil && cfg != nil {
+			strategy = cfg.Value
+		}
+	}
+	return newNodeSelector(strategy)
+}
+
+// leastLoadedNodeSelector picks the candidate with the most free ports; ties
+// go to the earlier candidate.
+type leastLoadedNodeSelector struct{}
+
+func (leastLoadedNodeSelector) Select(candidates []*nodeCandidate) (*nodeCandidate, error) {
+	var best *nodeCandidate
+	for _, c := range candidates {
+		if c != nil && (best == nil || c.FreePorts > best.FreePorts) {
+			best = c
+		}
+	}
+	if best == nil {
+		return nil, errNoNodeCandidates
+	}
+	return best, nil
+}
+
+// randomNodeSelector picks a candidate uniformly at random.
+type randomNodeSelector struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (s *randomNodeSelector) Select(candidates []*nodeCandidate) (*nodeCandidate, error) {
+	if len(candidates) == 0 {
+		return nil, errNoNodeCandidates
+	}
+	s.mu.Lock()
+	i := s.rnd.IntN(len(candidates))
+	s.mu.Unlock()
+	return candidates[i], nil
+}
+
+// roundRobinNodeSelector cycles through the candidates. Each distinct
+// candidate set keeps its own offset, so hops drawing from different pools
+// do not disturb each other.
+type roundRobinNodeSelector struct {
+	offsets sync.Map // candidate set key -> *atomic.Int64
+}
+
+func (s *roundRobinNodeSelector) Select(candidates []*nodeCandidate) (*nodeCandidate, error) {
+	if len(candidates) == 0 {
+		return nil, errNoNodeCandidates
+	}
+	counter, _ := s.offsets.LoadOrStore(nodeCandidateSetKey(candidates), new(atomic.Int64))
+	n := counter.(*atomic.Int64).Add(1) - 1
+	return candidates[n%int64(len(candidates))], nil
+}
+
+func nodeCandidateSetKey(candidates []*nodeCandidate) string {
+	ids := make([]int64, 0, len(candidates))
+	for _, c := range candidates {
+		ids = append(ids, c.NodeID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// selectTunnelNode assigns a hop given as candidateNodeIds to one node.
+// Candidates that are offline, remote or already part of the tunnel are
+// skipped.
+func (h *Handler) selectTunnelNode(db sqlite.Execer, candidateIDs []int64, taken []int64, excludeTunnelID int64) (int64, error) {
+	skip := make(map[int64]struct{}, len(taken))
+	for _, id := range taken {
+		skip[id] = struct{}{}
+	}
+	candidates := make([]*nodeCandidate, 0, len(candidateIDs))
+	for _, id := range candidateIDs {
+		if _, ok := skip[id]; ok || id <= 0 {
+			continue
+		}
+		skip[id] = struct{}{}
+		var portRange string
+		var status, isRemote int
+		err := db.QueryRow(`SELECT port, status, COALESCE(is_remote, 0) FROM node WHERE id = ?`, id).Scan(&portRange, &status, &isRemote)
+		if err != nil {
+			continue
+		}
+		if status != 1 || isRemote == 1 {
+			continue
+		}
+		used, err := countNodeUsedPorts(db, id, excludeTunnelID)
+		if err != nil {
+			return 0, err
+		}
+		free := len(parsePortRangeSpec(portRange)) - used
+		if free <= 0 {
+			continue
+		}
+		candidates = append(candidates, &nodeCandidate{NodeID: id, FreePorts: free})
+	}
+	var selector nodeSelector = leastLoadedNodeSelector{}
+	if h.nodeSelector != nil {
+		selector = h.nodeSelector
+	}
+	picked, err := selector.Select(candidates)
+	if err != nil {
+		return 0, err
+	}
+	return picked.NodeID, nil
+}
+
+// countNodeUsedPorts counts the ports tunnels and forwards hold on a node.
+func countNodeUsedPorts(db sqlite.Execer, nodeID, excludeTunnelID int64) (int, error) {
+	var chainPorts, forwardPorts int
+	if err := db.QueryRow(`SELECT COUNT(DISTINCT port) FROM chain_tunnel WHERE node_id = ? AND port IS NOT NULL AND tunnel_id != ?`, nodeID, excludeTunnelID).Scan(&chainPorts); err != nil {
+		return 0, err
+	}
+	if err := db.QueryRow(`SELECT COUNT(DISTINCT port) FROM forward_port WHERE node_id = ?`, nodeID).Scan(&forwardPorts); err != nil {
+		return 0, err
+	}
+	return chainPorts + forwardPorts, nil
+}
+
+// resolveTunnelHopNodeID returns the node of an exit or relay entry. An
+// entry without nodeId but with candidateNodeIds is auto-assigned by the
+// configured selector, skipping the nodes the tunnel already uses.
+func (h *Handler) resolveTunnelHopNodeID(db sqlite.Execer, item map[string]interface{}, taken []int64, excludeTunnelID int64) (int64, error) {
+	if nodeID := asInt64(item["nodeId"], 0); nodeID > 0 {
+		return nodeID, nil
+	}
+	raw := asAnySlice(item["candidateNodeIds"])
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	candidateIDs := make([]int64, 0, len(raw))
+	for _, v := range raw {
+		candidateIDs = append(candidateIDs, asInt64(v, 0))
+	}
+	return h.selectTunnelNode(db, candidateIDs, taken, excludeTunnelID)
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/sqlite"
+)
+
+func testNodeCandidates() []*nodeCandidate {
+	return []*nodeCandidate{
+		{NodeID: 1, FreePorts: 40},
+		{NodeID: 2, FreePorts: 90},
+		{NodeID: 3, FreePorts: 10},
+	}
+}
+
+func selectSix(t *testing.T, s nodeSelector) []int64 {
+	t.Helper()
+	picked := make([]int64, 0, 6)
+	for i := 0; i < 6; i++ {
+		c, err := s.Select(testNodeCandidates())
+		if err != nil {
+			t.Fatalf("select %d: %v", i, err)
+		}
+		picked = append(picked, c.NodeID)
+	}
+	return picked
+}
+
+func TestLeastLoadedNodeSelectorPicksMostFreePorts(t *testing.T) {
+	for i, id := range selectSix(t, newNodeSelector("least_loaded")) {
+		if id != 2 {
+			t.Fatalf("select %d: expected node 2, got %d", i, id)
+		}
+	}
+}
+
+func TestRandomNodeSelectorUsesEveryCandidate(t *testing.T) {
+	s := &randomNodeSelector{rnd: rand.New(rand.NewPCG(1, 2))}
+	seen := map[int64]int{}
+	for _, id := range selectSix(t, s) {
+		seen[id]++
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected all 3 candidates to be picked, got %v", seen)
+	}
+}
+
+func TestRoundRobinNodeSelectorCyclesInOrder(t *testing.T) {
+	got := selectSix(t, newNodeSelector("round_robin"))
+	want := []int64{1, 2, 3, 1, 2, 3}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestNodeSelectorRejectsEmptyCandidates(t *testing.T) {
+	for _, strategy := range []string{"least_loaded", "random", "round_robin"} {
+		if _, err := newNodeSelector(strategy).Select(nil); err == nil {
+			t.Fatalf("%s: expected an error without candidates", strategy)
+		}
+	}
+}
+
+func TestPrepareTunnelCreateStateAssignsCandidateExit(t *testing.T) {
+	repo, err := sqlite.Open(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatalf("open repo: %v", err)
+	}
+	defer repo.Close()
+
+	h := &Handler{repo: repo, nodeSelector: newNodeSelector("round_robin")}
+	now := time.Now().UnixMilli()
+	insertNode := func(name string, status int) int64 {
+		res, execErr := repo.DB().Exec(`
+			INSERT INTO node(name, secret, server_ip, port, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx, is_remote)
+			VALUES(?, ?, '10.0.0.1', '35000-35010', 1, 1, 1, ?, ?, ?, '[::]', '[::]', 0, 0)
+		`, name, name+"-secret", now, now, status)
+		if execErr != nil {
+			t.Fatalf("insert node %s: %v", name, execErr)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+	entryID := insertNode("entry", 1)
+	outA := insertNode("out-a", 1)
+	offline := insertNode("out-offline", 0)
+	outB := insertNode("out-b", 1)
+
+	prepare := func() int64 {
+		t.Helper()
+		req := map[string]interface{}{
+			"inNodeId": []interface{}{
+				map[string]interface{}{"nodeId": float64(entryID)},
+			},
+			"outNodeId": []interface{}{
+				map[string]interface{}{"candidateNodeIds": []interface{}{float64(entryID), float64(outA), float64(offline), float64(outB)}},
+			},
+		}
+		state, err := h.prepareTunnelCreateState(context.Background(), req, 2, 0)
+		if err != nil {
+			t.Fatalf("prepare state: %v", err)
+		}
+		if len(state.OutNodes) != 1 || state.OutNodes[0].Port <= 0 {
+			t.Fatalf("expected one assigned exit with a port, got %+v", state.OutNodes)
+		}
+		return state.OutNodes[0].NodeID
+	}
+
+	// The entry node and the offline node are never candidates.
+	if first, second := prepare(), prepare(); first != outA || second != outB {
+		t.Fatalf("expected exits %d then %d, got %d then %d", outA, outB, first, second)
+	}
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// errNoPanelAddress means the panel address nodes connect back to has not
+// been configured, so no install command can be built yet.
+var errNoPanelAddress = errors.New("请先前往网站配置中设置ip")
+
+// validateNodePortRange checks a node port range such as
+// "10000-20000,30000": comma separated ports or inclusive ranges within
+// 1-65535. parsePortRangeSpec silently skips bad parts, so input is checked
+// here before it is stored.
+func validateNodePortRange(spec string) error {
+	if strings.TrimSpace(spec) == "" {
+		return errors.New("端口范围不能为空")
+	}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		start, end, isRange := strings.Cut(part, "-")
+		if !isRange {
+			end = start
+		}
+		lo, err1 := strconv.Atoi(strings.TrimSpace(start))
+		hi, err2 := strconv.Atoi(strings.TrimSpace(end))
+		if err1 != nil || err2 != nil || lo < 1 || hi > 65535 || lo > hi {
+			return fmt.Errorf("端口范围格式错误: %q", part)
+		}
+	}
+	return nil
+}
+
+// validateListenAddr checks a node listen address: an IPv4 or IPv6 literal,
+// the latter optionally in brackets, e.g. "[::]" or "0.0.0.0". Empty means
+// the default.
+func validateListenAddr(addr string) error {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if net.ParseIP(host) == nil || (host != addr && !strings.Contains(host, ":")) {
+		return fmt.Errorf("监听地址格式错误: %q", addr)
+	}
+	return nil
+}
+
+// validateNodeInput checks the port range and listen addresses of a node
+// create or update request.
+func validateNodeInput(req map[string]interface{}) error {
+	if port := asString(req["port"]); port != "" {
+		if err := validateNodePortRange(port); err != nil {
+			return err
+		}
+	}
+	for _, key := range []string{"tcpListenAddr", "udpListenAddr"} {
+		if err := validateListenAddr(asString(req[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeInstallCommand returns the one-line agent install command for a node
+// with the given secret.
+func (h *Handler) nodeInstallCommand(secret string) (string, error) {
+	var panelAddr string
+	if err := h.repo.DB().QueryRow(`SELECT value FROM vite_config WHERE name = 'ip' LIMIT 1`).Scan(&panelAddr); err != nil {
+		if err == sql.ErrNoRows {
+			return "", errNoPanelAddress
+		}
+		return "", err
+	}
+	return fmt.Sprintf("curl -L https://gcode.hostcentral.cc/https://github.com/Sagit-chu/flvx/releases/latest/download/install.sh -o ./install.sh && chmod +x ./install.sh && ./install.sh -a %s -s %s", processServerAddress(panelAddr), secret), nil
+}
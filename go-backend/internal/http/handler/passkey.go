@@ -0,0 +1,427 @@
+package handler
+
+import (
+	"crypto/rand"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store"
+	"go-backend/internal/store/sqlite"
+	"go-backend/internal/webauthn"
+)
+
+const (
+	webauthnRPIDConfigKey   = "webauthn_rp_id"
+	webauthnOriginConfigKey = "webauthn_origin"
+
+	passkeyCeremonyTTL    = 5 * time.Minute
+	maxPasskeyNameLength  = 50
+	passkeyTimeoutMillis  = int64(passkeyCeremonyTTL / time.Millisecond)
+	defaultPasskeyName    = "Passkey"
+	passkeyChallengeBytes = 32
+)
+
+// passkeyCeremonies remembers the challenges handed out by the begin
+// endpoints until the matching finish call consumes them.
+type passkeyCeremonies struct {
+	mu      sync.Mutex
+	pending map[string]passkeyCeremony
+}
+
+// passkeyCeremony is a challenge in flight. userID is the registering user,
+// or, for logins, the user the credentials were offered for (0 when the
+// browser may pick any passkey).
+type passkeyCeremony struct {
+	userID    int64
+	register  bool
+	expiresAt time.Time
+}
+
+func newPasskeyCeremonies() *passkeyCeremonies {
+	return &passkeyCeremonies{pending: make(map[string]passkeyCeremony)}
+}
+
+func (c *passkeyCeremonies) start(ceremony passkeyCeremony, now time.Time) (string, error) {
+	raw := make([]byte, passkeyChallengeBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	challenge := webauthn.Encoding.EncodeToString(raw)
+	ceremony.expiresAt = now.Add(passkeyCeremonyTTL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range c.pending {
+		if !v.expiresAt.After(now) {
+			delete(c.pending, k)
+		}
+	}
+	c.pending[challenge] = ceremony
+	return challenge, nil
+}
+
+// take returns the ceremony for challenge and forgets it, so every
+// challenge answers at most one attempt.
+func (c *passkeyCeremonies) take(challenge string, register bool, now time.Time) (passkeyCeremony, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ceremony, ok := c.pending[challenge]
+	delete(c.pending, challenge)
+	if !ok || ceremony.register != register || !ceremony.expiresAt.After(now) {
+		return passkeyCeremony{}, false
+	}
+	return ceremony, true
+}
+
+type passkeyRegisterFinishRequest struct {
+	Name              string `json:"name"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+type passkeyLoginBeginRequest struct {
+	Username string `json:"username"`
+}
+
+type passkeyLoginFinishRequest struct {
+	CredentialID      string `json:"credentialId"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+}
+
+type passkeyDeleteRequest struct {
+	ID int64 `json:"id"`
+}
+
+// relyingParty is the panel as WebAuthn sees it. Without configuration it
+// is derived from the host the browser used to reach the panel.
+func (h *Handler) relyingParty(r *http.Request) webauthn.RelyingParty {
+	rp := webauthn.RelyingParty{
+		ID:     h.configValue(webauthnRPIDConfigKey),
+		Origin: strings.TrimRight(h.configValue(webauthnOriginConfigKey), "/"),
+	}
+	if rp.Origin == "" {
+		scheme := "http"
+		if requestIsHTTPS(r) {
+			scheme = "https"
+		}
+		rp.Origin = scheme + "://" + r.Host
+	}
+	if rp.ID == "" {
+		rp.ID = r.Host
+		if host, _, err := net.SplitHostPort(r.Host); err == nil {
+			rp.ID = host
+		}
+	}
+	return rp
+}
+
+func credentialDescriptors(creds []sqlite.WebAuthnCredential) []map[string]string {
+	out := make([]map[string]string, 0, len(creds))
+	for _, c := range creds {
+		out = append(out, map[string]string{"type": "public-key", "id": c.CredentialID})
+	}
+	return out
+}
+
+// passkeyRegisterBegin returns the options for navigator.credentials.create.
+func (h *Handler) passkeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	user, err := h.repo.GetUserByID(userID)
+	if store.IsNotFound(err) {
+		response.WriteJSON(w, response.ErrDefault("用户不存在"))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	creds, err := h.repo.ListWebAuthnCredentials(userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	challenge, err := h.passkeys.start(passkeyCeremony{userID: userID, register: true}, time.Now())
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	rp := h.relyingParty(r)
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"challenge": challenge,
+		"rp":        map[string]string{"id": rp.ID, "name": h.totpIssuer()},
+		"user": map[string]string{
+			"id":          webauthn.Encoding.EncodeToString([]byte(strconv.FormatInt(userID, 10))),
+			"name":        user.User,
+			"displayName": user.User,
+		},
+		"pubKeyCredParams": []map[string]interface{}{
+			{"type": "public-key", "alg": webauthn.AlgES256},
+			{"type": "public-key", "alg": webauthn.AlgRS256},
+		},
+		"timeout":            passkeyTimeoutMillis,
+		"attestation":        "none",
+		"excludeCredentials": credentialDescriptors(creds),
+		"authenticatorSelection": map[string]string{
+			"residentKey":      "preferred",
+			"userVerification": "preferred",
+		},
+	}))
+}
+
+// passkeyRegisterFinish verifies the authenticator's response and stores
+// the new credential.
+func (h *Handler) passkeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	var req passkeyRegisterFinishRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	name := defaultString(strings.TrimSpace(req.Name), defaultPasskeyName)
+	if len([]rune(name)) > maxPasskeyNameLength {
+		response.WriteJSON(w, response.ErrDefault("名称过长"))
+		return
+	}
+	clientDataJSON, err1 := webauthn.Encoding.DecodeString(req.ClientDataJSON)
+	attestationObject, err2 := webauthn.Encoding.DecodeString(req.AttestationObject)
+	if err1 != nil || err2 != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+
+	challenge, err := webauthn.ChallengeOf(clientDataJSON)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("通行密钥注册失败"))
+		return
+	}
+	ceremony, ok := h.passkeys.take(challenge, true, time.Now())
+	if !ok || ceremony.userID != userID {
+		response.WriteJSON(w, response.ErrDefault("请求已过期，请重试"))
+		return
+	}
+	cred, err := h.relyingParty(r).VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		log.Printf("passkey registration for user %d: %v", userID, err)
+		response.WriteJSON(w, response.ErrDefault("通行密钥注册失败"))
+		return
+	}
+
+	credentialID := webauthn.Encoding.EncodeToString(cred.ID)
+	existing, err := h.repo.GetWebAuthnCredential(credentialID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if existing != nil {
+		response.WriteJSON(w, response.ErrDefault("该通行密钥已注册"))
+		return
+	}
+	record := &sqlite.WebAuthnCredential{
+		UserID:       userID,
+		Name:         name,
+		CredentialID: credentialID,
+		PublicKey:    webauthn.Encoding.EncodeToString(cred.PublicKey),
+		SignCount:    cred.SignCount,
+		CreatedTime:  time.Now().UnixMilli(),
+	}
+	if record.ID, err = h.repo.CreateWebAuthnCredential(record); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(record))
+}
+
+func (h *Handler) passkeyList(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	creds, err := h.repo.ListWebAuthnCredentials(userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(creds))
+}
+
+func (h *Handler) passkeyDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	var req passkeyDeleteRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	deleted, err := h.repo.DeleteWebAuthnCredential(req.ID, userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if !deleted {
+		response.WriteJSON(w, response.ErrDefault("通行密钥不存在"))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// passkeyLoginBegin returns the options for navigator.credentials.get. With
+// a username it offers that user's passkeys, and reports available=false
+// when there are none so the login page stays on password and captcha;
+// without one the browser may offer any discoverable passkey.
+func (h *Handler) passkeyLoginBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req passkeyLoginBeginRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+
+	ceremony := passkeyCeremony{}
+	creds := []sqlite.WebAuthnCredential{}
+	if username := strings.TrimSpace(req.Username); username != "" {
+		user, err := h.repo.GetUserByUsername(username)
+		if err != nil && !store.IsNotFound(err) {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		if user != nil {
+			if creds, err = h.repo.ListWebAuthnCredentials(user.ID); err != nil {
+				response.WriteJSON(w, response.Err(-2, err.Error()))
+				return
+			}
+			ceremony.userID = user.ID
+		}
+		if len(creds) == 0 {
+			response.WriteJSON(w, response.OK(map[string]interface{}{"available": false}))
+			return
+		}
+	}
+
+	challenge, err := h.passkeys.start(ceremony, time.Now())
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"available":        true,
+		"challenge":        challenge,
+		"rpId":             h.relyingParty(r).ID,
+		"timeout":          passkeyTimeoutMillis,
+		"userVerification": "preferred",
+		"allowCredentials": credentialDescriptors(creds),
+	}))
+}
+
+// passkeyLoginFinish verifies the assertion and logs the credential's owner
+// in. A passkey is a login on its own: it needs neither the password nor
+// the TOTP code.
+func (h *Handler) passkeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req passkeyLoginFinishRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	clientDataJSON, err1 := webauthn.Encoding.DecodeString(req.ClientDataJSON)
+	authData, err2 := webauthn.Encoding.DecodeString(req.AuthenticatorData)
+	signature, err3 := webauthn.Encoding.DecodeString(req.Signature)
+	if err1 != nil || err2 != nil || err3 != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+
+	now := time.Now()
+	challenge, err := webauthn.ChallengeOf(clientDataJSON)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault("通行密钥验证失败"))
+		return
+	}
+	ceremony, ok := h.passkeys.take(challenge, false, now)
+	if !ok {
+		response.WriteJSON(w, response.ErrDefault("请求已过期，请重试"))
+		return
+	}
+	record, err := h.repo.GetWebAuthnCredential(req.CredentialID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if record == nil || (ceremony.userID != 0 && record.UserID != ceremony.userID) {
+		response.WriteJSON(w, response.ErrDefault("通行密钥验证失败"))
+		return
+	}
+	publicKey, err := webauthn.Encoding.DecodeString(record.PublicKey)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	cred := &webauthn.Credential{PublicKey: publicKey, SignCount: record.SignCount}
+	signCount, err := h.relyingParty(r).VerifyAssertion(cred, challenge, clientDataJSON, authData, signature)
+	if err != nil {
+		log.Printf("passkey login with credential %d: %v", record.ID, err)
+		response.WriteJSON(w, response.ErrDefault("通行密钥验证失败"))
+		return
+	}
+	updated, err := h.repo.UpdateWebAuthnSignCount(record.ID, record.SignCount, signCount, now.UnixMilli())
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if !updated {
+		response.WriteJSON(w, response.ErrDefault("通行密钥验证失败"))
+		return
+	}
+
+	user, err := h.repo.GetUserByID(record.UserID)
+	if store.IsNotFound(err) {
+		response.WriteJSON(w, response.ErrDefault("通行密钥验证失败"))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if user.Status == 0 {
+		response.WriteJSON(w, response.ErrDefault("账号被停用"))
+		return
+	}
+	h.writeLoginSuccess(w, r, user, false)
+}
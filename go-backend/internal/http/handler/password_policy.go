@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"strconv"
+
+	"go-backend/internal/security"
+)
+
+const (
+	passwordMinLengthConfigKey      = "password_min_length"
+	passwordMinCharClassesConfigKey = "password_min_char_classes"
+	passwordRejectCommonConfigKey   = "password_reject_common"
+
+	defaultPasswordMinLength = 6
+)
+
+// passwordPolicy reads the policy for new passwords from vite_config.
+// Unparseable values fall back to the defaults rather than to no policy.
+func (h *Handler) passwordPolicy() security.PasswordPolicy {
+	policy := security.PasswordPolicy{
+		MinLength:      defaultPasswordMinLength,
+		MinCharClasses: 1,
+		RejectCommon:   h.configValue(passwordRejectCommonConfigKey) != "false",
+	}
+	if n, err := strconv.Atoi(h.configValue(passwordMinLengthConfigKey)); err == nil && n > 0 {
+		policy.MinLength = n
+	}
+	if n, err := strconv.Atoi(h.configValue(passwordMinCharClassesConfigKey)); err == nil && n >= 1 && n <= 4 {
+		policy.MinCharClasses = n
+	}
+	return policy
+}
@@ -0,0 +1,358 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/mail"
+	"go-backend/internal/secrets"
+	"go-backend/internal/security"
+	"go-backend/internal/store"
+)
+
+const (
+	smtpHostConfigKey       = "smtp_host"
+	smtpPortConfigKey       = "smtp_port"
+	smtpUsernameConfigKey   = "smtp_username"
+	smtpPasswordConfigKey   = "smtp_password"
+	smtpFromConfigKey       = "smtp_from"
+	smtpSkipVerifyConfigKey = "smtp_skip_verify"
+
+	// passwordResetURLConfigKey is the page users open to set the new
+	// password; the token is appended to it.
+	passwordResetURLConfigKey = "password_reset_url"
+	passwordResetTTLConfigKey = "password_reset_ttl_minutes"
+
+	defaultPasswordResetTTL = 30 * time.Minute
+	// passwordResetInterval is the minimum time between two reset mails to
+	// the same user.
+	passwordResetInterval = time.Minute
+	mailSendTimeout       = 30 * time.Second
+	secretLookupTimeout   = 10 * time.Second
+)
+
+var errInvalidResetToken = errors.New("重置链接无效或已过期")
+
+type passwordResetRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type passwordResetConfirmRequest struct {
+	Token           string `json:"token"`
+	NewPassword     string `json:"newPassword"`
+	ConfirmPassword string `json:"confirmPassword"`
+}
+
+type userEmailUpdateRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// userThrottle lets one notification through per user and interval.
+type userThrottle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	sent     map[int64]time.Time
+}
+
+func newUserThrottle(interval time.Duration) *userThrottle {
+	return &userThrottle{interval: interval, sent: make(map[int64]time.Time)}
+}
+
+// allow reports whether userID may be notified at now and records it if so.
+func (t *userThrottle) allow(userID int64, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, at := range t.sent {
+		if now.Sub(at) >= t.interval {
+			delete(t.sent, id)
+		}
+	}
+	if _, ok := t.sent[userID]; ok {
+		return false
+	}
+	t.sent[userID] = now
+	return true
+}
+
+// smtpConfig returns nil when no relay is configured.
+func (h *Handler) smtpConfig() *mail.Config {
+	cfg := &mail.Config{
+		Host:               h.configValue(smtpHostConfigKey),
+		Username:           h.secretValue(secrets.SMTPUsername, smtpUsernameConfigKey),
+		Password:           h.secretValue(secrets.SMTPPassword, smtpPasswordConfigKey),
+		From:               h.configValue(smtpFromConfigKey),
+		InsecureSkipVerify: h.configValue(smtpSkipVerifyConfigKey) == "true",
+	}
+	if cfg.Host == "" || cfg.From == "" {
+		return nil
+	}
+	cfg.Port, _ = strconv.Atoi(h.configValue(smtpPortConfigKey))
+	return cfg
+}
+
+// secretValue reads name from the secrets provider, falling back to the
+// configKey row of the config table.
+func (h *Handler) secretValue(name, configKey string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), secretLookupTimeout)
+	defer cancel()
+	v, err := secrets.Lookup(ctx, h.secrets, name, "")
+	if err != nil {
+		log.Printf("read %s from secrets provider: %v", name, err)
+	}
+	if v == "" {
+		v = h.configValue(configKey)
+	}
+	return v
+}
+
+func (h *Handler) passwordResetTTL() time.Duration {
+	if n, err := strconv.Atoi(h.configValue(passwordResetTTLConfigKey)); err == nil && n > 0 {
+		return time.Duration(n) * time.Minute
+	}
+	return defaultPasswordResetTTL
+}
+
+// passwordResetToken signs userID and the expiry together with the current
+// password hash, so the token stops working once the password changes and
+// can only ever be used once.
+func (h *Handler) passwordResetToken(userID int64, pwdHash string, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d", userID, expires.Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(h.passwordResetMAC(payload, pwdHash))
+}
+
+func (h *Handler) passwordResetMAC(payload, pwdHash string) []byte {
+	mac := hmac.New(sha256.New, []byte(h.jwtSecret))
+	mac.Write([]byte("password-reset\n" + payload + "\n" + pwdHash))
+	return mac.Sum(nil)
+}
+
+// verifyPasswordResetToken returns the user the token was issued to.
+func (h *Handler) verifyPasswordResetToken(token string, now time.Time) (int64, string, error) {
+	encodedPayload, encodedMAC, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok {
+		return 0, "", errInvalidResetToken
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return 0, "", errInvalidResetToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return 0, "", errInvalidResetToken
+	}
+	payload := string(payloadBytes)
+	rawID, rawExp, ok := strings.Cut(payload, ".")
+	if !ok {
+		return 0, "", errInvalidResetToken
+	}
+	userID, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil || userID <= 0 {
+		return 0, "", errInvalidResetToken
+	}
+	exp, err := strconv.ParseInt(rawExp, 10, 64)
+	if err != nil || now.Unix() >= exp {
+		return 0, "", errInvalidResetToken
+	}
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil {
+		return 0, "", errInvalidResetToken
+	}
+	if !hmac.Equal(sig, h.passwordResetMAC(payload, user.Pwd)) {
+		return 0, "", errInvalidResetToken
+	}
+	return userID, user.User, nil
+}
+
+// userPasswordResetRequest mails a reset link to the account named by
+// username or email. It answers the same way whether or not the account
+// exists, so it cannot be used to probe for users.
+func (h *Handler) userPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req passwordResetRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	username := strings.TrimSpace(req.Username)
+	email := strings.TrimSpace(req.Email)
+	if username == "" && email == "" {
+		response.WriteJSON(w, response.ErrDefault("用户名或邮箱不能为空"))
+		return
+	}
+	smtpCfg := h.smtpConfig()
+	if smtpCfg == nil {
+		response.WriteJSON(w, response.ErrDefault("未配置邮件服务，请联系管理员重置密码"))
+		return
+	}
+
+	var userID int64
+	if username != "" {
+		if user, err := h.repo.GetUserByUsername(username); err == nil {
+			userID = user.ID
+		}
+	} else if user, err := h.repo.GetUserByEmail(email); err == nil && user != nil {
+		userID = user.ID
+	}
+	if userID > 0 {
+		h.sendPasswordResetMail(*smtpCfg, userID)
+	}
+	response.WriteJSON(w, response.OK("如果账号存在且已绑定邮箱，重置邮件已发送"))
+}
+
+// sendPasswordResetMail mails a reset link to userID in the background so
+// the response time does not reveal whether the account has an address.
+func (h *Handler) sendPasswordResetMail(cfg mail.Config, userID int64) {
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil || user.Status != 1 {
+		return
+	}
+	email, err := h.repo.GetUserEmail(userID)
+	if err != nil || email == "" {
+		return
+	}
+	now := time.Now()
+	if !h.resetThrottle.allow(userID, now) {
+		return
+	}
+	ttl := h.passwordResetTTL()
+	token := h.passwordResetToken(userID, user.Pwd, now.Add(ttl))
+	link := token
+	if base := h.configValue(passwordResetURLConfigKey); base != "" {
+		link = base + token
+	}
+	msg := mail.Message{
+		To:      email,
+		Subject: "重置密码",
+		Body: fmt.Sprintf("您好 %s，\n\n我们收到了重置您账号密码的请求。请在 %d 分钟内使用以下链接或令牌设置新密码：\n\n%s\n\n如果这不是您本人的操作，请忽略此邮件，您的密码不会改变。\n",
+			user.User, int(ttl/time.Minute), link),
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		if err := mail.Send(ctx, cfg, msg); err != nil {
+			log.Printf("send password reset mail to user %d: %v", userID, err)
+		}
+	}()
+}
+
+func (h *Handler) userPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req passwordResetConfirmRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if strings.TrimSpace(req.NewPassword) == "" {
+		response.WriteJSON(w, response.ErrDefault("新密码不能为空"))
+		return
+	}
+	if req.NewPassword != req.ConfirmPassword {
+		response.WriteJSON(w, response.ErrDefault("新密码和确认密码不匹配"))
+		return
+	}
+	userID, username, err := h.verifyPasswordResetToken(req.Token, time.Now())
+	if err != nil {
+		h.recordAuthFailure(r, "password reset")
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	if err := h.passwordPolicy().Check(req.NewPassword); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	passwordHash, err := security.HashPassword(req.NewPassword)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err := h.repo.UpdateUserNameAndPassword(userID, username, passwordHash, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.revokeUserSessions(userID)
+	h.writeAuditLog(r, "password_reset", "user", userID, username)
+	h.notifySecurityEvent(r, userID, username, eventSecurityPasswordChanged, "通过邮件重置")
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func (h *Handler) userEmailGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	userID, _, err := userRoleFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	email, err := h.repo.GetUserEmail(userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{"email": email}))
+}
+
+// userEmailUpdate sets the address password reset mails go to. The current
+// password is required, since the address can be used to take the account
+// over.
+func (h *Handler) userEmailUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req userEmailUpdateRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	userID, _, err := userRoleFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email != "" && !mail.ValidAddress(email) {
+		response.WriteJSON(w, response.ErrDefault("邮箱格式不正确"))
+		return
+	}
+	user, err := h.repo.GetUserByID(userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if ok, _ := security.VerifyPassword(user.Pwd, req.Password); !ok {
+		response.WriteJSON(w, response.ErrDefault("当前密码错误"))
+		return
+	}
+	if err := h.repo.UpdateUserEmail(userID, email, time.Now().UnixMilli()); err != nil {
+		if store.IsConflict(err) {
+			response.WriteJSON(w, response.ErrDefault("该邮箱已被其他账号使用"))
+			return
+		}
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.writeAuditLog(r, "user_email_update", "user", userID, email)
+	response.WriteJSON(w, response.OK(map[string]interface{}{"email": email}))
+}
@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store"
+	"go-backend/internal/store/sqlite"
+)
+
+type peerShareRuntimeCreateRequest struct {
+	ShareID       int64  `json:"shareId"`
+	NodeID        int64  `json:"nodeId"`
+	ReservationID string `json:"reservationId"`
+	ResourceKey   string `json:"resourceKey"`
+	BindingID     string `json:"bindingId"`
+	Role          string `json:"role"`
+	ServiceName   string `json:"serviceName"`
+	Protocol      string `json:"protocol"`
+	Strategy      string `json:"strategy"`
+	Port          int    `json:"port"`
+	Target        string `json:"target"`
+}
+
+type peerShareRuntimeListRequest struct {
+	ShareID  int64 `json:"shareId"`
+	Page     int   `json:"page"`
+	PageSize int   `json:"pageSize"`
+}
+
+type peerShareRuntimeItem struct {
+	ID            int64  `json:"id"`
+	ShareID       int64  `json:"shareId"`
+	NodeID        int64  `json:"nodeId"`
+	ConsumerID    string `json:"consumerId"`
+	ReservationID string `json:"reservationId"`
+	ResourceKey   string `json:"resourceKey"`
+	BindingID     string `json:"bindingId"`
+	Role          string `json:"role"`
+	ChainName     string `json:"chainName"`
+	ServiceName   string `json:"serviceName"`
+	Protocol      string `json:"protocol"`
+	Strategy      string `json:"strategy"`
+	Port          int    `json:"port"`
+	Target        string `json:"target"`
+	Applied       int    `json:"applied"`
+	Status        int    `json:"status"`
+	CreatedTime   int64  `json:"createdTime"`
+	UpdatedTime   int64  `json:"updatedTime"`
+}
+
+// adminPeerShareRuntimeCreate records a runtime binding by hand when the
+// automatic reserve/apply path failed. The port is checked against the share
+// the same way a reservation is; a row naming a service is taken as already
+// applied on the node.
+func (h *Handler) adminPeerShareRuntimeCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("Invalid method"))
+		return
+	}
+	var req peerShareRuntimeCreateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("Invalid JSON"))
+		return
+	}
+	resourceKey := strings.TrimSpace(req.ResourceKey)
+	if req.ShareID <= 0 || resourceKey == "" {
+		response.WriteJSON(w, response.ErrDefault("shareId and resourceKey are required"))
+		return
+	}
+	role := strings.ToLower(strings.TrimSpace(req.Role))
+	if role != "middle" && role != "exit" {
+		response.WriteJSON(w, response.ErrDefault("Invalid role"))
+		return
+	}
+
+	share, err := h.repo.GetPeerShare(req.ShareID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if share == nil {
+		response.WriteJSON(w, response.ErrDefault("Share not found"))
+		return
+	}
+	if req.NodeID > 0 && req.NodeID != share.NodeID {
+		response.WriteJSON(w, response.ErrDefault("Node does not belong to share"))
+		return
+	}
+	existing, err := h.repo.GetPeerShareRuntimeByResourceKey(share.ID, "", resourceKey)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if existing != nil && existing.Status == 1 {
+		response.WriteJSON(w, response.ErrDefault("Resource key already bound"))
+		return
+	}
+
+	tx, err := h.repo.DB().Begin()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+	ctx := store.WithTx(r.Context(), tx)
+	if share, err = h.repo.LockPeerShareTx(ctx, share.ID); err != nil || share == nil {
+		response.WriteJSON(w, response.ErrDefault("Share not found"))
+		return
+	}
+	port, err := h.pickPeerSharePort(ctx, share, req.Port)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	runtime := &sqlite.PeerShareRuntime{
+		ShareID:       share.ID,
+		NodeID:        share.NodeID,
+		ReservationID: defaultString(strings.TrimSpace(req.ReservationID), randomToken(24)),
+		ResourceKey:   resourceKey,
+		BindingID:     strings.TrimSpace(req.BindingID),
+		Role:          role,
+		ServiceName:   strings.TrimSpace(req.ServiceName),
+		Protocol:      defaultString(req.Protocol, "tls"),
+		Strategy:      defaultString(req.Strategy, "round"),
+		Port:          port,
+		Target:        req.Target,
+		Status:        1,
+		CreatedTime:   now,
+		UpdatedTime:   now,
+	}
+	if runtime.ServiceName != "" {
+		runtime.Applied = 1
+	}
+	if existing != nil {
+		runtime.ID = existing.ID
+		runtime.CreatedTime = existing.CreatedTime
+		err = h.repo.UpdatePeerShareRuntimeTx(ctx, runtime)
+	} else {
+		err = h.repo.CreatePeerShareRuntimeTx(ctx, runtime)
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if created, err := h.repo.GetPeerShareRuntimeByResourceKey(share.ID, "", resourceKey); err == nil && created != nil {
+		runtime = created
+	}
+	response.WriteJSON(w, response.OK(newPeerShareRuntimeItem(*runtime)))
+}
+
+// adminPeerShareRuntimeDelete expires a runtime row and throttles its
+// service on the node to 0 bps so the binding stops carrying traffic.
+func (h *Handler) adminPeerShareRuntimeDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("Invalid method"))
+		return
+	}
+	var req deletePeerShareRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("Invalid JSON"))
+		return
+	}
+	runtime, err := h.repo.GetPeerShareRuntimeByID(req.ID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if runtime == nil {
+		response.WriteJSON(w, response.ErrDefault("Runtime not found"))
+		return
+	}
+	if err := h.repo.MarkPeerShareRuntimeReleased(runtime.ID, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if strings.TrimSpace(runtime.ServiceName) != "" {
+		_, _ = h.sendNodeCommand(runtime.NodeID, "ThrottleService", map[string]interface{}{
+			"services": []string{runtime.ServiceName},
+			"bps":      0,
+		}, false, true)
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// adminPeerShareRuntimeList pages through every runtime row of a share,
+// expired ones included.
+func (h *Handler) adminPeerShareRuntimeList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("Invalid method"))
+		return
+	}
+	var req peerShareRuntimeListRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("Invalid JSON"))
+		return
+	}
+	if req.ShareID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("shareId is required"))
+		return
+	}
+	runtimes, total, err := h.repo.ListPeerShareRuntimesPage(req.ShareID, req.Page, req.PageSize)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	items := make([]peerShareRuntimeItem, 0, len(runtimes))
+	for _, runtime := range runtimes {
+		items = append(items, newPeerShareRuntimeItem(runtime))
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"shareId": req.ShareID,
+		"page":    req.Page,
+		"total":   total,
+		"list":    items,
+	}))
+}
+
+func newPeerShareRuntimeItem(rt sqlite.PeerShareRuntime) peerShareRuntimeItem {
+	return peerShareRuntimeItem{
+		ID:            rt.ID,
+		ShareID:       rt.ShareID,
+		NodeID:        rt.NodeID,
+		ConsumerID:    rt.ConsumerID,
+		ReservationID: rt.ReservationID,
+		ResourceKey:   rt.ResourceKey,
+		BindingID:     rt.BindingID,
+		Role:          rt.Role,
+		ChainName:     rt.ChainName,
+		ServiceName:   rt.ServiceName,
+		Protocol:      rt.Protocol,
+		Strategy:      rt.Strategy,
+		Port:          rt.Port,
+		Target:        rt.Target,
+		Applied:       rt.Applied,
+		Status:        rt.Status,
+		CreatedTime:   rt.CreatedTime,
+		UpdatedTime:   rt.UpdatedTime,
+	}
+}
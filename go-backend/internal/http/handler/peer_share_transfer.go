@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+// peerShareTransferItem is a share as exported for another panel. Usage
+// counters and local IDs are left out; the token is kept so consumers do
+// not have to re-import the share.
+type peerShareTransferItem struct {
+	Name           string   `json:"name"`
+	NodeID         int64    `json:"nodeId"`
+	Token          string   `json:"token"`
+	MaxBandwidth   int64    `json:"maxBandwidth"`
+	ExpiryTime     int64    `json:"expiryTime"`
+	PortRangeStart int      `json:"portRangeStart"`
+	PortRangeEnd   int      `json:"portRangeEnd"`
+	IsActive       int      `json:"isActive"`
+	AllowedDomains string   `json:"allowedDomains"`
+	AllowedIPs     string   `json:"allowedIps"`
+	BindingIDs     []string `json:"bindingIds,omitempty"`
+}
+
+// adminPeerShareExport lists every share in the format accepted by
+// adminPeerShareImport.
+func (h *Handler) adminPeerShareExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("Invalid method"))
+		return
+	}
+	shares, err := h.repo.ListPeerShares()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	items := make([]peerShareTransferItem, 0, len(shares))
+	for _, share := range shares {
+		bindingIDs, err := h.repo.ListPeerShareBindingIDs(share.ID)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		items = append(items, peerShareTransferItem{
+			Name:           share.Name,
+			NodeID:         share.NodeID,
+			Token:          share.Token,
+			MaxBandwidth:   share.MaxBandwidth,
+			ExpiryTime:     share.ExpiryTime,
+			PortRangeStart: share.PortRangeStart,
+			PortRangeEnd:   share.PortRangeEnd,
+			IsActive:       share.IsActive,
+			AllowedDomains: share.AllowedDomains,
+			AllowedIPs:     share.AllowedIPs,
+			BindingIDs:     bindingIDs,
+		})
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+// adminPeerShareImport upserts exported shares by token. Nothing is written
+// unless every share is valid and its port range overlaps no other share on
+// the same node.
+func (h *Handler) adminPeerShareImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("Invalid method"))
+		return
+	}
+	var req []peerShareTransferItem
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("Invalid JSON"))
+		return
+	}
+	if len(req) == 0 {
+		response.WriteJSON(w, response.ErrDefault("No shares to import"))
+		return
+	}
+
+	existing, err := h.repo.ListPeerShares()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	// Ranges claimed so far, by node: the shares already here that are not
+	// being replaced, then each imported share as it is accepted.
+	importTokens := make(map[string]bool, len(req))
+	for _, item := range req {
+		importTokens[item.Token] = true
+	}
+	claimed := make(map[int64][]sqlite.PeerShare)
+	for _, share := range existing {
+		if !importTokens[share.Token] {
+			claimed[share.NodeID] = append(claimed[share.NodeID], share)
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	items := make([]sqlite.PeerShareImport, 0, len(req))
+	seen := make(map[string]bool, len(req))
+	for i, item := range req {
+		share, err := h.validatePeerShareImport(item)
+		if err != nil {
+			response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("Share %d: %s", i+1, err.Error())))
+			return
+		}
+		if seen[share.Token] {
+			response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("Share %d: duplicate token", i+1)))
+			return
+		}
+		seen[share.Token] = true
+		for _, other := range claimed[share.NodeID] {
+			if peerShareRangesOverlap(*share, other) {
+				response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("Share %d: port range %d-%d overlaps share %q", i+1, share.PortRangeStart, share.PortRangeEnd, other.Name)))
+				return
+			}
+		}
+		claimed[share.NodeID] = append(claimed[share.NodeID], *share)
+
+		share.CreatedTime = now
+		share.UpdatedTime = now
+		items = append(items, sqlite.PeerShareImport{Share: *share, BindingIDs: item.BindingIDs})
+	}
+
+	created, updated, err := h.repo.ImportPeerShares(items)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.writeAuditLog(r, "peer_share_import", "peer_share", 0, fmt.Sprintf("created %d, updated %d", created, updated))
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"created": created,
+		"updated": updated,
+	}))
+}
+
+// validatePeerShareImport applies the checks of federationShareCreate to an
+// imported share and returns it as a row to store.
+func (h *Handler) validatePeerShareImport(item peerShareTransferItem) (*sqlite.PeerShare, error) {
+	token := strings.TrimSpace(item.Token)
+	if token == "" {
+		return nil, fmt.Errorf("token is required")
+	}
+	if item.Name == "" || item.NodeID == 0 {
+		return nil, fmt.Errorf("name and nodeId are required")
+	}
+	if item.MaxBandwidth < 0 || item.ExpiryTime < 0 {
+		return nil, fmt.Errorf("max bandwidth and expiry time cannot be negative")
+	}
+	if item.PortRangeStart < 0 || item.PortRangeStart > 65535 || item.PortRangeEnd < 0 || item.PortRangeEnd > 65535 || item.PortRangeStart > item.PortRangeEnd {
+		return nil, fmt.Errorf("invalid port range")
+	}
+	if item.IsActive != 0 && item.IsActive != 1 {
+		return nil, fmt.Errorf("invalid active state")
+	}
+	allowedIPs, err := normalizePeerShareAllowedIPs(item.AllowedIPs)
+	if err != nil {
+		return nil, err
+	}
+	node, err := h.repo.GetNodeByID(item.NodeID)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, fmt.Errorf("node %d not found", item.NodeID)
+	}
+	if node.IsRemote == 1 {
+		return nil, fmt.Errorf("only local nodes can be shared")
+	}
+	return &sqlite.PeerShare{
+		Name:           item.Name,
+		NodeID:         item.NodeID,
+		Token:          token,
+		MaxBandwidth:   item.MaxBandwidth,
+		ExpiryTime:     item.ExpiryTime,
+		PortRangeStart: item.PortRangeStart,
+		PortRangeEnd:   item.PortRangeEnd,
+		IsActive:       item.IsActive,
+		AllowedDomains: item.AllowedDomains,
+		AllowedIPs:     allowedIPs,
+	}, nil
+}
+
+// peerShareRangesOverlap reports whether two shares claim a common port. A
+// share without a port range claims none.
+func peerShareRangesOverlap(a, b sqlite.PeerShare) bool {
+	if a.PortRangeEnd <= 0 || b.PortRangeEnd <= 0 {
+		return false
+	}
+	return a.PortRangeStart <= b.PortRangeEnd && b.PortRangeStart <= a.PortRangeEnd
+}
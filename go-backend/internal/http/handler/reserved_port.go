@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+type reservedPortCreateRequest struct {
+	NodeID    int64  `json:"nodeId"`
+	PortStart int    `json:"portStart"`
+	PortEnd   int    `json:"portEnd"`
+	Reason    string `json:"reason"`
+}
+
+type reservedPortListRequest struct {
+	NodeID int64 `json:"nodeId"`
+}
+
+func (h *Handler) adminReservedPortList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req reservedPortListRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	items, err := h.repo.ListReservedPorts(req.NodeID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+func (h *Handler) adminReservedPortCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req reservedPortCreateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.NodeID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("节点ID不能为空"))
+		return
+	}
+	if req.PortEnd <= 0 {
+		req.PortEnd = req.PortStart
+	}
+	if req.PortStart < 1 || req.PortEnd > 65535 || req.PortStart > req.PortEnd {
+		response.WriteJSON(w, response.ErrDefault("端口范围无效"))
+		return
+	}
+	if _, err := h.getNodeRecord(req.NodeID); err != nil {
+		response.WriteJSON(w, response.ErrDefault("节点不存在"))
+		return
+	}
+	id, err := h.repo.CreateReservedPort(req.NodeID, req.PortStart, req.PortEnd, strings.TrimSpace(req.Reason))
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{"id": id}))
+}
+
+func (h *Handler) adminReservedPortDelete(w http.ResponseWriter, r *http.Request) {
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	if err := h.repo.DeleteReservedPort(id); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// checkPortReservation reports an error naming the reservation when port is
+// reserved on any of nodeIDs.
+func (h *Handler) checkPortReservation(nodeIDs []int64, port int) error {
+	for _, nodeID := range nodeIDs {
+		reserved, err := h.repo.FindPortReservation(nodeID, port)
+		if err != nil {
+			return err
+		}
+		if reserved != nil {
+			return reservedPortError(port, reserved)
+		}
+	}
+	return nil
+}
+
+func reservedPortError(port int, reserved *sqlite.ReservedPort) error {
+	if reserved.Reason == "" {
+		return fmt.Errorf("端口 %d 已被保留", port)
+	}
+	return fmt.Errorf("端口 %d 已被保留: %s", port, reserved.Reason)
+}
+
+// markReservedPorts adds every reserved port of nodeID to used so automatic
+// allocation skips them.
+func markReservedPorts(db sqlite.Execer, nodeID int64, used map[int]struct{}) error {
+	ranges, err := sqlite.ListReservedPortsWith(db, nodeID)
+	if err != nil {
+		return err
+	}
+	for _, p := range ranges {
+		for port := p.PortStart; port <= p.PortEnd; port++ {
+			used[port] = struct{}{}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+	"go-backend/internal/store"
+)
+
+type roleRequest struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Actions     []string `json:"actions"`
+}
+
+type roleAssignRequest struct {
+	UserID int64 `json:"userId"`
+	RoleID int64 `json:"roleId"`
+}
+
+func (h *Handler) roleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	roles, err := h.repo.ListRoles()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(roles))
+}
+
+// roleActions lists the actions a role can be granted.
+func (h *Handler) roleActions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	response.WriteJSON(w, response.OK(auth.AllActions))
+}
+
+func (h *Handler) roleCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req roleRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	actions, msg := normalizeRoleRequest(&req)
+	if msg != "" {
+		response.WriteJSON(w, response.ErrDefault(msg))
+		return
+	}
+	id, err := h.repo.CreateRole(req.Name, req.Description, actions, time.Now().UnixMilli())
+	if store.IsConflict(err) {
+		response.WriteJSON(w, response.ErrDefault("角色名称已存在"))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.writeAuditLog(r, "role_create", "role", id, fmt.Sprintf("%s: %s", req.Name, strings.Join(actions, ",")))
+	response.WriteJSON(w, response.OK(map[string]interface{}{"id": id}))
+}
+
+func (h *Handler) roleUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req roleRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.ID == auth.RoleAdmin {
+		response.WriteJSON(w, response.ErrDefault("管理员角色不可修改"))
+		return
+	}
+	role, err := h.repo.GetRole(req.ID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if role == nil {
+		response.WriteJSON(w, response.ErrDefault("角色不存在"))
+		return
+	}
+	actions, msg := normalizeRoleRequest(&req)
+	if msg != "" {
+		response.WriteJSON(w, response.ErrDefault(msg))
+		return
+	}
+	if req.ID == auth.RoleUser {
+		// The default role keeps its name; only its actions can change.
+		req.Name = role.Name
+	}
+	err = h.repo.UpdateRole(req.ID, req.Name, req.Description, actions, time.Now().UnixMilli())
+	if store.IsConflict(err) {
+		response.WriteJSON(w, response.ErrDefault("角色名称已存在"))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.writeAuditLog(r, "role_update", "role", req.ID, fmt.Sprintf("%s: %s", req.Name, strings.Join(actions, ",")))
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func (h *Handler) roleDelete(w http.ResponseWriter, r *http.Request) {
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	if id == auth.RoleUser {
+		response.WriteJSON(w, response.ErrDefault("内置角色不可删除"))
+		return
+	}
+	role, err := h.repo.GetRole(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if role == nil {
+		response.WriteJSON(w, response.ErrDefault("角色不存在"))
+		return
+	}
+	if role.UserCount > 0 {
+		response.WriteJSON(w, response.ErrDefault("该角色下仍有用户，无法删除"))
+		return
+	}
+	if err := h.repo.DeleteRole(id); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.writeAuditLog(r, "role_delete", "role", id, role.Name)
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// roleAssign moves a non-admin user to another non-admin role. The user's
+// token carries the role, so their sessions are revoked and the new role
+// applies from their next login.
+func (h *Handler) roleAssign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req roleAssignRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.UserID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("用户ID不能为空"))
+		return
+	}
+	if req.RoleID == auth.RoleAdmin {
+		response.WriteJSON(w, response.ErrDefault("不能通过角色分配授予管理员权限"))
+		return
+	}
+	role, err := h.repo.GetRole(req.RoleID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if role == nil {
+		response.WriteJSON(w, response.ErrDefault("角色不存在"))
+		return
+	}
+	user, err := h.repo.GetUserByID(req.UserID)
+	if store.IsNotFound(err) {
+		response.WriteJSON(w, response.ErrDefault("用户不存在"))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if user.RoleID == auth.RoleAdmin {
+		response.WriteJSON(w, response.ErrDefault("管理员账号无需设置角色"))
+		return
+	}
+	if err := h.repo.SetUserRole(req.UserID, req.RoleID, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.revokeUserSessions(req.UserID)
+	h.writeAuditLog(r, "role_assign", "user", req.UserID, role.Name)
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// normalizeRoleRequest trims the request and returns its actions sorted and
+// deduplicated, or a user-facing message when it is invalid.
+func normalizeRoleRequest(req *roleRequest) ([]string, string) {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Description = strings.TrimSpace(req.Description)
+	if req.Name == "" {
+		return nil, "角色名称不能为空"
+	}
+	seen := make(map[string]bool, len(req.Actions))
+	actions := make([]string, 0, len(req.Actions))
+	for _, action := range req.Actions {
+		action = strings.TrimSpace(action)
+		if !auth.ValidAction(action) {
+			return nil, "未知的权限: " + action
+		}
+		if !seen[action] {
+			seen[action] = true
+			actions = append(actions, action)
+		}
+	}
+	sort.Strings(actions)
+	return actions, ""
+}
@@ -0,0 +1,45 @@
+package handler
+
+import "net/http"
+
+// RouteGroup registers routes under a shared path prefix, wrapping each one
+// in the group's middleware chain. The first middleware is the outermost.
+type RouteGroup struct {
+	mux        *http.ServeMux
+	prefix     string
+	middleware []func(http.Handler) http.Handler
+}
+
+func NewRouteGroup(mux *http.ServeMux, prefix string, middleware ...func(http.Handler) http.Handler) *RouteGroup {
+	return &RouteGroup{
+		mux:        mux,
+		prefix:     prefix,
+		middleware: append([]func(http.Handler) http.Handler(nil), middleware...),
+	}
+}
+
+// Group returns a child group that extends the prefix and runs its own
+// middleware after the parent's.
+func (g *RouteGroup) Group(prefix string, middleware ...func(http.Handler) http.Handler) *RouteGroup {
+	chain := make([]func(http.Handler) http.Handler, 0, len(g.middleware)+len(middleware))
+	chain = append(chain, g.middleware...)
+	chain = append(chain, middleware...)
+	return &RouteGroup{mux: g.mux, prefix: g.prefix + prefix, middleware: chain}
+}
+
+// Use appends middleware to the group. It applies to routes registered
+// afterwards, including those of child groups created afterwards.
+func (g *RouteGroup) Use(mw func(http.Handler) http.Handler) {
+	g.middleware = append(g.middleware, mw)
+}
+
+func (g *RouteGroup) Handle(pattern string, handler http.Handler) {
+	for i := len(g.middleware) - 1; i >= 0; i-- {
+		handler = g.middleware[i](handler)
+	}
+	g.mux.Handle(g.prefix+pattern, handler)
+}
+
+func (g *RouteGroup) HandleFunc(pattern string, fn http.HandlerFunc) {
+	g.Handle(pattern, fn)
+}
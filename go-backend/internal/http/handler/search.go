@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"go-backend/internal/http/response"
+)
+
+type adminSearchRequest struct {
+	Query    string   `json:"query"`
+	Types    []string `json:"types"`
+	Page     int      `json:"page"`
+	PageSize int      `json:"pageSize"`
+}
+
+func (h *Handler) adminSearchAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req adminSearchRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	query := strings.TrimSpace(req.Query)
+	if query == "" {
+		response.WriteJSON(w, response.ErrDefault("搜索关键字不能为空"))
+		return
+	}
+
+	types := map[string]bool{}
+	for _, t := range req.Types {
+		types[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+	if len(types) == 0 {
+		types["forward"] = true
+		types["tunnel"] = true
+	}
+
+	result := map[string]interface{}{
+		"query": query,
+		"page":  req.Page,
+		"total": 0,
+	}
+	total := 0
+	if types["forward"] {
+		forwards, count, err := h.repo.FTSSearchForwards(query, req.Page, req.PageSize)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		result["forwards"] = forwards
+		result["forwardTotal"] = count
+		total += count
+	}
+	if types["tunnel"] {
+		tunnels, count, err := h.repo.FTSSearchTunnels(query, req.Page, req.PageSize)
+		if err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+		result["tunnels"] = tunnels
+		result["tunnelTotal"] = count
+		total += count
+	}
+	result["total"] = total
+
+	response.WriteJSON(w, response.OK(result))
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"go-backend/internal/mail"
+	"go-backend/internal/network"
+)
+
+const (
+	// webhookAllowPrivateConfigKey lets security webhooks reach loopback and
+	// private addresses. Off by default, since any user can set a webhook and
+	// the panel would otherwise call into its own network.
+	webhookAllowPrivateConfigKey = "webhook_allow_private"
+
+	securityWebhookTimeout = 10 * time.Second
+	securityWebhookMaxURL  = 500
+
+	// loginFailedNoticeInterval is the minimum time between two failed-login
+	// mails or webhooks to the same user, so password guessing cannot be
+	// turned into a mail flood.
+	loginFailedNoticeInterval = 10 * time.Minute
+)
+
+var errWebhookAddressBlocked = errors.New("webhook address is not public")
+
+// securityEventSubjects are the mail subjects of the security events.
+var securityEventSubjects = map[string]string{
+	eventSecurityLogin:           "账号登录提醒",
+	eventSecurityLoginFailed:     "账号登录失败提醒",
+	eventSecurityPasswordChanged: "账号密码已修改",
+	eventSecurityAPIKeyCreated:   "新的API密钥已创建",
+}
+
+// notifySecurityEvent tells userID about a security-relevant change to
+// their account. The event always goes out on the event stream; mail and
+// the webhook are used when the user opted in. Delivery never blocks the
+// request.
+func (h *Handler) notifySecurityEvent(r *http.Request, userID int64, username, eventType, detail string) {
+	ip := ""
+	if addr := network.ClientIP(r); addr != nil {
+		ip = addr.String()
+	}
+	now := time.Now()
+	data := map[string]interface{}{
+		"userId":    userID,
+		"username":  username,
+		"ip":        ip,
+		"userAgent": r.UserAgent(),
+		"time":      now.UnixMilli(),
+		"detail":    detail,
+	}
+	h.events.publish(panelEvent{Type: eventType, UserID: userID, Data: data})
+
+	if eventType == eventSecurityLoginFailed && !h.loginFailNotices.allow(userID, now) {
+		return
+	}
+	pref, err := h.repo.GetUserNotificationPref(userID)
+	if err != nil {
+		return
+	}
+	if pref.SecurityEmailEnabled {
+		body := fmt.Sprintf("账号：%s\n时间：%s\nIP：%s\n客户端：%s\n", username, now.Format("2006-01-02 15:04:05 MST"), ip, r.UserAgent())
+		if detail != "" {
+			body += "详情：" + detail + "\n"
+		}
+		body += "\n如果这不是您本人的操作，请立即修改密码。\n"
+		// The SMTP credentials may come from a secrets backend, so they are
+		// resolved with the mail rather than while the request waits.
+		go func() {
+			cfg := h.smtpConfig()
+			if cfg == nil {
+				return
+			}
+			email, err := h.repo.GetUserEmail(userID)
+			if err != nil || email == "" {
+				return
+			}
+			msg := mail.Message{To: email, Subject: securityEventSubjects[eventType], Body: body}
+			ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+			defer cancel()
+			if err := mail.Send(ctx, *cfg, msg); err != nil {
+				log.Printf("send %s mail to user %d: %v", eventType, userID, err)
+			}
+		}()
+	}
+	if pref.SecurityWebhookURL != "" {
+		payload, err := json.Marshal(map[string]interface{}{"type": eventType, "data": data})
+		if err != nil {
+			return
+		}
+		client := securityWebhookClient(h.configValue(webhookAllowPrivateConfigKey) == "true")
+		go func(target string) {
+			if err := postSecurityWebhook(client, target, payload); err != nil {
+				log.Printf("deliver %s webhook for user %d: %v", eventType, userID, err)
+			}
+		}(pref.SecurityWebhookURL)
+	}
+}
+
+func postSecurityWebhook(client *http.Client, target string, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "flvx-panel")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// securityWebhookClient checks every address it connects to, after DNS
+// resolution and on redirects, unless private targets are allowed.
+func securityWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: securityWebhookTimeout}
+	if !allowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+				ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+				return errWebhookAddressBlocked
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   securityWebhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+}
+
+// validSecurityWebhookURL accepts an absolute http(s) URL.
+func validSecurityWebhookURL(raw string) bool {
+	if len(raw) > securityWebhookMaxURL {
+		return false
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
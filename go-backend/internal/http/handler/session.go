@@ -0,0 +1,383 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/middleware"
+	"go-backend/internal/http/response"
+	"go-backend/internal/network"
+	"go-backend/internal/store"
+	"go-backend/internal/store/sqlite"
+)
+
+// authCookieConfigKey turns on cookie auth mode: login and refresh also set
+// the tokens as HttpOnly cookies, which the JWT middleware accepts in place
+// of the Authorization header.
+const authCookieConfigKey = "auth_cookie_enabled"
+
+// sessionUserAgentMax is the width of refresh_token.user_agent.
+const sessionUserAgentMax = 512
+
+// The refresh cookie is only ever sent to the refresh endpoint.
+const refreshCookiePath = "/api/v1/user/refresh"
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// sessionTokens is what login and refresh hand back to the client.
+type sessionTokens struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// startSession opens a refresh session for user, remembering the client r
+// came from, and issues its first access token.
+func (h *Handler) startSession(r *http.Request, user *sqlite.User) (sessionTokens, error) {
+	refreshToken := randomToken(32)
+	now := time.Now()
+	sessionID, err := h.repo.CreateRefreshToken(user.ID, hashRefreshToken(refreshToken), now.Add(auth.RefreshTokenTTL).UnixMilli(), now.UnixMilli(), sessionClient(r))
+	if err != nil {
+		return sessionTokens{}, err
+	}
+	accessToken, err := h.issueAccessToken(user, sessionID)
+	if err != nil {
+		return sessionTokens{}, err
+	}
+	return sessionTokens{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+func (h *Handler) issueAccessToken(user *sqlite.User, sessionID int64) (string, error) {
+	audience, _ := middleware.LoadJWTAudience(h.repo)
+	return auth.GenerateAccessTokenWithKey(user.ID, user.User, user.RoleID, user.PermissionMask, audience, sessionID, h.keys.Current())
+}
+
+// setSessionCookies delivers tokens as cookies when cookie auth mode is on.
+// The CSRF cookie is readable by the frontend, which echoes it back in
+// middleware.CSRFHeader.
+func (h *Handler) setSessionCookies(w http.ResponseWriter, r *http.Request, tokens sessionTokens) {
+	if h.configValue(authCookieConfigKey) != "true" {
+		return
+	}
+	secure := requestIsHTTPS(r)
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.AuthCookieName,
+		Value:    tokens.AccessToken,
+		Path:     "/",
+		MaxAge:   int(auth.AccessTokenTTL / time.Second),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.RefreshCookieName,
+		Value:    tokens.RefreshToken,
+		Path:     refreshCookiePath,
+		MaxAge:   int(auth.RefreshTokenTTL / time.Second),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.CSRFCookieName,
+		Value:    randomToken(16),
+		Path:     "/",
+		MaxAge:   int(auth.RefreshTokenTTL / time.Second),
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSessionCookies is sent on logout whether or not cookie mode is on,
+// so turning it off does not leave stale cookies behind.
+func clearSessionCookies(w http.ResponseWriter) {
+	for name, path := range map[string]string{
+		middleware.AuthCookieName:    "/",
+		middleware.RefreshCookieName: refreshCookiePath,
+		middleware.CSRFCookieName:    "/",
+	} {
+		http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: path, MaxAge: -1})
+	}
+}
+
+func requestIsHTTPS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// userRefresh exchanges a refresh token for a new access token and a new
+// refresh token. The old refresh token stops working, and the session's
+// expiry moves forward, so an active client is never logged out.
+func (h *Handler) userRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req refreshRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	presented := strings.TrimSpace(req.RefreshToken)
+	if cookie, err := r.Cookie(middleware.RefreshCookieName); presented == "" && err == nil {
+		presented = cookie.Value
+	}
+	if presented == "" {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+
+	now := time.Now()
+	session, err := h.repo.GetRefreshTokenByHash(hashRefreshToken(presented))
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if session == nil || session.RevokedTime > 0 || session.ExpiresAt <= now.UnixMilli() {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+
+	user, err := h.repo.GetUserByID(session.UserID)
+	if store.IsNotFound(err) {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if user.Status == 0 {
+		if err := h.repo.RevokeRefreshToken(session.ID, user.ID, now.UnixMilli()); err != nil {
+			log.Printf("revoke session %d of disabled user %d: %v", session.ID, user.ID, err)
+		}
+		response.WriteJSON(w, response.ErrDefault("账号被停用"))
+		return
+	}
+
+	refreshToken := randomToken(32)
+	rotated, err := h.repo.RotateRefreshToken(session.ID, session.TokenHash, hashRefreshToken(refreshToken), now.Add(auth.RefreshTokenTTL).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if !rotated {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	accessToken, err := h.issueAccessToken(user, session.ID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.setSessionCookies(w, r, sessionTokens{AccessToken: accessToken, RefreshToken: refreshToken})
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"token":        accessToken,
+		"refreshToken": refreshToken,
+		"expiresIn":    int64(auth.AccessTokenTTL / time.Second),
+	}))
+}
+
+// userLogout revokes the session the request's token belongs to. Tokens
+// issued before sessions existed have nothing to revoke.
+func (h *Handler) userLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	claims, ok := r.Context().Value(middleware.ClaimsContextKey).(auth.Claims)
+	if !ok {
+		response.WriteJSON(w, response.Err(401, "无法获取用户权限信息"))
+		return
+	}
+	userID, err := parseUserID(claims.Sub)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无法获取用户权限信息"))
+		return
+	}
+	if claims.Sid > 0 {
+		if err := h.repo.RevokeRefreshToken(claims.Sid, userID, time.Now().UnixMilli()); err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+	}
+	clearSessionCookies(w)
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// userLogoutAll revokes every session of the caller, including the one the
+// request came in on.
+func (h *Handler) userLogoutAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无法获取用户权限信息"))
+		return
+	}
+	if err := h.repo.RevokeUserRefreshTokens(userID, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	clearSessionCookies(w)
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// userSessionList returns the caller's open sessions. The one the request
+// came in on is marked current.
+func (h *Handler) userSessionList(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.ClaimsContextKey).(auth.Claims)
+	if !ok {
+		response.WriteJSON(w, response.Err(401, "无法获取用户权限信息"))
+		return
+	}
+	userID, err := parseUserID(claims.Sub)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无法获取用户权限信息"))
+		return
+	}
+	sessions, err := h.repo.ListUserSessions(userID, time.Now().UnixMilli())
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	items := make([]map[string]interface{}, 0, len(sessions))
+	for _, s := range sessions {
+		items = append(items, map[string]interface{}{
+			"id":           s.ID,
+			"device":       s.Device,
+			"ip":           s.IP,
+			"userAgent":    s.UserAgent,
+			"createdTime":  s.CreatedTime,
+			"lastSeenTime": s.LastSeenTime,
+			"expiresAt":    s.ExpiresAt,
+			"current":      s.ID == claims.Sid,
+		})
+	}
+	response.WriteJSON(w, response.OK(items))
+}
+
+// userSessionRevoke ends one of the caller's sessions. Access tokens issued
+// under it stop working on their next request.
+func (h *Handler) userSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	claims, ok := r.Context().Value(middleware.ClaimsContextKey).(auth.Claims)
+	if !ok {
+		response.WriteJSON(w, response.Err(401, "无法获取用户权限信息"))
+		return
+	}
+	userID, err := parseUserID(claims.Sub)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无法获取用户权限信息"))
+		return
+	}
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	now := time.Now().UnixMilli()
+	sessions, err := h.repo.ListUserSessions(userID, now)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	found := false
+	for _, s := range sessions {
+		found = found || s.ID == id
+	}
+	if !found {
+		response.WriteJSON(w, response.ErrDefault("会话不存在"))
+		return
+	}
+	if err := h.repo.RevokeRefreshToken(id, userID, now); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.writeAuditLog(r, "session_revoke", "session", id, "")
+	if id == claims.Sid {
+		clearSessionCookies(w)
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// revokeUserSessions logs userID out everywhere, e.g. after a password
+// change or a ban. Failure is logged; the change itself already happened.
+func (h *Handler) revokeUserSessions(userID int64) {
+	if err := h.repo.RevokeUserRefreshTokens(userID, time.Now().UnixMilli()); err != nil {
+		log.Printf("revoke sessions of user %d: %v", userID, err)
+	}
+}
+
+// sessionClient describes the client of r for the session list.
+func sessionClient(r *http.Request) sqlite.SessionClient {
+	userAgent := []rune(r.UserAgent())
+	if len(userAgent) > sessionUserAgentMax {
+		userAgent = userAgent[:sessionUserAgentMax]
+	}
+	client := sqlite.SessionClient{UserAgent: string(userAgent)}
+	if ip := network.ClientIP(r); ip != nil {
+		client.IP = ip.String()
+	}
+	client.Device = describeDevice(client.UserAgent)
+	return client
+}
+
+// describeDevice turns a user agent into a short label such as
+// "Chrome / Windows". It only needs to be good enough for a user to
+// recognise their own devices.
+func describeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return ""
+	}
+	browser := "未知浏览器"
+	for _, b := range []struct{ token, name string }{
+		{"edg/", "Edge"},
+		{"opr/", "Opera"},
+		{"firefox/", "Firefox"},
+		{"chrome/", "Chrome"},
+		{"safari/", "Safari"},
+		{"curl/", "curl"},
+		{"okhttp", "OkHttp"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	system := ""
+	for _, o := range []struct{ token, name string }{
+		{"android", "Android"},
+		{"iphone", "iOS"},
+		{"ipad", "iPadOS"},
+		{"windows", "Windows"},
+		{"mac os x", "macOS"},
+		{"linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			system = o.name
+			break
+		}
+	}
+	if system == "" {
+		return browser
+	}
+	return browser + " / " + system
+}
+
+// Refresh tokens are stored hashed so a leaked database cannot be replayed
+// against /user/refresh.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
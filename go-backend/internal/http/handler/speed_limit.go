@@ -0,0 +1,281 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+type speedLimitRequest struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Speed    *int   `json:"speed"`
+	TunnelID int64  `json:"tunnelId"`
+	Status   *int   `json:"status"`
+	// TunnelName is sent back by the panel form; the stored name is always
+	// read from the tunnel.
+	TunnelName string `json:"tunnelName"`
+}
+
+// validate checks the rule fields and fills in the defaults, returning the
+// name of the rule's tunnel.
+func (h *Handler) validateSpeedLimitRequest(req *speedLimitRequest) (string, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return "", errors.New("名称不能为空")
+	}
+	if req.Speed == nil {
+		speed := 100
+		req.Speed = &speed
+	}
+	if *req.Speed <= 0 {
+		return "", errors.New("限速必须大于0")
+	}
+	if req.Status == nil {
+		status := 1
+		req.Status = &status
+	}
+	if *req.Status != 0 && *req.Status != 1 {
+		return "", errors.New("请求参数错误")
+	}
+	if req.TunnelID <= 0 {
+		return "", errors.New("隧道ID不能为空")
+	}
+	var tunnelName string
+	err := h.repo.DB().QueryRow(`SELECT name FROM tunnel WHERE id = ?`, req.TunnelID).Scan(&tunnelName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errors.New("隧道不存在")
+	}
+	return tunnelName, err
+}
+
+func (h *Handler) speedLimitCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req speedLimitRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	tunnelName, err := h.validateSpeedLimitRequest(&req)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	now := time.Now().UnixMilli()
+	id, err := h.repo.DB().ExecReturningID(`INSERT INTO speed_limit(name, speed, tunnel_id, tunnel_name, created_time, updated_time, status) VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		req.Name, *req.Speed, req.TunnelID, tunnelName, now, now, *req.Status)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if *req.Status == 1 {
+		h.pushSpeedLimit(id, *req.Speed, req.TunnelID)
+	}
+	h.writeAuditLog(r, "speed_limit_create", "speed_limit", id, req.Name)
+	response.WriteJSON(w, response.OK(map[string]interface{}{"id": id}))
+}
+
+// speedLimitUpdate saves a rule and pushes the new rate to the nodes
+// already enforcing it, so running forwards slow down or speed up without
+// being redeployed. Disabling a rule lifts it like a delete; enabling it
+// again puts it back on the assigned forwards.
+func (h *Handler) speedLimitUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req speedLimitRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.ID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	tunnelName, err := h.validateSpeedLimitRequest(&req)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	var oldTunnelID int64
+	var oldStatus int
+	err = h.repo.DB().QueryRow(`SELECT tunnel_id, status FROM speed_limit WHERE id = ?`, req.ID).Scan(&oldTunnelID, &oldStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		response.WriteJSON(w, response.ErrDefault("限速规则不存在"))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	// Collected before the update, while the old tunnel still counts.
+	nodeIDs, err := h.speedLimitNodeIDs(req.ID, oldTunnelID, req.TunnelID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	_, err = h.repo.DB().Exec(`UPDATE speed_limit SET name=?, speed=?, tunnel_id=?, tunnel_name=?, status=?, updated_time=? WHERE id=?`,
+		req.Name, *req.Speed, req.TunnelID, tunnelName, *req.Status, time.Now().UnixMilli(), req.ID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	switch {
+	case *req.Status == 1:
+		h.pushSpeedLimit(req.ID, *req.Speed, oldTunnelID, req.TunnelID)
+		if oldStatus != 1 {
+			h.resyncSpeedLimitForwards(req.ID)
+		}
+	case oldStatus == 1:
+		h.resyncSpeedLimitForwards(req.ID)
+		h.removeSpeedLimit(req.ID, nodeIDs)
+	}
+	h.writeAuditLog(r, "speed_limit_update", "speed_limit", req.ID, req.Name)
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// speedLimitDelete deletes a rule and detaches it from the users it was
+// assigned to. Their forwards are re-sent without the limiter before the
+// limiter itself is removed from the nodes.
+func (h *Handler) speedLimitDelete(w http.ResponseWriter, r *http.Request) {
+	id := idFromBody(r, w)
+	if id <= 0 {
+		return
+	}
+	var name string
+	var tunnelID int64
+	err := h.repo.DB().QueryRow(`SELECT name, tunnel_id FROM speed_limit WHERE id = ?`, id).Scan(&name, &tunnelID)
+	if errors.Is(err, sql.ErrNoRows) {
+		response.WriteJSON(w, response.ErrDefault("限速规则不存在"))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	nodeIDs, err := h.speedLimitNodeIDs(id, tunnelID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	assignments, err := h.speedLimitAssignments(id)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+
+	if err := h.repo.DeleteSpeedLimit(id); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	for _, a := range assignments {
+		h.syncUserTunnelForwards(a[0], a[1])
+	}
+	h.removeSpeedLimit(id, nodeIDs)
+	h.writeAuditLog(r, "speed_limit_delete", "speed_limit", id, name)
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+// pushSpeedLimit sends a rule's limiter to every node that enforces it,
+// replacing the rate where the limiter already exists. Offline nodes are
+// skipped; they receive the limiter when their forwards are re-sent on
+// reconnect.
+func (h *Handler) pushSpeedLimit(id int64, speedMbps int, tunnelIDs ...int64) {
+	nodeIDs, err := h.speedLimitNodeIDs(id, tunnelIDs...)
+	if err != nil {
+		log.Printf("speed limit %d: list nodes failed: %v", id, err)
+		return
+	}
+	payload := limiterConfig(id, speedMbps)
+	update := map[string]interface{}{"limiter": payload["name"], "data": payload}
+	for _, nodeID := range nodeIDs {
+		if _, err := h.sendNodeCommand(nodeID, "UpdateLimiters", update, false, false); err == nil {
+			continue
+		}
+		if _, err := h.sendNodeCommand(nodeID, "AddLimiters", payload, true, false); err != nil {
+			log.Printf("speed limit %d: push to node %d failed: %v", id, nodeID, err)
+		}
+	}
+}
+
+// resyncSpeedLimitForwards re-sends the forwards of every assignment of
+// the rule, picking the limiter up or dropping it with the rule's status.
+func (h *Handler) resyncSpeedLimitForwards(id int64) {
+	assignments, err := h.speedLimitAssignments(id)
+	if err != nil {
+		log.Printf("speed limit %d: list assignments failed: %v", id, err)
+		return
+	}
+	for _, a := range assignments {
+		h.syncUserTunnelForwards(a[0], a[1])
+	}
+}
+
+// removeSpeedLimit deletes the rule's limiter from the given nodes once no
+// forward refers to it any more.
+func (h *Handler) removeSpeedLimit(id int64, nodeIDs []int64) {
+	payload := map[string]interface{}{"limiter": limiterConfig(id, 0)["name"]}
+	for _, nodeID := range nodeIDs {
+		_, _ = h.sendNodeCommand(nodeID, "DeleteLimiters", payload, false, true)
+	}
+}
+
+// speedLimitNodeIDs returns the entry nodes of the given tunnels and of
+// every tunnel assignment using the rule.
+func (h *Handler) speedLimitNodeIDs(id int64, tunnelIDs ...int64) ([]int64, error) {
+	args := []interface{}{id}
+	placeholders := make([]string, 0, len(tunnelIDs))
+	for _, tunnelID := range tunnelIDs {
+		placeholders = append(placeholders, "?")
+		args = append(args, tunnelID)
+	}
+	query := `
+		SELECT DISTINCT node_id FROM chain_tunnel
+		WHERE chain_type IN ('0', '1')
+		AND (tunnel_id IN (SELECT tunnel_id FROM user_tunnel WHERE speed_id = ?)`
+	if len(placeholders) > 0 {
+		query += ` OR tunnel_id IN (` + strings.Join(placeholders, ", ") + `)`
+	}
+	rows, err := h.repo.DB().Query(query+`) ORDER BY node_id ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	nodeIDs := make([]int64, 0)
+	for rows.Next() {
+		var nodeID int64
+		if err := rows.Scan(&nodeID); err != nil {
+			return nil, err
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	return nodeIDs, rows.Err()
+}
+
+// speedLimitAssignments returns the (user, tunnel) pairs the rule is
+// assigned to.
+func (h *Handler) speedLimitAssignments(id int64) ([][2]int64, error) {
+	rows, err := h.repo.DB().Query(`SELECT user_id, tunnel_id FROM user_tunnel WHERE speed_id = ? ORDER BY id ASC`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([][2]int64, 0)
+	for rows.Next() {
+		var a [2]int64
+		if err := rows.Scan(&a[0], &a[1]); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+	"go-backend/internal/security/totp"
+	"go-backend/internal/store"
+)
+
+const (
+	recoveryCodeCount = 10
+	defaultTOTPIssuer = "FLVX"
+)
+
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type totpDisableRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// TOTP secrets are stored encrypted with the panel's JWT secret.
+func (h *Handler) decryptTOTPSecret(encrypted string) (string, error) {
+	crypto, err := security.NewAESCrypto(h.jwtSecret)
+	if err != nil {
+		return "", err
+	}
+	plain, err := crypto.Decrypt(encrypted)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func (h *Handler) encryptTOTPSecret(secret string) (string, error) {
+	crypto, err := security.NewAESCrypto(h.jwtSecret)
+	if err != nil {
+		return "", err
+	}
+	return crypto.Encrypt([]byte(secret))
+}
+
+func (h *Handler) verifyTOTPCode(encryptedSecret, code string) bool {
+	if code == "" || encryptedSecret == "" {
+		return false
+	}
+	secret, err := h.decryptTOTPSecret(encryptedSecret)
+	if err != nil {
+		return false
+	}
+	return totp.Validate(code, secret)
+}
+
+// verifySecondFactor accepts either the current TOTP code or one of the
+// user's unused recovery codes, which is consumed.
+func (h *Handler) verifySecondFactor(userID int64, encryptedSecret, code string) bool {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		return h.verifyTOTPCode(encryptedSecret, code)
+	}
+	if code == "" {
+		return false
+	}
+	used, err := h.repo.UseRecoveryCode(userID, hashRecoveryCode(code), time.Now().UnixMilli())
+	if err != nil {
+		log.Printf("use recovery code for user %d: %v", userID, err)
+		return false
+	}
+	return used
+}
+
+// userTOTPStatus reports whether the caller has TOTP enabled and how many
+// recovery codes are left.
+func (h *Handler) userTOTPStatus(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	enabled, _, err := h.repo.GetUserTOTP(userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	remaining := 0
+	if enabled {
+		if remaining, err = h.repo.CountUnusedRecoveryCodes(userID); err != nil {
+			response.WriteJSON(w, response.Err(-2, err.Error()))
+			return
+		}
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"enabled":           enabled,
+		"recoveryCodesLeft": remaining,
+	}))
+}
+
+// userTOTPSetup generates a new secret for the caller. It is stored but not
+// enforced until userTOTPConfirm sees a valid code for it.
+func (h *Handler) userTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	user, err := h.repo.GetUserByID(userID)
+	if store.IsNotFound(err) {
+		response.WriteJSON(w, response.ErrDefault("用户不存在"))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	enabled, _, err := h.repo.GetUserTOTP(userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if enabled {
+		response.WriteJSON(w, response.ErrDefault("TOTP已启用，请先关闭"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	encrypted, err := h.encryptTOTPSecret(secret)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err := h.repo.SetPendingUserTOTP(userID, encrypted, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"secret": secret,
+		"uri":    totp.ProvisioningURI(h.totpIssuer(), user.User, secret),
+	}))
+}
+
+// userTOTPConfirm enables TOTP once the caller proves their authenticator
+// works, and returns recovery codes. The codes are only ever shown here.
+func (h *Handler) userTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	var req totpCodeRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	enabled, encrypted, err := h.repo.GetUserTOTP(userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if enabled {
+		response.WriteJSON(w, response.ErrDefault("TOTP已启用"))
+		return
+	}
+	if encrypted == "" {
+		response.WriteJSON(w, response.ErrDefault("请先生成TOTP密钥"))
+		return
+	}
+	if !h.verifyTOTPCode(encrypted, strings.TrimSpace(req.Code)) {
+		response.WriteJSON(w, response.Err(403, "TOTP验证失败"))
+		return
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := randomToken(5)
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	if err := h.repo.EnableUserTOTP(userID, hashes, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"recoveryCodes": codes,
+	}))
+}
+
+// userTOTPDisable turns TOTP off. It asks for the password and a second
+// factor, so a stolen session alone cannot remove it.
+func (h *Handler) userTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	userID, err := userIDFromRequest(r)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	var req totpDisableRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	user, err := h.repo.GetUserByID(userID)
+	if store.IsNotFound(err) {
+		response.WriteJSON(w, response.ErrDefault("用户不存在"))
+		return
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if ok, _ := security.VerifyPassword(user.Pwd, req.Password); !ok {
+		response.WriteJSON(w, response.ErrDefault("当前密码错误"))
+		return
+	}
+	enabled, encrypted, err := h.repo.GetUserTOTP(userID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if enabled && !h.verifySecondFactor(userID, encrypted, req.Code) {
+		response.WriteJSON(w, response.Err(403, "TOTP验证失败"))
+		return
+	}
+	if err := h.repo.DisableUserTOTP(userID, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+func (h *Handler) totpIssuer() string {
+	if cfg, err := h.repo.GetConfigByName("app_name"); err == nil && cfg != nil && strings.TrimSpace(cfg.Value) != "" {
+		return strings.TrimSpace(cfg.Value)
+	}
+	return defaultTOTPIssuer
+}
+
+// Recovery codes are shown as xxxxx-xxxxx but hashed without the dash, so
+// users may type them either way.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
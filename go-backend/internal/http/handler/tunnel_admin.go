@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+type tunnelUserAssignmentsRequest struct {
+	TunnelID int64 `json:"tunnelId"`
+	Page     int   `json:"page"`
+	PageSize int   `json:"pageSize"`
+}
+
+type tunnelHopStatsRequest struct {
+	TunnelID int64 `json:"tunnelId"`
+}
+
+type tunnelBulkExtendRequest struct {
+	TunnelID         int64 `json:"tunnelId"`
+	ExtendDays       int   `json:"extendDays"`
+	OnlyExpiringSoon bool  `json:"onlyExpiringSoon"`
+	WithinDays       int   `json:"withinDays"`
+}
+
+func (h *Handler) adminTunnelUserAssignments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req tunnelUserAssignmentsRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.TunnelID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("隧道ID不能为空"))
+		return
+	}
+
+	items, total, err := h.repo.ListUserTunnelsByTunnel(req.TunnelID, req.Page, req.PageSize)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"tunnelId": req.TunnelID,
+		"page":     req.Page,
+		"total":    total,
+		"list":     items,
+	}))
+}
+
+// adminTunnelBulkExtend extends the expiry of every user permission on a
+// tunnel, or only of those expiring within withinDays when onlyExpiringSoon
+// is set.
+func (h *Handler) adminTunnelBulkExtend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req tunnelBulkExtendRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.TunnelID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("隧道ID不能为空"))
+		return
+	}
+	if req.ExtendDays <= 0 {
+		response.WriteJSON(w, response.ErrDefault("延长天数必须大于0"))
+		return
+	}
+	var beforeMs int64
+	if req.OnlyExpiringSoon {
+		if req.WithinDays <= 0 {
+			response.WriteJSON(w, response.ErrDefault("到期天数必须大于0"))
+			return
+		}
+		beforeMs = time.Now().UnixMilli() + int64(req.WithinDays)*millisPerDay
+	}
+
+	updated, err := h.repo.BulkExtendUserTunnelExpiry(req.TunnelID, int64(req.ExtendDays)*millisPerDay, beforeMs)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	detail := fmt.Sprintf("extendDays=%d updated=%d", req.ExtendDays, updated)
+	if req.OnlyExpiringSoon {
+		detail += fmt.Sprintf(" withinDays=%d", req.WithinDays)
+	}
+	h.writeAuditLog(r, "tunnel_bulk_extend", "tunnel", req.TunnelID, detail)
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"tunnelId": req.TunnelID,
+		"updated":  updated,
+	}))
+}
+
+// adminTunnelHopStats returns the latest per-hop latency and byte counters
+// reported by the nodes of a tunnel chain, entry hop first.
+func (h *Handler) adminTunnelHopStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req tunnelHopStatsRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.TunnelID <= 0 {
+		response.WriteJSON(w, response.ErrDefault("隧道ID不能为空"))
+		return
+	}
+
+	hops, err := h.repo.ListChainHopStats(req.TunnelID)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	response.WriteJSON(w, response.OK(map[string]interface{}{
+		"tunnelId": req.TunnelID,
+		"hops":     hops,
+	}))
+}
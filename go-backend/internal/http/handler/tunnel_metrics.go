@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+const (
+	tunnelMetricsDecayInterval = 5 * time.Second
+	tunnelMetricsWindow        = time.Minute
+)
+
+// TunnelMetric is the live load of a tunnel as seen by the panel.
+type TunnelMetric struct {
+	TunnelID    int64   `json:"tunnelId"`
+	Connections int64   `json:"connections"`
+	BytesSec    float64 `json:"bytesSec"`
+}
+
+type tunnelMetricEntry struct {
+	connections int64
+	pending     int64
+	bytesSec    float64
+}
+
+// tunnelMetrics keeps per-tunnel connection counts and a rolling one-minute
+// bandwidth average in memory so they can be read without touching the DB.
+// A single mutex guards the map and its entries, so decay cannot drop an
+// entry while a report is still adding to it.
+type tunnelMetrics struct {
+	mu      sync.Mutex
+	entries map[int64]*tunnelMetricEntry
+}
+
+func newTunnelMetrics() *tunnelMetrics {
+	return &tunnelMetrics{entries: make(map[int64]*tunnelMetricEntry)}
+}
+
+// entry returns the tunnel's entry, creating it. The caller holds m.mu.
+func (m *tunnelMetrics) entry(tunnelID int64) *tunnelMetricEntry {
+	e, ok := m.entries[tunnelID]
+	if !ok {
+		e = &tunnelMetricEntry{}
+		m.entries[tunnelID] = e
+	}
+	return e
+}
+
+func (m *tunnelMetrics) connectionsOpened(tunnelID int64, n int64) {
+	if m == nil || tunnelID <= 0 || n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entry(tunnelID).connections += n
+}
+
+func (m *tunnelMetrics) connectionsClosed(tunnelID int64, n int64) {
+	if m == nil || tunnelID <= 0 || n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(tunnelID)
+	e.connections -= n
+	if e.connections < 0 {
+		e.connections = 0
+	}
+}
+
+func (m *tunnelMetrics) addBytes(tunnelID int64, n int64) {
+	if m == nil || tunnelID <= 0 || n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entry(tunnelID).pending += n
+}
+
+// decay folds the bytes seen during the last interval into each tunnel's
+// exponential moving average and drops tunnels that have gone idle.
+func (m *tunnelMetrics) decay(interval time.Duration) {
+	if m == nil || interval <= 0 {
+		return
+	}
+	alpha := 1 - math.Exp(-interval.Seconds()/tunnelMetricsWindow.Seconds())
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for tunnelID, e := range m.entries {
+		rate := float64(e.pending) / interval.Seconds()
+		e.pending = 0
+		e.bytesSec += alpha * (rate - e.bytesSec)
+		if e.bytesSec < 0.01 {
+			e.bytesSec = 0
+		}
+		if e.bytesSec == 0 && e.connections == 0 {
+			delete(m.entries, tunnelID)
+		}
+	}
+}
+
+func (m *tunnelMetrics) snapshot() []TunnelMetric {
+	out := make([]TunnelMetric, 0)
+	if m == nil {
+		return out
+	}
+	m.mu.Lock()
+	for tunnelID, e := range m.entries {
+		out = append(out, TunnelMetric{
+			TunnelID:    tunnelID,
+			Connections: e.connections,
+			BytesSec:    e.bytesSec,
+		})
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].TunnelID < out[j].TunnelID })
+	return out
+}
+
+func (h *Handler) adminTunnelMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	response.WriteJSON(w, response.OK(h.tunnelMetrics.snapshot()))
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/sqlite"
+)
+
+func fetchTunnelMetrics(t *testing.T, h *Handler) map[int64]TunnelMetric {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.adminTunnelMetrics(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/tunnel/metrics", nil))
+	var out struct {
+		Code int            `json:"code"`
+		Data []TunnelMetric `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode metrics: %v", err)
+	}
+	if out.Code != 0 {
+		t.Fatalf("expected code 0, got %d", out.Code)
+	}
+	byTunnel := make(map[int64]TunnelMetric, len(out.Data))
+	for _, m := range out.Data {
+		byTunnel[m.TunnelID] = m
+	}
+	return byTunnel
+}
+
+func TestAdminTunnelMetricsCountsConnections(t *testing.T) {
+	h := &Handler{tunnelMetrics: newTunnelMetrics()}
+	for i := 0; i < 10; i++ {
+		h.tunnelMetrics.connectionsOpened(5, 1)
+	}
+
+	metrics := fetchTunnelMetrics(t, h)
+	if got := metrics[5].Connections; got != 10 {
+		t.Fatalf("expected 10 connections on tunnel 5, got %d", got)
+	}
+
+	h.tunnelMetrics.connectionsClosed(5, 3)
+	h.tunnelMetrics.connectionsClosed(5, 20)
+	if got := fetchTunnelMetrics(t, h)[5].Connections; got != 0 {
+		t.Fatalf("expected connections to floor at 0, got %d", got)
+	}
+}
+
+func TestAdminTunnelMetricsBytesSecAfterDecay(t *testing.T) {
+	h := &Handler{tunnelMetrics: newTunnelMetrics()}
+	h.tunnelMetrics.addBytes(5, 5*1024*1024)
+
+	if got := fetchTunnelMetrics(t, h)[5].BytesSec; got != 0 {
+		t.Fatalf("expected bytesSec 0 before decay, got %f", got)
+	}
+	h.tunnelMetrics.decay(tunnelMetricsDecayInterval)
+	first := fetchTunnelMetrics(t, h)[5].BytesSec
+	if first <= 0 {
+		t.Fatalf("expected non-zero bytesSec after decay, got %f", first)
+	}
+
+	h.tunnelMetrics.decay(tunnelMetricsDecayInterval)
+	if second := fetchTunnelMetrics(t, h)[5].BytesSec; second >= first {
+		t.Fatalf("expected bytesSec to decay without traffic, got %f then %f", first, second)
+	}
+}
+
+func TestProcessFlowItemFeedsTunnelMetrics(t *testing.T) {
+	repo, err := sqlite.Open(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatalf("open repo: %v", err)
+	}
+	defer repo.Close()
+
+	now := time.Now().UnixMilli()
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('metrics-tunnel', 1.0, 1, 'tls', 1, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	res, err = repo.DB().Exec(`
+		INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+		VALUES(1, 'admin_user', 'metrics-forward', ?, '1.1.1.1:443', 'fifo', 0, 0, ?, ?, 1, 0)
+	`, tunnelID, now, now)
+	if err != nil {
+		t.Fatalf("insert forward: %v", err)
+	}
+	forwardID, _ := res.LastInsertId()
+
+	h := &Handler{repo: repo, tunnelMetrics: newTunnelMetrics()}
+	h.processFlowItem(flowItem{N: buildForwardServiceBase(forwardID, 1, 0) + "_tcp", U: 600, D: 400, O: 4, C: 1})
+	h.tunnelMetrics.decay(tunnelMetricsDecayInterval)
+
+	m := fetchTunnelMetrics(t, h)[tunnelID]
+	if m.Connections != 3 {
+		t.Fatalf("expected 3 open connections, got %d", m.Connections)
+	}
+	if m.BytesSec <= 0 {
+		t.Fatalf("expected non-zero bytesSec, got %f", m.BytesSec)
+	}
+}
@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"go-backend/internal/store/sqlite"
+)
+
+// tunnelRuntimeDiff lists the nodes whose part of a tunnel must change when
+// its layout is edited: Changed nodes get their chain or relay service
+// (re)sent, Removed nodes no longer belong to the tunnel.
+type tunnelRuntimeDiff struct {
+	Changed []int64
+	Removed []int64
+}
+
+func (d tunnelRuntimeDiff) empty() bool {
+	return len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// diffTunnelRuntime compares the runtime each node runs for the old and the
+// new layout of a tunnel. A node is unchanged when its role, port, protocol,
+// strategy and next hop are all the same, so editing one hop leaves the
+// others, and the forwards riding on them, alone.
+func diffTunnelRuntime(oldState, newState *tunnelCreateState) tunnelRuntimeDiff {
+	oldSpecs := tunnelNodeRuntimeSpecs(oldState)
+	newSpecs := tunnelNodeRuntimeSpecs(newState)
+	var diff tunnelRuntimeDiff
+	for _, nodeID := range tunnelStateNodeOrder(newState) {
+		spec, ok := newSpecs[nodeID]
+		if !ok {
+			continue
+		}
+		if oldSpecs[nodeID] != spec {
+			diff.Changed = append(diff.Changed, nodeID)
+		}
+	}
+	for _, nodeID := range tunnelStateNodeOrder(oldState) {
+		if _, ok := oldSpecs[nodeID]; !ok {
+			continue
+		}
+		if _, ok := newSpecs[nodeID]; !ok {
+			diff.Removed = append(diff.Removed, nodeID)
+		}
+	}
+	return diff
+}
+
+// tunnelNodeRuntimeSpecs describes, per node, the chain and relay service
+// applyTunnelRuntime sends it. Only tunnel forwarding (type 2) deploys
+// anything; other types have no runtime of their own.
+func tunnelNodeRuntimeSpecs(state *tunnelCreateState) map[int64]string {
+	specs := make(map[int64]string)
+	if state == nil || state.Type != 2 {
+		return specs
+	}
+	firstTargets := state.OutNodes
+	if len(state.ChainHops) > 0 {
+		firstTargets = state.ChainHops[0]
+	}
+	for _, inNode := range state.InNodes {
+		specs[inNode.NodeID] = "in|" + tunnelHopSpec(inNode) + "->" + tunnelTargetsSpec(firstTargets)
+	}
+	for i, hop := range state.ChainHops {
+		nextTargets := state.OutNodes
+		if i+1 < len(state.ChainHops) {
+			nextTargets = state.ChainHops[i+1]
+		}
+		for _, chainNode := range hop {
+			specs[chainNode.NodeID] = fmt.Sprintf("chain%d|%s->%s", i, tunnelHopSpec(chainNode), tunnelTargetsSpec(nextTargets))
+		}
+	}
+	for _, outNode := range state.OutNodes {
+		specs[outNode.NodeID] = "out|" + tunnelHopSpec(outNode)
+	}
+	return specs
+}
+
+func tunnelHopSpec(node tunnelRuntimeNode) string {
+	return fmt.Sprintf("%d:%d:%s:%s", node.NodeID, node.Port, node.Protocol, node.Strategy)
+}
+
+func tunnelTargetsSpec(targets []tunnelRuntimeNode) string {
+	parts := make([]string, 0, len(targets))
+	for _, target := range targets {
+		parts = append(parts, tunnelHopSpec(target))
+	}
+	return strings.Join(parts, ",")
+}
+
+func tunnelStateNodeOrder(state *tunnelCreateState) []int64 {
+	if state == nil {
+		return nil
+	}
+	ids := make([]int64, 0, len(state.NodeIDList))
+	for _, inNode := range state.InNodes {
+		ids = append(ids, inNode.NodeID)
+	}
+	for _, hop := range state.ChainHops {
+		for _, chainNode := range hop {
+			ids = append(ids, chainNode.NodeID)
+		}
+	}
+	for _, outNode := range state.OutNodes {
+		ids = append(ids, outNode.NodeID)
+	}
+	return ids
+}
+
+// tunnelEntryNodesChanged reports whether the entry nodes, which carry the
+// tunnel's forward services, differ between two layouts.
+func tunnelEntryNodesChanged(oldState, newState *tunnelCreateState) bool {
+	if oldState == nil || newState == nil {
+		return true
+	}
+	if len(oldState.InNodes) != len(newState.InNodes) {
+		return true
+	}
+	old := make(map[int64]struct{}, len(oldState.InNodes))
+	for _, inNode := range oldState.InNodes {
+		old[inNode.NodeID] = struct{}{}
+	}
+	for _, inNode := range newState.InNodes {
+		if _, ok := old[inNode.NodeID]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupTunnelNodes removes what the old layout deployed on the given
+// nodes, mirroring cleanupTunnelRuntime for a subset of the tunnel.
+func (h *Handler) cleanupTunnelNodes(oldState *tunnelCreateState, nodeIDs []int64) {
+	if h == nil || oldState == nil || oldState.Type != 2 || len(nodeIDs) == 0 {
+		return
+	}
+	wanted := make(map[int64]struct{}, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		wanted[nodeID] = struct{}{}
+	}
+	serviceName := fmt.Sprintf("%d_tls", oldState.TunnelID)
+	chainName := fmt.Sprintf("chains_%d", oldState.TunnelID)
+	deleteChains := func(nodeID int64) {
+		_, _ = h.sendNodeCommand(nodeID, "DeleteChains", map[string]interface{}{"chain": chainName}, false, true)
+	}
+	deleteService := func(nodeID int64) {
+		_, _ = h.sendNodeCommand(nodeID, "DeleteService", map[string]interface{}{"services": []string{serviceName}}, false, true)
+	}
+
+	for _, inNode := range oldState.InNodes {
+		if _, ok := wanted[inNode.NodeID]; ok {
+			deleteChains(inNode.NodeID)
+		}
+	}
+	for _, hop := range oldState.ChainHops {
+		for _, chainNode := range hop {
+			if _, ok := wanted[chainNode.NodeID]; ok {
+				deleteChains(chainNode.NodeID)
+				deleteService(chainNode.NodeID)
+			}
+		}
+	}
+	for _, outNode := range oldState.OutNodes {
+		if _, ok := wanted[outNode.NodeID]; ok {
+			deleteService(outNode.NodeID)
+		}
+	}
+}
+
+// detachTunnelForwards removes the services of the tunnel's forwards from
+// their current entry nodes and moves their entry ports onto the tunnel's
+// new entry nodes, keeping each forward's port. The caller re-sends the
+// services with syncForwardServices once the tunnel runtime is in place.
+func (h *Handler) detachTunnelForwards(tunnelID int64) []forwardRecord {
+	forwards := h.tunnelForwards(tunnelID)
+	for i := range forwards {
+		ports, err := h.listForwardPorts(forwards[i].ID)
+		if err != nil || len(ports) == 0 {
+			continue
+		}
+		if err := h.controlForwardServices(&forwards[i], "DeleteService", true); err != nil {
+			log.Printf("tunnel %d: delete services of forward %d failed: %v", tunnelID, forwards[i].ID, err)
+		}
+		if err := h.replaceForwardPorts(forwards[i].ID, tunnelID, ports[0].Port); err != nil {
+			log.Printf("tunnel %d: move ports of forward %d failed: %v", tunnelID, forwards[i].ID, err)
+		}
+	}
+	return forwards
+}
+
+// tunnelForwards lists the tunnel's forwards for resendTunnelForwards.
+func (h *Handler) tunnelForwards(tunnelID int64) []forwardRecord {
+	forwards, err := h.listForwardsByTunnel(tunnelID)
+	if err != nil {
+		log.Printf("tunnel %d: list forwards failed: %v", tunnelID, err)
+		return nil
+	}
+	return forwards
+}
+
+// resendTunnelForwards re-sends the services of the active forwards moved
+// by detachTunnelForwards, or of forwards whose services changed with the
+// tunnel's settings.
+func (h *Handler) resendTunnelForwards(tunnelID int64, forwards []forwardRecord) {
+	for i := range forwards {
+		if forwards[i].Status != 1 {
+			continue
+		}
+		if err := h.syncForwardServices(&forwards[i], "UpdateService", true); err != nil {
+			log.Printf("tunnel %d: re-send forward %d failed: %v", tunnelID, forwards[i].ID, err)
+		}
+	}
+}
+
+// currentTunnelHopPortsTx returns the ports a tunnel's hops listen on today
+// that are still inside their node's port range, so an update can keep them
+// instead of picking new ones.
+func currentTunnelHopPortsTx(db sqlite.Execer, tunnelID int64) (map[int64]int, error) {
+	ports := map[int64]int{}
+	if db == nil || tunnelID <= 0 {
+		return ports, nil
+	}
+	rows, err := db.Query(`
+		SELECT ct.node_id, ct.port, n.port
+		FROM chain_tunnel ct
+		JOIN node n ON n.id = ct.node_id
+		WHERE ct.tunnel_id = ? AND ct.port > 0
+	`, tunnelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var nodeID int64
+		var port int
+		var portRange sql.NullString
+		if err := rows.Scan(&nodeID, &port, &portRange); err != nil {
+			return nil, err
+		}
+		for _, candidate := range parsePortRangeSpec(portRange.String) {
+			if candidate == port {
+				ports[nodeID] = port
+				break
+			}
+		}
+	}
+	return ports, rows.Err()
+}
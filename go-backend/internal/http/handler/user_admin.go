@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+	"go-backend/internal/store"
+	"go-backend/internal/store/sqlite"
+)
+
+type userResetPasswordRequest struct {
+	ID       int64  `json:"id"`
+	Password string `json:"password"`
+}
+
+// userResetPassword sets a new password for a user, e.g. one who lost it
+// and has no email on file. The user is logged out everywhere.
+func (h *Handler) userResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req userResetPasswordRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if strings.TrimSpace(req.Password) == "" {
+		response.WriteJSON(w, response.ErrDefault("密码不能为空"))
+		return
+	}
+	user, ok := h.managedUser(w, r, req.ID)
+	if !ok {
+		return
+	}
+	if err := h.passwordPolicy().Check(req.Password); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	passwordHash, err := security.HashPassword(req.Password)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if err := h.repo.SetUserPassword(user.ID, passwordHash, time.Now().UnixMilli()); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.revokeUserSessions(user.ID)
+	h.writeAuditLog(r, "user_password_reset", "user", user.ID, user.User)
+	h.notifySecurityEvent(r, user.ID, user.User, eventSecurityPasswordChanged, "管理员重置")
+	response.WriteJSON(w, response.OKEmpty())
+}
+
+type userToggleStatusRequest struct {
+	ID int64 `json:"id"`
+	// Status sets the status; without it the status is flipped.
+	Status *int `json:"status"`
+}
+
+// userToggleStatus enables or disables a user. Disabling pauses the user's
+// active forwards and logs the user out; enabling leaves the forwards
+// paused for the user to resume, as after expiry.
+func (h *Handler) userToggleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	var req userToggleStatusRequest
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	if req.Status != nil && *req.Status != 0 && *req.Status != 1 {
+		response.WriteJSON(w, response.ErrDefault("请求参数错误"))
+		return
+	}
+	user, ok := h.managedUser(w, r, req.ID)
+	if !ok {
+		return
+	}
+	status := 1 - user.Status
+	if req.Status != nil {
+		status = *req.Status
+	}
+
+	now := time.Now().UnixMilli()
+	if status == 0 {
+		h.pauseUserForwards(user.ID, now)
+	}
+	if err := h.repo.SetUserStatus(user.ID, status, now); err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	if status == 0 {
+		h.revokeUserSessions(user.ID)
+	}
+	action := "user_enable"
+	if status == 0 {
+		action = "user_disable"
+	}
+	h.writeAuditLog(r, action, "user", user.ID, user.User)
+	response.WriteJSON(w, response.OK(map[string]interface{}{"status": status}))
+}
+
+// managedUser loads the non-admin user id for an admin change and checks
+// that the caller outranks it. On failure the error response is already
+// written.
+func (h *Handler) managedUser(w http.ResponseWriter, r *http.Request, id int64) (*sqlite.User, bool) {
+	if id <= 0 {
+		response.WriteJSON(w, response.ErrDefault("用户ID不能为空"))
+		return nil, false
+	}
+	user, err := h.repo.GetUserByID(id)
+	if store.IsNotFound(err) {
+		response.WriteJSON(w, response.ErrDefault("用户不存在"))
+		return nil, false
+	}
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return nil, false
+	}
+	if user.RoleID == 0 {
+		response.WriteJSON(w, response.ErrDefault("请不要作死"))
+		return nil, false
+	}
+	if err := h.checkManageableUser(r, user.RoleID, user.PermissionMask); err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return nil, false
+	}
+	return user, true
+}
+
+// deleteUserForwardServices removes the services of every forward of a
+// user from the nodes before the user is deleted. Nodes that cannot be
+// reached are logged and skipped so the delete still goes through.
+func (h *Handler) deleteUserForwardServices(userID int64) {
+	rows, err := h.repo.DB().Query(`
+		SELECT `+forwardRecordColumns+`
+		FROM forward
+		WHERE user_id = ?
+		ORDER BY id ASC
+	`, userID)
+	if err != nil {
+		log.Printf("list forwards of user %d: %v", userID, err)
+		return
+	}
+	forwards, err := scanForwardRecords(rows)
+	_ = rows.Close()
+	if err != nil {
+		log.Printf("list forwards of user %d: %v", userID, err)
+		return
+	}
+	for i := range forwards {
+		if err := h.controlForwardServices(&forwards[i], "DeleteService", true); err != nil {
+			log.Printf("delete services of forward %d: %v", forwards[i].ID, err)
+		}
+	}
+}
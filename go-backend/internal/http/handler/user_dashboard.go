@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/middleware"
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+const (
+	userDashboardCacheTTL    = 30 * time.Second
+	userDashboardGraphHours  = 24
+	userDashboardExpiryAhead = 7 * 24 * time.Hour
+)
+
+// FlowBucket is one point of a user's traffic graph.
+type FlowBucket struct {
+	Time string `json:"time"`
+	Flow int64  `json:"flow"`
+}
+
+type userDashboard struct {
+	TotalFlow       int64        `json:"totalFlow"`
+	UsedFlow        int64        `json:"usedFlow"`
+	RemainingFlow   int64        `json:"remainingFlow"`
+	ActiveTunnels   int          `json:"activeTunnels"`
+	ActiveForwards  int          `json:"activeForwards"`
+	ExpiringIn7Days int          `json:"expiringIn7Days"`
+	RecentFlowGraph []FlowBucket `json:"recentFlowGraph"`
+}
+
+type userDashboardCacheEntry struct {
+	data    userDashboard
+	expires time.Time
+}
+
+// userDashboardCache holds computed dashboards per user_id so that frequent
+// polling from the UI does not recompute them on every request.
+type userDashboardCache struct {
+	entries sync.Map // user_id -> userDashboardCacheEntry
+}
+
+func (c *userDashboardCache) get(userID int64, now time.Time) (userDashboard, bool) {
+	if c == nil {
+		return userDashboard{}, false
+	}
+	v, ok := c.entries.Load(userID)
+	if !ok {
+		return userDashboard{}, false
+	}
+	entry := v.(userDashboardCacheEntry)
+	if !now.Before(entry.expires) {
+		c.entries.Delete(userID)
+		return userDashboard{}, false
+	}
+	return entry.data, true
+}
+
+func (c *userDashboardCache) put(userID int64, data userDashboard, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.entries.Store(userID, userDashboardCacheEntry{data: data, expires: now.Add(userDashboardCacheTTL)})
+}
+
+// hourlyFlowBuckets spreads hourly statistics over the `hours` full hours
+// ending with the one containing now. Hours without samples are zero.
+func hourlyFlowBuckets(samples []sqlite.HourlyFlow, now time.Time, hours int) []FlowBucket {
+	if hours <= 0 {
+		return []FlowBucket{}
+	}
+	start := now.Truncate(time.Hour).Add(-time.Duration(hours-1) * time.Hour)
+	buckets := make([]FlowBucket, hours)
+	for i := range buckets {
+		buckets[i].Time = start.Add(time.Duration(i) * time.Hour).Format("15:04")
+	}
+	startMs := start.UnixMilli()
+	hourMs := int64(time.Hour / time.Millisecond)
+	for _, s := range samples {
+		if s.HourStart < startMs {
+			continue
+		}
+		idx := int((s.HourStart - startMs) / hourMs)
+		if idx >= hours {
+			continue
+		}
+		buckets[idx].Flow += s.Flow
+	}
+	return buckets
+}
+
+func (h *Handler) buildUserDashboard(userID int64, now time.Time) (userDashboard, error) {
+	var out userDashboard
+	total, used, err := h.repo.GetUserFlowSummary(userID)
+	if err != nil {
+		return out, err
+	}
+	out.TotalFlow = total
+	out.UsedFlow = used
+	if remaining := total - used; remaining > 0 {
+		out.RemainingFlow = remaining
+	}
+
+	nowMs := now.UnixMilli()
+	if out.ActiveTunnels, err = h.repo.CountUserActiveTunnels(userID, nowMs); err != nil {
+		return out, err
+	}
+	if out.ActiveForwards, err = h.repo.CountUserActiveForwards(userID); err != nil {
+		return out, err
+	}
+	if out.ExpiringIn7Days, err = h.repo.CountUserTunnelsExpiringBefore(userID, nowMs, now.Add(userDashboardExpiryAhead).UnixMilli()); err != nil {
+		return out, err
+	}
+
+	since := now.Truncate(time.Hour).Add(-(userDashboardGraphHours - 1) * time.Hour)
+	samples, err := h.repo.GetUserHourlyFlow(userID, since.UnixMilli())
+	if err != nil {
+		return out, err
+	}
+	out.RecentFlowGraph = hourlyFlowBuckets(samples, now, userDashboardGraphHours)
+	return out, nil
+}
+
+func (h *Handler) userDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+
+	claims, ok := r.Context().Value(middleware.ClaimsContextKey).(auth.Claims)
+	if !ok {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+	userID, err := parseUserID(claims.Sub)
+	if err != nil {
+		response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
+		return
+	}
+
+	now := time.Now()
+	if cached, ok := h.dashboardCache.get(userID, now); ok {
+		response.WriteJSON(w, response.OK(cached))
+		return
+	}
+
+	data, err := h.buildUserDashboard(userID, now)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.dashboardCache.put(userID, data, now)
+	response.WriteJSON(w, response.OK(data))
+}
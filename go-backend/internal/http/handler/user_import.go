@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+	"go-backend/internal/store/sqlite"
+)
+
+const (
+	// userImportMaxRows caps the users one import may create.
+	userImportMaxRows = 1000
+	// userImportMaxBytes caps the size of an import upload.
+	userImportMaxBytes = 4 << 20
+)
+
+type userImportRequest struct {
+	Users  []map[string]interface{} `json:"users"`
+	CSV    string                   `json:"csv"`
+	DryRun bool                     `json:"dryRun"`
+}
+
+type userImportError struct {
+	Row   int    `json:"row"`
+	User  string `json:"user"`
+	Error string `json:"error"`
+}
+
+type userImportPreview struct {
+	Row     int     `json:"row"`
+	User    string  `json:"user"`
+	Flow    int64   `json:"flow"`
+	Num     int     `json:"num"`
+	ExpTime int64   `json:"expTime"`
+	Tunnels []int64 `json:"tunnels"`
+}
+
+// userImport creates users in bulk from a CSV or JSON upload. Rows carry
+// user, pwd, flow, num, expTime (unix ms or YYYY-MM-DD) and tunnels (tunnel
+// ids separated by ";" in CSV). Every row is checked first; any error
+// rejects the whole import. With dryRun nothing is written and the response
+// lists the users that would be created next to the row errors.
+//
+// The upload is a multipart "file" field, a text/csv body, or JSON with
+// either a "users" array or a "csv" string.
+func (h *Handler) userImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteJSON(w, response.ErrDefault("请求失败"))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, userImportMaxBytes)
+	rows, dryRun, err := readUserImport(r)
+	if err != nil {
+		response.WriteJSON(w, response.ErrDefault(err.Error()))
+		return
+	}
+	if len(rows) == 0 {
+		response.WriteJSON(w, response.ErrDefault("导入数据不能为空"))
+		return
+	}
+	if len(rows) > userImportMaxRows {
+		response.WriteJSON(w, response.ErrDefault(fmt.Sprintf("单次最多导入 %d 个用户", userImportMaxRows)))
+		return
+	}
+
+	users, previews, rowErrs := h.prepareUserImport(rows, !dryRun)
+	if dryRun {
+		response.WriteJSON(w, response.OK(map[string]interface{}{
+			"dryRun": true,
+			"total":  len(rows),
+			"users":  previews,
+			"errors": rowErrs,
+		}))
+		return
+	}
+	if len(rowErrs) > 0 {
+		resp := response.ErrDefault("导入数据校验失败")
+		resp.Data = map[string]interface{}{"errors": rowErrs}
+		response.WriteJSON(w, resp)
+		return
+	}
+
+	ids, err := h.repo.ImportUsers(users)
+	if err != nil {
+		response.WriteJSON(w, response.Err(-2, err.Error()))
+		return
+	}
+	h.writeAuditLog(r, "user_import", "user", 0, fmt.Sprintf("%d users", len(ids)))
+	response.WriteJSON(w, response.OK(map[string]interface{}{"created": len(ids), "ids": ids}))
+}
+
+// readUserImport decodes the rows of an import in any of the accepted
+// upload forms. dryRun may also be given as a query parameter.
+func readUserImport(r *http.Request) ([]map[string]interface{}, bool, error) {
+	dryRun := asBool(r.URL.Query().Get("dryRun"), false)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(userImportMaxBytes); err != nil {
+			return nil, false, errors.New("请求参数错误")
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, false, errors.New("请上传导入文件")
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return nil, false, errors.New("请求参数错误")
+		}
+		dryRun = asBool(r.FormValue("dryRun"), dryRun)
+		if strings.HasSuffix(strings.ToLower(header.Filename), ".json") {
+			rows, err := parseUserImportJSON(data)
+			return rows, dryRun, err
+		}
+		rows, err := parseUserImportCSV(data)
+		return rows, dryRun, err
+	case "text/csv":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, false, errors.New("请求参数错误")
+		}
+		rows, err := parseUserImportCSV(data)
+		return rows, dryRun, err
+	}
+
+	var req userImportRequest
+	// Permissive: import options added by a newer frontend are ignored rather than rejected.
+	if err := decodeJSONPermissive(r.Body, &req); err != nil {
+		return nil, false, errors.New("请求参数错误")
+	}
+	dryRun = dryRun || req.DryRun
+	if strings.TrimSpace(req.CSV) != "" {
+		rows, err := parseUserImportCSV([]byte(req.CSV))
+		return rows, dryRun, err
+	}
+	return req.Users, dryRun, nil
+}
+
+func parseUserImportJSON(data []byte) ([]map[string]interface{}, error) {
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(data, &rows); err == nil {
+		return rows, nil
+	}
+	var wrapped struct {
+		Users []map[string]interface{} `json:"users"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, errors.New("JSON 格式错误")
+	}
+	return wrapped.Users, nil
+}
+
+// parseUserImportCSV reads a CSV with a header row into one map per line,
+// keyed by the header names.
+func parseUserImportCSV(data []byte) ([]map[string]interface{}, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("CSV 格式错误: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := make([]string, len(records[0]))
+	for i, name := range records[0] {
+		header[i] = strings.TrimSpace(name)
+	}
+	rows := make([]map[string]interface{}, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]interface{}, len(header))
+		empty := true
+		for i, value := range record {
+			if i >= len(header) || header[i] == "" {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if value != "" {
+				empty = false
+				row[header[i]] = value
+			}
+		}
+		if !empty {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// prepareUserImport validates every row, including duplicate names within
+// the upload and against existing users. Passwords are only hashed when
+// hash is set, which a dry run skips.
+func (h *Handler) prepareUserImport(rows []map[string]interface{}, hash bool) ([]sqlite.ImportedUser, []userImportPreview, []userImportError) {
+	users := make([]sqlite.ImportedUser, 0, len(rows))
+	previews := make([]userImportPreview, 0, len(rows))
+	rowErrs := make([]userImportError, 0)
+	seen := make(map[string]int, len(rows))
+	tunnelExists := make(map[int64]bool)
+	now := time.Now().UnixMilli()
+
+	for i, row := range rows {
+		rowNum := i + 1
+		username := strings.TrimSpace(asString(row["user"]))
+		fail := func(err error) {
+			rowErrs = append(rowErrs, userImportError{Row: rowNum, User: username, Error: err.Error()})
+		}
+		if username == "" {
+			fail(errors.New("用户名不能为空"))
+			continue
+		}
+		if first, ok := seen[username]; ok {
+			fail(fmt.Errorf("用户名与第 %d 行重复", first))
+			continue
+		}
+		seen[username] = rowNum
+		exists, err := h.repo.UsernameExistsExceptID(username, 0)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		if exists {
+			fail(errors.New("用户名已存在"))
+			continue
+		}
+		pwd := asString(row["pwd"])
+		if pwd == "" {
+			fail(errors.New("密码不能为空"))
+			continue
+		}
+		if err := h.passwordPolicy().Check(pwd); err != nil {
+			fail(err)
+			continue
+		}
+		flow := asInt64(row["flow"], 100)
+		num := asInt(row["num"], 10)
+		if flow < 0 || num < 0 {
+			fail(errors.New("流量和转发数量不能为负数"))
+			continue
+		}
+		expTime, err := parseUserImportExpTime(row["expTime"])
+		if err != nil {
+			fail(err)
+			continue
+		}
+		tunnelIDs, err := h.parseUserImportTunnels(row["tunnels"], tunnelExists)
+		if err != nil {
+			fail(err)
+			continue
+		}
+
+		previews = append(previews, userImportPreview{Row: rowNum, User: username, Flow: flow, Num: num, ExpTime: expTime, Tunnels: tunnelIDs})
+		if !hash {
+			continue
+		}
+		passwordHash, err := security.HashPassword(pwd)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		users = append(users, sqlite.ImportedUser{
+			User: sqlite.User{
+				User:          username,
+				Pwd:           passwordHash,
+				RoleID:        1,
+				ExpTime:       expTime,
+				Flow:          flow,
+				FlowResetTime: asInt64(row["flowResetTime"], 1),
+				Num:           num,
+				CreatedTime:   now,
+				UpdatedTime:   sql.NullInt64{Int64: now, Valid: true},
+				Status:        1,
+			},
+			TunnelIDs: tunnelIDs,
+		})
+	}
+	return users, previews, rowErrs
+}
+
+// parseUserImportExpTime accepts unix milliseconds or a YYYY-MM-DD date and
+// defaults to one year from now like userCreate.
+func parseUserImportExpTime(v interface{}) (int64, error) {
+	s := strings.TrimSpace(asString(v))
+	if s == "" {
+		return time.Now().Add(365 * 24 * time.Hour).UnixMilli(), nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil && ms > 0 {
+		return ms, nil
+	}
+	if day, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return day.UnixMilli(), nil
+	}
+	return 0, fmt.Errorf("到期时间格式错误: %q", s)
+}
+
+// parseUserImportTunnels reads tunnel ids from a JSON array or a ";" or
+// "," separated string and checks that each tunnel exists.
+func (h *Handler) parseUserImportTunnels(v interface{}, exists map[int64]bool) ([]int64, error) {
+	var parts []string
+	switch t := v.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range t {
+			parts = append(parts, asString(item))
+		}
+	default:
+		parts = strings.FieldsFunc(asString(t), func(r rune) bool { return r == ';' || r == ',' || r == '|' })
+	}
+
+	ids := make([]int64, 0, len(parts))
+	seen := make(map[int64]struct{}, len(parts))
+	for _, part := range parts {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("隧道ID格式错误: %q", part)
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		ok, checked := exists[id]
+		if !checked {
+			var count int
+			if err := h.repo.DB().QueryRow(`SELECT COUNT(1) FROM tunnel WHERE id = ?`, id).Scan(&count); err != nil {
+				return nil, err
+			}
+			ok = count > 0
+			exists[id] = ok
+		}
+		if !ok {
+			return nil, fmt.Errorf("隧道 %d 不存在", id)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
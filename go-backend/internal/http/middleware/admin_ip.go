@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/network"
+	"go-backend/internal/store/sqlite"
+)
+
+const (
+	AdminAllowedIPsConfigKey = "admin_allowed_ips"
+	adminAllowlistCacheTTL   = 60 * time.Second
+)
+
+// ConfigReader is the part of the repository the allowlist needs.
+type ConfigReader interface {
+	GetConfigByName(name string) (*sqlite.ViteConfig, error)
+}
+
+type adminAllowlist struct {
+	repo ConfigReader
+
+	mu       sync.Mutex
+	nets     []*net.IPNet
+	loadedAt time.Time
+}
+
+// AdminIPAllowlist rejects requests whose client IP is not covered by the
+// comma-separated IPs/CIDRs in admin_allowed_ips. It is attached to the admin
+// and permission-gated route groups, so every management route is covered
+// without keeping a list of paths. The client IP is resolved with
+// network.ClientIP, the same as for peer-share AllowedIPs, so X-Forwarded-For
+// is only honoured from trusted proxies. An empty or missing setting allows
+// everyone. Create it once and share it so the groups share one cache.
+func AdminIPAllowlist(repo ConfigReader) func(http.Handler) http.Handler {
+	list := &adminAllowlist{repo: repo}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nets := list.load()
+			if len(nets) > 0 && !network.AllowListContains(nets, network.ClientIP(r)) {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusForbidden)
+				_ = json.NewEncoder(w).Encode(response.Err(403, "当前IP不允许访问管理接口"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (l *adminAllowlist) load() []*net.IPNet {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.loadedAt.IsZero() && time.Since(l.loadedAt) < adminAllowlistCacheTTL {
+		return l.nets
+	}
+	raw := ""
+	if l.repo != nil {
+		if cfg, err := l.repo.GetConfigByName(AdminAllowedIPsConfigKey); err == nil && cfg != nil {
+			raw = cfg.Value
+		}
+	}
+	l.nets = network.ParseAllowList(raw)
+	l.loadedAt = time.Now()
+	return l.nets
+}
@@ -2,50 +2,93 @@ package middleware
 
 import (
 	"context"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"go-backend/internal/auth"
 	"go-backend/internal/http/response"
+	"go-backend/internal/network"
+	"go-backend/internal/store/sqlite"
 )
 
 type contextKey string
 
 const ClaimsContextKey contextKey = "claims"
 
-type AuthOptions struct {
-	JWTSecret string
+// RequireJWT rejects requests without a valid token and stores the token
+// claims in the request context.
+func RequireJWT(jwtSecret string) func(http.Handler) http.Handler {
+	return RequireJWTWithConfig(jwtSecret, nil)
 }
 
-func JWT(opts AuthOptions) func(http.Handler) http.Handler {
+// SessionChecker reports whether a refresh session was revoked, and for
+// impersonation tokens, which have no session, whether the user's tokens
+// were. The repository implements it next to ConfigReader.
+type SessionChecker interface {
+	IsSessionRevoked(id int64) (bool, error)
+	IsUserTokenRevoked(userID, issuedAt int64) (bool, error)
+}
+
+// SessionToucher records when and from where a session was last used. The
+// repository implements it next to SessionChecker.
+type SessionToucher interface {
+	TouchSession(id int64, ip string, now int64) error
+}
+
+// APIKeyAuthenticator resolves an API key hash to the user owning it. The
+// repository implements it next to ConfigReader.
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(keyHash string, now int64) (*sqlite.User, error)
+}
+
+// RequireJWTWithConfig is RequireJWT with the token audience checked against
+// jwt_audience read from repo. When repo is also a SessionChecker, tokens
+// issued under a revoked session are refused; when it is an
+// APIKeyAuthenticator, requests without a token may send an X-Api-Key
+// instead. When it is a SessionToucher, the session's last-seen time and IP
+// are kept current.
+func RequireJWTWithConfig(jwtSecret string, repo ConfigReader) func(http.Handler) http.Handler {
+	return RequireJWTWithKeyring(auth.NewKeyring(jwtSecret), repo)
+}
+
+// RequireJWTWithKeyring is RequireJWTWithConfig verifying tokens against
+// every active key in keys, so tokens signed before a rotation keep working.
+func RequireJWTWithKeyring(keys *auth.Keyring, repo ConfigReader) func(http.Handler) http.Handler {
+	validate := TokenValidator(keys, repo)
+	touches := &sessionTouches{last: make(map[int64]time.Time)}
+	touches.repo, _ = repo.(SessionToucher)
+	apiKeys, _ := repo.(APIKeyAuthenticator)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if shouldSkip(r.URL.Path) {
-				next.ServeHTTP(w, r)
+			token := strings.TrimSpace(r.Header.Get("Authorization"))
+			if key := strings.TrimSpace(r.Header.Get(auth.APIKeyHeader)); token == "" && key != "" && apiKeys != nil {
+				claims, ok := apiKeyClaims(apiKeys, key)
+				if !ok {
+					response.WriteJSON(w, response.Err(401, "无效的API Key"))
+					return
+				}
+				ctx := context.WithValue(r.Context(), ClaimsContextKey, claims)
+				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
-
-			if !strings.HasPrefix(r.URL.Path, "/api/") {
-				next.ServeHTTP(w, r)
-				return
+			if token == "" {
+				token = tokenFromCookie(r)
 			}
-
-			token := strings.TrimSpace(r.Header.Get("Authorization"))
 			if token == "" {
 				response.WriteJSON(w, response.Err(401, "未登录或token已过期"))
 				return
 			}
 
-			claims, ok := auth.ValidateToken(token, opts.JWTSecret)
+			claims, ok := validate(token)
 			if !ok {
 				response.WriteJSON(w, response.Err(401, "无效的token或token已过期"))
 				return
 			}
-
-			if requiresAdmin(r.URL.Path) && claims.RoleID != 0 {
-				response.WriteJSON(w, response.Err(403, "权限不足，仅管理员可操作"))
-				return
-			}
+			touches.touch(claims.Sid, r)
 
 			ctx := context.WithValue(r.Context(), ClaimsContextKey, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -53,6 +96,106 @@ func JWT(opts AuthOptions) func(http.Handler) http.Handler {
 	}
 }
 
+// TokenValidator returns the login token check of RequireJWTWithKeyring:
+// signature, jwt_audience and session revocation. It is for tokens that do
+// not arrive in the Authorization header, such as the admin websocket's.
+func TokenValidator(keys *auth.Keyring, repo ConfigReader) func(token string) (auth.Claims, bool) {
+	aud := &jwtAudience{repo: repo}
+	sessions, _ := repo.(SessionChecker)
+	return func(token string) (auth.Claims, bool) {
+		claims, ok := auth.ValidateTokenWithKeyring(token, keys)
+		if !ok || !aud.accepts(claims) || sessionRevoked(sessions, claims) {
+			return auth.Claims{}, false
+		}
+		return claims, true
+	}
+}
+
+// apiKeyClaims builds the claims a login token for the key's owner would
+// carry, so handlers cannot tell the two apart.
+func apiKeyClaims(apiKeys APIKeyAuthenticator, key string) (auth.Claims, bool) {
+	now := time.Now()
+	user, err := apiKeys.AuthenticateAPIKey(auth.HashAPIKey(key), now.UnixMilli())
+	if err != nil || user == nil {
+		return auth.Claims{}, false
+	}
+	return auth.Claims{
+		Sub:         strconv.FormatInt(user.ID, 10),
+		Iat:         now.Unix(),
+		User:        user.User,
+		Name:        user.User,
+		RoleID:      user.RoleID,
+		Permissions: user.PermissionMask,
+	}, true
+}
+
+// sessionRevoked reports whether claims must be refused because their
+// session, or for impersonation tokens their user, was revoked. A failed
+// lookup refuses.
+func sessionRevoked(sessions SessionChecker, claims auth.Claims) bool {
+	if sessions == nil {
+		return false
+	}
+	if claims.Sid > 0 {
+		revoked, err := sessions.IsSessionRevoked(claims.Sid)
+		return err != nil || revoked
+	}
+	// Only impersonation tokens are issued outside a session; any other
+	// token without one predates sessions and cannot be revoked.
+	if claims.Act <= 0 {
+		return true
+	}
+	userID, err := strconv.ParseInt(claims.Sub, 10, 64)
+	if err != nil {
+		return true
+	}
+	revoked, err := sessions.IsUserTokenRevoked(userID, claims.Iat*1000)
+	return err != nil || revoked
+}
+
+// sessionTouchInterval bounds how often a session's last-seen time is
+// written; a busy client would otherwise write on every request.
+const sessionTouchInterval = time.Minute
+
+// sessionTouchMaxTracked bounds the sessions whose last write is remembered.
+const sessionTouchMaxTracked = 10000
+
+type sessionTouches struct {
+	repo SessionToucher
+
+	mu   sync.Mutex
+	last map[int64]time.Time
+}
+
+func (t *sessionTouches) touch(sid int64, r *http.Request) {
+	if t.repo == nil || sid <= 0 {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	if now.Sub(t.last[sid]) < sessionTouchInterval {
+		t.mu.Unlock()
+		return
+	}
+	if len(t.last) >= sessionTouchMaxTracked {
+		for id, at := range t.last {
+			if now.Sub(at) >= sessionTouchInterval {
+				delete(t.last, id)
+			}
+		}
+	}
+	t.last[sid] = now
+	t.mu.Unlock()
+
+	ip := ""
+	if addr := network.ClientIP(r); addr != nil {
+		ip = addr.String()
+	}
+	if err := t.repo.TouchSession(sid, ip, now.UnixMilli()); err != nil {
+		log.Printf("touch session %d: %v", sid, err)
+	}
+}
+
 func RequireAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		raw := r.Context().Value(ClaimsContextKey)
@@ -69,75 +212,78 @@ func RequireAdmin(next http.Handler) http.Handler {
 	})
 }
 
-func shouldSkip(path string) bool {
-	switch {
-	case strings.HasPrefix(path, "/flow/"):
-		return true
-	case strings.HasPrefix(path, "/api/v1/open_api/"):
-		return true
-	case strings.HasPrefix(path, "/api/v1/captcha/"):
-		return true
-	case path == "/api/v1/config/get":
-		return true
-	case path == "/api/v1/user/login":
-		return true
-	case path == "/api/v1/federation/connect":
-		return true
-	case path == "/api/v1/federation/tunnel/create":
-		return true
-	case path == "/api/v1/federation/runtime/reserve-port":
-		return true
-	case path == "/api/v1/federation/runtime/apply-role":
-		return true
-	case path == "/api/v1/federation/runtime/release-role":
-		return true
-	case path == "/api/v1/federation/runtime/diagnose":
-		return true
-	case path == "/api/v1/federation/runtime/command":
-		return true
-	default:
-		return false
+// RequirePermission lets through full admins and users whose token carries
+// every bit of perm.
+func RequirePermission(perm auth.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw := r.Context().Value(ClaimsContextKey)
+			claims, ok := raw.(auth.Claims)
+			if !ok {
+				response.WriteJSON(w, response.Err(401, "无法获取用户权限信息"))
+				return
+			}
+			if !claims.HasPermission(perm) {
+				response.WriteJSON(w, response.Err(403, "权限不足，仅管理员可操作"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
 }
 
-func requiresAdmin(path string) bool {
-	if strings.HasPrefix(path, "/api/v1/group/") {
-		return true
-	}
-
-	if strings.HasPrefix(path, "/api/v1/federation/share/") {
-		return true
-	}
-
-	if strings.HasPrefix(path, "/api/v1/node/") {
-		return true
-	}
+// RejectImpersonation refuses tokens an admin obtained to act as another
+// user. It guards the endpoints that change the user's own credentials.
+func RejectImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, ok := r.Context().Value(ClaimsContextKey).(auth.Claims); ok && claims.Act != 0 {
+			response.WriteJSON(w, response.Err(403, "模拟登录状态下不能执行此操作"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-	if strings.HasPrefix(path, "/api/v1/speed-limit/") {
-		return true
-	}
+// RoleActionReader returns the actions granted to a role. The repository
+// implements it next to ConfigReader.
+type RoleActionReader interface {
+	RoleActions(roleID int) ([]string, error)
+}
 
-	if strings.HasPrefix(path, "/api/v1/backup/") {
-		return true
+// RequireAction lets through full admins and tokens whose role or
+// permission_mask grants action. Role actions are read on every request, so
+// edits to a role apply without a new login.
+func RequireAction(roles RoleActionReader, action auth.Action) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw := r.Context().Value(ClaimsContextKey)
+			claims, ok := raw.(auth.Claims)
+			if !ok {
+				response.WriteJSON(w, response.Err(401, "无法获取用户权限信息"))
+				return
+			}
+			if !Can(roles, claims, action) {
+				response.WriteJSON(w, response.Err(403, "权限不足，仅管理员可操作"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
+}
 
-	if strings.HasPrefix(path, "/api/v1/api/v1/backup/") {
+// Can reports whether claims may perform action, loading the role's actions
+// from roles when the token's own bits are not enough. A lookup failure
+// denies.
+func Can(roles RoleActionReader, claims auth.Claims, action auth.Action) bool {
+	if claims.Can(action, nil) {
 		return true
 	}
-
-	if strings.HasPrefix(path, "/api/v1/tunnel/") {
-		if strings.HasPrefix(path, "/api/v1/tunnel/user/tunnel") {
-			return false
-		}
-		return true
+	if roles == nil {
+		return false
 	}
-
-	switch path {
-	case "/api/v1/user/create", "/api/v1/user/list", "/api/v1/user/update", "/api/v1/user/delete", "/api/v1/user/reset":
-		return true
-	case "/api/v1/config/update", "/api/v1/config/update-single":
-		return true
-	default:
+	actions, err := roles.RoleActions(claims.RoleID)
+	if err != nil {
 		return false
 	}
+	return claims.Can(action, actions)
 }
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+// bufferWriter holds a response back so ConditionalGet can hash it before
+// anything reaches the client.
+type bufferWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+// ConditionalGet tags successful list responses with a weak ETag taken from
+// the data payload (the envelope's ts changes on every call) and a
+// Last-Modified taken from the newest updatedTime among the listed items. A
+// request whose If-None-Match or If-Modified-Since still matches gets 304
+// Not Modified without a body. NDJSON streams and error envelopes pass
+// through untouched.
+func ConditionalGet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if response.WantsNDJSON(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf := &bufferWriter{header: w.Header()}
+		next.ServeHTTP(response.WithFieldCase(buf, response.FieldCaseOf(w)), r)
+		if buf.status == 0 {
+			buf.status = http.StatusOK
+		}
+
+		var envelope struct {
+			Code int             `json:"code"`
+			Data json.RawMessage `json:"data"`
+		}
+		if buf.status != http.StatusOK || json.Unmarshal(buf.body.Bytes(), &envelope) != nil || envelope.Code != 0 {
+			w.WriteHeader(buf.status)
+			_, _ = w.Write(buf.body.Bytes())
+			return
+		}
+
+		sum := md5.Sum(envelope.Data)
+		etag := `W/"` + hex.EncodeToString(sum[:]) + `"`
+		w.Header().Set("ETag", etag)
+		var modified time.Time
+		if latest := latestUpdatedTime(envelope.Data); latest > 0 {
+			modified = time.UnixMilli(latest).UTC()
+			w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		}
+
+		if notModified(r, etag, modified) {
+			w.Header().Del("Content-Type")
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(buf.body.Bytes())
+	})
+}
+
+// notModified applies If-None-Match, or If-Modified-Since when the former is
+// absent, as RFC 9110 orders them.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if modified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// latestUpdatedTime returns the largest updatedTime (or updated_time) among
+// the items of a list payload, which is either a bare array or an object
+// with an items array. It returns 0 when no item carries one.
+func latestUpdatedTime(data json.RawMessage) int64 {
+	var items []map[string]interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		var page struct {
+			Items []map[string]interface{} `json:"items"`
+		}
+		if json.Unmarshal(data, &page) != nil {
+			return 0
+		}
+		items = page.Items
+	}
+	var latest int64
+	for _, item := range items {
+		for _, key := range []string{"updatedTime", "updated_time"} {
+			if v, ok := item[key].(float64); ok && int64(v) > latest {
+				latest = int64(v)
+			}
+		}
+	}
+	return latest
+}
@@ -1,17 +1,166 @@
 package middleware
 
-import "net/http"
-
-func CORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, PUT, OPTIONS")
-		w.Header().Set("Access-Control-Expose-Headers", "Authorization")
-		if r.Method == http.MethodOptions {
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+const (
+	CORSAllowedOriginsConfigKey = "cors_allowed_origins"
+	CORSAllowedMethodsConfigKey = "cors_allowed_methods"
+	CORSAllowedHeadersConfigKey = "cors_allowed_headers"
+
+	defaultCORSAllowedMethods = "GET, POST, DELETE, PUT, OPTIONS"
+	corsCacheTTL              = 60 * time.Second
+	corsPreflightMaxAge       = "600"
+)
+
+type corsPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+	methods   string
+	headers   string
+}
+
+type corsConfig struct {
+	repo ConfigReader
+
+	mu       sync.Mutex
+	policy   corsPolicy
+	loadedAt time.Time
+}
+
+// CORS answers cross-origin requests according to cors_allowed_origins, a
+// comma-separated list of origins such as https://panel.example.com, or *
+// (the default) for any origin. With an explicit list the allowed origin is
+// echoed back and credentials are allowed, so cookie auth works across
+// origins; with * they are not. Preflights from other origins are refused,
+// and so are WebSocket upgrades from a foreign origin, since browsers do not
+// preflight those.
+func CORS(repo ConfigReader) func(http.Handler) http.Handler {
+	cfg := &corsConfig{repo: repo}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := cfg.load()
+			origin := r.Header.Get("Origin")
+			allowed := origin == "" || policy.allows(origin) || sameOrigin(r, origin)
+
+			if isWebSocketUpgrade(r) {
+				if !allowed {
+					writeCORSForbidden(w)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if origin != "" && policy.allows(origin) {
+				h := w.Header()
+				if policy.anyOrigin {
+					h.Set("Access-Control-Allow-Origin", "*")
+				} else {
+					h.Set("Access-Control-Allow-Origin", origin)
+					h.Set("Access-Control-Allow-Credentials", "true")
+					h.Add("Vary", "Origin")
+				}
+				h.Set("Access-Control-Expose-Headers", "Authorization")
+			}
+			if r.Method != http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+				if !policy.allows(origin) {
+					writeCORSForbidden(w)
+					return
+				}
+				h := w.Header()
+				h.Set("Access-Control-Allow-Methods", policy.methods)
+				h.Set("Access-Control-Allow-Headers", policy.allowHeaders(r))
+				h.Set("Access-Control-Max-Age", corsPreflightMaxAge)
+			}
 			w.WriteHeader(http.StatusNoContent)
-			return
+		})
+	}
+}
+
+func (c *corsConfig) load() corsPolicy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loadedAt.IsZero() && time.Since(c.loadedAt) < corsCacheTTL {
+		return c.policy
+	}
+	c.policy = parseCORSPolicy(c.value(CORSAllowedOriginsConfigKey), c.value(CORSAllowedMethodsConfigKey), c.value(CORSAllowedHeadersConfigKey))
+	c.loadedAt = time.Now()
+	return c.policy
+}
+
+func (c *corsConfig) value(name string) string {
+	if c.repo == nil {
+		return ""
+	}
+	cfg, err := c.repo.GetConfigByName(name)
+	if err != nil || cfg == nil {
+		return ""
+	}
+	return strings.TrimSpace(cfg.Value)
+}
+
+func parseCORSPolicy(origins, methods, headers string) corsPolicy {
+	policy := corsPolicy{origins: make(map[string]bool), methods: methods, headers: headers}
+	if policy.methods == "" {
+		policy.methods = defaultCORSAllowedMethods
+	}
+	if policy.headers == "" {
+		policy.headers = "*"
+	}
+	if origins == "" {
+		origins = "*"
+	}
+	for _, part := range strings.Split(origins, ",") {
+		part = strings.TrimRight(strings.TrimSpace(part), "/")
+		switch part {
+		case "":
+		case "*":
+			policy.anyOrigin = true
+		default:
+			policy.origins[strings.ToLower(part)] = true
 		}
-		next.ServeHTTP(w, r)
-	})
+	}
+	return policy
+}
+
+func (p corsPolicy) allows(origin string) bool {
+	return p.anyOrigin || p.origins[strings.ToLower(origin)]
+}
+
+// allowHeaders answers Access-Control-Allow-Headers. Browsers ignore the *
+// wildcard on credentialed requests, so it is expanded to what was asked.
+func (p corsPolicy) allowHeaders(r *http.Request) string {
+	if p.headers == "*" && !p.anyOrigin {
+		return r.Header.Get("Access-Control-Request-Headers")
+	}
+	return p.headers
+}
+
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func writeCORSForbidden(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(response.Err(403, "跨域请求来源不被允许"))
 }
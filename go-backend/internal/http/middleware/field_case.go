@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+const (
+	ResponseFieldCaseConfigKey = "response_field_case"
+	responseFieldCaseCacheTTL  = 10 * time.Second
+)
+
+type responseFieldCase struct {
+	repo ConfigReader
+
+	mu        sync.Mutex
+	fieldCase string
+	loadedAt  time.Time
+}
+
+// ResponseFieldCase renders JSON response keys in the case configured by
+// response_field_case ("camel" or "snake"). Anything else keeps camelCase.
+func ResponseFieldCase(repo ConfigReader) func(http.Handler) http.Handler {
+	fc := &responseFieldCase{repo: repo}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(response.WithFieldCase(w, fc.load()), r)
+		})
+	}
+}
+
+func (c *responseFieldCase) load() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loadedAt.IsZero() && time.Since(c.loadedAt) < responseFieldCaseCacheTTL {
+		return c.fieldCase
+	}
+	c.fieldCase = response.FieldCaseCamel
+	if c.repo != nil {
+		if cfg, err := c.repo.GetConfigByName(ResponseFieldCaseConfigKey); err == nil && cfg != nil &&
+			strings.EqualFold(strings.TrimSpace(cfg.Value), response.FieldCaseSnake) {
+			c.fieldCase = response.FieldCaseSnake
+		}
+	}
+	c.loadedAt = time.Now()
+	return c.fieldCase
+}
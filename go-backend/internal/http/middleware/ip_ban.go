@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/network"
+	"go-backend/internal/store/sqlite"
+)
+
+const (
+	IPBanThresholdConfigKey = "ip_ban_threshold"
+	IPBanWindowConfigKey    = "ip_ban_window_minutes"
+	IPBanDurationConfigKey  = "ip_ban_duration_minutes"
+
+	ipBanCacheTTL        = 60 * time.Second
+	ipBanDefaultLimit    = 10
+	ipBanDefaultWindow   = 15 * time.Minute
+	ipBanDefaultDuration = 30 * time.Minute
+	// ipBanMaxTracked bounds the IPs with recent failures kept in memory.
+	ipBanMaxTracked = 10000
+)
+
+// IPBanStore is the part of the repository IPBans needs.
+type IPBanStore interface {
+	ConfigReader
+	BanIP(ip, reason string, failures int, until, now int64) error
+	ListActiveIPBans(now int64) ([]sqlite.IPBan, error)
+	DeleteIPBan(ip string) (bool, error)
+}
+
+// IPBans counts authentication failures per client IP and bans an IP for
+// ip_ban_duration_minutes once it reaches ip_ban_threshold failures within
+// ip_ban_window_minutes. A threshold of 0 turns banning off. Bans are stored
+// in ip_ban so they survive a restart; failure counts are not.
+type IPBans struct {
+	repo IPBanStore
+	now  func() time.Time
+
+	mu       sync.Mutex
+	failures map[string][]time.Time
+	banned   map[string]int64 // ip -> banned until, unix ms
+	limit    int
+	window   time.Duration
+	duration time.Duration
+	loadedAt time.Time
+}
+
+func NewIPBans(repo IPBanStore) *IPBans {
+	return &IPBans{
+		repo:     repo,
+		now:      time.Now,
+		failures: make(map[string][]time.Time),
+		banned:   make(map[string]int64),
+	}
+}
+
+// IPBan refuses every request from a banned client IP.
+func IPBan(bans *IPBans) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if bans != nil && bans.Banned(network.ClientIP(r)) {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusForbidden)
+				_ = json.NewEncoder(w).Encode(response.Err(403, "当前IP因多次认证失败已被临时封禁"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Banned reports whether ip is under a ban.
+func (b *IPBans) Banned(addr net.IP) bool {
+	if addr == nil {
+		return false
+	}
+	ip := addr.String()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.loadLocked()
+	until, ok := b.banned[ip]
+	return ok && until > b.now().UnixMilli()
+}
+
+// RecordFailure counts a failed login or node authentication from ip and
+// bans it when the threshold is reached. It reports whether ip is banned
+// as a result.
+func (b *IPBans) RecordFailure(addr net.IP, reason string) bool {
+	if addr == nil {
+		return false
+	}
+	ip := addr.String()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.loadLocked()
+	if b.limit <= 0 {
+		return false
+	}
+	now := b.now()
+	cutoff := now.Add(-b.window)
+	hits := b.failures[ip]
+	expired := 0
+	for expired < len(hits) && !hits[expired].After(cutoff) {
+		expired++
+	}
+	hits = append(hits[expired:], now)
+	if len(hits) < b.limit {
+		if _, tracked := b.failures[ip]; !tracked && len(b.failures) >= ipBanMaxTracked {
+			b.pruneLocked(cutoff)
+		}
+		b.failures[ip] = hits
+		return false
+	}
+
+	delete(b.failures, ip)
+	until := now.Add(b.duration).UnixMilli()
+	b.banned[ip] = until
+	if b.repo != nil {
+		_ = b.repo.BanIP(ip, reason, len(hits), until, now.UnixMilli())
+	}
+	return true
+}
+
+// Unban lifts the ban on ip and forgets its failures. It reports whether ip
+// was banned.
+func (b *IPBans) Unban(ip string) (bool, error) {
+	if addr := network.ParseIPLiteral(ip); addr != nil {
+		ip = addr.String()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, cached := b.banned[ip]
+	delete(b.banned, ip)
+	delete(b.failures, ip)
+	if b.repo == nil {
+		return cached, nil
+	}
+	stored, err := b.repo.DeleteIPBan(ip)
+	return cached || stored, err
+}
+
+// loadLocked refreshes the settings and the active bans from the
+// repository at most once per ipBanCacheTTL.
+func (b *IPBans) loadLocked() {
+	now := b.now()
+	if !b.loadedAt.IsZero() && now.Sub(b.loadedAt) < ipBanCacheTTL {
+		return
+	}
+	b.limit = ipBanDefaultLimit
+	b.window = ipBanDefaultWindow
+	b.duration = ipBanDefaultDuration
+	if b.repo != nil {
+		if n, ok := configInt(b.repo, IPBanThresholdConfigKey); ok && n >= 0 {
+			b.limit = n
+		}
+		if n, ok := configInt(b.repo, IPBanWindowConfigKey); ok && n > 0 {
+			b.window = time.Duration(n) * time.Minute
+		}
+		if n, ok := configInt(b.repo, IPBanDurationConfigKey); ok && n > 0 {
+			b.duration = time.Duration(n) * time.Minute
+		}
+		if bans, err := b.repo.ListActiveIPBans(now.UnixMilli()); err == nil {
+			b.banned = make(map[string]int64, len(bans))
+			for _, ban := range bans {
+				b.banned[ban.IP] = ban.BannedUntil
+			}
+		}
+	}
+	b.loadedAt = now
+}
+
+func (b *IPBans) pruneLocked(cutoff time.Time) {
+	for ip, hits := range b.failures {
+		if len(hits) == 0 || !hits[len(hits)-1].After(cutoff) {
+			delete(b.failures, ip)
+		}
+	}
+}
+
+func configInt(repo ConfigReader, name string) (int, bool) {
+	cfg, err := repo.GetConfigByName(name)
+	if err != nil || cfg == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(cfg.Value))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/sqlite"
+)
+
+type stubBanStore struct {
+	stubConfigReader
+	bans map[string]sqlite.IPBan
+}
+
+func (s *stubBanStore) BanIP(ip, reason string, failures int, until, now int64) error {
+	s.bans[ip] = sqlite.IPBan{IP: ip, Reason: reason, Failures: failures, BannedUntil: until, CreatedTime: now}
+	return nil
+}
+
+func (s *stubBanStore) ListActiveIPBans(now int64) ([]sqlite.IPBan, error) {
+	out := make([]sqlite.IPBan, 0, len(s.bans))
+	for _, b := range s.bans {
+		if b.BannedUntil > now {
+			out = append(out, b)
+		}
+	}
+	return out, nil
+}
+
+func (s *stubBanStore) DeleteIPBan(ip string) (bool, error) {
+	_, ok := s.bans[ip]
+	delete(s.bans, ip)
+	return ok, nil
+}
+
+func TestIPBansThresholdWindowAndExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := &stubBanStore{
+		stubConfigReader: stubConfigReader{
+			IPBanThresholdConfigKey: "3",
+			IPBanWindowConfigKey:    "10",
+			IPBanDurationConfigKey:  "5",
+		},
+		bans: map[string]sqlite.IPBan{},
+	}
+	bans := NewIPBans(store)
+	bans.now = func() time.Time { return now }
+	attacker := net.ParseIP("203.0.113.7")
+
+	bans.RecordFailure(attacker, "login")
+	bans.RecordFailure(attacker, "login")
+	// The first two failures fall out of the window.
+	now = now.Add(11 * time.Minute)
+	if bans.RecordFailure(attacker, "login") || bans.Banned(attacker) {
+		t.Fatalf("expected failures outside the window not to count")
+	}
+	bans.RecordFailure(attacker, "login")
+	if !bans.RecordFailure(attacker, "login") {
+		t.Fatalf("expected the third failure in the window to ban")
+	}
+	if !bans.Banned(attacker) || store.bans["203.0.113.7"].Reason != "login" {
+		t.Fatalf("expected the ban to be active and stored, got %+v", store.bans)
+	}
+	if bans.Banned(net.ParseIP("203.0.113.8")) {
+		t.Fatalf("expected other IPs to be unaffected")
+	}
+
+	h := IPBan(bans)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/config/get", nil)
+	req.RemoteAddr = "203.0.113.7:4000"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected a banned IP to be refused, got %d", rec.Code)
+	}
+
+	now = now.Add(6 * time.Minute)
+	if bans.Banned(attacker) {
+		t.Fatalf("expected the ban to run out")
+	}
+}
+
+func TestIPBansUnbanAndDisabled(t *testing.T) {
+	store := &stubBanStore{stubConfigReader: stubConfigReader{IPBanThresholdConfigKey: "1"}, bans: map[string]sqlite.IPBan{}}
+	bans := NewIPBans(store)
+	ip := net.ParseIP("198.51.100.4")
+	if !bans.RecordFailure(ip, "node secret") {
+		t.Fatalf("expected a threshold of 1 to ban at once")
+	}
+	if removed, err := bans.Unban("198.51.100.4"); err != nil || !removed {
+		t.Fatalf("unban: removed=%v err=%v", removed, err)
+	}
+	if bans.Banned(ip) || len(store.bans) != 0 {
+		t.Fatalf("expected the ban to be lifted everywhere")
+	}
+
+	off := NewIPBans(&stubBanStore{stubConfigReader: stubConfigReader{IPBanThresholdConfigKey: "0"}, bans: map[string]sqlite.IPBan{}})
+	for i := 0; i < 50; i++ {
+		if off.RecordFailure(ip, "login") {
+			t.Fatalf("expected a threshold of 0 to disable banning")
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/auth"
+)
+
+const (
+	JWTAudienceConfigKey        = "jwt_audience"
+	JWTLegacyAudCompatConfigKey = "jwt_legacy_aud_compat"
+	jwtAudienceCacheTTL         = 10 * time.Second
+)
+
+// jwtAudience holds the audience tokens must carry. Without a config reader
+// it expects auth.DefaultAudience and accepts legacy tokens.
+type jwtAudience struct {
+	repo ConfigReader
+
+	mu          sync.Mutex
+	audience    string
+	allowLegacy bool
+	loadedAt    time.Time
+}
+
+func (a *jwtAudience) accepts(claims auth.Claims) bool {
+	audience, allowLegacy := a.load()
+	return claims.AcceptsAudience(audience, allowLegacy)
+}
+
+func (a *jwtAudience) load() (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if !a.loadedAt.IsZero() && now.Sub(a.loadedAt) < jwtAudienceCacheTTL {
+		return a.audience, a.allowLegacy
+	}
+	a.audience, a.allowLegacy = LoadJWTAudience(a.repo)
+	a.loadedAt = now
+	return a.audience, a.allowLegacy
+}
+
+// LoadJWTAudience reads jwt_audience and jwt_legacy_aud_compat. Unset
+// values fall back to auth.DefaultAudience and accepting legacy tokens.
+func LoadJWTAudience(repo ConfigReader) (audience string, allowLegacy bool) {
+	audience, allowLegacy = auth.DefaultAudience, true
+	if repo == nil {
+		return audience, allowLegacy
+	}
+	if cfg, err := repo.GetConfigByName(JWTAudienceConfigKey); err == nil && cfg != nil && strings.TrimSpace(cfg.Value) != "" {
+		audience = strings.TrimSpace(cfg.Value)
+	}
+	if cfg, err := repo.GetConfigByName(JWTLegacyAudCompatConfigKey); err == nil && cfg != nil {
+		allowLegacy = !strings.EqualFold(strings.TrimSpace(cfg.Value), "false")
+	}
+	return audience, allowLegacy
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-backend/internal/pki"
+	"go-backend/internal/store/sqlite"
+)
+
+const (
+	NodeMTLSRequiredConfigKey = "node_mtls_required"
+	nodeMTLSCacheTTL          = 60 * time.Second
+)
+
+// NodeCertReader is the part of the repository NodeMTLS needs.
+type NodeCertReader interface {
+	ConfigReader
+	GetNodeClientCert(secret string) (*sqlite.NodeClientCert, error)
+}
+
+type nodeMTLSConfig struct {
+	repo NodeCertReader
+
+	mu       sync.Mutex
+	required bool
+	loadedAt time.Time
+}
+
+// NodeMTLS checks the client certificate on node agent requests (the node
+// WebSocket and the /flow/ reports). A verified certificate must be the one
+// last issued to the node whose secret the request carries. Requests
+// without one are let through unless node_mtls_required is "true", so nodes
+// can be moved to the TLS listener one at a time.
+func NodeMTLS(repo NodeCertReader) func(http.Handler) http.Handler {
+	cfg := &nodeMTLSConfig{repo: repo}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isNodeAgentRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+				if cfg.isRequired() || r.URL.Path == "/flow/cert/renew" {
+					http.Error(w, "client certificate required", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if repo == nil {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			issued, err := repo.GetNodeClientCert(r.URL.Query().Get("secret"))
+			presented := pki.SerialString(r.TLS.VerifiedChains[0][0].SerialNumber)
+			if err != nil || issued == nil || issued.Serial == "" || issued.Serial != presented {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (c *nodeMTLSConfig) isRequired() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loadedAt.IsZero() && time.Since(c.loadedAt) < nodeMTLSCacheTTL {
+		return c.required
+	}
+	c.required = false
+	if c.repo != nil {
+		if cfg, err := c.repo.GetConfigByName(NodeMTLSRequiredConfigKey); err == nil && cfg != nil {
+			c.required = strings.TrimSpace(cfg.Value) == "true"
+		}
+	}
+	c.loadedAt = time.Now()
+	return c.required
+}
+
+func isNodeAgentRequest(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/flow/upload", "/flow/config", "/flow/cert/renew":
+		return true
+	case "/system-info":
+		return r.URL.Query().Get("type") == "1"
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NodeUploadRateLimitConfigKey = "node_upload_rate_limit_per_min"
+	nodeUploadRateLimitCacheTTL  = 10 * time.Second
+	nodeUploadWindow             = time.Minute
+	nodeUploadIdleTTL            = 5 * time.Minute
+)
+
+// uploadWindow holds the times of the uploads a node made in the last
+// window, oldest first.
+type uploadWindow struct {
+	mu       sync.Mutex
+	hits     []time.Time
+	lastSeen time.Time
+}
+
+// NodeUploadStore is what NodeUploadLimits needs from the repository: the
+// limit setting and a way to tell a registered node secret from a guess.
+type NodeUploadStore interface {
+	ConfigReader
+	NodeExistsBySecret(secret string) (bool, error)
+}
+
+// NodeUploadLimits tracks /flow/upload calls per registered node secret.
+// Idle windows are dropped by EvictIdle, which the owner runs periodically.
+type NodeUploadLimits struct {
+	repo     NodeUploadStore
+	fallback int
+	now      func() time.Time
+
+	windows sync.Map // node secret -> *uploadWindow
+
+	mu       sync.Mutex
+	limit    int
+	loadedAt time.Time
+}
+
+// NewNodeUploadLimits reads the limit from node_upload_rate_limit_per_min,
+// falling back to maxPerMinute.
+func NewNodeUploadLimits(maxPerMinute int, repo NodeUploadStore) *NodeUploadLimits {
+	return &NodeUploadLimits{repo: repo, fallback: maxPerMinute, now: time.Now}
+}
+
+// NodeUploadLimit caps how often each node secret may call /flow/upload
+// within a sliding one-minute window. Requests over the limit get 429 with
+// a plain-text body like the endpoint's own replies. Secrets that match no
+// node are passed through uncounted, so the endpoint's own check rejects
+// them and made-up secrets cannot grow the window table.
+func NodeUploadLimit(limits *NodeUploadLimits) func(http.Handler) http.Handler {
+	return limits.wrap
+}
+
+func (l *NodeUploadLimits) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := r.URL.Query().Get("secret")
+		if l.known(secret) && !l.allow(secret) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte("rate limited"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *NodeUploadLimits) known(secret string) bool {
+	if l.repo == nil {
+		return true
+	}
+	ok, err := l.repo.NodeExistsBySecret(secret)
+	return err == nil && ok
+}
+
+// allow records an upload for secret and reports whether it is within the
+// limit. Rejected uploads are not counted.
+func (l *NodeUploadLimits) allow(secret string) bool {
+	now := l.now()
+	v, _ := l.windows.LoadOrStore(secret, &uploadWindow{})
+	win := v.(*uploadWindow)
+
+	win.mu.Lock()
+	defer win.mu.Unlock()
+	win.lastSeen = now
+	cutoff := now.Add(-nodeUploadWindow)
+	expired := 0
+	for expired < len(win.hits) && !win.hits[expired].After(cutoff) {
+		expired++
+	}
+	win.hits = win.hits[expired:]
+	if len(win.hits) >= l.load() {
+		return false
+	}
+	win.hits = append(win.hits, now)
+	return true
+}
+
+func (l *NodeUploadLimits) load() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if !l.loadedAt.IsZero() && now.Sub(l.loadedAt) < nodeUploadRateLimitCacheTTL {
+		return l.limit
+	}
+	l.limit = l.fallback
+	if l.repo != nil {
+		if cfg, err := l.repo.GetConfigByName(NodeUploadRateLimitConfigKey); err == nil && cfg != nil {
+			if n, err := strconv.Atoi(strings.TrimSpace(cfg.Value)); err == nil && n > 0 {
+				l.limit = n
+			}
+		}
+	}
+	l.loadedAt = now
+	return l.limit
+}
+
+// EvictIdle drops the windows of nodes that have not uploaded for five
+// minutes.
+func (l *NodeUploadLimits) EvictIdle() {
+	cutoff := l.now().Add(-nodeUploadIdleTTL)
+	l.windows.Range(func(key, v interface{}) bool {
+		win := v.(*uploadWindow)
+		win.mu.Lock()
+		idle := win.lastSeen.Before(cutoff)
+		win.mu.Unlock()
+		if idle {
+			l.windows.Delete(key)
+		}
+		return true
+	})
+}
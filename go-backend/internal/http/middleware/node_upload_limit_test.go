@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/sqlite"
+)
+
+type stubConfigReader map[string]string
+
+func (s stubConfigReader) GetConfigByName(name string) (*sqlite.ViteConfig, error) {
+	v, ok := s[name]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &sqlite.ViteConfig{Name: name, Value: v}, nil
+}
+
+type stubNodeUploadStore struct {
+	stubConfigReader
+	nodes map[string]bool
+}
+
+func (s stubNodeUploadStore) NodeExistsBySecret(secret string) (bool, error) {
+	return s.nodes[secret], nil
+}
+
+func TestNodeUploadLimiterSlidingWindow(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewNodeUploadLimits(120, nil)
+	l.now = func() time.Time { return now }
+	h := l.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	upload := func(secret string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/flow/upload?secret="+secret, nil))
+		return rec
+	}
+
+	for i := 0; i < 120; i++ {
+		now = now.Add(100 * time.Millisecond)
+		if rec := upload("node-a"); rec.Code != http.StatusOK {
+			t.Fatalf("upload %d: expected 200, got %d", i+1, rec.Code)
+		}
+	}
+	rec := upload("node-a")
+	if rec.Code != http.StatusTooManyRequests || rec.Body.String() != "rate limited" {
+		t.Fatalf("upload 121: expected 429 rate limited, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := upload("node-b"); rec.Code != http.StatusOK {
+		t.Fatalf("other node: expected 200, got %d", rec.Code)
+	}
+
+	// One minute after the first upload its slot frees up.
+	now = now.Add(time.Minute - 120*100*time.Millisecond + 100*time.Millisecond)
+	if rec := upload("node-a"); rec.Code != http.StatusOK {
+		t.Fatalf("next minute: expected 200, got %d", rec.Code)
+	}
+	if rec := upload("node-a"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("next minute second upload: expected 429, got %d", rec.Code)
+	}
+}
+
+func TestNodeUploadLimiterReadsConfigAndEvictsIdleNodes(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewNodeUploadLimits(120, stubNodeUploadStore{stubConfigReader: stubConfigReader{NodeUploadRateLimitConfigKey: "2"}})
+	l.now = func() time.Time { return now }
+
+	if !l.allow("node-a") || !l.allow("node-a") || l.allow("node-a") {
+		t.Fatalf("expected the configured limit of 2 per minute")
+	}
+	now = now.Add(4 * time.Minute)
+	l.allow("node-b")
+	now = now.Add(2 * time.Minute)
+	l.EvictIdle()
+	if _, ok := l.windows.Load("node-a"); ok {
+		t.Fatalf("expected node-a to be evicted after 5 idle minutes")
+	}
+	if _, ok := l.windows.Load("node-b"); !ok {
+		t.Fatalf("expected node-b to be kept")
+	}
+}
+
+func TestNodeUploadLimiterIgnoresUnknownSecrets(t *testing.T) {
+	l := NewNodeUploadLimits(120, stubNodeUploadStore{
+		stubConfigReader: stubConfigReader{NodeUploadRateLimitConfigKey: "1"},
+		nodes:            map[string]bool{"node-a": true},
+	})
+	h := l.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	upload := func(secret string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/flow/upload?secret="+secret, nil))
+		return rec.Code
+	}
+
+	if upload("node-a") != http.StatusOK || upload("node-a") != http.StatusTooManyRequests {
+		t.Fatalf("expected the registered node to be limited to 1 per minute")
+	}
+	for i := 0; i < 3; i++ {
+		if code := upload("guess"); code != http.StatusOK {
+			t.Fatalf("unknown secret %d: expected to reach the handler, got %d", i+1, code)
+		}
+	}
+	if _, ok := l.windows.Load("guess"); ok {
+		t.Fatalf("expected no window for an unknown secret")
+	}
+}
@@ -0,0 +1,131 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+const (
+	FieldCaseCamel = "camel"
+	FieldCaseSnake = "snake"
+)
+
+// ResponseOption adjusts how WriteJSON renders a payload.
+type ResponseOption func(*writeOptions)
+
+type writeOptions struct {
+	fieldCase string
+}
+
+// WithCase renders the payload's object keys in the given case. Keys are
+// camelCase by default; FieldCaseSnake turns userInfo into user_info.
+func WithCase(fieldCase string) ResponseOption {
+	return func(o *writeOptions) {
+		o.fieldCase = fieldCase
+	}
+}
+
+// caseWriter carries a field case down to WriteJSON for every response
+// written through it.
+type caseWriter struct {
+	http.ResponseWriter
+	fieldCase string
+}
+
+func (w *caseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *caseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WithFieldCase returns a writer that makes WriteJSON and NDJSONWriter use
+// fieldCase. The camelCase default leaves w untouched.
+func WithFieldCase(w http.ResponseWriter, fieldCase string) http.ResponseWriter {
+	if fieldCase != FieldCaseSnake {
+		return w
+	}
+	return &caseWriter{ResponseWriter: w, fieldCase: fieldCase}
+}
+
+// FieldCaseOf returns the field case carried by w, so middleware that
+// swaps the writer out can pass it on with WithFieldCase.
+func FieldCaseOf(w http.ResponseWriter) string {
+	if cw, ok := w.(*caseWriter); ok {
+		return cw.fieldCase
+	}
+	return FieldCaseCamel
+}
+
+// CamelToSnake returns a copy of m whose keys, including those of nested
+// maps and slices, are converted to snake_case.
+func CamelToSnake(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[snakeCase(k)] = snakeValue(v)
+	}
+	return out
+}
+
+func snakeValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return CamelToSnake(t)
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, item := range t {
+			out[i] = snakeValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// toSnakeJSON converts any JSON-encodable value, structs included, by
+// round-tripping it through its generic JSON form.
+func toSnakeJSON(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return snakeValue(generic), nil
+}
+
+// snakeCase converts a camelCase key. Acronyms stay together, so serverIP
+// becomes server_ip and userIDList becomes user_id_list.
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	b.Grow(len(s) + 4)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				prev := runes[i-1]
+				nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+					b.WriteByte('_')
+				}
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
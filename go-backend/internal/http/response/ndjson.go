@@ -0,0 +1,47 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const NDJSONContentType = "application/x-ndjson"
+
+// WantsNDJSON reports whether the client asked for a newline-delimited JSON
+// stream instead of a single envelope.
+func WantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), NDJSONContentType)
+}
+
+// NDJSONWriter writes one JSON value per line and flushes after each, so
+// large lists reach the client without being buffered in full.
+type NDJSONWriter struct {
+	w         http.ResponseWriter
+	enc       *json.Encoder
+	flusher   http.Flusher
+	fieldCase string
+}
+
+func NewNDJSONWriter(w http.ResponseWriter) *NDJSONWriter {
+	w.Header().Set("Content-Type", NDJSONContentType)
+	flusher, _ := w.(http.Flusher)
+	return &NDJSONWriter{w: w, enc: json.NewEncoder(w), flusher: flusher, fieldCase: FieldCaseOf(w)}
+}
+
+func (n *NDJSONWriter) Write(v interface{}) error {
+	if n.fieldCase == FieldCaseSnake {
+		converted, err := toSnakeJSON(v)
+		if err != nil {
+			return err
+		}
+		v = converted
+	}
+	if err := n.enc.Encode(v); err != nil {
+		return err
+	}
+	if n.flusher != nil {
+		n.flusher.Flush()
+	}
+	return nil
+}
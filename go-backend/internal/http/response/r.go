@@ -42,7 +42,16 @@ func ErrDefault(msg string) R {
 	return Err(-1, msg)
 }
 
-func WriteJSON(w http.ResponseWriter, payload R) {
+func WriteJSON(w http.ResponseWriter, payload R, opts ...ResponseOption) {
+	o := writeOptions{fieldCase: FieldCaseOf(w)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.fieldCase == FieldCaseSnake && payload.Data != nil {
+		if data, err := toSnakeJSON(payload.Data); err == nil {
+			payload.Data = data
+		}
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	_ = json.NewEncoder(w).Encode(payload)
 }
@@ -7,14 +7,15 @@ import (
 	"go-backend/internal/http/middleware"
 )
 
-func NewRouter(h *handler.Handler, jwtSecret string) http.Handler {
+func NewRouter(h *handler.Handler) http.Handler {
 	mux := http.NewServeMux()
 	h.Register(mux)
 	mux.Handle("/system-info", h.WebSocketHandler())
 
-	wrapped := middleware.Recover(mux)
-	wrapped = middleware.JWT(middleware.AuthOptions{JWTSecret: jwtSecret})(wrapped)
+	wrapped := middleware.Recover(middleware.NodeMTLS(h.Repo())(mux))
+	wrapped = middleware.CSRF(wrapped)
+	wrapped = middleware.IPBan(h.IPBans())(wrapped)
 	wrapped = middleware.RequestLog(wrapped)
-	wrapped = middleware.CORS(wrapped)
+	wrapped = middleware.CORS(h.Repo())(wrapped)
 	return wrapped
 }
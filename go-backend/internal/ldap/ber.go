@@ -0,0 +1,188 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER tags used by the subset of LDAPv3 (RFC 4511) this package speaks.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+
+	TagBindRequest       = 0x60
+	TagBindResponse      = 0x61
+	TagUnbindRequest     = 0x42
+	TagSearchRequest     = 0x63
+	TagSearchResultEntry = 0x64
+	TagSearchResultDone  = 0x65
+
+	tagSimpleAuth    = 0x80
+	tagEqualityMatch = 0xa3
+)
+
+const maxPacketSize = 1 << 20
+
+// Packet is one decoded BER element. Constructed elements keep their
+// children; primitive ones keep their raw value.
+type Packet struct {
+	Tag      byte
+	Value    []byte
+	Children []*Packet
+}
+
+func isConstructed(tag byte) bool {
+	return tag&0x20 != 0
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var buf []byte
+	for v := n; v > 0; v >>= 8 {
+		buf = append([]byte{byte(v)}, buf...)
+	}
+	return append([]byte{0x80 | byte(len(buf))}, buf...)
+}
+
+// Encode returns the element as a tag, length and contents.
+func Encode(tag byte, contents ...[]byte) []byte {
+	var body []byte
+	for _, c := range contents {
+		body = append(body, c...)
+	}
+	out := append([]byte{tag}, encodeLength(len(body))...)
+	return append(out, body...)
+}
+
+func encodeInt(tag byte, v int) []byte {
+	if v == 0 {
+		return Encode(tag, []byte{0})
+	}
+	var buf []byte
+	for n := v; n > 0; n >>= 8 {
+		buf = append([]byte{byte(n)}, buf...)
+	}
+	if buf[0]&0x80 != 0 {
+		buf = append([]byte{0}, buf...)
+	}
+	return Encode(tag, buf)
+}
+
+// Integer, Enumerated, OctetString and Boolean encode the primitive types.
+func Integer(v int) []byte        { return encodeInt(tagInteger, v) }
+func Enumerated(v int) []byte     { return encodeInt(tagEnumerated, v) }
+func OctetString(s string) []byte { return Encode(tagOctetString, []byte(s)) }
+func Sequence(children ...[]byte) []byte {
+	return Encode(tagSequence, children...)
+}
+func Boolean(v bool) []byte {
+	if v {
+		return Encode(tagBoolean, []byte{0xff})
+	}
+	return Encode(tagBoolean, []byte{0})
+}
+
+// Message wraps a protocol operation in an LDAPMessage envelope.
+func Message(id int, op []byte) []byte {
+	return Sequence(Integer(id), op)
+}
+
+// ReadPacket reads one complete BER element from r.
+func ReadPacket(r *bufio.Reader) (*Packet, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, errors.New("ldap: unsupported length encoding")
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("ldap: packet of %d bytes too large", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return parseElement(tag, body)
+}
+
+func parseElement(tag byte, body []byte) (*Packet, error) {
+	p := &Packet{Tag: tag, Value: body}
+	if !isConstructed(tag) {
+		return p, nil
+	}
+	rest := body
+	for len(rest) > 0 {
+		child, n, err := parseChild(rest)
+		if err != nil {
+			return nil, err
+		}
+		p.Children = append(p.Children, child)
+		rest = rest[n:]
+	}
+	return p, nil
+}
+
+func parseChild(buf []byte) (*Packet, int, error) {
+	if len(buf) < 2 {
+		return nil, 0, errors.New("ldap: truncated element")
+	}
+	tag, first := buf[0], buf[1]
+	offset, length := 2, int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 || len(buf) < 2+n {
+			return nil, 0, errors.New("ldap: unsupported length encoding")
+		}
+		length = 0
+		for _, b := range buf[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		offset += n
+	}
+	if length < 0 || len(buf) < offset+length {
+		return nil, 0, errors.New("ldap: truncated element")
+	}
+	p, err := parseElement(tag, buf[offset:offset+length])
+	if err != nil {
+		return nil, 0, err
+	}
+	return p, offset + length, nil
+}
+
+// Int decodes an INTEGER or ENUMERATED value.
+func (p *Packet) Int() int {
+	v := 0
+	for _, b := range p.Value {
+		v = v<<8 | int(b)
+	}
+	return v
+}
+
+// String returns the raw value of a primitive element.
+func (p *Packet) String() string {
+	return string(p.Value)
+}
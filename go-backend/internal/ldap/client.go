@@ -0,0 +1,258 @@
+// Package ldap is a minimal LDAPv3 client: simple bind and equality
+// searches, which is all the panel needs to verify passwords and read group
+// membership.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Result codes from RFC 4511 section 4.1.9.
+const (
+	ResultSuccess            = 0
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+)
+
+// Search scopes.
+const (
+	ScopeBaseObject   = 0
+	ScopeWholeSubtree = 2
+)
+
+var (
+	ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+	// ErrEmptyPassword is returned instead of attempting the bind: a simple
+	// bind with an empty password is an unauthenticated bind and succeeds.
+	ErrEmptyPassword = errors.New("ldap: empty password")
+)
+
+// ResultError is a non-success LDAPResult.
+type ResultError struct {
+	Code    int
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Entry is one search result.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Get returns the first value of attr, matched case-insensitively.
+func (e *Entry) Get(attr string) string {
+	for name, values := range e.Attributes {
+		if strings.EqualFold(name, attr) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// Conn is a single LDAP connection. It is not safe for concurrent use.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+}
+
+// Dial connects to an ldap:// or ldaps:// URL. The context deadline, if
+// any, applies to the whole conversation, not just the dial.
+func Dial(ctx context.Context, rawURL string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid url: %w", err)
+	}
+	host := u.Host
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	switch strings.ToLower(u.Scheme) {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: u.Hostname()}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+	return &Conn{conn: conn, reader: bufio.NewReader(conn), nextID: 1}, nil
+}
+
+// Close sends an unbind request and closes the connection.
+func (c *Conn) Close() error {
+	_, _ = c.conn.Write(Message(c.nextID, Encode(TagUnbindRequest)))
+	return c.conn.Close()
+}
+
+func (c *Conn) send(op []byte) (int, error) {
+	id := c.nextID
+	c.nextID++
+	_, err := c.conn.Write(Message(id, op))
+	return id, err
+}
+
+// read returns the protocol op of the next message for id.
+func (c *Conn) read(id int) (*Packet, error) {
+	for {
+		msg, err := ReadPacket(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Tag != tagSequence || len(msg.Children) < 2 {
+			return nil, errors.New("ldap: malformed message")
+		}
+		if msg.Children[0].Int() != id {
+			continue
+		}
+		return msg.Children[1], nil
+	}
+}
+
+func resultError(op *Packet) error {
+	if len(op.Children) < 3 {
+		return errors.New("ldap: malformed result")
+	}
+	code := op.Children[0].Int()
+	if code == ResultSuccess {
+		return nil
+	}
+	if code == ResultInvalidCredentials {
+		return ErrInvalidCredentials
+	}
+	return &ResultError{Code: code, Message: op.Children[2].String()}
+}
+
+// Bind performs a simple bind.
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return ErrEmptyPassword
+	}
+	id, err := c.send(Encode(TagBindRequest,
+		Integer(3),
+		OctetString(dn),
+		Encode(tagSimpleAuth, []byte(password)),
+	))
+	if err != nil {
+		return err
+	}
+	op, err := c.read(id)
+	if err != nil {
+		return err
+	}
+	if op.Tag != TagBindResponse {
+		return errors.New("ldap: unexpected bind response")
+	}
+	return resultError(op)
+}
+
+// Search returns the entries under baseDN whose attr equals value. A base
+// that does not exist yields no entries rather than an error.
+func (c *Conn) Search(baseDN string, scope int, attr, value string, attributes ...string) ([]Entry, error) {
+	attrList := make([][]byte, 0, len(attributes))
+	for _, a := range attributes {
+		attrList = append(attrList, OctetString(a))
+	}
+	id, err := c.send(Encode(TagSearchRequest,
+		OctetString(baseDN),
+		Enumerated(scope),
+		Enumerated(0), // neverDerefAliases
+		Integer(0),
+		Integer(0),
+		Boolean(false),
+		Encode(tagEqualityMatch, OctetString(attr), OctetString(value)),
+		Sequence(attrList...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for {
+		op, err := c.read(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Tag {
+		case TagSearchResultEntry:
+			entries = append(entries, parseEntry(op))
+		case TagSearchResultDone:
+			err := resultError(op)
+			var resErr *ResultError
+			if errors.As(err, &resErr) && resErr.Code == ResultNoSuchObject {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return entries, nil
+		}
+	}
+}
+
+func parseEntry(op *Packet) Entry {
+	entry := Entry{Attributes: make(map[string][]string)}
+	if len(op.Children) > 0 {
+		entry.DN = op.Children[0].String()
+	}
+	if len(op.Children) < 2 {
+		return entry
+	}
+	for _, attr := range op.Children[1].Children {
+		if len(attr.Children) < 2 {
+			continue
+		}
+		name := attr.Children[0].String()
+		for _, v := range attr.Children[1].Children {
+			entry.Attributes[name] = append(entry.Attributes[name], v.String())
+		}
+	}
+	return entry
+}
+
+// EscapeDN escapes a value for use inside a distinguished name (RFC 4514).
+func EscapeDN(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			(r == ' ' || r == '#') && i == 0,
+			r == ' ' && i == len(value)-1:
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package ldap_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go-backend/internal/ldap"
+	"go-backend/internal/ldap/ldaptest"
+)
+
+func TestBindAndSearch(t *testing.T) {
+	// A long attribute value forces multi-byte BER lengths.
+	long := strings.Repeat("x", 300)
+	server := ldaptest.NewServer(
+		ldaptest.Entry{DN: "dc=example,dc=org"},
+		ldaptest.Entry{
+			DN:         "uid=alice,dc=example,dc=org",
+			Password:   "secret",
+			Attributes: map[string][]string{"uid": {"alice"}, "description": {long}},
+		},
+	)
+	defer server.Close()
+
+	conn, err := ldap.Dial(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Bind("uid=alice,dc=example,dc=org", "wrong"); !errors.Is(err, ldap.ErrInvalidCredentials) {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+	if err := conn.Bind("uid=alice,dc=example,dc=org", ""); !errors.Is(err, ldap.ErrEmptyPassword) {
+		t.Fatalf("expected empty password to be refused, got %v", err)
+	}
+	if err := conn.Bind("uid=alice,dc=example,dc=org", "secret"); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+
+	entries, err := conn.Search("dc=example,dc=org", ldap.ScopeWholeSubtree, "uid", "alice")
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(entries) != 1 || entries[0].DN != "uid=alice,dc=example,dc=org" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if got := entries[0].Get("Description"); got != long {
+		t.Fatalf("expected long attribute to round-trip, got %d bytes", len(got))
+	}
+
+	entries, err = conn.Search("ou=missing,dc=example,dc=org", ldap.ScopeBaseObject, "uid", "alice")
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected missing base to yield nothing, got %v, %v", entries, err)
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	cases := map[string]string{
+		"alice":         "alice",
+		"a,b":           `a\,b`,
+		"x=y+z":         `x\=y\+z`,
+		" lead":         `\ lead`,
+		"#hash":         `\#hash`,
+		"trail ":        `trail\ `,
+		`back\slash"q"`: `back\\slash\"q\"`,
+	}
+	for in, want := range cases {
+		if got := ldap.EscapeDN(in); got != want {
+			t.Errorf("EscapeDN(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
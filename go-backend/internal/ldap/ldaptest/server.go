@@ -0,0 +1,160 @@
+// Package ldaptest provides an in-memory LDAP server for tests. It answers
+// simple binds and equality searches against a fixed set of entries.
+package ldaptest
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+
+	"go-backend/internal/ldap"
+)
+
+// Entry is one directory object. Password is the simple bind password; an
+// empty Password means the entry cannot bind.
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server is a running fake directory.
+type Server struct {
+	URL string
+
+	listener net.Listener
+	entries  []Entry
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server on a loopback port.
+func NewServer(entries ...Entry) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("ldaptest: " + err.Error())
+	}
+	s := &Server{URL: "ldap://" + l.Addr().String(), listener: l, entries: entries}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Close stops the server and waits for open connections to finish.
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	bound := false
+	for {
+		msg, err := ldap.ReadPacket(reader)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+		id := msg.Children[0].Int()
+		op := msg.Children[1]
+		switch op.Tag {
+		case ldap.TagBindRequest:
+			code := ldap.ResultInvalidCredentials
+			if len(op.Children) >= 3 {
+				if entry := s.find(op.Children[1].String()); entry != nil && entry.Password != "" && entry.Password == op.Children[2].String() {
+					code = ldap.ResultSuccess
+				}
+			}
+			bound = code == ldap.ResultSuccess
+			_, _ = conn.Write(ldap.Message(id, result(ldap.TagBindResponse, code)))
+		case ldap.TagSearchRequest:
+			if !bound {
+				_, _ = conn.Write(ldap.Message(id, result(ldap.TagSearchResultDone, 50)))
+				continue
+			}
+			s.search(conn, id, op)
+		case ldap.TagUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *Server) search(conn net.Conn, id int, op *ldap.Packet) {
+	if len(op.Children) < 7 || len(op.Children[6].Children) < 2 {
+		_, _ = conn.Write(ldap.Message(id, result(ldap.TagSearchResultDone, 2)))
+		return
+	}
+	base := op.Children[0].String()
+	scope := op.Children[1].Int()
+	attr := op.Children[6].Children[0].String()
+	value := op.Children[6].Children[1].String()
+
+	if s.find(base) == nil {
+		_, _ = conn.Write(ldap.Message(id, result(ldap.TagSearchResultDone, ldap.ResultNoSuchObject)))
+		return
+	}
+	for _, entry := range s.entries {
+		inScope := strings.EqualFold(entry.DN, base)
+		if scope != ldap.ScopeBaseObject {
+			inScope = inScope || strings.HasSuffix(strings.ToLower(entry.DN), ","+strings.ToLower(base))
+		}
+		if !inScope || !matches(entry, attr, value) {
+			continue
+		}
+		var attrs [][]byte
+		for name, values := range entry.Attributes {
+			var vals [][]byte
+			for _, v := range values {
+				vals = append(vals, ldap.OctetString(v))
+			}
+			attrs = append(attrs, ldap.Sequence(ldap.OctetString(name), ldap.Encode(0x31, vals...)))
+		}
+		_, _ = conn.Write(ldap.Message(id, ldap.Encode(ldap.TagSearchResultEntry,
+			ldap.OctetString(entry.DN),
+			ldap.Sequence(attrs...),
+		)))
+	}
+	_, _ = conn.Write(ldap.Message(id, result(ldap.TagSearchResultDone, ldap.ResultSuccess)))
+}
+
+func (s *Server) find(dn string) *Entry {
+	for i := range s.entries {
+		if strings.EqualFold(s.entries[i].DN, dn) {
+			return &s.entries[i]
+		}
+	}
+	return nil
+}
+
+func matches(entry Entry, attr, value string) bool {
+	for name, values := range entry.Attributes {
+		if !strings.EqualFold(name, attr) {
+			continue
+		}
+		for _, v := range values {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func result(tag byte, code int) []byte {
+	return ldap.Encode(tag, ldap.Enumerated(code), ldap.OctetString(""), ldap.OctetString(""))
+}
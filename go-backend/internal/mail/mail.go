@@ -0,0 +1,134 @@
+// Package mail sends plain text notification mail through an SMTP relay.
+// Port 465 is spoken over implicit TLS; any other port upgrades with
+// STARTTLS when the server offers it.
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const implicitTLSPort = 465
+
+var (
+	ErrNotConfigured = errors.New("mail: smtp not configured")
+	ErrInvalidHeader = errors.New("mail: header contains a line break")
+)
+
+// Config is the relay the panel sends through. Username may be empty for
+// relays that accept unauthenticated mail.
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// InsecureSkipVerify disables certificate checks, for relays with a
+	// self-signed certificate.
+	InsecureSkipVerify bool
+}
+
+// Message is a single plain text mail.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// ValidAddress reports whether addr is a single bare mail address.
+func ValidAddress(addr string) bool {
+	parsed, err := mail.ParseAddress(addr)
+	return err == nil && parsed.Address == addr
+}
+
+// Send delivers msg. ctx bounds the whole SMTP exchange.
+func Send(ctx context.Context, cfg Config, msg Message) error {
+	if strings.TrimSpace(cfg.Host) == "" || strings.TrimSpace(cfg.From) == "" {
+		return ErrNotConfigured
+	}
+	if strings.ContainsAny(msg.To+msg.Subject+cfg.From, "\r\n") {
+		return ErrInvalidHeader
+	}
+	if !ValidAddress(msg.To) {
+		return fmt.Errorf("mail: invalid recipient %q", msg.To)
+	}
+	port := cfg.Port
+	if port <= 0 {
+		port = 587
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: cfg.Host, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if port == implicitTLSPort {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if port != implicitTLSPort {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(cfg.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(compose(cfg.From, msg, time.Now())); err != nil {
+		_ = w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func compose(from string, msg Message, now time.Time) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + now.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
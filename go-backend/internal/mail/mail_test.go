@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go-backend/internal/mail/mailtest"
+)
+
+func TestSendDeliversPlainTextMessage(t *testing.T) {
+	srv := mailtest.NewServer()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cfg := Config{Host: srv.Host, Port: srv.Port, Username: "panel", Password: "relay-pass", From: "panel@example.com"}
+	err := Send(ctx, cfg, Message{To: "user@example.com", Subject: "密码重置", Body: "line one\n.line two"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected one message, got %d", len(msgs))
+	}
+	got := msgs[0]
+	if got.From != "panel@example.com" || len(got.To) != 1 || got.To[0] != "user@example.com" {
+		t.Fatalf("unexpected envelope %+v", got)
+	}
+	if !strings.Contains(got.Data, "Subject: =?UTF-8?b?") {
+		t.Fatalf("expected an encoded subject, got %q", got.Data)
+	}
+	if !strings.Contains(got.Data, "\r\n\r\nline one\r\n.line two\r\n") {
+		t.Fatalf("expected the body with CRLF line endings, got %q", got.Data)
+	}
+}
+
+func TestSendRejectsBadInput(t *testing.T) {
+	ctx := context.Background()
+	if err := Send(ctx, Config{}, Message{To: "user@example.com"}); !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("expected ErrNotConfigured, got %v", err)
+	}
+	cfg := Config{Host: "127.0.0.1", Port: 1, From: "panel@example.com"}
+	if err := Send(ctx, cfg, Message{To: "user@example.com", Subject: "hi\r\nBcc: victim@example.com"}); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected ErrInvalidHeader, got %v", err)
+	}
+	if err := Send(ctx, cfg, Message{To: "Someone <user@example.com>"}); err == nil {
+		t.Fatalf("expected a display-name recipient to be rejected")
+	}
+}
@@ -0,0 +1,144 @@
+// Package mailtest provides an in-memory SMTP server for tests. It accepts
+// every message, without TLS, and keeps it for inspection.
+package mailtest
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Message is one accepted mail. Data is the raw content after DATA,
+// dot-unstuffed, with CRLF line endings.
+type Message struct {
+	From string
+	To   []string
+	Data string
+}
+
+// Server is a running fake relay.
+type Server struct {
+	Host string
+	Port int
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewServer starts a server on a loopback port.
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("mailtest: " + err.Error())
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	s := &Server{Host: "127.0.0.1", Port: addr.Port, listener: l}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Addr is the host:port the server listens on.
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// Messages returns the mail accepted so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Close stops the server and waits for open connections to finish.
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) bool {
+		_, err := conn.Write([]byte(line + "\r\n"))
+		return err == nil
+	}
+	if !reply("220 mailtest ready") {
+		return
+	}
+	var msg Message
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(verb, "EHLO"):
+			reply("250-mailtest")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(verb, "HELO"):
+			reply("250 mailtest")
+		case strings.HasPrefix(verb, "AUTH"):
+			reply("235 authenticated")
+		case strings.HasPrefix(verb, "MAIL FROM:"):
+			msg = Message{From: trimPath(line[len("MAIL FROM:"):])}
+			reply("250 ok")
+		case strings.HasPrefix(verb, "RCPT TO:"):
+			msg.To = append(msg.To, trimPath(line[len("RCPT TO:"):]))
+			reply("250 ok")
+		case verb == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.Data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case verb == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func trimPath(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[:i]
+	}
+	return strings.Trim(s, "<>")
+}
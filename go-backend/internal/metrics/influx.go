@@ -0,0 +1,118 @@
+// Package metrics ships panel statistics to external monitoring systems.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-backend/internal/store/sqlite"
+)
+
+const (
+	InfluxEnabledConfigKey   = "influx_enabled"
+	InfluxWriteURLConfigKey  = "influx_write_url"
+	InfluxAuthTokenConfigKey = "influx_auth_token"
+
+	influxWriteTimeout = 10 * time.Second
+)
+
+// ConfigReader looks up panel config values by name.
+type ConfigReader interface {
+	GetConfigByName(name string) (*sqlite.ViteConfig, error)
+}
+
+// FlowSample is the traffic one forward reported in a single flow upload.
+type FlowSample struct {
+	ForwardID int64
+	UserID    int64
+	Upload    int64
+	Download  int64
+}
+
+// InfluxExporter writes flow samples to an InfluxDB-compatible write
+// endpoint (InfluxDB, VictoriaMetrics) as line protocol. It is off unless
+// influx_enabled is "true" and influx_write_url is set; influx_auth_token,
+// when present, is sent as "Authorization: Token <value>".
+type InfluxExporter struct {
+	repo   ConfigReader
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewInfluxExporter(repo ConfigReader) *InfluxExporter {
+	return &InfluxExporter{
+		repo:   repo,
+		client: &http.Client{Timeout: influxWriteTimeout},
+		now:    time.Now,
+	}
+}
+
+// Export posts samples to the configured write URL. It is meant to run in
+// its own goroutine: failures are logged, never returned, so they cannot
+// affect flow accounting.
+func (e *InfluxExporter) Export(samples []FlowSample) {
+	if e == nil || len(samples) == 0 {
+		return
+	}
+	if !strings.EqualFold(e.config(InfluxEnabledConfigKey), "true") {
+		return
+	}
+	writeURL := e.config(InfluxWriteURLConfigKey)
+	if writeURL == "" {
+		return
+	}
+	body := FormatLineProtocol(samples, e.now())
+	if body == "" {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, writeURL, strings.NewReader(body))
+	if err != nil {
+		log.Printf("influx export: build request failed: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if token := e.config(InfluxAuthTokenConfigKey); token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+	res, err := e.client.Do(req)
+	if err != nil {
+		log.Printf("influx export: write %d samples failed: %v", len(samples), err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		log.Printf("influx export: write returned %d: %s", res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+}
+
+func (e *InfluxExporter) config(name string) string {
+	if e.repo == nil {
+		return ""
+	}
+	cfg, err := e.repo.GetConfigByName(name)
+	if err != nil || cfg == nil {
+		return ""
+	}
+	return strings.TrimSpace(cfg.Value)
+}
+
+// FormatLineProtocol renders one flvx_flow point per sample, all stamped
+// with ts:
+//
+//	flvx_flow,forward_id=7,user_id=2 upload=100,download=200 1700000000000000000
+func FormatLineProtocol(samples []FlowSample, ts time.Time) string {
+	var buf bytes.Buffer
+	stamp := strconv.FormatInt(ts.UnixNano(), 10)
+	for _, s := range samples {
+		fmt.Fprintf(&buf, "flvx_flow,forward_id=%d,user_id=%d upload=%d,download=%d %s\n", s.ForwardID, s.UserID, s.Upload, s.Download, stamp)
+	}
+	return buf.String()
+}
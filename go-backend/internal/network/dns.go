@@ -0,0 +1,36 @@
+// Package network holds address helpers shared by the panel handlers.
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ValidateDNSAddress checks that addr is a resolver address in the form
+// ip:port or [ipv6]:port. Hostnames are rejected because the node has to be
+// able to reach the resolver without resolving anything first.
+func ValidateDNSAddress(addr string) error {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return errors.New("dns address is empty")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid dns address %q: %w", addr, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid dns address %q: host must be an IP address", addr)
+	}
+	if ip.To4() == nil && !strings.HasPrefix(addr, "[") {
+		return fmt.Errorf("invalid dns address %q: IPv6 must be bracketed", addr)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return fmt.Errorf("invalid dns address %q: port out of range", addr)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package network
+
+import "fmt"
+
+// ValidateDSCP checks that v fits the 6-bit DSCP field. Zero means the
+// traffic is left unmarked.
+func ValidateDSCP(v int) error {
+	if v < 0 || v > 63 {
+		return fmt.Errorf("invalid dscp mark %d: must be between 0 and 63", v)
+	}
+	return nil
+}
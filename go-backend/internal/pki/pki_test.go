@@ -0,0 +1,64 @@
+package pki_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"go-backend/internal/pki"
+)
+
+func TestIssuedCertificatesChainToCA(t *testing.T) {
+	now := time.Now()
+	certPEM, keyPEM, err := pki.NewCA("test CA", now)
+	if err != nil {
+		t.Fatalf("new CA: %v", err)
+	}
+	ca, err := pki.ParseCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("parse CA: %v", err)
+	}
+
+	issued, err := ca.IssueClient("node-1", time.Hour, now)
+	if err != nil {
+		t.Fatalf("issue client: %v", err)
+	}
+	block, _ := pem.Decode(issued.CertPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse client cert: %v", err)
+	}
+	if cert.Subject.CommonName != "node-1" || pki.SerialString(cert.SerialNumber) != issued.Serial {
+		t.Fatalf("unexpected client cert %v serial %s", cert.Subject, issued.Serial)
+	}
+	opts := x509.VerifyOptions{Roots: ca.Pool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if _, err := cert.Verify(opts); err != nil {
+		t.Fatalf("verify client cert: %v", err)
+	}
+	opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if _, err := cert.Verify(opts); err == nil {
+		t.Fatalf("expected a client cert not to verify as a server cert")
+	}
+
+	server, err := ca.IssueServer([]string{"panel.example.com", "10.0.0.1"}, time.Hour, now)
+	if err != nil {
+		t.Fatalf("issue server: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(server.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse server cert: %v", err)
+	}
+	if err := leaf.VerifyHostname("10.0.0.1"); err != nil {
+		t.Fatalf("verify server ip: %v", err)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: ca.Pool(), DNSName: "panel.example.com"}); err != nil {
+		t.Fatalf("verify server cert: %v", err)
+	}
+
+	otherCert, otherKey, _ := pki.NewCA("other CA", now)
+	other, _ := pki.ParseCA(otherCert, otherKey)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: other.Pool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err == nil {
+		t.Fatalf("expected a foreign CA not to verify the cert")
+	}
+}
@@ -0,0 +1,152 @@
+// Package secrets loads sensitive settings (the JWT secret, the data master
+// key, SMTP credentials) from outside the config table: the environment, a
+// directory of mounted files, or a HashiCorp Vault KV version 2 secret.
+//
+// Secrets are named in lower snake case, e.g. "jwt_secret". The env
+// provider looks them up upper-cased ("JWT_SECRET"), the file provider as a
+// file of the same name in its directory, and the Vault provider as a key of
+// the configured secret.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Well-known secret names.
+const (
+	JWTSecret     = "jwt_secret"
+	DataMasterKey = "data_master_key"
+	SMTPUsername  = "smtp_username"
+	SMTPPassword  = "smtp_password"
+)
+
+// ErrNotFound means the provider has no value for the name. Callers fall
+// back to the next source.
+var ErrNotFound = errors.New("secrets: not found")
+
+// Provider returns the value of a named secret.
+type Provider interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// Env reads secrets from environment variables. Prefix is prepended to the
+// upper-cased name.
+type Env struct {
+	Prefix string
+}
+
+func (e Env) Get(_ context.Context, name string) (string, error) {
+	if v := os.Getenv(e.Prefix + strings.ToUpper(name)); v != "" {
+		return v, nil
+	}
+	return "", ErrNotFound
+}
+
+// File reads each secret from a file named after it in Dir, as Docker and
+// Kubernetes mount them. Surrounding whitespace, including the trailing
+// newline most editors add, is dropped.
+type File struct {
+	Dir string
+}
+
+func (f File) Get(_ context.Context, name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("secrets: invalid name %q", name)
+	}
+	raw, err := os.ReadFile(filepath.Join(f.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(string(raw))
+	if v == "" {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+// Chain asks each provider in turn and returns the first value found.
+type Chain []Provider
+
+func (c Chain) Get(ctx context.Context, name string) (string, error) {
+	for _, p := range c {
+		v, err := p.Get(ctx, name)
+		if err == nil {
+			return v, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+	}
+	return "", ErrNotFound
+}
+
+// Lookup returns the value of name, or fallback when p is nil or has no
+// value for it. Other errors are returned.
+func Lookup(ctx context.Context, p Provider, name, fallback string) (string, error) {
+	if p == nil {
+		return fallback, nil
+	}
+	v, err := p.Get(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		return fallback, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// FromEnv builds the provider selected by SECRETS_PROVIDER:
+//
+//   - "env" (the default): environment variables only.
+//   - "file": files in SECRETS_DIR (default /run/secrets), then the
+//     environment.
+//   - "vault": the KV version 2 secret VAULT_SECRET_PATH in the mount
+//     VAULT_KV_MOUNT (default "secret") on VAULT_ADDR, authenticated with
+//     VAULT_TOKEN or the token in VAULT_TOKEN_FILE, then the environment.
+//     VAULT_NAMESPACE is sent when set.
+func FromEnv() (Provider, error) {
+	env := Env{}
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("SECRETS_PROVIDER"))); kind {
+	case "", "env":
+		return env, nil
+	case "file":
+		dir := strings.TrimSpace(os.Getenv("SECRETS_DIR"))
+		if dir == "" {
+			dir = "/run/secrets"
+		}
+		return Chain{File{Dir: dir}, env}, nil
+	case "vault":
+		token := strings.TrimSpace(os.Getenv("VAULT_TOKEN"))
+		if path := strings.TrimSpace(os.Getenv("VAULT_TOKEN_FILE")); token == "" && path != "" {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("secrets: read VAULT_TOKEN_FILE: %w", err)
+			}
+			token = strings.TrimSpace(string(raw))
+		}
+		vault, err := NewVault(VaultConfig{
+			Addr:      os.Getenv("VAULT_ADDR"),
+			Token:     token,
+			Mount:     os.Getenv("VAULT_KV_MOUNT"),
+			Path:      os.Getenv("VAULT_SECRET_PATH"),
+			Namespace: os.Getenv("VAULT_NAMESPACE"),
+			Timeout:   10 * time.Second,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return Chain{vault, env}, nil
+	default:
+		return nil, fmt.Errorf("secrets: unknown SECRETS_PROVIDER %q", kind)
+	}
+}
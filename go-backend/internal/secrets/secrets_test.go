@@ -0,0 +1,113 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFileProviderReadsMountedSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, JWTSecret), []byte("from-file\n"), 0o600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+	p := File{Dir: dir}
+	ctx := context.Background()
+	if v, err := p.Get(ctx, JWTSecret); err != nil || v != "from-file" {
+		t.Fatalf("expected the trimmed file content, got %q, %v", v, err)
+	}
+	if _, err := p.Get(ctx, SMTPPassword); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a missing file, got %v", err)
+	}
+	if _, err := p.Get(ctx, "../etc/passwd"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a path outside the directory to be refused, got %v", err)
+	}
+}
+
+func TestChainFallsBackToEnvironment(t *testing.T) {
+	t.Setenv("DATA_MASTER_KEY", "from-env")
+	t.Setenv("JWT_SECRET", "env-jwt")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, JWTSecret), []byte("file-jwt"), 0o600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+	p := Chain{File{Dir: dir}, Env{}}
+	ctx := context.Background()
+	if v, _ := Lookup(ctx, p, JWTSecret, "fallback"); v != "file-jwt" {
+		t.Fatalf("expected the file to win, got %q", v)
+	}
+	if v, _ := Lookup(ctx, p, DataMasterKey, "fallback"); v != "from-env" {
+		t.Fatalf("expected the environment value, got %q", v)
+	}
+	if v, _ := Lookup(ctx, p, SMTPUsername, "fallback"); v != "fallback" {
+		t.Fatalf("expected the fallback, got %q", v)
+	}
+	if v, _ := Lookup(ctx, nil, SMTPUsername, "fallback"); v != "fallback" {
+		t.Fatalf("expected a nil provider to give the fallback, got %q", v)
+	}
+}
+
+func TestVaultProviderReadsKVv2Secret(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path != "/v1/kv/data/flvx/panel" || r.Header.Get("X-Vault-Token") != "vault-token" || r.Header.Get("X-Vault-Namespace") != "ops" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"jwt_secret":"vault-jwt","smtp_password":"relay-pass","port":25},"metadata":{"version":3}}}`))
+	}))
+	defer srv.Close()
+
+	v, err := NewVault(VaultConfig{Addr: srv.URL + "/", Token: "vault-token", Mount: "kv", Path: "/flvx/panel", Namespace: "ops"})
+	if err != nil {
+		t.Fatalf("new vault: %v", err)
+	}
+	ctx := context.Background()
+	if got, err := v.Get(ctx, JWTSecret); err != nil || got != "vault-jwt" {
+		t.Fatalf("expected vault-jwt, got %q, %v", got, err)
+	}
+	if got, err := v.Get(ctx, SMTPPassword); err != nil || got != "relay-pass" {
+		t.Fatalf("expected relay-pass, got %q, %v", got, err)
+	}
+	if _, err := v.Get(ctx, "port"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a non-string value to be skipped, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected one request for all names, got %d", n)
+	}
+
+	denied, err := NewVault(VaultConfig{Addr: srv.URL, Token: "wrong", Path: "flvx/panel"})
+	if err != nil {
+		t.Fatalf("new vault: %v", err)
+	}
+	if _, err := Lookup(ctx, denied, JWTSecret, "fallback"); err == nil {
+		t.Fatalf("expected a vault error to be reported, not hidden behind the fallback")
+	}
+}
+
+func TestFromEnvSelectsProvider(t *testing.T) {
+	t.Setenv("SECRETS_PROVIDER", "vault")
+	t.Setenv("VAULT_ADDR", "")
+	if _, err := FromEnv(); err == nil {
+		t.Fatalf("expected vault without VAULT_ADDR to be refused")
+	}
+	t.Setenv("SECRETS_PROVIDER", "keychain")
+	if _, err := FromEnv(); err == nil {
+		t.Fatalf("expected an unknown provider to be refused")
+	}
+	t.Setenv("SECRETS_PROVIDER", "file")
+	t.Setenv("SECRETS_DIR", t.TempDir())
+	p, err := FromEnv()
+	if err != nil {
+		t.Fatalf("file provider: %v", err)
+	}
+	if _, ok := p.(Chain); !ok {
+		t.Fatalf("expected the file provider to fall back to the environment, got %T", p)
+	}
+}
@@ -0,0 +1,130 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultCacheTTL is how long a fetched secret is reused. Values rotated in
+// Vault are picked up after at most this long; startup reads several names
+// with one request.
+const vaultCacheTTL = 5 * time.Minute
+
+// VaultConfig locates one KV version 2 secret whose keys are the secret
+// names.
+type VaultConfig struct {
+	Addr      string
+	Token     string
+	Mount     string // defaults to "secret"
+	Path      string
+	Namespace string
+	Timeout   time.Duration
+	// Client overrides the HTTP client, e.g. for a private CA.
+	Client *http.Client
+}
+
+// Vault reads secrets from HashiCorp Vault.
+type Vault struct {
+	cfg    VaultConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	values    map[string]string
+	fetchedAt time.Time
+}
+
+func NewVault(cfg VaultConfig) (*Vault, error) {
+	cfg.Addr = strings.TrimRight(strings.TrimSpace(cfg.Addr), "/")
+	cfg.Path = strings.Trim(strings.TrimSpace(cfg.Path), "/")
+	cfg.Mount = strings.Trim(strings.TrimSpace(cfg.Mount), "/")
+	if cfg.Mount == "" {
+		cfg.Mount = "secret"
+	}
+	if cfg.Addr == "" || cfg.Path == "" {
+		return nil, errors.New("secrets: VAULT_ADDR and VAULT_SECRET_PATH are required")
+	}
+	if strings.TrimSpace(cfg.Token) == "" {
+		return nil, errors.New("secrets: VAULT_TOKEN or VAULT_TOKEN_FILE is required")
+	}
+	if _, err := url.Parse(cfg.Addr); err != nil {
+		return nil, fmt.Errorf("secrets: invalid VAULT_ADDR: %w", err)
+	}
+	client := cfg.Client
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	return &Vault{cfg: cfg, client: client}, nil
+}
+
+func (v *Vault) Get(ctx context.Context, name string) (string, error) {
+	values, err := v.load(ctx)
+	if err != nil {
+		return "", err
+	}
+	if value := values[name]; value != "" {
+		return value, nil
+	}
+	return "", ErrNotFound
+}
+
+func (v *Vault) load(ctx context.Context) (map[string]string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.values != nil && time.Since(v.fetchedAt) < vaultCacheTTL {
+		return v.values, nil
+	}
+	values, err := v.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v.values, v.fetchedAt = values, time.Now()
+	return values, nil
+}
+
+func (v *Vault) fetch(ctx context.Context) (map[string]string, error) {
+	endpoint := v.cfg.Addr + "/v1/" + v.cfg.Mount + "/data/" + v.cfg.Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.cfg.Token)
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("secrets: vault answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var out struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("secrets: vault response: %w", err)
+	}
+	values := make(map[string]string, len(out.Data.Data))
+	for k, raw := range out.Data.Data {
+		if s, ok := raw.(string); ok {
+			values[k] = s
+		}
+	}
+	return values, nil
+}
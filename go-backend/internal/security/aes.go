@@ -21,7 +21,15 @@ func NewAESCrypto(secret string) (*AESCrypto, error) {
 	return &AESCrypto{key: hash[:]}, nil
 }
 
+// Encrypt seals plain with AES-256-GCM under a random nonce and returns
+// base64(nonce || ciphertext || tag).
 func (a *AESCrypto) Encrypt(plain []byte) (string, error) {
+	return a.EncryptWithAAD(plain, nil)
+}
+
+// EncryptWithAAD is Encrypt that also authenticates aad. aad is not part of
+// the output; DecryptWithAAD must be given the same bytes.
+func (a *AESCrypto) EncryptWithAAD(plain, aad []byte) (string, error) {
 	if len(plain) == 0 {
 		return "", fmt.Errorf("empty plaintext")
 	}
@@ -37,12 +45,18 @@ func (a *AESCrypto) Encrypt(plain []byte) (string, error) {
 	if _, err := rand.Read(nonce); err != nil {
 		return "", err
 	}
-	sealed := gcm.Seal(nil, nonce, plain, nil)
+	sealed := gcm.Seal(nil, nonce, plain, aad)
 	data := append(nonce, sealed...)
 	return base64.StdEncoding.EncodeToString(data), nil
 }
 
 func (a *AESCrypto) Decrypt(cipherText string) ([]byte, error) {
+	return a.DecryptWithAAD(cipherText, nil)
+}
+
+// DecryptWithAAD opens a value sealed by EncryptWithAAD, failing when the
+// ciphertext or aad was tampered with.
+func (a *AESCrypto) DecryptWithAAD(cipherText string, aad []byte) ([]byte, error) {
 	raw, err := base64.StdEncoding.DecodeString(cipherText)
 	if err != nil {
 		return nil, err
@@ -61,5 +75,5 @@ func (a *AESCrypto) Decrypt(cipherText string) ([]byte, error) {
 	}
 	nonce := raw[:nonceSize]
 	data := raw[nonceSize:]
-	return gcm.Open(nil, nonce, data, nil)
+	return gcm.Open(nil, nonce, data, aad)
 }
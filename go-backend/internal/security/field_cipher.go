@@ -0,0 +1,125 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// sealedFieldPrefix marks a column value written by FieldCipher.Seal.
+// Anything without it is a plaintext value from before encryption was
+// enabled.
+const sealedFieldPrefix = "enc:v1:"
+
+// FieldCipher encrypts individual column values (node secrets, share
+// tokens) with a data key. Sealing is deterministic: the nonce is derived
+// from the plaintext, so the same value always seals to the same string and
+// can still be looked up with WHERE col = ?. The data key itself is stored
+// wrapped by the master key (see WrapDataKey), so changing the master key
+// only rewraps the data key.
+//
+// A nil *FieldCipher passes values through unchanged.
+type FieldCipher struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// NewDataKey returns a random 32-byte data key.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapDataKey encrypts dataKey with masterKey for storage.
+func WrapDataKey(masterKey string, dataKey []byte) (string, error) {
+	crypto, err := NewAESCrypto(masterKey)
+	if err != nil {
+		return "", err
+	}
+	return crypto.EncryptWithAAD(dataKey, []byte("flvx-data-key"))
+}
+
+// UnwrapDataKey reverses WrapDataKey. It fails when masterKey is not the
+// key the data key was wrapped with.
+func UnwrapDataKey(masterKey, wrapped string) ([]byte, error) {
+	crypto, err := NewAESCrypto(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := crypto.DecryptWithAAD(wrapped, []byte("flvx-data-key"))
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// NewFieldCipher derives separate encryption and nonce keys from dataKey.
+func NewFieldCipher(dataKey []byte) (*FieldCipher, error) {
+	if len(dataKey) != 32 {
+		return nil, fmt.Errorf("data key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(deriveKey(dataKey, "enc"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &FieldCipher{aead: aead, macKey: deriveKey(dataKey, "nonce")}, nil
+}
+
+// Seal encrypts value. Empty and already sealed values are returned as is.
+func (c *FieldCipher) Seal(value string) string {
+	if c == nil || value == "" || IsSealedField(value) {
+		return value
+	}
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(value))
+	nonce := mac.Sum(nil)[:c.aead.NonceSize()]
+	sealed := c.aead.Seal(append([]byte(nil), nonce...), nonce, []byte(value), nil)
+	return sealedFieldPrefix + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// Open decrypts a value written by Seal. Plaintext values are returned
+// unchanged, so rows that were never migrated keep working.
+func (c *FieldCipher) Open(value string) (string, error) {
+	if !IsSealedField(value) {
+		return value, nil
+	}
+	if c == nil {
+		return "", fmt.Errorf("encrypted value but no master key is configured")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, sealedFieldPrefix))
+	if err != nil {
+		return "", err
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(raw) < nonceSize {
+		return "", fmt.Errorf("ciphertext too short")
+	}
+	plain, err := c.aead.Open(nil, raw[:nonceSize], raw[nonceSize:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// IsSealedField reports whether value was written by FieldCipher.Seal.
+func IsSealedField(value string) bool {
+	return strings.HasPrefix(value, sealedFieldPrefix)
+}
+
+func deriveKey(dataKey []byte, label string) []byte {
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write([]byte("flvx-field-" + label))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,59 @@
+package security
+
+import "testing"
+
+func TestFieldCipherSealIsDeterministic(t *testing.T) {
+	dataKey, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("new data key: %v", err)
+	}
+	c, err := NewFieldCipher(dataKey)
+	if err != nil {
+		t.Fatalf("new field cipher: %v", err)
+	}
+
+	sealed := c.Seal("NodeSecret12345")
+	if sealed == "NodeSecret12345" || !IsSealedField(sealed) {
+		t.Fatalf("expected a sealed value, got %q", sealed)
+	}
+	if again := c.Seal("NodeSecret12345"); again != sealed {
+		t.Fatalf("expected the same value to seal identically")
+	}
+	if c.Seal(sealed) != sealed {
+		t.Fatalf("expected sealing to be idempotent")
+	}
+	if c.Seal("") != "" {
+		t.Fatalf("expected an empty value to stay empty")
+	}
+	if plain, err := c.Open(sealed); err != nil || plain != "NodeSecret12345" {
+		t.Fatalf("open: %q %v", plain, err)
+	}
+	if plain, err := c.Open("legacy-plaintext"); err != nil || plain != "legacy-plaintext" {
+		t.Fatalf("expected plaintext to pass through, got %q %v", plain, err)
+	}
+
+	otherKey, _ := NewDataKey()
+	other, _ := NewFieldCipher(otherKey)
+	if _, err := other.Open(sealed); err == nil {
+		t.Fatalf("expected another data key to fail")
+	}
+	var disabled *FieldCipher
+	if _, err := disabled.Open(sealed); err == nil {
+		t.Fatalf("expected a sealed value to fail without a cipher")
+	}
+}
+
+func TestWrapDataKeyRoundTrip(t *testing.T) {
+	dataKey, _ := NewDataKey()
+	wrapped, err := WrapDataKey("master", dataKey)
+	if err != nil {
+		t.Fatalf("wrap: %v", err)
+	}
+	got, err := UnwrapDataKey("master", wrapped)
+	if err != nil || string(got) != string(dataKey) {
+		t.Fatalf("unwrap: %v", err)
+	}
+	if _, err := UnwrapDataKey("other", wrapped); err == nil {
+		t.Fatalf("expected the wrong master key to fail")
+	}
+}
@@ -0,0 +1,46 @@
+package security
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// NodeMessageMaxSkewConfigKey is how far, in seconds, the timestamp of
+	// an encrypted node message may be from the panel clock.
+	NodeMessageMaxSkewConfigKey = "node_message_max_skew_sec"
+
+	DefaultNodeMessageMaxSkew = 5 * time.Minute
+	// MaxNodeMessageSkew caps the configured window. Replayed nonces are
+	// only remembered for twice this long.
+	MaxNodeMessageSkew = time.Hour
+)
+
+// ParseNodeMessageMaxSkew reads the node_message_max_skew_sec value. A
+// missing, invalid or non-positive value gives the default.
+func ParseNodeMessageMaxSkew(value string) time.Duration {
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n <= 0 {
+		return DefaultNodeMessageMaxSkew
+	}
+	window := time.Duration(n) * time.Second
+	if window > MaxNodeMessageSkew {
+		return MaxNodeMessageSkew
+	}
+	return window
+}
+
+// TimestampSkew is how far ts is from now. Nodes stamp their messages in
+// unix seconds; millisecond timestamps are recognised too.
+func TimestampSkew(ts int64, now time.Time) time.Duration {
+	at := time.Unix(ts, 0)
+	if ts > 1e12 {
+		at = time.UnixMilli(ts)
+	}
+	skew := now.Sub(at)
+	if skew < 0 {
+		return -skew
+	}
+	return skew
+}
@@ -0,0 +1,40 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNodeMessageMaxSkew(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":       DefaultNodeMessageMaxSkew,
+		"abc":    DefaultNodeMessageMaxSkew,
+		"0":      DefaultNodeMessageMaxSkew,
+		"-5":     DefaultNodeMessageMaxSkew,
+		" 30 ":   30 * time.Second,
+		"86400":  MaxNodeMessageSkew,
+		"3600":   time.Hour,
+		"120000": MaxNodeMessageSkew,
+	}
+	for value, want := range cases {
+		if got := ParseNodeMessageMaxSkew(value); got != want {
+			t.Fatalf("ParseNodeMessageMaxSkew(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestTimestampSkewAcceptsSecondsAndMilliseconds(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	if got := TimestampSkew(now.Unix()-90, now); got != 90*time.Second {
+		t.Fatalf("expected 90s behind, got %v", got)
+	}
+	if got := TimestampSkew(now.Unix()+30, now); got != 30*time.Second {
+		t.Fatalf("expected 30s ahead, got %v", got)
+	}
+	if got := TimestampSkew(now.Add(-2*time.Second).UnixMilli(), now); got != 2*time.Second {
+		t.Fatalf("expected 2s for a millisecond timestamp, got %v", got)
+	}
+	if got := TimestampSkew(0, now); got < 24*time.Hour {
+		t.Fatalf("expected a missing timestamp to be far off, got %v", got)
+	}
+}
@@ -0,0 +1,60 @@
+package security
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"unicode"
+)
+
+const (
+	nodeSecretMinLength = 16
+	nodeSecretLength    = 32
+	nodeSecretAlphabet  = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// ValidateNodeSecret rejects node secrets that are short enough to be guessed
+// through the secret lookup on /flow/upload and the node websocket.
+func ValidateNodeSecret(secret string) error {
+	if len(secret) < nodeSecretMinLength {
+		return errors.New("节点密钥长度至少为16位")
+	}
+	var hasUpper, hasDigit bool
+	for _, r := range secret {
+		switch {
+		case unicode.IsSpace(r):
+			return errors.New("节点密钥不能包含空白字符")
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasUpper {
+		return errors.New("节点密钥需包含至少一个大写字母")
+	}
+	if !hasDigit {
+		return errors.New("节点密钥需包含至少一个数字")
+	}
+	return nil
+}
+
+// GenerateNodeSecret returns a random 32-character alphanumeric secret that
+// always satisfies ValidateNodeSecret.
+func GenerateNodeSecret() (string, error) {
+	max := big.NewInt(int64(len(nodeSecretAlphabet)))
+	buf := make([]byte, nodeSecretLength)
+	for {
+		for i := range buf {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			buf[i] = nodeSecretAlphabet[n.Int64()]
+		}
+		secret := string(buf)
+		if ValidateNodeSecret(secret) == nil {
+			return secret, nil
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package security
+
+import "testing"
+
+func TestValidateNodeSecret(t *testing.T) {
+	cases := map[string]bool{
+		"":                                 false,
+		"Short1":                           false,
+		"alllowercasesecret123":            false,
+		"NODIGITSINTHISSECRETx":            false,
+		"Has Whitespace1234567":            false,
+		"Tab\tSeparated1234567":            false,
+		"ValidNodeSecret12345":             true,
+		"Z0aaaaaaaaaaaaaa":                 true,
+		"A1b2C3d4E5f6G7h8I9j0K1l2M3n4O5p6": true,
+	}
+	for secret, ok := range cases {
+		err := ValidateNodeSecret(secret)
+		if ok && err != nil {
+			t.Fatalf("expected %q to be accepted, got %v", secret, err)
+		}
+		if !ok && err == nil {
+			t.Fatalf("expected %q to be rejected", secret)
+		}
+	}
+}
+
+func TestGenerateNodeSecretPassesValidation(t *testing.T) {
+	seen := make(map[string]struct{})
+	for i := 0; i < 200; i++ {
+		secret, err := GenerateNodeSecret()
+		if err != nil {
+			t.Fatalf("generate secret: %v", err)
+		}
+		if len(secret) != 32 {
+			t.Fatalf("expected 32 characters, got %d (%q)", len(secret), secret)
+		}
+		if err := ValidateNodeSecret(secret); err != nil {
+			t.Fatalf("generated secret %q failed validation: %v", secret, err)
+		}
+		if _, dup := seen[secret]; dup {
+			t.Fatalf("generated duplicate secret %q", secret)
+		}
+		seen[secret] = struct{}{}
+	}
+}
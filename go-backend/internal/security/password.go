@@ -0,0 +1,59 @@
+package security
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const passwordHashCost = bcrypt.DefaultCost
+
+// dummyPasswordHash is a bcrypt hash, at passwordHashCost, of a random value
+// that was thrown away. Nothing verifies against it.
+const dummyPasswordHash = "$2a$10$3nBWrWx9UoPg0meXB08x.OnwX7FLB4vFdhLd4YJfUG2q54jJt0C7u"
+
+// HashPassword returns the bcrypt hash stored in user.pwd.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// VerifyPassword checks password against a stored hash. Hashes written before
+// bcrypt was introduced are unsalted MD5 hex digests; they still verify, but
+// needsRehash tells the caller to replace them with HashPassword.
+func VerifyPassword(stored, password string) (ok bool, needsRehash bool) {
+	if isLegacyMD5Hash(stored) {
+		ok = subtle.ConstantTimeCompare([]byte(strings.ToLower(stored)), []byte(MD5(password))) == 1
+		return ok, ok
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)); err != nil {
+		return false, false
+	}
+	if cost, err := bcrypt.Cost([]byte(stored)); err == nil && cost < passwordHashCost {
+		return true, true
+	}
+	return true, false
+}
+
+// VerifyDummyPassword spends as long as VerifyPassword does on a bcrypt hash
+// and always fails. Login calls it when no account matches the username, so
+// the response time does not reveal which usernames exist.
+func VerifyDummyPassword(password string) {
+	_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+}
+
+func isLegacyMD5Hash(stored string) bool {
+	if len(stored) != 32 {
+		return false
+	}
+	for _, c := range stored {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
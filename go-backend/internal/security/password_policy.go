@@ -0,0 +1,72 @@
+package security
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy is the strength requirement for passwords users choose.
+// Character classes are lowercase, uppercase, digits and everything else.
+type PasswordPolicy struct {
+	MinLength      int
+	MinCharClasses int
+	RejectCommon   bool
+}
+
+// commonPasswords are refused when RejectCommon is set. The list is short
+// on purpose: it catches the passwords attackers try first, including the
+// panel's own default.
+var commonPasswords = map[string]bool{
+	"123456": true, "1234567": true, "12345678": true, "123456789": true,
+	"1234567890": true, "111111": true, "000000": true, "666666": true,
+	"888888": true, "123123": true, "654321": true, "112233": true,
+	"121212": true, "abc123": true, "abc12345": true, "a123456": true,
+	"qwerty": true, "qwerty123": true, "qwertyuiop": true, "asdfgh": true,
+	"1q2w3e4r": true, "1qaz2wsx": true, "zxcvbnm": true, "password": true,
+	"password1": true, "password123": true, "passw0rd": true, "p@ssw0rd": true,
+	"admin": true, "admin123": true, "admin888": true, "administrator": true,
+	"admin_user": true, "root": true, "root123": true, "toor": true,
+	"letmein": true, "welcome": true, "iloveyou": true, "monkey": true,
+	"dragon": true, "football": true, "baseball": true, "sunshine": true,
+	"princess": true, "master": true, "changeme": true, "secret": true,
+	"woaini": true, "woaini1314": true, "5201314": true,
+}
+
+// Check returns an error describing the first requirement password fails.
+func (p PasswordPolicy) Check(password string) error {
+	if p.MinLength > 0 && utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("密码长度至少%d位", p.MinLength)
+	}
+	if p.MinCharClasses > 1 && passwordCharClasses(password) < p.MinCharClasses {
+		return fmt.Errorf("密码需包含大写字母、小写字母、数字、符号中的至少%d种", p.MinCharClasses)
+	}
+	if p.RejectCommon && commonPasswords[strings.ToLower(password)] {
+		return fmt.Errorf("密码过于常见，请更换")
+	}
+	return nil
+}
+
+func passwordCharClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	n := 0
+	for _, has := range []bool{lower, upper, digit, other} {
+		if has {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,26 @@
+package security
+
+import "testing"
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MinCharClasses: 3, RejectCommon: true}
+	cases := []struct {
+		password string
+		ok       bool
+	}{
+		{"Ab1!", false},        // too short
+		{"abcdefgh1", false},   // two classes
+		{"Abcdefgh1", true},    // three classes
+		{"密码Abcdef1", true},    // non-ASCII counts towards length
+		{"Password123", false}, // common, whatever the case
+	}
+	for _, c := range cases {
+		if err := policy.Check(c.password); (err == nil) != c.ok {
+			t.Errorf("Check(%q) = %v, want ok=%v", c.password, err, c.ok)
+		}
+	}
+
+	if err := (PasswordPolicy{}).Check("123456"); err != nil {
+		t.Fatalf("expected the zero policy to accept anything, got %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package security
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPasswordVerifies(t *testing.T) {
+	hash, err := HashPassword("s3cret-pass")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if ok, rehash := VerifyPassword(hash, "s3cret-pass"); !ok || rehash {
+		t.Fatalf("expected bcrypt hash to verify without rehash, got ok=%v rehash=%v", ok, rehash)
+	}
+	if ok, _ := VerifyPassword(hash, "other"); ok {
+		t.Fatalf("expected wrong password to be rejected")
+	}
+}
+
+func TestVerifyPasswordAcceptsLegacyMD5(t *testing.T) {
+	legacy := MD5("admin_user")
+	if ok, rehash := VerifyPassword(legacy, "admin_user"); !ok || !rehash {
+		t.Fatalf("expected legacy hash to verify and ask for rehash, got ok=%v rehash=%v", ok, rehash)
+	}
+	if ok, rehash := VerifyPassword(legacy, "wrong"); ok || rehash {
+		t.Fatalf("expected wrong password against legacy hash to be rejected, got ok=%v rehash=%v", ok, rehash)
+	}
+	if ok, _ := VerifyPassword("", ""); ok {
+		t.Fatalf("expected empty hash to be rejected")
+	}
+}
+
+func TestDummyPasswordHashIsCurrentCost(t *testing.T) {
+	// A cheaper dummy hash would make unknown usernames answer faster.
+	if cost, err := bcrypt.Cost([]byte(dummyPasswordHash)); err != nil || cost != passwordHashCost {
+		t.Fatalf("expected dummy hash at cost %d, got %d (%v)", passwordHashCost, cost, err)
+	}
+}
@@ -0,0 +1,113 @@
+// Package totp implements RFC 6238 time-based one-time passwords as used by
+// common authenticator apps: HMAC-SHA1, 6 digits, 30 second steps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 * time.Second
+
+	// Skew is the number of steps accepted on either side of the current one.
+	Skew = 1
+
+	secretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(secret), " ", ""))
+	secret = strings.TrimRight(secret, "=")
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid totp secret: %w", err)
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("invalid totp secret: empty")
+	}
+	return key, nil
+}
+
+func codeAt(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
+
+// GenerateSecret returns a new random base32 secret of the size RFC 4226
+// recommends for HMAC-SHA1.
+func GenerateSecret() (string, error) {
+	key := make([]byte, secretSize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(key), nil
+}
+
+// ProvisioningURI returns the otpauth:// URI authenticator apps import,
+// usually through a QR code.
+func ProvisioningURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// GenerateCode returns the code for the base32 secret at time t.
+func GenerateCode(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return codeAt(key, uint64(t.Unix())/uint64(Period/time.Second)), nil
+}
+
+// Validate reports whether code is valid for secret right now.
+func Validate(code, secret string) bool {
+	return ValidateAt(code, secret, time.Now())
+}
+
+// ValidateAt reports whether code is valid for secret at time t, allowing
+// Skew steps of clock drift in either direction.
+func ValidateAt(code, secret string, t time.Time) bool {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return false
+	}
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return false
+	}
+	counter := int64(uint64(t.Unix()) / uint64(Period/time.Second))
+	for delta := int64(-Skew); delta <= Skew; delta++ {
+		c := counter + delta
+		if c < 0 {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(codeAt(key, uint64(c))), []byte(code)) == 1 {
+			return true
+		}
+	}
+	return false
+}
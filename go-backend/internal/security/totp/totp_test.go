@@ -0,0 +1,72 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B secret "12345678901234567890" in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateCodeMatchesRFCVectors(t *testing.T) {
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range cases {
+		got, err := GenerateCode(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("generate at %d: %v", unix, err)
+		}
+		if got != want {
+			t.Fatalf("at %d expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidateAtAllowsOneStepSkew(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	for _, offset := range []time.Duration{-Period, 0, Period} {
+		code, _ := GenerateCode(rfcSecret, now.Add(offset))
+		if !ValidateAt(code, rfcSecret, now) {
+			t.Fatalf("expected code at offset %v to validate", offset)
+		}
+	}
+	for _, offset := range []time.Duration{-2 * Period, 2 * Period} {
+		code, _ := GenerateCode(rfcSecret, now.Add(offset))
+		if ValidateAt(code, rfcSecret, now) {
+			t.Fatalf("expected code at offset %v to be rejected", offset)
+		}
+	}
+	if ValidateAt("", rfcSecret, now) || ValidateAt("12345", rfcSecret, now) || ValidateAt("123456", "not base32!", now) {
+		t.Fatalf("expected malformed input to be rejected")
+	}
+}
+
+func TestGenerateSecretRoundTrips(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("generate secret: %v", err)
+	}
+	now := time.Now()
+	code, err := GenerateCode(secret, now)
+	if err != nil {
+		t.Fatalf("generate code: %v", err)
+	}
+	if !ValidateAt(code, secret, now) {
+		t.Fatalf("expected code from generated secret to validate")
+	}
+	if other, _ := GenerateSecret(); other == secret {
+		t.Fatalf("expected fresh secrets to differ")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	got := ProvisioningURI("FLVX", "admin user", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/FLVX:admin%20user?digits=6&issuer=FLVX&period=30&secret=JBSWY3DPEHPK3PXP"
+	if got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// ErrorKind classifies a repository failure so callers can branch on it
+// without matching driver-specific messages.
+type ErrorKind int
+
+const (
+	Internal ErrorKind = iota
+	NotFound
+	Conflict
+)
+
+// String returns a human-readable kind name.
+func (k ErrorKind) String() string {
+	switch k {
+	case NotFound:
+		return "not found"
+	case Conflict:
+		return "conflict"
+	default:
+		return "internal"
+	}
+}
+
+// Error records the repository operation that failed and what kind of
+// failure it was.
+type Error struct {
+	Op   string
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Op + ": " + e.Kind.String()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError wraps err with the operation and kind. It returns nil when err is
+// nil so it can wrap the result of a call directly.
+func NewError(op string, kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Op: op, Kind: kind, Err: err}
+}
+
+// WrapError is NewError with the kind inferred from err: sql.ErrNoRows is
+// NotFound, a unique or primary key violation is Conflict, anything else is
+// Internal. An err that already carries a kind keeps it.
+func WrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return NewError(op, kindOf(err), err)
+}
+
+// IsNotFound reports whether err means the requested row does not exist.
+func IsNotFound(err error) bool {
+	return err != nil && kindOf(err) == NotFound
+}
+
+// IsConflict reports whether err is a uniqueness violation.
+func IsConflict(err error) bool {
+	return err != nil && kindOf(err) == Conflict
+}
+
+func kindOf(err error) ErrorKind {
+	var storeErr *Error
+	if errors.As(err, &storeErr) {
+		return storeErr.Kind
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return NotFound
+	}
+	if isUniqueViolation(err) {
+		return Conflict
+	}
+	return Internal
+}
+
+func isUniqueViolation(err error) bool {
+	// pgconn.PgError exposes its SQLSTATE; 23505 is unique_violation.
+	var coded interface{ SQLState() string }
+	if errors.As(err, &coded) && coded.SQLState() == "23505" {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "UNIQUE constraint failed") ||
+		strings.Contains(msg, "PRIMARY KEY constraint failed")
+}
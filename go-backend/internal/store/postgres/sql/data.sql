@@ -6,6 +6,10 @@ INSERT INTO vite_config (id, name, value, time)
 VALUES (1, 'app_name', 'flux', 1755147963000)
 ON CONFLICT DO NOTHING;
 
+INSERT INTO role (id, name, description, created_time, updated_time)
+VALUES (0, 'admin', '', 1748914865000, 1748914865000), (1, 'user', '', 1748914865000, 1748914865000)
+ON CONFLICT DO NOTHING;
+
 DO $$
 BEGIN
     IF to_regclass('public.user_id_seq') IS NOT NULL THEN
@@ -14,5 +18,8 @@ BEGIN
     IF to_regclass('public.vite_config_id_seq') IS NOT NULL THEN
         PERFORM setval('vite_config_id_seq', (SELECT COALESCE(MAX(id), 0) FROM vite_config));
     END IF;
+    IF to_regclass('public.role_id_seq') IS NOT NULL THEN
+        PERFORM setval('role_id_seq', (SELECT COALESCE(MAX(id), 0) FROM role));
+    END IF;
 END
 $$;
@@ -11,7 +11,11 @@ CREATE TABLE IF NOT EXISTS forward (
   created_time BIGINT NOT NULL,
   updated_time BIGINT NOT NULL,
   status INTEGER NOT NULL,
-  inx INTEGER NOT NULL DEFAULT 0
+  inx INTEGER NOT NULL DEFAULT 0,
+  protocol VARCHAR(10) NOT NULL DEFAULT 'tcp',
+  dns_server VARCHAR(100),
+  idle_timeout_sec INTEGER NOT NULL DEFAULT 0,
+  service_revision INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS forward_port (
@@ -43,7 +47,13 @@ CREATE TABLE IF NOT EXISTS node (
   is_remote INTEGER DEFAULT 0,
   remote_url TEXT,
   remote_token TEXT,
-  remote_config TEXT
+  remote_config TEXT,
+  last_seen_at BIGINT,
+  last_ip VARCHAR(100),
+  callback_url TEXT,
+  client_cert TEXT,
+  client_cert_serial VARCHAR(64),
+  client_cert_expire BIGINT
 );
 
 CREATE TABLE IF NOT EXISTS speed_limit (
@@ -77,7 +87,8 @@ CREATE TABLE IF NOT EXISTS tunnel (
   updated_time BIGINT NOT NULL,
   status INTEGER NOT NULL,
   in_ip TEXT,
-  inx INTEGER NOT NULL DEFAULT 0
+  inx INTEGER NOT NULL DEFAULT 0,
+  dscp_mark INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS chain_tunnel (
@@ -104,7 +115,12 @@ CREATE TABLE IF NOT EXISTS "user" (
   num INTEGER NOT NULL,
   created_time BIGINT NOT NULL,
   updated_time BIGINT,
-  status INTEGER NOT NULL
+  status INTEGER NOT NULL,
+  totp_enabled INTEGER NOT NULL DEFAULT 0,
+  totp_secret TEXT NOT NULL DEFAULT '',
+  permission_mask BIGINT NOT NULL DEFAULT 0,
+  email VARCHAR(255) NOT NULL DEFAULT '',
+  tokens_revoked_time BIGINT NOT NULL DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS user_tunnel (
@@ -174,6 +190,7 @@ CREATE UNIQUE INDEX IF NOT EXISTS idx_user_group_user_unique ON user_group_user(
 CREATE UNIQUE INDEX IF NOT EXISTS idx_group_permission_unique ON group_permission(user_group_id, tunnel_group_id);
 CREATE UNIQUE INDEX IF NOT EXISTS idx_group_permission_grant_unique ON group_permission_grant(user_group_id, tunnel_group_id, user_tunnel_id);
 CREATE UNIQUE INDEX IF NOT EXISTS idx_user_tunnel_unique ON user_tunnel(user_id, tunnel_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_email_unique ON "user"(email) WHERE email != '';
 
 CREATE TABLE IF NOT EXISTS vite_config (
   id SERIAL PRIMARY KEY,
@@ -203,6 +220,7 @@ CREATE TABLE IF NOT EXISTS peer_share_runtime (
     id SERIAL PRIMARY KEY,
     share_id INTEGER NOT NULL,
     node_id INTEGER NOT NULL,
+    consumer_id TEXT NOT NULL DEFAULT '',
     reservation_id TEXT NOT NULL UNIQUE,
     resource_key TEXT NOT NULL UNIQUE,
     binding_id TEXT NOT NULL DEFAULT '',
@@ -222,6 +240,17 @@ CREATE TABLE IF NOT EXISTS peer_share_runtime (
 CREATE INDEX IF NOT EXISTS idx_peer_share_runtime_share_node_status ON peer_share_runtime(share_id, node_id, status);
 CREATE INDEX IF NOT EXISTS idx_peer_share_runtime_binding_id ON peer_share_runtime(binding_id);
 
+CREATE TABLE IF NOT EXISTS peer_share_consumer (
+    id SERIAL PRIMARY KEY,
+    share_id INTEGER NOT NULL,
+    consumer_id TEXT NOT NULL,
+    callback_url TEXT NOT NULL DEFAULT '',
+    created_time BIGINT NOT NULL,
+    updated_time BIGINT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_peer_share_consumer_unique ON peer_share_consumer(share_id, consumer_id);
+
 CREATE TABLE IF NOT EXISTS federation_tunnel_binding (
     id SERIAL PRIMARY KEY,
     tunnel_id INTEGER NOT NULL,
@@ -239,3 +268,188 @@ CREATE TABLE IF NOT EXISTS federation_tunnel_binding (
 
 CREATE UNIQUE INDEX IF NOT EXISTS idx_federation_tunnel_binding_unique ON federation_tunnel_binding(tunnel_id, node_id, chain_type, hop_inx);
 CREATE INDEX IF NOT EXISTS idx_federation_tunnel_binding_tunnel ON federation_tunnel_binding(tunnel_id, status);
+
+CREATE TABLE IF NOT EXISTS node_connection_log (
+    id SERIAL PRIMARY KEY,
+    node_id INTEGER NOT NULL,
+    event TEXT NOT NULL,
+    remote_ip TEXT NOT NULL DEFAULT '',
+    version TEXT NOT NULL DEFAULT '',
+    created_time BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_connection_log_node_time ON node_connection_log(node_id, created_time);
+
+CREATE TABLE IF NOT EXISTS node_latency (
+    from_node_id INTEGER NOT NULL,
+    to_node_id INTEGER NOT NULL,
+    latency_ms DOUBLE PRECISION NOT NULL,
+    measured_at BIGINT NOT NULL,
+    PRIMARY KEY (from_node_id, to_node_id)
+);
+
+CREATE TABLE IF NOT EXISTS chain_hop_stats (
+    tunnel_id BIGINT NOT NULL,
+    chain_type INTEGER NOT NULL,
+    node_id BIGINT NOT NULL,
+    avg_latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    bytes_in BIGINT NOT NULL DEFAULT 0,
+    bytes_out BIGINT NOT NULL DEFAULT 0,
+    updated_at BIGINT NOT NULL,
+    PRIMARY KEY (tunnel_id, chain_type, node_id)
+);
+
+CREATE TABLE IF NOT EXISTS forward_baseline (
+    forward_id BIGINT PRIMARY KEY,
+    avg_bytes_per_upload DOUBLE PRECISION NOT NULL DEFAULT 0,
+    std_dev DOUBLE PRECISION NOT NULL DEFAULT 0,
+    sample_count INTEGER NOT NULL DEFAULT 0,
+    updated_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS group_tunnel_quota (
+    group_id BIGINT NOT NULL,
+    tunnel_id BIGINT NOT NULL,
+    max_forwards INTEGER NOT NULL DEFAULT 0,
+    updated_time BIGINT NOT NULL,
+    PRIMARY KEY (group_id, tunnel_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_notification_pref (
+    user_id INTEGER PRIMARY KEY,
+    expiry_warning_enabled INTEGER NOT NULL DEFAULT 1,
+    updated_time BIGINT NOT NULL,
+    security_email_enabled INTEGER NOT NULL DEFAULT 0,
+    security_webhook_url TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS flow_log (
+    id SERIAL PRIMARY KEY,
+    forward_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    flow_date VARCHAR(10) NOT NULL,
+    in_flow BIGINT NOT NULL DEFAULT 0,
+    out_flow BIGINT NOT NULL DEFAULT 0,
+    created_time BIGINT NOT NULL,
+    updated_time BIGINT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_flow_log_forward_date ON flow_log(forward_id, user_id, flow_date);
+CREATE INDEX IF NOT EXISTS idx_flow_log_user_date ON flow_log(user_id, flow_date);
+
+CREATE TABLE IF NOT EXISTS reserved_port (
+    id SERIAL PRIMARY KEY,
+    node_id INTEGER NOT NULL,
+    port_start INTEGER NOT NULL,
+    port_end INTEGER NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_time BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_reserved_port_node ON reserved_port(node_id);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    username TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL DEFAULT '',
+    target_id BIGINT NOT NULL DEFAULT 0,
+    detail TEXT NOT NULL DEFAULT '',
+    created_time BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_time ON audit_log(created_time);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action, created_time);
+
+CREATE TABLE IF NOT EXISTS refresh_token (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at BIGINT NOT NULL,
+    revoked_time BIGINT NOT NULL DEFAULT 0,
+    device VARCHAR(100) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    last_seen_time BIGINT NOT NULL DEFAULT 0,
+    created_time BIGINT NOT NULL,
+    updated_time BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_token_user ON refresh_token(user_id);
+
+CREATE TABLE IF NOT EXISTS user_recovery_code (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_time BIGINT NOT NULL DEFAULT 0,
+    created_time BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_code_user ON user_recovery_code(user_id, code_hash);
+
+CREATE TABLE IF NOT EXISTS user_api_key (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    key_prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    last_used_time BIGINT NOT NULL DEFAULT 0,
+    revoked_time BIGINT NOT NULL DEFAULT 0,
+    created_time BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_api_key_user ON user_api_key(user_id);
+
+CREATE TABLE IF NOT EXISTS user_oidc_identity (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    created_time BIGINT NOT NULL,
+    UNIQUE(issuer, subject)
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_credential (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    credential_id TEXT NOT NULL UNIQUE,
+    public_key TEXT NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    last_used_time BIGINT NOT NULL DEFAULT 0,
+    created_time BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credential_user ON webauthn_credential(user_id);
+
+CREATE TABLE IF NOT EXISTS jwt_signing_key (
+    id SERIAL PRIMARY KEY,
+    kid TEXT NOT NULL UNIQUE,
+    secret TEXT NOT NULL,
+    created_time BIGINT NOT NULL,
+    retired_time BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS role (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_time BIGINT NOT NULL,
+    updated_time BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permission (
+    role_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    PRIMARY KEY (role_id, action)
+);
+
+CREATE TABLE IF NOT EXISTS ip_ban (
+    id SERIAL PRIMARY KEY,
+    ip VARCHAR(100) NOT NULL UNIQUE,
+    reason TEXT NOT NULL DEFAULT '',
+    failures INTEGER NOT NULL DEFAULT 0,
+    banned_until BIGINT NOT NULL,
+    created_time BIGINT NOT NULL
+);
@@ -0,0 +1,159 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go-backend/internal/security"
+	"go-backend/internal/store"
+)
+
+// FieldDataKeyConfigKey names the vite_config entry holding the data key
+// that encrypts node secrets and share tokens, wrapped by the master key.
+const FieldDataKeyConfigKey = "field_data_key"
+
+// EnableFieldEncryption makes the repository encrypt node secrets, node
+// remote tokens and peer share tokens on write and decrypt them on read.
+// The data key is created on first use. Rows written before this was
+// enabled stay readable until EncryptStoredSecrets migrates them.
+func (r *Repository) EnableFieldEncryption(masterKey string) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	if strings.TrimSpace(masterKey) == "" {
+		return errors.New("master key is empty")
+	}
+
+	var dataKey []byte
+	cfg, err := r.GetConfigByName(FieldDataKeyConfigKey)
+	if err != nil {
+		return store.WrapError("EnableFieldEncryption", err)
+	}
+	if cfg != nil && cfg.Value != "" {
+		if dataKey, err = security.UnwrapDataKey(masterKey, cfg.Value); err != nil {
+			return store.WrapError("EnableFieldEncryption", err)
+		}
+	} else {
+		if dataKey, err = security.NewDataKey(); err != nil {
+			return store.WrapError("EnableFieldEncryption", err)
+		}
+		wrapped, err := security.WrapDataKey(masterKey, dataKey)
+		if err != nil {
+			return store.WrapError("EnableFieldEncryption", err)
+		}
+		if err := r.UpsertConfig(FieldDataKeyConfigKey, wrapped, unixMilliNow()); err != nil {
+			return store.WrapError("EnableFieldEncryption", err)
+		}
+	}
+
+	fields, err := security.NewFieldCipher(dataKey)
+	if err != nil {
+		return store.WrapError("EnableFieldEncryption", err)
+	}
+	r.fields = fields
+	return nil
+}
+
+// SealSecret encrypts a node secret or token for storage. It returns value
+// unchanged when field encryption is not enabled.
+func (r *Repository) SealSecret(value string) string {
+	if r == nil {
+		return value
+	}
+	return r.fields.Seal(value)
+}
+
+// OpenSecret decrypts a value read from a secret or token column.
+func (r *Repository) OpenSecret(value string) (string, error) {
+	if r == nil {
+		return value, nil
+	}
+	return r.fields.Open(value)
+}
+
+// secretLookup returns the arguments for "col IN (?, ?)" matching value
+// whether its row has been encrypted yet or not.
+func (r *Repository) secretLookup(value string) (interface{}, interface{}) {
+	return r.SealSecret(value), value
+}
+
+func (r *Repository) openNode(n *Node) error {
+	var err error
+	if n.Secret, err = r.OpenSecret(n.Secret); err != nil {
+		return err
+	}
+	if n.RemoteToken.Valid {
+		if n.RemoteToken.String, err = r.OpenSecret(n.RemoteToken.String); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) openPeerShare(s *PeerShare) error {
+	var err error
+	s.Token, err = r.OpenSecret(s.Token)
+	return err
+}
+
+// EncryptStoredSecrets encrypts every node secret, node remote token and
+// peer share token still stored in plaintext, and returns how many values it
+// rewrote. It is safe to run more than once.
+func (r *Repository) EncryptStoredSecrets() (int, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	if r.fields == nil {
+		return 0, errors.New("field encryption is not enabled")
+	}
+
+	type pending struct {
+		table, column string
+		id            int64
+		value         string
+	}
+	var todo []pending
+	collect := func(table, column string) error {
+		rows, err := r.db.Query(fmt.Sprintf(`SELECT id, %s FROM %s WHERE %s IS NOT NULL AND %s <> ''`, column, table, column, column))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.value); err != nil {
+				return err
+			}
+			if !security.IsSealedField(p.value) {
+				p.table, p.column = table, column
+				todo = append(todo, p)
+			}
+		}
+		return rows.Err()
+	}
+	for _, target := range [][2]string{{"node", "secret"}, {"node", "remote_token"}, {"peer_share", "token"}} {
+		if err := collect(target[0], target[1]); err != nil {
+			return 0, store.WrapError("EncryptStoredSecrets", err)
+		}
+	}
+	if len(todo) == 0 {
+		return 0, nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, store.WrapError("EncryptStoredSecrets", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, p := range todo {
+		query := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE id = ? AND %s = ?`, p.table, p.column, p.column)
+		if _, err := tx.Exec(query, r.fields.Seal(p.value), p.id, p.value); err != nil {
+			return 0, store.WrapError("EncryptStoredSecrets", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, store.WrapError("EncryptStoredSecrets", err)
+	}
+	return len(todo), nil
+}
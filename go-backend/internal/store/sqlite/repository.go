@@ -1,21 +1,29 @@
 package sqlite
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
 	_ "embed"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"go-backend/internal/cache"
+	"go-backend/internal/config"
+	"go-backend/internal/security"
 	"go-backend/internal/store"
 	pgstore "go-backend/internal/store/postgres"
-	_ "modernc.org/sqlite"
+	moderncsqlite "modernc.org/sqlite"
 )
 
 //go:embed sql/schema.sql
@@ -30,10 +38,14 @@ type Execer interface {
 	Exec(query string, args ...any) (sql.Result, error)
 	Query(query string, args ...any) (*sql.Rows, error)
 	QueryRow(query string, args ...any) *sql.Row
+	ExecReturningID(query string, args ...any) (int64, error)
 }
 
 type Repository struct {
-	db *store.DB
+	db      *store.DB
+	configs *cache.ConfigCache
+	// fields encrypts secret columns; nil leaves them in plaintext.
+	fields *security.FieldCipher
 }
 
 func (r *Repository) DB() *store.DB {
@@ -43,6 +55,23 @@ func (r *Repository) DB() *store.DB {
 	return r.db
 }
 
+// ConfigCache returns the cache in front of GetConfigByName.
+func (r *Repository) ConfigCache() *cache.ConfigCache {
+	if r == nil {
+		return nil
+	}
+	return r.configs
+}
+
+// ExecerFromCtx returns the transaction carried by ctx (see store.WithTx),
+// or the connection pool when there is none.
+func (r *Repository) ExecerFromCtx(ctx context.Context) Execer {
+	if tx, ok := store.TxFromCtx(ctx); ok {
+		return tx
+	}
+	return r.db
+}
+
 type User struct {
 	ID            int64
 	User          string
@@ -57,6 +86,9 @@ type User struct {
 	CreatedTime   int64
 	UpdatedTime   sql.NullInt64
 	Status        int
+	// PermissionMask holds the auth.Permission bits delegated to a non-admin
+	// user.
+	PermissionMask int64
 }
 
 type ViteConfig struct {
@@ -107,6 +139,7 @@ type StatisticsFlow struct {
 
 type Node struct {
 	ID           int64
+	Name         string
 	Secret       string
 	Version      sql.NullString
 	HTTP         int
@@ -140,6 +173,7 @@ type PeerShareRuntime struct {
 	ID            int64
 	ShareID       int64
 	NodeID        int64
+	ConsumerID    string
 	ReservationID string
 	ResourceKey   string
 	BindingID     string
@@ -203,7 +237,76 @@ func Open(path string) (*Repository, error) {
 		return nil, err
 	}
 
-	return &Repository{db: db}, nil
+	if err := ensurePanelID(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	repo := &Repository{db: db, configs: cache.NewConfigCache()}
+	if err := repo.InitDefaultConfigs(config.DefaultConfigs); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return repo, nil
+}
+
+// backupPagesPerStep is how many pages OnlineBackupContext copies before
+// yielding the source lock to writers and checking for cancellation.
+const backupPagesPerStep = 256
+
+// OnlineBackup writes a consistent snapshot of the SQLite database to
+// destPath using SQLite's online backup API, so the panel can keep writing
+// while it runs.
+func (r *Repository) OnlineBackup(destPath string) error {
+	return r.OnlineBackupContext(context.Background(), destPath)
+}
+
+// OnlineBackupContext is OnlineBackup with cancellation between steps. A
+// cancelled backup leaves an incomplete file at destPath.
+func (r *Repository) OnlineBackupContext(ctx context.Context, destPath string) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	if r.db.Dialect() != store.DialectSQLite {
+		return errors.New("online backup is only supported for sqlite")
+	}
+	conn, err := r.db.RawDB().Conn(ctx)
+	if err != nil {
+		return store.WrapError("OnlineBackup", err)
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(driverConn interface{}) error {
+		src, ok := driverConn.(interface {
+			NewBackup(dstURI string) (*moderncsqlite.Backup, error)
+		})
+		if !ok {
+			return errors.New("sqlite driver does not support online backup")
+		}
+		backup, err := src.NewBackup(destPath)
+		if err != nil {
+			return fmt.Errorf("start backup failed: %w", err)
+		}
+		for {
+			more, err := backup.Step(backupPagesPerStep)
+			if err != nil {
+				_ = backup.Finish()
+				return fmt.Errorf("backup step failed: %w", err)
+			}
+			if !more {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				_ = backup.Finish()
+				return err
+			}
+		}
+		if err := backup.Finish(); err != nil {
+			return fmt.Errorf("finish backup failed: %w", err)
+		}
+		return nil
+	})
+	return store.WrapError("OnlineBackup", err)
 }
 
 func OpenPostgres(dsn string) (*Repository, error) {
@@ -232,7 +335,17 @@ func OpenPostgres(dsn string) (*Repository, error) {
 		return nil, err
 	}
 
-	return &Repository{db: db}, nil
+	if err := ensurePanelID(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	repo := &Repository{db: db, configs: cache.NewConfigCache()}
+	if err := repo.InitDefaultConfigs(config.DefaultConfigs); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return repo, nil
 }
 
 func (r *Repository) Close() error {
@@ -242,33 +355,36 @@ func (r *Repository) Close() error {
 	return r.db.Close()
 }
 
+// GetUserByUsername returns a store.NotFound error when no user matches.
 func (r *Repository) GetUserByUsername(username string) (*User, error) {
 	if r == nil || r.db == nil {
 		return nil, errors.New("repository not initialized")
 	}
 
 	row := r.db.QueryRow(`
-		SELECT id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status
+		SELECT id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status, COALESCE(permission_mask, 0)
 		FROM user WHERE user = ? LIMIT 1
 	`, username)
 	user := &User{}
 	if err := row.Scan(
 		&user.ID, &user.User, &user.Pwd, &user.RoleID, &user.ExpTime,
 		&user.Flow, &user.InFlow, &user.OutFlow, &user.FlowResetTime,
-		&user.Num, &user.CreatedTime, &user.UpdatedTime, &user.Status,
+		&user.Num, &user.CreatedTime, &user.UpdatedTime, &user.Status, &user.PermissionMask,
 	); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, err
+		return nil, store.WrapError("GetUserByUsername", err)
 	}
 	return user, nil
 }
 
+// GetConfigByName reads through the config cache. Values served from the
+// cache carry only Name and Value.
 func (r *Repository) GetConfigByName(name string) (*ViteConfig, error) {
 	if r == nil || r.db == nil {
 		return nil, errors.New("repository not initialized")
 	}
+	if value, ok := r.configs.Get(name); ok {
+		return &ViteConfig{Name: name, Value: value}, nil
+	}
 
 	row := r.db.QueryRow(`SELECT id, name, value, time FROM vite_config WHERE name = ? LIMIT 1`, name)
 	cfg := &ViteConfig{}
@@ -276,8 +392,9 @@ func (r *Repository) GetConfigByName(name string) (*ViteConfig, error) {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
-		return nil, err
+		return nil, store.WrapError("GetConfigByName", err)
 	}
+	r.configs.Set(name, cfg.Value, 0)
 	return cfg, nil
 }
 
@@ -288,7 +405,7 @@ func (r *Repository) ListConfigs() (map[string]string, error) {
 
 	rows, err := r.db.Query(`SELECT name, value FROM vite_config`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("ListConfigs", err)
 	}
 	defer rows.Close()
 
@@ -296,52 +413,123 @@ func (r *Repository) ListConfigs() (map[string]string, error) {
 	for rows.Next() {
 		var name, value string
 		if err := rows.Scan(&name, &value); err != nil {
-			return nil, err
+			return nil, store.WrapError("ListConfigs", err)
 		}
 		result[name] = value
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError("ListConfigs", err)
+	}
+	return result, nil
+}
+
+// ListConfigsByPrefix returns the configs whose name starts with prefix.
+// LIKE wildcards in prefix are matched literally.
+func (r *Repository) ListConfigsByPrefix(prefix string) (map[string]string, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+
+	rows, err := r.db.Query(`SELECT name, value FROM vite_config WHERE name LIKE ? || '%' ESCAPE '\'`, escapeLike(prefix))
+	if err != nil {
+		return nil, store.WrapError("ListConfigsByPrefix", err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, store.WrapError("ListConfigsByPrefix", err)
+		}
+		result[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("ListConfigsByPrefix", err)
 	}
 	return result, nil
 }
 
 func (r *Repository) UpsertConfig(name, value string, now int64) error {
+	return r.UpsertConfigTx(context.Background(), name, value, now)
+}
+
+// UpsertConfigTx is UpsertConfig inside the transaction carried by ctx.
+func (r *Repository) UpsertConfigTx(ctx context.Context, name, value string, now int64) error {
 	if r == nil || r.db == nil {
 		return errors.New("repository not initialized")
 	}
 
-	_, err := r.db.Exec(`
+	_, err := r.ExecerFromCtx(ctx).Exec(`
 		INSERT INTO vite_config(name, value, time)
 		VALUES(?, ?, ?)
 		ON CONFLICT(name) DO UPDATE SET value=excluded.value, time=excluded.time
 	`, name, value, now)
-	return err
+	r.configs.Invalidate(name)
+	return store.WrapError("UpsertConfig", err)
 }
 
+// GetUserByID returns a store.NotFound error when no user matches.
 func (r *Repository) GetUserByID(id int64) (*User, error) {
 	if r == nil || r.db == nil {
 		return nil, errors.New("repository not initialized")
 	}
 
 	row := r.db.QueryRow(`
-		SELECT id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status
+		SELECT id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status, COALESCE(permission_mask, 0)
 		FROM user WHERE id = ? LIMIT 1
 	`, id)
 	user := &User{}
 	if err := row.Scan(
 		&user.ID, &user.User, &user.Pwd, &user.RoleID, &user.ExpTime,
 		&user.Flow, &user.InFlow, &user.OutFlow, &user.FlowResetTime,
-		&user.Num, &user.CreatedTime, &user.UpdatedTime, &user.Status,
+		&user.Num, &user.CreatedTime, &user.UpdatedTime, &user.Status, &user.PermissionMask,
 	); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, err
+		return nil, store.WrapError("GetUserByID", err)
 	}
 	return user, nil
 }
 
+// CreateUser inserts a user and returns its ID. A duplicate username yields
+// a store.Conflict error.
+func (r *Repository) CreateUser(user *User) (int64, error) {
+	return r.CreateUserTx(context.Background(), user)
+}
+
+// CreateUserTx is CreateUser inside the transaction carried by ctx.
+func (r *Repository) CreateUserTx(ctx context.Context, user *User) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	if user == nil {
+		return 0, errors.New("user is nil")
+	}
+
+	var updated interface{}
+	if user.UpdatedTime.Valid {
+		updated = user.UpdatedTime.Int64
+	}
+	id, err := r.ExecerFromCtx(ctx).ExecReturningID(`
+		INSERT INTO user(user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status, permission_mask)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, user.User, user.Pwd, user.RoleID, user.ExpTime, user.Flow, user.InFlow, user.OutFlow, user.FlowResetTime, user.Num, user.CreatedTime, updated, user.Status, user.PermissionMask)
+	if err != nil {
+		return 0, store.WrapError("CreateUser", err)
+	}
+	return id, nil
+}
+
+// SetUserPermissionMask replaces the delegated permission bits of a user.
+func (r *Repository) SetUserPermissionMask(userID int64, mask int64, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	if _, err := r.db.Exec(`UPDATE user SET permission_mask = ?, updated_time = ? WHERE id = ?`, mask, now, userID); err != nil {
+		return store.WrapError("SetUserPermissionMask", fmt.Errorf("set user permission mask failed: %w", err))
+	}
+	return nil
+}
+
 func (r *Repository) UsernameExistsExceptID(username string, exceptID int64) (bool, error) {
 	if r == nil || r.db == nil {
 		return false, errors.New("repository not initialized")
@@ -350,17 +538,27 @@ func (r *Repository) UsernameExistsExceptID(username string, exceptID int64) (bo
 	row := r.db.QueryRow(`SELECT COUNT(1) FROM user WHERE user = ? AND id != ?`, username, exceptID)
 	var count int
 	if err := row.Scan(&count); err != nil {
-		return false, err
+		return false, store.WrapError("UsernameExistsExceptID", err)
 	}
 	return count > 0, nil
 }
 
-func (r *Repository) UpdateUserNameAndPassword(userID int64, username, passwordMD5 string, now int64) error {
+func (r *Repository) UpdateUserNameAndPassword(userID int64, username, passwordHash string, now int64) error {
 	if r == nil || r.db == nil {
 		return errors.New("repository not initialized")
 	}
-	_, err := r.db.Exec(`UPDATE user SET user = ?, pwd = ?, updated_time = ? WHERE id = ?`, username, passwordMD5, now, userID)
-	return err
+	_, err := r.db.Exec(`UPDATE user SET user = ?, pwd = ?, updated_time = ? WHERE id = ?`, username, passwordHash, now, userID)
+	return store.WrapError("UpdateUserNameAndPassword", err)
+}
+
+// UpdateUserPasswordHash swaps the stored hash without touching updated_time,
+// so rehashing a legacy password on login is invisible to the user list.
+func (r *Repository) UpdateUserPasswordHash(userID int64, passwordHash string) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`UPDATE user SET pwd = ? WHERE id = ?`, passwordHash, userID)
+	return store.WrapError("UpdateUserPasswordHash", err)
 }
 
 func (r *Repository) GetUserPackageTunnels(userID int64) ([]UserTunnelDetail, error) {
@@ -378,7 +576,7 @@ func (r *Repository) GetUserPackageTunnels(userID int64) ([]UserTunnelDetail, er
 		ORDER BY ut.id ASC
 	`, userID)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("GetUserPackageTunnels", err)
 	}
 	defer rows.Close()
 
@@ -390,13 +588,13 @@ func (r *Repository) GetUserPackageTunnels(userID int64) ([]UserTunnelDetail, er
 			&item.Flow, &item.InFlow, &item.OutFlow, &item.Num, &item.FlowResetTime,
 			&item.ExpTime, &item.SpeedID, &item.SpeedLimit, &item.Speed,
 		); err != nil {
-			return nil, err
+			return nil, store.WrapError("GetUserPackageTunnels", err)
 		}
 		items = append(items, item)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError("GetUserPackageTunnels", err)
 	}
 
 	return items, nil
@@ -415,7 +613,7 @@ func (r *Repository) GetUserPackageForwards(userID int64) ([]UserForwardDetail,
 		ORDER BY f.id ASC
 	`, userID)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("GetUserPackageForwards", err)
 	}
 	defer rows.Close()
 
@@ -426,12 +624,12 @@ func (r *Repository) GetUserPackageForwards(userID int64) ([]UserForwardDetail,
 			&item.ID, &item.Name, &item.TunnelID, &item.TunnelName, &item.RemoteAddr,
 			&item.InFlow, &item.OutFlow, &item.Status, &item.CreatedAt,
 		); err != nil {
-			return nil, err
+			return nil, store.WrapError("GetUserPackageForwards", err)
 		}
 
 		inIP, inPort, err := resolveForwardIngress(r.db, item.ID, item.TunnelID)
 		if err != nil {
-			return nil, err
+			return nil, store.WrapError("GetUserPackageForwards", err)
 		}
 		item.InIP = inIP
 		item.InPort = inPort
@@ -440,7 +638,7 @@ func (r *Repository) GetUserPackageForwards(userID int64) ([]UserForwardDetail,
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError("GetUserPackageForwards", err)
 	}
 
 	return items, nil
@@ -459,7 +657,7 @@ func (r *Repository) GetStatisticsFlows(userID int64, limit int) ([]StatisticsFl
 		LIMIT ?
 	`, userID, limit)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("GetStatisticsFlows", err)
 	}
 	defer rows.Close()
 
@@ -467,13 +665,13 @@ func (r *Repository) GetStatisticsFlows(userID int64, limit int) ([]StatisticsFl
 	for rows.Next() {
 		var item StatisticsFlow
 		if err := rows.Scan(&item.ID, &item.UserID, &item.Flow, &item.TotalFlow, &item.Time); err != nil {
-			return nil, err
+			return nil, store.WrapError("GetStatisticsFlows", err)
 		}
 		items = append(items, item)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError("GetStatisticsFlows", err)
 	}
 
 	return items, nil
@@ -484,10 +682,11 @@ func (r *Repository) NodeExistsBySecret(secret string) (bool, error) {
 		return false, errors.New("repository not initialized")
 	}
 
-	row := r.db.QueryRow(`SELECT COUNT(1) FROM node WHERE secret = ?`, secret)
+	sealed, plain := r.secretLookup(secret)
+	row := r.db.QueryRow(`SELECT COUNT(1) FROM node WHERE secret IN (?, ?)`, sealed, plain)
 	var count int
 	if err := row.Scan(&count); err != nil {
-		return false, err
+		return false, store.WrapError("NodeExistsBySecret", err)
 	}
 	return count > 0, nil
 }
@@ -497,40 +696,183 @@ func (r *Repository) GetNodeBySecret(secret string) (*Node, error) {
 		return nil, errors.New("repository not initialized")
 	}
 
-	row := r.db.QueryRow(`SELECT id, secret, version, http, tls, socks, status, is_remote, remote_url, remote_token, remote_config FROM node WHERE secret = ? LIMIT 1`, secret)
+	sealed, plain := r.secretLookup(secret)
+	row := r.db.QueryRow(`SELECT id, name, secret, version, http, tls, socks, status, is_remote, remote_url, remote_token, remote_config FROM node WHERE secret IN (?, ?) LIMIT 1`, sealed, plain)
 	var n Node
-	if err := row.Scan(&n.ID, &n.Secret, &n.Version, &n.HTTP, &n.TLS, &n.Socks, &n.Status, &n.IsRemote, &n.RemoteURL, &n.RemoteToken, &n.RemoteConfig); err != nil {
+	if err := row.Scan(&n.ID, &n.Name, &n.Secret, &n.Version, &n.HTTP, &n.TLS, &n.Socks, &n.Status, &n.IsRemote, &n.RemoteURL, &n.RemoteToken, &n.RemoteConfig); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
-		return nil, err
+		return nil, store.WrapError("GetNodeBySecret", err)
+	}
+	if err := r.openNode(&n); err != nil {
+		return nil, store.WrapError("GetNodeBySecret", err)
 	}
 	return &n, nil
 }
 
+// NodeClientCert is the client certificate a node presents on the mutual
+// TLS listener. Serial is empty when none was issued.
+type NodeClientCert struct {
+	NodeID     int64
+	CertPEM    string
+	Serial     string
+	ExpireTime int64
+}
+
+// GetNodeClientCert returns the certificate issued to the node with secret,
+// or nil when there is no such node.
+func (r *Repository) GetNodeClientCert(secret string) (*NodeClientCert, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var c NodeClientCert
+	var certPEM, serial sql.NullString
+	var expire sql.NullInt64
+	sealed, plain := r.secretLookup(secret)
+	err := r.db.QueryRow(`SELECT id, client_cert, client_cert_serial, client_cert_expire FROM node WHERE secret IN (?, ?) LIMIT 1`, sealed, plain).
+		Scan(&c.NodeID, &certPEM, &serial, &expire)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, store.WrapError("GetNodeClientCert", err)
+	}
+	c.CertPEM, c.Serial, c.ExpireTime = certPEM.String, serial.String, expire.Int64
+	return &c, nil
+}
+
+// SetNodeClientCert records a newly issued certificate, replacing the
+// previous one.
+func (r *Repository) SetNodeClientCert(nodeID int64, certPEM, serial string, expireTime, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`UPDATE node SET client_cert = ?, client_cert_serial = ?, client_cert_expire = ?, updated_time = ? WHERE id = ?`, certPEM, serial, expireTime, now, nodeID)
+	return store.WrapError("SetNodeClientCert", err)
+}
+
 func (r *Repository) GetNodeByID(id int64) (*Node, error) {
 	if r == nil || r.db == nil {
 		return nil, errors.New("repository not initialized")
 	}
 
-	row := r.db.QueryRow(`SELECT id, secret, version, http, tls, socks, status, is_remote, remote_url, remote_token, remote_config FROM node WHERE id = ? LIMIT 1`, id)
+	row := r.db.QueryRow(`SELECT id, name, secret, version, http, tls, socks, status, is_remote, remote_url, remote_token, remote_config FROM node WHERE id = ? LIMIT 1`, id)
 	var n Node
-	if err := row.Scan(&n.ID, &n.Secret, &n.Version, &n.HTTP, &n.TLS, &n.Socks, &n.Status, &n.IsRemote, &n.RemoteURL, &n.RemoteToken, &n.RemoteConfig); err != nil {
+	if err := row.Scan(&n.ID, &n.Name, &n.Secret, &n.Version, &n.HTTP, &n.TLS, &n.Socks, &n.Status, &n.IsRemote, &n.RemoteURL, &n.RemoteToken, &n.RemoteConfig); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
-		return nil, err
+		return nil, store.WrapError("GetNodeByID", err)
+	}
+	if err := r.openNode(&n); err != nil {
+		return nil, store.WrapError("GetNodeByID", err)
 	}
 	return &n, nil
 }
 
+// NodeConfig is everything the panel expects a node to run: the tunnel
+// chain hops it takes part in and the forward services listening on it.
+type NodeConfig struct {
+	NodeID   int64               `json:"nodeId"`
+	Chains   []NodeChainConfig   `json:"chains"`
+	Services []NodeServiceConfig `json:"services"`
+}
+
+type NodeChainConfig struct {
+	TunnelID   int64  `json:"tunnelId"`
+	TunnelName string `json:"tunnelName"`
+	TunnelType int    `json:"tunnelType"`
+	ChainType  string `json:"chainType"`
+	Inx        int64  `json:"inx"`
+	Port       int64  `json:"port"`
+	Strategy   string `json:"strategy"`
+	Protocol   string `json:"protocol"`
+}
+
+type NodeServiceConfig struct {
+	ForwardID  int64  `json:"forwardId"`
+	Name       string `json:"name"`
+	TunnelID   int64  `json:"tunnelId"`
+	Port       int    `json:"port"`
+	RemoteAddr string `json:"remoteAddr"`
+	Protocol   string `json:"protocol"`
+	Strategy   string `json:"strategy"`
+	Status     int    `json:"status"`
+}
+
+// GetNodeFullConfig returns the chain and service configuration of the node
+// with the given secret, or nil when no node has that secret.
+func (r *Repository) GetNodeFullConfig(secret string) (*NodeConfig, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	node, err := r.GetNodeBySecret(secret)
+	if err != nil {
+		return nil, store.WrapError("GetNodeFullConfig", fmt.Errorf("get node by secret failed: %w", err))
+	}
+	if node == nil {
+		return nil, nil
+	}
+	cfg := &NodeConfig{
+		NodeID:   node.ID,
+		Chains:   make([]NodeChainConfig, 0),
+		Services: make([]NodeServiceConfig, 0),
+	}
+
+	rows, err := r.db.Query(`
+		SELECT ct.tunnel_id, t.name, t.type, ct.chain_type, COALESCE(ct.inx, 0), COALESCE(ct.port, 0), COALESCE(ct.strategy, ''), COALESCE(ct.protocol, '')
+		FROM chain_tunnel ct
+		JOIN tunnel t ON t.id = ct.tunnel_id
+		WHERE ct.node_id = ?
+		ORDER BY ct.tunnel_id ASC, ct.chain_type ASC, ct.inx ASC
+	`, node.ID)
+	if err != nil {
+		return nil, store.WrapError("GetNodeFullConfig", fmt.Errorf("query node chains failed: %w", err))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c NodeChainConfig
+		if err := rows.Scan(&c.TunnelID, &c.TunnelName, &c.TunnelType, &c.ChainType, &c.Inx, &c.Port, &c.Strategy, &c.Protocol); err != nil {
+			return nil, store.WrapError("GetNodeFullConfig", fmt.Errorf("scan node chain failed: %w", err))
+		}
+		cfg.Chains = append(cfg.Chains, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("GetNodeFullConfig", fmt.Errorf("query node chains failed: %w", err))
+	}
+
+	srows, err := r.db.Query(`
+		SELECT f.id, f.name, f.tunnel_id, fp.port, f.remote_addr, f.protocol, f.strategy, f.status
+		FROM forward_port fp
+		JOIN forward f ON f.id = fp.forward_id
+		WHERE fp.node_id = ?
+		ORDER BY f.id ASC
+	`, node.ID)
+	if err != nil {
+		return nil, store.WrapError("GetNodeFullConfig", fmt.Errorf("query node services failed: %w", err))
+	}
+	defer srows.Close()
+	for srows.Next() {
+		var sv NodeServiceConfig
+		if err := srows.Scan(&sv.ForwardID, &sv.Name, &sv.TunnelID, &sv.Port, &sv.RemoteAddr, &sv.Protocol, &sv.Strategy, &sv.Status); err != nil {
+			return nil, store.WrapError("GetNodeFullConfig", fmt.Errorf("scan node service failed: %w", err))
+		}
+		cfg.Services = append(cfg.Services, sv)
+	}
+	if err := srows.Err(); err != nil {
+		return nil, store.WrapError("GetNodeFullConfig", fmt.Errorf("query node services failed: %w", err))
+	}
+	return cfg, nil
+}
+
 func (r *Repository) UpdateNodeOnline(nodeID int64, status int, version string, httpVal, tlsVal, socksVal int) error {
 	if r == nil || r.db == nil {
 		return errors.New("repository not initialized")
 	}
 	_, err := r.db.Exec(`UPDATE node SET status = ?, version = ?, http = ?, tls = ?, socks = ?, updated_time = ? WHERE id = ?`,
 		status, version, httpVal, tlsVal, socksVal, unixMilliNow(), nodeID)
-	return err
+	return store.WrapError("UpdateNodeOnline", err)
 }
 
 func (r *Repository) UpdateNodeStatus(nodeID int64, status int) error {
@@ -538,339 +880,987 @@ func (r *Repository) UpdateNodeStatus(nodeID int64, status int) error {
 		return errors.New("repository not initialized")
 	}
 	_, err := r.db.Exec(`UPDATE node SET status = ?, updated_time = ? WHERE id = ?`, status, unixMilliNow(), nodeID)
-	return err
+	return store.WrapError("UpdateNodeStatus", err)
 }
 
-func (r *Repository) AddFlow(forwardID, userID int64, userTunnelID int64, inFlow, outFlow int64) error {
+// RecordNodeConnection appends a connect/disconnect event to the node's
+// connection log. Connect events also refresh node.last_seen_at and
+// node.last_ip.
+func (r *Repository) RecordNodeConnection(nodeID int64, event, remoteIP, version string) error {
 	if r == nil || r.db == nil {
 		return errors.New("repository not initialized")
 	}
-
-	tx, err := r.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if err != nil {
-			_ = tx.Rollback()
-		}
-	}()
-
-	if _, err = tx.Exec(`UPDATE forward SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id = ?`, inFlow, outFlow, forwardID); err != nil {
-		return err
+	now := unixMilliNow()
+	if _, err := r.db.Exec(`INSERT INTO node_connection_log(node_id, event, remote_ip, version, created_time) VALUES(?, ?, ?, ?, ?)`,
+		nodeID, event, remoteIP, version, now); err != nil {
+		return store.WrapError("RecordNodeConnection", fmt.Errorf("record node connection failed: %w", err))
 	}
-	if _, err = tx.Exec(`UPDATE user SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id = ?`, inFlow, outFlow, userID); err != nil {
-		return err
+	if event != "connect" {
+		return nil
 	}
-	if userTunnelID > 0 {
-		if _, err = tx.Exec(`UPDATE user_tunnel SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id = ?`, inFlow, outFlow, userTunnelID); err != nil {
-			return err
-		}
+	if _, err := r.db.Exec(`UPDATE node SET last_seen_at = ?, last_ip = ? WHERE id = ?`, now, remoteIP, nodeID); err != nil {
+		return store.WrapError("RecordNodeConnection", fmt.Errorf("update node last seen failed: %w", err))
 	}
-
-	err = tx.Commit()
-	return err
+	return nil
 }
 
-func (r *Repository) ListNodes() ([]map[string]interface{}, error) {
+// GetNodeConnectionHistory returns one page of a node's connection log,
+// newest first, together with the total number of entries.
+func (r *Repository) GetNodeConnectionHistory(nodeID int64, page, pageSize int) ([]map[string]interface{}, int, error) {
 	if r == nil || r.db == nil {
-		return nil, errors.New("repository not initialized")
+		return nil, 0, errors.New("repository not initialized")
+	}
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(1) FROM node_connection_log WHERE node_id = ?`, nodeID).Scan(&total); err != nil {
+		return nil, 0, store.WrapError("GetNodeConnectionHistory", fmt.Errorf("count node connection log failed: %w", err))
 	}
 
+	limit, offset := pageBounds(page, pageSize)
 	rows, err := r.db.Query(`
-		SELECT id, inx, name, server_ip, server_ip_v4, server_ip_v6, port, tcp_listen_addr, udp_listen_addr, version, http, tls, socks, status, is_remote, remote_url, remote_token, remote_config
-		FROM node
-		ORDER BY inx ASC, id ASC
-	`)
+		SELECT id, event, remote_ip, version, created_time
+		FROM node_connection_log
+		WHERE node_id = ?
+		ORDER BY created_time DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, nodeID, limit, offset)
 	if err != nil {
-		return nil, err
+		return nil, 0, store.WrapError("GetNodeConnectionHistory", fmt.Errorf("query node connection log failed: %w", err))
 	}
 	defer rows.Close()
 
 	items := make([]map[string]interface{}, 0)
 	for rows.Next() {
-		var id, inx int64
-		var name, serverIP, port string
-		var serverIPV4, serverIPV6, tcpListen, udpListen, version, remoteURL, remoteToken, remoteConfig sql.NullString
-		var httpVal, tlsVal, socksVal, status, isRemote int
-
-		if err := rows.Scan(&id, &inx, &name, &serverIP, &serverIPV4, &serverIPV6, &port, &tcpListen, &udpListen, &version, &httpVal, &tlsVal, &socksVal, &status, &isRemote, &remoteURL, &remoteToken, &remoteConfig); err != nil {
-			return nil, err
+		var id, createdTime int64
+		var event, remoteIP, version string
+		if err := rows.Scan(&id, &event, &remoteIP, &version, &createdTime); err != nil {
+			return nil, 0, store.WrapError("GetNodeConnectionHistory", fmt.Errorf("scan node connection log failed: %w", err))
 		}
-
 		items = append(items, map[string]interface{}{
-			"id":            id,
-			"inx":           inx,
-			"name":          name,
-			"ip":            serverIP,
-			"serverIp":      serverIP,
-			"serverIpV4":    nullableString(serverIPV4),
-			"serverIpV6":    nullableString(serverIPV6),
-			"port":          port,
-			"tcpListenAddr": nullableString(tcpListen),
-			"udpListenAddr": nullableString(udpListen),
-			"version":       nullableString(version),
-			"http":          httpVal,
-			"tls":           tlsVal,
-			"socks":         socksVal,
-			"status":        status,
-			"isRemote":      isRemote,
-			"remoteUrl":     nullableString(remoteURL),
-			"remoteToken":   nullableString(remoteToken),
-			"remoteConfig":  nullableString(remoteConfig),
+			"id":          id,
+			"nodeId":      nodeID,
+			"event":       event,
+			"remoteIp":    remoteIP,
+			"version":     version,
+			"createdTime": createdTime,
 		})
 	}
-
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, 0, store.WrapError("GetNodeConnectionHistory", err)
 	}
-	return items, nil
+	return items, total, nil
 }
 
-func (r *Repository) ListUsers() ([]map[string]interface{}, error) {
+// NodeLatency is the last measured round-trip time from one node to another.
+type NodeLatency struct {
+	From       int64   `json:"from"`
+	To         int64   `json:"to"`
+	LatencyMs  float64 `json:"latencyMs"`
+	MeasuredAt int64   `json:"measuredAt"`
+}
+
+// UpsertNodeLatency stores the latest latency measured from one node to another.
+func (r *Repository) UpsertNodeLatency(fromNodeID, toNodeID int64, latencyMs float64, measuredAt int64) error {
 	if r == nil || r.db == nil {
-		return nil, errors.New("repository not initialized")
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO node_latency(from_node_id, to_node_id, latency_ms, measured_at)
+		VALUES(?, ?, ?, ?)
+		ON CONFLICT(from_node_id, to_node_id)
+		DO UPDATE SET latency_ms = excluded.latency_ms, measured_at = excluded.measured_at
+	`, fromNodeID, toNodeID, latencyMs, measuredAt)
+	if err != nil {
+		return store.WrapError("UpsertNodeLatency", fmt.Errorf("upsert node latency failed: %w", err))
 	}
+	return nil
+}
 
+// ListNodeLatencies returns every stored node-to-node measurement.
+func (r *Repository) ListNodeLatencies() ([]NodeLatency, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
 	rows, err := r.db.Query(`
-		SELECT id, user, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status
-		FROM user
-		WHERE role_id != 0
-		ORDER BY id ASC
+		SELECT from_node_id, to_node_id, latency_ms, measured_at
+		FROM node_latency
+		ORDER BY from_node_id ASC, to_node_id ASC
 	`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("ListNodeLatencies", fmt.Errorf("query node latency failed: %w", err))
 	}
 	defer rows.Close()
 
-	items := make([]map[string]interface{}, 0)
+	items := make([]NodeLatency, 0)
 	for rows.Next() {
-		var id int64
-		var user string
-		var roleID int
-		var expTime, flow, inFlow, outFlow, flowResetTime, createdTime int64
-		var num, status int
-		var updatedTime sql.NullInt64
-
-		if err := rows.Scan(&id, &user, &roleID, &expTime, &flow, &inFlow, &outFlow, &flowResetTime, &num, &createdTime, &updatedTime, &status); err != nil {
-			return nil, err
+		var item NodeLatency
+		if err := rows.Scan(&item.From, &item.To, &item.LatencyMs, &item.MeasuredAt); err != nil {
+			return nil, store.WrapError("ListNodeLatencies", fmt.Errorf("scan node latency failed: %w", err))
 		}
-
-		items = append(items, map[string]interface{}{
-			"id":            id,
-			"user":          user,
-			"name":          user,
-			"roleId":        roleID,
-			"status":        status,
-			"flow":          flow,
-			"num":           num,
-			"expTime":       expTime,
-			"flowResetTime": flowResetTime,
-			"createdTime":   createdTime,
-			"updatedTime":   nullableInt64(updatedTime),
-			"inFlow":        inFlow,
-			"outFlow":       outFlow,
-		})
+		items = append(items, item)
 	}
-
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError("ListNodeLatencies", err)
 	}
 	return items, nil
 }
 
-func (r *Repository) ListSpeedLimits() ([]map[string]interface{}, error) {
+// ChainHopStat is the latest traffic report of one node on one hop of a
+// tunnel chain.
+type ChainHopStat struct {
+	TunnelID     int64   `json:"tunnelId"`
+	ChainType    int     `json:"chainType"`
+	NodeID       int64   `json:"nodeId"`
+	NodeName     string  `json:"nodeName"`
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+	BytesIn      int64   `json:"bytesIn"`
+	BytesOut     int64   `json:"bytesOut"`
+	UpdatedAt    int64   `json:"updatedAt"`
+}
+
+// UpsertChainHopStats records a hop report of a node. Reports carry the bytes
+// seen since the previous one, so they are added to the running totals; the
+// latency is replaced by the latest sample.
+func (r *Repository) UpsertChainHopStats(stat ChainHopStat) error {
 	if r == nil || r.db == nil {
-		return nil, errors.New("repository not initialized")
+		return errors.New("repository not initialized")
 	}
+	_, err := r.db.Exec(`
+		INSERT INTO chain_hop_stats(tunnel_id, chain_type, node_id, avg_latency_ms, bytes_in, bytes_out, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(tunnel_id, chain_type, node_id)
+		DO UPDATE SET avg_latency_ms = excluded.avg_latency_ms,
+			bytes_in = chain_hop_stats.bytes_in + excluded.bytes_in,
+			bytes_out = chain_hop_stats.bytes_out + excluded.bytes_out,
+			updated_at = excluded.updated_at
+	`, stat.TunnelID, stat.ChainType, stat.NodeID, stat.AvgLatencyMs, stat.BytesIn, stat.BytesOut, stat.UpdatedAt)
+	if err != nil {
+		return store.WrapError("UpsertChainHopStats", fmt.Errorf("upsert chain hop stats failed: %w", err))
+	}
+	return nil
+}
 
+// ListChainHopStats returns the hop reports of a tunnel in chain order:
+// entry (1), relays (2), then exit (3).
+func (r *Repository) ListChainHopStats(tunnelID int64) ([]ChainHopStat, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
 	rows, err := r.db.Query(`
-		SELECT id, name, speed, tunnel_id, tunnel_name, status, created_time, updated_time
-		FROM speed_limit
-		ORDER BY id ASC
-	`)
+		SELECT s.tunnel_id, s.chain_type, s.node_id, COALESCE(n.name, ''), s.avg_latency_ms, s.bytes_in, s.bytes_out, s.updated_at
+		FROM chain_hop_stats s
+		LEFT JOIN node n ON n.id = s.node_id
+		WHERE s.tunnel_id = ?
+		ORDER BY s.chain_type ASC, s.node_id ASC
+	`, tunnelID)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("ListChainHopStats", fmt.Errorf("query chain hop stats failed: %w", err))
 	}
 	defer rows.Close()
 
-	items := make([]map[string]interface{}, 0)
+	items := make([]ChainHopStat, 0)
 	for rows.Next() {
-		var id, tunnelID, createdTime int64
-		var name, tunnelName string
-		var speed, status int
-		var updatedTime sql.NullInt64
-		if err := rows.Scan(&id, &name, &speed, &tunnelID, &tunnelName, &status, &createdTime, &updatedTime); err != nil {
-			return nil, err
+		var item ChainHopStat
+		if err := rows.Scan(&item.TunnelID, &item.ChainType, &item.NodeID, &item.NodeName, &item.AvgLatencyMs, &item.BytesIn, &item.BytesOut, &item.UpdatedAt); err != nil {
+			return nil, store.WrapError("ListChainHopStats", fmt.Errorf("scan chain hop stats failed: %w", err))
 		}
-		items = append(items, map[string]interface{}{
-			"id":          id,
-			"name":        name,
-			"speed":       speed,
-			"tunnelId":    tunnelID,
-			"tunnelName":  tunnelName,
-			"status":      status,
-			"createdTime": createdTime,
-			"updatedTime": nullableInt64(updatedTime),
-		})
+		items = append(items, item)
 	}
-
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError("ListChainHopStats", err)
 	}
 	return items, nil
 }
 
-func (r *Repository) ListForwards() ([]map[string]interface{}, error) {
+// UserNotificationPref holds a user's opt-ins for panel notifications.
+// Users without a stored row get the defaults.
+type UserNotificationPref struct {
+	UserID               int64 `json:"userId"`
+	ExpiryWarningEnabled bool  `json:"expiryWarningEnabled"`
+	// SecurityEmailEnabled mails login and account security events to the
+	// user's address; SecurityWebhookURL, when set, receives them as JSON.
+	SecurityEmailEnabled bool   `json:"securityEmailEnabled"`
+	SecurityWebhookURL   string `json:"securityWebhookUrl"`
+}
+
+func (r *Repository) GetUserNotificationPref(userID int64) (*UserNotificationPref, error) {
 	if r == nil || r.db == nil {
 		return nil, errors.New("repository not initialized")
 	}
+	pref := &UserNotificationPref{UserID: userID, ExpiryWarningEnabled: true}
+	var enabled, securityEmail int
+	err := r.db.QueryRow(`SELECT expiry_warning_enabled, security_email_enabled, security_webhook_url FROM user_notification_pref WHERE user_id = ?`, userID).Scan(&enabled, &securityEmail, &pref.SecurityWebhookURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return pref, nil
+	}
+	if err != nil {
+		return nil, store.WrapError("GetUserNotificationPref", fmt.Errorf("query notification pref failed: %w", err))
+	}
+	pref.ExpiryWarningEnabled = enabled != 0
+	pref.SecurityEmailEnabled = securityEmail != 0
+	return pref, nil
+}
+
+func (r *Repository) UpsertUserNotificationPref(pref UserNotificationPref, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	enabled, securityEmail := 0, 0
+	if pref.ExpiryWarningEnabled {
+		enabled = 1
+	}
+	if pref.SecurityEmailEnabled {
+		securityEmail = 1
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO user_notification_pref(user_id, expiry_warning_enabled, security_email_enabled, security_webhook_url, updated_time)
+		VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(user_id)
+		DO UPDATE SET expiry_warning_enabled = excluded.expiry_warning_enabled, security_email_enabled = excluded.security_email_enabled,
+			security_webhook_url = excluded.security_webhook_url, updated_time = excluded.updated_time
+	`, pref.UserID, enabled, securityEmail, pref.SecurityWebhookURL, now)
+	if err != nil {
+		return store.WrapError("UpsertUserNotificationPref", fmt.Errorf("upsert notification pref failed: %w", err))
+	}
+	return nil
+}
 
+// ExpiringUserTunnel is an active tunnel assignment whose expiry falls in a
+// queried window.
+type ExpiringUserTunnel struct {
+	UserTunnelID int64
+	UserID       int64
+	TunnelName   string
+	ExpTime      int64
+}
+
+// ListExpiringUserTunnels returns active user tunnels expiring in [from, to].
+func (r *Repository) ListExpiringUserTunnels(from, to int64) ([]ExpiringUserTunnel, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
 	rows, err := r.db.Query(`
-		SELECT f.id, f.user_id, f.user_name, f.name, f.tunnel_id, COALESCE(t.name, ''), f.remote_addr, COALESCE(f.strategy, 'fifo'),
-		       f.in_flow, f.out_flow, f.created_time, f.status, f.inx
-		FROM forward f
-		LEFT JOIN tunnel t ON t.id = f.tunnel_id
-		ORDER BY f.inx ASC, f.id ASC
-	`)
+		SELECT ut.id, ut.user_id, COALESCE(t.name, ''), ut.exp_time
+		FROM user_tunnel ut
+		LEFT JOIN tunnel t ON t.id = ut.tunnel_id
+		WHERE ut.status = 1
+		  AND ut.exp_time IS NOT NULL
+		  AND ut.exp_time >= ?
+		  AND ut.exp_time <= ?
+		ORDER BY ut.exp_time ASC, ut.id ASC
+	`, from, to)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("ListExpiringUserTunnels", fmt.Errorf("query expiring user tunnels failed: %w", err))
 	}
 	defer rows.Close()
 
-	items := make([]map[string]interface{}, 0)
+	items := make([]ExpiringUserTunnel, 0)
 	for rows.Next() {
-		var id, userID, tunnelID, inFlow, outFlow, createdTime, inx int64
-		var userName, name, tunnelName, remoteAddr, strategy string
-		var status int
+		var item ExpiringUserTunnel
+		if err := rows.Scan(&item.UserTunnelID, &item.UserID, &item.TunnelName, &item.ExpTime); err != nil {
+			return nil, store.WrapError("ListExpiringUserTunnels", fmt.Errorf("scan expiring user tunnel failed: %w", err))
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("ListExpiringUserTunnels", err)
+	}
+	return items, nil
+}
 
-		if err := rows.Scan(&id, &userID, &userName, &name, &tunnelID, &tunnelName, &remoteAddr, &strategy, &inFlow, &outFlow, &createdTime, &status, &inx); err != nil {
-			return nil, err
+// OnlineNodeAddress is where peers can reach an online local node.
+type OnlineNodeAddress struct {
+	IP        string
+	PortRange string
+}
+
+// ListOnlineNodeAddresses maps each online local node to its server IP and
+// port range.
+func (r *Repository) ListOnlineNodeAddresses() (map[int64]OnlineNodeAddress, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`SELECT id, server_ip, port FROM node WHERE status = 1 AND COALESCE(is_remote, 0) = 0`)
+	if err != nil {
+		return nil, store.WrapError("ListOnlineNodeAddresses", fmt.Errorf("query online nodes failed: %w", err))
+	}
+	defer rows.Close()
+
+	out := make(map[int64]OnlineNodeAddress)
+	for rows.Next() {
+		var id int64
+		var addr OnlineNodeAddress
+		if err := rows.Scan(&id, &addr.IP, &addr.PortRange); err != nil {
+			return nil, store.WrapError("ListOnlineNodeAddresses", fmt.Errorf("scan online node failed: %w", err))
 		}
+		out[id] = addr
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("ListOnlineNodeAddresses", err)
+	}
+	return out, nil
+}
 
-		inIP, inPort, err := resolveForwardIngress(r.db, id, tunnelID)
+const (
+	// ForwardBaselineMinSamples is how many uploads a forward needs before
+	// its traffic is checked for anomalies.
+	ForwardBaselineMinSamples = 20
+	// forwardAnomalyZScore is how many deviations above the mean an upload
+	// must be to count as an anomaly.
+	forwardAnomalyZScore = 3.0
+)
+
+// ForwardAnomaly describes an upload that exceeded a forward's baseline.
+type ForwardAnomaly struct {
+	ForwardID     int64   `json:"forwardId"`
+	ExpectedBytes float64 `json:"expectedBytes"`
+	ActualBytes   int64   `json:"actualBytes"`
+	ZScore        float64 `json:"zScore"`
+}
+
+// UpdateForwardBaseline folds newBytes into the forward's running mean and
+// deviation (Welford's algorithm) and reports whether it exceeded the
+// baseline seen so far. The deviation used for scoring is never below the
+// mean, so steady traffic only alarms on spikes above four times its usual
+// volume rather than on any small increase.
+func (r *Repository) UpdateForwardBaseline(forwardID int64, newBytes int64) (ForwardAnomaly, bool, error) {
+	anomaly := ForwardAnomaly{ForwardID: forwardID, ActualBytes: newBytes}
+	if r == nil || r.db == nil {
+		return anomaly, false, errors.New("repository not initialized")
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return anomaly, false, store.WrapError("UpdateForwardBaseline", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var mean, stdDev float64
+	var count int64
+	err = tx.QueryRow(`SELECT avg_bytes_per_upload, std_dev, sample_count FROM forward_baseline WHERE forward_id = ?`, forwardID).Scan(&mean, &stdDev, &count)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return anomaly, false, store.WrapError("UpdateForwardBaseline", err)
+	}
+
+	x := float64(newBytes)
+	isAnomaly := false
+	if count >= ForwardBaselineMinSamples {
+		scale := math.Max(stdDev, mean)
+		if scale > 0 && x > mean+forwardAnomalyZScore*scale {
+			isAnomaly = true
+			anomaly.ExpectedBytes = mean
+			anomaly.ZScore = (x - mean) / scale
+		}
+	}
+
+	m2 := stdDev * stdDev * float64(count)
+	count++
+	delta := x - mean
+	mean += delta / float64(count)
+	m2 += delta * (x - mean)
+	stdDev = math.Sqrt(m2 / float64(count))
+
+	if _, err := tx.Exec(`
+		INSERT INTO forward_baseline(forward_id, avg_bytes_per_upload, std_dev, sample_count, updated_at)
+		VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(forward_id) DO UPDATE SET
+			avg_bytes_per_upload = excluded.avg_bytes_per_upload,
+			std_dev = excluded.std_dev,
+			sample_count = excluded.sample_count,
+			updated_at = excluded.updated_at
+	`, forwardID, mean, stdDev, count, time.Now().UnixMilli()); err != nil {
+		return anomaly, false, store.WrapError("UpdateForwardBaseline", fmt.Errorf("upsert forward baseline failed: %w", err))
+	}
+	if err := tx.Commit(); err != nil {
+		return anomaly, false, store.WrapError("UpdateForwardBaseline", err)
+	}
+	return anomaly, isAnomaly, nil
+}
+
+func (r *Repository) AddFlow(forwardID, userID int64, userTunnelID int64, inFlow, outFlow int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return store.WrapError("AddFlow", err)
+	}
+	defer func() {
 		if err != nil {
-			return nil, err
+			_ = tx.Rollback()
 		}
+	}()
 
-		items = append(items, map[string]interface{}{
-			"id":          id,
-			"userId":      userID,
-			"userName":    userName,
-			"name":        name,
-			"tunnelId":    tunnelID,
-			"tunnelName":  tunnelName,
-			"inIp":        nullableForwardIngress(inIP),
-			"inPort":      nullableInt64(inPort),
-			"remoteAddr":  remoteAddr,
-			"strategy":    strategy,
-			"inFlow":      inFlow,
-			"outFlow":     outFlow,
-			"createdTime": createdTime,
-			"status":      status,
-			"inx":         inx,
-		})
+	if _, err = tx.Exec(`UPDATE forward SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id = ?`, inFlow, outFlow, forwardID); err != nil {
+		return store.WrapError("AddFlow", err)
 	}
-
-	if err := rows.Err(); err != nil {
-		return nil, err
+	if _, err = tx.Exec(`UPDATE user SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id = ?`, inFlow, outFlow, userID); err != nil {
+		return store.WrapError("AddFlow", err)
 	}
-	return items, nil
+	if userTunnelID > 0 {
+		if _, err = tx.Exec(`UPDATE user_tunnel SET in_flow = in_flow + ?, out_flow = out_flow + ? WHERE id = ?`, inFlow, outFlow, userTunnelID); err != nil {
+			return store.WrapError("AddFlow", err)
+		}
+	}
+	now := time.Now()
+	if _, err = tx.Exec(`
+		INSERT INTO flow_log(forward_id, user_id, flow_date, in_flow, out_flow, created_time, updated_time)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(forward_id, user_id, flow_date) DO UPDATE SET
+			in_flow = flow_log.in_flow + excluded.in_flow,
+			out_flow = flow_log.out_flow + excluded.out_flow,
+			updated_time = excluded.updated_time
+	`, forwardID, userID, now.Format(flowDateLayout), inFlow, outFlow, now.UnixMilli(), now.UnixMilli()); err != nil {
+		return store.WrapError("AddFlow", err)
+	}
+
+	err = tx.Commit()
+	return store.WrapError("AddFlow", err)
 }
 
-func (r *Repository) ListUserAccessibleTunnels(userID int64) ([]map[string]interface{}, error) {
+const (
+	flowDateLayout = "2006-01-02"
+
+	// MaxFlowExportRows caps the number of rows a single flow export may return.
+	MaxFlowExportRows = 100000
+)
+
+// ErrFlowExportTooLarge is returned when a flow export would exceed MaxFlowExportRows.
+var ErrFlowExportTooLarge = fmt.Errorf("flow export exceeds %d rows", MaxFlowExportRows)
+
+// FlowExportRow is one day of traffic for a single forward.
+type FlowExportRow struct {
+	Date        string
+	TunnelName  string
+	ForwardName string
+	InBytes     int64
+	OutBytes    int64
+	TotalBytes  int64
+}
+
+// ScanUserFlowForExport calls fn for each day of per-forward traffic of
+// userID between fromDate and toDate (inclusive, YYYY-MM-DD), ordered by
+// date, as the rows are read. It returns ErrFlowExportTooLarge, before fn is
+// called, when the range holds more than MaxFlowExportRows rows. Iteration
+// stops at the first error returned by fn.
+func (r *Repository) ScanUserFlowForExport(userID int64, fromDate, toDate string, fn func(FlowExportRow) error) error {
 	if r == nil || r.db == nil {
-		return nil, errors.New("repository not initialized")
+		return errors.New("repository not initialized")
+	}
+
+	var count int
+	if err := r.db.QueryRow(`
+		SELECT COUNT(1) FROM flow_log WHERE user_id = ? AND flow_date >= ? AND flow_date <= ?
+	`, userID, fromDate, toDate).Scan(&count); err != nil {
+		return store.WrapError("ScanUserFlowForExport", fmt.Errorf("count flow export failed: %w", err))
+	}
+	if count > MaxFlowExportRows {
+		return ErrFlowExportTooLarge
 	}
 
 	rows, err := r.db.Query(`
-		SELECT t.id, t.name
-		FROM user_tunnel ut
-		JOIN tunnel t ON t.id = ut.tunnel_id
-		WHERE ut.user_id = ? AND t.status = 1
-		ORDER BY t.inx ASC, t.id ASC
-	`, userID)
+		SELECT fl.flow_date, COALESCE(t.name, ''), COALESCE(f.name, ''), fl.in_flow, fl.out_flow
+		FROM flow_log fl
+		LEFT JOIN forward f ON f.id = fl.forward_id
+		LEFT JOIN tunnel t ON t.id = f.tunnel_id
+		WHERE fl.user_id = ? AND fl.flow_date >= ? AND fl.flow_date <= ?
+		ORDER BY fl.flow_date ASC, t.name ASC, f.name ASC, fl.id ASC
+		LIMIT ?
+	`, userID, fromDate, toDate, MaxFlowExportRows)
 	if err != nil {
-		return nil, err
+		return store.WrapError("ScanUserFlowForExport", fmt.Errorf("query flow export failed: %w", err))
 	}
 	defer rows.Close()
 
-	items := make([]map[string]interface{}, 0)
 	for rows.Next() {
-		var id int64
-		var name string
-		if err := rows.Scan(&id, &name); err != nil {
-			return nil, err
+		var row FlowExportRow
+		if err := rows.Scan(&row.Date, &row.TunnelName, &row.ForwardName, &row.InBytes, &row.OutBytes); err != nil {
+			return store.WrapError("ScanUserFlowForExport", fmt.Errorf("scan flow export failed: %w", err))
+		}
+		row.TotalBytes = row.InBytes + row.OutBytes
+		if err := fn(row); err != nil {
+			return store.WrapError("ScanUserFlowForExport", err)
 		}
-		items = append(items, map[string]interface{}{"id": id, "name": name})
 	}
+	return store.WrapError("ScanUserFlowForExport", rows.Err())
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+func (r *Repository) ListNodes() ([]map[string]interface{}, error) {
+	items := make([]map[string]interface{}, 0)
+	err := r.ScanNodes(func(item map[string]interface{}) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, store.WrapError("ListNodes", err)
 	}
 	return items, nil
 }
 
-func (r *Repository) ListEnabledTunnelSummaries() ([]map[string]interface{}, error) {
+// ListActiveForwardsByNodeID returns the IDs of the active forwards that
+// have an entry port on the node.
+func (r *Repository) ListActiveForwardsByNodeID(nodeID int64) ([]int64, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	return r.queryIDs("ListActiveForwardsByNodeID", `
+		SELECT DISTINCT f.id
+		FROM forward f
+		JOIN forward_port fp ON fp.forward_id = f.id
+		WHERE fp.node_id = ? AND f.status = 1
+		ORDER BY f.id ASC
+	`, nodeID)
+}
+
+// ListActiveTunnelServicesByNodeID returns the IDs of the active tunnel
+// forward tunnels the node takes part in as entry, relay or exit.
+func (r *Repository) ListActiveTunnelServicesByNodeID(nodeID int64) ([]int64, error) {
 	if r == nil || r.db == nil {
 		return nil, errors.New("repository not initialized")
 	}
+	return r.queryIDs("ListActiveTunnelServicesByNodeID", `
+		SELECT DISTINCT t.id
+		FROM tunnel t
+		JOIN chain_tunnel ct ON ct.tunnel_id = t.id
+		WHERE ct.node_id = ? AND t.status = 1 AND t.type = 2
+		ORDER BY t.id ASC
+	`, nodeID)
+}
 
-	rows, err := r.db.Query(`
-		SELECT id, name
-		FROM tunnel
-		WHERE status = 1
-		ORDER BY inx ASC, id ASC
-	`)
+func (r *Repository) queryIDs(op, query string, args ...interface{}) ([]int64, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError(op, err)
 	}
 	defer rows.Close()
 
-	items := make([]map[string]interface{}, 0)
+	ids := make([]int64, 0)
 	for rows.Next() {
 		var id int64
-		var name string
-		if err := rows.Scan(&id, &name); err != nil {
-			return nil, err
+		if err := rows.Scan(&id); err != nil {
+			return nil, store.WrapError(op, err)
 		}
-		items = append(items, map[string]interface{}{"id": id, "name": name})
+		ids = append(ids, id)
 	}
-
 	if err := rows.Err(); err != nil {
+		return nil, store.WrapError(op, err)
+	}
+	return ids, nil
+}
+
+// ListNodesByIDs returns the nodes with the given IDs, ordered like ListNodes.
+// An empty ids slice yields an empty list.
+func (r *Repository) ListNodesByIDs(ids []int64) ([]map[string]interface{}, error) {
+	items := make([]map[string]interface{}, 0, len(ids))
+	if len(ids) == 0 {
+		return items, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	err := r.scanNodesWhere("ListNodesByIDs", "WHERE id IN ("+placeholders+")", args, func(item map[string]interface{}) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return items, nil
 }
 
-func (r *Repository) ListTunnels() ([]map[string]interface{}, error) {
+// ScanNodes calls fn for each node row as it is read, without loading the
+// whole table. Iteration stops at the first error returned by fn.
+func (r *Repository) ScanNodes(fn func(map[string]interface{}) error) error {
+	return r.scanNodesWhere("ScanNodes", "", nil, fn)
+}
+
+func (r *Repository) scanNodesWhere(op, where string, args []interface{}, fn func(map[string]interface{}) error) error {
 	if r == nil || r.db == nil {
-		return nil, errors.New("repository not initialized")
+		return errors.New("repository not initialized")
 	}
 
 	rows, err := r.db.Query(`
-		SELECT id, inx, name, type, flow, traffic_ratio, status, created_time, in_ip
-		FROM tunnel
+		SELECT id, inx, name, server_ip, server_ip_v4, server_ip_v6, port, tcp_listen_addr, udp_listen_addr, version, http, tls, socks, status, is_remote, remote_url, remote_token, remote_config, last_seen_at, last_ip, updated_time
+		FROM node
+		`+where+`
 		ORDER BY inx ASC, id ASC
-	`)
+	`, args...)
 	if err != nil {
-		return nil, err
+		return store.WrapError(op, err)
 	}
 	defer rows.Close()
 
-	tunnelMap := make(map[int64]map[string]interface{})
-	orderedIDs := make([]int64, 0)
-
 	for rows.Next() {
-		var id, inx, flow, createdTime int64
-		var name string
-		var typ, status int
-		var trafficRatio float64
-		var inIP sql.NullString
-		if err := rows.Scan(&id, &inx, &name, &typ, &flow, &trafficRatio, &status, &createdTime, &inIP); err != nil {
-			return nil, err
-		}
-
+		var id, inx int64
+		var name, serverIP, port string
+		var serverIPV4, serverIPV6, tcpListen, udpListen, version, remoteURL, remoteToken, remoteConfig, lastIP sql.NullString
+		var lastSeenAt, updatedTime sql.NullInt64
+		var httpVal, tlsVal, socksVal, status, isRemote int
+
+		if err := rows.Scan(&id, &inx, &name, &serverIP, &serverIPV4, &serverIPV6, &port, &tcpListen, &udpListen, &version, &httpVal, &tlsVal, &socksVal, &status, &isRemote, &remoteURL, &remoteToken, &remoteConfig, &lastSeenAt, &lastIP, &updatedTime); err != nil {
+			return store.WrapError(op, err)
+		}
+		if remoteToken.Valid {
+			if remoteToken.String, err = r.OpenSecret(remoteToken.String); err != nil {
+				return store.WrapError(op, err)
+			}
+		}
+
+		if err := fn(map[string]interface{}{
+			"id":            id,
+			"inx":           inx,
+			"name":          name,
+			"ip":            serverIP,
+			"serverIp":      serverIP,
+			"serverIpV4":    nullableString(serverIPV4),
+			"serverIpV6":    nullableString(serverIPV6),
+			"port":          port,
+			"tcpListenAddr": nullableString(tcpListen),
+			"udpListenAddr": nullableString(udpListen),
+			"version":       nullableString(version),
+			"http":          httpVal,
+			"tls":           tlsVal,
+			"socks":         socksVal,
+			"status":        status,
+			"isRemote":      isRemote,
+			"remoteUrl":     nullableString(remoteURL),
+			"remoteToken":   nullableString(remoteToken),
+			"remoteConfig":  nullableString(remoteConfig),
+			"lastSeenAt":    nullableInt64(lastSeenAt),
+			"lastIp":        nullableString(lastIP),
+			"updatedTime":   nullableInt64(updatedTime),
+		}); err != nil {
+			return store.WrapError(op, err)
+		}
+	}
+
+	return store.WrapError(op, rows.Err())
+}
+
+func (r *Repository) ListUsers() ([]map[string]interface{}, error) {
+	items := make([]map[string]interface{}, 0)
+	err := r.ScanUsers(func(u *User) error {
+		items = append(items, UserListItem(u))
+		return nil
+	})
+	if err != nil {
+		return nil, store.WrapError("ListUsers", err)
+	}
+	return items, nil
+}
+
+// ScanUsers calls fn for each non-admin user as it is read, without loading
+// the whole table. Iteration stops at the first error returned by fn.
+func (r *Repository) ScanUsers(fn func(*User) error) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+
+	rows, err := r.db.Query(`
+		SELECT id, user, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status, COALESCE(permission_mask, 0)
+		FROM user
+		WHERE role_id != 0
+		ORDER BY id ASC
+	`)
+	if err != nil {
+		return store.WrapError("ScanUsers", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.User, &u.RoleID, &u.ExpTime, &u.Flow, &u.InFlow, &u.OutFlow, &u.FlowResetTime, &u.Num, &u.CreatedTime, &u.UpdatedTime, &u.Status, &u.PermissionMask); err != nil {
+			return store.WrapError("ScanUsers", err)
+		}
+		if err := fn(&u); err != nil {
+			return store.WrapError("ScanUsers", err)
+		}
+	}
+
+	return rows.Err()
+}
+
+// SearchUsers returns one page of non-admin users matching filter, in the
+// form of UserListItem, together with the total number of matches. The name
+// filter matches the username; the tunnel filter selects the users assigned
+// to that tunnel.
+func (r *Repository) SearchUsers(filter ListFilter, req PageRequest) (ListPage, error) {
+	conds, args := filter.conds("user")
+	conds = append([]string{`role_id != 0`}, conds...)
+	if filter.TunnelID > 0 {
+		conds = append(conds, `id IN (SELECT user_id FROM user_tunnel WHERE tunnel_id = ?)`)
+		args = append(args, filter.TunnelID)
+	}
+
+	return r.listPage("SearchUsers", "user", strings.Join(conds, " AND "), args, req, []string{"id"}, func(idFilter string, idArgs []interface{}) ([]map[string]interface{}, error) {
+		rows, err := r.db.Query(`
+			SELECT id, user, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status, COALESCE(permission_mask, 0)
+			FROM user
+			WHERE id `+idFilter+`
+			ORDER BY id ASC
+		`, idArgs...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		items := make([]map[string]interface{}, 0)
+		for rows.Next() {
+			var u User
+			if err := rows.Scan(&u.ID, &u.User, &u.RoleID, &u.ExpTime, &u.Flow, &u.InFlow, &u.OutFlow, &u.FlowResetTime, &u.Num, &u.CreatedTime, &u.UpdatedTime, &u.Status, &u.PermissionMask); err != nil {
+				return nil, err
+			}
+			items = append(items, UserListItem(&u))
+		}
+		return items, rows.Err()
+	})
+}
+
+// UserListItem is the user list representation of u; it never includes the
+// password hash.
+func UserListItem(u *User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             u.ID,
+		"user":           u.User,
+		"name":           u.User,
+		"roleId":         u.RoleID,
+		"status":         u.Status,
+		"flow":           u.Flow,
+		"num":            u.Num,
+		"expTime":        u.ExpTime,
+		"flowResetTime":  u.FlowResetTime,
+		"createdTime":    u.CreatedTime,
+		"updatedTime":    nullableInt64(u.UpdatedTime),
+		"inFlow":         u.InFlow,
+		"outFlow":        u.OutFlow,
+		"permissionMask": u.PermissionMask,
+	}
+}
+
+func (r *Repository) ListSpeedLimits() ([]map[string]interface{}, error) {
+	return r.listSpeedLimits("ListSpeedLimits", "", nil)
+}
+
+// listSpeedLimits lists the speed limits, restricted by where when it is
+// not empty.
+func (r *Repository) listSpeedLimits(op, where string, args []interface{}) ([]map[string]interface{}, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+
+	rows, err := r.db.Query(`
+		SELECT id, name, speed, tunnel_id, tunnel_name, status, created_time, updated_time
+		FROM speed_limit
+		`+where+`
+		ORDER BY id ASC
+	`, args...)
+	if err != nil {
+		return nil, store.WrapError(op, err)
+	}
+	defer rows.Close()
+
+	items := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, tunnelID, createdTime int64
+		var name, tunnelName string
+		var speed, status int
+		var updatedTime sql.NullInt64
+		if err := rows.Scan(&id, &name, &speed, &tunnelID, &tunnelName, &status, &createdTime, &updatedTime); err != nil {
+			return nil, store.WrapError(op, err)
+		}
+		items = append(items, map[string]interface{}{
+			"id":          id,
+			"name":        name,
+			"speed":       speed,
+			"tunnelId":    tunnelID,
+			"tunnelName":  tunnelName,
+			"status":      status,
+			"createdTime": createdTime,
+			"updatedTime": nullableInt64(updatedTime),
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError(op, err)
+	}
+	return items, nil
+}
+
+func (r *Repository) ListForwards() ([]map[string]interface{}, error) {
+	return r.listForwards("ListForwards", "", nil)
+}
+
+// listForwards lists the forwards, restricted by where (on forward f) when
+// it is not empty.
+func (r *Repository) listForwards(op, where string, args []interface{}) ([]map[string]interface{}, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+
+	rows, err := r.db.Query(`
+		SELECT f.id, f.user_id, f.user_name, f.name, f.tunnel_id, COALESCE(t.name, ''), f.remote_addr, COALESCE(f.strategy, 'fifo'),
+		       COALESCE(f.protocol, 'tcp'), COALESCE(f.dns_server, ''), COALESCE(f.idle_timeout_sec, 0), f.in_flow, f.out_flow, f.created_time, f.updated_time, f.status, f.inx
+		FROM forward f
+		LEFT JOIN tunnel t ON t.id = f.tunnel_id
+		`+where+`
+		ORDER BY f.inx ASC, f.id ASC
+	`, args...)
+	if err != nil {
+		return nil, store.WrapError(op, err)
+	}
+	defer rows.Close()
+
+	items := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, userID, tunnelID, inFlow, outFlow, createdTime, updatedTime, inx int64
+		var userName, name, tunnelName, remoteAddr, strategy, protocol, dnsServer string
+		var status, idleTimeoutSec int
+
+		if err := rows.Scan(&id, &userID, &userName, &name, &tunnelID, &tunnelName, &remoteAddr, &strategy, &protocol, &dnsServer, &idleTimeoutSec, &inFlow, &outFlow, &createdTime, &updatedTime, &status, &inx); err != nil {
+			return nil, store.WrapError(op, err)
+		}
+
+		inIP, inPort, err := resolveForwardIngress(r.db, id, tunnelID)
+		if err != nil {
+			return nil, store.WrapError(op, err)
+		}
+
+		items = append(items, map[string]interface{}{
+			"id":             id,
+			"userId":         userID,
+			"userName":       userName,
+			"name":           name,
+			"tunnelId":       tunnelID,
+			"tunnelName":     tunnelName,
+			"inIp":           nullableForwardIngress(inIP),
+			"inPort":         nullableInt64(inPort),
+			"remoteAddr":     remoteAddr,
+			"strategy":       strategy,
+			"protocol":       protocol,
+			"dnsServer":      dnsServer,
+			"idleTimeoutSec": idleTimeoutSec,
+			"inFlow":         inFlow,
+			"outFlow":        outFlow,
+			"createdTime":    createdTime,
+			"updatedTime":    updatedTime,
+			"status":         status,
+			"inx":            inx,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError(op, err)
+	}
+	return items, nil
+}
+
+func (r *Repository) ListUserAccessibleTunnels(userID int64) ([]map[string]interface{}, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+
+	rows, err := r.db.Query(`
+		SELECT t.id, t.name
+		FROM user_tunnel ut
+		JOIN tunnel t ON t.id = ut.tunnel_id
+		WHERE ut.user_id = ? AND t.status = 1
+		ORDER BY t.inx ASC, t.id ASC
+	`, userID)
+	if err != nil {
+		return nil, store.WrapError("ListUserAccessibleTunnels", err)
+	}
+	defer rows.Close()
+
+	items := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, store.WrapError("ListUserAccessibleTunnels", err)
+		}
+		items = append(items, map[string]interface{}{"id": id, "name": name})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("ListUserAccessibleTunnels", err)
+	}
+	return items, nil
+}
+
+func (r *Repository) ListEnabledTunnelSummaries() ([]map[string]interface{}, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+
+	rows, err := r.db.Query(`
+		SELECT id, name
+		FROM tunnel
+		WHERE status = 1
+		ORDER BY inx ASC, id ASC
+	`)
+	if err != nil {
+		return nil, store.WrapError("ListEnabledTunnelSummaries", err)
+	}
+	defer rows.Close()
+
+	items := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, store.WrapError("ListEnabledTunnelSummaries", err)
+		}
+		items = append(items, map[string]interface{}{"id": id, "name": name})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("ListEnabledTunnelSummaries", err)
+	}
+	return items, nil
+}
+
+func (r *Repository) ListTunnels() ([]map[string]interface{}, error) {
+	return r.listTunnels("ListTunnels", "", nil)
+}
+
+// listTunnels lists the tunnels whose id matches idFilter, e.g.
+// "IN (SELECT ...)", or all of them when it is empty.
+func (r *Repository) listTunnels(op, idFilter string, args []interface{}) ([]map[string]interface{}, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+
+	tunnelWhere, chainWhere := "", ""
+	if idFilter != "" {
+		tunnelWhere, chainWhere = "WHERE id "+idFilter, "WHERE tunnel_id "+idFilter
+	}
+	rows, err := r.db.Query(`
+		SELECT id, inx, name, type, flow, traffic_ratio, status, created_time, updated_time, in_ip, COALESCE(dscp_mark, 0)
+		FROM tunnel
+		`+tunnelWhere+`
+		ORDER BY inx ASC, id ASC
+	`, args...)
+	if err != nil {
+		return nil, store.WrapError(op, err)
+	}
+	defer rows.Close()
+
+	tunnelMap := make(map[int64]map[string]interface{})
+	orderedIDs := make([]int64, 0)
+
+	for rows.Next() {
+		var id, inx, flow, createdTime, updatedTime int64
+		var name string
+		var typ, status, dscpMark int
+		var trafficRatio float64
+		var inIP sql.NullString
+		if err := rows.Scan(&id, &inx, &name, &typ, &flow, &trafficRatio, &status, &createdTime, &updatedTime, &inIP, &dscpMark); err != nil {
+			return nil, store.WrapError(op, err)
+		}
+
 		tunnelMap[id] = map[string]interface{}{
 			"id":           id,
 			"inx":          inx,
@@ -880,7 +1870,9 @@ func (r *Repository) ListTunnels() ([]map[string]interface{}, error) {
 			"trafficRatio": trafficRatio,
 			"status":       status,
 			"createdTime":  createdTime,
+			"updatedTime":  updatedTime,
 			"inIp":         nullableString(inIP),
+			"dscpMark":     dscpMark,
 			"inNodeId":     make([]map[string]interface{}, 0),
 			"outNodeId":    make([]map[string]interface{}, 0),
 			"chainNodes":   make([][]map[string]interface{}, 0),
@@ -888,7 +1880,7 @@ func (r *Repository) ListTunnels() ([]map[string]interface{}, error) {
 		orderedIDs = append(orderedIDs, id)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError(op, err)
 	}
 
 	nodeIPMap := map[int64]string{}
@@ -907,10 +1899,11 @@ func (r *Repository) ListTunnels() ([]map[string]interface{}, error) {
 	chainRows, err := r.db.Query(`
 		SELECT tunnel_id, CAST(chain_type AS INTEGER), node_id, protocol, strategy, COALESCE(inx, 0)
 		FROM chain_tunnel
+		`+chainWhere+`
 		ORDER BY tunnel_id ASC, CAST(chain_type AS INTEGER) ASC, inx ASC, id ASC
-	`)
+	`, args...)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError(op, err)
 	}
 	defer chainRows.Close()
 
@@ -922,7 +1915,7 @@ func (r *Repository) ListTunnels() ([]map[string]interface{}, error) {
 		var chainType int
 		var protocol, strategy sql.NullString
 		if err := chainRows.Scan(&tunnelID, &chainType, &nodeID, &protocol, &strategy, &inx); err != nil {
-			return nil, err
+			return nil, store.WrapError(op, err)
 		}
 
 		t, ok := tunnelMap[tunnelID]
@@ -943,7 +1936,7 @@ func (r *Repository) ListTunnels() ([]map[string]interface{}, error) {
 		}
 
 		switch chainType {
-		case 1:
+		case 0, 1:
 			t["inNodeId"] = append(t["inNodeId"].([]map[string]interface{}), nodeObj)
 			if ip, ok := nodeIPMap[nodeID]; ok && ip != "" {
 				inNodeIPs[tunnelID] = append(inNodeIPs[tunnelID], ip)
@@ -958,7 +1951,7 @@ func (r *Repository) ListTunnels() ([]map[string]interface{}, error) {
 		}
 	}
 	if err := chainRows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError(op, err)
 	}
 
 	for tunnelID, groups := range chainBucket {
@@ -1000,7 +1993,7 @@ func (r *Repository) ListTunnelGroups() ([]map[string]interface{}, error) {
 
 	rows, err := r.db.Query(`SELECT id, name, status, created_time FROM tunnel_group ORDER BY id ASC`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("ListTunnelGroups", err)
 	}
 	defer rows.Close()
 
@@ -1010,12 +2003,12 @@ func (r *Repository) ListTunnelGroups() ([]map[string]interface{}, error) {
 		var name string
 		var status int
 		if err := rows.Scan(&id, &name, &status, &createdTime); err != nil {
-			return nil, err
+			return nil, store.WrapError("ListTunnelGroups", err)
 		}
 
 		ids, names, err := r.listTunnelGroupMembers(id)
 		if err != nil {
-			return nil, err
+			return nil, store.WrapError("ListTunnelGroups", err)
 		}
 
 		result = append(result, map[string]interface{}{
@@ -1028,7 +2021,7 @@ func (r *Repository) ListTunnelGroups() ([]map[string]interface{}, error) {
 		})
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError("ListTunnelGroups", err)
 	}
 	return result, nil
 }
@@ -1040,7 +2033,7 @@ func (r *Repository) ListUserGroups() ([]map[string]interface{}, error) {
 
 	rows, err := r.db.Query(`SELECT id, name, status, created_time FROM user_group ORDER BY id ASC`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("ListUserGroups", err)
 	}
 	defer rows.Close()
 
@@ -1050,12 +2043,12 @@ func (r *Repository) ListUserGroups() ([]map[string]interface{}, error) {
 		var name string
 		var status int
 		if err := rows.Scan(&id, &name, &status, &createdTime); err != nil {
-			return nil, err
+			return nil, store.WrapError("ListUserGroups", err)
 		}
 
 		ids, names, err := r.listUserGroupMembers(id)
 		if err != nil {
-			return nil, err
+			return nil, store.WrapError("ListUserGroups", err)
 		}
 
 		result = append(result, map[string]interface{}{
@@ -1068,7 +2061,7 @@ func (r *Repository) ListUserGroups() ([]map[string]interface{}, error) {
 		})
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError("ListUserGroups", err)
 	}
 	return result, nil
 }
@@ -1086,7 +2079,7 @@ func (r *Repository) ListGroupPermissions() ([]map[string]interface{}, error) {
 		ORDER BY gp.id ASC
 	`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("ListGroupPermissions", err)
 	}
 	defer rows.Close()
 
@@ -1095,7 +2088,7 @@ func (r *Repository) ListGroupPermissions() ([]map[string]interface{}, error) {
 		var id, userGroupID, tunnelGroupID, createdTime int64
 		var userGroupName, tunnelGroupName sql.NullString
 		if err := rows.Scan(&id, &userGroupID, &userGroupName, &tunnelGroupID, &tunnelGroupName, &createdTime); err != nil {
-			return nil, err
+			return nil, store.WrapError("ListGroupPermissions", err)
 		}
 
 		result = append(result, map[string]interface{}{
@@ -1108,7 +2101,7 @@ func (r *Repository) ListGroupPermissions() ([]map[string]interface{}, error) {
 		})
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError("ListGroupPermissions", err)
 	}
 	return result, nil
 }
@@ -1122,7 +2115,7 @@ func (r *Repository) listTunnelGroupMembers(groupID int64) ([]int64, []string, e
 		ORDER BY t.id ASC
 	`, groupID)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, store.WrapError("listTunnelGroupMembers", err)
 	}
 	defer rows.Close()
 
@@ -1132,13 +2125,13 @@ func (r *Repository) listTunnelGroupMembers(groupID int64) ([]int64, []string, e
 		var id int64
 		var name string
 		if err := rows.Scan(&id, &name); err != nil {
-			return nil, nil, err
+			return nil, nil, store.WrapError("listTunnelGroupMembers", err)
 		}
 		ids = append(ids, id)
 		names = append(names, name)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, nil, err
+		return nil, nil, store.WrapError("listTunnelGroupMembers", err)
 	}
 	return ids, names, nil
 }
@@ -1152,7 +2145,7 @@ func (r *Repository) listUserGroupMembers(groupID int64) ([]int64, []string, err
 		ORDER BY u.id ASC
 	`, groupID)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, store.WrapError("listUserGroupMembers", err)
 	}
 	defer rows.Close()
 
@@ -1162,26 +2155,99 @@ func (r *Repository) listUserGroupMembers(groupID int64) ([]int64, []string, err
 		var id int64
 		var name string
 		if err := rows.Scan(&id, &name); err != nil {
-			return nil, nil, err
+			return nil, nil, store.WrapError("listUserGroupMembers", err)
 		}
 		ids = append(ids, id)
 		names = append(names, name)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, nil, err
+		return nil, nil, store.WrapError("listUserGroupMembers", err)
 	}
 	return ids, names, nil
 }
 
-func nullableString(v sql.NullString) interface{} {
-	if v.Valid {
-		return v.String
+// GetUserGroupID returns the user group the user belongs to, or 0 when the
+// user is in no group. A user in several groups is counted against the one
+// with the lowest id.
+func (r *Repository) GetUserGroupID(userID int64) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
 	}
-	return nil
+	var groupID int64
+	err := r.db.QueryRow(`SELECT COALESCE(MIN(user_group_id), 0) FROM user_group_user WHERE user_id = ?`, userID).Scan(&groupID)
+	if err != nil {
+		return 0, store.WrapError("GetUserGroupID", err)
+	}
+	return groupID, nil
 }
 
-func nullableForwardIngress(v string) interface{} {
-	v = strings.TrimSpace(v)
+// SetGroupTunnelQuota caps how many forwards members of a user group may
+// have on a tunnel. A maxForwards of 0 removes the cap.
+func (r *Repository) SetGroupTunnelQuota(groupID, tunnelID int64, maxForwards int, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	if maxForwards <= 0 {
+		_, err := r.db.Exec(`DELETE FROM group_tunnel_quota WHERE group_id = ? AND tunnel_id = ?`, groupID, tunnelID)
+		return store.WrapError("SetGroupTunnelQuota", err)
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO group_tunnel_quota(group_id, tunnel_id, max_forwards, updated_time)
+		VALUES(?, ?, ?, ?)
+		ON CONFLICT(group_id, tunnel_id) DO UPDATE SET
+			max_forwards = excluded.max_forwards,
+			updated_time = excluded.updated_time
+	`, groupID, tunnelID, maxForwards, now)
+	return store.WrapError("SetGroupTunnelQuota", err)
+}
+
+// CheckGroupForwardQuota returns how many forwards members of the group
+// currently have on the tunnel and the group's cap there. max is 0 when the
+// group has no quota on the tunnel.
+func (r *Repository) CheckGroupForwardQuota(groupID, tunnelID int64) (current, max int, err error) {
+	if r == nil || r.db == nil {
+		return 0, 0, errors.New("repository not initialized")
+	}
+	err = r.db.QueryRow(`SELECT max_forwards FROM group_tunnel_quota WHERE group_id = ? AND tunnel_id = ?`, groupID, tunnelID).Scan(&max)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, store.WrapError("CheckGroupForwardQuota", err)
+	}
+	err = r.db.QueryRow(`
+		SELECT COUNT(1)
+		FROM forward f
+		JOIN user_group_user ugu ON ugu.user_id = f.user_id
+		WHERE ugu.user_group_id = ? AND f.tunnel_id = ?
+	`, groupID, tunnelID).Scan(&current)
+	if err != nil {
+		return 0, 0, store.WrapError("CheckGroupForwardQuota", err)
+	}
+	return current, max, nil
+}
+
+func nullableString(v sql.NullString) interface{} {
+	if v.Valid {
+		return v.String
+	}
+	return nil
+}
+
+// escapeLike escapes LIKE wildcards in v for use with ESCAPE '\'.
+func escapeLike(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(v)
+}
+
+func nullableText(v string) interface{} {
+	if strings.TrimSpace(v) == "" {
+		return nil
+	}
+	return v
+}
+
+func nullableForwardIngress(v string) interface{} {
+	v = strings.TrimSpace(v)
 	if v == "" {
 		return nil
 	}
@@ -1305,7 +2371,65 @@ func bootstrapSchema(db *store.DB, schemaSQL, seedSQL string) error {
 	return nil
 }
 
-const currentSchemaVersion = 2
+// PanelIDConfigKey names the vite_config entry holding this panel's identity.
+// It is sent as X-Consumer-ID when the panel consumes a remote peer share.
+const PanelIDConfigKey = "panel_id"
+
+// ensurePanelID generates the panel identity on first run.
+func ensurePanelID(db *store.DB) error {
+	var existing string
+	err := db.QueryRow(`SELECT value FROM vite_config WHERE name = ? LIMIT 1`, PanelIDConfigKey).Scan(&existing)
+	if err == nil && strings.TrimSpace(existing) != "" {
+		return nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("read panel id: %w", err)
+	}
+	id, err := newUUID()
+	if err != nil {
+		return fmt.Errorf("generate panel id: %w", err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO vite_config(name, value, time)
+		VALUES(?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET value=excluded.value, time=excluded.time
+	`, PanelIDConfigKey, id, unixMilliNow()); err != nil {
+		return fmt.Errorf("store panel id: %w", err)
+	}
+	return nil
+}
+
+// InitDefaultConfigs inserts every key of defaults that vite_config does not
+// have yet. Keys that are already set keep their value.
+func (r *Repository) InitDefaultConfigs(defaults map[string]string) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	now := unixMilliNow()
+	for name, value := range defaults {
+		if _, err := r.db.Exec(`INSERT OR IGNORE INTO vite_config(name, value, time) VALUES(?, ?, ?)`, name, value, now); err != nil {
+			return store.WrapError("InitDefaultConfigs", fmt.Errorf("seed config %s: %w", name, err))
+		}
+		r.configs.Invalidate(name)
+	}
+	return nil
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+const currentSchemaVersion = 19
+
+// Flow quotas on users and user tunnels are stored in GB; traffic counters in bytes.
+const bytesPerGB int64 = 1024 * 1024 * 1024
 
 var ensurePostgresIDDefaultsFn = ensurePostgresIDDefaults
 
@@ -1338,17 +2462,20 @@ func migrateSchema(db *store.DB) error {
 		return nil
 	}
 
-	ensureColumn := func(table, col, typ string) {
+	ensureColumn := func(table, col, typ string) bool {
 		var dummy interface{}
 		err := db.QueryRow(fmt.Sprintf("SELECT %s FROM %s LIMIT 1", col, table)).Scan(&dummy)
 		if err == nil || errors.Is(err, sql.ErrNoRows) {
-			return
+			return false
 		}
 		if isMissingColumnError(db.Dialect(), err) {
 			if _, alterErr := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, col, typ)); alterErr != nil {
 				log.Printf("failed to add column %s to %s: %v", col, table, alterErr)
+				return false
 			}
+			return true
 		}
+		return false
 	}
 
 	columnsByTable := map[string]map[string]string{
@@ -1356,29 +2483,87 @@ func migrateSchema(db *store.DB) error {
 			"allowed_domains": "TEXT DEFAULT ''",
 			"allowed_ips":     "TEXT DEFAULT ''",
 		},
+		"user": {
+			"totp_enabled":        "INTEGER NOT NULL DEFAULT 0",
+			"totp_secret":         "TEXT NOT NULL DEFAULT ''",
+			"permission_mask":     "BIGINT NOT NULL DEFAULT 0",
+			"email":               "VARCHAR(255) NOT NULL DEFAULT ''",
+			"tokens_revoked_time": "BIGINT NOT NULL DEFAULT 0",
+		},
+		"user_notification_pref": {
+			"security_email_enabled": "INTEGER NOT NULL DEFAULT 0",
+			"security_webhook_url":   "TEXT NOT NULL DEFAULT ''",
+		},
+		"refresh_token": {
+			"device":         "VARCHAR(100) NOT NULL DEFAULT ''",
+			"ip":             "VARCHAR(64) NOT NULL DEFAULT ''",
+			"user_agent":     "VARCHAR(512) NOT NULL DEFAULT ''",
+			"last_seen_time": "BIGINT NOT NULL DEFAULT 0",
+		},
+		"peer_share_runtime": {
+			"consumer_id": "TEXT NOT NULL DEFAULT ''",
+		},
 		"node": {
-			"server_ip_v4":  "VARCHAR(100)",
-			"server_ip_v6":  "VARCHAR(100)",
-			"inx":           "INTEGER NOT NULL DEFAULT 0",
-			"is_remote":     "INTEGER DEFAULT 0",
-			"remote_url":    "TEXT",
-			"remote_token":  "TEXT",
-			"remote_config": "TEXT",
+			"server_ip_v4":       "VARCHAR(100)",
+			"server_ip_v6":       "VARCHAR(100)",
+			"inx":                "INTEGER NOT NULL DEFAULT 0",
+			"is_remote":          "INTEGER DEFAULT 0",
+			"remote_url":         "TEXT",
+			"remote_token":       "TEXT",
+			"remote_config":      "TEXT",
+			"last_seen_at":       "BIGINT",
+			"last_ip":            "VARCHAR(100)",
+			"callback_url":       "TEXT",
+			"client_cert":        "TEXT",
+			"client_cert_serial": "VARCHAR(64)",
+			"client_cert_expire": "BIGINT",
 		},
 		"tunnel": {
-			"inx": "INTEGER NOT NULL DEFAULT 0",
+			"inx":       "INTEGER NOT NULL DEFAULT 0",
+			"dscp_mark": "INTEGER NOT NULL DEFAULT 0",
 		},
 		"forward": {
-			"inx": "INTEGER NOT NULL DEFAULT 0",
+			"inx":              "INTEGER NOT NULL DEFAULT 0",
+			"protocol":         "VARCHAR(10) NOT NULL DEFAULT 'tcp'",
+			"dns_server":       "VARCHAR(100)",
+			"idle_timeout_sec": "INTEGER NOT NULL DEFAULT 0",
+			"service_revision": "INTEGER NOT NULL DEFAULT 0",
 		},
 		"chain_tunnel": {
 			"inx": "INTEGER",
 		},
 	}
 
+	added := make(map[string]bool)
 	for table, columns := range columnsByTable {
 		for col, typ := range columns {
-			ensureColumn(table, col, typ)
+			if ensureColumn(table, col, typ) {
+				added[table+"."+col] = true
+			}
+		}
+	}
+
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_peer_share_runtime_share_consumer ON peer_share_runtime(share_id, consumer_id, status)`); err != nil {
+		return fmt.Errorf("index peer_share_runtime.consumer_id: %w", err)
+	}
+
+	// Older panels never enforced unique usernames, so existing duplicates
+	// must not block startup; they keep working without the index.
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_user_username_unique ON user(user)`); err != nil {
+		log.Printf("failed to create unique index on user.user: %v", err)
+	}
+
+	// Addresses were only checked for duplicates by the application before
+	// this index; a panel that already holds duplicates keeps starting.
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_user_email_unique ON user(email) WHERE email != ''`); err != nil {
+		log.Printf("failed to create unique index on user.email: %v", err)
+	}
+
+	// Forwards created before the protocol column always listened on both
+	// TCP and UDP; keep them that way instead of falling back to the default.
+	if added["forward.protocol"] {
+		if _, err := db.Exec(`UPDATE forward SET protocol = 'both'`); err != nil {
+			return fmt.Errorf("backfill forward.protocol: %w", err)
 		}
 	}
 
@@ -1403,6 +2588,13 @@ func migrateSchema(db *store.DB) error {
 		return err
 	}
 
+	if err := replaceSearchIndexTriggers(db); err != nil {
+		return err
+	}
+	if err := rebuildSearchIndex(db); err != nil {
+		return err
+	}
+
 	setSchemaVersion(db, currentSchemaVersion)
 	return nil
 }
@@ -1586,8 +2778,8 @@ func (r *Repository) CreatePeerShare(share *PeerShare) error {
 	_, err := r.db.Exec(`
 		INSERT INTO peer_share(name, node_id, token, max_bandwidth, expiry_time, port_range_start, port_range_end, current_flow, is_active, created_time, updated_time, allowed_domains, allowed_ips)
 		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, share.Name, share.NodeID, share.Token, share.MaxBandwidth, share.ExpiryTime, share.PortRangeStart, share.PortRangeEnd, share.CurrentFlow, share.IsActive, share.CreatedTime, share.UpdatedTime, share.AllowedDomains, share.AllowedIPs)
-	return err
+	`, share.Name, share.NodeID, r.SealSecret(share.Token), share.MaxBandwidth, share.ExpiryTime, share.PortRangeStart, share.PortRangeEnd, share.CurrentFlow, share.IsActive, share.CreatedTime, share.UpdatedTime, share.AllowedDomains, share.AllowedIPs)
+	return store.WrapError("CreatePeerShare", err)
 }
 
 func (r *Repository) UpdatePeerShare(share *PeerShare) error {
@@ -1598,7 +2790,7 @@ func (r *Repository) UpdatePeerShare(share *PeerShare) error {
 		UPDATE peer_share SET name=?, max_bandwidth=?, expiry_time=?, port_range_start=?, port_range_end=?, is_active=?, updated_time=?, allowed_domains=?, allowed_ips=?
 		WHERE id=?
 	`, share.Name, share.MaxBandwidth, share.ExpiryTime, share.PortRangeStart, share.PortRangeEnd, share.IsActive, share.UpdatedTime, share.AllowedDomains, share.AllowedIPs, share.ID)
-	return err
+	return store.WrapError("UpdatePeerShare", err)
 }
 
 func (r *Repository) DeletePeerShare(id int64) error {
@@ -1607,12 +2799,13 @@ func (r *Repository) DeletePeerShare(id int64) error {
 	}
 	tx, err := r.db.Begin()
 	if err != nil {
-		return err
+		return store.WrapError("DeletePeerShare", err)
 	}
 	defer func() { _ = tx.Rollback() }()
 	_, _ = tx.Exec(`DELETE FROM peer_share_runtime WHERE share_id = ?`, id)
+	_, _ = tx.Exec(`DELETE FROM peer_share_consumer WHERE share_id = ?`, id)
 	if _, err := tx.Exec(`DELETE FROM peer_share WHERE id=?`, id); err != nil {
-		return err
+		return store.WrapError("DeletePeerShare", err)
 	}
 	return tx.Commit()
 }
@@ -1627,7 +2820,10 @@ func (r *Repository) GetPeerShare(id int64) (*PeerShare, error) {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
-		return nil, err
+		return nil, store.WrapError("GetPeerShare", err)
+	}
+	if err := r.openPeerShare(&s); err != nil {
+		return nil, store.WrapError("GetPeerShare", err)
 	}
 	return &s, nil
 }
@@ -1636,13 +2832,48 @@ func (r *Repository) GetPeerShareByToken(token string) (*PeerShare, error) {
 	if r == nil || r.db == nil {
 		return nil, errors.New("repository not initialized")
 	}
-	row := r.db.QueryRow(`SELECT id, name, node_id, token, max_bandwidth, expiry_time, port_range_start, port_range_end, current_flow, is_active, created_time, updated_time, allowed_domains, allowed_ips FROM peer_share WHERE token = ?`, token)
+	sealed, plain := r.secretLookup(token)
+	row := r.db.QueryRow(`SELECT id, name, node_id, token, max_bandwidth, expiry_time, port_range_start, port_range_end, current_flow, is_active, created_time, updated_time, allowed_domains, allowed_ips FROM peer_share WHERE token IN (?, ?)`, sealed, plain)
 	var s PeerShare
 	if err := row.Scan(&s.ID, &s.Name, &s.NodeID, &s.Token, &s.MaxBandwidth, &s.ExpiryTime, &s.PortRangeStart, &s.PortRangeEnd, &s.CurrentFlow, &s.IsActive, &s.CreatedTime, &s.UpdatedTime, &s.AllowedDomains, &s.AllowedIPs); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
-		return nil, err
+		return nil, store.WrapError("GetPeerShareByToken", err)
+	}
+	if err := r.openPeerShare(&s); err != nil {
+		return nil, store.WrapError("GetPeerShareByToken", err)
+	}
+	return &s, nil
+}
+
+// FindPeerSharePortConflict returns an active share on nodeID, other than
+// excludeShareID, whose port range overlaps portStart-portEnd, or nil when
+// there is none. Shares without a port range never conflict.
+func (r *Repository) FindPeerSharePortConflict(nodeID int64, portStart, portEnd int, excludeShareID int64) (*PeerShare, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	if portEnd <= 0 {
+		return nil, nil
+	}
+	row := r.db.QueryRow(`
+		SELECT id, name, node_id, token, max_bandwidth, expiry_time, port_range_start, port_range_end, current_flow, is_active, created_time, updated_time, allowed_domains, allowed_ips
+		FROM peer_share
+		WHERE node_id = ? AND is_active = 1 AND id != ? AND port_range_end > 0
+		  AND NOT (port_range_end < ? OR port_range_start > ?)
+		ORDER BY id ASC
+		LIMIT 1
+	`, nodeID, excludeShareID, portStart, portEnd)
+	var s PeerShare
+	if err := row.Scan(&s.ID, &s.Name, &s.NodeID, &s.Token, &s.MaxBandwidth, &s.ExpiryTime, &s.PortRangeStart, &s.PortRangeEnd, &s.CurrentFlow, &s.IsActive, &s.CreatedTime, &s.UpdatedTime, &s.AllowedDomains, &s.AllowedIPs); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, store.WrapError("FindPeerSharePortConflict", err)
+	}
+	if err := r.openPeerShare(&s); err != nil {
+		return nil, store.WrapError("FindPeerSharePortConflict", err)
 	}
 	return &s, nil
 }
@@ -1653,7 +2884,7 @@ func (r *Repository) ListPeerShares() ([]PeerShare, error) {
 	}
 	rows, err := r.db.Query(`SELECT id, name, node_id, token, max_bandwidth, expiry_time, port_range_start, port_range_end, current_flow, is_active, created_time, updated_time, allowed_domains, allowed_ips FROM peer_share ORDER BY id DESC`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("ListPeerShares", err)
 	}
 	defer rows.Close()
 
@@ -1661,71 +2892,180 @@ func (r *Repository) ListPeerShares() ([]PeerShare, error) {
 	for rows.Next() {
 		var s PeerShare
 		if err := rows.Scan(&s.ID, &s.Name, &s.NodeID, &s.Token, &s.MaxBandwidth, &s.ExpiryTime, &s.PortRangeStart, &s.PortRangeEnd, &s.CurrentFlow, &s.IsActive, &s.CreatedTime, &s.UpdatedTime, &s.AllowedDomains, &s.AllowedIPs); err != nil {
-			return nil, err
+			return nil, store.WrapError("ListPeerShares", err)
+		}
+		if err := r.openPeerShare(&s); err != nil {
+			return nil, store.WrapError("ListPeerShares", err)
 		}
 		shares = append(shares, s)
 	}
 	return shares, nil
 }
 
-func (r *Repository) GetPeerShareRuntimeByResourceKey(shareID int64, resourceKey string) (*PeerShareRuntime, error) {
+// PeerShareImport is a share copied from another panel, with the binding
+// IDs of the consumer tunnels that were using it.
+type PeerShareImport struct {
+	Share      PeerShare
+	BindingIDs []string
+}
+
+// ImportPeerShares upserts shares by token in one transaction and points the
+// runtime rows of their binding IDs back at them, re-activating those rows.
+// It returns how many shares were inserted and how many were updated.
+func (r *Repository) ImportPeerShares(items []PeerShareImport) (created int, updated int, err error) {
+	if r == nil || r.db == nil {
+		return 0, 0, errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, 0, store.WrapError("ImportPeerShares", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, item := range items {
+		s := item.Share
+		var id int64
+		sealed, plain := r.secretLookup(s.Token)
+		err := tx.QueryRow(`SELECT id FROM peer_share WHERE token IN (?, ?)`, sealed, plain).Scan(&id)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			id, err = tx.ExecReturningID(`
+				INSERT INTO peer_share(name, node_id, token, max_bandwidth, expiry_time, port_range_start, port_range_end, current_flow, is_active, created_time, updated_time, allowed_domains, allowed_ips)
+				VALUES(?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?)
+			`, s.Name, s.NodeID, sealed, s.MaxBandwidth, s.ExpiryTime, s.PortRangeStart, s.PortRangeEnd, s.IsActive, s.CreatedTime, s.UpdatedTime, s.AllowedDomains, s.AllowedIPs)
+			if err != nil {
+				return 0, 0, store.WrapError("ImportPeerShares", err)
+			}
+			created++
+		case err != nil:
+			return 0, 0, store.WrapError("ImportPeerShares", err)
+		default:
+			if _, err := tx.Exec(`
+				UPDATE peer_share SET name=?, node_id=?, max_bandwidth=?, expiry_time=?, port_range_start=?, port_range_end=?, is_active=?, updated_time=?, allowed_domains=?, allowed_ips=?
+				WHERE id=?
+			`, s.Name, s.NodeID, s.MaxBandwidth, s.ExpiryTime, s.PortRangeStart, s.PortRangeEnd, s.IsActive, s.UpdatedTime, s.AllowedDomains, s.AllowedIPs, id); err != nil {
+				return 0, 0, store.WrapError("ImportPeerShares", err)
+			}
+			updated++
+		}
+
+		for _, bindingID := range item.BindingIDs {
+			if strings.TrimSpace(bindingID) == "" {
+				continue
+			}
+			if _, err := tx.Exec(`
+				UPDATE peer_share_runtime SET share_id = ?, status = 1, updated_time = ?
+				WHERE binding_id = ? AND node_id = ?
+			`, id, s.UpdatedTime, bindingID, s.NodeID); err != nil {
+				return 0, 0, store.WrapError("ImportPeerShares", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, store.WrapError("ImportPeerShares", err)
+	}
+	return created, updated, nil
+}
+
+// ListPeerShareBindingIDs returns the distinct non-empty binding IDs of the
+// share's runtime rows.
+func (r *Repository) ListPeerShareBindingIDs(shareID int64) ([]string, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`SELECT DISTINCT binding_id FROM peer_share_runtime WHERE share_id = ? AND binding_id != '' ORDER BY binding_id ASC`, shareID)
+	if err != nil {
+		return nil, store.WrapError("ListPeerShareBindingIDs", err)
+	}
+	defer rows.Close()
+	out := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, store.WrapError("ListPeerShareBindingIDs", err)
+		}
+		out = append(out, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("ListPeerShareBindingIDs", err)
+	}
+	return out, nil
+}
+
+const peerShareRuntimeColumns = `id, share_id, node_id, consumer_id, reservation_id, resource_key, binding_id, role, chain_name, service_name, protocol, strategy, port, target, applied, status, created_time, updated_time`
+
+type peerShareRuntimeScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPeerShareRuntime(row peerShareRuntimeScanner) (*PeerShareRuntime, error) {
+	var item PeerShareRuntime
+	if err := row.Scan(&item.ID, &item.ShareID, &item.NodeID, &item.ConsumerID, &item.ReservationID, &item.ResourceKey, &item.BindingID, &item.Role, &item.ChainName, &item.ServiceName, &item.Protocol, &item.Strategy, &item.Port, &item.Target, &item.Applied, &item.Status, &item.CreatedTime, &item.UpdatedTime); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (r *Repository) GetPeerShareRuntimeByResourceKey(shareID int64, consumerID string, resourceKey string) (*PeerShareRuntime, error) {
 	if r == nil || r.db == nil {
 		return nil, errors.New("repository not initialized")
 	}
 	row := r.db.QueryRow(`
-		SELECT id, share_id, node_id, reservation_id, resource_key, binding_id, role, chain_name, service_name, protocol, strategy, port, target, applied, status, created_time, updated_time
+		SELECT `+peerShareRuntimeColumns+`
 		FROM peer_share_runtime
-		WHERE share_id = ? AND resource_key = ?
+		WHERE share_id = ? AND consumer_id = ? AND resource_key = ?
 		LIMIT 1
-	`, shareID, resourceKey)
-	var item PeerShareRuntime
-	if err := row.Scan(&item.ID, &item.ShareID, &item.NodeID, &item.ReservationID, &item.ResourceKey, &item.BindingID, &item.Role, &item.ChainName, &item.ServiceName, &item.Protocol, &item.Strategy, &item.Port, &item.Target, &item.Applied, &item.Status, &item.CreatedTime, &item.UpdatedTime); err != nil {
+	`, shareID, consumerID, resourceKey)
+	item, err := scanPeerShareRuntime(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
-		return nil, err
+		return nil, store.WrapError("GetPeerShareRuntimeByResourceKey", err)
 	}
-	return &item, nil
+	return item, nil
 }
 
-func (r *Repository) GetPeerShareRuntimeByReservationID(shareID int64, reservationID string) (*PeerShareRuntime, error) {
+// Reservation and binding IDs are unique per provider, so rows created before
+// consumers identified themselves (empty consumer_id) are still reachable.
+func (r *Repository) GetPeerShareRuntimeByReservationID(shareID int64, consumerID string, reservationID string) (*PeerShareRuntime, error) {
 	if r == nil || r.db == nil {
 		return nil, errors.New("repository not initialized")
 	}
 	row := r.db.QueryRow(`
-		SELECT id, share_id, node_id, reservation_id, resource_key, binding_id, role, chain_name, service_name, protocol, strategy, port, target, applied, status, created_time, updated_time
+		SELECT `+peerShareRuntimeColumns+`
 		FROM peer_share_runtime
-		WHERE share_id = ? AND reservation_id = ?
+		WHERE share_id = ? AND consumer_id IN (?, '') AND reservation_id = ?
 		LIMIT 1
-	`, shareID, reservationID)
-	var item PeerShareRuntime
-	if err := row.Scan(&item.ID, &item.ShareID, &item.NodeID, &item.ReservationID, &item.ResourceKey, &item.BindingID, &item.Role, &item.ChainName, &item.ServiceName, &item.Protocol, &item.Strategy, &item.Port, &item.Target, &item.Applied, &item.Status, &item.CreatedTime, &item.UpdatedTime); err != nil {
+	`, shareID, consumerID, reservationID)
+	item, err := scanPeerShareRuntime(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
-		return nil, err
+		return nil, store.WrapError("GetPeerShareRuntimeByReservationID", err)
 	}
-	return &item, nil
+	return item, nil
 }
 
-func (r *Repository) GetPeerShareRuntimeByBindingID(shareID int64, bindingID string) (*PeerShareRuntime, error) {
+func (r *Repository) GetPeerShareRuntimeByBindingID(shareID int64, consumerID string, bindingID string) (*PeerShareRuntime, error) {
 	if r == nil || r.db == nil {
 		return nil, errors.New("repository not initialized")
 	}
 	row := r.db.QueryRow(`
-		SELECT id, share_id, node_id, reservation_id, resource_key, binding_id, role, chain_name, service_name, protocol, strategy, port, target, applied, status, created_time, updated_time
+		SELECT `+peerShareRuntimeColumns+`
 		FROM peer_share_runtime
-		WHERE share_id = ? AND binding_id = ?
+		WHERE share_id = ? AND consumer_id IN (?, '') AND binding_id = ?
 		LIMIT 1
-	`, shareID, bindingID)
-	var item PeerShareRuntime
-	if err := row.Scan(&item.ID, &item.ShareID, &item.NodeID, &item.ReservationID, &item.ResourceKey, &item.BindingID, &item.Role, &item.ChainName, &item.ServiceName, &item.Protocol, &item.Strategy, &item.Port, &item.Target, &item.Applied, &item.Status, &item.CreatedTime, &item.UpdatedTime); err != nil {
+	`, shareID, consumerID, bindingID)
+	item, err := scanPeerShareRuntime(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
-		return nil, err
+		return nil, store.WrapError("GetPeerShareRuntimeByBindingID", err)
 	}
-	return &item, nil
+	return item, nil
 }
 
 func (r *Repository) GetPeerShareRuntimeByID(id int64) (*PeerShareRuntime, error) {
@@ -1733,19 +3073,87 @@ func (r *Repository) GetPeerShareRuntimeByID(id int64) (*PeerShareRuntime, error
 		return nil, errors.New("repository not initialized")
 	}
 	row := r.db.QueryRow(`
-		SELECT id, share_id, node_id, reservation_id, resource_key, binding_id, role, chain_name, service_name, protocol, strategy, port, target, applied, status, created_time, updated_time
+		SELECT `+peerShareRuntimeColumns+`
 		FROM peer_share_runtime
 		WHERE id = ?
 		LIMIT 1
 	`, id)
-	var item PeerShareRuntime
-	if err := row.Scan(&item.ID, &item.ShareID, &item.NodeID, &item.ReservationID, &item.ResourceKey, &item.BindingID, &item.Role, &item.ChainName, &item.ServiceName, &item.Protocol, &item.Strategy, &item.Port, &item.Target, &item.Applied, &item.Status, &item.CreatedTime, &item.UpdatedTime); err != nil {
+	item, err := scanPeerShareRuntime(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
-		return nil, err
+		return nil, store.WrapError("GetPeerShareRuntimeByID", err)
 	}
-	return &item, nil
+	return item, nil
+}
+
+// ListPeerShareRuntimes returns the runtime rows one consumer panel holds on a
+// share. Consumers importing the same share never see each other's rows.
+func (r *Repository) ListPeerShareRuntimes(shareID int64, consumerID string) ([]PeerShareRuntime, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`
+		SELECT `+peerShareRuntimeColumns+`
+		FROM peer_share_runtime
+		WHERE share_id = ? AND consumer_id = ?
+		ORDER BY id ASC
+	`, shareID, consumerID)
+	if err != nil {
+		return nil, store.WrapError("ListPeerShareRuntimes", err)
+	}
+	defer rows.Close()
+
+	out := make([]PeerShareRuntime, 0)
+	for rows.Next() {
+		item, err := scanPeerShareRuntime(rows)
+		if err != nil {
+			return nil, store.WrapError("ListPeerShareRuntimes", err)
+		}
+		out = append(out, *item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("ListPeerShareRuntimes", err)
+	}
+	return out, nil
+}
+
+// ListPeerShareRuntimesPage returns one page of a share's runtime rows from
+// every consumer, newest first, with the total row count.
+func (r *Repository) ListPeerShareRuntimesPage(shareID int64, page, pageSize int) ([]PeerShareRuntime, int, error) {
+	if r == nil || r.db == nil {
+		return nil, 0, errors.New("repository not initialized")
+	}
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(1) FROM peer_share_runtime WHERE share_id = ?`, shareID).Scan(&total); err != nil {
+		return nil, 0, store.WrapError("ListPeerShareRuntimesPage", err)
+	}
+	limit, offset := pageBounds(page, pageSize)
+	rows, err := r.db.Query(`
+		SELECT `+peerShareRuntimeColumns+`
+		FROM peer_share_runtime
+		WHERE share_id = ?
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, shareID, limit, offset)
+	if err != nil {
+		return nil, 0, store.WrapError("ListPeerShareRuntimesPage", err)
+	}
+	defer rows.Close()
+
+	out := make([]PeerShareRuntime, 0)
+	for rows.Next() {
+		item, err := scanPeerShareRuntime(rows)
+		if err != nil {
+			return nil, 0, store.WrapError("ListPeerShareRuntimesPage", err)
+		}
+		out = append(out, *item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, store.WrapError("ListPeerShareRuntimesPage", err)
+	}
+	return out, total, nil
 }
 
 func (r *Repository) ListActivePeerShareRuntimesByShareID(shareID int64) ([]PeerShareRuntime, error) {
@@ -1753,26 +3161,94 @@ func (r *Repository) ListActivePeerShareRuntimesByShareID(shareID int64) ([]Peer
 		return nil, errors.New("repository not initialized")
 	}
 	rows, err := r.db.Query(`
-		SELECT id, share_id, node_id, reservation_id, resource_key, binding_id, role, chain_name, service_name, protocol, strategy, port, target, applied, status, created_time, updated_time
+		SELECT `+peerShareRuntimeColumns+`
 		FROM peer_share_runtime
 		WHERE share_id = ? AND status = 1
 		ORDER BY port ASC, id ASC
 	`, shareID)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("ListActivePeerShareRuntimesByShareID", err)
 	}
 	defer rows.Close()
 
 	out := make([]PeerShareRuntime, 0)
 	for rows.Next() {
-		var item PeerShareRuntime
-		if err := rows.Scan(&item.ID, &item.ShareID, &item.NodeID, &item.ReservationID, &item.ResourceKey, &item.BindingID, &item.Role, &item.ChainName, &item.ServiceName, &item.Protocol, &item.Strategy, &item.Port, &item.Target, &item.Applied, &item.Status, &item.CreatedTime, &item.UpdatedTime); err != nil {
-			return nil, err
+		item, err := scanPeerShareRuntime(rows)
+		if err != nil {
+			return nil, store.WrapError("ListActivePeerShareRuntimesByShareID", err)
 		}
-		out = append(out, item)
+		out = append(out, *item)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError("ListActivePeerShareRuntimesByShareID", err)
+	}
+	return out, nil
+}
+
+// PeerShareConsumerCallback groups the active bindings one consumer panel
+// holds on a share with the URL it registered for share lifecycle events.
+type PeerShareConsumerCallback struct {
+	ConsumerID  string
+	CallbackURL string
+	BindingIDs  []string
+}
+
+// UpsertPeerShareConsumer records the callback URL a consumer panel registered
+// when it connected to a share.
+func (r *Repository) UpsertPeerShareConsumer(shareID int64, consumerID string, callbackURL string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO peer_share_consumer(share_id, consumer_id, callback_url, created_time, updated_time)
+		VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(share_id, consumer_id)
+		DO UPDATE SET callback_url = excluded.callback_url, updated_time = excluded.updated_time
+	`, shareID, consumerID, callbackURL, now, now)
+	if err != nil {
+		return store.WrapError("UpsertPeerShareConsumer", fmt.Errorf("upsert peer share consumer failed: %w", err))
+	}
+	return nil
+}
+
+// ListPeerShareRuntimeConsumerURLs returns every consumer of a share that
+// registered a callback URL, together with the binding IDs of its active
+// runtimes.
+func (r *Repository) ListPeerShareRuntimeConsumerURLs(shareID int64) ([]PeerShareConsumerCallback, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`
+		SELECT c.consumer_id, c.callback_url, COALESCE(rt.binding_id, '')
+		FROM peer_share_consumer c
+		LEFT JOIN peer_share_runtime rt ON rt.share_id = c.share_id AND rt.consumer_id = c.consumer_id AND rt.status = 1
+		WHERE c.share_id = ? AND c.callback_url <> ''
+		ORDER BY c.id ASC, rt.id ASC
+	`, shareID)
+	if err != nil {
+		return nil, store.WrapError("ListPeerShareRuntimeConsumerURLs", fmt.Errorf("list peer share consumers failed: %w", err))
+	}
+	defer rows.Close()
+
+	out := make([]PeerShareConsumerCallback, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		var consumerID, callbackURL, bindingID string
+		if err := rows.Scan(&consumerID, &callbackURL, &bindingID); err != nil {
+			return nil, store.WrapError("ListPeerShareRuntimeConsumerURLs", fmt.Errorf("scan peer share consumer failed: %w", err))
+		}
+		i, ok := index[consumerID]
+		if !ok {
+			i = len(out)
+			index[consumerID] = i
+			out = append(out, PeerShareConsumerCallback{ConsumerID: consumerID, CallbackURL: callbackURL, BindingIDs: []string{}})
+		}
+		if bindingID != "" {
+			out[i].BindingIDs = append(out[i].BindingIDs, bindingID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("ListPeerShareRuntimeConsumerURLs", fmt.Errorf("iterate peer share consumers failed: %w", err))
 	}
 	return out, nil
 }
@@ -1785,7 +3261,7 @@ func (r *Repository) AddPeerShareCurrentFlow(shareID int64, delta int64) error {
 		return nil
 	}
 	_, err := r.db.Exec(`UPDATE peer_share SET current_flow = current_flow + ?, updated_time = ? WHERE id = ?`, delta, unixMilliNow(), shareID)
-	return err
+	return store.WrapError("AddPeerShareCurrentFlow", err)
 }
 
 func (r *Repository) ResetPeerShareCurrentFlow(shareID int64, updatedTime int64) error {
@@ -1799,79 +3275,242 @@ func (r *Repository) ResetPeerShareCurrentFlow(shareID int64, updatedTime int64)
 		updatedTime = unixMilliNow()
 	}
 	_, err := r.db.Exec(`UPDATE peer_share SET current_flow = 0, updated_time = ? WHERE id = ?`, updatedTime, shareID)
-	return err
+	return store.WrapError("ResetPeerShareCurrentFlow", err)
 }
 
 func (r *Repository) CreatePeerShareRuntime(item *PeerShareRuntime) error {
+	return r.CreatePeerShareRuntimeTx(context.Background(), item)
+}
+
+// CreatePeerShareRuntimeTx is CreatePeerShareRuntime inside the transaction
+// carried by ctx.
+func (r *Repository) CreatePeerShareRuntimeTx(ctx context.Context, item *PeerShareRuntime) error {
 	if r == nil || r.db == nil {
 		return errors.New("repository not initialized")
 	}
 	if item == nil {
 		return errors.New("runtime item is nil")
 	}
-	_, err := r.db.Exec(`
-		INSERT INTO peer_share_runtime(share_id, node_id, reservation_id, resource_key, binding_id, role, chain_name, service_name, protocol, strategy, port, target, applied, status, created_time, updated_time)
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, item.ShareID, item.NodeID, item.ReservationID, item.ResourceKey, item.BindingID, item.Role, item.ChainName, item.ServiceName, item.Protocol, item.Strategy, item.Port, item.Target, item.Applied, item.Status, item.CreatedTime, item.UpdatedTime)
-	return err
+	_, err := r.ExecerFromCtx(ctx).Exec(`
+		INSERT INTO peer_share_runtime(share_id, node_id, consumer_id, reservation_id, resource_key, binding_id, role, chain_name, service_name, protocol, strategy, port, target, applied, status, created_time, updated_time)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, item.ShareID, item.NodeID, item.ConsumerID, item.ReservationID, item.ResourceKey, item.BindingID, item.Role, item.ChainName, item.ServiceName, item.Protocol, item.Strategy, item.Port, item.Target, item.Applied, item.Status, item.CreatedTime, item.UpdatedTime)
+	return store.WrapError("CreatePeerShareRuntime", err)
 }
 
 func (r *Repository) UpdatePeerShareRuntime(item *PeerShareRuntime) error {
+	return r.UpdatePeerShareRuntimeTx(context.Background(), item)
+}
+
+// UpdatePeerShareRuntimeTx is UpdatePeerShareRuntime inside the transaction
+// carried by ctx.
+func (r *Repository) UpdatePeerShareRuntimeTx(ctx context.Context, item *PeerShareRuntime) error {
 	if r == nil || r.db == nil {
 		return errors.New("repository not initialized")
 	}
 	if item == nil {
 		return errors.New("runtime item is nil")
 	}
-	_, err := r.db.Exec(`
+	_, err := r.ExecerFromCtx(ctx).Exec(`
 		UPDATE peer_share_runtime
-		SET binding_id = ?, role = ?, chain_name = ?, service_name = ?, protocol = ?, strategy = ?, port = ?, target = ?, applied = ?, status = ?, updated_time = ?
+		SET consumer_id = ?, binding_id = ?, role = ?, chain_name = ?, service_name = ?, protocol = ?, strategy = ?, port = ?, target = ?, applied = ?, status = ?, updated_time = ?
 		WHERE id = ?
-	`, item.BindingID, item.Role, item.ChainName, item.ServiceName, item.Protocol, item.Strategy, item.Port, item.Target, item.Applied, item.Status, item.UpdatedTime, item.ID)
-	return err
+	`, item.ConsumerID, item.BindingID, item.Role, item.ChainName, item.ServiceName, item.Protocol, item.Strategy, item.Port, item.Target, item.Applied, item.Status, item.UpdatedTime, item.ID)
+	return store.WrapError("UpdatePeerShareRuntime", err)
 }
 
-func (r *Repository) MarkPeerShareRuntimeReleased(id int64, updatedTime int64) error {
+// LockPeerShareTx locks the share row for the rest of the transaction
+// carried by ctx, so that concurrent port allocations on the share run one
+// after another. It must be the first statement of the transaction: the
+// no-op UPDATE takes the row lock in Postgres, like SELECT ... FOR UPDATE,
+// and the database write lock in SQLite, like BEGIN IMMEDIATE. It returns
+// nil when the share does not exist.
+func (r *Repository) LockPeerShareTx(ctx context.Context, shareID int64) (*PeerShare, error) {
 	if r == nil || r.db == nil {
-		return errors.New("repository not initialized")
+		return nil, errors.New("repository not initialized")
 	}
-	_, err := r.db.Exec(`UPDATE peer_share_runtime SET status = 0, updated_time = ? WHERE id = ?`, updatedTime, id)
-	return err
-}
-
+	tx, ok := store.TxFromCtx(ctx)
+	if !ok {
+		return nil, errors.New("LockPeerShareTx needs a transaction in the context")
+	}
+	if _, err := tx.Exec(`UPDATE peer_share SET updated_time = updated_time WHERE id = ?`, shareID); err != nil {
+		return nil, store.WrapError("LockPeerShareTx", err)
+	}
+	row := tx.QueryRow(`SELECT id, name, node_id, token, max_bandwidth, expiry_time, port_range_start, port_range_end, current_flow, is_active, created_time, updated_time, allowed_domains, allowed_ips FROM peer_share WHERE id = ?`, shareID)
+	var s PeerShare
+	if err := row.Scan(&s.ID, &s.Name, &s.NodeID, &s.Token, &s.MaxBandwidth, &s.ExpiryTime, &s.PortRangeStart, &s.PortRangeEnd, &s.CurrentFlow, &s.IsActive, &s.CreatedTime, &s.UpdatedTime, &s.AllowedDomains, &s.AllowedIPs); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, store.WrapError("LockPeerShareTx", err)
+	}
+	if err := r.openPeerShare(&s); err != nil {
+		return nil, store.WrapError("LockPeerShareTx", err)
+	}
+	return &s, nil
+}
+
+// PeerSharePortsInUseTx returns the ports on the share's node taken by
+// tunnel hops, forwards or active runtimes of the share. Reserved port
+// ranges are not included.
+func (r *Repository) PeerSharePortsInUseTx(ctx context.Context, share *PeerShare) (map[int]struct{}, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	if share == nil {
+		return nil, errors.New("share is nil")
+	}
+	rows, err := r.ExecerFromCtx(ctx).Query(`
+		SELECT port FROM chain_tunnel WHERE node_id = ? AND port IS NOT NULL AND port > 0
+		UNION
+		SELECT port FROM forward_port WHERE node_id = ? AND port > 0
+		UNION
+		SELECT port FROM peer_share_runtime WHERE share_id = ? AND node_id = ? AND status = 1 AND port > 0
+	`, share.NodeID, share.NodeID, share.ID, share.NodeID)
+	if err != nil {
+		return nil, store.WrapError("PeerSharePortsInUseTx", err)
+	}
+	defer rows.Close()
+	used := make(map[int]struct{})
+	for rows.Next() {
+		var port int
+		if err := rows.Scan(&port); err != nil {
+			return nil, store.WrapError("PeerSharePortsInUseTx", err)
+		}
+		used[port] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("PeerSharePortsInUseTx", err)
+	}
+	return used, nil
+}
+
+// ReservePeerSharePorts allocates count free ports from the share's range in
+// one transaction holding the share lock, skipping excludePorts and reserved
+// port ranges. Each port is held by a pending runtime row until a consumer
+// applies or releases it, so concurrent callers never receive the same port.
+func (r *Repository) ReservePeerSharePorts(shareID int64, count int, excludePorts []int) ([]int, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	if count <= 0 {
+		return []int{}, nil
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, store.WrapError("ReservePeerSharePorts", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	ctx := store.WithTx(context.Background(), tx)
+
+	share, err := r.LockPeerShareTx(ctx, shareID)
+	if err != nil {
+		return nil, err
+	}
+	if share == nil {
+		return nil, store.WrapError("ReservePeerSharePorts", sql.ErrNoRows)
+	}
+	used, err := r.PeerSharePortsInUseTx(ctx, share)
+	if err != nil {
+		return nil, err
+	}
+	for _, port := range excludePorts {
+		used[port] = struct{}{}
+	}
+	reserved, err := ListReservedPortsWith(tx, share.NodeID)
+	if err != nil {
+		return nil, store.WrapError("ReservePeerSharePorts", err)
+	}
+	for _, p := range reserved {
+		for port := p.PortStart; port <= p.PortEnd; port++ {
+			used[port] = struct{}{}
+		}
+	}
+
+	ports := make([]int, 0, count)
+	for port := share.PortRangeStart; port > 0 && port <= share.PortRangeEnd && len(ports) < count; port++ {
+		if _, ok := used[port]; !ok {
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) < count {
+		return nil, store.WrapError("ReservePeerSharePorts", fmt.Errorf("only %d of %d ports available", len(ports), count))
+	}
+
+	now := time.Now().UnixMilli()
+	for _, port := range ports {
+		reservationID, err := newUUID()
+		if err != nil {
+			return nil, store.WrapError("ReservePeerSharePorts", err)
+		}
+		if err := r.CreatePeerShareRuntimeTx(ctx, &PeerShareRuntime{
+			ShareID:       share.ID,
+			NodeID:        share.NodeID,
+			ReservationID: reservationID,
+			ResourceKey:   "reserved:" + reservationID,
+			Protocol:      "tls",
+			Strategy:      "round",
+			Port:          port,
+			Status:        1,
+			CreatedTime:   now,
+			UpdatedTime:   now,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, store.WrapError("ReservePeerSharePorts", err)
+	}
+	return ports, nil
+}
+
+func (r *Repository) MarkPeerShareRuntimeReleased(id int64, updatedTime int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`UPDATE peer_share_runtime SET status = 0, updated_time = ? WHERE id = ?`, updatedTime, id)
+	return store.WrapError("MarkPeerShareRuntimeReleased", err)
+}
+
 func (r *Repository) ListActivePeerShareRuntimePorts(shareID int64, nodeID int64) ([]int, error) {
 	if r == nil || r.db == nil {
 		return nil, errors.New("repository not initialized")
 	}
 	rows, err := r.db.Query(`SELECT port FROM peer_share_runtime WHERE share_id = ? AND node_id = ? AND status = 1 AND port > 0`, shareID, nodeID)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("ListActivePeerShareRuntimePorts", err)
 	}
 	defer rows.Close()
 	out := make([]int, 0)
 	for rows.Next() {
 		var port int
 		if err := rows.Scan(&port); err != nil {
-			return nil, err
+			return nil, store.WrapError("ListActivePeerShareRuntimePorts", err)
 		}
 		if port > 0 {
 			out = append(out, port)
 		}
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError("ListActivePeerShareRuntimePorts", err)
 	}
 	return out, nil
 }
 
 func (r *Repository) UpsertFederationTunnelBinding(item *FederationTunnelBinding) error {
+	return r.UpsertFederationTunnelBindingTx(context.Background(), item)
+}
+
+// UpsertFederationTunnelBindingTx is UpsertFederationTunnelBinding inside the
+// transaction carried by ctx.
+func (r *Repository) UpsertFederationTunnelBindingTx(ctx context.Context, item *FederationTunnelBinding) error {
 	if r == nil || r.db == nil {
 		return errors.New("repository not initialized")
 	}
 	if item == nil {
 		return errors.New("binding item is nil")
 	}
-	_, err := r.db.Exec(`
+	_, err := r.ExecerFromCtx(ctx).Exec(`
 		INSERT INTO federation_tunnel_binding(tunnel_id, node_id, chain_type, hop_inx, remote_url, resource_key, remote_binding_id, allocated_port, status, created_time, updated_time)
 		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(tunnel_id, node_id, chain_type, hop_inx)
@@ -1883,7 +3522,7 @@ func (r *Repository) UpsertFederationTunnelBinding(item *FederationTunnelBinding
 			status = excluded.status,
 			updated_time = excluded.updated_time
 	`, item.TunnelID, item.NodeID, item.ChainType, item.HopInx, item.RemoteURL, item.ResourceKey, item.RemoteBindingID, item.AllocatedPort, item.Status, item.CreatedTime, item.UpdatedTime)
-	return err
+	return store.WrapError("UpsertFederationTunnelBinding", err)
 }
 
 func (r *Repository) ListActiveFederationTunnelBindingsByTunnel(tunnelID int64) ([]FederationTunnelBinding, error) {
@@ -1897,29 +3536,185 @@ func (r *Repository) ListActiveFederationTunnelBindingsByTunnel(tunnelID int64)
 		ORDER BY chain_type ASC, hop_inx ASC, id ASC
 	`, tunnelID)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("ListActiveFederationTunnelBindingsByTunnel", err)
 	}
 	defer rows.Close()
 	out := make([]FederationTunnelBinding, 0)
 	for rows.Next() {
 		var item FederationTunnelBinding
 		if err := rows.Scan(&item.ID, &item.TunnelID, &item.NodeID, &item.ChainType, &item.HopInx, &item.RemoteURL, &item.ResourceKey, &item.RemoteBindingID, &item.AllocatedPort, &item.Status, &item.CreatedTime, &item.UpdatedTime); err != nil {
-			return nil, err
+			return nil, store.WrapError("ListActiveFederationTunnelBindingsByTunnel", err)
 		}
 		out = append(out, item)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, store.WrapError("ListActiveFederationTunnelBindingsByTunnel", err)
 	}
 	return out, nil
 }
 
 func (r *Repository) DeleteFederationTunnelBindingsByTunnel(tunnelID int64) error {
+	return r.DeleteFederationTunnelBindingsByTunnelTx(context.Background(), tunnelID)
+}
+
+// DeleteFederationTunnelBindingsByTunnelTx is
+// DeleteFederationTunnelBindingsByTunnel inside the transaction carried by ctx.
+func (r *Repository) DeleteFederationTunnelBindingsByTunnelTx(ctx context.Context, tunnelID int64) error {
 	if r == nil || r.db == nil {
 		return errors.New("repository not initialized")
 	}
-	_, err := r.db.Exec(`DELETE FROM federation_tunnel_binding WHERE tunnel_id = ?`, tunnelID)
-	return err
+	_, err := r.ExecerFromCtx(ctx).Exec(`DELETE FROM federation_tunnel_binding WHERE tunnel_id = ?`, tunnelID)
+	return store.WrapError("DeleteFederationTunnelBindingsByTunnel", err)
+}
+
+// CleanOrphanedFederationBindings removes federation tunnel bindings whose
+// tunnel no longer exists and peer share runtimes whose share no longer
+// exists. It returns the number of rows removed.
+func (r *Repository) CleanOrphanedFederationBindings() (int, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, store.WrapError("CleanOrphanedFederationBindings", fmt.Errorf("begin orphan cleanup failed: %w", err))
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	removed := 0
+	for _, stmt := range []string{
+		`DELETE FROM federation_tunnel_binding WHERE tunnel_id NOT IN (SELECT id FROM tunnel)`,
+		`DELETE FROM peer_share_runtime WHERE share_id NOT IN (SELECT id FROM peer_share)`,
+	} {
+		res, err := tx.Exec(stmt)
+		if err != nil {
+			return 0, store.WrapError("CleanOrphanedFederationBindings", fmt.Errorf("clean orphaned federation rows failed: %w", err))
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			removed += int(n)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, store.WrapError("CleanOrphanedFederationBindings", fmt.Errorf("commit orphan cleanup failed: %w", err))
+	}
+	return removed, nil
+}
+
+// DeactivateFederationTunnelBindings marks the active bindings on a remote
+// node as inactive and returns the IDs of the tunnels they belong to. When
+// remoteBindingIDs is empty every active binding on the node is affected.
+func (r *Repository) DeactivateFederationTunnelBindings(nodeID int64, remoteBindingIDs []string, now int64) ([]int64, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	bindings, err := r.db.Query(`
+		SELECT id, tunnel_id, remote_binding_id
+		FROM federation_tunnel_binding
+		WHERE node_id = ? AND status = 1
+		ORDER BY id ASC
+	`, nodeID)
+	if err != nil {
+		return nil, store.WrapError("DeactivateFederationTunnelBindings", fmt.Errorf("list federation tunnel bindings failed: %w", err))
+	}
+	wanted := make(map[string]struct{}, len(remoteBindingIDs))
+	for _, id := range remoteBindingIDs {
+		wanted[id] = struct{}{}
+	}
+	ids := make([]int64, 0)
+	tunnelIDs := make([]int64, 0)
+	seen := make(map[int64]struct{})
+	for bindings.Next() {
+		var id, tunnelID int64
+		var remoteBindingID string
+		if err := bindings.Scan(&id, &tunnelID, &remoteBindingID); err != nil {
+			bindings.Close()
+			return nil, store.WrapError("DeactivateFederationTunnelBindings", fmt.Errorf("scan federation tunnel binding failed: %w", err))
+		}
+		if len(wanted) > 0 {
+			if _, ok := wanted[remoteBindingID]; !ok {
+				continue
+			}
+		}
+		ids = append(ids, id)
+		if _, ok := seen[tunnelID]; !ok {
+			seen[tunnelID] = struct{}{}
+			tunnelIDs = append(tunnelIDs, tunnelID)
+		}
+	}
+	if err := bindings.Err(); err != nil {
+		bindings.Close()
+		return nil, store.WrapError("DeactivateFederationTunnelBindings", fmt.Errorf("iterate federation tunnel bindings failed: %w", err))
+	}
+	bindings.Close()
+
+	for _, id := range ids {
+		if _, err := r.db.Exec(`UPDATE federation_tunnel_binding SET status = 0, updated_time = ? WHERE id = ?`, now, id); err != nil {
+			return nil, store.WrapError("DeactivateFederationTunnelBindings", fmt.Errorf("deactivate federation tunnel binding failed: %w", err))
+		}
+	}
+	return tunnelIDs, nil
+}
+
+// PeerAllocation is a port a remote share assigned to one of this panel's
+// tunnels.
+type PeerAllocation struct {
+	TunnelID   int64  `json:"tunnelId"`
+	TunnelName string `json:"tunnelName"`
+	Port       int    `json:"port"`
+	Protocol   string `json:"protocol"`
+	Role       string `json:"role"`
+	Status     int    `json:"status"`
+}
+
+// GetRemoteNodeAllocations lists the ports the share behind the imported
+// remote node nodeID assigned to local tunnels, with the hop each port
+// serves. Inactive bindings are included with their status.
+func (r *Repository) GetRemoteNodeAllocations(nodeID int64) ([]PeerAllocation, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`
+		SELECT fb.tunnel_id, COALESCE(t.name, ''), fb.allocated_port, COALESCE(ct.protocol, ''), fb.chain_type, fb.status
+		FROM federation_tunnel_binding fb
+		JOIN node n ON n.id = fb.node_id AND n.is_remote = 1
+		JOIN chain_tunnel ct ON ct.tunnel_id = fb.tunnel_id AND ct.node_id = fb.node_id
+			AND CAST(ct.chain_type AS INTEGER) = fb.chain_type AND COALESCE(ct.inx, 0) = fb.hop_inx
+		LEFT JOIN tunnel t ON t.id = fb.tunnel_id
+		WHERE fb.node_id = ?
+		ORDER BY fb.allocated_port ASC, fb.id ASC
+	`, nodeID)
+	if err != nil {
+		return nil, store.WrapError("GetRemoteNodeAllocations", err)
+	}
+	defer rows.Close()
+	out := make([]PeerAllocation, 0)
+	for rows.Next() {
+		var item PeerAllocation
+		var chainType int
+		if err := rows.Scan(&item.TunnelID, &item.TunnelName, &item.Port, &item.Protocol, &chainType, &item.Status); err != nil {
+			return nil, store.WrapError("GetRemoteNodeAllocations", err)
+		}
+		item.Role = chainTypeRole(chainType)
+		out = append(out, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("GetRemoteNodeAllocations", err)
+	}
+	return out, nil
+}
+
+// chainTypeRole names a chain_tunnel chain type the way federation runtime
+// roles do.
+func chainTypeRole(chainType int) string {
+	switch chainType {
+	case 1:
+		return "entry"
+	case 2:
+		return "middle"
+	case 3:
+		return "exit"
+	default:
+		return ""
+	}
 }
 
 var osMkdirAll = func(path string) error {
@@ -1942,8 +3737,14 @@ type BackupData struct {
 	UserGroups   []UserGroupBackup   `json:"userGroups,omitempty"`
 	Permissions  []PermissionBackup  `json:"permissions,omitempty"`
 	Configs      map[string]string   `json:"configs,omitempty"`
+	PeerShares   []PeerShare         `json:"peerShares,omitempty"`
 }
 
+// BackupSnapshotVersion is the format version of ExportSnapshot archives.
+// 1.1 adds federation shares to the 1.0 backup layout. Import does not read
+// them, so a 1.1 archive restores through Import like a 1.0 one.
+const BackupSnapshotVersion = "1.1"
+
 type UserBackup struct {
 	ID            int64  `json:"id"`
 	User          string `json:"user"`
@@ -1997,6 +3798,7 @@ type TunnelBackup struct {
 	Status       int                 `json:"status"`
 	InIP         string              `json:"inIp,omitempty"`
 	Inx          int                 `json:"inx"`
+	DSCPMark     int                 `json:"dscpMark,omitempty"`
 	ChainTunnels []ChainTunnelBackup `json:"chainTunnels,omitempty"`
 }
 
@@ -2019,6 +3821,9 @@ type ForwardBackup struct {
 	TunnelID    int64  `json:"tunnelId"`
 	RemoteAddr  string `json:"remoteAddr"`
 	Strategy    string `json:"strategy"`
+	Protocol    string `json:"protocol,omitempty"`
+	DNSServer   string `json:"dnsServer,omitempty"`
+	IdleTimeout int    `json:"idleTimeoutSec,omitempty"`
 	InFlow      int64  `json:"inFlow"`
 	OutFlow     int64  `json:"outFlow"`
 	CreatedTime int64  `json:"createdTime"`
@@ -2098,63 +3903,63 @@ func (r *Repository) ExportAll() (*BackupData, error) {
 	}
 
 	// Export all data types
-	users, err := r.exportUsers()
+	users, err := r.exportUsers(r.db)
 	if err != nil {
-		return nil, fmt.Errorf("export users failed: %w", err)
+		return nil, store.WrapError("ExportAll", fmt.Errorf("export users failed: %w", err))
 	}
 	backup.Users = users
 
-	nodes, err := r.exportNodes()
+	nodes, err := r.exportNodes(r.db)
 	if err != nil {
-		return nil, fmt.Errorf("export nodes failed: %w", err)
+		return nil, store.WrapError("ExportAll", fmt.Errorf("export nodes failed: %w", err))
 	}
 	backup.Nodes = nodes
 
-	tunnels, err := r.exportTunnels()
+	tunnels, err := r.exportTunnels(r.db)
 	if err != nil {
-		return nil, fmt.Errorf("export tunnels failed: %w", err)
+		return nil, store.WrapError("ExportAll", fmt.Errorf("export tunnels failed: %w", err))
 	}
 	backup.Tunnels = tunnels
 
-	forwards, err := r.exportForwards()
+	forwards, err := r.exportForwards(r.db)
 	if err != nil {
-		return nil, fmt.Errorf("export forwards failed: %w", err)
+		return nil, store.WrapError("ExportAll", fmt.Errorf("export forwards failed: %w", err))
 	}
 	backup.Forwards = forwards
 
-	userTunnels, err := r.exportUserTunnels()
+	userTunnels, err := r.exportUserTunnels(r.db)
 	if err != nil {
-		return nil, fmt.Errorf("export user tunnels failed: %w", err)
+		return nil, store.WrapError("ExportAll", fmt.Errorf("export user tunnels failed: %w", err))
 	}
 	backup.UserTunnels = userTunnels
 
-	speedLimits, err := r.exportSpeedLimits()
+	speedLimits, err := r.exportSpeedLimits(r.db)
 	if err != nil {
-		return nil, fmt.Errorf("export speed limits failed: %w", err)
+		return nil, store.WrapError("ExportAll", fmt.Errorf("export speed limits failed: %w", err))
 	}
 	backup.SpeedLimits = speedLimits
 
-	tunnelGroups, err := r.exportTunnelGroups()
+	tunnelGroups, err := r.exportTunnelGroups(r.db)
 	if err != nil {
-		return nil, fmt.Errorf("export tunnel groups failed: %w", err)
+		return nil, store.WrapError("ExportAll", fmt.Errorf("export tunnel groups failed: %w", err))
 	}
 	backup.TunnelGroups = tunnelGroups
 
-	userGroups, err := r.exportUserGroups()
+	userGroups, err := r.exportUserGroups(r.db)
 	if err != nil {
-		return nil, fmt.Errorf("export user groups failed: %w", err)
+		return nil, store.WrapError("ExportAll", fmt.Errorf("export user groups failed: %w", err))
 	}
 	backup.UserGroups = userGroups
 
-	permissions, err := r.exportPermissions()
+	permissions, err := r.exportPermissions(r.db)
 	if err != nil {
-		return nil, fmt.Errorf("export permissions failed: %w", err)
+		return nil, store.WrapError("ExportAll", fmt.Errorf("export permissions failed: %w", err))
 	}
 	backup.Permissions = permissions
 
 	configs, err := r.ListConfigs()
 	if err != nil {
-		return nil, fmt.Errorf("export configs failed: %w", err)
+		return nil, store.WrapError("ExportAll", fmt.Errorf("export configs failed: %w", err))
 	}
 	backup.Configs = configs
 
@@ -2174,72 +3979,72 @@ func (r *Repository) ExportPartial(types []string) (*BackupData, error) {
 	}
 
 	if typeSet["users"] {
-		users, err := r.exportUsers()
+		users, err := r.exportUsers(r.db)
 		if err != nil {
-			return nil, fmt.Errorf("export users failed: %w", err)
+			return nil, store.WrapError("ExportPartial", fmt.Errorf("export users failed: %w", err))
 		}
 		backup.Users = users
 	}
 	if typeSet["nodes"] {
-		nodes, err := r.exportNodes()
+		nodes, err := r.exportNodes(r.db)
 		if err != nil {
-			return nil, fmt.Errorf("export nodes failed: %w", err)
+			return nil, store.WrapError("ExportPartial", fmt.Errorf("export nodes failed: %w", err))
 		}
 		backup.Nodes = nodes
 	}
 	if typeSet["tunnels"] {
-		tunnels, err := r.exportTunnels()
+		tunnels, err := r.exportTunnels(r.db)
 		if err != nil {
-			return nil, fmt.Errorf("export tunnels failed: %w", err)
+			return nil, store.WrapError("ExportPartial", fmt.Errorf("export tunnels failed: %w", err))
 		}
 		backup.Tunnels = tunnels
 	}
 	if typeSet["forwards"] {
-		forwards, err := r.exportForwards()
+		forwards, err := r.exportForwards(r.db)
 		if err != nil {
-			return nil, fmt.Errorf("export forwards failed: %w", err)
+			return nil, store.WrapError("ExportPartial", fmt.Errorf("export forwards failed: %w", err))
 		}
 		backup.Forwards = forwards
 	}
 	if typeSet["userTunnels"] {
-		userTunnels, err := r.exportUserTunnels()
+		userTunnels, err := r.exportUserTunnels(r.db)
 		if err != nil {
-			return nil, fmt.Errorf("export user tunnels failed: %w", err)
+			return nil, store.WrapError("ExportPartial", fmt.Errorf("export user tunnels failed: %w", err))
 		}
 		backup.UserTunnels = userTunnels
 	}
 	if typeSet["speedLimits"] {
-		speedLimits, err := r.exportSpeedLimits()
+		speedLimits, err := r.exportSpeedLimits(r.db)
 		if err != nil {
-			return nil, fmt.Errorf("export speed limits failed: %w", err)
+			return nil, store.WrapError("ExportPartial", fmt.Errorf("export speed limits failed: %w", err))
 		}
 		backup.SpeedLimits = speedLimits
 	}
 	if typeSet["tunnelGroups"] {
-		tunnelGroups, err := r.exportTunnelGroups()
+		tunnelGroups, err := r.exportTunnelGroups(r.db)
 		if err != nil {
-			return nil, fmt.Errorf("export tunnel groups failed: %w", err)
+			return nil, store.WrapError("ExportPartial", fmt.Errorf("export tunnel groups failed: %w", err))
 		}
 		backup.TunnelGroups = tunnelGroups
 	}
 	if typeSet["userGroups"] {
-		userGroups, err := r.exportUserGroups()
+		userGroups, err := r.exportUserGroups(r.db)
 		if err != nil {
-			return nil, fmt.Errorf("export user groups failed: %w", err)
+			return nil, store.WrapError("ExportPartial", fmt.Errorf("export user groups failed: %w", err))
 		}
 		backup.UserGroups = userGroups
 	}
 	if typeSet["permissions"] {
-		permissions, err := r.exportPermissions()
+		permissions, err := r.exportPermissions(r.db)
 		if err != nil {
-			return nil, fmt.Errorf("export permissions failed: %w", err)
+			return nil, store.WrapError("ExportPartial", fmt.Errorf("export permissions failed: %w", err))
 		}
 		backup.Permissions = permissions
 	}
 	if typeSet["configs"] {
 		configs, err := r.ListConfigs()
 		if err != nil {
-			return nil, fmt.Errorf("export configs failed: %w", err)
+			return nil, store.WrapError("ExportPartial", fmt.Errorf("export configs failed: %w", err))
 		}
 		backup.Configs = configs
 	}
@@ -2247,13 +4052,66 @@ func (r *Repository) ExportPartial(types []string) (*BackupData, error) {
 	return backup, nil
 }
 
-func (r *Repository) exportUsers() ([]UserBackup, error) {
-	rows, err := r.db.Query(`
+// ExportSnapshot exports everything, federation shares included, inside a
+// single read transaction so the archive reflects one point in time even
+// while the panel keeps writing.
+func (r *Repository) ExportSnapshot() (*BackupData, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, store.WrapError("ExportSnapshot", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	backup := &BackupData{
+		Version:    BackupSnapshotVersion,
+		ExportedAt: unixMilliNow(),
+	}
+	if backup.Users, err = r.exportUsers(tx); err != nil {
+		return nil, store.WrapError("ExportSnapshot", fmt.Errorf("export users failed: %w", err))
+	}
+	if backup.Nodes, err = r.exportNodes(tx); err != nil {
+		return nil, store.WrapError("ExportSnapshot", fmt.Errorf("export nodes failed: %w", err))
+	}
+	if backup.Tunnels, err = r.exportTunnels(tx); err != nil {
+		return nil, store.WrapError("ExportSnapshot", fmt.Errorf("export tunnels failed: %w", err))
+	}
+	if backup.Forwards, err = r.exportForwards(tx); err != nil {
+		return nil, store.WrapError("ExportSnapshot", fmt.Errorf("export forwards failed: %w", err))
+	}
+	if backup.UserTunnels, err = r.exportUserTunnels(tx); err != nil {
+		return nil, store.WrapError("ExportSnapshot", fmt.Errorf("export user tunnels failed: %w", err))
+	}
+	if backup.SpeedLimits, err = r.exportSpeedLimits(tx); err != nil {
+		return nil, store.WrapError("ExportSnapshot", fmt.Errorf("export speed limits failed: %w", err))
+	}
+	if backup.TunnelGroups, err = r.exportTunnelGroups(tx); err != nil {
+		return nil, store.WrapError("ExportSnapshot", fmt.Errorf("export tunnel groups failed: %w", err))
+	}
+	if backup.UserGroups, err = r.exportUserGroups(tx); err != nil {
+		return nil, store.WrapError("ExportSnapshot", fmt.Errorf("export user groups failed: %w", err))
+	}
+	if backup.Permissions, err = r.exportPermissions(tx); err != nil {
+		return nil, store.WrapError("ExportSnapshot", fmt.Errorf("export permissions failed: %w", err))
+	}
+	if backup.Configs, err = r.exportConfigs(tx); err != nil {
+		return nil, store.WrapError("ExportSnapshot", fmt.Errorf("export configs failed: %w", err))
+	}
+	if backup.PeerShares, err = r.exportPeerShares(tx); err != nil {
+		return nil, store.WrapError("ExportSnapshot", fmt.Errorf("export peer shares failed: %w", err))
+	}
+	return backup, nil
+}
+
+func (r *Repository) exportUsers(q Execer) ([]UserBackup, error) {
+	rows, err := q.Query(`
 		SELECT id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status
 		FROM user ORDER BY id ASC
 	`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("exportUsers", err)
 	}
 	defer rows.Close()
 
@@ -2262,7 +4120,7 @@ func (r *Repository) exportUsers() ([]UserBackup, error) {
 		var u UserBackup
 		var updatedTime sql.NullInt64
 		if err := rows.Scan(&u.ID, &u.User, &u.Pwd, &u.RoleID, &u.ExpTime, &u.Flow, &u.InFlow, &u.OutFlow, &u.FlowResetTime, &u.Num, &u.CreatedTime, &updatedTime, &u.Status); err != nil {
-			return nil, err
+			return nil, store.WrapError("exportUsers", err)
 		}
 		if updatedTime.Valid {
 			u.UpdatedTime = updatedTime.Int64
@@ -2272,13 +4130,13 @@ func (r *Repository) exportUsers() ([]UserBackup, error) {
 	return users, rows.Err()
 }
 
-func (r *Repository) exportNodes() ([]NodeBackup, error) {
-	rows, err := r.db.Query(`
+func (r *Repository) exportNodes(q Execer) ([]NodeBackup, error) {
+	rows, err := q.Query(`
 		SELECT id, name, secret, server_ip, server_ip_v4, server_ip_v6, port, interface_name, version, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx, is_remote, remote_url, remote_token, remote_config
 		FROM node ORDER BY inx ASC, id ASC
 	`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("exportNodes", err)
 	}
 	defer rows.Close()
 
@@ -2288,7 +4146,7 @@ func (r *Repository) exportNodes() ([]NodeBackup, error) {
 		var updatedTime sql.NullInt64
 		var serverIPv4, serverIPv6, interfaceName, version, remoteURL, remoteToken, remoteConfig sql.NullString
 		if err := rows.Scan(&n.ID, &n.Name, &n.Secret, &n.ServerIP, &serverIPv4, &serverIPv6, &n.Port, &interfaceName, &version, &n.HTTP, &n.TLS, &n.Socks, &n.CreatedTime, &updatedTime, &n.Status, &n.TCPListenAddr, &n.UDPListenAddr, &n.Inx, &n.IsRemote, &remoteURL, &remoteToken, &remoteConfig); err != nil {
-			return nil, err
+			return nil, store.WrapError("exportNodes", err)
 		}
 		if updatedTime.Valid {
 			n.UpdatedTime = updatedTime.Int64
@@ -2314,18 +4172,27 @@ func (r *Repository) exportNodes() ([]NodeBackup, error) {
 		if remoteConfig.Valid {
 			n.RemoteConfig = remoteConfig.String
 		}
+		// Backups carry plaintext so they can be restored under another
+		// master key.
+		var err error
+		if n.Secret, err = r.OpenSecret(n.Secret); err != nil {
+			return nil, store.WrapError("exportNodes", err)
+		}
+		if n.RemoteToken, err = r.OpenSecret(n.RemoteToken); err != nil {
+			return nil, store.WrapError("exportNodes", err)
+		}
 		nodes = append(nodes, n)
 	}
 	return nodes, rows.Err()
 }
 
-func (r *Repository) exportTunnels() ([]TunnelBackup, error) {
-	rows, err := r.db.Query(`
-		SELECT id, name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx
+func (r *Repository) exportTunnels(q Execer) ([]TunnelBackup, error) {
+	rows, err := q.Query(`
+		SELECT id, name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx, COALESCE(dscp_mark, 0)
 		FROM tunnel ORDER BY inx ASC, id ASC
 	`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("exportTunnels", err)
 	}
 	defer rows.Close()
 
@@ -2336,8 +4203,8 @@ func (r *Repository) exportTunnels() ([]TunnelBackup, error) {
 		var updatedTime sql.NullInt64
 		var inIP sql.NullString
 		var inx sql.NullInt64
-		if err := rows.Scan(&t.ID, &t.Name, &t.TrafficRatio, &t.Type, &protocol, &t.Flow, &t.CreatedTime, &updatedTime, &t.Status, &inIP, &inx); err != nil {
-			return nil, err
+		if err := rows.Scan(&t.ID, &t.Name, &t.TrafficRatio, &t.Type, &protocol, &t.Flow, &t.CreatedTime, &updatedTime, &t.Status, &inIP, &inx, &t.DSCPMark); err != nil {
+			return nil, store.WrapError("exportTunnels", err)
 		}
 		if protocol.Valid {
 			t.Protocol = protocol.String
@@ -2351,24 +4218,31 @@ func (r *Repository) exportTunnels() ([]TunnelBackup, error) {
 		if inx.Valid {
 			t.Inx = int(inx.Int64)
 		}
-		// Export chain tunnels
-		chainTunnels, err := r.exportChainTunnels(t.ID)
+		tunnels = append(tunnels, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("exportTunnels", err)
+	}
+	rows.Close()
+	// Export chain tunnels once the tunnel rows are closed, since q may be a
+	// transaction holding a single connection.
+	for i := range tunnels {
+		chainTunnels, err := r.exportChainTunnels(q, tunnels[i].ID)
 		if err != nil {
-			return nil, err
+			return nil, store.WrapError("exportTunnels", err)
 		}
-		t.ChainTunnels = chainTunnels
-		tunnels = append(tunnels, t)
+		tunnels[i].ChainTunnels = chainTunnels
 	}
-	return tunnels, rows.Err()
+	return tunnels, nil
 }
 
-func (r *Repository) exportChainTunnels(tunnelID int64) ([]ChainTunnelBackup, error) {
-	rows, err := r.db.Query(`
+func (r *Repository) exportChainTunnels(q Execer, tunnelID int64) ([]ChainTunnelBackup, error) {
+	rows, err := q.Query(`
 		SELECT id, tunnel_id, chain_type, node_id, port, strategy, inx, protocol
 		FROM chain_tunnel WHERE tunnel_id = ? ORDER BY inx ASC, id ASC
 	`, tunnelID)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("exportChainTunnels", err)
 	}
 	defer rows.Close()
 
@@ -2379,7 +4253,7 @@ func (r *Repository) exportChainTunnels(tunnelID int64) ([]ChainTunnelBackup, er
 		var strategy, protocol sql.NullString
 		var inx sql.NullInt64
 		if err := rows.Scan(&ct.ID, &ct.TunnelID, &ct.ChainType, &ct.NodeID, &port, &strategy, &inx, &protocol); err != nil {
-			return nil, err
+			return nil, store.WrapError("exportChainTunnels", err)
 		}
 		if port.Valid {
 			ct.Port = int(port.Int64)
@@ -2398,13 +4272,13 @@ func (r *Repository) exportChainTunnels(tunnelID int64) ([]ChainTunnelBackup, er
 	return chainTunnels, rows.Err()
 }
 
-func (r *Repository) exportForwards() ([]ForwardBackup, error) {
-	rows, err := r.db.Query(`
-		SELECT id, user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx
+func (r *Repository) exportForwards(q Execer) ([]ForwardBackup, error) {
+	rows, err := q.Query(`
+		SELECT id, user_id, user_name, name, tunnel_id, remote_addr, strategy, COALESCE(protocol, 'tcp'), COALESCE(dns_server, ''), COALESCE(idle_timeout_sec, 0), in_flow, out_flow, created_time, updated_time, status, inx
 		FROM forward ORDER BY id ASC
 	`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("exportForwards", err)
 	}
 	defer rows.Close()
 
@@ -2414,8 +4288,8 @@ func (r *Repository) exportForwards() ([]ForwardBackup, error) {
 		var strategy sql.NullString
 		var updatedTime sql.NullInt64
 		var inx sql.NullInt64
-		if err := rows.Scan(&f.ID, &f.UserID, &f.UserName, &f.Name, &f.TunnelID, &f.RemoteAddr, &strategy, &f.InFlow, &f.OutFlow, &f.CreatedTime, &updatedTime, &f.Status, &inx); err != nil {
-			return nil, err
+		if err := rows.Scan(&f.ID, &f.UserID, &f.UserName, &f.Name, &f.TunnelID, &f.RemoteAddr, &strategy, &f.Protocol, &f.DNSServer, &f.IdleTimeout, &f.InFlow, &f.OutFlow, &f.CreatedTime, &updatedTime, &f.Status, &inx); err != nil {
+			return nil, store.WrapError("exportForwards", err)
 		}
 		if strategy.Valid {
 			f.Strategy = strategy.String
@@ -2431,13 +4305,13 @@ func (r *Repository) exportForwards() ([]ForwardBackup, error) {
 	return forwards, rows.Err()
 }
 
-func (r *Repository) exportUserTunnels() ([]UserTunnelBackup, error) {
-	rows, err := r.db.Query(`
+func (r *Repository) exportUserTunnels(q Execer) ([]UserTunnelBackup, error) {
+	rows, err := q.Query(`
 		SELECT id, user_id, tunnel_id, speed_id, num, flow, in_flow, out_flow, flow_reset_time, exp_time, status
 		FROM user_tunnel ORDER BY id ASC
 	`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("exportUserTunnels", err)
 	}
 	defer rows.Close()
 
@@ -2446,7 +4320,7 @@ func (r *Repository) exportUserTunnels() ([]UserTunnelBackup, error) {
 		var ut UserTunnelBackup
 		var speedID sql.NullInt64
 		if err := rows.Scan(&ut.ID, &ut.UserID, &ut.TunnelID, &speedID, &ut.Num, &ut.Flow, &ut.InFlow, &ut.OutFlow, &ut.FlowResetTime, &ut.ExpTime, &ut.Status); err != nil {
-			return nil, err
+			return nil, store.WrapError("exportUserTunnels", err)
 		}
 		if speedID.Valid {
 			ut.SpeedID = speedID.Int64
@@ -2456,13 +4330,13 @@ func (r *Repository) exportUserTunnels() ([]UserTunnelBackup, error) {
 	return userTunnels, rows.Err()
 }
 
-func (r *Repository) exportSpeedLimits() ([]SpeedLimitBackup, error) {
-	rows, err := r.db.Query(`
+func (r *Repository) exportSpeedLimits(q Execer) ([]SpeedLimitBackup, error) {
+	rows, err := q.Query(`
 		SELECT id, name, speed, tunnel_id, tunnel_name, created_time, updated_time, status
 		FROM speed_limit ORDER BY id ASC
 	`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("exportSpeedLimits", err)
 	}
 	defer rows.Close()
 
@@ -2471,7 +4345,7 @@ func (r *Repository) exportSpeedLimits() ([]SpeedLimitBackup, error) {
 		var sl SpeedLimitBackup
 		var updatedTime sql.NullInt64
 		if err := rows.Scan(&sl.ID, &sl.Name, &sl.Speed, &sl.TunnelID, &sl.TunnelName, &sl.CreatedTime, &updatedTime, &sl.Status); err != nil {
-			return nil, err
+			return nil, store.WrapError("exportSpeedLimits", err)
 		}
 		if updatedTime.Valid {
 			sl.UpdatedTime = updatedTime.Int64
@@ -2481,13 +4355,13 @@ func (r *Repository) exportSpeedLimits() ([]SpeedLimitBackup, error) {
 	return speedLimits, rows.Err()
 }
 
-func (r *Repository) exportTunnelGroups() ([]TunnelGroupBackup, error) {
-	rows, err := r.db.Query(`
+func (r *Repository) exportTunnelGroups(q Execer) ([]TunnelGroupBackup, error) {
+	rows, err := q.Query(`
 		SELECT id, name, created_time, updated_time, status
 		FROM tunnel_group ORDER BY id ASC
 	`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("exportTunnelGroups", err)
 	}
 	defer rows.Close()
 
@@ -2495,34 +4369,40 @@ func (r *Repository) exportTunnelGroups() ([]TunnelGroupBackup, error) {
 	for rows.Next() {
 		var tg TunnelGroupBackup
 		if err := rows.Scan(&tg.ID, &tg.Name, &tg.CreatedTime, &tg.UpdatedTime, &tg.Status); err != nil {
-			return nil, err
+			return nil, store.WrapError("exportTunnelGroups", err)
 		}
-		// Get tunnel IDs for this group
-		tunnelRows, err := r.db.Query(`SELECT tunnel_id FROM tunnel_group_tunnel WHERE tunnel_group_id = ?`, tg.ID)
+		groups = append(groups, tg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("exportTunnelGroups", err)
+	}
+	rows.Close()
+	// Get tunnel IDs for each group
+	for i := range groups {
+		tunnelRows, err := q.Query(`SELECT tunnel_id FROM tunnel_group_tunnel WHERE tunnel_group_id = ?`, groups[i].ID)
 		if err != nil {
-			return nil, err
+			return nil, store.WrapError("exportTunnelGroups", err)
 		}
 		for tunnelRows.Next() {
 			var tunnelID int64
 			if err := tunnelRows.Scan(&tunnelID); err != nil {
 				tunnelRows.Close()
-				return nil, err
+				return nil, store.WrapError("exportTunnelGroups", err)
 			}
-			tg.Tunnels = append(tg.Tunnels, tunnelID)
+			groups[i].Tunnels = append(groups[i].Tunnels, tunnelID)
 		}
 		tunnelRows.Close()
-		groups = append(groups, tg)
 	}
-	return groups, rows.Err()
+	return groups, nil
 }
 
-func (r *Repository) exportUserGroups() ([]UserGroupBackup, error) {
-	rows, err := r.db.Query(`
+func (r *Repository) exportUserGroups(q Execer) ([]UserGroupBackup, error) {
+	rows, err := q.Query(`
 		SELECT id, name, created_time, updated_time, status
 		FROM user_group ORDER BY id ASC
 	`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("exportUserGroups", err)
 	}
 	defer rows.Close()
 
@@ -2530,34 +4410,40 @@ func (r *Repository) exportUserGroups() ([]UserGroupBackup, error) {
 	for rows.Next() {
 		var ug UserGroupBackup
 		if err := rows.Scan(&ug.ID, &ug.Name, &ug.CreatedTime, &ug.UpdatedTime, &ug.Status); err != nil {
-			return nil, err
+			return nil, store.WrapError("exportUserGroups", err)
 		}
-		// Get user IDs for this group
-		userRows, err := r.db.Query(`SELECT user_id FROM user_group_user WHERE user_group_id = ?`, ug.ID)
+		groups = append(groups, ug)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("exportUserGroups", err)
+	}
+	rows.Close()
+	// Get user IDs for each group
+	for i := range groups {
+		userRows, err := q.Query(`SELECT user_id FROM user_group_user WHERE user_group_id = ?`, groups[i].ID)
 		if err != nil {
-			return nil, err
+			return nil, store.WrapError("exportUserGroups", err)
 		}
 		for userRows.Next() {
 			var userID int64
 			if err := userRows.Scan(&userID); err != nil {
 				userRows.Close()
-				return nil, err
+				return nil, store.WrapError("exportUserGroups", err)
 			}
-			ug.Users = append(ug.Users, userID)
+			groups[i].Users = append(groups[i].Users, userID)
 		}
 		userRows.Close()
-		groups = append(groups, ug)
 	}
-	return groups, rows.Err()
+	return groups, nil
 }
 
-func (r *Repository) exportPermissions() ([]PermissionBackup, error) {
-	rows, err := r.db.Query(`
+func (r *Repository) exportPermissions(q Execer) ([]PermissionBackup, error) {
+	rows, err := q.Query(`
 		SELECT id, user_group_id, tunnel_group_id, created_time
 		FROM group_permission ORDER BY id ASC
 	`)
 	if err != nil {
-		return nil, err
+		return nil, store.WrapError("exportPermissions", err)
 	}
 	defer rows.Close()
 
@@ -2565,26 +4451,76 @@ func (r *Repository) exportPermissions() ([]PermissionBackup, error) {
 	for rows.Next() {
 		var p PermissionBackup
 		if err := rows.Scan(&p.ID, &p.UserGroupID, &p.TunnelGroupID, &p.CreatedTime); err != nil {
-			return nil, err
+			return nil, store.WrapError("exportPermissions", err)
 		}
 		p.CreatedByGroup = 0
-		// Get grants for this permission
-		grantRows, err := r.db.Query(`SELECT id, user_group_id, tunnel_group_id, user_tunnel_id, created_time, created_by_group FROM group_permission_grant WHERE user_group_id = ? AND tunnel_group_id = ?`, p.UserGroupID, p.TunnelGroupID)
+		permissions = append(permissions, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("exportPermissions", err)
+	}
+	rows.Close()
+	// Get grants for each permission
+	for i := range permissions {
+		p := &permissions[i]
+		grantRows, err := q.Query(`SELECT id, user_group_id, tunnel_group_id, user_tunnel_id, created_time, created_by_group FROM group_permission_grant WHERE user_group_id = ? AND tunnel_group_id = ?`, p.UserGroupID, p.TunnelGroupID)
 		if err != nil {
-			return nil, err
+			return nil, store.WrapError("exportPermissions", err)
 		}
 		for grantRows.Next() {
 			var g PermissionGrantBackup
 			if err := grantRows.Scan(&g.ID, &g.UserGroupID, &g.TunnelGroupID, &g.UserTunnelID, &g.CreatedTime, &g.CreatedByGroup); err != nil {
 				grantRows.Close()
-				return nil, err
+				return nil, store.WrapError("exportPermissions", err)
 			}
 			p.Grants = append(p.Grants, g)
 		}
 		grantRows.Close()
-		permissions = append(permissions, p)
 	}
-	return permissions, rows.Err()
+	return permissions, nil
+}
+
+func (r *Repository) exportConfigs(q Execer) (map[string]string, error) {
+	rows, err := q.Query(`SELECT name, value FROM vite_config`)
+	if err != nil {
+		return nil, store.WrapError("exportConfigs", err)
+	}
+	defer rows.Close()
+
+	configs := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, store.WrapError("exportConfigs", err)
+		}
+		configs[name] = value
+	}
+	return configs, rows.Err()
+}
+
+func (r *Repository) exportPeerShares(q Execer) ([]PeerShare, error) {
+	rows, err := q.Query(`
+		SELECT id, name, node_id, token, max_bandwidth, expiry_time, port_range_start, port_range_end, current_flow, is_active, created_time, updated_time, allowed_domains, allowed_ips
+		FROM peer_share ORDER BY id ASC
+	`)
+	if err != nil {
+		return nil, store.WrapError("exportPeerShares", err)
+	}
+	defer rows.Close()
+
+	var shares []PeerShare
+	for rows.Next() {
+		var s PeerShare
+		if err := rows.Scan(&s.ID, &s.Name, &s.NodeID, &s.Token, &s.MaxBandwidth, &s.ExpiryTime, &s.PortRangeStart, &s.PortRangeEnd, &s.CurrentFlow, &s.IsActive, &s.CreatedTime, &s.UpdatedTime, &s.AllowedDomains, &s.AllowedIPs); err != nil {
+			return nil, store.WrapError("exportPeerShares", err)
+		}
+		// Tokens are exported in plaintext, like node secrets.
+		if err := r.openPeerShare(&s); err != nil {
+			return nil, store.WrapError("exportPeerShares", err)
+		}
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
 }
 
 // ============ Import Methods ============
@@ -2615,7 +4551,7 @@ func (r *Repository) Import(backup *BackupData, types []string) (*ImportResult,
 
 	tx, err := r.db.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, store.WrapError("Import", fmt.Errorf("failed to begin transaction: %w", err))
 	}
 	defer func() { _ = tx.Rollback() }()
 
@@ -2624,7 +4560,7 @@ func (r *Repository) Import(backup *BackupData, types []string) (*ImportResult,
 	if typeSet["users"] && len(backup.Users) > 0 {
 		count, err := r.importUsers(tx, backup.Users, now)
 		if err != nil {
-			return nil, fmt.Errorf("import users failed: %w", err)
+			return nil, store.WrapError("Import", fmt.Errorf("import users failed: %w", err))
 		}
 		result.UsersImported = count
 	}
@@ -2632,7 +4568,7 @@ func (r *Repository) Import(backup *BackupData, types []string) (*ImportResult,
 	if typeSet["nodes"] && len(backup.Nodes) > 0 {
 		count, err := r.importNodes(tx, backup.Nodes, now)
 		if err != nil {
-			return nil, fmt.Errorf("import nodes failed: %w", err)
+			return nil, store.WrapError("Import", fmt.Errorf("import nodes failed: %w", err))
 		}
 		result.NodesImported = count
 	}
@@ -2640,7 +4576,7 @@ func (r *Repository) Import(backup *BackupData, types []string) (*ImportResult,
 	if typeSet["tunnels"] && len(backup.Tunnels) > 0 {
 		count, err := r.importTunnels(tx, backup.Tunnels, now)
 		if err != nil {
-			return nil, fmt.Errorf("import tunnels failed: %w", err)
+			return nil, store.WrapError("Import", fmt.Errorf("import tunnels failed: %w", err))
 		}
 		result.TunnelsImported = count
 	}
@@ -2648,7 +4584,7 @@ func (r *Repository) Import(backup *BackupData, types []string) (*ImportResult,
 	if typeSet["forwards"] && len(backup.Forwards) > 0 {
 		count, err := r.importForwards(tx, backup.Forwards, now)
 		if err != nil {
-			return nil, fmt.Errorf("import forwards failed: %w", err)
+			return nil, store.WrapError("Import", fmt.Errorf("import forwards failed: %w", err))
 		}
 		result.ForwardsImported = count
 	}
@@ -2656,7 +4592,7 @@ func (r *Repository) Import(backup *BackupData, types []string) (*ImportResult,
 	if typeSet["userTunnels"] && len(backup.UserTunnels) > 0 {
 		count, err := r.importUserTunnels(tx, backup.UserTunnels, now)
 		if err != nil {
-			return nil, fmt.Errorf("import user tunnels failed: %w", err)
+			return nil, store.WrapError("Import", fmt.Errorf("import user tunnels failed: %w", err))
 		}
 		result.UserTunnelsImported = count
 	}
@@ -2664,7 +4600,7 @@ func (r *Repository) Import(backup *BackupData, types []string) (*ImportResult,
 	if typeSet["speedLimits"] && len(backup.SpeedLimits) > 0 {
 		count, err := r.importSpeedLimits(tx, backup.SpeedLimits, now)
 		if err != nil {
-			return nil, fmt.Errorf("import speed limits failed: %w", err)
+			return nil, store.WrapError("Import", fmt.Errorf("import speed limits failed: %w", err))
 		}
 		result.SpeedLimitsImported = count
 	}
@@ -2672,7 +4608,7 @@ func (r *Repository) Import(backup *BackupData, types []string) (*ImportResult,
 	if typeSet["tunnelGroups"] && len(backup.TunnelGroups) > 0 {
 		count, err := r.importTunnelGroups(tx, backup.TunnelGroups, now)
 		if err != nil {
-			return nil, fmt.Errorf("import tunnel groups failed: %w", err)
+			return nil, store.WrapError("Import", fmt.Errorf("import tunnel groups failed: %w", err))
 		}
 		result.TunnelGroupsImported = count
 	}
@@ -2680,7 +4616,7 @@ func (r *Repository) Import(backup *BackupData, types []string) (*ImportResult,
 	if typeSet["userGroups"] && len(backup.UserGroups) > 0 {
 		count, err := r.importUserGroups(tx, backup.UserGroups, now)
 		if err != nil {
-			return nil, fmt.Errorf("import user groups failed: %w", err)
+			return nil, store.WrapError("Import", fmt.Errorf("import user groups failed: %w", err))
 		}
 		result.UserGroupsImported = count
 	}
@@ -2688,7 +4624,7 @@ func (r *Repository) Import(backup *BackupData, types []string) (*ImportResult,
 	if typeSet["permissions"] && len(backup.Permissions) > 0 {
 		count, err := r.importPermissions(tx, backup.Permissions, now)
 		if err != nil {
-			return nil, fmt.Errorf("import permissions failed: %w", err)
+			return nil, store.WrapError("Import", fmt.Errorf("import permissions failed: %w", err))
 		}
 		result.PermissionsImported = count
 	}
@@ -2696,13 +4632,13 @@ func (r *Repository) Import(backup *BackupData, types []string) (*ImportResult,
 	if typeSet["configs"] && len(backup.Configs) > 0 {
 		count, err := r.importConfigs(tx, backup.Configs, now)
 		if err != nil {
-			return nil, fmt.Errorf("import configs failed: %w", err)
+			return nil, store.WrapError("Import", fmt.Errorf("import configs failed: %w", err))
 		}
 		result.ConfigsImported = count
 	}
 
 	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		return nil, store.WrapError("Import", fmt.Errorf("failed to commit transaction: %w", err))
 	}
 
 	return result, nil
@@ -2728,7 +4664,7 @@ func (r *Repository) importUsers(db Execer, users []UserBackup, now int64) (int,
 				status = excluded.status
 		`, u.ID, u.User, u.Pwd, u.RoleID, u.ExpTime, u.Flow, u.InFlow, u.OutFlow, u.FlowResetTime, u.Num, u.CreatedTime, now, u.Status)
 		if err != nil {
-			return count, err
+			return count, store.WrapError("importUsers", err)
 		}
 		count++
 	}
@@ -2739,7 +4675,7 @@ func (r *Repository) UsernameExists(username string) (bool, error) {
 	var count int
 	err := r.db.QueryRow(`SELECT COUNT(1) FROM user WHERE user = ?`, username).Scan(&count)
 	if err != nil {
-		return false, err
+		return false, store.WrapError("UsernameExists", err)
 	}
 	return count > 0, nil
 }
@@ -2771,9 +4707,9 @@ func (r *Repository) importNodes(db Execer, nodes []NodeBackup, now int64) (int,
 				remote_url = excluded.remote_url,
 				remote_token = excluded.remote_token,
 				remote_config = excluded.remote_config
-		`, n.ID, n.Name, n.Secret, n.ServerIP, n.ServerIPv4, n.ServerIPv6, n.Port, n.InterfaceName, n.Version, n.HTTP, n.TLS, n.Socks, n.CreatedTime, now, n.Status, n.TCPListenAddr, n.UDPListenAddr, n.Inx, n.IsRemote, n.RemoteURL, n.RemoteToken, n.RemoteConfig)
+		`, n.ID, n.Name, r.SealSecret(n.Secret), n.ServerIP, n.ServerIPv4, n.ServerIPv6, n.Port, n.InterfaceName, n.Version, n.HTTP, n.TLS, n.Socks, n.CreatedTime, now, n.Status, n.TCPListenAddr, n.UDPListenAddr, n.Inx, n.IsRemote, n.RemoteURL, r.SealSecret(n.RemoteToken), n.RemoteConfig)
 		if err != nil {
-			return count, err
+			return count, store.WrapError("importNodes", err)
 		}
 		count++
 	}
@@ -2784,21 +4720,23 @@ func (r *Repository) importTunnels(db Execer, tunnels []TunnelBackup, now int64)
 	count := 0
 	for _, t := range tunnels {
 		_, err := db.Exec(`
-			INSERT INTO tunnel(id, name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
-			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			INSERT INTO tunnel(id, name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx, dscp_mark)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 			ON CONFLICT(id) DO UPDATE SET
 				name = excluded.name,
 				traffic_ratio = excluded.traffic_ratio,
 				type = excluded.type,
 				protocol = excluded.protocol,
+				dns_server = excluded.dns_server,
 				flow = excluded.flow,
 				updated_time = excluded.updated_time,
 				status = excluded.status,
 				in_ip = excluded.in_ip,
-				inx = excluded.inx
-		`, t.ID, t.Name, t.TrafficRatio, t.Type, t.Protocol, t.Flow, t.CreatedTime, now, t.Status, t.InIP, t.Inx)
+				inx = excluded.inx,
+				dscp_mark = excluded.dscp_mark
+		`, t.ID, t.Name, t.TrafficRatio, t.Type, t.Protocol, t.Flow, t.CreatedTime, now, t.Status, t.InIP, t.Inx, t.DSCPMark)
 		if err != nil {
-			return count, err
+			return count, store.WrapError("importTunnels", err)
 		}
 		if len(t.ChainTunnels) > 0 {
 			for _, ct := range t.ChainTunnels {
@@ -2814,7 +4752,7 @@ func (r *Repository) importTunnels(db Execer, tunnels []TunnelBackup, now int64)
 						protocol = excluded.protocol
 				`, ct.ID, ct.TunnelID, ct.ChainType, ct.NodeID, ct.Port, ct.Strategy, ct.Inx, ct.Protocol)
 				if err != nil {
-					return count, err
+					return count, store.WrapError("importTunnels", err)
 				}
 			}
 		}
@@ -2826,9 +4764,14 @@ func (r *Repository) importTunnels(db Execer, tunnels []TunnelBackup, now int64)
 func (r *Repository) importForwards(db Execer, forwards []ForwardBackup, now int64) (int, error) {
 	count := 0
 	for _, f := range forwards {
+		// Backups taken before forwards carried a protocol served both TCP and UDP.
+		protocol := f.Protocol
+		if strings.TrimSpace(protocol) == "" {
+			protocol = "both"
+		}
 		_, err := db.Exec(`
-			INSERT INTO forward(id, user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
-			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			INSERT INTO forward(id, user_id, user_name, name, tunnel_id, remote_addr, strategy, protocol, dns_server, idle_timeout_sec, in_flow, out_flow, created_time, updated_time, status, inx)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 			ON CONFLICT(id) DO UPDATE SET
 				user_id = excluded.user_id,
 				user_name = excluded.user_name,
@@ -2836,14 +4779,17 @@ func (r *Repository) importForwards(db Execer, forwards []ForwardBackup, now int
 				tunnel_id = excluded.tunnel_id,
 				remote_addr = excluded.remote_addr,
 				strategy = excluded.strategy,
+				protocol = excluded.protocol,
+				dns_server = excluded.dns_server,
+				idle_timeout_sec = excluded.idle_timeout_sec,
 				in_flow = excluded.in_flow,
 				out_flow = excluded.out_flow,
 				updated_time = excluded.updated_time,
 				status = excluded.status,
 				inx = excluded.inx
-		`, f.ID, f.UserID, f.UserName, f.Name, f.TunnelID, f.RemoteAddr, f.Strategy, f.InFlow, f.OutFlow, f.CreatedTime, now, f.Status, f.Inx)
+		`, f.ID, f.UserID, f.UserName, f.Name, f.TunnelID, f.RemoteAddr, f.Strategy, protocol, nullableText(f.DNSServer), f.IdleTimeout, f.InFlow, f.OutFlow, f.CreatedTime, now, f.Status, f.Inx)
 		if err != nil {
-			return count, err
+			return count, store.WrapError("importForwards", err)
 		}
 		count++
 	}
@@ -2873,7 +4819,7 @@ func (r *Repository) importUserTunnels(db Execer, userTunnels []UserTunnelBackup
 				status = excluded.status
 		`, ut.ID, ut.UserID, ut.TunnelID, speedID, ut.Num, ut.Flow, ut.InFlow, ut.OutFlow, ut.FlowResetTime, ut.ExpTime, ut.Status)
 		if err != nil {
-			return count, err
+			return count, store.WrapError("importUserTunnels", err)
 		}
 		count++
 	}
@@ -2895,7 +4841,7 @@ func (r *Repository) importSpeedLimits(db Execer, speedLimits []SpeedLimitBackup
 				status = excluded.status
 		`, sl.ID, sl.Name, sl.Speed, sl.TunnelID, sl.TunnelName, sl.CreatedTime, now, sl.Status)
 		if err != nil {
-			return count, err
+			return count, store.WrapError("importSpeedLimits", err)
 		}
 		count++
 	}
@@ -2914,11 +4860,11 @@ func (r *Repository) importTunnelGroups(db Execer, tunnelGroups []TunnelGroupBac
 				status = excluded.status
 		`, tg.ID, tg.Name, tg.CreatedTime, now, tg.Status)
 		if err != nil {
-			return count, err
+			return count, store.WrapError("importTunnelGroups", err)
 		}
 		_, err = db.Exec(`DELETE FROM tunnel_group_tunnel WHERE tunnel_group_id = ?`, tg.ID)
 		if err != nil {
-			return count, err
+			return count, store.WrapError("importTunnelGroups", err)
 		}
 		for _, tunnelID := range tg.Tunnels {
 			_, err = db.Exec(`
@@ -2926,7 +4872,7 @@ func (r *Repository) importTunnelGroups(db Execer, tunnelGroups []TunnelGroupBac
 				VALUES(?, ?, ?)
 			`, tg.ID, tunnelID, now)
 			if err != nil {
-				return count, err
+				return count, store.WrapError("importTunnelGroups", err)
 			}
 		}
 		count++
@@ -2946,11 +4892,11 @@ func (r *Repository) importUserGroups(db Execer, userGroups []UserGroupBackup, n
 				status = excluded.status
 		`, ug.ID, ug.Name, ug.CreatedTime, now, ug.Status)
 		if err != nil {
-			return count, err
+			return count, store.WrapError("importUserGroups", err)
 		}
 		_, err = db.Exec(`DELETE FROM user_group_user WHERE user_group_id = ?`, ug.ID)
 		if err != nil {
-			return count, err
+			return count, store.WrapError("importUserGroups", err)
 		}
 		for _, userID := range ug.Users {
 			_, err = db.Exec(`
@@ -2958,7 +4904,7 @@ func (r *Repository) importUserGroups(db Execer, userGroups []UserGroupBackup, n
 				VALUES(?, ?, ?)
 			`, ug.ID, userID, now)
 			if err != nil {
-				return count, err
+				return count, store.WrapError("importUserGroups", err)
 			}
 		}
 		count++
@@ -2978,7 +4924,7 @@ func (r *Repository) importPermissions(db Execer, permissions []PermissionBackup
 				created_by_group = excluded.created_by_group
 		`, p.ID, p.UserGroupID, p.TunnelGroupID, p.CreatedTime, p.CreatedByGroup)
 		if err != nil {
-			return count, err
+			return count, store.WrapError("importPermissions", err)
 		}
 		for _, g := range p.Grants {
 			_, err = db.Exec(`
@@ -2989,7 +4935,7 @@ func (r *Repository) importPermissions(db Execer, permissions []PermissionBackup
 					created_by_group = excluded.created_by_group
 			`, g.ID, g.UserGroupID, g.TunnelGroupID, g.UserTunnelID, g.CreatedTime, g.CreatedByGroup)
 			if err != nil {
-				return count, err
+				return count, store.WrapError("importPermissions", err)
 			}
 		}
 		count++
@@ -3000,11 +4946,2077 @@ func (r *Repository) importPermissions(db Execer, permissions []PermissionBackup
 func (r *Repository) importConfigs(db Execer, configs map[string]string, now int64) (int, error) {
 	count := 0
 	for name, value := range configs {
+		// The data key is wrapped with this panel's master key.
+		if name == FieldDataKeyConfigKey {
+			continue
+		}
 		err := r.UpsertConfig(name, value, now)
 		if err != nil {
-			return count, err
+			return count, store.WrapError("importConfigs", err)
 		}
 		count++
 	}
 	return count, nil
 }
+
+type Forward struct {
+	ID          int64  `json:"id"`
+	UserID      int64  `json:"userId"`
+	UserName    string `json:"userName"`
+	Name        string `json:"name"`
+	TunnelID    int64  `json:"tunnelId"`
+	TunnelName  string `json:"tunnelName"`
+	InIP        string `json:"inIp"`
+	RemoteAddr  string `json:"remoteAddr"`
+	Strategy    string `json:"strategy"`
+	InFlow      int64  `json:"inFlow"`
+	OutFlow     int64  `json:"outFlow"`
+	Status      int    `json:"status"`
+	CreatedTime int64  `json:"createdTime"`
+}
+
+type Tunnel struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Type        int    `json:"type"`
+	InIP        string `json:"inIp"`
+	Flow        int64  `json:"flow"`
+	Status      int    `json:"status"`
+	CreatedTime int64  `json:"createdTime"`
+}
+
+// FTSSearchForwards matches forwards by name, remote address and tunnel
+// ingress IP. SQLite uses the forward_fts index; PostgreSQL falls back to
+// ILIKE matching on the same columns.
+func (r *Repository) FTSSearchForwards(query string, page, pageSize int) ([]Forward, int, error) {
+	if r == nil || r.db == nil {
+		return nil, 0, errors.New("repository not initialized")
+	}
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []Forward{}, 0, nil
+	}
+	limit, offset := pageBounds(page, pageSize)
+
+	const columns = `f.id, f.user_id, f.user_name, f.name, f.tunnel_id, COALESCE(t.name, ''), COALESCE(t.in_ip, ''), f.remote_addr,
+		COALESCE(f.strategy, 'fifo'), f.in_flow, f.out_flow, f.status, f.created_time`
+
+	var where string
+	var args []interface{}
+	if r.db.Dialect() == store.DialectPostgres {
+		clauses := make([]string, 0, len(terms))
+		for _, term := range terms {
+			clauses = append(clauses, `(f.name ILIKE ? OR f.remote_addr ILIKE ? OR COALESCE(t.in_ip, '') ILIKE ?)`)
+			pattern := "%" + term + "%"
+			args = append(args, pattern, pattern, pattern)
+		}
+		where = strings.Join(clauses, " AND ")
+	} else {
+		where = `f.id IN (SELECT forward_id FROM forward_fts WHERE forward_fts MATCH ?)`
+		args = append(args, ftsMatchExpr(terms))
+	}
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM forward f LEFT JOIN tunnel t ON t.id = f.tunnel_id WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, store.WrapError("FTSSearchForwards", fmt.Errorf("count forward search failed: %w", err))
+	}
+
+	rows, err := r.db.Query(`SELECT `+columns+` FROM forward f LEFT JOIN tunnel t ON t.id = f.tunnel_id WHERE `+where+` ORDER BY f.inx ASC, f.id ASC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, store.WrapError("FTSSearchForwards", fmt.Errorf("forward search failed: %w", err))
+	}
+	defer rows.Close()
+
+	items := make([]Forward, 0)
+	for rows.Next() {
+		var f Forward
+		if err := rows.Scan(&f.ID, &f.UserID, &f.UserName, &f.Name, &f.TunnelID, &f.TunnelName, &f.InIP, &f.RemoteAddr, &f.Strategy, &f.InFlow, &f.OutFlow, &f.Status, &f.CreatedTime); err != nil {
+			return nil, 0, store.WrapError("FTSSearchForwards", err)
+		}
+		items = append(items, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, store.WrapError("FTSSearchForwards", err)
+	}
+	return items, total, nil
+}
+
+// FTSSearchTunnels matches tunnels by name using the tunnel_fts index.
+func (r *Repository) FTSSearchTunnels(query string, page, pageSize int) ([]Tunnel, int, error) {
+	if r == nil || r.db == nil {
+		return nil, 0, errors.New("repository not initialized")
+	}
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []Tunnel{}, 0, nil
+	}
+	limit, offset := pageBounds(page, pageSize)
+
+	var where string
+	var args []interface{}
+	if r.db.Dialect() == store.DialectPostgres {
+		clauses := make([]string, 0, len(terms))
+		for _, term := range terms {
+			clauses = append(clauses, `name ILIKE ?`)
+			args = append(args, "%"+term+"%")
+		}
+		where = strings.Join(clauses, " AND ")
+	} else {
+		where = `id IN (SELECT tunnel_id FROM tunnel_fts WHERE tunnel_fts MATCH ?)`
+		args = append(args, ftsMatchExpr(terms))
+	}
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM tunnel WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, store.WrapError("FTSSearchTunnels", fmt.Errorf("count tunnel search failed: %w", err))
+	}
+
+	rows, err := r.db.Query(`SELECT id, name, type, COALESCE(in_ip, ''), flow, status, created_time FROM tunnel WHERE `+where+` ORDER BY inx ASC, id ASC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, store.WrapError("FTSSearchTunnels", fmt.Errorf("tunnel search failed: %w", err))
+	}
+	defer rows.Close()
+
+	items := make([]Tunnel, 0)
+	for rows.Next() {
+		var t Tunnel
+		if err := rows.Scan(&t.ID, &t.Name, &t.Type, &t.InIP, &t.Flow, &t.Status, &t.CreatedTime); err != nil {
+			return nil, 0, store.WrapError("FTSSearchTunnels", err)
+		}
+		items = append(items, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, store.WrapError("FTSSearchTunnels", err)
+	}
+	return items, total, nil
+}
+
+func searchTerms(query string) []string {
+	fields := strings.Fields(query)
+	terms := make([]string, 0, len(fields))
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			terms = append(terms, f)
+		}
+	}
+	return terms
+}
+
+// ftsMatchExpr quotes every term so user input cannot inject FTS5 operators,
+// and makes each term a prefix match.
+func ftsMatchExpr(terms []string) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		parts = append(parts, `"`+strings.ReplaceAll(term, `"`, `""`)+`"*`)
+	}
+	return strings.Join(parts, " AND ")
+}
+
+func pageBounds(page, pageSize int) (int, int) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+	if pageSize > 200 {
+		pageSize = 200
+	}
+	return pageSize, (page - 1) * pageSize
+}
+
+// searchIndexUpdateTriggers are the schema.sql update triggers of the search
+// index. They only fire for the searched columns, so the frequent flow and
+// status updates do not rewrite the index.
+var searchIndexUpdateTriggers = []string{
+	`DROP TRIGGER IF EXISTS forward_fts_au`,
+	`CREATE TRIGGER forward_fts_au AFTER UPDATE OF name, remote_addr, tunnel_id ON forward BEGIN
+	  DELETE FROM forward_fts WHERE forward_id = OLD.id;
+	  INSERT INTO forward_fts(forward_id, name, remote_addr, in_ip)
+	  VALUES (NEW.id, NEW.name, NEW.remote_addr, COALESCE((SELECT in_ip FROM tunnel WHERE id = NEW.tunnel_id), ''));
+	END`,
+	`DROP TRIGGER IF EXISTS tunnel_fts_au`,
+	`CREATE TRIGGER tunnel_fts_au AFTER UPDATE OF name, in_ip ON tunnel BEGIN
+	  DELETE FROM tunnel_fts WHERE tunnel_id = OLD.id;
+	  INSERT INTO tunnel_fts(tunnel_id, name) VALUES (NEW.id, NEW.name);
+	  UPDATE forward_fts SET in_ip = COALESCE(NEW.in_ip, '')
+	  WHERE forward_id IN (SELECT id FROM forward WHERE tunnel_id = NEW.id);
+	END`,
+}
+
+// replaceSearchIndexTriggers swaps in the column-scoped update triggers on
+// databases created before they were scoped; schema.sql's IF NOT EXISTS keeps
+// the old ones there.
+func replaceSearchIndexTriggers(db *store.DB) error {
+	if db == nil || db.Dialect() != store.DialectSQLite {
+		return nil
+	}
+	for _, stmt := range searchIndexUpdateTriggers {
+		if _, err := db.Exec(stmt); err != nil {
+			if isMissingTableError(db.Dialect(), err) {
+				return nil
+			}
+			return fmt.Errorf("replace search index triggers: %w", err)
+		}
+	}
+	return nil
+}
+
+func rebuildSearchIndex(db *store.DB) error {
+	if db == nil || db.Dialect() != store.DialectSQLite {
+		return nil
+	}
+	stmts := []string{
+		`DELETE FROM forward_fts`,
+		`INSERT INTO forward_fts(forward_id, name, remote_addr, in_ip)
+		 SELECT f.id, f.name, f.remote_addr, COALESCE(t.in_ip, '') FROM forward f LEFT JOIN tunnel t ON t.id = f.tunnel_id`,
+		`DELETE FROM tunnel_fts`,
+		`INSERT INTO tunnel_fts(tunnel_id, name) SELECT id, name FROM tunnel`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			if isMissingTableError(db.Dialect(), err) {
+				return nil
+			}
+			return fmt.Errorf("rebuild search index: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReservedPort is a port range on a node that must never be handed out by
+// automatic allocation, e.g. because another service on the host owns it.
+type ReservedPort struct {
+	ID          int64  `json:"id"`
+	NodeID      int64  `json:"nodeId"`
+	PortStart   int    `json:"portStart"`
+	PortEnd     int    `json:"portEnd"`
+	Reason      string `json:"reason"`
+	CreatedTime int64  `json:"createdTime"`
+}
+
+func (p ReservedPort) Contains(port int) bool {
+	return port >= p.PortStart && port <= p.PortEnd
+}
+
+func (r *Repository) CreateReservedPort(nodeID int64, portStart, portEnd int, reason string) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	id, err := r.db.ExecReturningID(`INSERT INTO reserved_port(node_id, port_start, port_end, reason, created_time) VALUES(?, ?, ?, ?, ?)`,
+		nodeID, portStart, portEnd, reason, unixMilliNow())
+	if err != nil {
+		return 0, store.WrapError("CreateReservedPort", fmt.Errorf("create reserved port failed: %w", err))
+	}
+	return id, nil
+}
+
+func (r *Repository) DeleteReservedPort(id int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	if _, err := r.db.Exec(`DELETE FROM reserved_port WHERE id = ?`, id); err != nil {
+		return store.WrapError("DeleteReservedPort", fmt.Errorf("delete reserved port failed: %w", err))
+	}
+	return nil
+}
+
+// ListReservedPorts returns the reserved ranges of nodeID, or of every node
+// when nodeID is 0.
+func (r *Repository) ListReservedPorts(nodeID int64) ([]ReservedPort, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	return ListReservedPortsWith(r.db, nodeID)
+}
+
+// ListReservedPortsWith is ListReservedPorts for callers that already hold a
+// transaction.
+func ListReservedPortsWith(db Execer, nodeID int64) ([]ReservedPort, error) {
+	query := `SELECT id, node_id, port_start, port_end, reason, created_time FROM reserved_port`
+	args := []interface{}{}
+	if nodeID > 0 {
+		query += ` WHERE node_id = ?`
+		args = append(args, nodeID)
+	}
+	query += ` ORDER BY node_id ASC, port_start ASC, id ASC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query reserved ports failed: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]ReservedPort, 0)
+	for rows.Next() {
+		var p ReservedPort
+		if err := rows.Scan(&p.ID, &p.NodeID, &p.PortStart, &p.PortEnd, &p.Reason, &p.CreatedTime); err != nil {
+			return nil, fmt.Errorf("scan reserved port failed: %w", err)
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// FindPortReservation returns the reservation covering port on nodeID, or nil
+// when the port is not reserved.
+func (r *Repository) FindPortReservation(nodeID int64, port int) (*ReservedPort, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var p ReservedPort
+	err := r.db.QueryRow(`
+		SELECT id, node_id, port_start, port_end, reason, created_time
+		FROM reserved_port
+		WHERE node_id = ? AND port_start <= ? AND port_end >= ?
+		ORDER BY id ASC
+		LIMIT 1
+	`, nodeID, port, port).Scan(&p.ID, &p.NodeID, &p.PortStart, &p.PortEnd, &p.Reason, &p.CreatedTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, store.WrapError("FindPortReservation", fmt.Errorf("query reserved port failed: %w", err))
+	}
+	return &p, nil
+}
+
+func (r *Repository) IsPortReserved(nodeID int64, port int) bool {
+	p, err := r.FindPortReservation(nodeID, port)
+	return err == nil && p != nil
+}
+
+// ForwardWithIdleTimeout carries a forward's configured idle timeout and the
+// value that is actually enforced once the panel-wide default is applied.
+type ForwardWithIdleTimeout struct {
+	ID                      int64 `json:"id"`
+	IdleTimeoutSec          int   `json:"idleTimeoutSec"`
+	EffectiveIdleTimeoutSec int   `json:"effectiveIdleTimeoutSec"`
+}
+
+// GetForwardWithIdleTimeout resolves the idle timeout of forwardID, falling back
+// to the default_forward_idle_timeout_sec config when the forward has none.
+// It returns nil when the forward does not exist.
+func (r *Repository) GetForwardWithIdleTimeout(forwardID int64) (*ForwardWithIdleTimeout, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+
+	out := &ForwardWithIdleTimeout{ID: forwardID}
+	err := r.db.QueryRow(`SELECT COALESCE(idle_timeout_sec, 0) FROM forward WHERE id = ? LIMIT 1`, forwardID).Scan(&out.IdleTimeoutSec)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, store.WrapError("GetForwardWithIdleTimeout", fmt.Errorf("query forward idle timeout failed: %w", err))
+	}
+	out.EffectiveIdleTimeoutSec = out.IdleTimeoutSec
+	if out.EffectiveIdleTimeoutSec > 0 {
+		return out, nil
+	}
+
+	cfg, err := r.GetConfigByName("default_forward_idle_timeout_sec")
+	if err != nil {
+		return nil, store.WrapError("GetForwardWithIdleTimeout", fmt.Errorf("query default idle timeout failed: %w", err))
+	}
+	if cfg != nil {
+		if v, convErr := strconv.Atoi(strings.TrimSpace(cfg.Value)); convErr == nil && v > 0 {
+			out.EffectiveIdleTimeoutSec = v
+		}
+	}
+	return out, nil
+}
+
+// IncrementForwardRevision bumps the service revision of a forward and
+// returns the new value. Nodes compare it against the revision they already
+// run to tell a new AddService from a replay.
+func (r *Repository) IncrementForwardRevision(forwardID int64) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, store.WrapError("IncrementForwardRevision", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec(`UPDATE forward SET service_revision = service_revision + 1 WHERE id = ?`, forwardID)
+	if err != nil {
+		return 0, store.WrapError("IncrementForwardRevision", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, store.WrapError("IncrementForwardRevision", sql.ErrNoRows)
+	}
+	var revision int64
+	if err := tx.QueryRow(`SELECT service_revision FROM forward WHERE id = ?`, forwardID).Scan(&revision); err != nil {
+		return 0, store.WrapError("IncrementForwardRevision", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, store.WrapError("IncrementForwardRevision", err)
+	}
+	return revision, nil
+}
+
+// HourlyFlow is the traffic a user generated during one statistics hour.
+type HourlyFlow struct {
+	HourStart int64 `json:"hourStart"`
+	Flow      int64 `json:"flow"`
+}
+
+// GetUserFlowSummary returns the user's flow quota in bytes together with the
+// bytes consumed so far.
+func (r *Repository) GetUserFlowSummary(userID int64) (int64, int64, error) {
+	if r == nil || r.db == nil {
+		return 0, 0, errors.New("repository not initialized")
+	}
+	var flowGB, inFlow, outFlow int64
+	err := r.db.QueryRow(`SELECT flow, in_flow, out_flow FROM user WHERE id = ?`, userID).Scan(&flowGB, &inFlow, &outFlow)
+	if err != nil {
+		return 0, 0, fmt.Errorf("query user flow failed: %w", err)
+	}
+	return flowGB * bytesPerGB, inFlow + outFlow, nil
+}
+
+// CountUserActiveTunnels counts the enabled tunnels the user may currently use.
+func (r *Repository) CountUserActiveTunnels(userID int64, nowMs int64) (int, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	var n int
+	err := r.db.QueryRow(`
+		SELECT COUNT(1)
+		FROM user_tunnel ut
+		JOIN tunnel t ON t.id = ut.tunnel_id
+		WHERE ut.user_id = ? AND ut.status = 1 AND t.status = 1
+		  AND (ut.exp_time <= 0 OR ut.exp_time > ?)
+	`, userID, nowMs).Scan(&n)
+	if err != nil {
+		return 0, store.WrapError("CountUserActiveTunnels", fmt.Errorf("count active tunnels failed: %w", err))
+	}
+	return n, nil
+}
+
+func (r *Repository) CountUserActiveForwards(userID int64) (int, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	var n int
+	if err := r.db.QueryRow(`SELECT COUNT(1) FROM forward WHERE user_id = ? AND status = 1`, userID).Scan(&n); err != nil {
+		return 0, store.WrapError("CountUserActiveForwards", fmt.Errorf("count active forwards failed: %w", err))
+	}
+	return n, nil
+}
+
+// BulkExtendUserTunnelExpiry pushes back the expiry of the tunnel's user
+// permissions by extensionMs and re-enables them. Permissions without an
+// expiry are left alone; when beforeMs is positive only those expiring
+// before it are extended. It returns the number of permissions updated.
+func (r *Repository) BulkExtendUserTunnelExpiry(tunnelID int64, extensionMs int64, beforeMs int64) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	query := `UPDATE user_tunnel SET exp_time = exp_time + ?, status = 1 WHERE tunnel_id = ? AND exp_time > 0`
+	args := []interface{}{extensionMs, tunnelID}
+	if beforeMs > 0 {
+		query += ` AND exp_time < ?`
+		args = append(args, beforeMs)
+	}
+	res, err := r.db.Exec(query, args...)
+	if err != nil {
+		return 0, store.WrapError("BulkExtendUserTunnelExpiry", fmt.Errorf("extend user tunnels failed: %w", err))
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, store.WrapError("BulkExtendUserTunnelExpiry", err)
+	}
+	return n, nil
+}
+
+// CountUserTunnelsExpiringBefore counts the user's tunnel permissions that are
+// still valid at nowMs but expire no later than untilMs.
+func (r *Repository) CountUserTunnelsExpiringBefore(userID int64, nowMs, untilMs int64) (int, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	var n int
+	err := r.db.QueryRow(`
+		SELECT COUNT(1) FROM user_tunnel
+		WHERE user_id = ? AND exp_time > ? AND exp_time <= ?
+	`, userID, nowMs, untilMs).Scan(&n)
+	if err != nil {
+		return 0, store.WrapError("CountUserTunnelsExpiringBefore", fmt.Errorf("count expiring tunnels failed: %w", err))
+	}
+	return n, nil
+}
+
+// GetUserHourlyFlow returns the user's hourly statistics recorded at or after
+// sinceMs, oldest first.
+func (r *Repository) GetUserHourlyFlow(userID int64, sinceMs int64) ([]HourlyFlow, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`
+		SELECT created_time, flow FROM statistics_flow
+		WHERE user_id = ? AND created_time >= ?
+		ORDER BY created_time ASC, id ASC
+	`, userID, sinceMs)
+	if err != nil {
+		return nil, store.WrapError("GetUserHourlyFlow", fmt.Errorf("query hourly flow failed: %w", err))
+	}
+	defer rows.Close()
+
+	out := make([]HourlyFlow, 0)
+	for rows.Next() {
+		var item HourlyFlow
+		if err := rows.Scan(&item.HourStart, &item.Flow); err != nil {
+			return nil, store.WrapError("GetUserHourlyFlow", fmt.Errorf("scan hourly flow failed: %w", err))
+		}
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+// AuditLog records an administrative or system action for later review.
+// UserID is 0 for actions taken by the panel itself.
+type AuditLog struct {
+	ID          int64
+	UserID      int64
+	Username    string
+	Action      string
+	TargetType  string
+	TargetID    int64
+	Detail      string
+	CreatedTime int64
+}
+
+func (r *Repository) CreateAuditLog(entry *AuditLog) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	if entry == nil || strings.TrimSpace(entry.Action) == "" {
+		return errors.New("audit action is required")
+	}
+	if entry.CreatedTime <= 0 {
+		entry.CreatedTime = unixMilliNow()
+	}
+	id, err := r.db.ExecReturningID(`
+		INSERT INTO audit_log(user_id, username, action, target_type, target_id, detail, created_time)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+	`, entry.UserID, entry.Username, entry.Action, entry.TargetType, entry.TargetID, entry.Detail, entry.CreatedTime)
+	if err != nil {
+		return store.WrapError("CreateAuditLog", fmt.Errorf("insert audit log failed: %w", err))
+	}
+	entry.ID = id
+	return nil
+}
+
+// ListAuditLogs pages through the audit log, newest first. An empty action
+// lists every entry.
+func (r *Repository) ListAuditLogs(action string, page, pageSize int) ([]map[string]interface{}, int, error) {
+	if r == nil || r.db == nil {
+		return nil, 0, errors.New("repository not initialized")
+	}
+
+	where := ""
+	args := make([]interface{}, 0, 3)
+	if action = strings.TrimSpace(action); action != "" {
+		where = "WHERE action = ?"
+		args = append(args, action)
+	}
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(1) FROM audit_log `+where, args...).Scan(&total); err != nil {
+		return nil, 0, store.WrapError("ListAuditLogs", fmt.Errorf("count audit log failed: %w", err))
+	}
+
+	limit, offset := pageBounds(page, pageSize)
+	rows, err := r.db.Query(`
+		SELECT id, user_id, username, action, target_type, target_id, detail, created_time
+		FROM audit_log `+where+`
+		ORDER BY created_time DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, store.WrapError("ListAuditLogs", fmt.Errorf("query audit log failed: %w", err))
+	}
+	defer rows.Close()
+
+	items := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var e AuditLog
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Username, &e.Action, &e.TargetType, &e.TargetID, &e.Detail, &e.CreatedTime); err != nil {
+			return nil, 0, store.WrapError("ListAuditLogs", fmt.Errorf("scan audit log failed: %w", err))
+		}
+		items = append(items, map[string]interface{}{
+			"id":          e.ID,
+			"userId":      e.UserID,
+			"username":    e.Username,
+			"action":      e.Action,
+			"targetType":  e.TargetType,
+			"targetId":    e.TargetID,
+			"detail":      e.Detail,
+			"createdTime": e.CreatedTime,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, store.WrapError("ListAuditLogs", err)
+	}
+	return items, total, nil
+}
+
+// ForwardStatusError marks a forward whose service a node failed to start.
+const ForwardStatusError = -1
+
+// GetForwardIDByNodePort returns the forward listening on port of nodeID, or 0.
+func (r *Repository) GetForwardIDByNodePort(nodeID int64, port int) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	var forwardID int64
+	err := r.db.QueryRow(`SELECT forward_id FROM forward_port WHERE node_id = ? AND port = ? ORDER BY forward_id ASC LIMIT 1`, nodeID, port).Scan(&forwardID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, store.WrapError("GetForwardIDByNodePort", fmt.Errorf("query forward by port failed: %w", err))
+	}
+	return forwardID, nil
+}
+
+// MarkForwardServiceFailed puts a forward into the error state.
+func (r *Repository) MarkForwardServiceFailed(forwardID int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`UPDATE forward SET status = ?, updated_time = ? WHERE id = ?`, ForwardStatusError, unixMilliNow(), forwardID)
+	return store.WrapError("MarkForwardServiceFailed", err)
+}
+
+// MarkForwardServiceStarted confirms a forward as running. Paused forwards
+// keep their status; only active and errored forwards are touched.
+func (r *Repository) MarkForwardServiceStarted(forwardID int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`UPDATE forward SET status = 1, updated_time = ? WHERE id = ? AND status IN (1, ?)`, unixMilliNow(), forwardID, ForwardStatusError)
+	return store.WrapError("MarkForwardServiceStarted", err)
+}
+
+func (r *Repository) ListForwardsByStatus(status int) ([]map[string]interface{}, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`
+		SELECT f.id, f.name, f.user_id, f.user_name, f.tunnel_id, COALESCE(t.name, ''), f.status, f.updated_time
+		FROM forward f
+		LEFT JOIN tunnel t ON t.id = f.tunnel_id
+		WHERE f.status = ?
+		ORDER BY f.updated_time DESC, f.id DESC
+	`, status)
+	if err != nil {
+		return nil, store.WrapError("ListForwardsByStatus", fmt.Errorf("query forwards by status failed: %w", err))
+	}
+	defer rows.Close()
+
+	items := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, userID, tunnelID, updatedTime int64
+		var name, userName, tunnelName string
+		var st int
+		if err := rows.Scan(&id, &name, &userID, &userName, &tunnelID, &tunnelName, &st, &updatedTime); err != nil {
+			return nil, store.WrapError("ListForwardsByStatus", fmt.Errorf("scan forward failed: %w", err))
+		}
+		items = append(items, map[string]interface{}{
+			"id":          id,
+			"name":        name,
+			"userId":      userID,
+			"userName":    userName,
+			"tunnelId":    tunnelID,
+			"tunnelName":  tunnelName,
+			"status":      st,
+			"updatedTime": updatedTime,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("ListForwardsByStatus", err)
+	}
+	return items, nil
+}
+
+// GetUserTOTP returns whether TOTP is enabled for the user together with the
+// stored (encrypted) secret.
+func (r *Repository) GetUserTOTP(userID int64) (bool, string, error) {
+	if r == nil || r.db == nil {
+		return false, "", errors.New("repository not initialized")
+	}
+	var enabled int
+	var secret string
+	err := r.db.QueryRow(`SELECT totp_enabled, totp_secret FROM user WHERE id = ?`, userID).Scan(&enabled, &secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, "", nil
+		}
+		return false, "", fmt.Errorf("query user totp failed: %w", err)
+	}
+	return enabled == 1, secret, nil
+}
+
+// SetPendingUserTOTP stores a freshly generated secret that is not enforced
+// until EnableUserTOTP confirms the user can produce codes from it.
+func (r *Repository) SetPendingUserTOTP(userID int64, encryptedSecret string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`UPDATE user SET totp_enabled = 0, totp_secret = ?, updated_time = ? WHERE id = ?`, encryptedSecret, now, userID)
+	return store.WrapError("SetPendingUserTOTP", err)
+}
+
+// EnableUserTOTP turns on TOTP for the user and replaces their recovery
+// codes with codeHashes.
+func (r *Repository) EnableUserTOTP(userID int64, codeHashes []string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return store.WrapError("EnableUserTOTP", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`UPDATE user SET totp_enabled = 1, updated_time = ? WHERE id = ?`, now, userID); err != nil {
+		return store.WrapError("EnableUserTOTP", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM user_recovery_code WHERE user_id = ?`, userID); err != nil {
+		return store.WrapError("EnableUserTOTP", err)
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO user_recovery_code(user_id, code_hash, used_time, created_time) VALUES(?, ?, 0, ?)`, userID, hash, now); err != nil {
+			return store.WrapError("EnableUserTOTP", err)
+		}
+	}
+	return store.WrapError("EnableUserTOTP", tx.Commit())
+}
+
+// DisableUserTOTP clears the user's secret and recovery codes.
+func (r *Repository) DisableUserTOTP(userID int64, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return store.WrapError("DisableUserTOTP", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`UPDATE user SET totp_enabled = 0, totp_secret = '', updated_time = ? WHERE id = ?`, now, userID); err != nil {
+		return store.WrapError("DisableUserTOTP", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM user_recovery_code WHERE user_id = ?`, userID); err != nil {
+		return store.WrapError("DisableUserTOTP", err)
+	}
+	return store.WrapError("DisableUserTOTP", tx.Commit())
+}
+
+// UseRecoveryCode consumes an unused recovery code of the user. It reports
+// false when no such code exists or it was already used.
+func (r *Repository) UseRecoveryCode(userID int64, codeHash string, now int64) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	res, err := r.db.Exec(`UPDATE user_recovery_code SET used_time = ? WHERE user_id = ? AND code_hash = ? AND used_time = 0`, now, userID, codeHash)
+	if err != nil {
+		return false, store.WrapError("UseRecoveryCode", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, store.WrapError("UseRecoveryCode", err)
+	}
+	return n > 0, nil
+}
+
+func (r *Repository) CountUnusedRecoveryCodes(userID int64) (int, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	var n int
+	if err := r.db.QueryRow(`SELECT COUNT(1) FROM user_recovery_code WHERE user_id = ? AND used_time = 0`, userID).Scan(&n); err != nil {
+		return 0, store.WrapError("CountUnusedRecoveryCodes", err)
+	}
+	return n, nil
+}
+
+// GetUserIDByOIDCIdentity returns the local user linked to the external
+// subject, or 0 when there is none.
+func (r *Repository) GetUserIDByOIDCIdentity(issuer, subject string) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	var userID int64
+	err := r.db.QueryRow(`
+		SELECT u.id FROM user_oidc_identity i
+		JOIN user u ON u.id = i.user_id
+		WHERE i.issuer = ? AND i.subject = ?
+	`, issuer, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, store.WrapError("GetUserIDByOIDCIdentity", err)
+	}
+	return userID, nil
+}
+
+// LinkOIDCIdentity links the external subject to userID, replacing a link
+// left behind by a deleted user.
+func (r *Repository) LinkOIDCIdentity(userID int64, issuer, subject string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return store.WrapError("LinkOIDCIdentity", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`DELETE FROM user_oidc_identity WHERE issuer = ? AND subject = ?`, issuer, subject); err != nil {
+		return store.WrapError("LinkOIDCIdentity", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO user_oidc_identity(user_id, issuer, subject, created_time) VALUES(?, ?, ?, ?)`, userID, issuer, subject, now); err != nil {
+		return store.WrapError("LinkOIDCIdentity", err)
+	}
+	return store.WrapError("LinkOIDCIdentity", tx.Commit())
+}
+
+// WebAuthnCredential is a passkey registered by a user. CredentialID and
+// PublicKey are stored base64url-encoded; PublicKey is a COSE key.
+type WebAuthnCredential struct {
+	ID           int64  `json:"id"`
+	UserID       int64  `json:"userId"`
+	Name         string `json:"name"`
+	CredentialID string `json:"credentialId"`
+	PublicKey    string `json:"-"`
+	SignCount    uint32 `json:"-"`
+	LastUsedTime int64  `json:"lastUsedTime"`
+	CreatedTime  int64  `json:"createdTime"`
+}
+
+func (r *Repository) CreateWebAuthnCredential(cred *WebAuthnCredential) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	id, err := r.db.ExecReturningID(`
+		INSERT INTO webauthn_credential(user_id, name, credential_id, public_key, sign_count, last_used_time, created_time)
+		VALUES(?, ?, ?, ?, ?, 0, ?)
+	`, cred.UserID, cred.Name, cred.CredentialID, cred.PublicKey, int64(cred.SignCount), cred.CreatedTime)
+	if err != nil {
+		return 0, store.WrapError("CreateWebAuthnCredential", err)
+	}
+	return id, nil
+}
+
+// ListWebAuthnCredentials returns the user's passkeys, oldest first.
+func (r *Repository) ListWebAuthnCredentials(userID int64) ([]WebAuthnCredential, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`
+		SELECT id, user_id, name, credential_id, public_key, sign_count, last_used_time, created_time
+		FROM webauthn_credential WHERE user_id = ? ORDER BY id ASC
+	`, userID)
+	if err != nil {
+		return nil, store.WrapError("ListWebAuthnCredentials", err)
+	}
+	defer rows.Close()
+	creds := make([]WebAuthnCredential, 0)
+	for rows.Next() {
+		var c WebAuthnCredential
+		var signCount int64
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Name, &c.CredentialID, &c.PublicKey, &signCount, &c.LastUsedTime, &c.CreatedTime); err != nil {
+			return nil, store.WrapError("ListWebAuthnCredentials", err)
+		}
+		c.SignCount = uint32(signCount)
+		creds = append(creds, c)
+	}
+	return creds, store.WrapError("ListWebAuthnCredentials", rows.Err())
+}
+
+// GetWebAuthnCredential looks a passkey up by its credential ID. It returns
+// nil when there is none.
+func (r *Repository) GetWebAuthnCredential(credentialID string) (*WebAuthnCredential, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var c WebAuthnCredential
+	var signCount int64
+	err := r.db.QueryRow(`
+		SELECT id, user_id, name, credential_id, public_key, sign_count, last_used_time, created_time
+		FROM webauthn_credential WHERE credential_id = ?
+	`, credentialID).Scan(&c.ID, &c.UserID, &c.Name, &c.CredentialID, &c.PublicKey, &signCount, &c.LastUsedTime, &c.CreatedTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, store.WrapError("GetWebAuthnCredential", err)
+	}
+	c.SignCount = uint32(signCount)
+	return &c, nil
+}
+
+// UpdateWebAuthnSignCount records a successful assertion. It only moves the
+// counter forward, so it reports false when a concurrent login with the
+// same counter won the race.
+func (r *Repository) UpdateWebAuthnSignCount(id int64, oldCount, newCount uint32, now int64) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	res, err := r.db.Exec(`
+		UPDATE webauthn_credential SET sign_count = ?, last_used_time = ?
+		WHERE id = ? AND sign_count = ?
+	`, int64(newCount), now, id, int64(oldCount))
+	if err != nil {
+		return false, store.WrapError("UpdateWebAuthnSignCount", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, store.WrapError("UpdateWebAuthnSignCount", err)
+	}
+	return n > 0, nil
+}
+
+// DeleteWebAuthnCredential removes one of userID's passkeys. It reports
+// false when the passkey does not belong to the user.
+func (r *Repository) DeleteWebAuthnCredential(id, userID int64) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	res, err := r.db.Exec(`DELETE FROM webauthn_credential WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return false, store.WrapError("DeleteWebAuthnCredential", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, store.WrapError("DeleteWebAuthnCredential", err)
+	}
+	return n > 0, nil
+}
+
+// JWTSigningKey is a rotated token signing key. Secret is stored encrypted
+// with the static JWT secret. RetiredTime is when a newer key replaced it,
+// 0 while it is the current key.
+type JWTSigningKey struct {
+	ID          int64
+	Kid         string
+	Secret      string
+	CreatedTime int64
+	RetiredTime int64
+}
+
+// ListJWTSigningKeys returns every rotated key, oldest first.
+func (r *Repository) ListJWTSigningKeys() ([]JWTSigningKey, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`SELECT id, kid, secret, created_time, retired_time FROM jwt_signing_key ORDER BY id ASC`)
+	if err != nil {
+		return nil, store.WrapError("ListJWTSigningKeys", err)
+	}
+	defer rows.Close()
+	keys := make([]JWTSigningKey, 0)
+	for rows.Next() {
+		var k JWTSigningKey
+		if err := rows.Scan(&k.ID, &k.Kid, &k.Secret, &k.CreatedTime, &k.RetiredTime); err != nil {
+			return nil, store.WrapError("ListJWTSigningKeys", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, store.WrapError("ListJWTSigningKeys", rows.Err())
+}
+
+// RotateJWTSigningKey retires the current key and makes a new one current.
+func (r *Repository) RotateJWTSigningKey(kid, secret string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return store.WrapError("RotateJWTSigningKey", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`UPDATE jwt_signing_key SET retired_time = ? WHERE retired_time = 0`, now); err != nil {
+		return store.WrapError("RotateJWTSigningKey", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO jwt_signing_key(kid, secret, created_time, retired_time) VALUES(?, ?, ?, 0)`, kid, secret, now); err != nil {
+		return store.WrapError("RotateJWTSigningKey", err)
+	}
+	return store.WrapError("RotateJWTSigningKey", tx.Commit())
+}
+
+// APIKey is a long-lived credential a user minted for scripts. Only the
+// hash of the key is stored.
+type APIKey struct {
+	ID           int64  `json:"id"`
+	UserID       int64  `json:"userId"`
+	Name         string `json:"name"`
+	KeyPrefix    string `json:"keyPrefix"`
+	KeyHash      string `json:"-"`
+	LastUsedTime int64  `json:"lastUsedTime"`
+	RevokedTime  int64  `json:"revokedTime"`
+	CreatedTime  int64  `json:"createdTime"`
+}
+
+func (r *Repository) CreateAPIKey(key *APIKey) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	id, err := r.db.ExecReturningID(`
+		INSERT INTO user_api_key(user_id, name, key_prefix, key_hash, last_used_time, revoked_time, created_time)
+		VALUES(?, ?, ?, ?, 0, 0, ?)
+	`, key.UserID, key.Name, key.KeyPrefix, key.KeyHash, key.CreatedTime)
+	if err != nil {
+		return 0, store.WrapError("CreateAPIKey", err)
+	}
+	return id, nil
+}
+
+// ListAPIKeys returns the user's keys, revoked ones included, newest first.
+func (r *Repository) ListAPIKeys(userID int64) ([]APIKey, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`
+		SELECT id, user_id, name, key_prefix, key_hash, last_used_time, revoked_time, created_time
+		FROM user_api_key WHERE user_id = ? ORDER BY id DESC
+	`, userID)
+	if err != nil {
+		return nil, store.WrapError("ListAPIKeys", err)
+	}
+	defer rows.Close()
+	keys := make([]APIKey, 0)
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.UserID, &k.Name, &k.KeyPrefix, &k.KeyHash, &k.LastUsedTime, &k.RevokedTime, &k.CreatedTime); err != nil {
+			return nil, store.WrapError("ListAPIKeys", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, store.WrapError("ListAPIKeys", rows.Err())
+}
+
+// RevokeAPIKey revokes one of userID's keys. It reports false when the key
+// does not belong to the user or was already revoked.
+func (r *Repository) RevokeAPIKey(id, userID, now int64) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	res, err := r.db.Exec(`UPDATE user_api_key SET revoked_time = ? WHERE id = ? AND user_id = ? AND revoked_time = 0`, now, id, userID)
+	if err != nil {
+		return false, store.WrapError("RevokeAPIKey", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, store.WrapError("RevokeAPIKey", err)
+	}
+	return n > 0, nil
+}
+
+// AuthenticateAPIKey returns the enabled user owning the unrevoked key with
+// keyHash and records the use. It returns nil when there is none.
+func (r *Repository) AuthenticateAPIKey(keyHash string, now int64) (*User, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var keyID int64
+	user := &User{}
+	err := r.db.QueryRow(`
+		SELECT k.id, u.id, u.user, u.role_id, u.status, COALESCE(u.permission_mask, 0)
+		FROM user_api_key k
+		JOIN user u ON u.id = k.user_id
+		WHERE k.key_hash = ? AND k.revoked_time = 0 AND u.status = 1
+	`, keyHash).Scan(&keyID, &user.ID, &user.User, &user.RoleID, &user.Status, &user.PermissionMask)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, store.WrapError("AuthenticateAPIKey", err)
+	}
+	if _, err := r.db.Exec(`UPDATE user_api_key SET last_used_time = ? WHERE id = ?`, now, keyID); err != nil {
+		return nil, store.WrapError("AuthenticateAPIKey", err)
+	}
+	return user, nil
+}
+
+// RefreshToken is one login session. The row is kept across refreshes; only
+// token_hash and expires_at move forward, so its ID identifies the session.
+type RefreshToken struct {
+	ID           int64
+	UserID       int64
+	TokenHash    string
+	ExpiresAt    int64
+	RevokedTime  int64
+	Device       string
+	IP           string
+	UserAgent    string
+	LastSeenTime int64
+	CreatedTime  int64
+	UpdatedTime  int64
+}
+
+// SessionClient describes where a session was opened from.
+type SessionClient struct {
+	Device    string
+	IP        string
+	UserAgent string
+}
+
+// CreateRefreshToken opens a session for userID and drops the user's
+// sessions that have already expired.
+func (r *Repository) CreateRefreshToken(userID int64, tokenHash string, expiresAt, now int64, client SessionClient) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	if _, err := r.db.Exec(`DELETE FROM refresh_token WHERE user_id = ? AND expires_at <= ?`, userID, now); err != nil {
+		return 0, store.WrapError("CreateRefreshToken", err)
+	}
+	id, err := r.db.ExecReturningID(`
+		INSERT INTO refresh_token(user_id, token_hash, expires_at, revoked_time, device, ip, user_agent, last_seen_time, created_time, updated_time)
+		VALUES(?, ?, ?, 0, ?, ?, ?, ?, ?, ?)
+	`, userID, tokenHash, expiresAt, client.Device, client.IP, client.UserAgent, now, now, now)
+	if err != nil {
+		return 0, store.WrapError("CreateRefreshToken", err)
+	}
+	return id, nil
+}
+
+func (r *Repository) GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var t RefreshToken
+	err := r.db.QueryRow(`
+		SELECT id, user_id, token_hash, expires_at, revoked_time, created_time, updated_time
+		FROM refresh_token WHERE token_hash = ?
+	`, tokenHash).Scan(&t.ID, &t.UserID, &t.TokenHash, &t.ExpiresAt, &t.RevokedTime, &t.CreatedTime, &t.UpdatedTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, store.WrapError("GetRefreshTokenByHash", err)
+	}
+	return &t, nil
+}
+
+// RotateRefreshToken swaps the session's token for a new one. It reports
+// false when oldHash is no longer current, i.e. the token was already
+// exchanged or the session revoked, so concurrent refreshes cannot both win.
+func (r *Repository) RotateRefreshToken(id int64, oldHash, newHash string, expiresAt, now int64) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	res, err := r.db.Exec(`
+		UPDATE refresh_token SET token_hash = ?, expires_at = ?, updated_time = ?
+		WHERE id = ? AND token_hash = ? AND revoked_time = 0
+	`, newHash, expiresAt, now, id, oldHash)
+	if err != nil {
+		return false, store.WrapError("RotateRefreshToken", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, store.WrapError("RotateRefreshToken", err)
+	}
+	return n > 0, nil
+}
+
+// RevokeRefreshToken ends one session of userID.
+func (r *Repository) RevokeRefreshToken(id, userID, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`UPDATE refresh_token SET revoked_time = ?, updated_time = ? WHERE id = ? AND user_id = ? AND revoked_time = 0`, now, now, id, userID)
+	return store.WrapError("RevokeRefreshToken", err)
+}
+
+// RevokeUserRefreshTokens ends every session of userID and records now as
+// the user's tokens_revoked_time, which also ends the impersonation tokens
+// issued for the user before it.
+func (r *Repository) RevokeUserRefreshTokens(userID, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return store.WrapError("RevokeUserRefreshTokens", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`UPDATE refresh_token SET revoked_time = ?, updated_time = ? WHERE user_id = ? AND revoked_time = 0`, now, now, userID); err != nil {
+		return store.WrapError("RevokeUserRefreshTokens", err)
+	}
+	if _, err := tx.Exec(`UPDATE user SET tokens_revoked_time = ? WHERE id = ?`, now, userID); err != nil {
+		return store.WrapError("RevokeUserRefreshTokens", err)
+	}
+	return store.WrapError("RevokeUserRefreshTokens", tx.Commit())
+}
+
+// IsSessionRevoked reports whether access tokens issued under session id
+// must be refused: the session was revoked, has expired or no longer exists,
+// or its user was disabled.
+func (r *Repository) IsSessionRevoked(id int64) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	var revokedTime, expiresAt int64
+	var status int
+	err := r.db.QueryRow(`
+		SELECT rt.revoked_time, rt.expires_at, u.status
+		FROM refresh_token rt
+		JOIN user u ON u.id = rt.user_id
+		WHERE rt.id = ?
+	`, id).Scan(&revokedTime, &expiresAt, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return true, nil
+		}
+		return false, store.WrapError("IsSessionRevoked", err)
+	}
+	return revokedTime > 0 || expiresAt <= time.Now().UnixMilli() || status != 1, nil
+}
+
+// IsUserTokenRevoked reports whether a token issued for userID at issuedAt
+// (unix milliseconds) outside any session must be refused: the user no
+// longer exists, was disabled, or had every token revoked after issuedAt.
+func (r *Repository) IsUserTokenRevoked(userID, issuedAt int64) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	var status int
+	var revokedTime int64
+	err := r.db.QueryRow(`SELECT status, tokens_revoked_time FROM user WHERE id = ?`, userID).Scan(&status, &revokedTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return true, nil
+		}
+		return false, store.WrapError("IsUserTokenRevoked", err)
+	}
+	return status != 1 || issuedAt < revokedTime, nil
+}
+
+// NodePortConflict identifies a node whose port range overlaps another's.
+type NodePortConflict struct {
+	NodeID    int64
+	Name      string
+	PortRange string
+}
+
+// FindNodePortConflict returns the first other node on serverIP whose port
+// range overlaps [start, end], or nil if there is none.
+func (r *Repository) FindNodePortConflict(serverIP string, excludeNodeID int64, start, end int) (*NodePortConflict, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`SELECT id, name, port FROM node WHERE server_ip = ? AND id <> ? ORDER BY id ASC`, serverIP, excludeNodeID)
+	if err != nil {
+		return nil, store.WrapError("FindNodePortConflict", fmt.Errorf("query nodes by server ip failed: %w", err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c NodePortConflict
+		if err := rows.Scan(&c.NodeID, &c.Name, &c.PortRange); err != nil {
+			return nil, store.WrapError("FindNodePortConflict", fmt.Errorf("scan node failed: %w", err))
+		}
+		if portSpecOverlaps(c.PortRange, start, end) {
+			return &c, nil
+		}
+	}
+	return nil, rows.Err()
+}
+
+// portSpecOverlaps reports whether a port spec such as "1000-2000,3000"
+// shares any port with [start, end].
+func portSpecOverlaps(spec string, start, end int) bool {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			lo, hi = part[:i], part[i+1:]
+		}
+		a, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		b, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if a > b {
+			a, b = b, a
+		}
+		if a <= end && b >= start {
+			return true
+		}
+	}
+	return false
+}
+
+// ListUserTunnelsByTunnel pages through the user assignments of a tunnel with
+// their quota and usage in bytes.
+func (r *Repository) ListUserTunnelsByTunnel(tunnelID int64, page, pageSize int) ([]map[string]interface{}, int, error) {
+	if r == nil || r.db == nil {
+		return nil, 0, errors.New("repository not initialized")
+	}
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(1) FROM user_tunnel WHERE tunnel_id = ?`, tunnelID).Scan(&total); err != nil {
+		return nil, 0, store.WrapError("ListUserTunnelsByTunnel", fmt.Errorf("count user tunnels failed: %w", err))
+	}
+
+	limit, offset := pageBounds(page, pageSize)
+	rows, err := r.db.Query(`
+		SELECT ut.id, ut.user_id, COALESCE(u.user, ''), ut.speed_id, COALESCE(sl.name, ''),
+		       ut.num, ut.flow, ut.in_flow, ut.out_flow, ut.flow_reset_time, ut.exp_time, ut.status
+		FROM user_tunnel ut
+		LEFT JOIN user u ON u.id = ut.user_id
+		LEFT JOIN speed_limit sl ON sl.id = ut.speed_id
+		WHERE ut.tunnel_id = ?
+		ORDER BY ut.id ASC
+		LIMIT ? OFFSET ?
+	`, tunnelID, limit, offset)
+	if err != nil {
+		return nil, 0, store.WrapError("ListUserTunnelsByTunnel", fmt.Errorf("query user tunnels failed: %w", err))
+	}
+	defer rows.Close()
+
+	items := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, userID, flowGB, inFlow, outFlow, flowResetTime, expTime int64
+		var speedID sql.NullInt64
+		var username, speedName string
+		var num, status int
+		if err := rows.Scan(&id, &userID, &username, &speedID, &speedName, &num, &flowGB, &inFlow, &outFlow, &flowResetTime, &expTime, &status); err != nil {
+			return nil, 0, store.WrapError("ListUserTunnelsByTunnel", fmt.Errorf("scan user tunnel failed: %w", err))
+		}
+		totalFlow := flowGB * bytesPerGB
+		usedFlow := inFlow + outFlow
+		remainingFlow := totalFlow - usedFlow
+		if remainingFlow < 0 {
+			remainingFlow = 0
+		}
+		item := map[string]interface{}{
+			"id":            id,
+			"userId":        userID,
+			"username":      username,
+			"speedId":       nil,
+			"speedName":     speedName,
+			"num":           num,
+			"flow":          flowGB,
+			"inFlow":        inFlow,
+			"outFlow":       outFlow,
+			"totalFlow":     totalFlow,
+			"usedFlow":      usedFlow,
+			"remainingFlow": remainingFlow,
+			"overQuota":     flowGB > 0 && usedFlow >= totalFlow,
+			"flowResetTime": flowResetTime,
+			"expTime":       expTime,
+			"status":        status,
+		}
+		if speedID.Valid {
+			item["speedId"] = speedID.Int64
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, store.WrapError("ListUserTunnelsByTunnel", err)
+	}
+	return items, total, nil
+}
+
+// Role is a named set of actions assigned to users through user.role_id.
+// Role 0 (admin) and role 1 (user) are built in.
+type Role struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Actions     []string `json:"actions"`
+	UserCount   int      `json:"userCount"`
+	CreatedTime int64    `json:"createdTime"`
+	UpdatedTime int64    `json:"updatedTime"`
+}
+
+// ListRoles returns every role with its actions and how many users hold it.
+func (r *Repository) ListRoles() ([]Role, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`
+		SELECT r.id, r.name, r.description, r.created_time, r.updated_time,
+		       (SELECT COUNT(1) FROM user u WHERE u.role_id = r.id)
+		FROM role r
+		ORDER BY r.id ASC
+	`)
+	if err != nil {
+		return nil, store.WrapError("ListRoles", err)
+	}
+	roles := make([]Role, 0)
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.CreatedTime, &role.UpdatedTime, &role.UserCount); err != nil {
+			rows.Close()
+			return nil, store.WrapError("ListRoles", err)
+		}
+		roles = append(roles, role)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, store.WrapError("ListRoles", err)
+	}
+	for i := range roles {
+		actions, err := r.RoleActions(int(roles[i].ID))
+		if err != nil {
+			return nil, err
+		}
+		roles[i].Actions = actions
+	}
+	return roles, nil
+}
+
+// GetRole returns the role, or nil when it does not exist.
+func (r *Repository) GetRole(id int64) (*Role, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	var role Role
+	err := r.db.QueryRow(`
+		SELECT r.id, r.name, r.description, r.created_time, r.updated_time,
+		       (SELECT COUNT(1) FROM user u WHERE u.role_id = r.id)
+		FROM role r
+		WHERE r.id = ?
+	`, id).Scan(&role.ID, &role.Name, &role.Description, &role.CreatedTime, &role.UpdatedTime, &role.UserCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, store.WrapError("GetRole", err)
+	}
+	if role.Actions, err = r.RoleActions(int(role.ID)); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// RoleActions returns the actions granted to a role.
+func (r *Repository) RoleActions(roleID int) ([]string, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`SELECT action FROM role_permission WHERE role_id = ? ORDER BY action ASC`, roleID)
+	if err != nil {
+		return nil, store.WrapError("RoleActions", err)
+	}
+	defer rows.Close()
+	actions := make([]string, 0)
+	for rows.Next() {
+		var action string
+		if err := rows.Scan(&action); err != nil {
+			return nil, store.WrapError("RoleActions", err)
+		}
+		actions = append(actions, action)
+	}
+	return actions, store.WrapError("RoleActions", rows.Err())
+}
+
+// CreateRole inserts a role with its actions.
+func (r *Repository) CreateRole(name, description string, actions []string, now int64) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, store.WrapError("CreateRole", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	id, err := tx.ExecReturningID(`INSERT INTO role(name, description, created_time, updated_time) VALUES(?, ?, ?, ?)`, name, description, now, now)
+	if err != nil {
+		return 0, store.WrapError("CreateRole", err)
+	}
+	if err := replaceRoleActionsTx(tx, id, actions); err != nil {
+		return 0, store.WrapError("CreateRole", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, store.WrapError("CreateRole", err)
+	}
+	return id, nil
+}
+
+// UpdateRole renames a role and replaces its actions.
+func (r *Repository) UpdateRole(id int64, name, description string, actions []string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return store.WrapError("UpdateRole", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`UPDATE role SET name = ?, description = ?, updated_time = ? WHERE id = ?`, name, description, now, id); err != nil {
+		return store.WrapError("UpdateRole", err)
+	}
+	if err := replaceRoleActionsTx(tx, id, actions); err != nil {
+		return store.WrapError("UpdateRole", err)
+	}
+	return store.WrapError("UpdateRole", tx.Commit())
+}
+
+// DeleteRole removes a role and its actions. Callers check that no user
+// still holds it.
+func (r *Repository) DeleteRole(id int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return store.WrapError("DeleteRole", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`DELETE FROM role_permission WHERE role_id = ?`, id); err != nil {
+		return store.WrapError("DeleteRole", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM role WHERE id = ?`, id); err != nil {
+		return store.WrapError("DeleteRole", err)
+	}
+	return store.WrapError("DeleteRole", tx.Commit())
+}
+
+func replaceRoleActionsTx(tx *store.Tx, roleID int64, actions []string) error {
+	if _, err := tx.Exec(`DELETE FROM role_permission WHERE role_id = ?`, roleID); err != nil {
+		return err
+	}
+	for _, action := range actions {
+		if _, err := tx.Exec(`INSERT INTO role_permission(role_id, action) VALUES(?, ?)`, roleID, action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetUserRole moves a user to another role. Like a permission_mask change,
+// it takes effect on the user's next login.
+func (r *Repository) SetUserRole(userID int64, roleID int64, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	if _, err := r.db.Exec(`UPDATE user SET role_id = ?, updated_time = ? WHERE id = ?`, roleID, now, userID); err != nil {
+		return store.WrapError("SetUserRole", err)
+	}
+	return nil
+}
+
+// IPBan is a temporary ban on a client IP that kept failing to log in or to
+// authenticate as a node.
+type IPBan struct {
+	ID          int64  `json:"id"`
+	IP          string `json:"ip"`
+	Reason      string `json:"reason"`
+	Failures    int    `json:"failures"`
+	BannedUntil int64  `json:"bannedUntil"`
+	CreatedTime int64  `json:"createdTime"`
+}
+
+// BanIP bans ip until the given time, replacing any earlier ban on it.
+func (r *Repository) BanIP(ip, reason string, failures int, until, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO ip_ban(ip, reason, failures, banned_until, created_time)
+		VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(ip) DO UPDATE SET reason=excluded.reason, failures=excluded.failures, banned_until=excluded.banned_until, created_time=excluded.created_time
+	`, ip, reason, failures, until, now)
+	return store.WrapError("BanIP", err)
+}
+
+// ListActiveIPBans returns the bans still in force at now, newest first.
+func (r *Repository) ListActiveIPBans(now int64) ([]IPBan, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`SELECT id, ip, reason, failures, banned_until, created_time FROM ip_ban WHERE banned_until > ? ORDER BY created_time DESC`, now)
+	if err != nil {
+		return nil, store.WrapError("ListActiveIPBans", err)
+	}
+	defer rows.Close()
+	bans := make([]IPBan, 0)
+	for rows.Next() {
+		var b IPBan
+		if err := rows.Scan(&b.ID, &b.IP, &b.Reason, &b.Failures, &b.BannedUntil, &b.CreatedTime); err != nil {
+			return nil, store.WrapError("ListActiveIPBans", err)
+		}
+		bans = append(bans, b)
+	}
+	return bans, store.WrapError("ListActiveIPBans", rows.Err())
+}
+
+// DeleteIPBan lifts the ban on ip and reports whether there was one.
+func (r *Repository) DeleteIPBan(ip string) (bool, error) {
+	if r == nil || r.db == nil {
+		return false, errors.New("repository not initialized")
+	}
+	res, err := r.db.Exec(`DELETE FROM ip_ban WHERE ip = ?`, ip)
+	if err != nil {
+		return false, store.WrapError("DeleteIPBan", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// PruneIPBans deletes bans that ran out before now.
+func (r *Repository) PruneIPBans(now int64) (int64, error) {
+	if r == nil || r.db == nil {
+		return 0, errors.New("repository not initialized")
+	}
+	res, err := r.db.Exec(`DELETE FROM ip_ban WHERE banned_until <= ?`, now)
+	if err != nil {
+		return 0, store.WrapError("PruneIPBans", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// GetUserEmail returns the address a user registered for password resets,
+// or "" when none is set.
+func (r *Repository) GetUserEmail(userID int64) (string, error) {
+	if r == nil || r.db == nil {
+		return "", errors.New("repository not initialized")
+	}
+	var email string
+	if err := r.db.QueryRow(`SELECT email FROM user WHERE id = ?`, userID).Scan(&email); err != nil {
+		return "", store.WrapError("GetUserEmail", err)
+	}
+	return email, nil
+}
+
+// UpdateUserEmail stores email, lower-cased, for userID. An empty email
+// clears it. Two users cannot share an address: idx_user_email_unique
+// rejects the update, which is reported as a store.Conflict.
+func (r *Repository) UpdateUserEmail(userID int64, email string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	_, err := r.db.Exec(`UPDATE user SET email = ?, updated_time = ? WHERE id = ?`, email, now, userID)
+	return store.WrapError("UpdateUserEmail", err)
+}
+
+// GetUserByEmail finds the user with email, or returns nil when none has it.
+func (r *Repository) GetUserByEmail(email string) (*User, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil, nil
+	}
+	var id int64
+	if err := r.db.QueryRow(`SELECT id FROM user WHERE email = ? LIMIT 1`, email).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, store.WrapError("GetUserByEmail", err)
+	}
+	return r.GetUserByID(id)
+}
+
+// ListUserSessions returns userID's sessions that are neither revoked nor
+// expired, most recently used first.
+func (r *Repository) ListUserSessions(userID, now int64) ([]RefreshToken, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	rows, err := r.db.Query(`
+		SELECT id, user_id, expires_at, revoked_time, device, ip, user_agent, last_seen_time, created_time, updated_time
+		FROM refresh_token
+		WHERE user_id = ? AND revoked_time = 0 AND expires_at > ?
+		ORDER BY last_seen_time DESC, id DESC
+	`, userID, now)
+	if err != nil {
+		return nil, store.WrapError("ListUserSessions", err)
+	}
+	defer rows.Close()
+	sessions := make([]RefreshToken, 0)
+	for rows.Next() {
+		var t RefreshToken
+		if err := rows.Scan(&t.ID, &t.UserID, &t.ExpiresAt, &t.RevokedTime, &t.Device, &t.IP, &t.UserAgent, &t.LastSeenTime, &t.CreatedTime, &t.UpdatedTime); err != nil {
+			return nil, store.WrapError("ListUserSessions", err)
+		}
+		sessions = append(sessions, t)
+	}
+	return sessions, store.WrapError("ListUserSessions", rows.Err())
+}
+
+// TouchSession records that session id was used from ip at now.
+func (r *Repository) TouchSession(id int64, ip string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`UPDATE refresh_token SET last_seen_time = ?, ip = ? WHERE id = ? AND revoked_time = 0`, now, ip, id)
+	return store.WrapError("TouchSession", err)
+}
+
+// ErrInvalidCursor is returned for a page cursor that was not issued as the
+// NextCursor of the same list.
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// PageRequest selects one page of a list. A Cursor taken from the
+// NextCursor of the previous page continues right after its last row and
+// takes precedence over Page; unlike an offset it neither skips nor repeats
+// rows when rows are added or removed in between.
+type PageRequest struct {
+	Page     int
+	PageSize int
+	Cursor   string
+}
+
+// ListPage is one page of a list. Total counts all matching rows, not just
+// this page. NextCursor is empty on the last page; Page is 0 when the page
+// was selected by cursor.
+type ListPage struct {
+	Items      []map[string]interface{}
+	Total      int
+	Page       int
+	PageSize   int
+	NextCursor string
+}
+
+// ListFilter narrows a list. Zero fields do not filter; each list ignores
+// the fields that do not apply to it.
+type ListFilter struct {
+	// Name is a case-insensitive substring of the name.
+	Name   string
+	Status *int
+	// NodeID selects the tunnels with a hop on the node and the forwards
+	// with an entry port on it.
+	NodeID   int64
+	TunnelID int64
+	UserID   int64
+	// CreatedFrom and CreatedTo bound the creation time in milliseconds,
+	// inclusive.
+	CreatedFrom int64
+	CreatedTo   int64
+}
+
+// conds returns the conditions on the name, status and creation time, with
+// nameCol holding the name.
+func (f ListFilter) conds(nameCol string) ([]string, []interface{}) {
+	conds := make([]string, 0, 4)
+	args := make([]interface{}, 0, 4)
+	if name := strings.TrimSpace(f.Name); name != "" {
+		conds = append(conds, `LOWER(`+nameCol+`) LIKE '%' || ? || '%' ESCAPE '\'`)
+		args = append(args, escapeLike(strings.ToLower(name)))
+	}
+	if f.Status != nil {
+		conds = append(conds, `status = ?`)
+		args = append(args, *f.Status)
+	}
+	if f.CreatedFrom > 0 {
+		conds = append(conds, `created_time >= ?`)
+		args = append(args, f.CreatedFrom)
+	}
+	if f.CreatedTo > 0 {
+		conds = append(conds, `created_time <= ?`)
+		args = append(args, f.CreatedTo)
+	}
+	return conds, args
+}
+
+// ListNodesPage returns one page of the nodes matching filter in list order.
+func (r *Repository) ListNodesPage(filter ListFilter, req PageRequest) (ListPage, error) {
+	conds, args := filter.conds("name")
+	return r.listPage("ListNodesPage", "node", strings.Join(conds, " AND "), args, req, []string{"inx", "id"}, func(idFilter string, args []interface{}) ([]map[string]interface{}, error) {
+		items := make([]map[string]interface{}, 0)
+		err := r.scanNodesWhere("ListNodesPage", "WHERE id "+idFilter, args, func(item map[string]interface{}) error {
+			items = append(items, item)
+			return nil
+		})
+		return items, err
+	})
+}
+
+// ListTunnelsPage returns one page of the tunnels matching filter in list
+// order.
+func (r *Repository) ListTunnelsPage(filter ListFilter, req PageRequest) (ListPage, error) {
+	conds, args := filter.conds("name")
+	if filter.NodeID > 0 {
+		conds = append(conds, `id IN (SELECT tunnel_id FROM chain_tunnel WHERE node_id = ?)`)
+		args = append(args, filter.NodeID)
+	}
+	return r.listPage("ListTunnelsPage", "tunnel", strings.Join(conds, " AND "), args, req, []string{"inx", "id"}, func(idFilter string, args []interface{}) ([]map[string]interface{}, error) {
+		return r.listTunnels("ListTunnelsPage", idFilter, args)
+	})
+}
+
+// ListForwardsPage returns one page of the forwards matching filter in list
+// order.
+func (r *Repository) ListForwardsPage(filter ListFilter, req PageRequest) (ListPage, error) {
+	conds, args := filter.conds("name")
+	if filter.UserID > 0 {
+		conds = append(conds, `user_id = ?`)
+		args = append(args, filter.UserID)
+	}
+	if filter.TunnelID > 0 {
+		conds = append(conds, `tunnel_id = ?`)
+		args = append(args, filter.TunnelID)
+	}
+	if filter.NodeID > 0 {
+		conds = append(conds, `id IN (SELECT forward_id FROM forward_port WHERE node_id = ?)`)
+		args = append(args, filter.NodeID)
+	}
+	return r.listPage("ListForwardsPage", "forward", strings.Join(conds, " AND "), args, req, []string{"inx", "id"}, func(idFilter string, args []interface{}) ([]map[string]interface{}, error) {
+		return r.listForwards("ListForwardsPage", "WHERE f.id "+idFilter, args)
+	})
+}
+
+// ListSpeedLimitsPage returns one page of speed limits in list order.
+func (r *Repository) ListSpeedLimitsPage(req PageRequest) (ListPage, error) {
+	return r.listPage("ListSpeedLimitsPage", "speed_limit", "", nil, req, []string{"id"}, func(idFilter string, args []interface{}) ([]map[string]interface{}, error) {
+		return r.listSpeedLimits("ListSpeedLimitsPage", "WHERE id "+idFilter, args)
+	})
+}
+
+// listPage counts the rows of table matching filter and loads the page req
+// selects from them, ordered ascending by orderCols, which must end with
+// the unique id and name int64 keys of the loaded items. load receives an
+// "IN (SELECT id ...)" clause for the page's ids and its arguments, and
+// returns the items in list order.
+func (r *Repository) listPage(op, table, filter string, filterArgs []interface{}, req PageRequest, orderCols []string, load func(idFilter string, args []interface{}) ([]map[string]interface{}, error)) (ListPage, error) {
+	if r == nil || r.db == nil {
+		return ListPage{}, errors.New("repository not initialized")
+	}
+	limit, offset := pageBounds(req.Page, req.PageSize)
+	page := ListPage{Page: offset/limit + 1, PageSize: limit}
+
+	conds := make([]string, 0, 2)
+	args := make([]interface{}, 0, len(filterArgs)+len(orderCols)*2+2)
+	if filter != "" {
+		conds = append(conds, filter)
+		args = append(args, filterArgs...)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+	if err := r.db.QueryRow(`SELECT COUNT(1) FROM `+table+where, args...).Scan(&page.Total); err != nil {
+		return ListPage{}, store.WrapError(op, err)
+	}
+
+	if req.Cursor != "" {
+		keys, err := decodePageCursor(req.Cursor, len(orderCols))
+		if err != nil {
+			return ListPage{}, err
+		}
+		cond, condArgs := keysetAfter(orderCols, keys)
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+		offset, page.Page = 0, 0
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, limit, offset)
+
+	items, err := load(`IN (SELECT id FROM `+table+where+` ORDER BY `+strings.Join(orderCols, " ASC, ")+` ASC LIMIT ? OFFSET ?)`, args)
+	if err != nil {
+		return ListPage{}, store.WrapError(op, err)
+	}
+	page.Items = items
+	if len(items) == limit {
+		last := items[len(items)-1]
+		keys := make([]int64, len(orderCols))
+		for i, col := range orderCols {
+			keys[i], _ = last[col].(int64)
+		}
+		page.NextCursor = encodePageCursor(keys)
+	}
+	return page, nil
+}
+
+// keysetAfter returns the condition selecting the rows that sort after keys
+// in ascending cols order.
+func keysetAfter(cols []string, keys []int64) (string, []interface{}) {
+	if len(cols) == 1 {
+		return cols[0] + " > ?", []interface{}{keys[0]}
+	}
+	rest, restArgs := keysetAfter(cols[1:], keys[1:])
+	return "(" + cols[0] + " > ? OR (" + cols[0] + " = ? AND " + rest + "))", append([]interface{}{keys[0], keys[0]}, restArgs...)
+}
+
+func encodePageCursor(keys []int64) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = strconv.FormatInt(k, 10)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(parts, ":")))
+}
+
+func decodePageCursor(cursor string, n int) ([]int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != n {
+		return nil, ErrInvalidCursor
+	}
+	keys := make([]int64, n)
+	for i, part := range parts {
+		if keys[i], err = strconv.ParseInt(part, 10, 64); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return keys, nil
+}
+
+// SetUserPassword replaces the password hash of a user set by an admin.
+func (r *Repository) SetUserPassword(userID int64, passwordHash string, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`UPDATE user SET pwd = ?, updated_time = ? WHERE id = ?`, passwordHash, now, userID)
+	return store.WrapError("SetUserPassword", err)
+}
+
+// SetUserStatus enables (1) or disables (0) a user.
+func (r *Repository) SetUserStatus(userID int64, status int, now int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	_, err := r.db.Exec(`UPDATE user SET status = ?, updated_time = ? WHERE id = ?`, status, now, userID)
+	return store.WrapError("SetUserStatus", err)
+}
+
+// DeleteUserCascade deletes a user together with its forwards, tunnel
+// assignments, sessions, credentials and statistics in one transaction.
+// Stopping the forwards' services on the nodes is up to the caller.
+func (r *Repository) DeleteUserCascade(userID int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return store.WrapError("DeleteUserCascade", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmts := []string{
+		`DELETE FROM forward_port WHERE forward_id IN (SELECT id FROM forward WHERE user_id = ?)`,
+		`DELETE FROM forward_baseline WHERE forward_id IN (SELECT id FROM forward WHERE user_id = ?)`,
+		`DELETE FROM forward WHERE user_id = ?`,
+		`DELETE FROM group_permission_grant WHERE user_tunnel_id IN (SELECT id FROM user_tunnel WHERE user_id = ?)`,
+		`DELETE FROM user_tunnel WHERE user_id = ?`,
+		`DELETE FROM user_group_user WHERE user_id = ?`,
+		`DELETE FROM statistics_flow WHERE user_id = ?`,
+		`DELETE FROM flow_log WHERE user_id = ?`,
+		`DELETE FROM user_notification_pref WHERE user_id = ?`,
+		`DELETE FROM refresh_token WHERE user_id = ?`,
+		`DELETE FROM user_api_key WHERE user_id = ?`,
+		`DELETE FROM user_recovery_code WHERE user_id = ?`,
+		`DELETE FROM user_oidc_identity WHERE user_id = ?`,
+		`DELETE FROM webauthn_credential WHERE user_id = ?`,
+		`DELETE FROM user WHERE id = ?`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, userID); err != nil {
+			return store.WrapError("DeleteUserCascade", err)
+		}
+	}
+	return store.WrapError("DeleteUserCascade", tx.Commit())
+}
+
+// DeleteForwards deletes the forwards with the given ids, with their entry
+// ports and traffic baselines, in one transaction: either all go or none.
+func (r *Repository) DeleteForwards(ids []int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return store.WrapError("DeleteForwards", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, table := range []string{"forward_port", "forward_baseline"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE forward_id IN (`+placeholders+`)`, args...); err != nil {
+			return store.WrapError("DeleteForwards", err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM forward WHERE id IN (`+placeholders+`)`, args...); err != nil {
+		return store.WrapError("DeleteForwards", err)
+	}
+	return store.WrapError("DeleteForwards", tx.Commit())
+}
+
+// DeleteSpeedLimit deletes a speed limit rule and detaches it from the
+// tunnel assignments using it, in one transaction.
+func (r *Repository) DeleteSpeedLimit(id int64) error {
+	if r == nil || r.db == nil {
+		return errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return store.WrapError("DeleteSpeedLimit", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`UPDATE user_tunnel SET speed_id = NULL WHERE speed_id = ?`, id); err != nil {
+		return store.WrapError("DeleteSpeedLimit", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM speed_limit WHERE id = ?`, id); err != nil {
+		return store.WrapError("DeleteSpeedLimit", err)
+	}
+	return store.WrapError("DeleteSpeedLimit", tx.Commit())
+}
+
+// ImportedUser is a user created by a bulk import, assigned to TunnelIDs
+// with the user's own flow, forward count and expiry.
+type ImportedUser struct {
+	User      User
+	TunnelIDs []int64
+}
+
+// ImportUsers creates the users and their tunnel assignments in one
+// transaction and returns the new ids in order. A username conflict rolls
+// the whole import back.
+func (r *Repository) ImportUsers(users []ImportedUser) ([]int64, error) {
+	if r == nil || r.db == nil {
+		return nil, errors.New("repository not initialized")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, store.WrapError("ImportUsers", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	ctx := store.WithTx(context.Background(), tx)
+
+	ids := make([]int64, 0, len(users))
+	for i := range users {
+		u := &users[i].User
+		id, err := r.CreateUserTx(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		for _, tunnelID := range users[i].TunnelIDs {
+			if _, err := tx.Exec(`
+				INSERT INTO user_tunnel(user_id, tunnel_id, num, flow, in_flow, out_flow, flow_reset_time, exp_time, status)
+				VALUES(?, ?, ?, ?, 0, 0, ?, ?, 1)
+			`, id, tunnelID, u.Num, u.Flow, u.FlowResetTime, u.ExpTime); err != nil {
+				return nil, store.WrapError("ImportUsers", err)
+			}
+		}
+		ids = append(ids, id)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, store.WrapError("ImportUsers", err)
+	}
+	return ids, nil
+}
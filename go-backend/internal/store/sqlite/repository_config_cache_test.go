@@ -0,0 +1,61 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetConfigByNameReadsThroughCache(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "config.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	now := time.Now().UnixMilli()
+	if err := repo.UpsertConfig("captcha_enabled", "true", now); err != nil {
+		t.Fatalf("upsert config: %v", err)
+	}
+	if cfg, err := repo.GetConfigByName("captcha_enabled"); err != nil || cfg == nil || cfg.Value != "true" {
+		t.Fatalf("expected first read from the database, got %+v (%v)", cfg, err)
+	}
+
+	// Change the row behind the repository's back: a cache hit must not see it.
+	if _, err := repo.DB().Exec(`UPDATE vite_config SET value = 'false' WHERE name = ?`, "captcha_enabled"); err != nil {
+		t.Fatalf("update config: %v", err)
+	}
+	if cfg, err := repo.GetConfigByName("captcha_enabled"); err != nil || cfg == nil || cfg.Value != "true" {
+		t.Fatalf("expected second read from the cache, got %+v (%v)", cfg, err)
+	}
+
+	if err := repo.UpsertConfig("captcha_enabled", "false", now+1); err != nil {
+		t.Fatalf("upsert config: %v", err)
+	}
+	if cfg, err := repo.GetConfigByName("captcha_enabled"); err != nil || cfg == nil || cfg.Value != "false" {
+		t.Fatalf("expected upsert to invalidate the cache, got %+v (%v)", cfg, err)
+	}
+}
+
+func TestConfigCacheExpiresAfterTTL(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "config.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	repo.ConfigCache().TTL = 20 * time.Millisecond
+
+	if err := repo.UpsertConfig("panel_domain", "a.example.com", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("upsert config: %v", err)
+	}
+	if _, err := repo.GetConfigByName("panel_domain"); err != nil {
+		t.Fatalf("get config: %v", err)
+	}
+	if _, err := repo.DB().Exec(`UPDATE vite_config SET value = 'b.example.com' WHERE name = ?`, "panel_domain"); err != nil {
+		t.Fatalf("update config: %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if cfg, err := repo.GetConfigByName("panel_domain"); err != nil || cfg == nil || cfg.Value != "b.example.com" {
+		t.Fatalf("expected expired entry to be reloaded, got %+v (%v)", cfg, err)
+	}
+}
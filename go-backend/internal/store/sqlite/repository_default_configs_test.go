@@ -0,0 +1,50 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-backend/internal/config"
+)
+
+func TestOpenSeedsDefaultConfigs(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "defaults.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	if err := repo.InitDefaultConfigs(config.DefaultConfigs); err != nil {
+		t.Fatalf("init default configs: %v", err)
+	}
+	for name, want := range config.DefaultConfigs {
+		cfg, err := repo.GetConfigByName(name)
+		if err != nil || cfg == nil {
+			t.Fatalf("%s: expected a config row, got %v, %v", name, cfg, err)
+		}
+		if cfg.Value != want {
+			t.Fatalf("%s: expected %q, got %q", name, want, cfg.Value)
+		}
+	}
+}
+
+func TestInitDefaultConfigsKeepsExistingValues(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "defaults.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	if err := repo.UpsertConfig("captcha_enabled", "true", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("set captcha_enabled: %v", err)
+	}
+	if err := repo.InitDefaultConfigs(map[string]string{"captcha_enabled": "false", "app_name": "other"}); err != nil {
+		t.Fatalf("init default configs: %v", err)
+	}
+	for name, want := range map[string]string{"captcha_enabled": "true", "app_name": "flux"} {
+		if cfg, err := repo.GetConfigByName(name); err != nil || cfg == nil || cfg.Value != want {
+			t.Fatalf("%s: expected %q to be kept, got %v, %v", name, want, cfg, err)
+		}
+	}
+}
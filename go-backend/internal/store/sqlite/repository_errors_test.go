@@ -0,0 +1,55 @@
+package sqlite
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-backend/internal/store"
+)
+
+func TestCreateUserDuplicateUsernameIsConflict(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "errors.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	now := time.Now().UnixMilli()
+	user := &User{User: "dup_user", Pwd: "x", RoleID: 1, ExpTime: now, Flow: 1, FlowResetTime: 1, Num: 1, CreatedTime: now, Status: 1}
+	if _, err := repo.CreateUser(user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	_, err = repo.CreateUser(user)
+	if !store.IsConflict(err) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+	var storeErr *store.Error
+	if !errors.As(err, &storeErr) || storeErr.Op != "CreateUser" {
+		t.Fatalf("expected store.Error with op CreateUser, got %#v", err)
+	}
+	if store.IsNotFound(err) {
+		t.Fatalf("conflict must not report not found")
+	}
+}
+
+func TestGetUserMissingIsNotFound(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "errors.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	user, err := repo.GetUserByUsername("missing_user")
+	if user != nil || !store.IsNotFound(err) {
+		t.Fatalf("expected not found for missing username, got %v, %v", user, err)
+	}
+	user, err = repo.GetUserByID(987654)
+	if user != nil || !store.IsNotFound(err) {
+		t.Fatalf("expected not found for missing id, got %v, %v", user, err)
+	}
+	if store.IsConflict(err) {
+		t.Fatalf("not found must not report conflict")
+	}
+}
@@ -0,0 +1,88 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncryptStoredSecretsKeepsLookupsWorking(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fields.db")
+	repo, err := Open(path)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	now := time.Now().UnixMilli()
+	if _, err := repo.DB().Exec(`
+		INSERT INTO node(name, secret, server_ip, port, http, tls, socks, created_time, updated_time, status, tcp_listen_addr, udp_listen_addr, inx, is_remote, remote_url, remote_token)
+		VALUES('legacy', 'LegacyNodeSecret123', '10.0.0.1', '1000-2000', 0, 0, 0, ?, ?, 1, '[::]', '[::]', 0, 1, 'https://peer.example', 'legacy-remote-token')
+	`, now, now); err != nil {
+		t.Fatalf("insert node: %v", err)
+	}
+	if err := repo.CreatePeerShare(&PeerShare{Name: "legacy", NodeID: 1, Token: "legacy-share-token", IsActive: 1, CreatedTime: now, UpdatedTime: now}); err != nil {
+		t.Fatalf("create share: %v", err)
+	}
+
+	if err := repo.EnableFieldEncryption("master-key-one"); err != nil {
+		t.Fatalf("enable field encryption: %v", err)
+	}
+	// Plaintext rows stay usable before the migration runs.
+	if node, err := repo.GetNodeBySecret("LegacyNodeSecret123"); err != nil || node == nil {
+		t.Fatalf("expected plaintext lookup to work, got %+v (%v)", node, err)
+	}
+
+	n, err := repo.EncryptStoredSecrets()
+	if err != nil {
+		t.Fatalf("encrypt stored secrets: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 values encrypted, got %d", n)
+	}
+	if n, err := repo.EncryptStoredSecrets(); err != nil || n != 0 {
+		t.Fatalf("expected a second run to do nothing, got %d (%v)", n, err)
+	}
+
+	var rawSecret, rawRemote, rawToken string
+	if err := repo.DB().QueryRow(`SELECT secret, remote_token FROM node WHERE id = 1`).Scan(&rawSecret, &rawRemote); err != nil {
+		t.Fatalf("read node: %v", err)
+	}
+	if err := repo.DB().QueryRow(`SELECT token FROM peer_share WHERE id = 1`).Scan(&rawToken); err != nil {
+		t.Fatalf("read share: %v", err)
+	}
+	for _, raw := range []string{rawSecret, rawRemote, rawToken} {
+		if !strings.HasPrefix(raw, "enc:v1:") || strings.Contains(raw, "legacy") || strings.Contains(raw, "Legacy") {
+			t.Fatalf("expected an encrypted column value, got %q", raw)
+		}
+	}
+
+	node, err := repo.GetNodeBySecret("LegacyNodeSecret123")
+	if err != nil || node == nil {
+		t.Fatalf("expected encrypted lookup to work, got %+v (%v)", node, err)
+	}
+	if node.Secret != "LegacyNodeSecret123" || node.RemoteToken.String != "legacy-remote-token" {
+		t.Fatalf("expected decrypted values, got %q %q", node.Secret, node.RemoteToken.String)
+	}
+	if share, err := repo.GetPeerShareByToken("legacy-share-token"); err != nil || share == nil || share.Token != "legacy-share-token" {
+		t.Fatalf("expected share lookup to work, got %+v (%v)", share, err)
+	}
+	if ok, err := repo.NodeExistsBySecret("LegacyNodeSecret123"); err != nil || !ok {
+		t.Fatalf("expected node to exist, got %v (%v)", ok, err)
+	}
+	_ = repo.Close()
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = reopened.Close() })
+	if err := reopened.EnableFieldEncryption("wrong-master-key"); err == nil {
+		t.Fatalf("expected a wrong master key to be refused")
+	}
+	if err := reopened.EnableFieldEncryption("master-key-one"); err != nil {
+		t.Fatalf("enable with the original master key: %v", err)
+	}
+	if node, err := reopened.GetNodeBySecret("LegacyNodeSecret123"); err != nil || node == nil {
+		t.Fatalf("expected lookup after reopen to work, got %+v (%v)", node, err)
+	}
+}
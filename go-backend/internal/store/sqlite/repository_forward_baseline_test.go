@@ -0,0 +1,65 @@
+package sqlite
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateForwardBaselineFlagsSpike(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "baseline.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	for i := 0; i < 25; i++ {
+		_, isAnomaly, err := repo.UpdateForwardBaseline(7, 100)
+		if err != nil {
+			t.Fatalf("sample %d: %v", i, err)
+		}
+		if isAnomaly {
+			t.Fatalf("steady sample %d must not be an anomaly", i)
+		}
+	}
+
+	anomaly, isAnomaly, err := repo.UpdateForwardBaseline(7, 1000)
+	if err != nil {
+		t.Fatalf("spike sample: %v", err)
+	}
+	if !isAnomaly {
+		t.Fatalf("expected the spike to be an anomaly")
+	}
+	if anomaly.ForwardID != 7 || anomaly.ActualBytes != 1000 || anomaly.ExpectedBytes != 100 {
+		t.Fatalf("unexpected anomaly %+v", anomaly)
+	}
+	if math.Abs(anomaly.ZScore-9) > 0.01 {
+		t.Fatalf("expected z-score about 9, got %v", anomaly.ZScore)
+	}
+
+	var count int64
+	var mean float64
+	if err := repo.DB().QueryRow(`SELECT sample_count, avg_bytes_per_upload FROM forward_baseline WHERE forward_id = ?`, 7).Scan(&count, &mean); err != nil {
+		t.Fatalf("query baseline: %v", err)
+	}
+	if count != 26 || math.Abs(mean-3500.0/26) > 1e-9 {
+		t.Fatalf("expected 26 samples with the spike folded in, got %d samples, mean %v", count, mean)
+	}
+}
+
+func TestUpdateForwardBaselineNeedsMinimumSamples(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "baseline.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	for i := 0; i < ForwardBaselineMinSamples-1; i++ {
+		if _, _, err := repo.UpdateForwardBaseline(8, 100); err != nil {
+			t.Fatalf("sample %d: %v", i, err)
+		}
+	}
+	if _, isAnomaly, err := repo.UpdateForwardBaseline(8, 100000); err != nil || isAnomaly {
+		t.Fatalf("expected no anomaly before %d samples, got %v (%v)", ForwardBaselineMinSamples, isAnomaly, err)
+	}
+}
@@ -0,0 +1,86 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReservePeerSharePortsConcurrentCallsDoNotOverlap(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "ports.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	now := time.Now().UnixMilli()
+	share := &PeerShare{Name: "ports", NodeID: 1, Token: "ports-token", PortRangeStart: 5000, PortRangeEnd: 5004, IsActive: 1, CreatedTime: now, UpdatedTime: now}
+	if err := repo.CreatePeerShare(share); err != nil {
+		t.Fatalf("create share: %v", err)
+	}
+	saved, err := repo.GetPeerShareByToken("ports-token")
+	if err != nil || saved == nil {
+		t.Fatalf("load share: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]int, 2)
+	errs := make([]error, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = repo.ReservePeerSharePorts(saved.ID, 2, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[int]bool)
+	for i, ports := range results {
+		if errs[i] != nil {
+			t.Fatalf("reserve call %d: %v", i, errs[i])
+		}
+		if len(ports) != 2 || ports[0] == ports[1] {
+			t.Fatalf("expected 2 distinct ports from call %d, got %v", i, ports)
+		}
+		for _, port := range ports {
+			if port < 5000 || port > 5004 {
+				t.Fatalf("port %d outside the share range", port)
+			}
+			if seen[port] {
+				t.Fatalf("port %d handed out twice: %v", port, results)
+			}
+			seen[port] = true
+		}
+	}
+
+	ports, err := repo.ReservePeerSharePorts(saved.ID, 1, nil)
+	if err != nil || len(ports) != 1 || seen[ports[0]] {
+		t.Fatalf("expected the last free port, got %v (%v)", ports, err)
+	}
+	if _, err := repo.ReservePeerSharePorts(saved.ID, 1, nil); err == nil {
+		t.Fatalf("expected an exhausted share to fail")
+	}
+}
+
+func TestReservePeerSharePortsSkipsExcludedPorts(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "ports.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	now := time.Now().UnixMilli()
+	if err := repo.CreatePeerShare(&PeerShare{Name: "ports", NodeID: 1, Token: "ports-token", PortRangeStart: 5000, PortRangeEnd: 5004, IsActive: 1, CreatedTime: now, UpdatedTime: now}); err != nil {
+		t.Fatalf("create share: %v", err)
+	}
+	saved, _ := repo.GetPeerShareByToken("ports-token")
+	ports, err := repo.ReservePeerSharePorts(saved.ID, 2, []int{5000, 5002})
+	if err != nil {
+		t.Fatalf("reserve ports: %v", err)
+	}
+	if len(ports) != 2 || ports[0] != 5001 || ports[1] != 5003 {
+		t.Fatalf("expected [5001 5003], got %v", ports)
+	}
+}
@@ -0,0 +1,73 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-backend/internal/store"
+)
+
+func TestRepositoryTxMethodsJoinContextTransaction(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "tx.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	tx, err := repo.DB().Begin()
+	if err != nil {
+		t.Fatalf("begin tx: %v", err)
+	}
+	ctx := store.WithTx(context.Background(), tx)
+	if got, ok := store.TxFromCtx(ctx); !ok || got != tx {
+		t.Fatalf("expected the transaction back from the context")
+	}
+
+	now := time.Now().UnixMilli()
+	user := &User{User: "tx_user", Pwd: "x", RoleID: 1, ExpTime: now, Flow: 1, FlowResetTime: 1, Num: 1, CreatedTime: now, Status: 1}
+	if _, err := repo.CreateUserTx(ctx, user); err != nil {
+		t.Fatalf("create user in tx: %v", err)
+	}
+	if err := repo.UpsertConfigTx(ctx, "tx_config", "1", now); err != nil {
+		t.Fatalf("upsert config in tx: %v", err)
+	}
+	var inTx int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM user WHERE user = ?`, "tx_user").Scan(&inTx); err != nil || inTx != 1 {
+		t.Fatalf("expected user visible inside the transaction, got %d (%v)", inTx, err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+
+	if _, err := repo.GetUserByUsername("tx_user"); !store.IsNotFound(err) {
+		t.Fatalf("expected rolled back user to be missing, got %v", err)
+	}
+	cfg, err := repo.GetConfigByName("tx_config")
+	if err != nil {
+		t.Fatalf("get config: %v", err)
+	}
+	if cfg != nil {
+		t.Fatalf("expected rolled back config to be missing, got %+v", cfg)
+	}
+}
+
+func TestRepositoryTxMethodsFallBackToPool(t *testing.T) {
+	repo, err := Open(filepath.Join(t.TempDir(), "tx.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	if _, ok := store.TxFromCtx(context.Background()); ok {
+		t.Fatalf("expected no transaction in a plain context")
+	}
+	if err := repo.UpsertConfigTx(context.Background(), "pool_config", "1", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("upsert config: %v", err)
+	}
+	cfg, err := repo.GetConfigByName("pool_config")
+	if err != nil || cfg == nil || cfg.Value != "1" {
+		t.Fatalf("expected config written through the pool, got %+v (%v)", cfg, err)
+	}
+}
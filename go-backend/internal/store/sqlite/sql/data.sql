@@ -3,3 +3,9 @@ VALUES (1, 'admin_user', '3c85cdebade1c51cf64ca9f3c09d182d', 0, 2727251700000, 9
 
 INSERT OR IGNORE INTO vite_config (id, name, value, time)
 VALUES (1, 'app_name', 'flux', 1755147963000);
+
+INSERT OR IGNORE INTO role (id, name, description, created_time, updated_time)
+VALUES (0, 'admin', '', 1748914865000, 1748914865000);
+
+INSERT OR IGNORE INTO role (id, name, description, created_time, updated_time)
+VALUES (1, 'user', '', 1748914865000, 1748914865000);
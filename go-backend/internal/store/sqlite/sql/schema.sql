@@ -14,7 +14,11 @@ CREATE TABLE IF NOT EXISTS forward (
   created_time INTEGER NOT NULL,
   updated_time INTEGER NOT NULL,
   status INTEGER NOT NULL,
-  inx INTEGER NOT NULL DEFAULT 0
+  inx INTEGER NOT NULL DEFAULT 0,
+  protocol VARCHAR(10) NOT NULL DEFAULT 'tcp',
+  dns_server VARCHAR(100),
+  idle_timeout_sec INTEGER NOT NULL DEFAULT 0,
+  service_revision INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS forward_port (
@@ -46,7 +50,13 @@ CREATE TABLE IF NOT EXISTS node (
   is_remote INTEGER DEFAULT 0,
   remote_url TEXT,
   remote_token TEXT,
-  remote_config TEXT
+  remote_config TEXT,
+  last_seen_at INTEGER,
+  last_ip VARCHAR(100),
+  callback_url TEXT,
+  client_cert TEXT,
+  client_cert_serial VARCHAR(64),
+  client_cert_expire INTEGER
 );
 
 CREATE TABLE IF NOT EXISTS speed_limit (
@@ -80,7 +90,8 @@ CREATE TABLE IF NOT EXISTS tunnel (
   updated_time INTEGER NOT NULL,
   status INTEGER NOT NULL,
   in_ip TEXT,
-  inx INTEGER NOT NULL DEFAULT 0
+  inx INTEGER NOT NULL DEFAULT 0,
+  dscp_mark INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS chain_tunnel (
@@ -108,7 +119,12 @@ CREATE TABLE IF NOT EXISTS user (
   num INTEGER NOT NULL,
   created_time INTEGER NOT NULL,
   updated_time INTEGER,
-  status INTEGER NOT NULL
+  status INTEGER NOT NULL,
+  totp_enabled INTEGER NOT NULL DEFAULT 0,
+  totp_secret TEXT NOT NULL DEFAULT '',
+  permission_mask INTEGER NOT NULL DEFAULT 0,
+  email VARCHAR(255) NOT NULL DEFAULT '',
+  tokens_revoked_time INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS user_tunnel (
@@ -178,6 +194,7 @@ CREATE UNIQUE INDEX IF NOT EXISTS idx_user_group_user_unique ON user_group_user(
 CREATE UNIQUE INDEX IF NOT EXISTS idx_group_permission_unique ON group_permission(user_group_id, tunnel_group_id);
 CREATE UNIQUE INDEX IF NOT EXISTS idx_group_permission_grant_unique ON group_permission_grant(user_group_id, tunnel_group_id, user_tunnel_id);
 CREATE UNIQUE INDEX IF NOT EXISTS idx_user_tunnel_unique ON user_tunnel(user_id, tunnel_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_email_unique ON user(email) WHERE email != '';
 
 CREATE TABLE IF NOT EXISTS vite_config (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -207,6 +224,7 @@ CREATE TABLE IF NOT EXISTS peer_share_runtime (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     share_id INTEGER NOT NULL,
     node_id INTEGER NOT NULL,
+    consumer_id TEXT NOT NULL DEFAULT '',
     reservation_id TEXT NOT NULL UNIQUE,
     resource_key TEXT NOT NULL UNIQUE,
     binding_id TEXT NOT NULL DEFAULT '',
@@ -226,6 +244,17 @@ CREATE TABLE IF NOT EXISTS peer_share_runtime (
 CREATE INDEX IF NOT EXISTS idx_peer_share_runtime_share_node_status ON peer_share_runtime(share_id, node_id, status);
 CREATE INDEX IF NOT EXISTS idx_peer_share_runtime_binding_id ON peer_share_runtime(binding_id);
 
+CREATE TABLE IF NOT EXISTS peer_share_consumer (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    share_id INTEGER NOT NULL,
+    consumer_id TEXT NOT NULL,
+    callback_url TEXT NOT NULL DEFAULT '',
+    created_time INTEGER NOT NULL,
+    updated_time INTEGER NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_peer_share_consumer_unique ON peer_share_consumer(share_id, consumer_id);
+
 CREATE TABLE IF NOT EXISTS federation_tunnel_binding (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     tunnel_id INTEGER NOT NULL,
@@ -243,3 +272,221 @@ CREATE TABLE IF NOT EXISTS federation_tunnel_binding (
 
 CREATE UNIQUE INDEX IF NOT EXISTS idx_federation_tunnel_binding_unique ON federation_tunnel_binding(tunnel_id, node_id, chain_type, hop_inx);
 CREATE INDEX IF NOT EXISTS idx_federation_tunnel_binding_tunnel ON federation_tunnel_binding(tunnel_id, status);
+
+CREATE TABLE IF NOT EXISTS node_connection_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    node_id INTEGER NOT NULL,
+    event TEXT NOT NULL,
+    remote_ip TEXT NOT NULL DEFAULT '',
+    version TEXT NOT NULL DEFAULT '',
+    created_time INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_connection_log_node_time ON node_connection_log(node_id, created_time);
+
+CREATE TABLE IF NOT EXISTS node_latency (
+    from_node_id INTEGER NOT NULL,
+    to_node_id INTEGER NOT NULL,
+    latency_ms REAL NOT NULL,
+    measured_at INTEGER NOT NULL,
+    PRIMARY KEY (from_node_id, to_node_id)
+);
+
+CREATE TABLE IF NOT EXISTS chain_hop_stats (
+    tunnel_id INTEGER NOT NULL,
+    chain_type INTEGER NOT NULL,
+    node_id INTEGER NOT NULL,
+    avg_latency_ms REAL NOT NULL DEFAULT 0,
+    bytes_in INTEGER NOT NULL DEFAULT 0,
+    bytes_out INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL,
+    PRIMARY KEY (tunnel_id, chain_type, node_id)
+);
+
+CREATE TABLE IF NOT EXISTS forward_baseline (
+    forward_id INTEGER PRIMARY KEY,
+    avg_bytes_per_upload REAL NOT NULL DEFAULT 0,
+    std_dev REAL NOT NULL DEFAULT 0,
+    sample_count INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS group_tunnel_quota (
+    group_id INTEGER NOT NULL,
+    tunnel_id INTEGER NOT NULL,
+    max_forwards INTEGER NOT NULL DEFAULT 0,
+    updated_time INTEGER NOT NULL,
+    PRIMARY KEY (group_id, tunnel_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_notification_pref (
+    user_id INTEGER PRIMARY KEY,
+    expiry_warning_enabled INTEGER NOT NULL DEFAULT 1,
+    updated_time INTEGER NOT NULL,
+    security_email_enabled INTEGER NOT NULL DEFAULT 0,
+    security_webhook_url TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS flow_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    forward_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    flow_date VARCHAR(10) NOT NULL,
+    in_flow INTEGER NOT NULL DEFAULT 0,
+    out_flow INTEGER NOT NULL DEFAULT 0,
+    created_time INTEGER NOT NULL,
+    updated_time INTEGER NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_flow_log_forward_date ON flow_log(forward_id, user_id, flow_date);
+CREATE INDEX IF NOT EXISTS idx_flow_log_user_date ON flow_log(user_id, flow_date);
+
+CREATE TABLE IF NOT EXISTS reserved_port (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    node_id INTEGER NOT NULL,
+    port_start INTEGER NOT NULL,
+    port_end INTEGER NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_time INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_reserved_port_node ON reserved_port(node_id);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS forward_fts USING fts5(forward_id UNINDEXED, name, remote_addr, in_ip);
+CREATE VIRTUAL TABLE IF NOT EXISTS tunnel_fts USING fts5(tunnel_id UNINDEXED, name);
+
+CREATE TRIGGER IF NOT EXISTS forward_fts_ai AFTER INSERT ON forward BEGIN
+  INSERT INTO forward_fts(forward_id, name, remote_addr, in_ip)
+  VALUES (NEW.id, NEW.name, NEW.remote_addr, COALESCE((SELECT in_ip FROM tunnel WHERE id = NEW.tunnel_id), ''));
+END;
+
+CREATE TRIGGER IF NOT EXISTS forward_fts_au AFTER UPDATE OF name, remote_addr, tunnel_id ON forward BEGIN
+  DELETE FROM forward_fts WHERE forward_id = OLD.id;
+  INSERT INTO forward_fts(forward_id, name, remote_addr, in_ip)
+  VALUES (NEW.id, NEW.name, NEW.remote_addr, COALESCE((SELECT in_ip FROM tunnel WHERE id = NEW.tunnel_id), ''));
+END;
+
+CREATE TRIGGER IF NOT EXISTS forward_fts_ad AFTER DELETE ON forward BEGIN
+  DELETE FROM forward_fts WHERE forward_id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS tunnel_fts_ai AFTER INSERT ON tunnel BEGIN
+  INSERT INTO tunnel_fts(tunnel_id, name) VALUES (NEW.id, NEW.name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS tunnel_fts_au AFTER UPDATE OF name, in_ip ON tunnel BEGIN
+  DELETE FROM tunnel_fts WHERE tunnel_id = OLD.id;
+  INSERT INTO tunnel_fts(tunnel_id, name) VALUES (NEW.id, NEW.name);
+  UPDATE forward_fts SET in_ip = COALESCE(NEW.in_ip, '')
+  WHERE forward_id IN (SELECT id FROM forward WHERE tunnel_id = NEW.id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS tunnel_fts_ad AFTER DELETE ON tunnel BEGIN
+  DELETE FROM tunnel_fts WHERE tunnel_id = OLD.id;
+END;
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    username TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL DEFAULT '',
+    target_id INTEGER NOT NULL DEFAULT 0,
+    detail TEXT NOT NULL DEFAULT '',
+    created_time INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_time ON audit_log(created_time);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action, created_time);
+
+CREATE TABLE IF NOT EXISTS refresh_token (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at INTEGER NOT NULL,
+    revoked_time INTEGER NOT NULL DEFAULT 0,
+    device VARCHAR(100) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    last_seen_time INTEGER NOT NULL DEFAULT 0,
+    created_time INTEGER NOT NULL,
+    updated_time INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_token_user ON refresh_token(user_id);
+
+CREATE TABLE IF NOT EXISTS user_recovery_code (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_time INTEGER NOT NULL DEFAULT 0,
+    created_time INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_code_user ON user_recovery_code(user_id, code_hash);
+
+CREATE TABLE IF NOT EXISTS user_api_key (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    key_prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    last_used_time INTEGER NOT NULL DEFAULT 0,
+    revoked_time INTEGER NOT NULL DEFAULT 0,
+    created_time INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_api_key_user ON user_api_key(user_id);
+
+CREATE TABLE IF NOT EXISTS user_oidc_identity (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    created_time INTEGER NOT NULL,
+    UNIQUE(issuer, subject)
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_credential (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    credential_id TEXT NOT NULL UNIQUE,
+    public_key TEXT NOT NULL,
+    sign_count INTEGER NOT NULL DEFAULT 0,
+    last_used_time INTEGER NOT NULL DEFAULT 0,
+    created_time INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credential_user ON webauthn_credential(user_id);
+
+CREATE TABLE IF NOT EXISTS jwt_signing_key (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kid TEXT NOT NULL UNIQUE,
+    secret TEXT NOT NULL,
+    created_time INTEGER NOT NULL,
+    retired_time INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS role (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_time INTEGER NOT NULL,
+    updated_time INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permission (
+    role_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    PRIMARY KEY (role_id, action)
+);
+
+CREATE TABLE IF NOT EXISTS ip_ban (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    ip VARCHAR(100) NOT NULL UNIQUE,
+    reason TEXT NOT NULL DEFAULT '',
+    failures INTEGER NOT NULL DEFAULT 0,
+    banned_until INTEGER NOT NULL,
+    created_time INTEGER NOT NULL
+);
@@ -0,0 +1,23 @@
+package store
+
+import "context"
+
+type txContextKey struct{}
+
+// WithTx returns a copy of ctx carrying tx, so that repository calls made
+// with the returned context join the transaction instead of using the pool.
+func WithTx(ctx context.Context, tx *Tx) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, txContextKey{}, tx)
+}
+
+// TxFromCtx returns the transaction stored in ctx by WithTx, if any.
+func TxFromCtx(ctx context.Context) (*Tx, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	tx, ok := ctx.Value(txContextKey{}).(*Tx)
+	return tx, ok && tx != nil
+}
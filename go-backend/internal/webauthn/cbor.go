@@ -0,0 +1,140 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("webauthn: truncated cbor")
+
+// decodeCBOR decodes the first CBOR item in buf and returns it with the
+// number of bytes it used. It understands the subset authenticators emit:
+// integers, byte and text strings, arrays, maps, tags and simple values,
+// all with definite lengths. Integers decode to int64, maps to
+// map[interface{}]interface{}.
+func decodeCBOR(buf []byte) (interface{}, int, error) {
+	return decodeCBORItem(buf, 0)
+}
+
+func decodeCBORItem(buf []byte, depth int) (interface{}, int, error) {
+	if depth > maxCBORDepth {
+		return nil, 0, errors.New("webauthn: cbor nested too deeply")
+	}
+	if len(buf) == 0 {
+		return nil, 0, errCBORTruncated
+	}
+	major, info := buf[0]>>5, buf[0]&0x1f
+	arg, n, err := cborArgument(buf, info)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, 0, errors.New("webauthn: cbor integer overflow")
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, 0, errors.New("webauthn: cbor integer overflow")
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if uint64(len(buf)-n) < arg {
+			return nil, 0, errCBORTruncated
+		}
+		end := n + int(arg)
+		if major == 2 {
+			return append([]byte(nil), buf[n:end]...), end, nil
+		}
+		return string(buf[n:end]), end, nil
+	case 4:
+		if arg > uint64(len(buf)) {
+			return nil, 0, errCBORTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, used, err := decodeCBORItem(buf[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			n += used
+		}
+		return items, n, nil
+	case 5:
+		if arg > uint64(len(buf)) {
+			return nil, 0, errCBORTruncated
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, used, err := decodeCBORItem(buf[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += used
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errors.New("webauthn: unsupported cbor map key")
+			}
+			value, used, err := decodeCBORItem(buf[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += used
+			m[key] = value
+		}
+		return m, n, nil
+	case 6:
+		item, used, err := decodeCBORItem(buf[n:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return item, n + used, nil
+	default:
+		switch info {
+		case 20:
+			return false, 1, nil
+		case 21:
+			return true, 1, nil
+		case 22, 23:
+			return nil, 1, nil
+		}
+		return nil, 0, fmt.Errorf("webauthn: unsupported cbor simple value %d", info)
+	}
+}
+
+// cborArgument reads the argument that follows an initial byte and returns
+// it with the total header length.
+func cborArgument(buf []byte, info byte) (uint64, int, error) {
+	switch {
+	case info < 24:
+		return uint64(info), 1, nil
+	case info == 24:
+		if len(buf) < 2 {
+			return 0, 0, errCBORTruncated
+		}
+		return uint64(buf[1]), 2, nil
+	case info == 25:
+		if len(buf) < 3 {
+			return 0, 0, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(buf[1:])), 3, nil
+	case info == 26:
+		if len(buf) < 5 {
+			return 0, 0, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(buf[1:])), 5, nil
+	case info == 27:
+		if len(buf) < 9 {
+			return 0, 0, errCBORTruncated
+		}
+		return binary.BigEndian.Uint64(buf[1:]), 9, nil
+	}
+	return 0, 0, errors.New("webauthn: indefinite-length cbor is not supported")
+}
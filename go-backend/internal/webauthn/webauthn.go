@@ -0,0 +1,238 @@
+// Package webauthn verifies passkey registrations and assertions (Web
+// Authentication Level 2) for a single relying party. Only ES256 and RS256
+// credentials are accepted, and attestation statements are not checked: the
+// panel trusts whichever authenticator a signed-in user registers, exactly
+// as with the "none" attestation conveyance it asks for.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers this package verifies.
+const (
+	AlgES256 = -7
+	AlgRS256 = -257
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagAttestedData = 0x40
+)
+
+// RelyingParty is what every ceremony is checked against.
+type RelyingParty struct {
+	ID     string // e.g. panel.example.com
+	Origin string // e.g. https://panel.example.com
+}
+
+// Credential is a registered public key. PublicKey holds the COSE key as
+// the authenticator sent it.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	credID    []byte
+	publicKey []byte
+}
+
+// Encoding is the unpadded base64url used for every binary WebAuthn field
+// in JSON.
+var Encoding = base64.RawURLEncoding
+
+// ChallengeOf returns the challenge a client data JSON claims to answer, so
+// the caller can look up the ceremony it belongs to before verifying.
+func ChallengeOf(clientDataJSON []byte) (string, error) {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return "", errors.New("webauthn: invalid client data")
+	}
+	return cd.Challenge, nil
+}
+
+func (rp RelyingParty) checkClientData(raw []byte, typ, challenge string) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return errors.New("webauthn: invalid client data")
+	}
+	if cd.Type != typ {
+		return errors.New("webauthn: unexpected ceremony type")
+	}
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return errors.New("webauthn: challenge mismatch")
+	}
+	if cd.Origin != rp.Origin {
+		return errors.New("webauthn: origin mismatch")
+	}
+	return nil
+}
+
+func (rp RelyingParty) checkAuthData(ad *authenticatorData) error {
+	want := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.rpIDHash, want[:]) {
+		return errors.New("webauthn: relying party mismatch")
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return errors.New("webauthn: user not present")
+	}
+	return nil
+}
+
+// VerifyRegistration checks the response to navigator.credentials.create
+// and returns the new credential.
+func (rp RelyingParty) VerifyRegistration(challenge string, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+	decoded, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	att, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webauthn: invalid attestation object")
+	}
+	rawAuthData, ok := att["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: attestation without authenticator data")
+	}
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.checkAuthData(ad); err != nil {
+		return nil, err
+	}
+	if ad.credID == nil {
+		return nil, errors.New("webauthn: attestation without credential")
+	}
+	if _, err := parsePublicKey(ad.publicKey); err != nil {
+		return nil, err
+	}
+	return &Credential{ID: ad.credID, PublicKey: ad.publicKey, SignCount: ad.signCount}, nil
+}
+
+// VerifyAssertion checks the response to navigator.credentials.get against
+// cred and returns the authenticator's new signature counter. A counter that
+// did not move forward means the credential was cloned, unless the
+// authenticator does not keep one (both zero).
+func (rp RelyingParty) VerifyAssertion(cred *Credential, challenge string, clientDataJSON, rawAuthData, signature []byte) (uint32, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.checkAuthData(ad); err != nil {
+		return 0, err
+	}
+	key, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientHash[:]...)
+	digest := sha256.Sum256(signed)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], signature) {
+			return 0, errors.New("webauthn: bad signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature); err != nil {
+			return 0, errors.New("webauthn: bad signature")
+		}
+	}
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return 0, errors.New("webauthn: signature counter did not increase")
+	}
+	return ad.signCount, nil
+}
+
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+	ad := &authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if ad.flags&flagAttestedData == 0 {
+		return ad, nil
+	}
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("webauthn: attested credential data too short")
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return nil, errors.New("webauthn: attested credential data too short")
+	}
+	ad.credID = rest[:idLen]
+	_, used, err := decodeCBOR(rest[idLen:])
+	if err != nil {
+		return nil, err
+	}
+	ad.publicKey = rest[idLen : idLen+used]
+	return ad, nil
+}
+
+// parsePublicKey turns a COSE_Key into an ECDSA P-256 or RSA public key.
+func parsePublicKey(cose []byte) (crypto.PublicKey, error) {
+	decoded, _, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webauthn: invalid public key")
+	}
+	alg, _ := m[int64(3)].(int64)
+	switch alg {
+	case AlgES256:
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv, _ := m[int64(-1)].(int64); crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("webauthn: invalid P-256 key")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("webauthn: invalid P-256 key")
+		}
+		return key, nil
+	case AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("webauthn: invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return nil, errors.New("webauthn: unsupported key algorithm")
+}
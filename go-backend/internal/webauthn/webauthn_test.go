@@ -0,0 +1,62 @@
+package webauthn_test
+
+import (
+	"testing"
+
+	"go-backend/internal/webauthn"
+	"go-backend/internal/webauthn/webauthntest"
+)
+
+func TestRegisterAndAssert(t *testing.T) {
+	rp := webauthn.RelyingParty{ID: "panel.example.com", Origin: "https://panel.example.com"}
+	auth := webauthntest.New(rp.ID, rp.Origin)
+
+	clientData, attestation := auth.Register("reg-challenge")
+	if _, err := rp.VerifyRegistration("other-challenge", clientData, attestation); err == nil {
+		t.Fatalf("expected a challenge mismatch to be rejected")
+	}
+	cred, err := rp.VerifyRegistration("reg-challenge", clientData, attestation)
+	if err != nil {
+		t.Fatalf("verify registration: %v", err)
+	}
+	if string(cred.ID) != string(auth.ID) {
+		t.Fatalf("unexpected credential id")
+	}
+
+	clientData, authData, sig := auth.Assert("login-challenge")
+	count, err := rp.VerifyAssertion(cred, "login-challenge", clientData, authData, sig)
+	if err != nil {
+		t.Fatalf("verify assertion: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected sign count 1, got %d", count)
+	}
+
+	// Replaying with the stored counter caught up must fail.
+	cred.SignCount = count
+	if _, err := rp.VerifyAssertion(cred, "login-challenge", clientData, authData, sig); err == nil {
+		t.Fatalf("expected a replayed assertion to be rejected")
+	}
+
+	clientData, authData, sig = auth.Assert("login-challenge")
+	sig[len(sig)-1] ^= 0xff
+	if _, err := rp.VerifyAssertion(cred, "login-challenge", clientData, authData, sig); err == nil {
+		t.Fatalf("expected a bad signature to be rejected")
+	}
+}
+
+func TestRelyingPartyMismatch(t *testing.T) {
+	rp := webauthn.RelyingParty{ID: "panel.example.com", Origin: "https://panel.example.com"}
+
+	other := webauthntest.New("evil.example", rp.Origin)
+	clientData, attestation := other.Register("c")
+	if _, err := rp.VerifyRegistration("c", clientData, attestation); err == nil {
+		t.Fatalf("expected a foreign rp id to be rejected")
+	}
+
+	phished := webauthntest.New(rp.ID, "https://evil.example")
+	clientData, attestation = phished.Register("c")
+	if _, err := rp.VerifyRegistration("c", clientData, attestation); err == nil {
+		t.Fatalf("expected a foreign origin to be rejected")
+	}
+}
@@ -0,0 +1,138 @@
+// Package webauthntest provides a software authenticator for tests. It
+// creates an ES256 credential with "none" attestation and signs assertions
+// the way a browser would hand them to the relying party.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+
+	"go-backend/internal/webauthn"
+)
+
+// Authenticator holds one credential for one relying party.
+type Authenticator struct {
+	RPID      string
+	Origin    string
+	ID        []byte
+	SignCount uint32
+
+	key *ecdsa.PrivateKey
+}
+
+// New creates an authenticator with a fresh P-256 key.
+func New(rpID, origin string) *Authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("webauthntest: " + err.Error())
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic("webauthntest: " + err.Error())
+	}
+	return &Authenticator{RPID: rpID, Origin: origin, ID: id, key: key}
+}
+
+// CredentialID is the credential ID as the browser reports it.
+func (a *Authenticator) CredentialID() string {
+	return webauthn.Encoding.EncodeToString(a.ID)
+}
+
+// Register answers navigator.credentials.create for challenge.
+func (a *Authenticator) Register(challenge string) (clientDataJSON, attestationObject []byte) {
+	clientDataJSON = a.clientData("webauthn.create", challenge)
+
+	x := a.key.X.FillBytes(make([]byte, 32))
+	y := a.key.Y.FillBytes(make([]byte, 32))
+	coseKey := cborMap(map[int64][]byte{
+		1:  cborInt(2),  // kty: EC2
+		3:  cborInt(-7), // alg: ES256
+		-1: cborInt(1),  // crv: P-256
+		-2: cborBytes(x),
+		-3: cborBytes(y),
+	})
+
+	authData := a.authData(0x41) // user present, attested credential data
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.ID)))
+	authData = append(authData, a.ID...)
+	authData = append(authData, coseKey...)
+
+	attestationObject = cborHead(5, 3)
+	attestationObject = append(attestationObject, cborText("fmt")...)
+	attestationObject = append(attestationObject, cborText("none")...)
+	attestationObject = append(attestationObject, cborText("attStmt")...)
+	attestationObject = append(attestationObject, cborHead(5, 0)...)
+	attestationObject = append(attestationObject, cborText("authData")...)
+	attestationObject = append(attestationObject, cborBytes(authData)...)
+	return clientDataJSON, attestationObject
+}
+
+// Assert answers navigator.credentials.get for challenge, advancing the
+// signature counter.
+func (a *Authenticator) Assert(challenge string) (clientDataJSON, authData, signature []byte) {
+	a.SignCount++
+	clientDataJSON = a.clientData("webauthn.get", challenge)
+	authData = a.authData(0x01)
+	clientHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		panic("webauthntest: " + err.Error())
+	}
+	return clientDataJSON, authData, signature
+}
+
+func (a *Authenticator) clientData(typ, challenge string) []byte {
+	raw, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": a.Origin})
+	return raw
+}
+
+func (a *Authenticator) authData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	out := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(out, a.SignCount)
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 1<<8:
+		return []byte{major<<5 | 24, byte(n)}
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	}
+	return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+}
+
+func cborInt(v int64) []byte {
+	if v < 0 {
+		return cborHead(1, uint64(-1-v))
+	}
+	return cborHead(0, uint64(v))
+}
+
+func cborBytes(b []byte) []byte { return append(cborHead(2, uint64(len(b))), b...) }
+
+func cborText(s string) []byte { return append(cborHead(3, uint64(len(s))), s...) }
+
+// cborMap encodes a map with integer keys whose values are already encoded.
+func cborMap(m map[int64][]byte) []byte {
+	keys := make([]int64, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	out := cborHead(5, uint64(len(m)))
+	for _, k := range keys {
+		out = append(out, cborInt(k)...)
+		out = append(out, m[k]...)
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package ws
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrCommandNotSupported is returned by SendCommand when the node's agent
+// version is older than the command requires.
+var ErrCommandNotSupported = errors.New("command not supported by node version")
+
+// nodeCommands lists every command the node agent handles with the first
+// agent release that understands it. Commands from before the registry
+// existed are marked 1.0.0 so any released agent receives them.
+var nodeCommands = map[string]string{
+	"AddService":      "1.0.0",
+	"UpdateService":   "1.0.0",
+	"DeleteService":   "1.0.0",
+	"PauseService":    "1.0.0",
+	"ResumeService":   "1.0.0",
+	"AddChains":       "1.0.0",
+	"UpdateChains":    "1.0.0",
+	"DeleteChains":    "1.0.0",
+	"AddLimiters":     "1.0.0",
+	"UpdateLimiters":  "1.0.0",
+	"DeleteLimiters":  "1.0.0",
+	"TcpPing":         "1.0.0",
+	"SetProtocol":     "1.0.0",
+	"UpgradeAgent":    "1.0.0",
+	"RollbackAgent":   "1.0.0",
+	"Ping":            "1.0.0",
+	"ThrottleService": "2.2.0",
+	"RotateSecret":    "2.2.0",
+	"CommitSecret":    "2.2.0",
+	"MeasureLatency":  "2.2.0",
+}
+
+// CommandRegistry maps command types to the minimum node agent version that
+// understands them. Unregistered commands are sent to every node.
+type CommandRegistry struct {
+	mu          sync.RWMutex
+	minVersions map[string]string
+}
+
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{minVersions: make(map[string]string)}
+}
+
+// Register records that cmdType needs a node at minVersion or newer.
+func (c *CommandRegistry) Register(cmdType string, minVersion string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.minVersions[strings.TrimSpace(cmdType)] = strings.TrimSpace(minVersion)
+	c.mu.Unlock()
+}
+
+// MinVersion returns the version registered for cmdType, if any.
+func (c *CommandRegistry) MinVersion(cmdType string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.minVersions[strings.TrimSpace(cmdType)]
+	return v, ok
+}
+
+// Supports reports whether a node running nodeVersion understands cmdType.
+// Versions that cannot be parsed, such as development builds, are assumed
+// to support everything.
+func (c *CommandRegistry) Supports(cmdType string, nodeVersion string) bool {
+	minVersion, ok := c.MinVersion(cmdType)
+	if !ok {
+		return true
+	}
+	have, okHave := parseNodeVersion(nodeVersion)
+	want, okWant := parseNodeVersion(minVersion)
+	if !okHave || !okWant {
+		return true
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			return have[i] > want[i]
+		}
+	}
+	return true
+}
+
+// parseNodeVersion reads "v1.5.0", "1.5" or "1.5.0-beta" into major, minor
+// and patch numbers.
+func parseNodeVersion(v string) ([3]int, bool) {
+	var out [3]int
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return out, false
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return out, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return out, false
+		}
+		out[i] = n
+	}
+	return out, true
+}
@@ -0,0 +1,159 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+)
+
+const (
+	maxMultiplexedChannelsConfigKey = "ws_max_multiplexed_channels"
+	defaultMaxMultiplexedChannels   = 4
+
+	// multiplexQueueSize is how many messages a channel buffers before the
+	// read loop waits for its worker.
+	multiplexQueueSize = 256
+)
+
+// multiplexRequest is sent by a node that wants its messages handled on
+// several logical channels instead of one after another.
+type multiplexRequest struct {
+	Channels int `json:"channels"`
+}
+
+type multiplexedMessage struct {
+	msgType string
+	msg     string
+}
+
+// MultiplexedSession splits a node's connection into logical channels. Each
+// granted channel has its own worker, so a slow message on one channel does
+// not hold up the others. Messages without a "chan" field, or with an
+// unknown one, stay on the read loop as before.
+type MultiplexedSession struct {
+	ns       *nodeSession
+	channels map[int]chan multiplexedMessage
+	wg       sync.WaitGroup
+}
+
+func newMultiplexedSession(ns *nodeSession, count int, handle func(msgType, msg string)) *MultiplexedSession {
+	m := &MultiplexedSession{
+		ns:       ns,
+		channels: make(map[int]chan multiplexedMessage, count),
+	}
+	for id := 1; id <= count; id++ {
+		queue := make(chan multiplexedMessage, multiplexQueueSize)
+		m.channels[id] = queue
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			for item := range queue {
+				handle(item.msgType, item.msg)
+			}
+		}()
+	}
+	return m
+}
+
+// ChannelIDs returns the granted channel IDs in ascending order.
+func (m *MultiplexedSession) ChannelIDs() []int {
+	ids := make([]int, 0, len(m.channels))
+	for id := 1; id <= len(m.channels); id++ {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// dispatch queues msg on channel chanID and reports whether the channel
+// exists.
+func (m *MultiplexedSession) dispatch(chanID int, msgType, msg string) bool {
+	if m == nil {
+		return false
+	}
+	queue, ok := m.channels[chanID]
+	if !ok {
+		return false
+	}
+	queue <- multiplexedMessage{msgType: msgType, msg: msg}
+	return true
+}
+
+// send writes msg, which must encode as a JSON object, to the node tagged
+// with chanID. Channel 0 is the connection itself and is sent untagged.
+func (m *MultiplexedSession) send(chanID int, msg interface{}) error {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if chanID != 0 {
+		if _, ok := m.channels[chanID]; !ok {
+			return errors.New("unknown multiplexed channel")
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return err
+		}
+		fields["chan"], _ = json.Marshal(chanID)
+		if raw, err = json.Marshal(fields); err != nil {
+			return err
+		}
+	}
+	return writeNodeMessage(m.ns, raw)
+}
+
+// close stops accepting messages and waits for the workers to drain their
+// queues. It must be called from the read loop, the only caller of dispatch.
+func (m *MultiplexedSession) close() {
+	if m == nil {
+		return
+	}
+	for _, queue := range m.channels {
+		close(queue)
+	}
+	m.wg.Wait()
+}
+
+// maxMultiplexedChannels is the most channels a node may be granted, from
+// ws_max_multiplexed_channels.
+func (s *Server) maxMultiplexedChannels() int {
+	cfg, err := s.repo.GetConfigByName(maxMultiplexedChannelsConfigKey)
+	if err != nil || cfg == nil {
+		return defaultMaxMultiplexedChannels
+	}
+	n := parseIntDefault(strings.TrimSpace(cfg.Value), 0)
+	if n <= 0 {
+		return defaultMaxMultiplexedChannels
+	}
+	return n
+}
+
+// handleMultiplexRequest replaces the node's channels with the number it
+// asked for, capped by the configured maximum, and replies with the granted
+// channel IDs. Asking for 0 channels turns multiplexing off.
+func (s *Server) handleMultiplexRequest(ns *nodeSession, current *MultiplexedSession, message string) *MultiplexedSession {
+	var req multiplexRequest
+	if err := json.Unmarshal([]byte(message), &req); err != nil {
+		return current
+	}
+	current.close()
+
+	count := req.Channels
+	if limit := s.maxMultiplexedChannels(); count > limit {
+		count = limit
+	}
+	if count < 0 {
+		count = 0
+	}
+	mux := newMultiplexedSession(ns, count, func(msgType, msg string) {
+		s.handleNodeMessage(ns, msgType, msg)
+	})
+	_ = mux.send(0, map[string]interface{}{
+		"type":     "MultiplexResponse",
+		"channels": mux.ChannelIDs(),
+	})
+	if count == 0 {
+		return nil
+	}
+	return mux
+}
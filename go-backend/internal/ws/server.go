@@ -14,6 +14,7 @@ import (
 	"github.com/gorilla/websocket"
 
 	"go-backend/internal/auth"
+	"go-backend/internal/network"
 	"go-backend/internal/security"
 	"go-backend/internal/store/sqlite"
 )
@@ -36,9 +37,16 @@ type connWrap struct {
 }
 
 type nodeSession struct {
-	nodeID int64
-	secret string
-	conn   *connWrap
+	nodeID      int64
+	nodeName    string
+	secretMu    sync.RWMutex
+	secret      string
+	oldSecret   string // accepted on incoming messages after a rekey
+	version     string
+	remoteAddr  string
+	connectedAt time.Time
+	conn        *connWrap
+	counters    sessionCounters
 }
 
 type commandResponse struct {
@@ -49,17 +57,66 @@ type commandResponse struct {
 	RequestID string          `json:"requestId,omitempty"`
 }
 
+// serviceAddedAck is sent by a node once a service from AddService has
+// actually bound (or failed to bind) its listening port.
+type serviceAddedAck struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+	Port    int    `json:"port"`
+}
+
+// latencyTarget is one peer a node is asked to measure in MeasureLatency.
+// Port is where the node dials the peer; a refused connection still counts
+// as a round trip.
+type latencyTarget struct {
+	NodeID int64  `json:"nodeId"`
+	IP     string `json:"ip"`
+	Port   int    `json:"port"`
+}
+
+// latencyResult is sent by a node once it has measured the targets of a
+// MeasureLatency command. Unreachable targets are reported with success false.
+type latencyResult struct {
+	Results []struct {
+		NodeID    int64   `json:"nodeId"`
+		LatencyMs float64 `json:"latencyMs"`
+		Success   bool    `json:"success"`
+	} `json:"results"`
+}
+
+// hopStats is sent periodically by a node for every tunnel chain hop it
+// serves, with the average latency to the next hop and the bytes relayed
+// since the last report.
+type hopStats struct {
+	Hops []struct {
+		TunnelID     int64   `json:"tunnelId"`
+		ChainType    int     `json:"chainType"`
+		AvgLatencyMs float64 `json:"avgLatencyMs"`
+		BytesIn      int64   `json:"bytesIn"`
+		BytesOut     int64   `json:"bytesOut"`
+	} `json:"hops"`
+}
+
 type pendingRequest struct {
 	nodeID int64
 	ch     chan CommandResult
 }
 
 const (
-	wsPingPeriod = 15 * time.Second
-	wsPongWait   = 45 * time.Second
-	wsWriteWait  = 5 * time.Second
+	wsWriteWait = 5 * time.Second
+
+	keepaliveIntervalConfigKey = "ws_keepalive_interval_sec"
+	keepaliveTimeoutConfigKey  = "ws_keepalive_timeout_sec"
+	defaultKeepaliveInterval   = 20 * time.Second
+	defaultKeepaliveTimeout    = 5 * time.Second
+
+	// maxSkewRefresh is how long the node_message_max_skew_sec value is
+	// cached; the read loop would otherwise query it for every message.
+	maxSkewRefresh = 30 * time.Second
 )
 
+var errStaleMessage = errors.New("message timestamp outside the allowed window")
+
 type CommandResult struct {
 	Type    string                 `json:"type"`
 	Success bool                   `json:"success"`
@@ -68,31 +125,133 @@ type CommandResult struct {
 }
 
 type Server struct {
-	repo      *sqlite.Repository
-	jwtSecret string
-	upgrader  websocket.Upgrader
+	repo     *sqlite.Repository
+	keys     *auth.Keyring
+	upgrader websocket.Upgrader
 
 	mu      sync.RWMutex
 	admins  map[*connWrap]struct{}
 	nodes   map[int64]*nodeSession
 	byConn  map[*websocket.Conn]*nodeSession
 	pending map[string]pendingRequest
+
+	commands *CommandRegistry
+
+	hookMu          sync.RWMutex
+	onNodeConnected func(nodeID int64)
+	onAuthFailure   func(r *http.Request)
+	validateToken   func(token string) (auth.Claims, bool)
+
+	keepaliveMu       sync.RWMutex
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+
+	maxSkewMu     sync.Mutex
+	maxSkew       time.Duration
+	maxSkewReadAt time.Time
 }
 
-func NewServer(repo *sqlite.Repository, jwtSecret string) *Server {
+func NewServer(repo *sqlite.Repository, keys *auth.Keyring) *Server {
+	commands := NewCommandRegistry()
+	for cmdType, minVersion := range nodeCommands {
+		commands.Register(cmdType, minVersion)
+	}
 	return &Server{
-		repo:      repo,
-		jwtSecret: jwtSecret,
+		repo: repo,
+		keys: keys,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
-		admins:  make(map[*connWrap]struct{}),
-		nodes:   make(map[int64]*nodeSession),
-		byConn:  make(map[*websocket.Conn]*nodeSession),
-		pending: make(map[string]pendingRequest),
+		admins:   make(map[*connWrap]struct{}),
+		nodes:    make(map[int64]*nodeSession),
+		byConn:   make(map[*websocket.Conn]*nodeSession),
+		pending:  make(map[string]pendingRequest),
+		commands: commands,
 	}
 }
 
+// SetNodeConnectedHook registers fn to run, in its own goroutine, every time
+// a node opens a session. It is meant for restoring the node's services.
+func (s *Server) SetNodeConnectedHook(fn func(nodeID int64)) {
+	s.hookMu.Lock()
+	s.onNodeConnected = fn
+	s.hookMu.Unlock()
+}
+
+// SetAuthFailureHook registers fn to run when a node connection presents an
+// unknown secret. It is meant for brute-force tracking.
+func (s *Server) SetAuthFailureHook(fn func(r *http.Request)) {
+	s.hookMu.Lock()
+	s.onAuthFailure = fn
+	s.hookMu.Unlock()
+}
+
+// SetTokenValidator replaces the check admin connections' tokens must pass,
+// which by default only verifies the signature against the keyring.
+func (s *Server) SetTokenValidator(fn func(token string) (auth.Claims, bool)) {
+	s.hookMu.Lock()
+	s.validateToken = fn
+	s.hookMu.Unlock()
+}
+
+// Commands returns the registry SendCommand consults before dispatching a
+// command to a node.
+func (s *Server) Commands() *CommandRegistry {
+	return s.commands
+}
+
+// SetKeepaliveConfig overrides the ping interval and pong timeout for
+// sessions opened afterwards. Zero values fall back to the configured
+// ws_keepalive_interval_sec / ws_keepalive_timeout_sec.
+func (s *Server) SetKeepaliveConfig(interval, timeout time.Duration) {
+	s.keepaliveMu.Lock()
+	s.keepaliveInterval = interval
+	s.keepaliveTimeout = timeout
+	s.keepaliveMu.Unlock()
+}
+
+func (s *Server) keepaliveConfig() (time.Duration, time.Duration) {
+	s.keepaliveMu.RLock()
+	interval, timeout := s.keepaliveInterval, s.keepaliveTimeout
+	s.keepaliveMu.RUnlock()
+	if interval <= 0 {
+		interval = s.configSeconds(keepaliveIntervalConfigKey, defaultKeepaliveInterval)
+	}
+	if timeout <= 0 {
+		timeout = s.configSeconds(keepaliveTimeoutConfigKey, defaultKeepaliveTimeout)
+	}
+	return interval, timeout
+}
+
+// nodeMessageMaxSkew is how far an encrypted message's timestamp may be
+// from the panel clock.
+func (s *Server) nodeMessageMaxSkew(now time.Time) time.Duration {
+	s.maxSkewMu.Lock()
+	defer s.maxSkewMu.Unlock()
+	if s.maxSkew > 0 && now.Sub(s.maxSkewReadAt) < maxSkewRefresh {
+		return s.maxSkew
+	}
+	value := ""
+	if cfg, err := s.repo.GetConfigByName(security.NodeMessageMaxSkewConfigKey); err == nil && cfg != nil {
+		value = cfg.Value
+	}
+	s.maxSkew = security.ParseNodeMessageMaxSkew(value)
+	s.maxSkewReadAt = now
+	return s.maxSkew
+}
+
+func (s *Server) configSeconds(name string, fallback time.Duration) time.Duration {
+	cfg, err := s.repo.GetConfigByName(name)
+	if err != nil || cfg == nil {
+		return fallback
+	}
+	n := parseIntDefault(strings.TrimSpace(cfg.Value), 0)
+	if n <= 0 {
+		return fallback
+	}
+	return time.Duration(n) * time.Second
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	typeVal := query.Get("type")
@@ -101,15 +260,29 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if typeVal == "1" {
 		node, err := s.repo.GetNodeBySecret(secret)
 		if err != nil || node == nil {
+			if err == nil {
+				s.hookMu.RLock()
+				onAuthFailure := s.onAuthFailure
+				s.hookMu.RUnlock()
+				if onAuthFailure != nil {
+					onAuthFailure(r)
+				}
+			}
 			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
-		s.handleNode(w, r, node.ID, secret)
+		s.handleNode(w, r, node.ID, node.Name, secret)
 		return
 	}
 
 	if typeVal == "0" {
-		if _, ok := auth.ValidateToken(secret, s.jwtSecret); !ok {
+		s.hookMu.RLock()
+		validate := s.validateToken
+		s.hookMu.RUnlock()
+		if validate == nil {
+			validate = func(token string) (auth.Claims, bool) { return auth.ValidateTokenWithKeyring(token, s.keys) }
+		}
+		if _, ok := validate(secret); !ok {
 			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
@@ -126,12 +299,8 @@ func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	cw := &connWrap{conn: conn}
-	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
-	conn.SetPongHandler(func(string) error {
-		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
-	})
 	done := make(chan struct{})
-	go startKeepalive(cw, done)
+	go s.startKeepalive(cw, watchPongs(conn), done, "admin session")
 
 	s.mu.Lock()
 	s.admins[cw] = struct{}{}
@@ -152,37 +321,55 @@ func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) handleNode(w http.ResponseWriter, r *http.Request, nodeID int64, secret string) {
+func (s *Server) handleNode(w http.ResponseWriter, r *http.Request, nodeID int64, nodeName, secret string) {
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
 	cw := &connWrap{conn: conn}
-	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
-	conn.SetPongHandler(func(string) error {
-		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
-	})
 	done := make(chan struct{})
-	go startKeepalive(cw, done)
+	go s.startKeepalive(cw, watchPongs(conn), done, fmt.Sprintf("node %d", nodeID))
 
 	version := r.URL.Query().Get("version")
 	httpVal := parseIntDefault(r.URL.Query().Get("http"), 0)
 	tlsVal := parseIntDefault(r.URL.Query().Get("tls"), 0)
 	socksVal := parseIntDefault(r.URL.Query().Get("socks"), 0)
+	remoteIP := ""
+	if ip := network.ClientIP(r); ip != nil {
+		remoteIP = ip.String()
+	}
 
 	s.mu.Lock()
 	if old, ok := s.nodes[nodeID]; ok {
 		_ = old.conn.conn.Close()
 		delete(s.byConn, old.conn.conn)
 	}
-	ns := &nodeSession{nodeID: nodeID, secret: secret, conn: cw}
+	ns := &nodeSession{
+		nodeID:      nodeID,
+		nodeName:    nodeName,
+		secret:      secret,
+		version:     version,
+		remoteAddr:  remoteIP,
+		connectedAt: time.Now(),
+		conn:        cw,
+	}
 	s.nodes[nodeID] = ns
 	s.byConn[conn] = ns
 	s.mu.Unlock()
 
 	_ = s.repo.UpdateNodeOnline(nodeID, 1, version, httpVal, tlsVal, socksVal)
+	_ = s.repo.RecordNodeConnection(nodeID, "connect", remoteIP, version)
 	s.broadcastStatus(nodeID, 1)
 
+	// The hook sends commands whose replies arrive on this read loop, so it
+	// must not block it.
+	s.hookMu.RLock()
+	onConnected := s.onNodeConnected
+	s.hookMu.RUnlock()
+	if onConnected != nil {
+		go onConnected(nodeID)
+	}
+
 	defer func() {
 		close(done)
 		needOfflineBroadcast := false
@@ -194,6 +381,7 @@ func (s *Server) handleNode(w http.ResponseWriter, r *http.Request, nodeID int64
 		}
 		delete(s.byConn, conn)
 		s.mu.Unlock()
+		_ = s.repo.RecordNodeConnection(nodeID, "disconnect", remoteIP, version)
 		if needOfflineBroadcast {
 			s.failPendingForNode(nodeID, "节点连接已断开")
 			_ = s.repo.UpdateNodeStatus(nodeID, 0)
@@ -202,26 +390,141 @@ func (s *Server) handleNode(w http.ResponseWriter, r *http.Request, nodeID int64
 		_ = conn.Close()
 	}()
 
+	var mux *MultiplexedSession
+	defer func() { mux.close() }()
+
 	for {
 		_, payload, err := conn.ReadMessage()
 		if err != nil {
 			return
 		}
+		ns.counters.recordIn(len(payload))
 
-		msg := decryptIfNeeded(payload, secret)
-		s.tryResolvePending(nodeID, msg)
-
+		now := time.Now()
+		maxSkew := s.nodeMessageMaxSkew(now)
+		msg, err := ns.decrypt(payload, now, maxSkew)
+		if errors.Is(err, errStaleMessage) {
+			log.Printf("node %d (%s): dropped message whose timestamp is outside %s of the panel clock", ns.nodeID, ns.nodeName, maxSkew)
+			continue
+		}
 		var parsed struct {
 			Type string `json:"type"`
+			Chan int    `json:"chan"`
+		}
+		_ = json.Unmarshal([]byte(msg), &parsed)
+		if parsed.Type == "MultiplexRequest" {
+			mux = s.handleMultiplexRequest(ns, mux, msg)
+			continue
 		}
-		if json.Unmarshal([]byte(msg), &parsed) == nil && parsed.Type == "UpgradeProgress" {
-			s.broadcastTyped(nodeID, "upgrade_progress", msg)
-		} else {
-			s.broadcastInfo(nodeID, msg)
+		if mux.dispatch(parsed.Chan, parsed.Type, msg) {
+			continue
 		}
+		s.handleNodeMessage(ns, parsed.Type, msg)
+	}
+}
+
+// handleNodeMessage handles one decrypted message from a node, either on
+// the read loop or on a multiplexed channel worker.
+func (s *Server) handleNodeMessage(ns *nodeSession, msgType, msg string) {
+	nodeID := ns.nodeID
+	s.tryResolvePending(nodeID, msg)
+
+	switch msgType {
+	case "UpgradeProgress":
+		s.broadcastTyped(nodeID, "upgrade_progress", msg)
+	case "ServiceAddedAck":
+		s.handleServiceAddedAck(nodeID, msg)
+	case "LatencyResult":
+		s.handleLatencyResult(nodeID, msg)
+	case "HopStats":
+		s.handleHopStats(nodeID, msg)
+	default:
+		s.broadcastInfo(nodeID, msg)
 	}
 }
 
+// ErrNodeNotConnected is returned when a node has no live session.
+var ErrNodeNotConnected = errors.New("node not connected")
+
+// DisconnectNode sends a close frame carrying reason to the live session of
+// nodeID and closes it. The node is marked offline by the session's own
+// cleanup.
+func (s *Server) DisconnectNode(nodeID int64, reason string) error {
+	if s == nil {
+		return ErrNodeNotConnected
+	}
+	s.mu.RLock()
+	ns, ok := s.nodes[nodeID]
+	s.mu.RUnlock()
+	if !ok || ns == nil || ns.conn == nil || ns.conn.conn == nil {
+		return ErrNodeNotConnected
+	}
+	// Close frame payloads are limited to 125 bytes, two of which hold the code.
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	ns.conn.mu.Lock()
+	_ = ns.conn.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason), time.Now().Add(wsWriteWait))
+	ns.conn.mu.Unlock()
+	return ns.conn.conn.Close()
+}
+
+// RekeyNode sends secret to the connected node as a RotateSecret command,
+// encrypted with the current secret. Once the node acknowledges it the
+// session switches to the new secret without reconnecting; messages still
+// in flight under the old secret are accepted. Nothing is stored.
+func (s *Server) RekeyNode(nodeID int64, secret string, timeout time.Duration) error {
+	if _, err := s.SendCommand(nodeID, "RotateSecret", map[string]interface{}{"secret": secret}, timeout); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	ns, ok := s.nodes[nodeID]
+	s.mu.RUnlock()
+	if !ok || ns == nil {
+		return ErrNodeNotConnected
+	}
+	ns.secretMu.Lock()
+	ns.oldSecret = ns.secret
+	ns.secret = secret
+	ns.secretMu.Unlock()
+	return nil
+}
+
+// CommitNodeSecret tells the node to persist the secret RekeyNode switched it
+// to. The agent keeps its previous secret on disk until then, so a restart
+// before the commit brings it back on the old one.
+func (s *Server) CommitNodeSecret(nodeID int64, secret string, timeout time.Duration) error {
+	_, err := s.SendCommand(nodeID, "CommitSecret", map[string]interface{}{"secret": secret}, timeout)
+	return err
+}
+
+func (ns *nodeSession) currentSecret() string {
+	ns.secretMu.RLock()
+	defer ns.secretMu.RUnlock()
+	return ns.secret
+}
+
+// decrypt decrypts an incoming message with the session secret, falling
+// back to the secret it replaced. An encrypted message stamped further than
+// maxSkew from now is refused with errStaleMessage.
+func (ns *nodeSession) decrypt(payload []byte, now time.Time, maxSkew time.Duration) (string, error) {
+	var wrap encryptedMessage
+	if err := json.Unmarshal(payload, &wrap); err != nil || !wrap.Encrypted || strings.TrimSpace(wrap.Data) == "" {
+		return string(payload), nil
+	}
+	if security.TimestampSkew(wrap.Timestamp, now) > maxSkew {
+		return "", errStaleMessage
+	}
+	ns.secretMu.RLock()
+	secret, oldSecret := ns.secret, ns.oldSecret
+	ns.secretMu.RUnlock()
+	msg := decryptIfNeeded(payload, secret)
+	if oldSecret != "" && msg == string(payload) {
+		msg = decryptIfNeeded(payload, oldSecret)
+	}
+	return msg, nil
+}
+
 func (s *Server) SendCommand(nodeID int64, cmdType string, data interface{}, timeout time.Duration) (CommandResult, error) {
 	if s == nil {
 		return CommandResult{}, errors.New("server not initialized")
@@ -239,6 +542,11 @@ func (s *Server) SendCommand(nodeID int64, cmdType string, data interface{}, tim
 	if !ok || ns == nil || ns.conn == nil || ns.conn.conn == nil {
 		return CommandResult{}, errors.New("节点不在线")
 	}
+	if !s.commands.Supports(cmdType, ns.version) {
+		minVersion, _ := s.commands.MinVersion(cmdType)
+		log.Printf("skip command %s for node %d: version %q is below %s", cmdType, nodeID, ns.version, minVersion)
+		return CommandResult{}, fmt.Errorf("%w: %s requires %s", ErrCommandNotSupported, cmdType, minVersion)
+	}
 
 	requestID := fmt.Sprintf("%d_%d", nodeID, time.Now().UnixNano())
 	ch := make(chan CommandResult, 1)
@@ -267,36 +575,7 @@ func (s *Server) SendCommand(nodeID int64, cmdType string, data interface{}, tim
 		return CommandResult{}, err
 	}
 
-	messageData := rawCmd
-	if strings.TrimSpace(ns.secret) != "" {
-		crypto, err := security.NewAESCrypto(ns.secret)
-		if err != nil {
-			cleanup()
-			return CommandResult{}, err
-		}
-		encrypted, err := crypto.Encrypt(rawCmd)
-		if err != nil {
-			cleanup()
-			return CommandResult{}, err
-		}
-		wrapper := map[string]interface{}{
-			"encrypted": true,
-			"data":      encrypted,
-			"timestamp": time.Now().UnixMilli(),
-		}
-		messageData, err = json.Marshal(wrapper)
-		if err != nil {
-			cleanup()
-			return CommandResult{}, err
-		}
-	}
-
-	ns.conn.mu.Lock()
-	_ = ns.conn.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
-	err = ns.conn.conn.WriteMessage(websocket.TextMessage, messageData)
-	_ = ns.conn.conn.SetWriteDeadline(time.Time{})
-	ns.conn.mu.Unlock()
-	if err != nil {
+	if err := writeNodeMessage(ns, rawCmd); err != nil {
 		cleanup()
 		return CommandResult{}, err
 	}
@@ -319,6 +598,41 @@ func (s *Server) SendCommand(nodeID int64, cmdType string, data interface{}, tim
 	}
 }
 
+// writeNodeMessage encrypts raw with the node secret, when there is one, and
+// writes it to the node's connection.
+func writeNodeMessage(ns *nodeSession, raw []byte) error {
+	messageData := raw
+	if secret := ns.currentSecret(); strings.TrimSpace(secret) != "" {
+		crypto, err := security.NewAESCrypto(secret)
+		if err != nil {
+			return err
+		}
+		encrypted, err := crypto.Encrypt(raw)
+		if err != nil {
+			return err
+		}
+		wrapper := map[string]interface{}{
+			"encrypted": true,
+			"data":      encrypted,
+			"timestamp": time.Now().UnixMilli(),
+		}
+		messageData, err = json.Marshal(wrapper)
+		if err != nil {
+			return err
+		}
+	}
+
+	ns.conn.mu.Lock()
+	defer ns.conn.mu.Unlock()
+	_ = ns.conn.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	err := ns.conn.conn.WriteMessage(websocket.TextMessage, messageData)
+	_ = ns.conn.conn.SetWriteDeadline(time.Time{})
+	if err == nil {
+		ns.counters.recordOut(len(messageData))
+	}
+	return err
+}
+
 func (s *Server) tryResolvePending(nodeID int64, message string) {
 	if s == nil || strings.TrimSpace(message) == "" {
 		return
@@ -369,6 +683,148 @@ func (s *Server) tryResolvePending(nodeID int64, message string) {
 	close(p.ch)
 }
 
+func (s *Server) handleServiceAddedAck(nodeID int64, message string) {
+	if s == nil || s.repo == nil {
+		return
+	}
+	var ack serviceAddedAck
+	if err := json.Unmarshal([]byte(message), &ack); err != nil || ack.Port <= 0 {
+		return
+	}
+	forwardID, err := s.repo.GetForwardIDByNodePort(nodeID, ack.Port)
+	if err != nil || forwardID <= 0 {
+		return
+	}
+	if ack.Success {
+		_ = s.repo.MarkForwardServiceStarted(forwardID)
+		return
+	}
+
+	reason := strings.TrimSpace(ack.Error)
+	if reason == "" {
+		reason = "unknown error"
+	}
+	if err := s.repo.MarkForwardServiceFailed(forwardID); err != nil {
+		log.Printf("mark forward %d failed: %v", forwardID, err)
+		return
+	}
+	_ = s.repo.CreateAuditLog(&sqlite.AuditLog{
+		Username:   "system",
+		Action:     "forward_service_failed",
+		TargetType: "forward",
+		TargetID:   forwardID,
+		Detail:     fmt.Sprintf("node %d port %d: %s", nodeID, ack.Port, reason),
+	})
+}
+
+// ProbeLatency asks every idle online node to measure its latency to the
+// other online nodes. A node is idle when no command to it is in flight.
+// The node acknowledges MeasureLatency straight away; results arrive
+// asynchronously as LatencyResult messages.
+func (s *Server) ProbeLatency() {
+	if s == nil || s.repo == nil {
+		return
+	}
+	addresses, err := s.repo.ListOnlineNodeAddresses()
+	if err != nil {
+		return
+	}
+
+	s.mu.RLock()
+	busy := make(map[int64]struct{})
+	for _, p := range s.pending {
+		busy[p.nodeID] = struct{}{}
+	}
+	peers := make([]*nodeSession, 0, len(s.nodes))
+	for nodeID, ns := range s.nodes {
+		if _, ok := addresses[nodeID]; ok {
+			peers = append(peers, ns)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, ns := range peers {
+		if _, ok := busy[ns.nodeID]; ok || !s.commands.Supports("MeasureLatency", ns.version) {
+			continue
+		}
+		targets := make([]latencyTarget, 0, len(peers))
+		for _, peer := range peers {
+			addr := addresses[peer.nodeID]
+			ip := strings.TrimSpace(addr.IP)
+			if peer.nodeID == ns.nodeID || ip == "" {
+				continue
+			}
+			port := firstPortFromRange(addr.PortRange)
+			if port <= 0 {
+				port = 443
+			}
+			targets = append(targets, latencyTarget{NodeID: peer.nodeID, IP: ip, Port: port})
+		}
+		if len(targets) == 0 {
+			continue
+		}
+		go func(nodeID int64, targets []latencyTarget) {
+			if _, err := s.SendCommand(nodeID, "MeasureLatency", map[string]interface{}{"targets": targets}, 10*time.Second); err != nil {
+				log.Printf("measure latency on node %d: %v", nodeID, err)
+			}
+		}(ns.nodeID, targets)
+	}
+}
+
+// firstPortFromRange returns the first port of a node port range such as
+// "20000-20010,30000", or 0 if it cannot be parsed.
+func firstPortFromRange(portRange string) int {
+	first := strings.TrimSpace(strings.Split(strings.TrimSpace(portRange), ",")[0])
+	first, _, _ = strings.Cut(first, "-")
+	p, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return p
+}
+
+func (s *Server) handleLatencyResult(nodeID int64, message string) {
+	if s == nil || s.repo == nil {
+		return
+	}
+	var res latencyResult
+	if err := json.Unmarshal([]byte(message), &res); err != nil {
+		return
+	}
+	now := time.Now().UnixMilli()
+	for _, item := range res.Results {
+		if !item.Success || item.NodeID <= 0 || item.NodeID == nodeID || item.LatencyMs < 0 {
+			continue
+		}
+		_ = s.repo.UpsertNodeLatency(nodeID, item.NodeID, item.LatencyMs, now)
+	}
+}
+
+func (s *Server) handleHopStats(nodeID int64, message string) {
+	if s == nil || s.repo == nil {
+		return
+	}
+	var stats hopStats
+	if err := json.Unmarshal([]byte(message), &stats); err != nil {
+		return
+	}
+	now := time.Now().UnixMilli()
+	for _, hop := range stats.Hops {
+		if hop.TunnelID <= 0 || hop.ChainType < 1 || hop.ChainType > 3 {
+			continue
+		}
+		_ = s.repo.UpsertChainHopStats(sqlite.ChainHopStat{
+			TunnelID:     hop.TunnelID,
+			ChainType:    hop.ChainType,
+			NodeID:       nodeID,
+			AvgLatencyMs: hop.AvgLatencyMs,
+			BytesIn:      hop.BytesIn,
+			BytesOut:     hop.BytesOut,
+			UpdatedAt:    now,
+		})
+	}
+}
+
 func (s *Server) failPendingForNode(nodeID int64, message string) {
 	if s == nil {
 		return
@@ -467,11 +923,30 @@ func parseIntDefault(v string, fallback int) int {
 	return x
 }
 
-func startKeepalive(cw *connWrap, done <-chan struct{}) {
+// watchPongs signals on the returned channel whenever a pong arrives. Pongs
+// are dispatched by the read loop, so it must be installed before reading.
+func watchPongs(conn *websocket.Conn) <-chan struct{} {
+	pong := make(chan struct{}, 1)
+	conn.SetPongHandler(func(string) error {
+		select {
+		case pong <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	return pong
+}
+
+// startKeepalive pings the peer every keepalive interval and closes the
+// connection when a ping fails or its pong does not arrive within the
+// keepalive timeout.
+func (s *Server) startKeepalive(cw *connWrap, pong <-chan struct{}, done <-chan struct{}, label string) {
 	if cw == nil || cw.conn == nil {
 		return
 	}
-	ticker := time.NewTicker(wsPingPeriod)
+	interval, timeout := s.keepaliveConfig()
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -479,15 +954,34 @@ func startKeepalive(cw *connWrap, done <-chan struct{}) {
 		case <-done:
 			return
 		case <-ticker.C:
-			cw.mu.Lock()
-			_ = cw.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
-			err := cw.conn.WriteMessage(websocket.PingMessage, nil)
-			_ = cw.conn.SetWriteDeadline(time.Time{})
-			cw.mu.Unlock()
-			if err != nil {
-				_ = cw.conn.Close()
-				return
-			}
+		}
+
+		select {
+		case <-pong:
+		default:
+		}
+		cw.mu.Lock()
+		_ = cw.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		err := cw.conn.WriteMessage(websocket.PingMessage, nil)
+		_ = cw.conn.SetWriteDeadline(time.Time{})
+		cw.mu.Unlock()
+		if err != nil {
+			log.Printf("websocket %s ping failed, closing: %v", label, err)
+			_ = cw.conn.Close()
+			return
+		}
+
+		timer := time.NewTimer(timeout)
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-pong:
+			timer.Stop()
+		case <-timer.C:
+			log.Printf("websocket %s missed pong within %s, closing", label, timeout)
+			_ = cw.conn.Close()
+			return
 		}
 	}
 }
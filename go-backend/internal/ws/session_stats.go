@@ -0,0 +1,103 @@
+package ws
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// NodeSessionStat describes one open node session for the admin API.
+// Message and byte counters cover text frames only; keepalive pings are not
+// counted.
+type NodeSessionStat struct {
+	NodeID      int64  `json:"nodeId"`
+	NodeName    string `json:"nodeName"`
+	Secret      string `json:"secret"`
+	ConnectedAt int64  `json:"connectedAt"`
+	RemoteAddr  string `json:"remoteAddr"`
+	MessagesIn  int64  `json:"messagesIn"`
+	MessagesOut int64  `json:"messagesOut"`
+	BytesIn     int64  `json:"bytesIn"`
+	BytesOut    int64  `json:"bytesOut"`
+}
+
+type sessionCounters struct {
+	messagesIn  atomic.Int64
+	messagesOut atomic.Int64
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+}
+
+func (c *sessionCounters) recordIn(n int) {
+	c.messagesIn.Add(1)
+	c.bytesIn.Add(int64(n))
+}
+
+func (c *sessionCounters) recordOut(n int) {
+	c.messagesOut.Add(1)
+	c.bytesOut.Add(int64(n))
+}
+
+// SessionStats returns the open node sessions ordered by node ID. The list
+// is empty, never nil, when no node is connected.
+func (s *Server) SessionStats() []NodeSessionStat {
+	stats := make([]NodeSessionStat, 0)
+	if s == nil {
+		return stats
+	}
+	s.mu.RLock()
+	for _, ns := range s.nodes {
+		stats = append(stats, NodeSessionStat{
+			NodeID:      ns.nodeID,
+			NodeName:    ns.nodeName,
+			Secret:      maskSecret(ns.currentSecret()),
+			ConnectedAt: ns.connectedAt.UnixMilli(),
+			RemoteAddr:  ns.remoteAddr,
+			MessagesIn:  ns.counters.messagesIn.Load(),
+			MessagesOut: ns.counters.messagesOut.Load(),
+			BytesIn:     ns.counters.bytesIn.Load(),
+			BytesOut:    ns.counters.bytesOut.Load(),
+		})
+	}
+	s.mu.RUnlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].NodeID < stats[j].NodeID })
+	return stats
+}
+
+// ConnectedNodeIDs returns the IDs of the nodes with an open session in
+// ascending order.
+func (s *Server) ConnectedNodeIDs() []int64 {
+	ids := make([]int64, 0)
+	if s == nil {
+		return ids
+	}
+	s.mu.RLock()
+	for id := range s.nodes {
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// ConnectedAt reports when the open session of nodeID was established.
+func (s *Server) ConnectedAt(nodeID int64) (time.Time, bool) {
+	if s == nil {
+		return time.Time{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ns, ok := s.nodes[nodeID]
+	if !ok || ns == nil {
+		return time.Time{}, false
+	}
+	return ns.connectedAt, true
+}
+
+// maskSecret hides all but the last four characters of a node secret.
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
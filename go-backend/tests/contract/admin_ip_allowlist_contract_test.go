@@ -0,0 +1,95 @@
+package contract_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdminIPAllowlistContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	if _, err := repo.DB().Exec(`INSERT INTO vite_config(name, value, time) VALUES('admin_allowed_ips', '10.0.0.0/8, 192.0.2.7', ?)`, time.Now().UnixMilli()); err != nil {
+		t.Fatalf("insert admin_allowed_ips: %v", err)
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	send := func(path, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(`{}`))
+		req.Header.Set("Authorization", adminToken)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	expectDenied := func(t *testing.T, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected HTTP 403, got %d", rec.Code)
+		}
+		assertCode(t, rec, 403)
+	}
+
+	t.Run("allowed CIDR passes", func(t *testing.T) {
+		rec := send("/api/v1/admin/tunnel/metrics", "10.1.2.3:40000", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected HTTP 200, got %d", rec.Code)
+		}
+		assertCode(t, rec, 0)
+	})
+
+	t.Run("single allowed IP passes", func(t *testing.T) {
+		assertCode(t, send("/api/v1/admin/tunnel/metrics", "192.0.2.7:40000", ""), 0)
+	})
+
+	t.Run("other IP is denied", func(t *testing.T) {
+		expectDenied(t, send("/api/v1/admin/tunnel/metrics", "203.0.113.1:40000", ""))
+	})
+
+	t.Run("forwarded client from trusted proxy is checked", func(t *testing.T) {
+		expectDenied(t, send("/api/v1/admin/tunnel/metrics", "127.0.0.1:40000", "203.0.113.1"))
+		assertCode(t, send("/api/v1/admin/tunnel/metrics", "127.0.0.1:40000", "10.9.9.9"), 0)
+	})
+
+	t.Run("forwarded header from untrusted peer is ignored", func(t *testing.T) {
+		expectDenied(t, send("/api/v1/admin/tunnel/metrics", "203.0.113.1:40000", "10.1.2.3"))
+	})
+
+	t.Run("admin routes outside /admin are restricted", func(t *testing.T) {
+		expectDenied(t, send("/api/v1/node/list", "203.0.113.1:40000", ""))
+		expectDenied(t, send("/api/v1/user/list", "203.0.113.1:40000", ""))
+		expectDenied(t, send("/api/v1/config/update", "203.0.113.1:40000", ""))
+		assertCode(t, send("/api/v1/node/list", "10.1.2.3:40000", ""), 0)
+	})
+
+	t.Run("every admin and permission-gated group is restricted", func(t *testing.T) {
+		for _, path := range []string{
+			"/api/v1/user/reset-password",
+			"/api/v1/user/toggle-status",
+			"/api/v1/user/import",
+			"/api/v1/system/backup",
+			"/api/v1/security/ban/list",
+			"/api/v1/forward/batch-create",
+			"/api/v1/config/list-by-prefix",
+		} {
+			rec := send(path, "203.0.113.1:40000", "")
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("%s: expected HTTP 403, got %d", path, rec.Code)
+			}
+		}
+		assertCode(t, send("/api/v1/security/ban/list", "10.1.2.3:40000", ""), 0)
+	})
+
+	t.Run("non-admin routes are not restricted", func(t *testing.T) {
+		assertCode(t, send("/api/v1/user/package", "203.0.113.1:40000", ""), 0)
+	})
+}
@@ -0,0 +1,109 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestUserAPIKeyContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path string, headers map[string]string, payload interface{}) response.R {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+	withToken := map[string]string{"Authorization": adminToken}
+
+	out := post("/api/v1/user/apikey/create", withToken, map[string]interface{}{"name": "deploy script"})
+	if out.Code != 0 {
+		t.Fatalf("create api key: code %d (%s)", out.Code, out.Msg)
+	}
+	created := out.Data.(map[string]interface{})
+	key := valueAsString(created["key"])
+	keyID := valueAsInt(created["id"])
+	if !strings.HasPrefix(key, valueAsString(created["keyPrefix"])) || len(key) < 32 {
+		t.Fatalf("unexpected api key %v", created)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM user_api_key WHERE key_hash = ?`, auth.HashAPIKey(key), 1)
+	assertCount(t, repo, `SELECT COUNT(1) FROM user_api_key WHERE key_hash = ?`, key, 0)
+
+	t.Run("name is required", func(t *testing.T) {
+		if out := post("/api/v1/user/apikey/create", withToken, map[string]interface{}{"name": " "}); out.Code == 0 {
+			t.Fatalf("expected empty name to be rejected")
+		}
+	})
+
+	t.Run("key authenticates in place of a token", func(t *testing.T) {
+		if out := post("/api/v1/forward/list", map[string]string{auth.APIKeyHeader: key}, map[string]interface{}{}); out.Code != 0 {
+			t.Fatalf("expected api key to open forward list, got %d (%s)", out.Code, out.Msg)
+		}
+		if out := post("/api/v1/node/list", map[string]string{auth.APIKeyHeader: key}, map[string]interface{}{}); out.Code != 0 {
+			t.Fatalf("expected admin api key to keep admin role, got %d (%s)", out.Code, out.Msg)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM user_api_key WHERE id = ? AND last_used_time > 0`, keyID, 1)
+	})
+
+	t.Run("unknown key is refused", func(t *testing.T) {
+		if out := post("/api/v1/forward/list", map[string]string{auth.APIKeyHeader: "flvx_nope"}, map[string]interface{}{}); out.Code != 401 {
+			t.Fatalf("expected 401, got %d (%s)", out.Code, out.Msg)
+		}
+	})
+
+	t.Run("list never returns the key", func(t *testing.T) {
+		out := post("/api/v1/user/apikey/list", withToken, map[string]interface{}{})
+		if out.Code != 0 {
+			t.Fatalf("list api keys: code %d (%s)", out.Code, out.Msg)
+		}
+		list := out.Data.([]interface{})
+		if len(list) != 1 {
+			t.Fatalf("expected one key, got %v", list)
+		}
+		item := list[0].(map[string]interface{})
+		if item["name"] != "deploy script" || item["key"] != nil || item["keyHash"] != nil {
+			t.Fatalf("unexpected list item %v", item)
+		}
+	})
+
+	t.Run("other users cannot revoke the key", func(t *testing.T) {
+		otherToken, err := contractToken(repo, 2, "someone", 1, secret)
+		if err != nil {
+			t.Fatalf("generate token: %v", err)
+		}
+		if out := post("/api/v1/user/apikey/revoke", map[string]string{"Authorization": otherToken}, map[string]interface{}{"id": keyID}); out.Code == 0 {
+			t.Fatalf("expected revoke of a foreign key to fail")
+		}
+	})
+
+	t.Run("revoked key is refused", func(t *testing.T) {
+		if out := post("/api/v1/user/apikey/revoke", withToken, map[string]interface{}{"id": keyID}); out.Code != 0 {
+			t.Fatalf("revoke api key: code %d (%s)", out.Code, out.Msg)
+		}
+		if out := post("/api/v1/forward/list", map[string]string{auth.APIKeyHeader: key}, map[string]interface{}{}); out.Code != 401 {
+			t.Fatalf("expected revoked key to be refused, got %d (%s)", out.Code, out.Msg)
+		}
+	})
+}
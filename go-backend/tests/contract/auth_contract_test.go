@@ -5,65 +5,102 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"go-backend/internal/auth"
-	"go-backend/internal/http/middleware"
+	httpserver "go-backend/internal/http"
+	"go-backend/internal/http/handler"
 	"go-backend/internal/http/response"
 )
 
 func TestJWTMiddlewareContracts(t *testing.T) {
 	secret := "unit-test-secret"
-
-	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		response.WriteJSON(w, response.OK("pass"))
-	})
-
-	wrapped := middleware.JWT(middleware.AuthOptions{JWTSecret: secret})(next)
+	router, repo := setupContractRouter(t, secret)
+	call := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
 
 	t.Run("login path is excluded", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPost, "/api/v1/user/login", nil)
-		res := httptest.NewRecorder()
-		wrapped.ServeHTTP(res, req)
-		assertCode(t, res, 0)
+		var out response.R
+		if err := json.NewDecoder(call("/api/v1/user/login", "").Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if out.Code == 401 {
+			t.Fatalf("expected login to be reachable without a token, got 401 (%s)", out.Msg)
+		}
 	})
 
 	t.Run("missing token returns 401 contract message", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPost, "/api/v1/tunnel/list", nil)
-		res := httptest.NewRecorder()
-		wrapped.ServeHTTP(res, req)
-		assertCodeMsg(t, res, 401, "未登录或token已过期")
+		assertCodeMsg(t, call("/api/v1/tunnel/list", ""), 401, "未登录或token已过期")
 	})
 
 	t.Run("invalid token returns 401 contract message", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPost, "/api/v1/tunnel/list", nil)
-		req.Header.Set("Authorization", "invalid.token.value")
-		res := httptest.NewRecorder()
-		wrapped.ServeHTTP(res, req)
-		assertCodeMsg(t, res, 401, "无效的token或token已过期")
+		assertCodeMsg(t, call("/api/v1/tunnel/list", "invalid.token.value"), 401, "无效的token或token已过期")
 	})
 
-	t.Run("valid token reaches next", func(t *testing.T) {
-		token, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	t.Run("valid token reaches the handler", func(t *testing.T) {
+		token, err := contractToken(repo, 1, "admin_user", 0, secret)
 		if err != nil {
 			t.Fatalf("generate token: %v", err)
 		}
-		req := httptest.NewRequest(http.MethodPost, "/api/v1/tunnel/list", nil)
-		req.Header.Set("Authorization", token)
-		res := httptest.NewRecorder()
-		wrapped.ServeHTTP(res, req)
-		assertCode(t, res, 0)
+		assertCode(t, call("/api/v1/tunnel/list", token), 0)
 	})
 
-	t.Run("non-admin blocked on admin path", func(t *testing.T) {
-		token, err := auth.GenerateToken(2, "normal_user", 1, secret)
+	t.Run("non-admin blocked on admin route", func(t *testing.T) {
+		insertContractUser(t, repo, 2, "normal_user", 1)
+		token, err := contractToken(repo, 2, "normal_user", 1, secret)
 		if err != nil {
 			t.Fatalf("generate token: %v", err)
 		}
-		req := httptest.NewRequest(http.MethodPost, "/api/v1/config/update", nil)
+		assertCodeMsg(t, call("/api/v1/config/update", token), 403, "权限不足，仅管理员可操作")
+	})
+}
+
+func TestJWTAudienceContracts(t *testing.T) {
+	secret := "audience-test-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	call := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/tunnel/list", nil)
 		req.Header.Set("Authorization", token)
 		res := httptest.NewRecorder()
-		wrapped.ServeHTTP(res, req)
-		assertCodeMsg(t, res, 403, "权限不足，仅管理员可操作")
+		router.ServeHTTP(res, req)
+		return res
+	}
+	issue := func(audience string) string {
+		token, err := contractTokenForAudience(repo, 1, "admin_user", 0, 0, audience, secret)
+		if err != nil {
+			t.Fatalf("generate token: %v", err)
+		}
+		return token
+	}
+
+	t.Run("token for the configured audience passes", func(t *testing.T) {
+		assertCode(t, call(issue(auth.DefaultAudience)), 0)
+	})
+
+	t.Run("token for another audience is rejected", func(t *testing.T) {
+		assertCodeMsg(t, call(issue("other-service")), 401, "无效的token或token已过期")
+	})
+
+	t.Run("legacy token passes while compat is on", func(t *testing.T) {
+		assertCode(t, call(issue("")), 0)
+	})
+
+	t.Run("legacy token is rejected once compat is off", func(t *testing.T) {
+		if err := repo.UpsertConfig("jwt_legacy_aud_compat", "false", time.Now().UnixMilli()); err != nil {
+			t.Fatalf("disable legacy compat: %v", err)
+		}
+		// A fresh router picks the new value up without waiting for its cache.
+		router = httpserver.NewRouter(handler.New(repo, secret))
+		assertCodeMsg(t, call(issue("")), 401, "无效的token或token已过期")
+		assertCode(t, call(issue(auth.DefaultAudience)), 0)
 	})
 }
 
@@ -0,0 +1,63 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptchaProviderContract(t *testing.T) {
+	router, repo := setupContractRouter(t, "contract-jwt-secret")
+	now := time.Now().UnixMilli()
+	for name, value := range map[string]string{"captcha_enabled": "true", "captcha_provider": "image"} {
+		if err := repo.UpsertConfig(name, value, now); err != nil {
+			t.Fatalf("set %s: %v", name, err)
+		}
+	}
+	login := func(captchaID string) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"username": "admin_user", "password": "admin_user", "captchaId": captchaID})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/user/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/captcha/generate", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var out struct {
+		Code int `json:"code"`
+		Data struct {
+			ID    string `json:"id"`
+			Image string `json:"image"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode generate response: %v", err)
+	}
+	if out.Code != 0 || out.Data.ID == "" || !strings.HasPrefix(out.Data.Image, "data:image/png;base64,") {
+		t.Fatalf("unexpected generate response: %+v", out)
+	}
+
+	assertCodeMsg(t, login(out.Data.ID+":wrong"), -1, "验证码校验失败")
+	// The wrong answer used the challenge up.
+	assertCodeMsg(t, login(out.Data.ID+":00000"), -1, "验证码校验失败")
+
+	t.Run("hcaptcha without a secret refuses logins", func(t *testing.T) {
+		if err := repo.UpsertConfig("captcha_provider", "hcaptcha", time.Now().UnixMilli()); err != nil {
+			t.Fatalf("set provider: %v", err)
+		}
+		assertCodeMsg(t, login("some-hcaptcha-response"), -1, "验证码校验失败")
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/captcha/generate", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assertCodeMsg(t, rec, -1, "未启用图形验证码")
+	})
+}
@@ -0,0 +1,146 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"go-backend/internal/http/response"
+)
+
+func TestTunnelChainHopStatsContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	const tunnelID = 42
+	hops := []struct {
+		name      string
+		ip        string
+		chainType int
+		latencyMs float64
+		bytesIn   int64
+		bytesOut  int64
+	}{
+		{"hop-exit", "10.0.9.3", 3, 3.5, 3000, 3100},
+		{"hop-entry", "10.0.9.1", 1, 12.25, 1000, 1100},
+		{"hop-relay", "10.0.9.2", 2, 40, 2000, 2100},
+	}
+
+	u, _ := url.Parse(server.URL)
+	u.Scheme = "ws"
+	u.Path = "/system-info"
+	nodeIDs := make(map[int]int64, len(hops))
+	for _, hop := range hops {
+		nodeSecret := hop.name + "-secret"
+		nodeID := insertContractNode(t, repo, hop.name, hop.ip, "21000-21010", nodeSecret, 0)
+		nodeIDs[hop.chainType] = nodeID
+
+		q := url.Values{}
+		q.Set("type", "1")
+		q.Set("secret", nodeSecret)
+		q.Set("version", "v1")
+		u.RawQuery = q.Encode()
+		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		if err != nil {
+			t.Fatalf("dial websocket: %v", err)
+		}
+		defer conn.Close()
+		waitNodeStatus(t, repo, nodeID, 1)
+
+		report := map[string]interface{}{
+			"type": "HopStats",
+			"hops": []map[string]interface{}{
+				// An earlier report: its latency is replaced, its bytes add up.
+				{"tunnelId": tunnelID, "chainType": hop.chainType, "avgLatencyMs": 999, "bytesIn": 1, "bytesOut": 1},
+				{"tunnelId": tunnelID + 1, "chainType": hop.chainType, "avgLatencyMs": 1, "bytesIn": 1, "bytesOut": 1},
+			},
+		}
+		payload, _ := json.Marshal(report)
+		if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+			t.Fatalf("write hop stats: %v", err)
+		}
+		report["hops"] = []map[string]interface{}{
+			{"tunnelId": tunnelID, "chainType": hop.chainType, "avgLatencyMs": hop.latencyMs, "bytesIn": hop.bytesIn, "bytesOut": hop.bytesOut},
+		}
+		payload, _ = json.Marshal(report)
+		if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+			t.Fatalf("write hop stats: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stats, err := repo.ListChainHopStats(tunnelID)
+		if err != nil {
+			t.Fatalf("list hop stats: %v", err)
+		}
+		settled := len(stats) == len(hops)
+		for _, s := range stats {
+			if s.AvgLatencyMs == 999 {
+				settled = false
+			}
+		}
+		if settled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d settled hop reports, got %+v", len(hops), stats)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	body, _ := json.Marshal(map[string]interface{}{"tunnelId": tunnelID})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/tunnel/hop-stats", bytes.NewReader(body))
+	req.Header.Set("Authorization", adminToken)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var out response.R
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Code != 0 {
+		t.Fatalf("hop stats: code %d (%s)", out.Code, out.Msg)
+	}
+	data, _ := out.Data.(map[string]interface{})
+	items, _ := data["hops"].([]interface{})
+	if len(items) != len(hops) {
+		t.Fatalf("expected %d hops, got %d", len(hops), len(items))
+	}
+	for i, item := range items {
+		m := item.(map[string]interface{})
+		chainType := i + 1
+		if valueAsInt(m["chainType"]) != chainType {
+			t.Fatalf("expected hop %d to have chainType %d, got %v", i, chainType, m["chainType"])
+		}
+		if int64(valueAsInt(m["nodeId"])) != nodeIDs[chainType] {
+			t.Fatalf("expected hop %d on node %d, got %v", i, nodeIDs[chainType], m["nodeId"])
+		}
+		for _, hop := range hops {
+			if hop.chainType != chainType {
+				continue
+			}
+			if m["nodeName"] != hop.name || m["avgLatencyMs"] != hop.latencyMs ||
+				int64(valueAsInt(m["bytesIn"])) != hop.bytesIn+1 || int64(valueAsInt(m["bytesOut"])) != hop.bytesOut+1 {
+				t.Fatalf("unexpected stats for hop %d: %v", i, m)
+			}
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/admin/tunnel/hop-stats", bytes.NewBufferString(`{}`))
+	req.Header.Set("Authorization", adminToken)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assertCodeMsg(t, rec, -1, "隧道ID不能为空")
+}
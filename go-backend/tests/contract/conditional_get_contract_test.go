@@ -0,0 +1,71 @@
+package contract_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestListConditionalGetContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	nodeID := insertContractNode(t, repo, "etag-node", "10.0.0.96", "42000-42010", "etag-node-secret", 1)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	list := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/node/list", nil)
+		req.Header.Set("Authorization", adminToken)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := list(nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", first.Code, etag)
+	}
+	assertCode(t, first, 0)
+	lastModified := first.Header().Get("Last-Modified")
+	if lastModified == "" {
+		t.Fatalf("expected a Last-Modified header")
+	}
+
+	t.Run("matching etag is not modified", func(t *testing.T) {
+		rec := list(map[string]string{"If-None-Match": etag})
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Fatalf("expected an empty 304, got %d with %d bytes", rec.Code, rec.Body.Len())
+		}
+	})
+
+	t.Run("unchanged since last modified is not modified", func(t *testing.T) {
+		rec := list(map[string]string{"If-Modified-Since": lastModified})
+		if rec.Code != http.StatusNotModified {
+			t.Fatalf("expected 304, got %d", rec.Code)
+		}
+	})
+
+	t.Run("changed node yields a new etag", func(t *testing.T) {
+		if _, err := repo.DB().Exec(`UPDATE node SET name = ?, updated_time = ? WHERE id = ?`, "etag-node-renamed", time.Now().Add(time.Hour).UnixMilli(), nodeID); err != nil {
+			t.Fatalf("update node: %v", err)
+		}
+		rec := list(map[string]string{"If-None-Match": etag})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 after the change, got %d", rec.Code)
+		}
+		if got := rec.Header().Get("ETag"); got == "" || got == etag {
+			t.Fatalf("expected a new ETag, got %q", got)
+		}
+		if rec.Header().Get("Last-Modified") == lastModified {
+			t.Fatalf("expected Last-Modified to move forward")
+		}
+		assertCode(t, rec, 0)
+	})
+}
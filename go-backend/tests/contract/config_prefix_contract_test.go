@@ -0,0 +1,112 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestConfigPrefixListAndProtectionContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	now := time.Now().UnixMilli()
+	for i := 0; i < 5; i++ {
+		if err := repo.UpsertConfig(fmt.Sprintf("ldap_key_%d", i), "v", now); err != nil {
+			t.Fatalf("insert ldap config: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := repo.UpsertConfig(fmt.Sprintf("oauth_key_%d", i), "v", now); err != nil {
+			t.Fatalf("insert oauth config: %v", err)
+		}
+	}
+	// "_" must not act as a LIKE wildcard.
+	if err := repo.UpsertConfig("ldapXkey", "v", now); err != nil {
+		t.Fatalf("insert lookalike config: %v", err)
+	}
+	if err := repo.UpsertConfig("protected_config_prefixes", "jwt_, metrics_", now); err != nil {
+		t.Fatalf("insert protected prefixes: %v", err)
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	insertContractUser(t, repo, 2, "config_user", 1)
+	delegateToken, err := contractTokenWithPermissions(repo, 2, "config_user", 1, int64(auth.PermManageConfig), secret)
+	if err != nil {
+		t.Fatalf("generate delegate token: %v", err)
+	}
+	post := func(path, token string, body interface{}) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("lists only keys with the prefix", func(t *testing.T) {
+		rec := post("/api/v1/config/list-by-prefix", adminToken, map[string]string{"prefix": "ldap_"})
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if out.Code != 0 {
+			t.Fatalf("list by prefix: code %d (%s)", out.Code, out.Msg)
+		}
+		items, _ := out.Data.(map[string]interface{})
+		if len(items) != 5 {
+			t.Fatalf("expected 5 ldap_ configs, got %v", items)
+		}
+		for i := 0; i < 5; i++ {
+			if _, ok := items[fmt.Sprintf("ldap_key_%d", i)]; !ok {
+				t.Fatalf("expected ldap_key_%d in %v", i, items)
+			}
+		}
+	})
+
+	t.Run("needs config permission and hides secrets", func(t *testing.T) {
+		if err := repo.UpsertConfig("ldap_bind_password", "directory-secret", now); err != nil {
+			t.Fatalf("insert ldap bind password: %v", err)
+		}
+		insertContractUser(t, repo, 3, "plain_user", 1)
+		userToken, err := contractToken(repo, 3, "plain_user", 1, secret)
+		if err != nil {
+			t.Fatalf("generate user token: %v", err)
+		}
+		assertCode(t, post("/api/v1/config/list-by-prefix", userToken, map[string]string{"prefix": "l"}), 403)
+
+		rec := post("/api/v1/config/list-by-prefix", delegateToken, map[string]string{"prefix": "l"})
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		items, _ := out.Data.(map[string]interface{})
+		if out.Code != 0 || len(items) == 0 {
+			t.Fatalf("list by prefix: code %d (%s) %v", out.Code, out.Msg, items)
+		}
+		if _, ok := items["ldap_bind_password"]; ok {
+			t.Fatalf("expected the bind password to be hidden, got %v", items)
+		}
+	})
+
+	t.Run("protected prefix needs the admin role", func(t *testing.T) {
+		assertCode(t, post("/api/v1/config/update", delegateToken, map[string]string{"jwt_issuer": "x"}), 403)
+		assertCode(t, post("/api/v1/config/update-single", delegateToken, map[string]string{"name": "metrics_token", "value": "x"}), 403)
+		assertCode(t, post("/api/v1/config/update", delegateToken, map[string]string{"protected_config_prefixes": ""}), 403)
+		assertCount(t, repo, `SELECT COUNT(1) FROM vite_config WHERE name IN (?, 'metrics_token')`, "jwt_issuer", 0)
+
+		assertCode(t, post("/api/v1/config/update", delegateToken, map[string]string{"ldap_key_0": "changed"}), 0)
+		assertCode(t, post("/api/v1/config/update", adminToken, map[string]string{"jwt_issuer": "x"}), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM vite_config WHERE name = ?`, "jwt_issuer", 1)
+	})
+}
@@ -0,0 +1,95 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCookieAuthContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	post := func(path string, cookies []*http.Cookie, csrf string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte(`{}`)))
+		req.Header.Set("Content-Type", "application/json")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		if csrf != "" {
+			req.Header.Set("X-CSRF-Token", csrf)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{"username": "admin_user", "password": "admin_user"})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/user/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assertCode(t, rec, 0)
+		return rec
+	}
+	cookiesByName := func(rec *httptest.ResponseRecorder) map[string]*http.Cookie {
+		out := make(map[string]*http.Cookie)
+		for _, c := range rec.Result().Cookies() {
+			out[c.Name] = c
+		}
+		return out
+	}
+
+	t.Run("cookies are not set by default", func(t *testing.T) {
+		if cookies := login().Result().Cookies(); len(cookies) != 0 {
+			t.Fatalf("expected no cookies without cookie mode, got %v", cookies)
+		}
+	})
+
+	if err := repo.UpsertConfig("auth_cookie_enabled", "true", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("enable cookie mode: %v", err)
+	}
+	cookies := cookiesByName(login())
+	access, refresh, csrf := cookies["flvx_token"], cookies["flvx_refresh"], cookies["flvx_csrf"]
+	if access == nil || refresh == nil || csrf == nil {
+		t.Fatalf("expected token, refresh and csrf cookies, got %v", cookies)
+	}
+	if !access.HttpOnly || !refresh.HttpOnly || csrf.HttpOnly {
+		t.Fatalf("expected only the csrf cookie to be readable by scripts")
+	}
+
+	t.Run("the token cookie authenticates with a matching csrf header", func(t *testing.T) {
+		assertCode(t, post("/api/v1/user/package", []*http.Cookie{access, csrf}, csrf.Value), 0)
+	})
+
+	t.Run("cookie requests without the csrf header are refused", func(t *testing.T) {
+		rec := post("/api/v1/user/package", []*http.Cookie{access, csrf}, "")
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d: %s", rec.Code, rec.Body.String())
+		}
+		rec = post("/api/v1/user/package", []*http.Cookie{access, csrf}, "forged")
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected 403 for a mismatched token, got %d", rec.Code)
+		}
+	})
+
+	t.Run("refresh reads the refresh cookie and logout clears the cookies", func(t *testing.T) {
+		rec := post("/api/v1/user/refresh", []*http.Cookie{refresh, csrf}, csrf.Value)
+		assertCode(t, rec, 0)
+		rotated := cookiesByName(rec)
+		if rotated["flvx_token"] == nil || rotated["flvx_refresh"].Value == refresh.Value {
+			t.Fatalf("expected refresh to rotate the cookies, got %v", rotated)
+		}
+
+		rec = post("/api/v1/user/logout", []*http.Cookie{rotated["flvx_token"], rotated["flvx_csrf"]}, rotated["flvx_csrf"].Value)
+		assertCode(t, rec, 0)
+		for name, c := range cookiesByName(rec) {
+			if c.MaxAge >= 0 {
+				t.Fatalf("expected logout to expire cookie %s", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package contract_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCORSContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+
+	preflight := func(router http.Handler, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/api/v1/user/package", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	upgrade := func(router http.Handler, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/system-info?type=0&secret=bad", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("any origin is allowed by default", func(t *testing.T) {
+		router, _ := setupContractRouter(t, secret)
+		rec := preflight(router, "https://elsewhere.example")
+		if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Fatalf("expected a wildcard preflight answer, got %d %v", rec.Code, rec.Header())
+		}
+		if rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Fatalf("expected no credentials with a wildcard origin")
+		}
+	})
+
+	t.Run("configured origins are echoed and others refused", func(t *testing.T) {
+		router, repo := setupContractRouter(t, secret)
+		now := time.Now().UnixMilli()
+		if err := repo.UpsertConfig("cors_allowed_origins", "https://ui.example.com, https://ops.example.com/", now); err != nil {
+			t.Fatalf("set origins: %v", err)
+		}
+		if err := repo.UpsertConfig("cors_allowed_methods", "GET, POST", now); err != nil {
+			t.Fatalf("set methods: %v", err)
+		}
+
+		rec := preflight(router, "https://ops.example.com")
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("expected allowed preflight, got %d", rec.Code)
+		}
+		h := rec.Header()
+		if h.Get("Access-Control-Allow-Origin") != "https://ops.example.com" || h.Get("Access-Control-Allow-Credentials") != "true" {
+			t.Fatalf("expected the origin to be echoed with credentials, got %v", h)
+		}
+		if h.Get("Access-Control-Allow-Methods") != "GET, POST" || h.Get("Access-Control-Allow-Headers") != "authorization, content-type" {
+			t.Fatalf("unexpected preflight answer %v", h)
+		}
+
+		if rec := preflight(router, "https://evil.example"); rec.Code != http.StatusForbidden {
+			t.Fatalf("expected foreign preflight to be refused, got %d", rec.Code)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/config/get", nil)
+		req.Header.Set("Origin", "https://evil.example")
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Fatalf("expected no CORS headers for a foreign origin")
+		}
+
+		if rec := upgrade(router, "https://evil.example"); rec.Code != http.StatusForbidden {
+			t.Fatalf("expected a foreign websocket upgrade to be refused, got %d", rec.Code)
+		}
+		// The bad secret is then refused by the websocket handler itself.
+		if rec := upgrade(router, "https://ui.example.com"); strings.Contains(rec.Body.String(), "跨域") {
+			t.Fatalf("expected an allowed origin to reach the websocket handler, got %s", rec.Body.String())
+		}
+	})
+}
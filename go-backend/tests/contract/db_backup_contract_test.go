@@ -0,0 +1,77 @@
+package contract_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/sqlite"
+)
+
+func TestAdminDBBackupContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	backup := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/db/backup", bytes.NewBufferString(`{}`))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		assertCodeMsg(t, backup(), -1, "数据库备份下载未开启")
+	})
+
+	now := time.Now().UnixMilli()
+	for i := 0; i < 100; i++ {
+		if _, err := repo.DB().Exec(`INSERT INTO vite_config(name, value, time) VALUES(?, ?, ?)`, fmt.Sprintf("backup_row_%d", i), strconv.Itoa(i), now); err != nil {
+			t.Fatalf("insert row %d: %v", i, err)
+		}
+	}
+	if err := repo.UpsertConfig("backup_download_enabled", "true", now); err != nil {
+		t.Fatalf("enable backup download: %v", err)
+	}
+	var sourceCount int
+	if err := repo.DB().QueryRow(`SELECT COUNT(1) FROM vite_config`).Scan(&sourceCount); err != nil {
+		t.Fatalf("count source rows: %v", err)
+	}
+
+	rec := backup()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/octet-stream" {
+		t.Fatalf("expected binary download, got %q: %s", ct, rec.Body.String())
+	}
+	if ts, err := strconv.ParseInt(rec.Header().Get("X-Backup-Timestamp"), 10, 64); err != nil || ts <= 0 {
+		t.Fatalf("expected X-Backup-Timestamp header, got %q", rec.Header().Get("X-Backup-Timestamp"))
+	}
+
+	path := filepath.Join(t.TempDir(), "restored.db")
+	if err := os.WriteFile(path, rec.Body.Bytes(), 0o600); err != nil {
+		t.Fatalf("write backup file: %v", err)
+	}
+	restored, err := sqlite.Open(path)
+	if err != nil {
+		t.Fatalf("open backup as repository: %v", err)
+	}
+	defer restored.Close()
+
+	var restoredCount int
+	if err := restored.DB().QueryRow(`SELECT COUNT(1) FROM vite_config`).Scan(&restoredCount); err != nil {
+		t.Fatalf("count restored rows: %v", err)
+	}
+	if restoredCount != sourceCount {
+		t.Fatalf("expected %d rows in backup, got %d", sourceCount, restoredCount)
+	}
+	assertCount(t, restored, `SELECT COUNT(1) FROM vite_config WHERE name LIKE ?`, "backup_row_%", 100)
+}
@@ -0,0 +1,42 @@
+package contract_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUnknownRequestFieldsContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	nodeID := insertContractNode(t, repo, "decode-node", "10.0.0.95", "41000-41010", "decode-node-secret", 1)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("user endpoints ignore unknown fields", func(t *testing.T) {
+		assertCode(t, post("/api/v1/user/login", "", `{"username":"admin_user","password":"admin_user","futureField":"x"}`), 0)
+		assertCode(t, post("/api/v1/user/notification-pref/update", adminToken, `{"expiryWarningEnabled":true,"futureField":"x"}`), 0)
+		assertCode(t, post("/api/v1/user/apikey/create", adminToken, `{"name":"ci","futureField":"x"}`), 0)
+		assertCodeMsg(t, post("/api/v1/user/reset-password", adminToken, `{"id":1,"password":"","futureField":"x"}`), -1, "密码不能为空")
+	})
+
+	t.Run("admin endpoints reject unknown fields", func(t *testing.T) {
+		body := fmt.Sprintf(`{"name":"strict-share","nodeId":%d,"portRangeStart":41000,"portRangeEnd":41005,"futureField":"x"}`, nodeID)
+		assertCodeMsg(t, post("/api/v1/federation/share/create", adminToken, body), -1, "Invalid JSON")
+		assertCount(t, repo, `SELECT COUNT(1) FROM peer_share WHERE name = ?`, "strict-share", 0)
+	})
+}
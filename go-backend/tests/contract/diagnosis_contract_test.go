@@ -3,6 +3,7 @@ package contract
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
@@ -93,11 +94,11 @@ func TestDiagnosisChainCoverageContracts(t *testing.T) {
 		t.Fatalf("get forward id: %v", err)
 	}
 
-	userToken, err := auth.GenerateToken(2, "normal_user", 1, secret)
+	userToken, err := diagnosisContractToken(repo, 2, "normal_user", 1, secret)
 	if err != nil {
 		t.Fatalf("generate user token: %v", err)
 	}
-	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	adminToken, err := diagnosisContractToken(repo, 1, "admin_user", 0, secret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
@@ -320,7 +321,7 @@ func TestDiagnosisUsesFederationRuntimeForRemoteNodes(t *testing.T) {
 		t.Fatalf("insert exit chain: %v", err)
 	}
 
-	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	adminToken, err := diagnosisContractToken(repo, 1, "admin_user", 0, secret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
@@ -421,5 +422,17 @@ func setupDiagnosisContractRouter(t *testing.T, jwtSecret string) (http.Handler,
 	})
 
 	h := handler.New(repo, jwtSecret)
-	return httpserver.NewRouter(h, jwtSecret), repo
+	return httpserver.NewRouter(h), repo
+}
+
+// diagnosisContractToken issues a login token for userID under a new
+// session, as /user/login does.
+func diagnosisContractToken(repo *sqlite.Repository, userID int64, username string, roleID int, secret string) (string, error) {
+	now := time.Now()
+	hash := fmt.Sprintf("diagnosis-session-%d-%d", userID, now.UnixNano())
+	sid, err := repo.CreateRefreshToken(userID, hash, now.Add(auth.RefreshTokenTTL).UnixMilli(), now.UnixMilli(), sqlite.SessionClient{})
+	if err != nil {
+		return "", err
+	}
+	return auth.GenerateAccessToken(userID, username, roleID, 0, auth.DefaultAudience, sid, secret)
 }
@@ -14,7 +14,6 @@ import (
 
 	"github.com/gorilla/websocket"
 
-	"go-backend/internal/auth"
 	"go-backend/internal/http/response"
 	"go-backend/internal/security"
 	"go-backend/internal/store/sqlite"
@@ -29,7 +28,7 @@ func TestFederationDualPanelMiddleExitAutoPortContract(t *testing.T) {
 	consumerSecret := "consumer-contract-jwt"
 	consumerRouter, consumerRepo := setupContractRouter(t, consumerSecret)
 
-	consumerAdminToken, err := auth.GenerateToken(1, "consumer-admin", 0, consumerSecret)
+	consumerAdminToken, err := contractToken(consumerRepo, 1, "consumer-admin", 0, consumerSecret)
 	if err != nil {
 		t.Fatalf("generate consumer admin token: %v", err)
 	}
@@ -181,7 +180,7 @@ func TestFederationDualPanelRemoteDiagnosisContract(t *testing.T) {
 	consumerSecret := "consumer-contract-jwt"
 	consumerRouter, consumerRepo := setupContractRouter(t, consumerSecret)
 
-	consumerAdminToken, err := auth.GenerateToken(1, "consumer-admin", 0, consumerSecret)
+	consumerAdminToken, err := contractToken(consumerRepo, 1, "consumer-admin", 0, consumerSecret)
 	if err != nil {
 		t.Fatalf("generate consumer admin token: %v", err)
 	}
@@ -325,7 +324,7 @@ func TestFederationDualPanelRemoteEntryRuntimeContract(t *testing.T) {
 	consumerSecret := "consumer-contract-jwt"
 	consumerRouter, consumerRepo := setupContractRouter(t, consumerSecret)
 
-	consumerAdminToken, err := auth.GenerateToken(1, "consumer-admin", 0, consumerSecret)
+	consumerAdminToken, err := contractToken(consumerRepo, 1, "consumer-admin", 0, consumerSecret)
 	if err != nil {
 		t.Fatalf("generate consumer admin token: %v", err)
 	}
@@ -543,6 +542,36 @@ func startMockNodeSession(t *testing.T, baseURL string, nodeSecret string) func(
 }
 
 func startMockNodeSessionWithHook(t *testing.T, baseURL string, nodeSecret string, onCommand func(cmdType string)) func() {
+	t.Helper()
+	var hook func(cmdType string, data json.RawMessage)
+	if onCommand != nil {
+		hook = func(cmdType string, _ json.RawMessage) { onCommand(cmdType) }
+	}
+	return startMockNodeSessionWithPayloadHook(t, baseURL, nodeSecret, hook)
+}
+
+func startMockNodeSessionWithPayloadHook(t *testing.T, baseURL string, nodeSecret string, onCommand func(cmdType string, data json.RawMessage)) func() {
+	t.Helper()
+	var hook func(cmdType string, data json.RawMessage) []interface{}
+	if onCommand != nil {
+		hook = func(cmdType string, data json.RawMessage) []interface{} {
+			onCommand(cmdType, data)
+			return nil
+		}
+	}
+	return startMockNodeSessionWithFollowUps(t, baseURL, nodeSecret, hook)
+}
+
+// startMockNodeSessionWithFollowUps runs a mock node that, after answering a
+// command, also sends every message returned by onCommand.
+func startMockNodeSessionWithFollowUps(t *testing.T, baseURL string, nodeSecret string, onCommand func(cmdType string, data json.RawMessage) []interface{}) func() {
+	t.Helper()
+	return startMockNodeSessionAtVersion(t, baseURL, nodeSecret, "2.2.0", onCommand)
+}
+
+// startMockNodeSessionAtVersion is startMockNodeSessionWithFollowUps for a
+// node that reports the given agent version on connect.
+func startMockNodeSessionAtVersion(t *testing.T, baseURL string, nodeSecret string, version string, onCommand func(cmdType string, data json.RawMessage) []interface{}) func() {
 	t.Helper()
 	u, err := url.Parse(baseURL)
 	if err != nil {
@@ -557,7 +586,7 @@ func startMockNodeSessionWithHook(t *testing.T, baseURL string, nodeSecret strin
 	q := u.Query()
 	q.Set("type", "1")
 	q.Set("secret", nodeSecret)
-	q.Set("version", "v1")
+	q.Set("version", version)
 	q.Set("http", "1")
 	q.Set("tls", "1")
 	q.Set("socks", "1")
@@ -572,6 +601,7 @@ func startMockNodeSessionWithHook(t *testing.T, baseURL string, nodeSecret strin
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		secrets := []string{nodeSecret}
 		for {
 			_, raw, readErr := conn.ReadMessage()
 			if readErr != nil {
@@ -584,17 +614,23 @@ func startMockNodeSessionWithHook(t *testing.T, baseURL string, nodeSecret strin
 				Data      string `json:"data"`
 			}
 			if err := json.Unmarshal(raw, &wrap); err == nil && wrap.Encrypted && strings.TrimSpace(wrap.Data) != "" {
-				crypto, cryptoErr := security.NewAESCrypto(nodeSecret)
-				if cryptoErr == nil {
+				// Like the agent, try the newest secret first.
+				for i := len(secrets) - 1; i >= 0; i-- {
+					crypto, cryptoErr := security.NewAESCrypto(secrets[i])
+					if cryptoErr != nil {
+						continue
+					}
 					if dec, decErr := crypto.Decrypt(wrap.Data); decErr == nil {
 						plain = []byte(dec)
+						break
 					}
 				}
 			}
 
 			var cmd struct {
-				Type      string `json:"type"`
-				RequestID string `json:"requestId"`
+				Type      string          `json:"type"`
+				RequestID string          `json:"requestId"`
+				Data      json.RawMessage `json:"data"`
 			}
 			if err := json.Unmarshal(plain, &cmd); err != nil {
 				continue
@@ -602,8 +638,17 @@ func startMockNodeSessionWithHook(t *testing.T, baseURL string, nodeSecret strin
 			if strings.TrimSpace(cmd.RequestID) == "" {
 				continue
 			}
+			if strings.TrimSpace(cmd.Type) == "RotateSecret" {
+				var rotate struct {
+					Secret string `json:"secret"`
+				}
+				if json.Unmarshal(cmd.Data, &rotate) == nil && rotate.Secret != "" {
+					secrets = append(secrets, rotate.Secret)
+				}
+			}
+			var followUps []interface{}
 			if onCommand != nil {
-				onCommand(strings.TrimSpace(cmd.Type))
+				followUps = onCommand(strings.TrimSpace(cmd.Type), cmd.Data)
 			}
 
 			respType := fmt.Sprintf("%sResponse", cmd.Type)
@@ -626,6 +671,11 @@ func startMockNodeSessionWithHook(t *testing.T, baseURL string, nodeSecret strin
 				continue
 			}
 			_ = conn.WriteMessage(websocket.TextMessage, respBytes)
+			for _, msg := range followUps {
+				if msgBytes, err := json.Marshal(msg); err == nil {
+					_ = conn.WriteMessage(websocket.TextMessage, msgBytes)
+				}
+			}
 		}
 	}()
 
@@ -0,0 +1,109 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestFederationRemoteUsageListsAllocationsContract(t *testing.T) {
+	providerSecret := "provider-alloc-jwt"
+	providerRouter, providerRepo := setupContractRouter(t, providerSecret)
+	providerServer := httptest.NewServer(providerRouter)
+	defer providerServer.Close()
+
+	consumerSecret := "consumer-alloc-jwt"
+	consumerRouter, consumerRepo := setupContractRouter(t, consumerSecret)
+	consumerServer := httptest.NewServer(consumerRouter)
+	defer consumerServer.Close()
+
+	consumerAdminToken, err := contractToken(consumerRepo, 1, "consumer-admin", 0, consumerSecret)
+	if err != nil {
+		t.Fatalf("generate consumer admin token: %v", err)
+	}
+	post := func(path string, payload interface{}) *httptest.ResponseRecorder {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("marshal %s payload: %v", path, err)
+		}
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", consumerAdminToken)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		consumerRouter.ServeHTTP(res, req)
+		return res
+	}
+
+	now := time.Now().UnixMilli()
+	providerExitNodeID := insertContractNode(t, providerRepo, "provider-exit-alloc", "198.51.100.41", "45140-45150", "provider-exit-alloc-secret", 1)
+	insertPeerShare(t, providerRepo, &sqlite.PeerShare{
+		Name:           "exit-share-alloc",
+		NodeID:         providerExitNodeID,
+		Token:          "share-exit-alloc-token",
+		PortRangeStart: 45140,
+		PortRangeEnd:   45150,
+		IsActive:       1,
+		CreatedTime:    now,
+		UpdatedTime:    now,
+	})
+	stopExit := startMockNodeSession(t, providerServer.URL, "provider-exit-alloc-secret")
+	defer stopExit()
+
+	assertCode(t, post("/api/v1/federation/node/import", map[string]string{
+		"remoteUrl": providerServer.URL,
+		"token":     "share-exit-alloc-token",
+	}), 0)
+	remoteNodeID := queryRemoteNodeIDByToken(t, consumerRepo, "share-exit-alloc-token")
+
+	entryNodeID := insertContractNode(t, consumerRepo, "consumer-entry-alloc", "203.0.113.41", "30140-30150", "consumer-entry-alloc-secret", 0)
+	stopEntry := startMockNodeSession(t, consumerServer.URL, "consumer-entry-alloc-secret")
+	defer stopEntry()
+	waitNodeStatus(t, consumerRepo, entryNodeID, 1)
+
+	assertCode(t, post("/api/v1/tunnel/create", map[string]interface{}{
+		"name":   "alloc-tunnel",
+		"type":   2,
+		"flow":   99999,
+		"status": 1,
+		"inNodeId": []map[string]interface{}{
+			{"nodeId": entryNodeID, "protocol": "tls", "strategy": "round"},
+		},
+		"outNodeId": []map[string]interface{}{
+			{"nodeId": remoteNodeID, "protocol": "tls", "strategy": "round"},
+		},
+	}), 0)
+	var tunnelID int64
+	var allocatedPort int
+	if err := consumerRepo.DB().QueryRow(`SELECT tunnel_id, allocated_port FROM federation_tunnel_binding WHERE node_id = ? AND status = 1`, remoteNodeID).Scan(&tunnelID, &allocatedPort); err != nil {
+		t.Fatalf("query binding: %v", err)
+	}
+	if allocatedPort < 45140 || allocatedPort > 45150 {
+		t.Fatalf("expected a port in the share range, got %d", allocatedPort)
+	}
+
+	res := post("/api/v1/federation/share/remote-usage/list", map[string]interface{}{})
+	var out struct {
+		response.R
+		Data []struct {
+			NodeID            int64                   `json:"nodeId"`
+			RemoteAllocations []sqlite.PeerAllocation `json:"remoteAllocations"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Code != 0 || len(out.Data) != 1 || out.Data[0].NodeID != remoteNodeID {
+		t.Fatalf("expected the imported node, got code %d (%s) %+v", out.Code, out.Msg, out.Data)
+	}
+	allocations := out.Data[0].RemoteAllocations
+	want := sqlite.PeerAllocation{TunnelID: tunnelID, TunnelName: "alloc-tunnel", Port: allocatedPort, Protocol: "tls", Role: "exit", Status: 1}
+	if len(allocations) != 1 || allocations[0] != want {
+		t.Fatalf("expected allocation %+v, got %+v", want, allocations)
+	}
+}
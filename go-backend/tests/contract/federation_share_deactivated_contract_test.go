@@ -0,0 +1,144 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/sqlite"
+)
+
+func TestFederationShareDeactivatedPausesConsumerContract(t *testing.T) {
+	providerSecret := "provider-contract-jwt"
+	providerRouter, providerRepo := setupContractRouter(t, providerSecret)
+	providerServer := httptest.NewServer(providerRouter)
+	defer providerServer.Close()
+
+	consumerSecret := "consumer-contract-jwt"
+	consumerRouter, consumerRepo := setupContractRouter(t, consumerSecret)
+	consumerServer := httptest.NewServer(consumerRouter)
+	defer consumerServer.Close()
+
+	providerAdminToken, err := contractToken(providerRepo, 1, "provider-admin", 0, providerSecret)
+	if err != nil {
+		t.Fatalf("generate provider admin token: %v", err)
+	}
+	consumerAdminToken, err := contractToken(consumerRepo, 1, "consumer-admin", 0, consumerSecret)
+	if err != nil {
+		t.Fatalf("generate consumer admin token: %v", err)
+	}
+	post := func(router http.Handler, token, path string, payload interface{}) *httptest.ResponseRecorder {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("marshal %s payload: %v", path, err)
+		}
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", token)
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	now := time.Now().UnixMilli()
+	providerExitNodeID := insertContractNode(t, providerRepo, "provider-exit-cb", "198.51.100.31", "45040-45050", "provider-exit-cb-secret", 1)
+	exitShareID := insertPeerShare(t, providerRepo, &sqlite.PeerShare{
+		Name:           "exit-share-cb",
+		NodeID:         providerExitNodeID,
+		Token:          "share-exit-cb-token",
+		PortRangeStart: 45040,
+		PortRangeEnd:   45050,
+		IsActive:       1,
+		CreatedTime:    now,
+		UpdatedTime:    now,
+	})
+	stopExit := startMockNodeSession(t, providerServer.URL, "provider-exit-cb-secret")
+	defer stopExit()
+
+	callbackURL := consumerServer.URL + "/api/v1/federation/share/deactivated"
+	t.Run("import rejects invalid callback URL", func(t *testing.T) {
+		res := post(consumerRouter, consumerAdminToken, "/api/v1/federation/node/import", map[string]string{
+			"remoteUrl":   providerServer.URL,
+			"token":       "share-exit-cb-token",
+			"callbackUrl": "ftp://consumer.invalid/callback",
+		})
+		assertCode(t, res, -1)
+	})
+
+	res := post(consumerRouter, consumerAdminToken, "/api/v1/federation/node/import", map[string]string{
+		"remoteUrl":   providerServer.URL,
+		"token":       "share-exit-cb-token",
+		"callbackUrl": callbackURL,
+	})
+	assertCode(t, res, 0)
+	exitRemoteNodeID := queryRemoteNodeIDByToken(t, consumerRepo, "share-exit-cb-token")
+	assertCount(t, consumerRepo, `SELECT COUNT(1) FROM node WHERE callback_url = ?`, callbackURL, 1)
+	assertCount(t, providerRepo, `SELECT COUNT(1) FROM peer_share_consumer WHERE callback_url = ?`, callbackURL, 1)
+
+	entryNodeID := insertContractNode(t, consumerRepo, "consumer-entry-cb", "203.0.113.31", "30040-30050", "consumer-entry-cb-secret", 0)
+	stopEntry := startMockNodeSession(t, consumerServer.URL, "consumer-entry-cb-secret")
+	defer stopEntry()
+	waitNodeStatus(t, consumerRepo, entryNodeID, 1)
+
+	res = post(consumerRouter, consumerAdminToken, "/api/v1/tunnel/create", map[string]interface{}{
+		"name":   "share-callback-tunnel",
+		"type":   2,
+		"flow":   99999,
+		"status": 1,
+		"inNodeId": []map[string]interface{}{
+			{"nodeId": entryNodeID, "protocol": "tls", "strategy": "round"},
+		},
+		"outNodeId": []map[string]interface{}{
+			{"nodeId": exitRemoteNodeID, "protocol": "tls", "strategy": "round"},
+		},
+	})
+	assertCode(t, res, 0)
+	var tunnelID int64
+	if err := consumerRepo.DB().QueryRow(`SELECT id FROM tunnel WHERE name = 'share-callback-tunnel'`).Scan(&tunnelID); err != nil {
+		t.Fatalf("query tunnel id: %v", err)
+	}
+	assertCount(t, consumerRepo, `SELECT COUNT(1) FROM federation_tunnel_binding WHERE tunnel_id = ? AND status = 1`, tunnelID, 1)
+
+	res = post(consumerRouter, consumerAdminToken, "/api/v1/forward/create", map[string]interface{}{
+		"name":       "share-callback-forward",
+		"tunnelId":   tunnelID,
+		"remoteAddr": "1.1.1.1:443",
+	})
+	assertCode(t, res, 0)
+	assertCount(t, consumerRepo, `SELECT COUNT(1) FROM forward WHERE tunnel_id = ? AND status = 1`, tunnelID, 1)
+
+	t.Run("rejects callback with unknown token", func(t *testing.T) {
+		body := []byte(fmt.Sprintf(`{"event":"share_deactivated","shareId":%d,"bindingIds":[]}`, exitShareID))
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/federation/share/deactivated", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer not-a-share-token")
+		rec := httptest.NewRecorder()
+		consumerRouter.ServeHTTP(rec, req)
+		assertCode(t, rec, 401)
+		assertCount(t, consumerRepo, `SELECT COUNT(1) FROM federation_tunnel_binding WHERE tunnel_id = ? AND status = 1`, tunnelID, 1)
+	})
+
+	res = post(providerRouter, providerAdminToken, "/api/v1/federation/share/delete", map[string]interface{}{"id": exitShareID})
+	assertCode(t, res, 0)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var bindingStatus, forwardStatus int
+		if err := consumerRepo.DB().QueryRow(`SELECT status FROM federation_tunnel_binding WHERE tunnel_id = ?`, tunnelID).Scan(&bindingStatus); err != nil {
+			t.Fatalf("query binding status: %v", err)
+		}
+		if err := consumerRepo.DB().QueryRow(`SELECT status FROM forward WHERE tunnel_id = ?`, tunnelID).Scan(&forwardStatus); err != nil {
+			t.Fatalf("query forward status: %v", err)
+		}
+		if bindingStatus == 0 && forwardStatus == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected consumer to pause after share delete, binding=%d forward=%d", bindingStatus, forwardStatus)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
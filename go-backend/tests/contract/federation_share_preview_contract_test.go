@@ -0,0 +1,103 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestFederationSharePreviewRejectsPortConflictContract(t *testing.T) {
+	providerRouter, providerRepo := setupContractRouter(t, "provider-contract-jwt")
+	providerServer := httptest.NewServer(providerRouter)
+	defer providerServer.Close()
+
+	consumerSecret := "consumer-contract-jwt"
+	consumerRouter, consumerRepo := setupContractRouter(t, consumerSecret)
+	consumerAdminToken, err := contractToken(consumerRepo, 1, "consumer-admin", 0, consumerSecret)
+	if err != nil {
+		t.Fatalf("generate consumer admin token: %v", err)
+	}
+
+	now := time.Now().UnixMilli()
+	providerNodeID := insertContractNode(t, providerRepo, "provider-shared", "198.51.100.21", "46000-46010", "provider-shared-secret", 1)
+	insertPeerShare(t, providerRepo, &sqlite.PeerShare{
+		Name:           "conflict-share",
+		NodeID:         providerNodeID,
+		Token:          "share-conflict-token",
+		PortRangeStart: 46000,
+		PortRangeEnd:   46010,
+		IsActive:       1,
+		CreatedTime:    now,
+		UpdatedTime:    now,
+	})
+	insertContractNode(t, consumerRepo, "local-same-host", "198.51.100.21", "46005-46020", "local-same-host-secret", 1)
+
+	postAs := func(token, path string) response.R {
+		body, _ := json.Marshal(map[string]string{"remoteUrl": providerServer.URL, "token": "share-conflict-token"})
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		consumerRouter.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+	post := func(path string) response.R {
+		return postAs(consumerAdminToken, path)
+	}
+
+	t.Run("users without federation access are refused", func(t *testing.T) {
+		insertContractUser(t, consumerRepo, 2, "consumer-user", 1)
+		userToken, err := contractToken(consumerRepo, 2, "consumer-user", 1, consumerSecret)
+		if err != nil {
+			t.Fatalf("generate user token: %v", err)
+		}
+		for _, path := range []string{"/api/v1/federation/node/preview", "/api/v1/federation/node/import"} {
+			if out := postAs(userToken, path); out.Code != 403 {
+				t.Fatalf("%s: expected 403, got code %d (%s)", path, out.Code, out.Msg)
+			}
+		}
+		assertCount(t, consumerRepo, `SELECT COUNT(1) FROM node WHERE is_remote = ?`, 1, 0)
+	})
+
+	t.Run("preview reports the conflict", func(t *testing.T) {
+		out := post("/api/v1/federation/node/preview")
+		if out.Code != 0 {
+			t.Fatalf("preview: code %d (%s)", out.Code, out.Msg)
+		}
+		data, _ := out.Data.(map[string]interface{})
+		if valueAsString(data["portRange"]) != "46000-46010" || !valueAsBool(data["hasPortConflict"]) {
+			t.Fatalf("expected conflicting 46000-46010 preview, got %v", data)
+		}
+		if _, ok := data["usedPorts"].([]interface{}); !ok {
+			t.Fatalf("expected usedPorts array, got %v", data["usedPorts"])
+		}
+	})
+
+	t.Run("import is rejected", func(t *testing.T) {
+		out := post("/api/v1/federation/node/import")
+		if out.Code == 0 || !strings.Contains(out.Msg, "local-same-host") {
+			t.Fatalf("expected import to be rejected naming the local node, got code %d (%s)", out.Code, out.Msg)
+		}
+		assertCount(t, consumerRepo, `SELECT COUNT(1) FROM node WHERE is_remote = ?`, 1, 0)
+	})
+
+	t.Run("config allows the conflict", func(t *testing.T) {
+		if err := consumerRepo.UpsertConfig("federation_allow_port_conflict", "true", now); err != nil {
+			t.Fatalf("insert federation_allow_port_conflict: %v", err)
+		}
+		if out := post("/api/v1/federation/node/import"); out.Code != 0 {
+			t.Fatalf("expected import to succeed, got code %d (%s)", out.Code, out.Msg)
+		}
+		assertCount(t, consumerRepo, `SELECT COUNT(1) FROM node WHERE is_remote = ?`, 1, 1)
+	})
+}
@@ -0,0 +1,90 @@
+package contract_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/client"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestFederationRequestSignatureContract(t *testing.T) {
+	router, repo := setupContractRouter(t, "contract-jwt-secret")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	nodeID := insertContractNode(t, repo, "signed-share-node", "10.0.0.120", "46000-46010", "signed-share-secret", 1)
+	now := time.Now().UnixMilli()
+	token := "signed-share-token"
+	insertPeerShare(t, repo, &sqlite.PeerShare{
+		Name:           "signed-share",
+		NodeID:         nodeID,
+		Token:          token,
+		PortRangeStart: 46000,
+		PortRangeEnd:   46010,
+		IsActive:       1,
+		CreatedTime:    now,
+		UpdatedTime:    now,
+	})
+
+	const path = "/api/v1/federation/share/status"
+	call := func(headers map[string]string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	signed := func(ts int64, nonce string, body []byte) map[string]string {
+		timestamp := strconv.FormatInt(ts, 10)
+		return map[string]string{
+			client.PeerTimestampHeader: timestamp,
+			client.PeerNonceHeader:     nonce,
+			client.PeerSignatureHeader: client.PeerSignature(token, http.MethodPost, path, timestamp, nonce, body),
+		}
+	}
+
+	// Older peers do not sign and are still let in by default.
+	assertCode(t, call(nil, nil), 0)
+
+	fresh := signed(time.Now().Unix(), "nonce-1", []byte(`{}`))
+	assertCode(t, call(fresh, []byte(`{}`)), 0)
+	assertCodeMsg(t, call(fresh, []byte(`{}`)), 401, "Invalid signature")
+
+	tampered := signed(time.Now().Unix(), "nonce-2", []byte(`{}`))
+	assertCodeMsg(t, call(tampered, []byte(`{"x":1}`)), 401, "Invalid signature")
+
+	stale := signed(time.Now().Add(-10*time.Minute).Unix(), "nonce-3", nil)
+	assertCodeMsg(t, call(stale, nil), 401, "Invalid signature")
+
+	forged := signed(time.Now().Unix(), "nonce-4", nil)
+	forged[client.PeerSignatureHeader] = client.PeerSignature("another-token", http.MethodPost, path, forged[client.PeerTimestampHeader], "nonce-4", nil)
+	assertCodeMsg(t, call(forged, nil), 401, "Invalid signature")
+
+	if err := repo.UpsertConfig("federation_signature_required", "true", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("require signatures: %v", err)
+	}
+	assertCodeMsg(t, call(nil, nil), 401, "Invalid signature")
+
+	// The federation client signs every call, including through a path
+	// prefix added by a reverse proxy.
+	status, err := client.NewFederationClient().ShareStatus(server.URL, token, "")
+	if err != nil {
+		t.Fatalf("signed share status: %v", err)
+	}
+	if status.ShareName != "signed-share" {
+		t.Fatalf("unexpected share status %+v", status)
+	}
+	proxy := httptest.NewServer(http.StripPrefix("/panel", router))
+	defer proxy.Close()
+	if _, err := client.NewFederationClient().ShareStatus(proxy.URL+"/panel", token, ""); err != nil {
+		t.Fatalf("signed share status through a path prefix: %v", err)
+	}
+}
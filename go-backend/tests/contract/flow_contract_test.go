@@ -1,12 +1,17 @@
 package contract_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"go-backend/internal/http/handler"
+	"go-backend/internal/security"
+	"go-backend/internal/store/sqlite"
 )
 
 func TestFlowEndpointsStringResponses(t *testing.T) {
@@ -21,7 +26,6 @@ func TestFlowEndpointsStringResponses(t *testing.T) {
 		expected string
 	}{
 		{name: "flow test", method: http.MethodGet, path: "/flow/test", expected: "test"},
-		{name: "flow config", method: http.MethodPost, path: "/flow/config?secret=abc", expected: "ok"},
 		{name: "flow upload", method: http.MethodPost, path: "/flow/upload?secret=abc", expected: "ok"},
 	}
 
@@ -42,3 +46,68 @@ func TestFlowEndpointsStringResponses(t *testing.T) {
 		})
 	}
 }
+
+func TestFlowConfigReturnsEncryptedNodeConfig(t *testing.T) {
+	router, repo := setupContractRouter(t, "contract-jwt-secret")
+	now := time.Now().UnixMilli()
+
+	nodeID := insertContractNode(t, repo, "config-node", "10.0.0.95", "6000-6010", "config-node-secret", 1)
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('config-tunnel', 1.0, 2, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 3, ?, 6001, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+
+	t.Run("unknown secret is forbidden", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/flow/config?secret=unknown", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d", rec.Code)
+		}
+		assertCode(t, rec, 403)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/flow/config?secret=config-node-secret", bytes.NewBufferString(`{}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var envelope struct {
+		Encrypted bool   `json:"encrypted"`
+		Data      string `json:"data"`
+		Timestamp int64  `json:"timestamp"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decode envelope: %v", err)
+	}
+	if !envelope.Encrypted || envelope.Data == "" || envelope.Timestamp <= 0 {
+		t.Fatalf("expected encrypted envelope, got %+v", envelope)
+	}
+	crypto, err := security.NewAESCrypto("config-node-secret")
+	if err != nil {
+		t.Fatalf("new crypto: %v", err)
+	}
+	plain, err := crypto.Decrypt(envelope.Data)
+	if err != nil {
+		t.Fatalf("decrypt config: %v", err)
+	}
+	var cfg sqlite.NodeConfig
+	if err := json.Unmarshal(plain, &cfg); err != nil {
+		t.Fatalf("decode node config: %v", err)
+	}
+	if cfg.NodeID != nodeID || len(cfg.Chains) != 1 || cfg.Services == nil {
+		t.Fatalf("unexpected node config: %+v", cfg)
+	}
+	chain := cfg.Chains[0]
+	if chain.TunnelID != tunnelID || chain.TunnelName != "config-tunnel" || chain.ChainType != "3" || chain.Port != 6001 || chain.Protocol != "tls" {
+		t.Fatalf("unexpected chain config: %+v", chain)
+	}
+}
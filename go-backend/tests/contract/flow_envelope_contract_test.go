@@ -0,0 +1,153 @@
+package contract_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-backend/internal/security"
+)
+
+func TestFlowUploadEnvelopeContract(t *testing.T) {
+	router, repo := setupContractRouter(t, "contract-jwt-secret")
+	insertContractNode(t, repo, "envelope-node", "10.0.0.96", "7000-7010", "envelope-node-secret", 1)
+
+	crypto, err := security.NewAESCrypto("envelope-node-secret")
+	if err != nil {
+		t.Fatalf("new crypto: %v", err)
+	}
+	upload := func(body []byte) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/flow/upload?secret=envelope-node-secret", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	v2 := func(ts int64, sealedTs int64) []byte {
+		t.Helper()
+		data, err := crypto.EncryptWithAAD([]byte(`[]`), []byte("flvx-flow:v2:"+strconv.FormatInt(sealedTs, 10)))
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		raw, _ := json.Marshal(map[string]interface{}{"encrypted": true, "v": 2, "data": data, "timestamp": ts})
+		return raw
+	}
+	v1 := func() []byte {
+		t.Helper()
+		data, err := crypto.Encrypt([]byte(`[]`))
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		raw, _ := json.Marshal(map[string]interface{}{"encrypted": true, "data": data, "timestamp": time.Now().Unix()})
+		return raw
+	}
+
+	now := time.Now().Unix()
+	fresh := v2(now, now)
+	if code := upload(fresh); code != http.StatusOK {
+		t.Fatalf("expected a fresh v2 report to be accepted, got %d", code)
+	}
+	if code := upload(fresh); code != http.StatusForbidden {
+		t.Fatalf("expected a replayed report to be rejected, got %d", code)
+	}
+	if code := upload(v2(now+1, now)); code != http.StatusForbidden {
+		t.Fatalf("expected a report with a rewritten timestamp to be rejected, got %d", code)
+	}
+	stale := now - int64((10 * time.Minute).Seconds())
+	if code := upload(v2(stale, stale)); code != http.StatusForbidden {
+		t.Fatalf("expected a stale report to be rejected, got %d", code)
+	}
+	if code := upload([]byte(`{"encrypted":true,"data":"bm90IGNpcGhlcnRleHQ="}`)); code != http.StatusForbidden {
+		t.Fatalf("expected an undecryptable report to be rejected, got %d", code)
+	}
+
+	// Older nodes still get through unless strict mode is on.
+	if code := upload(v1()); code != http.StatusOK {
+		t.Fatalf("expected a v1 report to be accepted, got %d", code)
+	}
+	if code := upload([]byte(`[]`)); code != http.StatusOK {
+		t.Fatalf("expected a plaintext report to be accepted, got %d", code)
+	}
+
+	if err := repo.UpsertConfig("node_payload_strict", "true", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("enable strict mode: %v", err)
+	}
+	if code := upload(v1()); code != http.StatusForbidden {
+		t.Fatalf("expected strict mode to reject a v1 report, got %d", code)
+	}
+	if code := upload([]byte(`[]`)); code != http.StatusForbidden {
+		t.Fatalf("expected strict mode to reject a plaintext report, got %d", code)
+	}
+	now = time.Now().Unix()
+	if code := upload(v2(now, now)); code != http.StatusOK {
+		t.Fatalf("expected strict mode to accept a v2 report, got %d", code)
+	}
+}
+
+func TestFlowUploadSignatureContract(t *testing.T) {
+	router, repo := setupContractRouter(t, "contract-jwt-secret")
+	insertContractNode(t, repo, "signed-node", "10.0.0.97", "7000-7010", "signed-node-secret", 1)
+
+	upload := func(body []byte, headers map[string]string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/flow/upload?secret=signed-node-secret", bytes.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	sign := func(key string, ts int64, nonce string, body []byte) map[string]string {
+		timestamp := strconv.FormatInt(ts, 10)
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(timestamp + "\n" + nonce + "\n"))
+		mac.Write(body)
+		return map[string]string{
+			"X-Flvx-Timestamp": timestamp,
+			"X-Flvx-Nonce":     nonce,
+			"X-Flvx-Signature": hex.EncodeToString(mac.Sum(nil)),
+		}
+	}
+
+	body := []byte(`[]`)
+	now := time.Now().Unix()
+	signed := sign("signed-node-secret", now, "nonce-1", body)
+	if code := upload(body, signed); code != http.StatusOK {
+		t.Fatalf("expected a signed report to be accepted, got %d", code)
+	}
+	if code := upload(body, signed); code != http.StatusForbidden {
+		t.Fatalf("expected a replayed nonce to be rejected, got %d", code)
+	}
+	if code := upload([]byte(`[{"n":"1_1_1","u":1,"d":1}]`), sign("signed-node-secret", now, "nonce-2", body)); code != http.StatusForbidden {
+		t.Fatalf("expected a tampered body to be rejected, got %d", code)
+	}
+	if code := upload(body, sign("other-secret", now, "nonce-3", body)); code != http.StatusForbidden {
+		t.Fatalf("expected a signature under another key to be rejected, got %d", code)
+	}
+	stale := now - int64((10 * time.Minute).Seconds())
+	if code := upload(body, sign("signed-node-secret", stale, "nonce-4", body)); code != http.StatusForbidden {
+		t.Fatalf("expected a stale signature to be rejected, got %d", code)
+	}
+
+	// Unsigned reports from older nodes pass until signatures are required.
+	if code := upload(body, nil); code != http.StatusOK {
+		t.Fatalf("expected an unsigned report to be accepted, got %d", code)
+	}
+	if err := repo.UpsertConfig("node_upload_signature_required", "true", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("require signatures: %v", err)
+	}
+	if code := upload(body, nil); code != http.StatusForbidden {
+		t.Fatalf("expected an unsigned report to be rejected, got %d", code)
+	}
+	if code := upload(body, sign("signed-node-secret", time.Now().Unix(), "nonce-5", body)); code != http.StatusOK {
+		t.Fatalf("expected a signed report to be accepted when required, got %d", code)
+	}
+}
@@ -0,0 +1,138 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAdminExportUserFlowContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	now := time.Now().UnixMilli()
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('export-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+
+	forwardIDs := make([]int64, 0, 2)
+	for _, name := range []string{"export-a", "export-b"} {
+		res, err := repo.DB().Exec(`
+			INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+			VALUES(1, 'admin_user', ?, ?, '1.1.1.1:443', 'fifo', 0, 0, ?, ?, 1, 0)
+		`, name, tunnelID, now, now)
+		if err != nil {
+			t.Fatalf("insert forward: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		forwardIDs = append(forwardIDs, id)
+	}
+
+	seed := []struct {
+		forwardID int64
+		userID    int64
+		date      string
+		in, out   int64
+	}{
+		{forwardIDs[0], 1, "2024-01-01", 100, 200},
+		{forwardIDs[1], 1, "2024-01-01", 10, 20},
+		{forwardIDs[0], 1, "2024-01-15", 1000, 3000},
+		{forwardIDs[0], 1, "2024-02-01", 5, 5},
+		{forwardIDs[0], 2, "2024-01-10", 7, 7},
+	}
+	for _, row := range seed {
+		if _, err := repo.DB().Exec(`
+			INSERT INTO flow_log(forward_id, user_id, flow_date, in_flow, out_flow, created_time, updated_time)
+			VALUES(?, ?, ?, ?, ?, ?, ?)
+		`, row.forwardID, row.userID, row.date, row.in, row.out, now, now); err != nil {
+			t.Fatalf("insert flow_log: %v", err)
+		}
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	export := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/export/user-flow", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("exports rows within range as csv", func(t *testing.T) {
+		rec := export(`{"userId":1,"from":"2024-01-01","to":"2024-01-31"}`)
+		if ct := rec.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+			t.Fatalf("unexpected content type %q (body %s)", ct, rec.Body.String())
+		}
+		if cd := rec.Header().Get("Content-Disposition"); cd != "attachment; filename=flow_1_2024-01-01_2024-01-31.csv" {
+			t.Fatalf("unexpected content disposition %q", cd)
+		}
+
+		records, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("parse csv: %v", err)
+		}
+		want := [][]string{
+			{"date", "tunnel_name", "forward_name", "in_bytes", "out_bytes", "total_bytes"},
+			{"2024-01-01", "export-tunnel", "export-a", "100", "200", "300"},
+			{"2024-01-01", "export-tunnel", "export-b", "10", "20", "30"},
+			{"2024-01-15", "export-tunnel", "export-a", "1000", "3000", "4000"},
+		}
+		if !reflect.DeepEqual(records, want) {
+			t.Fatalf("expected %v, got %v", want, records)
+		}
+	})
+
+	t.Run("reported flow is aggregated per day", func(t *testing.T) {
+		if err := repo.AddFlow(forwardIDs[1], 1, 0, 40, 60); err != nil {
+			t.Fatalf("add flow: %v", err)
+		}
+		if err := repo.AddFlow(forwardIDs[1], 1, 0, 1, 2); err != nil {
+			t.Fatalf("add flow: %v", err)
+		}
+		today := time.Now().Format("2006-01-02")
+		rec := export(fmt.Sprintf(`{"userId":1,"from":"%s","to":"%s"}`, today, today))
+		records, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("parse csv: %v", err)
+		}
+		want := [][]string{
+			{"date", "tunnel_name", "forward_name", "in_bytes", "out_bytes", "total_bytes"},
+			{today, "export-tunnel", "export-b", "41", "62", "103"},
+		}
+		if !reflect.DeepEqual(records, want) {
+			t.Fatalf("expected %v, got %v", want, records)
+		}
+	})
+
+	t.Run("empty range still gets the csv header", func(t *testing.T) {
+		rec := export(`{"userId":1,"from":"2023-01-01","to":"2023-01-31"}`)
+		if ct := rec.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+			t.Fatalf("unexpected content type %q (body %s)", ct, rec.Body.String())
+		}
+		records, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("parse csv: %v", err)
+		}
+		if len(records) != 1 || records[0][0] != "date" {
+			t.Fatalf("expected only the header, got %v", records)
+		}
+	})
+
+	t.Run("rejects invalid date range", func(t *testing.T) {
+		assertCode(t, export(`{"userId":1,"from":"2024-02-01","to":"2024-01-01"}`), -1)
+		assertCode(t, export(`{"userId":1,"from":"2024/01/01","to":"2024-01-31"}`), -1)
+	})
+}
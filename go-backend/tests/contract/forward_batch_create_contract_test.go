@@ -0,0 +1,183 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestAdminForwardBatchCreateContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	now := time.Now().UnixMilli()
+	nodeA := insertContractNode(t, repo, "batch-node-a", "10.0.0.61", "4000-4010", "batch-node-a-secret", 0)
+	nodeB := insertContractNode(t, repo, "batch-node-b", "10.0.0.62", "4000-4010", "batch-node-b-secret", 0)
+	tunnelIDs := make([]int64, 0, 2)
+	for i, nodeID := range []int64{nodeA, nodeB} {
+		res, err := repo.DB().Exec(`
+			INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+			VALUES(?, 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, ?)
+		`, fmt.Sprintf("batch-tunnel-%d", i), now, now, i)
+		if err != nil {
+			t.Fatalf("insert tunnel: %v", err)
+		}
+		tunnelID, _ := res.LastInsertId()
+		if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+			t.Fatalf("insert chain_tunnel: %v", err)
+		}
+		tunnelIDs = append(tunnelIDs, tunnelID)
+	}
+
+	var mu sync.Mutex
+	addService := map[string]int{}
+	for _, nodeSecret := range []string{"batch-node-a-secret", "batch-node-b-secret"} {
+		nodeSecret := nodeSecret
+		stop := startMockNodeSessionWithHook(t, server.URL, nodeSecret, func(cmdType string) {
+			if cmdType == "AddService" {
+				mu.Lock()
+				addService[nodeSecret]++
+				mu.Unlock()
+			}
+		})
+		defer stop()
+	}
+	waitNodeStatus(t, repo, nodeA, 1)
+	waitNodeStatus(t, repo, nodeB, 1)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	postTo := func(path string, body interface{}) response.R {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+	post := func(body interface{}) response.R {
+		return postTo("/api/v1/admin/forward/batch-create", body)
+	}
+
+	t.Run("invalid item rolls back whole batch", func(t *testing.T) {
+		out := post(map[string]interface{}{"forwards": []map[string]interface{}{
+			{"name": "ok-forward", "tunnelId": tunnelIDs[0], "remoteAddr": "1.1.1.1:443", "inPort": 4001},
+			{"name": "dup-port", "tunnelId": tunnelIDs[0], "remoteAddr": "1.1.1.1:443", "inPort": 4001},
+			{"name": "no-tunnel", "tunnelId": 9999, "remoteAddr": "1.1.1.1:443"},
+		}})
+		if out.Code == 0 {
+			t.Fatalf("expected batch to fail")
+		}
+		data, _ := out.Data.(map[string]interface{})
+		errs, _ := data["errors"].([]interface{})
+		if len(errs) != 2 {
+			t.Fatalf("expected 2 item errors, got %v", out.Data)
+		}
+		if valueAsInt(errs[0].(map[string]interface{})["index"]) != 1 || valueAsInt(errs[1].(map[string]interface{})["index"]) != 2 {
+			t.Fatalf("unexpected error indexes: %v", errs)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE tunnel_id > ?`, 0, 0)
+	})
+
+	t.Run("batch size is capped", func(t *testing.T) {
+		if err := repo.UpsertConfig("forward_batch_max", "2", now); err != nil {
+			t.Fatalf("insert forward_batch_max: %v", err)
+		}
+		defer func() {
+			_, _ = repo.DB().Exec(`DELETE FROM vite_config WHERE name = 'forward_batch_max'`)
+			repo.ConfigCache().Invalidate("forward_batch_max")
+		}()
+		out := post(map[string]interface{}{"forwards": []map[string]interface{}{
+			{"name": "a", "tunnelId": tunnelIDs[0], "remoteAddr": "1.1.1.1:443"},
+			{"name": "b", "tunnelId": tunnelIDs[0], "remoteAddr": "1.1.1.1:443"},
+			{"name": "c", "tunnelId": tunnelIDs[0], "remoteAddr": "1.1.1.1:443"},
+		}})
+		if out.Code == 0 {
+			t.Fatalf("expected oversized batch to be rejected")
+		}
+	})
+
+	t.Run("creates forwards with one AddService per node", func(t *testing.T) {
+		forwards := make([]map[string]interface{}, 0, 5)
+		for i := 0; i < 5; i++ {
+			forwards = append(forwards, map[string]interface{}{
+				"name":       fmt.Sprintf("batch-forward-%d", i),
+				"tunnelId":   tunnelIDs[i%2],
+				"remoteAddr": fmt.Sprintf("1.1.1.%d:443", i+1),
+			})
+		}
+		out := post(map[string]interface{}{"forwards": forwards})
+		if out.Code != 0 {
+			t.Fatalf("batch create: code %d (%s) %v", out.Code, out.Msg, out.Data)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE tunnel_id > ?`, 0, 5)
+		assertCount(t, repo, `SELECT COUNT(DISTINCT forward_id) FROM forward_port WHERE forward_id > ?`, 0, 5)
+		assertCount(t, repo, `SELECT COUNT(DISTINCT port) FROM forward_port WHERE node_id = ?`, nodeA, 3)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if addService["batch-node-a-secret"] != 1 || addService["batch-node-b-secret"] != 1 {
+			t.Fatalf("expected one AddService per node, got %v", addService)
+		}
+	})
+
+	t.Run("batch create and delete report per-item results", func(t *testing.T) {
+		// Earlier forwards took random ports; use the first free one.
+		inPort := 4000
+		for ; inPort <= 4010; inPort++ {
+			var used int
+			if err := repo.DB().QueryRow(`SELECT COUNT(1) FROM forward_port WHERE node_id = ? AND port = ?`, nodeA, inPort).Scan(&used); err != nil {
+				t.Fatalf("query port %d: %v", inPort, err)
+			}
+			if used == 0 {
+				break
+			}
+		}
+		out := postTo("/api/v1/forward/batch-create", map[string]interface{}{"forwards": []map[string]interface{}{
+			{"name": "result-a", "tunnelId": tunnelIDs[0], "remoteAddr": "1.1.1.1:443", "inPort": inPort},
+			{"name": "result-b", "tunnelId": tunnelIDs[1], "remoteAddr": "1.1.1.2:443"},
+		}})
+		if out.Code != 0 {
+			t.Fatalf("batch create: code %d (%s) %v", out.Code, out.Msg, out.Data)
+		}
+		results, _ := out.Data.(map[string]interface{})["results"].([]interface{})
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %v", out.Data)
+		}
+		first := results[0].(map[string]interface{})
+		if first["success"] != true || valueAsInt(first["inPort"]) != inPort || valueAsString(first["name"]) != "result-a" {
+			t.Fatalf("unexpected first result: %v", first)
+		}
+
+		ids := []interface{}{first["id"], results[1].(map[string]interface{})["id"], 99999}
+		out = postTo("/api/v1/forward/batch-delete", map[string]interface{}{"ids": ids})
+		if out.Code != 0 {
+			t.Fatalf("batch delete: code %d (%s)", out.Code, out.Msg)
+		}
+		data := out.Data.(map[string]interface{})
+		if valueAsInt(data["successCount"]) != 2 || valueAsInt(data["failCount"]) != 1 {
+			t.Fatalf("expected 2 deleted and 1 failed, got %v", data)
+		}
+		deleted, _ := data["results"].([]interface{})
+		if len(deleted) != 3 || deleted[2].(map[string]interface{})["success"] != false || valueAsString(deleted[2].(map[string]interface{})["error"]) == "" {
+			t.Fatalf("expected the unknown id to fail with an error, got %v", deleted)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE name LIKE ?`, "result-%", 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward_port WHERE forward_id = ?`, valueAsInt(first["id"]), 0)
+	})
+}
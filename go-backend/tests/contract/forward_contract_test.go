@@ -9,7 +9,6 @@ import (
 	"testing"
 	"time"
 
-	"go-backend/internal/auth"
 	"go-backend/internal/http/response"
 )
 
@@ -80,11 +79,11 @@ func TestForwardOwnershipAndScopeContracts(t *testing.T) {
 		t.Fatalf("get user forward id: %v", err)
 	}
 
-	userToken, err := auth.GenerateToken(2, "normal_user", 1, secret)
+	userToken, err := contractToken(repo, 2, "normal_user", 1, secret)
 	if err != nil {
 		t.Fatalf("generate user token: %v", err)
 	}
-	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
@@ -202,7 +201,7 @@ func TestForwardSwitchTunnelRollbackOnSyncFailure(t *testing.T) {
 	router, repo := setupContractRouter(t, secret)
 	now := time.Now().UnixMilli()
 
-	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
@@ -330,7 +329,7 @@ func TestForwardBatchChangeTunnelRollbackOnSyncFailure(t *testing.T) {
 	router, repo := setupContractRouter(t, secret)
 	now := time.Now().UnixMilli()
 
-	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
@@ -452,7 +451,7 @@ func TestUserTunnelReassignmentKeepsStableID(t *testing.T) {
 	router, repo := setupContractRouter(t, secret)
 	now := time.Now().UnixMilli()
 
-	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
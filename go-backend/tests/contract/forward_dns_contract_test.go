@@ -0,0 +1,86 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForwardDNSServerIsSentWithAddService(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "dns-node", "10.0.0.31", "32000-32010", "dns-node-secret", 0)
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('dns-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+
+	var mu sync.Mutex
+	var addPayloads []json.RawMessage
+	stop := startMockNodeSessionWithPayloadHook(t, server.URL, "dns-node-secret", func(cmdType string, data json.RawMessage) {
+		if cmdType != "AddService" {
+			return
+		}
+		mu.Lock()
+		addPayloads = append(addPayloads, data)
+		mu.Unlock()
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	createForward := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/forward/create", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("rejects hostname resolver", func(t *testing.T) {
+		rec := createForward(fmt.Sprintf(`{"name":"bad-dns","tunnelId":%d,"remoteAddr":"example.com:443","dnsServer":"dns.google:53"}`, tunnelID))
+		assertCode(t, rec, -1)
+	})
+
+	t.Run("includes dnsServer in AddService payload", func(t *testing.T) {
+		rec := createForward(fmt.Sprintf(`{"name":"dns-forward","tunnelId":%d,"remoteAddr":"example.com:443","dnsServer":"8.8.8.8:53"}`, tunnelID))
+		assertCode(t, rec, 0)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(addPayloads) != 1 {
+			t.Fatalf("expected 1 AddService command, got %d", len(addPayloads))
+		}
+		var services []map[string]interface{}
+		if err := json.Unmarshal(addPayloads[0], &services); err != nil {
+			t.Fatalf("decode AddService payload: %v", err)
+		}
+		if len(services) == 0 {
+			t.Fatalf("expected at least one service in AddService payload")
+		}
+		for _, svc := range services {
+			if svc["dnsServer"] != "8.8.8.8:53" {
+				t.Fatalf("expected dnsServer 8.8.8.8:53, got %v", svc["dnsServer"])
+			}
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForwardIdleTimeoutIsSentWithAddService(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "idle-node", "10.0.0.61", "34000-34010", "idle-node-secret", 0)
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('idle-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+
+	var mu sync.Mutex
+	var addPayloads []json.RawMessage
+	stop := startMockNodeSessionWithPayloadHook(t, server.URL, "idle-node-secret", func(cmdType string, data json.RawMessage) {
+		if cmdType != "AddService" {
+			return
+		}
+		mu.Lock()
+		addPayloads = append(addPayloads, data)
+		mu.Unlock()
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	createForward := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/forward/create", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	lastIdleTimeouts := func(t *testing.T) []interface{} {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if len(addPayloads) == 0 {
+			t.Fatalf("expected an AddService command")
+		}
+		var services []map[string]interface{}
+		if err := json.Unmarshal(addPayloads[len(addPayloads)-1], &services); err != nil {
+			t.Fatalf("decode AddService payload: %v", err)
+		}
+		out := make([]interface{}, 0, len(services))
+		for _, svc := range services {
+			out = append(out, svc["idleTimeoutSec"])
+		}
+		return out
+	}
+
+	t.Run("rejects negative timeout", func(t *testing.T) {
+		rec := createForward(fmt.Sprintf(`{"name":"bad-idle","tunnelId":%d,"remoteAddr":"1.1.1.1:443","idleTimeoutSec":-1}`, tunnelID))
+		assertCode(t, rec, -1)
+	})
+
+	t.Run("includes per-forward idleTimeoutSec", func(t *testing.T) {
+		rec := createForward(fmt.Sprintf(`{"name":"idle-forward","tunnelId":%d,"remoteAddr":"1.1.1.1:443","idleTimeoutSec":30}`, tunnelID))
+		assertCode(t, rec, 0)
+		for _, v := range lastIdleTimeouts(t) {
+			if v != float64(30) {
+				t.Fatalf("expected idleTimeoutSec 30, got %v", v)
+			}
+		}
+	})
+
+	t.Run("falls back to default config", func(t *testing.T) {
+		if _, err := repo.DB().Exec(`INSERT INTO vite_config(name, value, time) VALUES('default_forward_idle_timeout_sec', '45', ?)`, now); err != nil {
+			t.Fatalf("insert default config: %v", err)
+		}
+		rec := createForward(fmt.Sprintf(`{"name":"default-idle-forward","tunnelId":%d,"remoteAddr":"1.1.1.1:443"}`, tunnelID))
+		assertCode(t, rec, 0)
+		for _, v := range lastIdleTimeouts(t) {
+			if v != float64(45) {
+				t.Fatalf("expected default idleTimeoutSec 45, got %v", v)
+			}
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE name = 'default-idle-forward' AND idle_timeout_sec = ?`, 0, 1)
+	})
+}
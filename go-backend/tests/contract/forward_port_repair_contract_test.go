@@ -0,0 +1,161 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestAdminRepairForwardPortConflictsContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "repair-node", "10.0.0.41", "32000-32005", "repair-node-secret", 0)
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('repair-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+
+	forwardIDs := make([]int64, 0, 2)
+	for i := 0; i < 2; i++ {
+		res, err := repo.DB().Exec(`
+			INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+			VALUES(1, 'admin_user', ?, ?, '1.1.1.1:443', 'fifo', 0, 0, ?, ?, 1, ?)
+		`, fmt.Sprintf("repair-forward-%d", i), tunnelID, now, now, i)
+		if err != nil {
+			t.Fatalf("insert forward: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		forwardIDs = append(forwardIDs, id)
+		// Both forwards claim the same entry port.
+		if _, err := repo.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, 32000)`, id, nodeID); err != nil {
+			t.Fatalf("insert forward_port: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	var commands []string
+	stop := startMockNodeSessionWithHook(t, server.URL, "repair-node-secret", func(cmdType string) {
+		mu.Lock()
+		defer mu.Unlock()
+		commands = append(commands, cmdType)
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+	// Both forwards are re-dispatched on connect; let that settle first.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		restored := len(commands)
+		mu.Unlock()
+		if restored == len(forwardIDs) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d re-dispatched services on connect, got %d", len(forwardIDs), restored)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	mu.Lock()
+	commands = nil
+	mu.Unlock()
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	repair := func(dryRun bool) map[string]interface{} {
+		body := fmt.Sprintf(`{"dryRun":%t}`, dryRun)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/forward/repair-port-conflicts", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if out.Code != 0 {
+			t.Fatalf("expected code 0, got %d (%s)", out.Code, out.Msg)
+		}
+		return out.Data.(map[string]interface{})
+	}
+
+	t.Run("dry run reports the conflict", func(t *testing.T) {
+		data := repair(true)
+		conflicts, _ := data["conflicts"].([]interface{})
+		if len(conflicts) != 1 {
+			t.Fatalf("expected 1 conflict, got %v", data["conflicts"])
+		}
+		conflict := conflicts[0].(map[string]interface{})
+		ids, _ := conflict["forwardIds"].([]interface{})
+		if int64(valueAsInt(conflict["nodeId"])) != nodeID || valueAsInt(conflict["port"]) != 32000 || len(ids) != 2 {
+			t.Fatalf("unexpected conflict %v", conflict)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward_port WHERE port = ?`, 32000, 2)
+		mu.Lock()
+		defer mu.Unlock()
+		if len(commands) != 0 {
+			t.Fatalf("dry run must not dispatch commands, got %v", commands)
+		}
+	})
+
+	t.Run("live run keeps the lowest forward and moves the other", func(t *testing.T) {
+		data := repair(false)
+		remapped, _ := data["remapped"].([]interface{})
+		if len(remapped) != 1 {
+			t.Fatalf("expected 1 remapped forward, got %v", data["remapped"])
+		}
+		item := remapped[0].(map[string]interface{})
+		if int64(valueAsInt(item["forwardId"])) != forwardIDs[1] || item["error"] != nil {
+			t.Fatalf("unexpected remap %v", item)
+		}
+
+		var keptPort, movedPort int
+		if err := repo.DB().QueryRow(`SELECT port FROM forward_port WHERE forward_id = ?`, forwardIDs[0]).Scan(&keptPort); err != nil {
+			t.Fatalf("query kept port: %v", err)
+		}
+		if err := repo.DB().QueryRow(`SELECT port FROM forward_port WHERE forward_id = ?`, forwardIDs[1]).Scan(&movedPort); err != nil {
+			t.Fatalf("query moved port: %v", err)
+		}
+		if keptPort != 32000 || movedPort == 32000 || movedPort < 32000 || movedPort > 32005 {
+			t.Fatalf("expected ports 32000 and a free port in range, got %d and %d", keptPort, movedPort)
+		}
+		if valueAsInt(item["newPort"]) != movedPort {
+			t.Fatalf("expected reported new port %d, got %v", movedPort, item["newPort"])
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM audit_log WHERE action = ?`, "forward_port_remap", 1)
+
+		mu.Lock()
+		got := append([]string(nil), commands...)
+		mu.Unlock()
+		var deleted, added bool
+		for _, cmd := range got {
+			deleted = deleted || cmd == "DeleteService"
+			added = added || (deleted && cmd == "AddService")
+		}
+		if !deleted || !added {
+			t.Fatalf("expected DeleteService then AddService, got %v", got)
+		}
+
+		if conflicts, _ := repair(true)["conflicts"].([]interface{}); len(conflicts) != 0 {
+			t.Fatalf("expected no conflicts after repair, got %v", conflicts)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForwardCreateProtocolContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "proto-node", "10.0.0.62", "34100-34110", "proto-node-secret", 0)
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('proto-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+
+	var mu sync.Mutex
+	var addPayloads []json.RawMessage
+	stop := startMockNodeSessionWithPayloadHook(t, server.URL, "proto-node-secret", func(cmdType string, data json.RawMessage) {
+		if cmdType != "AddService" {
+			return
+		}
+		mu.Lock()
+		addPayloads = append(addPayloads, data)
+		mu.Unlock()
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	createForward := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/forward/create", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	lastProtocols := func(t *testing.T) string {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if len(addPayloads) == 0 {
+			t.Fatalf("expected an AddService command")
+		}
+		var services []map[string]interface{}
+		if err := json.Unmarshal(addPayloads[len(addPayloads)-1], &services); err != nil {
+			t.Fatalf("decode AddService payload: %v", err)
+		}
+		out := make([]string, 0, len(services))
+		for _, svc := range services {
+			out = append(out, fmt.Sprint(svc["protocol"]))
+		}
+		sort.Strings(out)
+		return strings.Join(out, ",")
+	}
+
+	t.Run("missing protocol opens tcp and udp", func(t *testing.T) {
+		rec := createForward(fmt.Sprintf(`{"name":"proto-default","tunnelId":%d,"remoteAddr":"1.1.1.1:443"}`, tunnelID))
+		assertCode(t, rec, 0)
+		if got := lastProtocols(t); got != "tcp,udp" {
+			t.Fatalf("expected tcp and udp services, got %q", got)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE name = 'proto-default' AND protocol = 'both'`, 0, 1)
+	})
+
+	t.Run("explicit tcp opens tcp only", func(t *testing.T) {
+		rec := createForward(fmt.Sprintf(`{"name":"proto-tcp","tunnelId":%d,"remoteAddr":"1.1.1.1:443","protocol":"tcp"}`, tunnelID))
+		assertCode(t, rec, 0)
+		if got := lastProtocols(t); got != "tcp" {
+			t.Fatalf("expected a tcp service only, got %q", got)
+		}
+	})
+
+	t.Run("rejects unknown protocol", func(t *testing.T) {
+		rec := createForward(fmt.Sprintf(`{"name":"proto-bad","tunnelId":%d,"remoteAddr":"1.1.1.1:443","protocol":"sctp"}`, tunnelID))
+		assertCode(t, rec, -1)
+	})
+}
@@ -0,0 +1,134 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForwardServiceRevisionContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "revision-node", "10.0.0.97", "32000-32010", "revision-node-secret", 0)
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('revision-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+	// An older forward holding port 32000, so the repair later moves the new
+	// forward instead of this one.
+	res, err = repo.DB().Exec(`
+		INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+		VALUES(1, 'admin_user', 'revision-holder', ?, '1.1.1.1:443', 'fifo', 0, 0, ?, ?, 1, 0)
+	`, tunnelID, now, now)
+	if err != nil {
+		t.Fatalf("insert forward: %v", err)
+	}
+	holderID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, 32000)`, holderID, nodeID); err != nil {
+		t.Fatalf("insert forward_port: %v", err)
+	}
+
+	var mu sync.Mutex
+	var added []map[string]interface{}
+	hook := func(cmdType string, data json.RawMessage) {
+		if cmdType != "AddService" {
+			return
+		}
+		var services []map[string]interface{}
+		_ = json.Unmarshal(data, &services)
+		mu.Lock()
+		added = append(added, services...)
+		mu.Unlock()
+	}
+	// takeServices waits until services of forwardID arrive and returns them.
+	takeServices := func(forwardID int64) []map[string]interface{} {
+		t.Helper()
+		prefix := fmt.Sprintf("%d_", forwardID)
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			mu.Lock()
+			var matched []map[string]interface{}
+			for _, svc := range added {
+				if name, _ := svc["name"].(string); strings.HasPrefix(name, prefix) {
+					matched = append(matched, svc)
+				}
+			}
+			if len(matched) > 0 {
+				added = nil
+				mu.Unlock()
+				return matched
+			}
+			mu.Unlock()
+			if time.Now().After(deadline) {
+				t.Fatalf("no AddService received for forward %d", forwardID)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	assertRevision := func(services []map[string]interface{}, want int) {
+		t.Helper()
+		for _, svc := range services {
+			if valueAsInt(svc["revision"]) != want {
+				t.Fatalf("expected revision %d, got %v in %v", want, svc["revision"], svc["name"])
+			}
+			if valueAsInt(svc["createdAt"]) <= 0 {
+				t.Fatalf("expected createdAt in %v", svc)
+			}
+		}
+	}
+
+	stop := startMockNodeSessionWithPayloadHook(t, server.URL, "revision-node-secret", hook)
+	waitNodeStatus(t, repo, nodeID, 1)
+	takeServices(holderID)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assertCode(t, post("/api/v1/forward/create", fmt.Sprintf(`{"name":"revision-forward","tunnelId":%d,"remoteAddr":"1.1.1.1:443"}`, tunnelID)), 0)
+	var forwardID int64
+	if err := repo.DB().QueryRow(`SELECT id FROM forward WHERE name = 'revision-forward'`).Scan(&forwardID); err != nil {
+		t.Fatalf("query forward: %v", err)
+	}
+	assertRevision(takeServices(forwardID), 1)
+
+	if _, err := repo.DB().Exec(`UPDATE forward_port SET port = 32000 WHERE forward_id = ?`, forwardID); err != nil {
+		t.Fatalf("force port conflict: %v", err)
+	}
+	assertCode(t, post("/api/v1/admin/forward/repair-port-conflicts", `{"dryRun":false}`), 0)
+	assertRevision(takeServices(forwardID), 2)
+
+	stop()
+	waitNodeStatus(t, repo, nodeID, 0)
+	stop = startMockNodeSessionWithPayloadHook(t, server.URL, "revision-node-secret", hook)
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+	// A reconnect replays the services without bumping the revision.
+	assertRevision(takeServices(forwardID), 2)
+	assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE id = ? AND service_revision = 2`, forwardID, 1)
+}
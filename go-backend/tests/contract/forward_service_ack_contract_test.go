@@ -0,0 +1,113 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestForwardServiceAddedAckContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "ack-node", "10.0.0.81", "36000-36010", "ack-node-secret", 0)
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('ack-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+
+	const busyPort = 36001
+	stop := startMockNodeSessionWithFollowUps(t, server.URL, "ack-node-secret", func(cmdType string, data json.RawMessage) []interface{} {
+		if cmdType != "AddService" {
+			return nil
+		}
+		var services []struct {
+			Addr string `json:"addr"`
+		}
+		if err := json.Unmarshal(data, &services); err != nil || len(services) == 0 {
+			return nil
+		}
+		_, portText, err := net.SplitHostPort(services[0].Addr)
+		if err != nil {
+			return nil
+		}
+		port, _ := strconv.Atoi(portText)
+		if port == busyPort {
+			return []interface{}{map[string]interface{}{"type": "ServiceAddedAck", "success": false, "error": "port in use", "port": port}}
+		}
+		return []interface{}{map[string]interface{}{"type": "ServiceAddedAck", "success": true, "port": port}}
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, body string) response.R {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+	forwardStatus := func(name string) int {
+		var status int
+		if err := repo.DB().QueryRow(`SELECT status FROM forward WHERE name = ?`, name).Scan(&status); err != nil {
+			t.Fatalf("query forward status: %v", err)
+		}
+		return status
+	}
+	waitForwardStatus := func(name string, expected int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for forwardStatus(name) != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("forward %s status did not reach %d, got %d", name, expected, forwardStatus(name))
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	for _, f := range []struct {
+		name string
+		port int
+	}{{"ack-busy", busyPort}, {"ack-ok", busyPort + 1}} {
+		out := post("/api/v1/forward/create", fmt.Sprintf(`{"name":%q,"tunnelId":%d,"remoteAddr":"1.1.1.1:443","inPort":%d}`, f.name, tunnelID, f.port))
+		if out.Code != 0 {
+			t.Fatalf("create forward %s: code %d (%s)", f.name, out.Code, out.Msg)
+		}
+	}
+
+	waitForwardStatus("ack-busy", -1)
+	waitForwardStatus("ack-ok", 1)
+	assertCount(t, repo, `SELECT COUNT(1) FROM audit_log WHERE action = ?`, "forward_service_failed", 1)
+
+	out := post("/api/v1/admin/forward/status-list", `{}`)
+	items, _ := out.Data.([]interface{})
+	if len(items) != 1 || items[0].(map[string]interface{})["name"] != "ack-busy" {
+		t.Fatalf("expected only ack-busy in status list, got %v", out.Data)
+	}
+}
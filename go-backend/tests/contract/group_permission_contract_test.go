@@ -6,8 +6,6 @@ import (
 	"net/http/httptest"
 	"testing"
 	"time"
-
-	"go-backend/internal/auth"
 )
 
 func TestGroupUserUnbindRevokesInheritedTunnelPermission(t *testing.T) {
@@ -59,7 +57,7 @@ func TestGroupUserUnbindRevokesInheritedTunnelPermission(t *testing.T) {
 		t.Fatalf("insert group_permission: %v", err)
 	}
 
-	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
@@ -147,7 +145,7 @@ func TestGroupPermissionRemoveRevokesInheritedTunnelPermission(t *testing.T) {
 		t.Fatalf("read tunnel_group id: %v", err)
 	}
 
-	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
@@ -0,0 +1,145 @@
+package contract_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupTunnelQuotaContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "quota-node", "10.0.0.71", "35000-35010", "quota-node-secret", 0)
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('quota-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+
+	// Users 1 and 3 share the capped group; user 2 is in an uncapped one.
+	groupIDs := make([]int64, 0, 2)
+	for _, name := range []string{"capped-group", "open-group"} {
+		res, err := repo.DB().Exec(`INSERT INTO user_group(name, created_time, updated_time, status) VALUES(?, ?, ?, 1)`, name, now, now)
+		if err != nil {
+			t.Fatalf("insert user group: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		groupIDs = append(groupIDs, id)
+	}
+	for userID, groupID := range map[int64]int64{1: groupIDs[0], 2: groupIDs[1], 3: groupIDs[0]} {
+		if _, err := repo.DB().Exec(`INSERT INTO user_group_user(user_group_id, user_id, created_time) VALUES(?, ?, ?)`, groupID, userID, now); err != nil {
+			t.Fatalf("insert user group member: %v", err)
+		}
+	}
+
+	stop := startMockNodeSessionWithHook(t, server.URL, "quota-node-secret", func(string) {})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	tokens := make(map[int64]string, 3)
+	for _, userID := range []int64{1, 2, 3} {
+		if userID != 1 {
+			insertContractUser(t, repo, userID, fmt.Sprintf("quota_user_%d", userID), 0)
+		}
+		token, err := contractToken(repo, userID, fmt.Sprintf("quota_user_%d", userID), 0, secret)
+		if err != nil {
+			t.Fatalf("generate token: %v", err)
+		}
+		tokens[userID] = token
+	}
+	post := func(path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	createForward := func(userID int64, name string) *httptest.ResponseRecorder {
+		return post("/api/v1/forward/create", tokens[userID], fmt.Sprintf(`{"name":%q,"tunnelId":%d,"remoteAddr":"1.1.1.1:443"}`, name, tunnelID))
+	}
+
+	assertCode(t, post("/api/v1/admin/group/tunnel-quota", tokens[1], fmt.Sprintf(`{"groupId":%d,"tunnelId":%d,"maxForwards":2}`, groupIDs[0], tunnelID)), 0)
+	assertCount(t, repo, `SELECT COUNT(1) FROM group_tunnel_quota WHERE max_forwards = ?`, 2, 1)
+
+	t.Run("creates up to the quota", func(t *testing.T) {
+		assertCode(t, createForward(1, "quota-forward-1"), 0)
+		assertCode(t, createForward(1, "quota-forward-2"), 0)
+		assertCodeMsg(t, createForward(1, "quota-forward-3"), -1, "group tunnel quota exceeded")
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE tunnel_id = ?`, tunnelID, 2)
+	})
+
+	t.Run("counts against the authenticated user's group", func(t *testing.T) {
+		assertCodeMsg(t, createForward(3, "quota-forward-member"), -1, "group tunnel quota exceeded")
+		assertCode(t, createForward(2, "quota-forward-open"), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE tunnel_id = ?`, tunnelID, 3)
+	})
+
+	t.Run("zero removes the quota", func(t *testing.T) {
+		assertCode(t, post("/api/v1/admin/group/tunnel-quota", tokens[1], fmt.Sprintf(`{"groupId":%d,"tunnelId":%d,"maxForwards":0}`, groupIDs[0], tunnelID)), 0)
+		assertCode(t, createForward(1, "quota-forward-3"), 0)
+	})
+
+	t.Run("moving forwards onto a capped tunnel counts", func(t *testing.T) {
+		res, err := repo.DB().Exec(`
+			INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+			VALUES('quota-tunnel-2', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 1)
+		`, now, now)
+		if err != nil {
+			t.Fatalf("insert tunnel: %v", err)
+		}
+		otherTunnelID, _ := res.LastInsertId()
+		if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, otherTunnelID, nodeID); err != nil {
+			t.Fatalf("insert chain_tunnel: %v", err)
+		}
+		assertCode(t, post("/api/v1/admin/group/tunnel-quota", tokens[1], fmt.Sprintf(`{"groupId":%d,"tunnelId":%d,"maxForwards":2}`, groupIDs[0], otherTunnelID)), 0)
+		forwardID := func(name string) int64 {
+			var id int64
+			if err := repo.DB().QueryRow(`SELECT id FROM forward WHERE name = ?`, name).Scan(&id); err != nil {
+				t.Fatalf("query forward %s: %v", name, err)
+			}
+			return id
+		}
+
+		assertCode(t, post("/api/v1/forward/update", tokens[1], fmt.Sprintf(`{"id":%d,"tunnelId":%d}`, forwardID("quota-forward-1"), otherTunnelID)), 0)
+
+		rec := post("/api/v1/forward/batch-change-tunnel", tokens[1], fmt.Sprintf(`{"forwardIds":[%d,%d],"targetTunnelId":%d}`, forwardID("quota-forward-2"), forwardID("quota-forward-3"), otherTunnelID))
+		assertCode(t, rec, 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE tunnel_id = ?`, otherTunnelID, 2)
+
+		assertCodeMsg(t, post("/api/v1/forward/update", tokens[3], fmt.Sprintf(`{"id":%d,"tunnelId":%d}`, forwardID("quota-forward-3"), otherTunnelID)), -1, "group tunnel quota exceeded")
+	})
+
+	t.Run("batch create counts the whole batch", func(t *testing.T) {
+		var otherTunnelID int64
+		if err := repo.DB().QueryRow(`SELECT id FROM tunnel WHERE name = 'quota-tunnel-2'`).Scan(&otherTunnelID); err != nil {
+			t.Fatalf("query tunnel: %v", err)
+		}
+		assertCode(t, post("/api/v1/admin/group/tunnel-quota", tokens[1], fmt.Sprintf(`{"groupId":%d,"tunnelId":%d,"maxForwards":3}`, groupIDs[0], otherTunnelID)), 0)
+		batch := func(names ...string) *httptest.ResponseRecorder {
+			items := make([]string, 0, len(names))
+			for _, name := range names {
+				items = append(items, fmt.Sprintf(`{"name":%q,"tunnelId":%d,"remoteAddr":"1.1.1.1:443","userId":1}`, name, otherTunnelID))
+			}
+			return post("/api/v1/forward/batch-create", tokens[1], `{"forwards":[`+strings.Join(items, ",")+`]}`)
+		}
+
+		assertCodeMsg(t, batch("quota-batch-1", "quota-batch-2"), -1, "group tunnel quota exceeded")
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE tunnel_id = ?`, otherTunnelID, 2)
+		assertCode(t, batch("quota-batch-1"), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE tunnel_id = ?`, otherTunnelID, 3)
+	})
+}
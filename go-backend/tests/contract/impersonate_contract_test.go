@@ -0,0 +1,108 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+)
+
+func TestUserImpersonationContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	now := time.Now().UnixMilli()
+
+	if _, err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(2, 'customer', ?, 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+	`, security.MD5("customer-pass"), now, now); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, token string, body interface{}) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/api/v1/user/impersonate", adminToken, map[string]interface{}{"id": 2})
+	var out response.R
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Code != 0 {
+		t.Fatalf("impersonate: code %d (%s)", out.Code, out.Msg)
+	}
+	token := valueAsString(out.Data.(map[string]interface{})["token"])
+	claims, err := auth.ParseClaims(token, secret)
+	if err != nil {
+		t.Fatalf("parse impersonation token: %v", err)
+	}
+	if claims.Sub != "2" || claims.RoleID != 1 || claims.Act != 1 || claims.Sid != 0 {
+		t.Fatalf("unexpected impersonation claims %+v", claims)
+	}
+	if ttl := claims.Exp - claims.Iat; ttl > int64(auth.ImpersonationTokenTTL/time.Second) {
+		t.Fatalf("expected a short-lived token, got %ds", ttl)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM audit_log WHERE action = 'user_impersonate' AND user_id = 1 AND target_id = ?`, 2, 1)
+
+	t.Run("token sees the user's own views", func(t *testing.T) {
+		assertCode(t, post("/api/v1/user/package", token, map[string]interface{}{}), 0)
+		assertCode(t, post("/api/v1/forward/list", token, map[string]interface{}{}), 0)
+	})
+
+	t.Run("token does not reach admin endpoints", func(t *testing.T) {
+		assertCode(t, post("/api/v1/user/impersonate", token, map[string]interface{}{"id": 2}), 403)
+		assertCode(t, post("/api/v1/user/list", token, map[string]interface{}{}), 403)
+	})
+
+	t.Run("token cannot change credentials", func(t *testing.T) {
+		assertCodeMsg(t, post("/api/v1/user/updatePassword", token, map[string]interface{}{
+			"username": "customer", "password": "customer-pass", "newPassword": "Other-pass-123", "confirmPassword": "Other-pass-123",
+		}), 403, "模拟登录状态下不能执行此操作")
+		assertCode(t, post("/api/v1/user/apikey/create", token, map[string]interface{}{"name": "k"}), 403)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user_api_key WHERE user_id = ?`, 2, 0)
+	})
+
+	t.Run("token stops working while the user is disabled", func(t *testing.T) {
+		if err := repo.SetUserStatus(2, 0, time.Now().UnixMilli()); err != nil {
+			t.Fatalf("disable user: %v", err)
+		}
+		assertCode(t, post("/api/v1/user/package", token, map[string]interface{}{}), 401)
+		if err := repo.SetUserStatus(2, 1, time.Now().UnixMilli()); err != nil {
+			t.Fatalf("enable user: %v", err)
+		}
+		assertCode(t, post("/api/v1/user/package", token, map[string]interface{}{}), 0)
+	})
+
+	t.Run("revoking the user's sessions ends the token", func(t *testing.T) {
+		assertCode(t, post("/api/v1/user/reset-password", adminToken, map[string]interface{}{"id": 2, "password": "Reset-pass-123"}), 0)
+		assertCode(t, post("/api/v1/user/package", token, map[string]interface{}{}), 401)
+	})
+
+	t.Run("admins cannot be impersonated", func(t *testing.T) {
+		rec := post("/api/v1/user/impersonate", adminToken, map[string]interface{}{"id": 1})
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if out.Code == 0 {
+			t.Fatalf("expected impersonating an admin to be refused")
+		}
+	})
+}
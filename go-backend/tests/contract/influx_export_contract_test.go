@@ -0,0 +1,95 @@
+package contract_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlowUploadExportsInfluxLineProtocolContract(t *testing.T) {
+	router, repo := setupContractRouter(t, "contract-jwt-secret")
+	insertContractNode(t, repo, "influx-node", "10.0.0.71", "46000-46010", "influx-node-secret", 1)
+
+	type write struct {
+		auth string
+		body string
+	}
+	writes := make(chan write, 4)
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		writes <- write{auth: r.Header.Get("Authorization"), body: string(body)}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influx.Close()
+
+	upload := func() {
+		t.Helper()
+		body := `[{"n":"7_2_3","u":100,"d":200},{"n":"8_5_0","u":1,"d":2},{"n":"web_api","u":9,"d":9}]`
+		req := httptest.NewRequest(http.MethodPost, "/flow/upload?secret=influx-node-secret", bytes.NewBufferString(body))
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		if res.Code != http.StatusOK || res.Body.String() != "ok" {
+			t.Fatalf("expected ok, got %d %q", res.Code, res.Body.String())
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	for name, value := range map[string]string{
+		"influx_write_url":  influx.URL + "/api/v2/write?bucket=flvx",
+		"influx_auth_token": "influx-secret",
+	} {
+		if err := repo.UpsertConfig(name, value, now); err != nil {
+			t.Fatalf("set %s: %v", name, err)
+		}
+	}
+
+	t.Run("disabled exporter sends nothing", func(t *testing.T) {
+		upload()
+		select {
+		case got := <-writes:
+			t.Fatalf("expected no write while influx_enabled is unset, got %q", got.body)
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+
+	t.Run("enabled exporter writes one line per forward", func(t *testing.T) {
+		if err := repo.UpsertConfig("influx_enabled", "true", now); err != nil {
+			t.Fatalf("enable influx: %v", err)
+		}
+		upload()
+		var got write
+		select {
+		case got = <-writes:
+		case <-time.After(3 * time.Second):
+			t.Fatal("expected a line protocol write")
+		}
+		if got.auth != "Token influx-secret" {
+			t.Fatalf("expected token auth header, got %q", got.auth)
+		}
+		lines := strings.Split(strings.TrimSpace(got.body), "\n")
+		patterns := []string{
+			`^flvx_flow,forward_id=7,user_id=2 upload=100,download=200 \d{19}$`,
+			`^flvx_flow,forward_id=8,user_id=5 upload=1,download=2 \d{19}$`,
+		}
+		if len(lines) != len(patterns) {
+			t.Fatalf("expected %d lines, got %q", len(patterns), got.body)
+		}
+		for i, pattern := range patterns {
+			if !regexp.MustCompile(pattern).MatchString(lines[i]) {
+				t.Fatalf("line %d: expected %s, got %q", i, pattern, lines[i])
+			}
+		}
+	})
+
+	t.Run("failed export still answers ok", func(t *testing.T) {
+		if err := repo.UpsertConfig("influx_write_url", "http://127.0.0.1:1/write", now); err != nil {
+			t.Fatalf("set influx_write_url: %v", err)
+		}
+		upload()
+	})
+}
@@ -0,0 +1,73 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestIPBanContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	if err := repo.UpsertConfig("ip_ban_threshold", "3", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("set ip_ban_threshold: %v", err)
+	}
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+
+	post := func(path, remoteAddr, token string, body interface{}) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	badLogin := map[string]interface{}{"username": "admin_user", "password": "wrong-password"}
+
+	for i := 0; i < 3; i++ {
+		assertCodeMsg(t, post("/api/v1/user/login", "203.0.113.50:5000", "", badLogin), -1, "账号或密码错误")
+	}
+	rec := post("/api/v1/user/login", "203.0.113.50:5000", "", map[string]interface{}{"username": "admin_user", "password": "admin_user"})
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected the banned IP to be refused even with the right password, got %d", rec.Code)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM ip_ban WHERE ip = ? AND reason = 'login'`, "203.0.113.50", 1)
+	assertCode(t, post("/api/v1/user/login", "203.0.113.51:5000", "", map[string]interface{}{"username": "admin_user", "password": "admin_user"}), 0)
+
+	t.Run("unknown node secrets count as failures", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			post("/flow/upload?secret=guess", "198.51.100.9:6000", "", []interface{}{})
+		}
+		if rec := post("/flow/upload?secret=guess", "198.51.100.9:6000", "", []interface{}{}); rec.Code != http.StatusForbidden {
+			t.Fatalf("expected the node-secret guesser to be banned, got %d", rec.Code)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM ip_ban WHERE ip = ? AND reason = 'node secret'`, "198.51.100.9", 1)
+	})
+
+	t.Run("admin lists and lifts bans", func(t *testing.T) {
+		rec := post("/api/v1/security/ban/list", "192.0.2.10:7000", adminToken, map[string]interface{}{})
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if out.Code != 0 || len(out.Data.([]interface{})) != 2 {
+			t.Fatalf("expected two active bans, got %d %v", out.Code, out.Data)
+		}
+
+		assertCode(t, post("/api/v1/security/ban/remove", "192.0.2.10:7000", adminToken, map[string]interface{}{"ip": "203.0.113.50"}), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM ip_ban WHERE ip = ?`, "203.0.113.50", 0)
+		assertCode(t, post("/api/v1/user/login", "203.0.113.50:5000", "", map[string]interface{}{"username": "admin_user", "password": "admin_user"}), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM audit_log WHERE action = 'ip_unban' AND detail = ?`, "203.0.113.50", 1)
+	})
+}
@@ -0,0 +1,105 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestJWTKeyRotationContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	post := func(path, token string, payload interface{}) response.R {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+	expectCode := func(out response.R, want int) map[string]interface{} {
+		t.Helper()
+		if out.Code != want {
+			t.Fatalf("expected code %d, got %d (%s)", want, out.Code, out.Msg)
+		}
+		data, _ := out.Data.(map[string]interface{})
+		return data
+	}
+	login := func() string {
+		t.Helper()
+		data := expectCode(post("/api/v1/user/login", "", map[string]interface{}{"username": "admin_user", "password": "admin_user"}), 0)
+		return valueAsString(data["token"])
+	}
+	kidOf := func(token string) string {
+		t.Helper()
+		raw, err := base64.RawURLEncoding.DecodeString(strings.SplitN(token, ".", 2)[0])
+		if err != nil {
+			t.Fatalf("decode token header: %v", err)
+		}
+		var header struct {
+			Kid string `json:"kid"`
+		}
+		if err := json.Unmarshal(raw, &header); err != nil {
+			t.Fatalf("unmarshal token header: %v", err)
+		}
+		return header.Kid
+	}
+
+	before := login()
+	if kidOf(before) != "" {
+		t.Fatalf("expected tokens signed with JWT_SECRET to carry no kid")
+	}
+
+	rotated := expectCode(post("/api/v1/admin/jwt/rotate", before, map[string]interface{}{}), 0)
+	kid := valueAsString(rotated["kid"])
+	if kid == "" {
+		t.Fatalf("expected the new key id, got %v", rotated)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM jwt_signing_key WHERE kid = ?`, kid, 1)
+
+	// Tokens issued before the rotation keep working.
+	expectCode(post("/api/v1/user/package", before, map[string]interface{}{}), 0)
+
+	after := login()
+	if kidOf(after) != kid {
+		t.Fatalf("expected new tokens to be signed with key %s, got %q", kid, kidOf(after))
+	}
+	expectCode(post("/api/v1/user/package", after, map[string]interface{}{}), 0)
+
+	expectCode(post("/api/v1/admin/jwt/rotate", after, map[string]interface{}{}), 0)
+	expectCode(post("/api/v1/user/package", after, map[string]interface{}{}), 0)
+
+	// A token naming an unknown key is refused even when signed with the
+	// static secret.
+	forged, err := auth.GenerateAccessTokenWithKey(1, "admin_user", 0, 0, auth.DefaultAudience, 0, auth.SigningKey{Kid: "unknown", Secret: secret})
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	expectCode(post("/api/v1/user/package", forged, map[string]interface{}{}), 401)
+
+	t.Run("non-admins cannot rotate", func(t *testing.T) {
+		userToken, err := contractToken(repo, 2, "member", 1, secret)
+		if err != nil {
+			t.Fatalf("generate token: %v", err)
+		}
+		if out := post("/api/v1/admin/jwt/rotate", userToken, map[string]interface{}{}); out.Code == 0 {
+			t.Fatalf("expected a non-admin rotation to be refused")
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/ldap/ldaptest"
+	"go-backend/internal/security"
+)
+
+func TestLDAPLoginContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	directory := ldaptest.NewServer(
+		ldaptest.Entry{DN: "ou=people,dc=example,dc=org"},
+		ldaptest.Entry{DN: "cn=reader,dc=example,dc=org", Password: "reader-pass"},
+		ldaptest.Entry{
+			DN:         "uid=alice,ou=people,dc=example,dc=org",
+			Password:   "alice-pass",
+			Attributes: map[string][]string{"uid": {"alice"}},
+		},
+		ldaptest.Entry{
+			DN:         "uid=bob,ou=people,dc=example,dc=org",
+			Password:   "bob-pass",
+			Attributes: map[string][]string{"uid": {"bob"}},
+		},
+		ldaptest.Entry{
+			DN:         "uid=dave,ou=people,dc=example,dc=org",
+			Password:   "dave-directory-pass",
+			Attributes: map[string][]string{"uid": {"dave"}},
+		},
+		ldaptest.Entry{
+			DN:         "uid=admin_user,ou=people,dc=example,dc=org",
+			Password:   "directory-admin-pass",
+			Attributes: map[string][]string{"uid": {"admin_user"}},
+		},
+		ldaptest.Entry{
+			DN:         "cn=panel-admins,dc=example,dc=org",
+			Attributes: map[string][]string{"member": {"uid=alice,ou=people,dc=example,dc=org"}},
+		},
+	)
+	defer directory.Close()
+
+	now := time.Now().UnixMilli()
+	setConfig := func(values map[string]string) {
+		t.Helper()
+		for name, value := range values {
+			if err := repo.UpsertConfig(name, value, now); err != nil {
+				t.Fatalf("set %s: %v", name, err)
+			}
+		}
+	}
+	setConfig(map[string]string{
+		"ldap_enabled":          "true",
+		"ldap_url":              directory.URL,
+		"ldap_user_dn_template": "uid={username},ou=people,dc=example,dc=org",
+		"ldap_admin_group_dn":   "cn=panel-admins,dc=example,dc=org",
+	})
+
+	login := func(username, password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{"username": username, "password": password})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/user/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("first login provisions a user with the mapped role", func(t *testing.T) {
+		assertCode(t, login("alice", "alice-pass"), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = 'alice' AND role_id = 0`, nil, 1)
+
+		assertCode(t, login("bob", "bob-pass"), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = 'bob' AND role_id = 1`, nil, 1)
+
+		assertCode(t, login("alice", "alice-pass"), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = ?`, "alice", 1)
+	})
+
+	t.Run("rejected credentials are not provisioned", func(t *testing.T) {
+		assertCodeMsg(t, login("alice", "wrong"), -1, "账号或密码错误")
+		assertCodeMsg(t, login("carol", "carol-pass"), -1, "账号或密码错误")
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = ?`, "carol", 0)
+	})
+
+	t.Run("local accounts still sign in", func(t *testing.T) {
+		assertCode(t, login("admin_user", "admin_user"), 0)
+	})
+
+	t.Run("existing local users are linked only when enabled", func(t *testing.T) {
+		if _, err := repo.DB().Exec(`
+			INSERT INTO user(user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+			VALUES('dave', ?, 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+		`, security.MD5("dave-local-pass"), now, now); err != nil {
+			t.Fatalf("insert local user: %v", err)
+		}
+		assertCodeMsg(t, login("dave", "dave-directory-pass"), -1, "账号或密码错误")
+		assertCode(t, login("dave", "dave-local-pass"), 0)
+
+		setConfig(map[string]string{"ldap_link_by_username": "true"})
+		assertCode(t, login("dave", "dave-directory-pass"), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = ?`, "dave", 1)
+	})
+
+	t.Run("admin accounts are never linked", func(t *testing.T) {
+		assertCodeMsg(t, login("admin_user", "directory-admin-pass"), -1, "账号或密码错误")
+	})
+
+	t.Run("admin group membership is re-applied on every login", func(t *testing.T) {
+		setConfig(map[string]string{"ldap_admin_group_dn": "ou=people,dc=example,dc=org"})
+		assertCode(t, login("alice", "alice-pass"), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = 'alice' AND role_id = 1`, nil, 1)
+
+		setConfig(map[string]string{"ldap_admin_group_dn": "cn=panel-admins,dc=example,dc=org"})
+		assertCode(t, login("alice", "alice-pass"), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = 'alice' AND role_id = 0`, nil, 1)
+	})
+
+	t.Run("users can be found by search with a service account", func(t *testing.T) {
+		setConfig(map[string]string{
+			"ldap_user_dn_template": "",
+			"ldap_bind_dn":          "cn=reader,dc=example,dc=org",
+			"ldap_bind_password":    "reader-pass",
+			"ldap_base_dn":          "ou=people,dc=example,dc=org",
+		})
+		assertCode(t, login("bob", "bob-pass"), 0)
+		assertCodeMsg(t, login("bob", "alice-pass"), -1, "账号或密码错误")
+	})
+
+	t.Run("the service account password is not public", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]string{"name": "ldap_bind_password"})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/config/get", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assertCodeMsg(t, rec, -1, "配置不存在")
+	})
+}
@@ -0,0 +1,141 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestListFilterContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	now := time.Now().UnixMilli()
+	hkNode := insertContractNode(t, repo, "HK-edge", "10.0.8.1", "48000-48010", "filter-node-hk", 1)
+	insertContractNode(t, repo, "JP-edge", "10.0.8.2", "48000-48010", "filter-node-jp", 0)
+	sgNode := insertContractNode(t, repo, "SG-core", "10.0.8.3", "48000-48010", "filter-node-sg", 1)
+
+	exec := func(query string, args ...interface{}) int64 {
+		t.Helper()
+		res, err := repo.DB().Exec(query, args...)
+		if err != nil {
+			t.Fatalf("exec %s: %v", query, err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+	tunnel := func(name string, status int, created int64) int64 {
+		return exec(`
+			INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+			VALUES(?, 1.0, 1, 'tls', 99999, ?, ?, ?, NULL, 0)
+		`, name, created, now, status)
+	}
+	hkTunnel := tunnel("hk-tunnel", 1, now-3*24*3600*1000)
+	sgTunnel := tunnel("sg-tunnel", 0, now)
+	exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, 0, 'round', 0, 'tls')`, hkTunnel, hkNode)
+	exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, 0, 'round', 0, 'tls')`, sgTunnel, sgNode)
+
+	forward := func(owner int64, name string, tunnelID, nodeID int64) {
+		id := exec(`
+			INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+			VALUES(?, ?, ?, ?, '1.1.1.1:443', 'fifo', 0, 0, ?, ?, 1, 0)
+		`, owner, fmt.Sprintf("user-%d", owner), name, tunnelID, now, now)
+		exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, ?)`, id, nodeID, 48000+id)
+	}
+	forward(2, "web-hk", hkTunnel, hkNode)
+	forward(2, "ssh-sg", sgTunnel, sgNode)
+	forward(3, "web-sg", sgTunnel, sgNode)
+
+	for _, name := range []string{"carol", "dave"} {
+		user := &sqlite.User{User: name, Pwd: "x", RoleID: 1, ExpTime: now, Flow: 1, FlowResetTime: 1, Num: 1, CreatedTime: now, Status: 1}
+		id, err := repo.CreateUser(user)
+		if err != nil {
+			t.Fatalf("create user %s: %v", name, err)
+		}
+		if name == "dave" {
+			exec(`INSERT INTO user_tunnel(user_id, tunnel_id, num, flow, flow_reset_time, exp_time, status) VALUES(?, ?, 1, 1, 1, ?, 1)`, id, hkTunnel, now)
+		}
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	userToken, err := contractToken(repo, 3, "user-3", 1, secret)
+	if err != nil {
+		t.Fatalf("generate user token: %v", err)
+	}
+	post := func(t *testing.T, token, path, body string) response.R {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+	names := func(t *testing.T, token, path, body string) string {
+		t.Helper()
+		out := post(t, token, path, body)
+		data, ok := out.Data.(map[string]interface{})
+		if out.Code != 0 || !ok {
+			t.Fatalf("%s %s: expected a paged result, got code %d (%s) %v", path, body, out.Code, out.Msg, out.Data)
+		}
+		items, _ := data["items"].([]interface{})
+		if valueAsInt(data["total"]) != len(items) {
+			t.Fatalf("%s %s: total %v does not match %d items", path, body, data["total"], len(items))
+		}
+		got := make([]string, 0, len(items))
+		for _, item := range items {
+			m := item.(map[string]interface{})
+			got = append(got, valueAsString(m["name"]))
+		}
+		sort.Strings(got)
+		return fmt.Sprint(got)
+	}
+
+	cases := []struct {
+		path, body, want string
+	}{
+		{"/api/v1/node/list", `{"name":"edge"}`, "[HK-edge JP-edge]"},
+		{"/api/v1/node/list", `{"name":"EDGE","status":1}`, "[HK-edge]"},
+		{"/api/v1/node/list", `{"name":"%"}`, "[]"},
+		{"/api/v1/tunnel/list", fmt.Sprintf(`{"nodeId":%d}`, sgNode), "[sg-tunnel]"},
+		{"/api/v1/tunnel/list", fmt.Sprintf(`{"createdTo":%d}`, now-24*3600*1000), "[hk-tunnel]"},
+		{"/api/v1/tunnel/list", fmt.Sprintf(`{"createdFrom":%d,"status":0}`, now-1000), "[sg-tunnel]"},
+		{"/api/v1/forward/list", fmt.Sprintf(`{"tunnelId":%d}`, sgTunnel), "[ssh-sg web-sg]"},
+		{"/api/v1/forward/list", fmt.Sprintf(`{"nodeId":%d,"name":"web"}`, hkNode), "[web-hk]"},
+		{"/api/v1/forward/list", `{"userId":2}`, "[ssh-sg web-hk]"},
+		{"/api/v1/user/list", `{"name":"a"}`, "[carol dave]"},
+		{"/api/v1/user/list", fmt.Sprintf(`{"tunnelId":%d}`, hkTunnel), "[dave]"},
+	}
+	for _, tc := range cases {
+		if got := names(t, adminToken, tc.path, tc.body); got != tc.want {
+			t.Fatalf("%s %s: expected %s, got %s", tc.path, tc.body, tc.want, got)
+		}
+	}
+
+	t.Run("users cannot filter their way to other users' forwards", func(t *testing.T) {
+		if got := names(t, userToken, "/api/v1/forward/list", `{"userId":2}`); got != "[web-sg]" {
+			t.Fatalf("expected only the caller's forwards, got %s", got)
+		}
+	})
+
+	t.Run("an inverted date range is rejected", func(t *testing.T) {
+		out := post(t, adminToken, "/api/v1/tunnel/list", `{"createdFrom":200,"createdTo":100}`)
+		if out.Code == 0 || out.Msg != "请求参数错误" {
+			t.Fatalf("expected a parameter error, got code %d (%s)", out.Code, out.Msg)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package contract_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListNDJSONStreamingContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	now := time.Now().UnixMilli()
+	for i := 0; i < 25; i++ {
+		if _, err := repo.DB().Exec(`
+			INSERT INTO user(user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+			VALUES(?, 'pwd', 1, 2727251700000, 100, 0, 0, 1, 10, ?, ?, 1)
+		`, fmt.Sprintf("stream_user_%02d", i), now, now); err != nil {
+			t.Fatalf("insert user: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		insertContractNode(t, repo, fmt.Sprintf("stream-node-%d", i), fmt.Sprintf("10.0.9.%d", i+1), "20000-20010", fmt.Sprintf("stream-node-secret-%d", i), 0)
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	stream := func(t *testing.T, path, body string) []map[string]interface{} {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, server.URL+path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("build request: %v", err)
+		}
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Accept", "application/x-ndjson")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request %s: %v", path, err)
+		}
+		defer res.Body.Close()
+
+		if ct := res.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Fatalf("expected Content-Type application/x-ndjson, got %q", ct)
+		}
+		rows := make([]map[string]interface{}, 0)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			var row map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatalf("line is not valid JSON: %q: %v", line, err)
+			}
+			rows = append(rows, row)
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		return rows
+	}
+
+	t.Run("user list", func(t *testing.T) {
+		rows := stream(t, "/api/v1/user/list", `{}`)
+		if len(rows) != 25 {
+			t.Fatalf("expected 25 user rows, got %d", len(rows))
+		}
+		for _, row := range rows {
+			if _, ok := row["pwd"]; ok {
+				t.Fatalf("user row leaks password: %v", row)
+			}
+			if !strings.HasPrefix(valueAsString(row["user"]), "stream_user_") {
+				t.Fatalf("unexpected user row: %v", row)
+			}
+		}
+	})
+
+	t.Run("user list keyword", func(t *testing.T) {
+		rows := stream(t, "/api/v1/user/list", `{"keyword":"user_1"}`)
+		if len(rows) != 10 {
+			t.Fatalf("expected 10 filtered user rows, got %d", len(rows))
+		}
+	})
+
+	t.Run("node list", func(t *testing.T) {
+		rows := stream(t, "/api/v1/node/list", `{}`)
+		if len(rows) != 3 {
+			t.Fatalf("expected 3 node rows, got %d", len(rows))
+		}
+		for i, row := range rows {
+			if valueAsString(row["name"]) != fmt.Sprintf("stream-node-%d", i) {
+				t.Fatalf("unexpected node row %d: %v", i, row)
+			}
+		}
+	})
+
+	t.Run("plain JSON unchanged", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/user/list", bytes.NewBufferString(`{}`))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Fatalf("expected JSON content type, got %q", ct)
+		}
+		assertCode(t, rec, 0)
+	})
+}
@@ -0,0 +1,133 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestListPaginationContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	now := time.Now().UnixMilli()
+	for i := 0; i < 5; i++ {
+		insertContractNode(t, repo, fmt.Sprintf("page-node-%d", i), fmt.Sprintf("10.0.9.%d", i+1), "47000-47010", fmt.Sprintf("page-node-secret-%d", i), 1)
+	}
+	// Tunnels are listed by inx, then id: the expected order is t2, t0, t1.
+	for i, inx := range []int{1, 2, 0} {
+		if _, err := repo.DB().Exec(`
+			INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+			VALUES(?, 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, ?)
+		`, fmt.Sprintf("t%d", i), now, now, inx); err != nil {
+			t.Fatalf("insert tunnel: %v", err)
+		}
+	}
+	for i, owner := range []int64{2, 3, 2, 2} {
+		if _, err := repo.DB().Exec(`
+			INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+			VALUES(?, ?, ?, 1, '1.1.1.1:443', 'fifo', 0, 0, ?, ?, 1, 0)
+		`, owner, fmt.Sprintf("user-%d", owner), fmt.Sprintf("f%d", i), now, now); err != nil {
+			t.Fatalf("insert forward: %v", err)
+		}
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	insertContractUser(t, repo, 2, "user-2", 1)
+	userToken, err := contractToken(repo, 2, "user-2", 1, secret)
+	if err != nil {
+		t.Fatalf("generate user token: %v", err)
+	}
+	post := func(t *testing.T, token, path, body string) response.R {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+	type page struct {
+		total, page int
+		names       []string
+		next        string
+	}
+	list := func(t *testing.T, token, path, body string) page {
+		t.Helper()
+		out := post(t, token, path, body)
+		data, ok := out.Data.(map[string]interface{})
+		if out.Code != 0 || !ok {
+			t.Fatalf("%s %s: expected a paged result, got code %d (%s) %v", path, body, out.Code, out.Msg, out.Data)
+		}
+		items, _ := data["items"].([]interface{})
+		p := page{total: valueAsInt(data["total"]), page: valueAsInt(data["page"]), next: valueAsString(data["nextCursor"])}
+		for _, item := range items {
+			p.names = append(p.names, valueAsString(item.(map[string]interface{})["name"]))
+		}
+		return p
+	}
+
+	t.Run("offset pages report the total", func(t *testing.T) {
+		p := list(t, adminToken, "/api/v1/node/list", `{"page":3,"pageSize":2}`)
+		if p.total != 5 || p.page != 3 || len(p.names) != 1 || p.names[0] != "page-node-4" || p.next != "" {
+			t.Fatalf("expected the last page [page-node-4] of 5, got %+v", p)
+		}
+		p = list(t, adminToken, "/api/v1/tunnel/list", `{"page":1,"pageSize":2}`)
+		if p.total != 3 || fmt.Sprint(p.names) != "[t2 t0]" {
+			t.Fatalf("expected tunnels in inx order, got %+v", p)
+		}
+	})
+
+	t.Run("cursor walks the whole list", func(t *testing.T) {
+		seen := make([]string, 0)
+		body := `{"pageSize":2}`
+		for i := 0; i < 5; i++ {
+			p := list(t, adminToken, "/api/v1/tunnel/list", body)
+			seen = append(seen, p.names...)
+			if p.next == "" {
+				break
+			}
+			body = fmt.Sprintf(`{"pageSize":2,"cursor":%q}`, p.next)
+		}
+		if fmt.Sprint(seen) != "[t2 t0 t1]" {
+			t.Fatalf("expected every tunnel once in order, got %v", seen)
+		}
+	})
+
+	t.Run("forwards of other users stay hidden", func(t *testing.T) {
+		p := list(t, userToken, "/api/v1/forward/list", `{"pageSize":10}`)
+		if p.total != 3 || fmt.Sprint(p.names) != "[f0 f2 f3]" {
+			t.Fatalf("expected only the caller's forwards, got %+v", p)
+		}
+		p = list(t, adminToken, "/api/v1/forward/list", `{"pageSize":10}`)
+		if p.total != 4 {
+			t.Fatalf("expected the admin to see every forward, got %+v", p)
+		}
+	})
+
+	t.Run("unpaged requests keep the plain list", func(t *testing.T) {
+		out := post(t, adminToken, "/api/v1/speed-limit/list", "")
+		if _, ok := out.Data.([]interface{}); out.Code != 0 || !ok {
+			t.Fatalf("expected a plain list, got code %d %v", out.Code, out.Data)
+		}
+	})
+
+	t.Run("a forged cursor is rejected", func(t *testing.T) {
+		out := post(t, adminToken, "/api/v1/node/list", `{"cursor":"bm9wZQ"}`)
+		if out.Code == 0 || out.Msg != "请求参数错误" {
+			t.Fatalf("expected a parameter error, got code %d (%s)", out.Code, out.Msg)
+		}
+	})
+}
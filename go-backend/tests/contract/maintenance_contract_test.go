@@ -0,0 +1,93 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/sqlite"
+)
+
+func TestAdminRunMaintenanceRemovesFederationOrphansContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	now := time.Now().UnixMilli()
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+
+	nodeID := insertContractNode(t, repo, "maintenance-node", "10.60.0.1", "46000-46010", "maintenance-node-secret", 1)
+	tunnelRes, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, "maintenance-tunnel", 1.0, 2, "tls", 99999, now, now, 1, nil, 0)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, err := tunnelRes.LastInsertId()
+	if err != nil {
+		t.Fatalf("read tunnel id: %v", err)
+	}
+	shareID := insertPeerShare(t, repo, &sqlite.PeerShare{
+		Name:           "maintenance-share",
+		NodeID:         nodeID,
+		Token:          "maintenance-share-token",
+		PortRangeStart: 46000,
+		PortRangeEnd:   46010,
+		IsActive:       1,
+		CreatedTime:    now,
+		UpdatedTime:    now,
+	})
+
+	insertBinding := func(tunnelID int64, resourceKey string) {
+		t.Helper()
+		if _, err := repo.DB().Exec(`
+			INSERT INTO federation_tunnel_binding(tunnel_id, node_id, chain_type, hop_inx, remote_url, resource_key, remote_binding_id, allocated_port, status, created_time, updated_time)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, tunnelID, nodeID, 1, 0, "http://peer.example", resourceKey, "1", 46001, 1, now, now); err != nil {
+			t.Fatalf("insert federation_tunnel_binding: %v", err)
+		}
+	}
+	insertRuntime := func(shareID int64, key string) {
+		t.Helper()
+		if _, err := repo.DB().Exec(`
+			INSERT INTO peer_share_runtime(share_id, node_id, reservation_id, resource_key, binding_id, role, chain_name, service_name, protocol, strategy, port, target, applied, status, created_time, updated_time)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, shareID, nodeID, "res-"+key, "rk-"+key, key, "exit", "", "fed_svc_"+key, "tls", "round", 46002, "", 1, 1, now, now); err != nil {
+			t.Fatalf("insert peer_share_runtime: %v", err)
+		}
+	}
+	insertBinding(tunnelID, "binding-live")
+	insertBinding(tunnelID+1000, "binding-orphan")
+	insertRuntime(shareID, "live")
+	insertRuntime(shareID+1000, "orphan")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/maintenance/run", bytes.NewBufferString(`{}`))
+	req.Header.Set("Authorization", adminToken)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var out struct {
+		Code int                    `json:"code"`
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Code != 0 {
+		t.Fatalf("expected code 0, got %d", out.Code)
+	}
+	if removed := valueAsInt(out.Data["orphanedFederationRows"]); removed != 2 {
+		t.Fatalf("expected 2 orphaned rows removed, got %d", removed)
+	}
+
+	assertCount(t, repo, `SELECT COUNT(1) FROM federation_tunnel_binding WHERE resource_key = ?`, "binding-orphan", 0)
+	assertCount(t, repo, `SELECT COUNT(1) FROM federation_tunnel_binding WHERE resource_key = ?`, "binding-live", 1)
+	assertCount(t, repo, `SELECT COUNT(1) FROM peer_share_runtime WHERE binding_id = ?`, "orphan", 0)
+	assertCount(t, repo, `SELECT COUNT(1) FROM peer_share_runtime WHERE binding_id = ?`, "live", 1)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -170,7 +171,7 @@ func TestOpenAPISubStoreContracts(t *testing.T) {
 
 func TestSpeedLimitTunnelsRouteAlias(t *testing.T) {
 	secret := "contract-jwt-secret"
-	router, _ := setupContractRouter(t, secret)
+	router, repo := setupContractRouter(t, secret)
 
 	t.Run("missing token blocked", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/api/v1/speed-limit/tunnels", nil)
@@ -182,7 +183,7 @@ func TestSpeedLimitTunnelsRouteAlias(t *testing.T) {
 	})
 
 	t.Run("admin token receives success envelope", func(t *testing.T) {
-		token, err := auth.GenerateToken(1, "admin_user", 0, secret)
+		token, err := contractToken(repo, 1, "admin_user", 0, secret)
 		if err != nil {
 			t.Fatalf("generate token: %v", err)
 		}
@@ -207,11 +208,12 @@ func TestBackupExportImportRestoreContracts(t *testing.T) {
 	secret := "contract-jwt-secret"
 	router, repo := setupContractRouter(t, secret)
 
-	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
-	userToken, err := auth.GenerateToken(2, "normal_user", 1, secret)
+	insertContractUser(t, repo, 2, "normal_user", 1)
+	userToken, err := contractToken(repo, 2, "normal_user", 1, secret)
 	if err != nil {
 		t.Fatalf("generate user token: %v", err)
 	}
@@ -443,7 +445,45 @@ func setupContractRouter(t *testing.T, jwtSecret string) (http.Handler, *sqlite.
 	})
 
 	h := handler.New(repo, jwtSecret)
-	return httpserver.NewRouter(h, jwtSecret), repo
+	return httpserver.NewRouter(h), repo
+}
+
+// insertContractUser adds an enabled user with the given id, for tests that
+// sign in as someone other than the seeded admin.
+func insertContractUser(t *testing.T, repo *sqlite.Repository, id int64, username string, roleID int) {
+	t.Helper()
+	now := time.Now().UnixMilli()
+	if _, err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(?, ?, '', ?, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+	`, id, username, roleID, now, now); err != nil {
+		t.Fatalf("insert user %d: %v", id, err)
+	}
+}
+
+// contractToken issues a login token for userID under a new session, as
+// /user/login does. Requests with it pass only while the user exists and is
+// enabled.
+func contractToken(repo *sqlite.Repository, userID int64, username string, roleID int, secret string) (string, error) {
+	return contractTokenWithPermissions(repo, userID, username, roleID, 0, secret)
+}
+
+// contractTokenWithPermissions is contractToken carrying delegated
+// permission bits.
+func contractTokenWithPermissions(repo *sqlite.Repository, userID int64, username string, roleID int, permissions int64, secret string) (string, error) {
+	return contractTokenForAudience(repo, userID, username, roleID, permissions, auth.DefaultAudience, secret)
+}
+
+// contractTokenForAudience is contractTokenWithPermissions issued for
+// audience.
+func contractTokenForAudience(repo *sqlite.Repository, userID int64, username string, roleID int, permissions int64, audience string, secret string) (string, error) {
+	now := time.Now()
+	hash := fmt.Sprintf("contract-session-%d-%d", userID, now.UnixNano())
+	sid, err := repo.CreateRefreshToken(userID, hash, now.Add(auth.RefreshTokenTTL).UnixMilli(), now.UnixMilli(), sqlite.SessionClient{})
+	if err != nil {
+		return "", err
+	}
+	return auth.GenerateAccessToken(userID, username, roleID, permissions, audience, sid, secret)
 }
 
 func TestOpenMigratesLegacyNodeDualStackColumns(t *testing.T) {
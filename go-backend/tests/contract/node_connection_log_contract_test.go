@@ -0,0 +1,106 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestAdminNodeConnectionHistoryContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	nodeID := insertContractNode(t, repo, "history-node", "10.0.0.41", "33000-33010", "history-node-secret", 0)
+
+	start := time.Now().UnixMilli()
+	for i := 0; i < 2; i++ {
+		stop := startMockNodeSessionWithHook(t, server.URL, "history-node-secret", nil)
+		waitNodeStatus(t, repo, nodeID, 1)
+		stop()
+		waitNodeStatus(t, repo, nodeID, 0)
+	}
+	end := time.Now().UnixMilli()
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, body string) response.R {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if out.Code != 0 {
+			t.Fatalf("expected code 0, got %d (%s)", out.Code, out.Msg)
+		}
+		return out
+	}
+
+	t.Run("history lists two connect and two disconnect events", func(t *testing.T) {
+		out := post("/api/v1/admin/node/connection-history", fmt.Sprintf(`{"nodeId":%d,"page":1,"pageSize":10}`, nodeID))
+		data := out.Data.(map[string]interface{})
+		if valueAsInt(data["total"]) != 4 {
+			t.Fatalf("expected 4 history rows, got %v", data["total"])
+		}
+		list, _ := data["list"].([]interface{})
+		if len(list) != 4 {
+			t.Fatalf("expected 4 history items, got %d", len(list))
+		}
+
+		events := map[string]int{}
+		lastTime := int64(end + 1)
+		for _, raw := range list {
+			item := raw.(map[string]interface{})
+			events[item["event"].(string)]++
+			if item["remoteIp"] != "127.0.0.1" {
+				t.Fatalf("expected remote ip 127.0.0.1, got %v", item["remoteIp"])
+			}
+			created := int64(item["createdTime"].(float64))
+			if created < start || created > end {
+				t.Fatalf("createdTime %d outside [%d, %d]", created, start, end)
+			}
+			if created > lastTime {
+				t.Fatalf("history not ordered newest first")
+			}
+			lastTime = created
+		}
+		if events["connect"] != 2 || events["disconnect"] != 2 {
+			t.Fatalf("expected 2 connect and 2 disconnect events, got %v", events)
+		}
+		if first := list[0].(map[string]interface{}); first["event"] != "disconnect" {
+			t.Fatalf("expected newest event to be disconnect, got %v", first["event"])
+		}
+	})
+
+	t.Run("node list exposes last seen and last ip", func(t *testing.T) {
+		out := post("/api/v1/node/list", `{}`)
+		nodes, _ := out.Data.([]interface{})
+		for _, raw := range nodes {
+			node := raw.(map[string]interface{})
+			if int64(valueAsInt(node["id"])) != nodeID {
+				continue
+			}
+			if node["lastIp"] != "127.0.0.1" {
+				t.Fatalf("expected lastIp 127.0.0.1, got %v", node["lastIp"])
+			}
+			seen := int64(node["lastSeenAt"].(float64))
+			if seen < start || seen > end {
+				t.Fatalf("lastSeenAt %d outside [%d, %d]", seen, start, end)
+			}
+			return
+		}
+		t.Fatalf("node %d missing from node list", nodeID)
+	})
+}
@@ -0,0 +1,127 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"go-backend/internal/http/response"
+)
+
+func TestAdminNodeConnectivityTestContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	var flowHits atomic.Int64
+	var flowBytes atomic.Int64
+	flowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/flow/test" {
+			http.NotFound(w, r)
+			return
+		}
+		n, _ := io.Copy(io.Discard, r.Body)
+		flowHits.Add(1)
+		flowBytes.Add(n)
+		_, _ = w.Write([]byte("test"))
+	}))
+	defer flowServer.Close()
+	_, flowPort, _ := net.SplitHostPort(flowServer.Listener.Addr().String())
+
+	httpNodeID := insertContractNode(t, repo, "http-node", "127.0.0.1", flowPort, "http-node-secret", 0)
+	// Nothing listens on port 1, so this node cannot be reached.
+	deadNodeID := insertContractNode(t, repo, "dead-node", "127.0.0.1", "1", "dead-node-secret", 0)
+	wsNodeID := insertContractNode(t, repo, "ws-node", "10.0.0.99", "44000-44010", "ws-node-secret", 0)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	runTest := func(body string) map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/node/test", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if out.Code != 0 {
+			t.Fatalf("expected code 0, got %d (%s)", out.Code, out.Msg)
+		}
+		return out.Data.(map[string]interface{})
+	}
+
+	t.Run("ping falls back to flow test", func(t *testing.T) {
+		data := runTest(fmt.Sprintf(`{"nodeId":%d,"testType":"ping","durationMs":1000}`, httpNodeID))
+		if data["reachable"] != true || data["via"] != "http" {
+			t.Fatalf("expected reachable over http, got %v", data)
+		}
+		if latency, ok := data["latencyMs"].(float64); !ok || latency < 0 {
+			t.Fatalf("expected a latency, got %v", data["latencyMs"])
+		}
+		if flowHits.Load() != 1 {
+			t.Fatalf("expected 1 flow test request, got %d", flowHits.Load())
+		}
+	})
+
+	t.Run("latency sends five probes", func(t *testing.T) {
+		flowHits.Store(0)
+		data := runTest(fmt.Sprintf(`{"nodeId":%d,"testType":"latency"}`, httpNodeID))
+		if data["reachable"] != true || valueAsInt(data["received"]) != 5 || flowHits.Load() != 5 {
+			t.Fatalf("expected 5 answered probes, got %v (%d hits)", data, flowHits.Load())
+		}
+		minMs, maxMs, avgMs := data["minMs"].(float64), data["maxMs"].(float64), data["avgMs"].(float64)
+		if minMs > avgMs || avgMs > maxMs {
+			t.Fatalf("expected min <= avg <= max, got %v", data)
+		}
+	})
+
+	t.Run("throughput sends the test bytes", func(t *testing.T) {
+		flowBytes.Store(0)
+		data := runTest(fmt.Sprintf(`{"nodeId":%d,"testType":"throughput","bytes":65536}`, httpNodeID))
+		if data["reachable"] != true || valueAsInt(data["bytes"]) != 65536 || flowBytes.Load() != 65536 {
+			t.Fatalf("expected 65536 bytes delivered, got %v (%d received)", data, flowBytes.Load())
+		}
+		if rate, _ := data["bytesPerSec"].(float64); rate <= 0 {
+			t.Fatalf("expected a throughput, got %v", data["bytesPerSec"])
+		}
+	})
+
+	t.Run("unreachable node is reported", func(t *testing.T) {
+		data := runTest(fmt.Sprintf(`{"nodeId":%d,"testType":"ping","durationMs":500}`, deadNodeID))
+		if data["reachable"] != false || data["error"] == nil {
+			t.Fatalf("expected unreachable with an error, got %v", data)
+		}
+	})
+
+	t.Run("connected node is pinged over websocket", func(t *testing.T) {
+		var mu sync.Mutex
+		var pings int
+		stop := startMockNodeSessionWithPayloadHook(t, server.URL, "ws-node-secret", func(cmdType string, _ json.RawMessage) {
+			if cmdType == "Ping" {
+				mu.Lock()
+				pings++
+				mu.Unlock()
+			}
+		})
+		defer stop()
+		waitNodeStatus(t, repo, wsNodeID, 1)
+
+		data := runTest(fmt.Sprintf(`{"nodeId":%d,"testType":"ping"}`, wsNodeID))
+		mu.Lock()
+		defer mu.Unlock()
+		if data["reachable"] != true || data["via"] != "ws" || pings != 1 {
+			t.Fatalf("expected one websocket ping, got %v (%d pings)", data, pings)
+		}
+	})
+}
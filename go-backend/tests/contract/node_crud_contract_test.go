@@ -0,0 +1,123 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestNodeCRUDContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, body string) response.R {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	t.Run("create validates ports and listen addresses", func(t *testing.T) {
+		for _, extra := range []string{
+			`"port":"70000-70010"`,
+			`"port":"2000-1000"`,
+			`"port":"1000-2000,abc"`,
+			`"tcpListenAddr":"example.com"`,
+			`"udpListenAddr":"[0.0.0.0]"`,
+		} {
+			body := fmt.Sprintf(`{"name":"invalid-node","serverIp":"10.0.0.80",%s}`, extra)
+			if out := post("/api/v1/node/create", body); out.Code == 0 {
+				t.Fatalf("expected %s to be rejected", extra)
+			}
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM node WHERE name = ?`, "invalid-node", 0)
+	})
+
+	t.Run("create returns the install command with the secret", func(t *testing.T) {
+		out := post("/api/v1/node/create", `{"name":"no-panel-ip","serverIp":"10.0.0.81"}`)
+		data, _ := out.Data.(map[string]interface{})
+		if out.Code != 0 || valueAsInt(data["id"]) <= 0 {
+			t.Fatalf("create node: code %d (%s) %v", out.Code, out.Msg, out.Data)
+		}
+		if _, ok := data["installCommand"]; ok {
+			t.Fatalf("expected no install command before the panel address is set, got %v", data)
+		}
+
+		if err := repo.UpsertConfig("ip", "panel.example.com:6365", time.Now().UnixMilli()); err != nil {
+			t.Fatalf("set panel ip: %v", err)
+		}
+		out = post("/api/v1/node/create", `{"name":"installable","serverIp":"10.0.0.82","port":"20000-20010, 30000","tcpListenAddr":"0.0.0.0","udpListenAddr":"::"}`)
+		data, _ = out.Data.(map[string]interface{})
+		if out.Code != 0 {
+			t.Fatalf("create node: code %d (%s)", out.Code, out.Msg)
+		}
+		cmd := valueAsString(data["installCommand"])
+		var stored string
+		if err := repo.DB().QueryRow(`SELECT secret FROM node WHERE id = ?`, valueAsInt(data["id"])).Scan(&stored); err != nil {
+			t.Fatalf("query secret: %v", err)
+		}
+		if stored, err = repo.OpenSecret(stored); err != nil {
+			t.Fatalf("open secret: %v", err)
+		}
+		if !strings.Contains(cmd, "-a panel.example.com:6365") || !strings.Contains(cmd, "-s "+stored) {
+			t.Fatalf("expected the install command to carry the panel address and secret, got %q", cmd)
+		}
+		if install := post("/api/v1/node/install", fmt.Sprintf(`{"id":%d}`, valueAsInt(data["id"]))); valueAsString(install.Data) != cmd {
+			t.Fatalf("expected node/install to return the same command, got %v", install.Data)
+		}
+	})
+
+	t.Run("update validates the port range", func(t *testing.T) {
+		nodeID := insertContractNode(t, repo, "update-node", "10.0.0.83", "40000-40010", "update-node-secret", 0)
+		body := fmt.Sprintf(`{"id":%d,"name":"update-node","serverIp":"10.0.0.83","port":"0-10"}`, nodeID)
+		if out := post("/api/v1/node/update", body); out.Code == 0 {
+			t.Fatalf("expected port 0 to be rejected")
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM node WHERE port = '40000-40010' AND id = ?`, nodeID, 1)
+	})
+
+	t.Run("delete removes the node's hops and ports", func(t *testing.T) {
+		nodeID := insertContractNode(t, repo, "doomed-node", "10.0.0.84", "41000-41010", "doomed-node-secret", 0)
+		now := time.Now().UnixMilli()
+		res, err := repo.DB().Exec(`
+			INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+			VALUES('doomed-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+		`, now, now)
+		if err != nil {
+			t.Fatalf("insert tunnel: %v", err)
+		}
+		tunnelID, _ := res.LastInsertId()
+		if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, 0, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+			t.Fatalf("insert chain: %v", err)
+		}
+		if _, err := repo.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(1, ?, 41001)`, nodeID); err != nil {
+			t.Fatalf("insert forward port: %v", err)
+		}
+
+		if out := post("/api/v1/node/delete", fmt.Sprintf(`{"id":%d}`, nodeID)); out.Code != 0 {
+			t.Fatalf("delete node: code %d (%s)", out.Code, out.Msg)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM chain_tunnel WHERE node_id = ?`, nodeID, 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward_port WHERE node_id = ?`, nodeID, 0)
+		if out := post("/api/v1/node/delete", fmt.Sprintf(`{"id":%d}`, nodeID)); out.Msg != "节点不存在" {
+			t.Fatalf("expected deleting it again to report a missing node, got code %d (%s)", out.Code, out.Msg)
+		}
+	})
+}
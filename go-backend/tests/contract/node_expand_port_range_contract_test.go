@@ -0,0 +1,87 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go-backend/internal/http/response"
+)
+
+func TestAdminNodeExpandPortRangeContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	nodeID := insertContractNode(t, repo, "expand-node", "10.0.0.91", "30000-30010", "expand-node-secret", 0)
+	insertContractNode(t, repo, "neighbour-node", "10.0.0.91", "31000-31010", "neighbour-node-secret", 0)
+
+	var mu sync.Mutex
+	var payloads []json.RawMessage
+	stop := startMockNodeSessionWithPayloadHook(t, server.URL, "expand-node-secret", func(cmdType string, data json.RawMessage) {
+		if cmdType != "UpdatePortRange" {
+			return
+		}
+		mu.Lock()
+		payloads = append(payloads, data)
+		mu.Unlock()
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	expand := func(start, end int) response.R {
+		body := fmt.Sprintf(`{"nodeId":%d,"newPortRangeStart":%d,"newPortRangeEnd":%d}`, nodeID, start, end)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/node/expand-port-range", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	t.Run("rejects shrinking range", func(t *testing.T) {
+		if out := expand(30002, 30020); out.Code == 0 {
+			t.Fatalf("expected non-superset range to be rejected")
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM node WHERE id = ? AND port = '30000-30010'`, nodeID, 1)
+	})
+
+	t.Run("rejects overlap with node on same server", func(t *testing.T) {
+		if out := expand(29990, 31005); out.Code == 0 {
+			t.Fatalf("expected overlapping range to be rejected")
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM node WHERE id = ? AND port = '30000-30010'`, nodeID, 1)
+	})
+
+	t.Run("expands range and notifies node", func(t *testing.T) {
+		if out := expand(29990, 30020); out.Code != 0 {
+			t.Fatalf("expand port range: code %d (%s)", out.Code, out.Msg)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM node WHERE id = ? AND port = '29990-30020'`, nodeID, 1)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(payloads) != 1 {
+			t.Fatalf("expected one UpdatePortRange command, got %d", len(payloads))
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(payloads[0], &got); err != nil {
+			t.Fatalf("decode payload: %v", err)
+		}
+		if got["portRange"] != "29990-30020" || valueAsInt(got["portStart"]) != 29990 || valueAsInt(got["portEnd"]) != 30020 {
+			t.Fatalf("unexpected UpdatePortRange payload %v", got)
+		}
+	})
+}
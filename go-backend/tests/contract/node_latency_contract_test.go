@@ -0,0 +1,112 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestNodeLatencyMatrixAndOptimalPathContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	entry := insertContractNode(t, repo, "latency-entry", "10.0.8.1", "20000-20010", "latency-entry-secret", 1)
+	middleA := insertContractNode(t, repo, "latency-middle-a", "10.0.8.2", "20000-20010", "latency-middle-a-secret", 1)
+	middleB := insertContractNode(t, repo, "latency-middle-b", "10.0.8.3", "20000-20010", "latency-middle-b-secret", 1)
+	exit := insertContractNode(t, repo, "latency-exit", "10.0.8.4", "20000-20010", "latency-exit-secret", 1)
+
+	now := time.Now().UnixMilli()
+	seed := []struct {
+		from, to int64
+		ms       float64
+	}{
+		{entry, exit, 50},
+		{entry, middleA, 10},
+		{middleA, exit, 10},
+		{entry, middleB, 5},
+		{middleB, middleA, 2},
+		{middleB, exit, 30},
+	}
+	for _, s := range seed {
+		if err := repo.UpsertNodeLatency(s.from, s.to, s.ms, now); err != nil {
+			t.Fatalf("seed latency: %v", err)
+		}
+	}
+	// A newer measurement replaces the old one.
+	if err := repo.UpsertNodeLatency(entry, exit, 40, now+1); err != nil {
+		t.Fatalf("update latency: %v", err)
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, body string) response.R {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+	optimalPath := func(t *testing.T, middles []int64) ([]int64, float64) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]interface{}{"entryNodeId": entry, "exitNodeId": exit, "middleNodeIds": middles})
+		out := post("/api/v1/admin/tunnel/optimal-path", string(body))
+		if out.Code != 0 {
+			t.Fatalf("optimal path: code %d (%s)", out.Code, out.Msg)
+		}
+		data, _ := out.Data.(map[string]interface{})
+		raw, _ := data["path"].([]interface{})
+		path := make([]int64, 0, len(raw))
+		for _, v := range raw {
+			path = append(path, int64(valueAsInt(v)))
+		}
+		latency, _ := data["latencyMs"].(float64)
+		return path, latency
+	}
+
+	t.Run("matrix", func(t *testing.T) {
+		out := post("/api/v1/admin/node/latency-matrix", `{}`)
+		items, _ := out.Data.([]interface{})
+		if len(items) != len(seed) {
+			t.Fatalf("expected %d matrix entries, got %d", len(seed), len(items))
+		}
+		for _, item := range items {
+			m := item.(map[string]interface{})
+			if int64(valueAsInt(m["from"])) == entry && int64(valueAsInt(m["to"])) == exit && m["latencyMs"] != float64(40) {
+				t.Fatalf("expected updated entry->exit latency 40, got %v", m["latencyMs"])
+			}
+		}
+	})
+
+	t.Run("picks lowest latency chain", func(t *testing.T) {
+		path, latency := optimalPath(t, []int64{middleA, middleB})
+		if fmt.Sprint(path) != fmt.Sprint([]int64{entry, middleB, middleA, exit}) || latency != 17 {
+			t.Fatalf("expected path entry->B->A->exit (17ms), got %v (%vms)", path, latency)
+		}
+	})
+
+	t.Run("only uses offered middle nodes", func(t *testing.T) {
+		path, latency := optimalPath(t, []int64{middleA})
+		if fmt.Sprint(path) != fmt.Sprint([]int64{entry, middleA, exit}) || latency != 20 {
+			t.Fatalf("expected path entry->A->exit (20ms), got %v (%vms)", path, latency)
+		}
+	})
+
+	t.Run("no path", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]interface{}{"entryNodeId": exit, "exitNodeId": entry})
+		if out := post("/api/v1/admin/tunnel/optimal-path", string(body)); out.Code == 0 {
+			t.Fatalf("expected error without a measured path")
+		}
+	})
+}
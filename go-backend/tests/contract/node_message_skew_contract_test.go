@@ -0,0 +1,141 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	httpserver "go-backend/internal/http"
+	"go-backend/internal/http/handler"
+	"go-backend/internal/security"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestFlowUploadTimestampWindowContract(t *testing.T) {
+	router, repo := setupContractRouter(t, "contract-jwt-secret")
+	insertContractNode(t, repo, "skew-node", "10.0.0.97", "7100-7110", "skew-node-secret", 1)
+
+	crypto, err := security.NewAESCrypto("skew-node-secret")
+	if err != nil {
+		t.Fatalf("new crypto: %v", err)
+	}
+	upload := func(version int, ts int64) int {
+		t.Helper()
+		envelope := map[string]interface{}{"encrypted": true, "timestamp": ts}
+		var data string
+		if version == 2 {
+			envelope["v"] = 2
+			data, err = crypto.EncryptWithAAD([]byte(`[]`), []byte("flvx-flow:v2:"+strconv.FormatInt(ts, 10)))
+		} else {
+			data, err = crypto.Encrypt([]byte(`[]`))
+		}
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		envelope["data"] = data
+		raw, _ := json.Marshal(envelope)
+		req := httptest.NewRequest(http.MethodPost, "/flow/upload?secret=skew-node-secret", bytes.NewReader(raw))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	now := time.Now().Unix()
+	if code := upload(1, now-int64((10*time.Minute).Seconds())); code != http.StatusForbidden {
+		t.Fatalf("expected a stale v1 report to be rejected, got %d", code)
+	}
+	if code := upload(1, 0); code != http.StatusForbidden {
+		t.Fatalf("expected a v1 report without a timestamp to be rejected, got %d", code)
+	}
+	if code := upload(1, now-120); code != http.StatusOK {
+		t.Fatalf("expected a v1 report inside the default window to be accepted, got %d", code)
+	}
+
+	if err := repo.UpsertConfig("node_message_max_skew_sec", "60", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("set window: %v", err)
+	}
+	if code := upload(2, now-120); code != http.StatusForbidden {
+		t.Fatalf("expected a v2 report outside the configured window to be rejected, got %d", code)
+	}
+	if code := upload(1, now+120); code != http.StatusForbidden {
+		t.Fatalf("expected a report from the future to be rejected, got %d", code)
+	}
+	if code := upload(2, now-30); code != http.StatusOK {
+		t.Fatalf("expected a v2 report inside the configured window to be accepted, got %d", code)
+	}
+}
+
+func TestNodeWebsocketDropsStaleMessagesContract(t *testing.T) {
+	repo, err := sqlite.Open(filepath.Join(t.TempDir(), "contract.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	server := httptest.NewServer(httpserver.NewRouter(handler.New(repo, "contract-jwt-secret")))
+	defer server.Close()
+
+	nodeID := insertContractNode(t, repo, "ws-skew-node", "10.0.0.98", "7200-7210", "ws-skew-secret", 0)
+	peerID := insertContractNode(t, repo, "ws-skew-peer", "10.0.0.99", "7300-7310", "ws-skew-peer-secret", 0)
+
+	u, _ := url.Parse(server.URL)
+	u.Scheme = "ws"
+	u.Path = "/system-info"
+	u.RawQuery = url.Values{"type": {"1"}, "secret": {"ws-skew-secret"}, "version": {"v1"}}.Encode()
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatalf("dial node websocket: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	crypto, err := security.NewAESCrypto("ws-skew-secret")
+	if err != nil {
+		t.Fatalf("new crypto: %v", err)
+	}
+	sendLatency := func(ts int64, latency float64) {
+		t.Helper()
+		plain, _ := json.Marshal(map[string]interface{}{
+			"type":    "LatencyResult",
+			"results": []map[string]interface{}{{"nodeId": peerID, "latencyMs": latency, "success": true}},
+		})
+		data, err := crypto.Encrypt(plain)
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		raw, _ := json.Marshal(map[string]interface{}{"encrypted": true, "data": data, "timestamp": ts})
+		if err := conn.WriteMessage(websocket.TextMessage, raw); err != nil {
+			t.Fatalf("write message: %v", err)
+		}
+	}
+	latencyOf := func() float64 {
+		var latency float64
+		_ = repo.DB().QueryRow(`SELECT latency_ms FROM node_latency WHERE from_node_id = ? AND to_node_id = ?`, nodeID, peerID).Scan(&latency)
+		return latency
+	}
+
+	// The stale message is dropped; the fresh one after it is applied.
+	sendLatency(time.Now().Add(-time.Hour).Unix(), 99)
+	sendLatency(time.Now().Unix(), 12)
+	deadline := time.Now().Add(2 * time.Second)
+	for latencyOf() == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := latencyOf(); got != 12 {
+		t.Fatalf("expected only the fresh latency to be recorded, got %v", got)
+	}
+}
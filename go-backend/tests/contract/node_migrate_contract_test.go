@@ -0,0 +1,216 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestAdminNodeMigrateForwardsContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	now := time.Now().UnixMilli()
+	sourceNodeID := insertContractNode(t, repo, "source-node", "10.0.0.21", "30000-30010", "migrate-source-secret", 0)
+	targetNodeID := insertContractNode(t, repo, "target-node", "10.0.0.22", "31000-31010", "migrate-target-secret", 0)
+	exitNodeID := insertContractNode(t, repo, "exit-node", "10.0.0.23", "32000-32010", "migrate-exit-secret", 0)
+
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('migrate-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, sourceNodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+
+	// A chain tunnel: its forwards need chains_<id> on whichever node is
+	// the entry.
+	res, err = repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('migrate-chain-tunnel', 1.0, 2, 'tls', 99999, ?, ?, 1, NULL, 1)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert chain tunnel: %v", err)
+	}
+	chainTunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, chainTunnelID, sourceNodeID); err != nil {
+		t.Fatalf("insert chain tunnel entry: %v", err)
+	}
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 3, ?, 32000, 'round', 0, 'tls')`, chainTunnelID, exitNodeID); err != nil {
+		t.Fatalf("insert chain tunnel exit: %v", err)
+	}
+
+	forwardIDs := make([]int64, 0, 4)
+	for i := 0; i < 4; i++ {
+		forwardTunnelID := tunnelID
+		if i == 3 {
+			forwardTunnelID = chainTunnelID
+		}
+		res, err := repo.DB().Exec(`
+			INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+			VALUES(1, 'admin_user', ?, ?, '1.1.1.1:443', 'fifo', 0, 0, ?, ?, 1, ?)
+		`, fmt.Sprintf("migrate-forward-%d", i), forwardTunnelID, now, now, i)
+		if err != nil {
+			t.Fatalf("insert forward: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		forwardIDs = append(forwardIDs, id)
+		if _, err := repo.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, ?)`, id, sourceNodeID, 31000+i); err != nil {
+			t.Fatalf("insert forward_port: %v", err)
+		}
+	}
+	// Occupy the first port on the target so one forward must be reassigned.
+	if _, err := repo.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(999, ?, 31000)`, targetNodeID); err != nil {
+		t.Fatalf("insert blocking forward_port: %v", err)
+	}
+
+	var mu sync.Mutex
+	commands := map[string][]string{}
+	record := func(node string) func(string) {
+		return func(cmdType string) {
+			mu.Lock()
+			defer mu.Unlock()
+			commands[node] = append(commands[node], cmdType)
+		}
+	}
+	stopSource := startMockNodeSessionWithHook(t, server.URL, "migrate-source-secret", record("source"))
+	defer stopSource()
+	stopTarget := startMockNodeSessionWithHook(t, server.URL, "migrate-target-secret", record("target"))
+	defer stopTarget()
+	waitNodeStatus(t, repo, sourceNodeID, 1)
+	waitNodeStatus(t, repo, targetNodeID, 1)
+	// The source node gets its chain and four forwards re-dispatched on
+	// connect; let that settle so only migration commands are recorded below.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		restored := len(commands["source"])
+		mu.Unlock()
+		if restored == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 5 re-dispatched commands on connect, got %d", restored)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	mu.Lock()
+	commands = map[string][]string{}
+	mu.Unlock()
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	migrate := func(dryRun bool) response.R {
+		body := fmt.Sprintf(`{"sourceNodeId":%d,"targetNodeId":%d,"dryRun":%t}`, sourceNodeID, targetNodeID, dryRun)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/node/migrate-forwards", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if out.Code != 0 {
+			t.Fatalf("expected code 0, got %d (%s)", out.Code, out.Msg)
+		}
+		return out
+	}
+
+	t.Run("dry run reports plan without changes", func(t *testing.T) {
+		out := migrate(true)
+		data := out.Data.(map[string]interface{})
+		if forwards, _ := data["forwards"].([]interface{}); len(forwards) != 4 {
+			t.Fatalf("expected 4 planned forwards, got %v", data["forwards"])
+		}
+		if conflicts, _ := data["conflicts"].([]interface{}); len(conflicts) != 1 {
+			t.Fatalf("expected 1 port conflict, got %v", data["conflicts"])
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward_port WHERE node_id = ?`, sourceNodeID, 4)
+		mu.Lock()
+		defer mu.Unlock()
+		if len(commands["source"])+len(commands["target"]) != 0 {
+			t.Fatalf("dry run must not dispatch commands, got %v", commands)
+		}
+	})
+
+	t.Run("live run moves ports and dispatches services", func(t *testing.T) {
+		migrate(false)
+
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward_port WHERE node_id = ?`, sourceNodeID, 0)
+		for _, id := range forwardIDs {
+			var nodeID int64
+			var port int
+			if err := repo.DB().QueryRow(`SELECT node_id, port FROM forward_port WHERE forward_id = ?`, id).Scan(&nodeID, &port); err != nil {
+				t.Fatalf("query forward_port for %d: %v", id, err)
+			}
+			if nodeID != targetNodeID {
+				t.Fatalf("forward %d expected node %d, got %d", id, targetNodeID, nodeID)
+			}
+			if port < 31000 || port > 31010 {
+				t.Fatalf("forward %d port %d outside target range", id, port)
+			}
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward_port WHERE port = 31000 AND node_id = ?`, targetNodeID, 1)
+		// The target replaces the source as entry node of both tunnels.
+		assertCount(t, repo, `SELECT COUNT(1) FROM chain_tunnel WHERE chain_type = 1 AND node_id = ?`, sourceNodeID, 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM chain_tunnel WHERE chain_type = 1 AND node_id = ?`, targetNodeID, 2)
+
+		mu.Lock()
+		defer mu.Unlock()
+		target := commands["target"]
+		if len(target) == 0 || target[0] != "AddChains" {
+			t.Fatalf("expected the chain on target before any service, got %v", target)
+		}
+		if countCommand(target, "AddService") != 4 {
+			t.Fatalf("expected 4 AddService on target, got %v", target)
+		}
+		if countCommand(commands["source"], "DeleteService") < 4 {
+			t.Fatalf("expected DeleteService on source for each forward, got %v", commands["source"])
+		}
+		if countCommand(commands["source"], "DeleteChains") != 1 {
+			t.Fatalf("expected the chain removed from source, got %v", commands["source"])
+		}
+	})
+
+	t.Run("editing a migrated forward keeps it on the target", func(t *testing.T) {
+		body := fmt.Sprintf(`{"id":%d,"name":"migrate-forward-1","tunnelId":%d,"remoteAddr":"1.1.1.2:443","strategy":"fifo"}`, forwardIDs[1], tunnelID)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/forward/update", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assertCode(t, rec, 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward_port WHERE forward_id = ?`, forwardIDs[1], 1)
+		var nodeID int64
+		if err := repo.DB().QueryRow(`SELECT node_id FROM forward_port WHERE forward_id = ?`, forwardIDs[1]).Scan(&nodeID); err != nil {
+			t.Fatalf("query forward_port: %v", err)
+		}
+		if nodeID != targetNodeID {
+			t.Fatalf("expected forward to stay on node %d, got %d", targetNodeID, nodeID)
+		}
+	})
+}
+
+func countCommand(commands []string, cmdType string) int {
+	n := 0
+	for _, c := range commands {
+		if c == cmdType {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,145 @@
+package contract_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	httpserver "go-backend/internal/http"
+	"go-backend/internal/http/handler"
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestNodeMutualTLSContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	repo, err := sqlite.Open(filepath.Join(t.TempDir(), "contract.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	h := handler.New(repo, secret)
+	router := httpserver.NewRouter(h)
+
+	tlsConfig, err := h.NodeTLSConfig([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("node tls config: %v", err)
+	}
+	server := httptest.NewUnstartedServer(router)
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	nodeID := insertContractNode(t, repo, "mtls-node", "10.0.0.97", "7100-7110", "mtls-node-secret", 1)
+	insertContractNode(t, repo, "other-node", "10.0.0.98", "7200-7210", "other-node-secret", 1)
+
+	admin := func(path string, id int64) response.R {
+		t.Helper()
+		body, _ := json.Marshal(map[string]interface{}{"id": id})
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+	clientFor := func(data map[string]interface{}) *http.Client {
+		t.Helper()
+		pair, err := tls.X509KeyPair([]byte(valueAsString(data["cert"])), []byte(valueAsString(data["key"])))
+		if err != nil {
+			t.Fatalf("load client cert: %v", err)
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM([]byte(valueAsString(data["ca"])))
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			Certificates: []tls.Certificate{pair},
+			RootCAs:      roots,
+		}}}
+	}
+	post := func(client *http.Client, path string) (int, []byte) {
+		t.Helper()
+		resp, err := client.Post(server.URL+path, "application/json", bytes.NewBufferString(`[]`))
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+
+	if out := admin("/api/v1/node/cert/renew", nodeID); out.Code == 0 {
+		t.Fatalf("expected renewal without an issued certificate to fail")
+	}
+	out := admin("/api/v1/node/cert/issue", nodeID)
+	if out.Code != 0 {
+		t.Fatalf("issue certificate: %d %s", out.Code, out.Msg)
+	}
+	issued := out.Data.(map[string]interface{})
+	assertCount(t, repo, `SELECT COUNT(1) FROM node WHERE client_cert_serial = ?`, valueAsString(issued["serial"]), 1)
+	client := clientFor(issued)
+
+	if code, body := post(client, "/flow/upload?secret=mtls-node-secret"); code != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("expected the node's certificate to be accepted, got %d %s", code, body)
+	}
+	if code, _ := post(client, "/flow/upload?secret=other-node-secret"); code != http.StatusForbidden {
+		t.Fatalf("expected the certificate to be refused for another node, got %d", code)
+	}
+
+	noCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	if resp, err := noCert.Post(server.URL+"/flow/upload?secret=mtls-node-secret", "application/json", nil); err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected the TLS listener to require a client certificate")
+	}
+
+	t.Run("node renews its own certificate", func(t *testing.T) {
+		code, body := post(client, "/flow/cert/renew?secret=mtls-node-secret")
+		if code != http.StatusOK {
+			t.Fatalf("renew: %d %s", code, body)
+		}
+		var renewed response.R
+		if err := json.Unmarshal(body, &renewed); err != nil || renewed.Code != 0 {
+			t.Fatalf("renew response %s", body)
+		}
+		data := renewed.Data.(map[string]interface{})
+		if valueAsString(data["serial"]) == valueAsString(issued["serial"]) {
+			t.Fatalf("expected a new serial")
+		}
+		if code, _ := post(client, "/flow/upload?secret=mtls-node-secret"); code != http.StatusForbidden {
+			t.Fatalf("expected the replaced certificate to be refused, got %d", code)
+		}
+		client = clientFor(data)
+		if code, _ := post(client, "/flow/upload?secret=mtls-node-secret"); code != http.StatusOK {
+			t.Fatalf("expected the renewed certificate to be accepted, got %d", code)
+		}
+	})
+
+	t.Run("required mode refuses plain node requests", func(t *testing.T) {
+		if err := repo.UpsertConfig("node_mtls_required", "true", time.Now().UnixMilli()); err != nil {
+			t.Fatalf("set node_mtls_required: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/flow/upload?secret=mtls-node-secret", bytes.NewBufferString(`[]`))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected a plain upload to be refused, got %d", rec.Code)
+		}
+		// Admin endpoints are not affected.
+		if out := admin("/api/v1/node/cert/issue", nodeID); out.Code != 0 {
+			t.Fatalf("expected admin issuance to keep working, got %d %s", out.Code, out.Msg)
+		}
+	})
+}
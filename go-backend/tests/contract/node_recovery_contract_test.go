@@ -0,0 +1,105 @@
+package contract_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNodeReconnectRedispatchesActiveForwardsContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "recovery-node", "10.0.0.61", "32000-32010", "recovery-node-secret", 0)
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('recovery-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+	// Three active forwards plus a paused one that must not be restored.
+	for i, status := range []int{1, 1, 1, 0} {
+		res, err := repo.DB().Exec(`
+			INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+			VALUES(1, 'admin_user', ?, ?, '1.1.1.1:443', 'fifo', 0, 0, ?, ?, ?, ?)
+		`, fmt.Sprintf("recovery-forward-%d", i), tunnelID, now, now, status, i)
+		if err != nil {
+			t.Fatalf("insert forward: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		if _, err := repo.DB().Exec(`INSERT INTO forward_port(forward_id, node_id, port) VALUES(?, ?, ?)`, id, nodeID, 32000+i); err != nil {
+			t.Fatalf("insert forward_port: %v", err)
+		}
+	}
+
+	// Records the first service name of every AddService command.
+	var mu sync.Mutex
+	var added []string
+	hook := func(cmdType string, data json.RawMessage) {
+		if cmdType != "AddService" {
+			return
+		}
+		var services []struct {
+			Name string `json:"name"`
+		}
+		_ = json.Unmarshal(data, &services)
+		name := ""
+		if len(services) > 0 {
+			name = services[0].Name
+		}
+		mu.Lock()
+		added = append(added, name)
+		mu.Unlock()
+	}
+	assertRestored := func() {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			mu.Lock()
+			n := len(added)
+			mu.Unlock()
+			if n >= 3 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		// Leave room for a stray duplicate to arrive before checking.
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		got := added
+		added = nil
+		mu.Unlock()
+		if len(got) != 3 {
+			t.Fatalf("expected 3 AddService commands, got %d: %v", len(got), got)
+		}
+		seen := map[string]bool{}
+		for _, name := range got {
+			if seen[name] {
+				t.Fatalf("service %s dispatched twice: %v", name, got)
+			}
+			seen[name] = true
+		}
+	}
+
+	stop := startMockNodeSessionWithPayloadHook(t, server.URL, "recovery-node-secret", hook)
+	waitNodeStatus(t, repo, nodeID, 1)
+	assertRestored()
+
+	stop()
+	waitNodeStatus(t, repo, nodeID, 0)
+	stop = startMockNodeSessionWithPayloadHook(t, server.URL, "recovery-node-secret", hook)
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+	assertRestored()
+}
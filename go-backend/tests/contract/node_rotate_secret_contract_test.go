@@ -0,0 +1,122 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	httpserver "go-backend/internal/http"
+	"go-backend/internal/http/handler"
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestNodeRotateSecretRekeysLiveAgentContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	repo, err := sqlite.Open(filepath.Join(t.TempDir(), "contract.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.UpsertConfig("ip_ban_threshold", "3", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("set ip_ban_threshold: %v", err)
+	}
+
+	router := httpserver.NewRouter(handler.New(repo, secret))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(body interface{}) response.R {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/node/rotate-secret", bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	var mu sync.Mutex
+	var pushed, committed string
+	var order []string
+	nodeID := insertContractNode(t, repo, "rekey-node", "10.0.0.91", "5000-5010", "rekey-old-secret", 0)
+	stop := startMockNodeSessionWithPayloadHook(t, server.URL, "rekey-old-secret", func(cmdType string, data json.RawMessage) {
+		if cmdType != "RotateSecret" && cmdType != "CommitSecret" {
+			return
+		}
+		var req struct {
+			Secret string `json:"secret"`
+		}
+		_ = json.Unmarshal(data, &req)
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, cmdType)
+		if cmdType == "RotateSecret" {
+			pushed = req.Secret
+		} else {
+			committed = req.Secret
+		}
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	out := post(map[string]interface{}{"id": nodeID})
+	if out.Code != 0 {
+		t.Fatalf("rotate secret: code %d (%s)", out.Code, out.Msg)
+	}
+	newSecret := valueAsString(out.Data.(map[string]interface{})["secret"])
+	mu.Lock()
+	got, gotCommit, gotOrder := pushed, committed, strings.Join(order, ",")
+	mu.Unlock()
+	if newSecret == "" || got != newSecret {
+		t.Fatalf("expected the agent to receive the returned secret, pushed %q returned %q", got, newSecret)
+	}
+	if gotOrder != "RotateSecret,CommitSecret" || gotCommit != newSecret {
+		t.Fatalf("expected the agent to be told to persist the secret after switching, got %s (%q)", gotOrder, gotCommit)
+	}
+	node, err := repo.GetNodeBySecret(newSecret)
+	if err != nil || node == nil || node.ID != nodeID {
+		t.Fatalf("expected the new secret to be stored, got %+v err=%v", node, err)
+	}
+	if old, _ := repo.GetNodeBySecret("rekey-old-secret"); old != nil {
+		t.Fatalf("expected the old secret to stop resolving")
+	}
+	waitNodeStatus(t, repo, nodeID, 1)
+	assertCount(t, repo, `SELECT COUNT(1) FROM audit_log WHERE action = 'node_secret_rotate' AND target_id = ?`, nodeID, 1)
+
+	t.Run("old secret does not count as a failed login during rotation", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			req := httptest.NewRequest(http.MethodPost, "/flow/upload?secret=rekey-old-secret", bytes.NewBufferString(`[]`))
+			req.RemoteAddr = "10.0.0.91:6000"
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code == http.StatusForbidden {
+				t.Fatalf("expected the rotating node not to be banned")
+			}
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM ip_ban WHERE ip = ?`, "10.0.0.91", 0)
+	})
+
+	t.Run("offline nodes keep their secret", func(t *testing.T) {
+		offlineID := insertContractNode(t, repo, "offline-node", "10.0.0.92", "5000-5010", "offline-secret", 0)
+		if out := post(map[string]interface{}{"id": offlineID}); out.Code == 0 {
+			t.Fatalf("expected rotating an offline node to be refused")
+		}
+		if node, _ := repo.GetNodeBySecret("offline-secret"); node == nil || node.ID != offlineID {
+			t.Fatalf("expected the offline node's secret to be unchanged")
+		}
+	})
+}
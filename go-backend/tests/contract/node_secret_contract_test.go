@@ -0,0 +1,91 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+)
+
+func TestNodeSecretContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, body string) response.R {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	t.Run("create rejects weak secret", func(t *testing.T) {
+		for _, weak := range []string{"short1A", "alllowercasesecret123", "Has Whitespace1234567"} {
+			body := fmt.Sprintf(`{"name":"weak-node","serverIp":"10.0.0.71","secret":%q}`, weak)
+			if out := post("/api/v1/node/create", body); out.Code == 0 {
+				t.Fatalf("expected secret %q to be rejected", weak)
+			}
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM node WHERE name = ?`, "weak-node", 0)
+	})
+
+	t.Run("create generates a valid secret when omitted", func(t *testing.T) {
+		if out := post("/api/v1/node/create", `{"name":"generated-node","serverIp":"10.0.0.72"}`); out.Code != 0 {
+			t.Fatalf("create node: code %d (%s)", out.Code, out.Msg)
+		}
+		var stored string
+		if err := repo.DB().QueryRow(`SELECT secret FROM node WHERE name = 'generated-node'`).Scan(&stored); err != nil {
+			t.Fatalf("query secret: %v", err)
+		}
+		if err := security.ValidateNodeSecret(stored); err != nil {
+			t.Fatalf("generated secret %q is invalid: %v", stored, err)
+		}
+	})
+
+	t.Run("generate-secret returns a valid secret", func(t *testing.T) {
+		out := post("/api/v1/admin/node/generate-secret", `{}`)
+		data, _ := out.Data.(map[string]interface{})
+		generated, _ := data["secret"].(string)
+		if err := security.ValidateNodeSecret(generated); err != nil {
+			t.Fatalf("generated secret %q is invalid: %v", generated, err)
+		}
+	})
+
+	t.Run("rotate replaces secret and drops live session", func(t *testing.T) {
+		nodeID := insertContractNode(t, repo, "rotate-node", "10.0.0.73", "35000-35010", "rotate-node-secret", 0)
+		stop := startMockNodeSessionWithHook(t, server.URL, "rotate-node-secret", nil)
+		defer stop()
+		waitNodeStatus(t, repo, nodeID, 1)
+
+		if out := post("/api/v1/admin/node/rotate-secret", fmt.Sprintf(`{"id":%d,"secret":"weak"}`, nodeID)); out.Code == 0 {
+			t.Fatalf("expected weak rotation secret to be rejected")
+		}
+
+		out := post("/api/v1/admin/node/rotate-secret", fmt.Sprintf(`{"id":%d}`, nodeID))
+		if out.Code != 0 {
+			t.Fatalf("rotate secret: code %d (%s)", out.Code, out.Msg)
+		}
+		data, _ := out.Data.(map[string]interface{})
+		rotated, _ := data["secret"].(string)
+		if err := security.ValidateNodeSecret(rotated); err != nil {
+			t.Fatalf("rotated secret %q is invalid: %v", rotated, err)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM node WHERE secret = ?`, rotated, 1)
+		waitNodeStatus(t, repo, nodeID, 0)
+	})
+}
@@ -0,0 +1,231 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+// fakeOIDCProvider issues an ID token for whatever subject the test sets,
+// echoing back the nonce it saw on the authorization request.
+type fakeOIDCProvider struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	subject  string
+	username string
+	nonces   map[string]string // code -> nonce
+}
+
+func newFakeOIDCProvider(t *testing.T, clientID, clientSecret string) *fakeOIDCProvider {
+	p := &fakeOIDCProvider{nonces: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, sec, ok := r.BasicAuth()
+		if !ok || id != clientID || sec != clientSecret || r.FormValue("grant_type") != "authorization_code" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		p.mu.Lock()
+		nonce, known := p.nonces[r.FormValue("code")]
+		delete(p.nonces, r.FormValue("code"))
+		claims := map[string]interface{}{
+			"iss":                p.server.URL,
+			"aud":                clientID,
+			"sub":                p.subject,
+			"preferred_username": p.username,
+			"nonce":              nonce,
+			"exp":                time.Now().Add(time.Minute).Unix(),
+		}
+		p.mu.Unlock()
+		if !known {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		payload, _ := json.Marshal(claims)
+		idToken := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "access_token": "at"})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize plays the user approving the login at the provider and returns
+// the callback URL the provider would redirect to.
+func (p *fakeOIDCProvider) authorize(t *testing.T, location string) string {
+	t.Helper()
+	u, err := url.Parse(location)
+	if err != nil || !strings.HasPrefix(location, p.server.URL+"/authorize") {
+		t.Fatalf("expected a redirect to the provider, got %q", location)
+	}
+	q := u.Query()
+	code := "code-" + q.Get("state")
+	p.mu.Lock()
+	p.nonces[code] = q.Get("nonce")
+	p.mu.Unlock()
+	return q.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+}
+
+func (p *fakeOIDCProvider) setUser(subject, username string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subject, p.username = subject, username
+}
+
+func TestOIDCLoginContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	provider := newFakeOIDCProvider(t, "panel-client", "panel-secret")
+
+	now := time.Now().UnixMilli()
+	for name, value := range map[string]string{
+		"oidc_enabled":       "true",
+		"oidc_issuer":        provider.server.URL,
+		"oidc_client_id":     "panel-client",
+		"oidc_client_secret": "panel-secret",
+	} {
+		if err := repo.UpsertConfig(name, value, now); err != nil {
+			t.Fatalf("set %s: %v", name, err)
+		}
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	// ssoLogin walks the whole redirect dance and returns the fragment the
+	// panel hands to its frontend.
+	ssoLogin := func() url.Values {
+		t.Helper()
+		start := get("/api/v1/user/oidc/login")
+		if start.Code != http.StatusFound {
+			t.Fatalf("expected a redirect from oidc login, got %d: %s", start.Code, start.Body.String())
+		}
+		callback := provider.authorize(t, start.Header().Get("Location"))
+		u, _ := url.Parse(callback)
+		done := get(u.RequestURI())
+		if done.Code != http.StatusFound {
+			t.Fatalf("expected a redirect from the callback, got %d", done.Code)
+		}
+		location := done.Header().Get("Location")
+		if !strings.HasPrefix(location, "/#") {
+			t.Fatalf("expected a redirect to the frontend, got %q", location)
+		}
+		fragment, err := url.ParseQuery(strings.TrimPrefix(location, "/#"))
+		if err != nil {
+			t.Fatalf("parse fragment: %v", err)
+		}
+		return fragment
+	}
+
+	t.Run("unlinked subject is refused by default", func(t *testing.T) {
+		provider.setUser("sub-admin", "admin_user")
+		if got := ssoLogin(); got.Get("oidcError") == "" || got.Get("token") != "" {
+			t.Fatalf("expected an error without linking enabled, got %v", got)
+		}
+	})
+
+	t.Run("existing user is linked by username when allowed", func(t *testing.T) {
+		if err := repo.UpsertConfig("oidc_link_by_username", "true", now); err != nil {
+			t.Fatalf("set link config: %v", err)
+		}
+		got := ssoLogin()
+		claims, err := auth.ParseClaims(got.Get("token"), secret)
+		if err != nil || claims.Sub != "1" || got.Get("refreshToken") == "" {
+			t.Fatalf("expected a panel session for admin_user, got %v (%v)", got, err)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM user_oidc_identity WHERE user_id = 1 AND subject = ?`, "sub-admin", 1)
+	})
+
+	t.Run("new subject is provisioned when allowed", func(t *testing.T) {
+		provider.setUser("sub-new", "sso_person")
+		if got := ssoLogin(); got.Get("oidcError") == "" {
+			t.Fatalf("expected provisioning to be off by default, got %v", got)
+		}
+		if err := repo.UpsertConfig("oidc_auto_provision", "true", now); err != nil {
+			t.Fatalf("set provision config: %v", err)
+		}
+		got := ssoLogin()
+		if got.Get("name") != "sso_person" || got.Get("role_id") != "1" || got.Get("token") == "" {
+			t.Fatalf("expected a provisioned regular user, got %v", got)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = ? AND role_id = 1`, "sso_person", 1)
+
+		// The link, not the username, identifies the user from now on.
+		provider.setUser("sub-new", "renamed_at_provider")
+		if got := ssoLogin(); got.Get("name") != "sso_person" {
+			t.Fatalf("expected the linked user, got %v", got)
+		}
+	})
+
+	t.Run("callback state cannot be replayed", func(t *testing.T) {
+		start := get("/api/v1/user/oidc/login")
+		callback := provider.authorize(t, start.Header().Get("Location"))
+		u, _ := url.Parse(callback)
+		get(u.RequestURI())
+		replay := get(u.RequestURI())
+		if !strings.Contains(replay.Header().Get("Location"), "oidcError") {
+			t.Fatalf("expected a replayed callback to fail, got %q", replay.Header().Get("Location"))
+		}
+	})
+
+	t.Run("client secret is not served publicly", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]string{"name": "oidc_client_secret"})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/config/get", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		_ = json.NewDecoder(rec.Body).Decode(&out)
+		if out.Code == 0 || strings.Contains(rec.Body.String(), "panel-secret") {
+			t.Fatalf("expected the secret to be withheld, got %d %v", out.Code, out.Data)
+		}
+	})
+
+	t.Run("password login can be disabled", func(t *testing.T) {
+		if err := repo.UpsertConfig("password_login_enabled", "false", now); err != nil {
+			t.Fatalf("disable password login: %v", err)
+		}
+		body, _ := json.Marshal(map[string]string{"username": "admin_user", "password": "admin_user"})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/user/login", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		_ = json.NewDecoder(rec.Body).Decode(&out)
+		if out.Code == 0 {
+			t.Fatalf("expected password login to be refused")
+		}
+
+		// Without OIDC the switch is ignored, so nobody is locked out.
+		if err := repo.UpsertConfig("oidc_enabled", "false", now); err != nil {
+			t.Fatalf("disable oidc: %v", err)
+		}
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/user/login", bytes.NewReader(body))
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		out = response.R{}
+		_ = json.NewDecoder(rec.Body).Decode(&out)
+		if out.Code != 0 {
+			t.Fatalf("expected password login with oidc off, got %d (%s)", out.Code, out.Msg)
+		}
+	})
+}
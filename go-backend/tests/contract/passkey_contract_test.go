@@ -0,0 +1,130 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/webauthn"
+	"go-backend/internal/webauthn/webauthntest"
+)
+
+func TestPasskeyContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+
+	// httptest requests are addressed to http://example.com.
+	authenticator := webauthntest.New("example.com", "http://example.com")
+
+	post := func(path, token string, payload interface{}) response.R {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+	expectCode := func(out response.R, want int) map[string]interface{} {
+		t.Helper()
+		if out.Code != want {
+			t.Fatalf("expected code %d, got %d (%s)", want, out.Code, out.Msg)
+		}
+		data, _ := out.Data.(map[string]interface{})
+		return data
+	}
+	loginFinish := func(challenge string) response.R {
+		t.Helper()
+		clientData, authData, sig := authenticator.Assert(challenge)
+		return post("/api/v1/user/passkey/login/finish", "", map[string]interface{}{
+			"credentialId":      authenticator.CredentialID(),
+			"clientDataJSON":    webauthn.Encoding.EncodeToString(clientData),
+			"authenticatorData": webauthn.Encoding.EncodeToString(authData),
+			"signature":         webauthn.Encoding.EncodeToString(sig),
+		})
+	}
+
+	t.Run("login reports no passkey before one is registered", func(t *testing.T) {
+		data := expectCode(post("/api/v1/user/passkey/login/begin", "", map[string]interface{}{"username": "admin_user"}), 0)
+		if data["available"] != false {
+			t.Fatalf("expected available=false, got %v", data)
+		}
+	})
+
+	t.Run("register then log in with the passkey", func(t *testing.T) {
+		options := expectCode(post("/api/v1/user/passkey/register/begin", adminToken, map[string]interface{}{}), 0)
+		challenge := valueAsString(options["challenge"])
+		if rp := options["rp"].(map[string]interface{}); rp["id"] != "example.com" {
+			t.Fatalf("expected rp id from the request host, got %v", rp)
+		}
+		clientData, attestation := authenticator.Register(challenge)
+		finish := map[string]interface{}{
+			"name":              "laptop",
+			"clientDataJSON":    webauthn.Encoding.EncodeToString(clientData),
+			"attestationObject": webauthn.Encoding.EncodeToString(attestation),
+		}
+		expectCode(post("/api/v1/user/passkey/register/finish", adminToken, finish), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM webauthn_credential WHERE user_id = ?`, 1, 1)
+
+		// The challenge is single use.
+		out := post("/api/v1/user/passkey/register/finish", adminToken, finish)
+		if out.Code == 0 {
+			t.Fatalf("expected a reused registration challenge to be rejected")
+		}
+
+		options = expectCode(post("/api/v1/user/passkey/login/begin", "", map[string]interface{}{"username": "admin_user"}), 0)
+		allowed := options["allowCredentials"].([]interface{})
+		if options["available"] != true || len(allowed) != 1 {
+			t.Fatalf("expected the registered passkey to be offered, got %v", options)
+		}
+		data := expectCode(loginFinish(valueAsString(options["challenge"])), 0)
+		if valueAsString(data["token"]) == "" {
+			t.Fatalf("expected a session token, got %v", data)
+		}
+
+		// Without a username any discoverable passkey may answer.
+		options = expectCode(post("/api/v1/user/passkey/login/begin", "", map[string]interface{}{}), 0)
+		expectCode(loginFinish(valueAsString(options["challenge"])), 0)
+	})
+
+	t.Run("cloned authenticator and unknown challenge are refused", func(t *testing.T) {
+		options := expectCode(post("/api/v1/user/passkey/login/begin", "", map[string]interface{}{}), 0)
+		authenticator.SignCount = 0
+		if out := loginFinish(valueAsString(options["challenge"])); out.Code == 0 {
+			t.Fatalf("expected a stale signature counter to be rejected")
+		}
+		authenticator.SignCount = 100
+		if out := loginFinish("never-issued"); out.Code == 0 {
+			t.Fatalf("expected an unknown challenge to be rejected")
+		}
+	})
+
+	t.Run("list and delete", func(t *testing.T) {
+		out := post("/api/v1/user/passkey/list", adminToken, map[string]interface{}{})
+		expectCode(out, 0)
+		list := out.Data.([]interface{})
+		if len(list) != 1 {
+			t.Fatalf("expected one passkey, got %v", list)
+		}
+		entry := list[0].(map[string]interface{})
+		if entry["name"] != "laptop" || entry["publicKey"] != nil {
+			t.Fatalf("unexpected passkey entry %v", entry)
+		}
+		expectCode(post("/api/v1/user/passkey/delete", adminToken, map[string]interface{}{"id": entry["id"]}), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM webauthn_credential WHERE user_id = ?`, 1, 0)
+	})
+}
@@ -0,0 +1,54 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-backend/internal/security"
+)
+
+func TestLoginMigratesLegacyPasswordHashContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{"username": "admin_user", "password": password})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/user/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	storedHash := func() string {
+		var pwd string
+		if err := repo.DB().QueryRow(`SELECT pwd FROM user WHERE user = ?`, "admin_user").Scan(&pwd); err != nil {
+			t.Fatalf("query admin hash: %v", err)
+		}
+		return pwd
+	}
+
+	if got := storedHash(); got != security.MD5("admin_user") {
+		t.Fatalf("expected seeded admin to carry a legacy MD5 hash, got %q", got)
+	}
+
+	assertCodeMsg(t, login("wrong-password"), -1, "账号或密码错误")
+	if got := storedHash(); got != security.MD5("admin_user") {
+		t.Fatalf("expected a failed login to leave the hash alone, got %q", got)
+	}
+
+	assertCode(t, login("admin_user"), 0)
+	migrated := storedHash()
+	if !strings.HasPrefix(migrated, "$2") {
+		t.Fatalf("expected login to rehash the password with bcrypt, got %q", migrated)
+	}
+
+	assertCode(t, login("admin_user"), 0)
+	if got := storedHash(); got != migrated {
+		t.Fatalf("expected a bcrypt hash to be kept on later logins")
+	}
+	assertCodeMsg(t, login("wrong-password"), -1, "账号或密码错误")
+}
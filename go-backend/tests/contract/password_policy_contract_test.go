@@ -0,0 +1,66 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPasswordPolicyContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+
+	post := func(path string, payload interface{}) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	changePassword := func(newPassword string) *httptest.ResponseRecorder {
+		return post("/api/v1/user/updatePassword", map[string]interface{}{
+			"newUsername":     "admin_user",
+			"currentPassword": "admin_user",
+			"newPassword":     newPassword,
+			"confirmPassword": newPassword,
+		})
+	}
+
+	t.Run("defaults refuse short and common passwords", func(t *testing.T) {
+		assertCodeMsg(t, changePassword("abc"), -1, "密码长度至少6位")
+		assertCodeMsg(t, changePassword("admin_user"), -1, "密码过于常见，请更换")
+		assertCodeMsg(t, post("/api/v1/user/create", map[string]interface{}{"user": "weak", "pwd": "123456"}), -1, "密码过于常见，请更换")
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = ?`, "weak", 0)
+	})
+
+	if err := repo.UpsertConfig("password_min_length", "10", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("set min length: %v", err)
+	}
+	if err := repo.UpsertConfig("password_min_char_classes", "3", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("set char classes: %v", err)
+	}
+
+	t.Run("configured policy applies to every password entry point", func(t *testing.T) {
+		assertCodeMsg(t, post("/api/v1/user/create", map[string]interface{}{"user": "member", "pwd": "short-1"}), -1, "密码长度至少10位")
+		assertCodeMsg(t, post("/api/v1/user/create", map[string]interface{}{"user": "member", "pwd": "lowercase-only"}), -1, "密码需包含大写字母、小写字母、数字、符号中的至少3种")
+		assertCode(t, post("/api/v1/user/create", map[string]interface{}{"user": "member", "pwd": "Member-pass-1"}), 0)
+
+		var memberID int64
+		if err := repo.DB().QueryRow(`SELECT id FROM user WHERE user = ?`, "member").Scan(&memberID); err != nil {
+			t.Fatalf("query member: %v", err)
+		}
+		assertCodeMsg(t, post("/api/v1/user/update", map[string]interface{}{"id": memberID, "user": "member", "pwd": "weakpass"}), -1, "密码长度至少10位")
+
+		assertCodeMsg(t, changePassword("changed-pass"), -1, "密码需包含大写字母、小写字母、数字、符号中的至少3种")
+		assertCode(t, changePassword("Changed-pass-1"), 0)
+	})
+}
@@ -0,0 +1,118 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/mail/mailtest"
+	"go-backend/internal/security"
+	"go-backend/internal/store"
+)
+
+func TestPasswordResetContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	relay := mailtest.NewServer()
+	defer relay.Close()
+
+	now := time.Now().UnixMilli()
+	for name, value := range map[string]string{
+		"smtp_host":          relay.Host,
+		"smtp_port":          strconv.Itoa(relay.Port),
+		"smtp_from":          "panel@example.com",
+		"password_reset_url": "https://panel.example.com/reset?token=",
+	} {
+		if err := repo.UpsertConfig(name, value, now); err != nil {
+			t.Fatalf("set %s: %v", name, err)
+		}
+	}
+	if _, err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(2, 'forgetful', ?, 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+	`, security.MD5("old-pass-123"), now, now); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	userToken, err := contractToken(repo, 2, "forgetful", 1, secret)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	post := func(path, token string, body interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	if out := post("/api/v1/user/email/update", userToken, map[string]interface{}{"email": "Forgetful@Example.com", "password": "wrong"}); out.Code == 0 {
+		t.Fatalf("expected the email change to need the current password")
+	}
+	if out := post("/api/v1/user/email/update", userToken, map[string]interface{}{"email": "Forgetful@Example.com", "password": "old-pass-123"}); out.Code != 0 {
+		t.Fatalf("update email: %d (%s)", out.Code, out.Msg)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE email = ?`, "forgetful@example.com", 1)
+
+	// The address is unique across users, while any number may have none.
+	if err := repo.UpdateUserEmail(1, "FORGETFUL@example.com", now); !store.IsConflict(err) {
+		t.Fatalf("expected a second user taking the address to conflict, got %v", err)
+	}
+	if _, err := repo.DB().Exec(`UPDATE user SET email = 'forgetful@example.com' WHERE id = 1`); err == nil {
+		t.Fatalf("expected the email index to reject a duplicate address")
+	}
+	if err := repo.UpdateUserEmail(1, "", now); err != nil {
+		t.Fatalf("clear email: %v", err)
+	}
+
+	// Unknown accounts get the same answer and no mail.
+	if out := post("/api/v1/user/password-reset/request", "", map[string]interface{}{"username": "nobody"}); out.Code != 0 {
+		t.Fatalf("expected unknown accounts to be answered like known ones, got %d", out.Code)
+	}
+	if out := post("/api/v1/user/password-reset/request", "", map[string]interface{}{"email": "forgetful@example.com"}); out.Code != 0 {
+		t.Fatalf("request reset: %d (%s)", out.Code, out.Msg)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(relay.Messages()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	msgs := relay.Messages()
+	if len(msgs) != 1 || msgs[0].To[0] != "forgetful@example.com" {
+		t.Fatalf("expected one reset mail to the user, got %+v", msgs)
+	}
+	match := regexp.MustCompile(`https://panel\.example\.com/reset\?token=([A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+)`).FindStringSubmatch(msgs[0].Data)
+	if match == nil {
+		t.Fatalf("expected a reset link in the mail, got %q", msgs[0].Data)
+	}
+	resetToken := match[1]
+
+	if out := post("/api/v1/user/password-reset/confirm", "", map[string]interface{}{"token": resetToken + "x", "newPassword": "New-pass-456", "confirmPassword": "New-pass-456"}); out.Code == 0 {
+		t.Fatalf("expected a tampered token to be rejected")
+	}
+	if out := post("/api/v1/user/password-reset/confirm", "", map[string]interface{}{"token": resetToken, "newPassword": "New-pass-456", "confirmPassword": "New-pass-456"}); out.Code != 0 {
+		t.Fatalf("confirm reset: %d (%s)", out.Code, out.Msg)
+	}
+	if out := post("/api/v1/user/login", "", map[string]interface{}{"username": "forgetful", "password": "New-pass-456"}); out.Code != 0 {
+		t.Fatalf("expected login with the new password, got %d (%s)", out.Code, out.Msg)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM audit_log WHERE action = 'password_reset' AND target_id = ?`, 2, 1)
+
+	if out := post("/api/v1/user/password-reset/confirm", "", map[string]interface{}{"token": resetToken, "newPassword": "Other-pass-789", "confirmPassword": "Other-pass-789"}); out.Code == 0 {
+		t.Fatalf("expected a used token to be rejected")
+	}
+}
@@ -0,0 +1,136 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestAdminPeerShareExportImportContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "share-node", "10.0.0.91", "40000-40100", "share-node-secret", 1)
+	tokens := []string{"share-token-a", "share-token-b", "share-token-c"}
+	shareIDs := make([]int64, 0, len(tokens))
+	for i, token := range tokens {
+		shareIDs = append(shareIDs, insertPeerShare(t, repo, &sqlite.PeerShare{
+			Name:           fmt.Sprintf("share-%d", i),
+			NodeID:         nodeID,
+			Token:          token,
+			MaxBandwidth:   int64(100 * (i + 1)),
+			PortRangeStart: 40000 + i*10,
+			PortRangeEnd:   40009 + i*10,
+			IsActive:       1,
+			CreatedTime:    now,
+			UpdatedTime:    now,
+		}))
+	}
+	if err := repo.CreatePeerShareRuntime(&sqlite.PeerShareRuntime{
+		ShareID: shareIDs[0], NodeID: nodeID, ConsumerID: "consumer", ReservationID: "res-1", ResourceKey: "key-1",
+		BindingID: "binding-1", Role: "exit", Protocol: "tls", Strategy: "round", Port: 40001, Status: 1,
+		CreatedTime: now, UpdatedTime: now,
+	}); err != nil {
+		t.Fatalf("create runtime: %v", err)
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/api/v1/admin/federation/share/export", nil)
+	var exported struct {
+		Code int             `json:"code"`
+		Msg  string          `json:"msg"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&exported); err != nil {
+		t.Fatalf("decode export: %v", err)
+	}
+	if exported.Code != 0 {
+		t.Fatalf("export: code %d (%s)", exported.Code, exported.Msg)
+	}
+
+	// Clear the shares but keep the runtime row, as a copied database would.
+	if _, err := repo.DB().Exec(`DELETE FROM peer_share`); err != nil {
+		t.Fatalf("clear shares: %v", err)
+	}
+	if _, err := repo.DB().Exec(`UPDATE peer_share_runtime SET status = 0`); err != nil {
+		t.Fatalf("release runtime: %v", err)
+	}
+
+	t.Run("rejects an overlapping port range", func(t *testing.T) {
+		var items []map[string]interface{}
+		if err := json.Unmarshal(exported.Data, &items); err != nil {
+			t.Fatalf("decode export items: %v", err)
+		}
+		items = append(items, map[string]interface{}{
+			"name": "overlap", "nodeId": nodeID, "token": "share-token-overlap",
+			"portRangeStart": 40015, "portRangeEnd": 40025, "isActive": 1,
+		})
+		body, _ := json.Marshal(items)
+		assertCode(t, post("/api/v1/admin/federation/share/import", body), -1)
+		assertCount(t, repo, `SELECT COUNT(1) FROM peer_share WHERE node_id = ?`, nodeID, 0)
+	})
+
+	t.Run("restores the exported shares", func(t *testing.T) {
+		rec := post("/api/v1/admin/federation/share/import", exported.Data)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode import: %v", err)
+		}
+		if out.Code != 0 {
+			t.Fatalf("import: code %d (%s)", out.Code, out.Msg)
+		}
+		data := out.Data.(map[string]interface{})
+		if valueAsInt(data["created"]) != 3 || valueAsInt(data["updated"]) != 0 {
+			t.Fatalf("expected 3 created shares, got %v", data)
+		}
+		for i, token := range tokens {
+			share, err := repo.GetPeerShareByToken(token)
+			if err != nil || share == nil {
+				t.Fatalf("expected share %s restored, got %v", token, err)
+			}
+			if share.Name != fmt.Sprintf("share-%d", i) || share.PortRangeStart != 40000+i*10 || share.MaxBandwidth != int64(100*(i+1)) {
+				t.Fatalf("unexpected restored share %+v", share)
+			}
+		}
+
+		restored, _ := repo.GetPeerShareByToken(tokens[0])
+		assertCount(t, repo, `SELECT COUNT(1) FROM peer_share_runtime WHERE binding_id = 'binding-1' AND status = 1 AND share_id = ?`, restored.ID, 1)
+	})
+
+	t.Run("updates existing shares by token", func(t *testing.T) {
+		var items []map[string]interface{}
+		_ = json.Unmarshal(exported.Data, &items)
+		items[0]["name"] = "renamed"
+		body, _ := json.Marshal(items)
+		rec := post("/api/v1/admin/federation/share/import", body)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode import: %v", err)
+		}
+		data, _ := out.Data.(map[string]interface{})
+		if out.Code != 0 || valueAsInt(data["updated"]) != 3 {
+			t.Fatalf("expected 3 updated shares, got code %d data %v", out.Code, out.Data)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM peer_share WHERE name = ?`, "renamed", 1)
+		assertCount(t, repo, `SELECT COUNT(1) FROM peer_share WHERE node_id = ?`, nodeID, 3)
+	})
+}
@@ -0,0 +1,59 @@
+package contract_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/sqlite"
+)
+
+func TestPeerSharePortRangeConflictContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "conflict-node", "10.0.0.98", "43000-43100", "conflict-node-secret", 1)
+	insertPeerShare(t, repo, &sqlite.PeerShare{
+		Name: "share-a", NodeID: nodeID, Token: "conflict-token-a", PortRangeStart: 43000, PortRangeEnd: 43010,
+		IsActive: 1, CreatedTime: now, UpdatedTime: now,
+	})
+	shareB := insertPeerShare(t, repo, &sqlite.PeerShare{
+		Name: "share-b", NodeID: nodeID, Token: "conflict-token-b", PortRangeStart: 43020, PortRangeEnd: 43030,
+		IsActive: 1, CreatedTime: now, UpdatedTime: now,
+	})
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	update := func(start, end int) *httptest.ResponseRecorder {
+		return post("/api/v1/federation/share/update", fmt.Sprintf(`{"id":%d,"name":"share-b","portRangeStart":%d,"portRangeEnd":%d}`, shareB, start, end))
+	}
+
+	t.Run("update onto another share is rejected", func(t *testing.T) {
+		assertCodeMsg(t, update(43005, 43025), -1, `Port range 43005-43025 overlaps share "share-a" (43000-43010)`)
+		assertCount(t, repo, `SELECT COUNT(1) FROM peer_share WHERE id = ? AND port_range_start = 43020`, shareB, 1)
+	})
+
+	t.Run("update to a free range succeeds", func(t *testing.T) {
+		assertCode(t, update(43011, 43040), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM peer_share WHERE id = ? AND port_range_start = 43011 AND port_range_end = 43040`, shareB, 1)
+	})
+
+	t.Run("create onto another share is rejected", func(t *testing.T) {
+		body := fmt.Sprintf(`{"name":"share-c","nodeId":%d,"portRangeStart":43035,"portRangeEnd":43050}`, nodeID)
+		assertCodeMsg(t, post("/api/v1/federation/share/create", body), -1, `Port range 43035-43050 overlaps share "share-b" (43011-43040)`)
+		assertCount(t, repo, `SELECT COUNT(1) FROM peer_share WHERE name = ?`, "share-c", 0)
+	})
+}
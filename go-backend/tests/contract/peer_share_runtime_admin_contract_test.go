@@ -0,0 +1,158 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestAdminPeerShareRuntimeCRUDContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path string, payload interface{}) response.R {
+		t.Helper()
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("marshal %s payload: %v", path, err)
+		}
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "runtime-admin-node", "198.51.100.61", "47000-47010", "runtime-admin-secret", 0)
+	shareID := insertPeerShare(t, repo, &sqlite.PeerShare{
+		Name:           "runtime-admin-share",
+		NodeID:         nodeID,
+		Token:          "runtime-admin-token",
+		PortRangeStart: 47000,
+		PortRangeEnd:   47010,
+		IsActive:       1,
+		CreatedTime:    now,
+		UpdatedTime:    now,
+	})
+
+	var mu sync.Mutex
+	var throttles []json.RawMessage
+	stop := startMockNodeSessionWithPayloadHook(t, server.URL, "runtime-admin-secret", func(cmdType string, data json.RawMessage) {
+		if cmdType == "ThrottleService" {
+			mu.Lock()
+			throttles = append(throttles, data)
+			mu.Unlock()
+		}
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	create := map[string]interface{}{
+		"shareId":       shareID,
+		"nodeId":        nodeID,
+		"reservationId": "manual-res-1",
+		"resourceKey":   "manual-rk-1",
+		"bindingId":     "manual-b-1",
+		"role":          "exit",
+		"serviceName":   "fed_svc_manual_1",
+		"protocol":      "tls",
+		"strategy":      "round",
+		"port":          47005,
+		"target":        "",
+	}
+
+	t.Run("create validates like a reservation", func(t *testing.T) {
+		bad := map[string]interface{}{}
+		for k, v := range create {
+			bad[k] = v
+		}
+		bad["port"] = 48000
+		if out := post("/api/v1/admin/federation/runtime/create", bad); out.Code == 0 {
+			t.Fatalf("expected out-of-range port to be rejected")
+		}
+		bad["port"] = 47005
+		bad["role"] = "entry"
+		if out := post("/api/v1/admin/federation/runtime/create", bad); out.Code == 0 {
+			t.Fatalf("expected invalid role to be rejected")
+		}
+	})
+
+	out := post("/api/v1/admin/federation/runtime/create", create)
+	if out.Code != 0 {
+		t.Fatalf("create runtime: code %d (%s)", out.Code, out.Msg)
+	}
+	runtimeID := int64(valueAsInt(out.Data.(map[string]interface{})["id"]))
+	if runtimeID <= 0 {
+		t.Fatalf("expected a runtime id, got %v", out.Data)
+	}
+	if out := post("/api/v1/admin/federation/runtime/create", create); out.Code == 0 {
+		t.Fatalf("expected a duplicate resource key to be rejected")
+	}
+
+	out = post("/api/v1/admin/federation/runtime/list", map[string]interface{}{"shareId": shareID, "page": 1, "pageSize": 10})
+	if out.Code != 0 {
+		t.Fatalf("list runtimes: code %d (%s)", out.Code, out.Msg)
+	}
+	data := out.Data.(map[string]interface{})
+	list := data["list"].([]interface{})
+	if valueAsInt(data["total"]) != 1 || len(list) != 1 {
+		t.Fatalf("expected one runtime, got %v", data)
+	}
+	row := list[0].(map[string]interface{})
+	for key, want := range map[string]interface{}{
+		"reservationId": "manual-res-1",
+		"resourceKey":   "manual-rk-1",
+		"bindingId":     "manual-b-1",
+		"role":          "exit",
+		"serviceName":   "fed_svc_manual_1",
+		"protocol":      "tls",
+		"strategy":      "round",
+	} {
+		if row[key] != want {
+			t.Fatalf("expected %s %v, got %v", key, want, row[key])
+		}
+	}
+	if valueAsInt(row["port"]) != 47005 || valueAsInt(row["status"]) != 1 || valueAsInt(row["nodeId"]) != int(nodeID) {
+		t.Fatalf("unexpected runtime row %v", row)
+	}
+
+	if out := post("/api/v1/admin/federation/runtime/delete", map[string]interface{}{"id": runtimeID}); out.Code != 0 {
+		t.Fatalf("delete runtime: code %d (%s)", out.Code, out.Msg)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM peer_share_runtime WHERE id = ? AND status = 0`, runtimeID, 1)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(throttles) != 1 {
+		t.Fatalf("expected one ThrottleService command, got %d", len(throttles))
+	}
+	var payload struct {
+		Services []string `json:"services"`
+		Bps      int64    `json:"bps"`
+	}
+	if err := json.Unmarshal(throttles[0], &payload); err != nil {
+		t.Fatalf("decode ThrottleService payload: %v", err)
+	}
+	if len(payload.Services) != 1 || payload.Services[0] != "fed_svc_manual_1" || payload.Bps != 0 {
+		t.Fatalf("unexpected ThrottleService payload %s", throttles[0])
+	}
+}
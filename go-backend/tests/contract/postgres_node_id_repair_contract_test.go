@@ -13,7 +13,6 @@ import (
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 
-	"go-backend/internal/auth"
 	httpserver "go-backend/internal/http"
 	"go-backend/internal/http/handler"
 	"go-backend/internal/store/sqlite"
@@ -80,8 +79,8 @@ func TestPostgresNodeCreateRepairsMissingIDDefaultContract(t *testing.T) {
 	}
 
 	jwtSecret := "postgres-contract-secret"
-	router := httpserver.NewRouter(handler.New(repo, jwtSecret), jwtSecret)
-	token, err := auth.GenerateToken(1, "admin_user", 0, jwtSecret)
+	router := httpserver.NewRouter(handler.New(repo, jwtSecret))
+	token, err := contractToken(repo, 1, "admin_user", 0, jwtSecret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
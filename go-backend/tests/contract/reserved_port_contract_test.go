@@ -0,0 +1,109 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestAdminReservedPortsContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "reserved-node", "10.0.0.51", "3000-3006", "reserved-node-secret", 0)
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('reserved-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+
+	stop := startMockNodeSessionWithHook(t, server.URL, "reserved-node-secret", nil)
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, body string) response.R {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	out := post("/api/v1/admin/node/reserved-ports/create", fmt.Sprintf(`{"nodeId":%d,"portStart":3000,"portEnd":3005,"reason":"nginx on host"}`, nodeID))
+	if out.Code != 0 {
+		t.Fatalf("create reservation: code %d (%s)", out.Code, out.Msg)
+	}
+
+	t.Run("list returns reservation", func(t *testing.T) {
+		out := post("/api/v1/admin/node/reserved-ports/list", fmt.Sprintf(`{"nodeId":%d}`, nodeID))
+		items, _ := out.Data.([]interface{})
+		if len(items) != 1 {
+			t.Fatalf("expected 1 reservation, got %v", out.Data)
+		}
+		item := items[0].(map[string]interface{})
+		if valueAsInt(item["portStart"]) != 3000 || valueAsInt(item["portEnd"]) != 3005 || item["reason"] != "nginx on host" {
+			t.Fatalf("unexpected reservation %v", item)
+		}
+	})
+
+	t.Run("explicit reserved port is rejected with reason", func(t *testing.T) {
+		out := post("/api/v1/forward/create", fmt.Sprintf(`{"name":"reserved-forward","tunnelId":%d,"remoteAddr":"1.1.1.1:443","inPort":3002}`, tunnelID))
+		if out.Code == 0 {
+			t.Fatalf("expected reserved port to be rejected")
+		}
+		if !strings.Contains(out.Msg, "nginx on host") {
+			t.Fatalf("expected message to reference reservation reason, got %q", out.Msg)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE tunnel_id = ?`, tunnelID, 0)
+	})
+
+	t.Run("auto allocation skips reserved ports", func(t *testing.T) {
+		out := post("/api/v1/forward/create", fmt.Sprintf(`{"name":"auto-forward","tunnelId":%d,"remoteAddr":"1.1.1.1:443"}`, tunnelID))
+		if out.Code != 0 {
+			t.Fatalf("create forward: code %d (%s)", out.Code, out.Msg)
+		}
+		var port int
+		if err := repo.DB().QueryRow(`SELECT fp.port FROM forward_port fp JOIN forward f ON f.id = fp.forward_id WHERE f.name = 'auto-forward'`).Scan(&port); err != nil {
+			t.Fatalf("query forward port: %v", err)
+		}
+		if port != 3006 {
+			t.Fatalf("expected auto-allocated port 3006, got %d", port)
+		}
+	})
+
+	t.Run("delete removes reservation", func(t *testing.T) {
+		var id int64
+		if err := repo.DB().QueryRow(`SELECT id FROM reserved_port WHERE node_id = ?`, nodeID).Scan(&id); err != nil {
+			t.Fatalf("query reservation: %v", err)
+		}
+		out := post("/api/v1/admin/node/reserved-ports/delete", fmt.Sprintf(`{"id":%d}`, id))
+		if out.Code != 0 {
+			t.Fatalf("delete reservation: code %d (%s)", out.Code, out.Msg)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM reserved_port WHERE node_id = ?`, nodeID, 0)
+	})
+}
@@ -0,0 +1,67 @@
+package contract_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestResponseFieldCaseSnakeContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	if err := repo.UpsertConfig("response_field_case", "snake", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("insert response_field_case: %v", err)
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/user/package", bytes.NewBufferString(`{}`))
+	req.Header.Set("Authorization", adminToken)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	assertCode(t, rec, 0)
+	if !strings.Contains(body, `"user_info"`) {
+		t.Fatalf("expected snake_case user_info key, got %s", body)
+	}
+	if strings.Contains(body, `"userInfo"`) {
+		t.Fatalf("expected camelCase userInfo key to be converted, got %s", body)
+	}
+}
+
+func TestCamelToSnakeConvertsNestedKeys(t *testing.T) {
+	got := response.CamelToSnake(map[string]interface{}{
+		"userInfo": map[string]interface{}{"inFlow": 1, "serverIP": "10.0.0.1"},
+		"forwards": []interface{}{map[string]interface{}{"remoteAddr": "a"}},
+		"id":       7,
+	})
+	info, ok := got["user_info"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected user_info map, got %#v", got)
+	}
+	if _, ok := info["in_flow"]; !ok {
+		t.Fatalf("expected in_flow key, got %#v", info)
+	}
+	if _, ok := info["server_ip"]; !ok {
+		t.Fatalf("expected server_ip key, got %#v", info)
+	}
+	forwards, _ := got["forwards"].([]interface{})
+	if len(forwards) != 1 {
+		t.Fatalf("expected forwards slice, got %#v", got["forwards"])
+	}
+	if _, ok := forwards[0].(map[string]interface{})["remote_addr"]; !ok {
+		t.Fatalf("expected remote_addr key, got %#v", forwards[0])
+	}
+	if _, ok := got["id"]; !ok {
+		t.Fatalf("expected id key to be kept, got %#v", got)
+	}
+}
@@ -0,0 +1,135 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+)
+
+func TestRolePermissionMatrixContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	now := time.Now().UnixMilli()
+
+	if _, err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(2, 'operator', ?, 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+	`, security.MD5("operator-pass"), now, now); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, token string, body interface{}) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) response.R {
+		t.Helper()
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	t.Run("rejects unknown actions", func(t *testing.T) {
+		out := decode(post("/api/v1/role/create", adminToken, map[string]interface{}{"name": "bad", "actions": []string{"node:destroy"}}))
+		if out.Code == 0 {
+			t.Fatalf("expected unknown action to be rejected")
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM role WHERE name = ?`, "bad", 0)
+	})
+
+	out := decode(post("/api/v1/role/create", adminToken, map[string]interface{}{
+		"name":    "node-operator",
+		"actions": []string{"node:read", "tunnel:read", "node:read"},
+	}))
+	if out.Code != 0 {
+		t.Fatalf("create role: %d %s", out.Code, out.Msg)
+	}
+	roleID := int64(out.Data.(map[string]interface{})["id"].(float64))
+	if roleID <= auth.RoleUser {
+		t.Fatalf("expected a custom role id after the built-in roles, got %d", roleID)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM role_permission WHERE role_id = ?`, roleID, 2)
+	assertCode(t, post("/api/v1/role/assign", adminToken, map[string]interface{}{"userId": 2, "roleId": roleID}), 0)
+
+	operatorToken, err := contractToken(repo, 2, "operator", int(roleID), secret)
+	if err != nil {
+		t.Fatalf("generate operator token: %v", err)
+	}
+
+	t.Run("read action opens list endpoints", func(t *testing.T) {
+		assertCode(t, post("/api/v1/node/list", operatorToken, map[string]interface{}{}), 0)
+		assertCode(t, post("/api/v1/tunnel/list", operatorToken, map[string]interface{}{}), 0)
+	})
+
+	t.Run("read action does not open writes", func(t *testing.T) {
+		assertCodeMsg(t, post("/api/v1/node/create", operatorToken, map[string]interface{}{"name": "n"}), 403, "权限不足，仅管理员可操作")
+		assertCount(t, repo, `SELECT COUNT(1) FROM node WHERE name = ?`, "n", 0)
+		assertCode(t, post("/api/v1/user/list", operatorToken, map[string]interface{}{}), 403)
+	})
+
+	t.Run("role edits apply to existing tokens", func(t *testing.T) {
+		assertCode(t, post("/api/v1/role/update", adminToken, map[string]interface{}{
+			"id":      roleID,
+			"name":    "node-operator",
+			"actions": []string{"node:read", "federation:manage"},
+		}), 0)
+		assertCode(t, post("/api/v1/federation/share/list", operatorToken, map[string]interface{}{}), 0)
+		assertCode(t, post("/api/v1/tunnel/list", operatorToken, map[string]interface{}{}), 403)
+	})
+
+	t.Run("custom roles cannot manage roles", func(t *testing.T) {
+		assertCode(t, post("/api/v1/role/list", operatorToken, map[string]interface{}{}), 403)
+	})
+
+	t.Run("built-in roles are protected", func(t *testing.T) {
+		if out := decode(post("/api/v1/role/update", adminToken, map[string]interface{}{"id": 0, "name": "root"})); out.Code == 0 {
+			t.Fatalf("expected the admin role to be read-only")
+		}
+		if out := decode(post("/api/v1/role/delete", adminToken, map[string]interface{}{"id": 1})); out.Code == 0 {
+			t.Fatalf("expected the default role to be undeletable")
+		}
+		if out := decode(post("/api/v1/role/assign", adminToken, map[string]interface{}{"userId": 2, "roleId": 0})); out.Code == 0 {
+			t.Fatalf("expected assigning the admin role to be refused")
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE id = 2 AND role_id = ?`, roleID, 1)
+	})
+
+	t.Run("a role in use cannot be deleted", func(t *testing.T) {
+		if out := decode(post("/api/v1/role/delete", adminToken, map[string]interface{}{"id": roleID})); out.Code == 0 {
+			t.Fatalf("expected delete to be refused while a user holds the role")
+		}
+		assertCode(t, post("/api/v1/role/assign", adminToken, map[string]interface{}{"userId": 2, "roleId": auth.RoleUser}), 0)
+		assertCode(t, post("/api/v1/node/list", operatorToken, map[string]interface{}{}), 401)
+		assertCode(t, post("/api/v1/role/delete", adminToken, map[string]interface{}{"id": roleID}), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM role_permission WHERE role_id = ?`, roleID, 0)
+	})
+
+	t.Run("list includes the built-in roles", func(t *testing.T) {
+		out := decode(post("/api/v1/role/list", adminToken, map[string]interface{}{}))
+		if out.Code != 0 {
+			t.Fatalf("list roles: %d %s", out.Code, out.Msg)
+		}
+		if roles := out.Data.([]interface{}); len(roles) != 2 {
+			t.Fatalf("expected only the built-in roles after delete, got %d", len(roles))
+		}
+	})
+}
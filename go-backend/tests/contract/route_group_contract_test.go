@@ -0,0 +1,96 @@
+package contract_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-backend/internal/http/handler"
+)
+
+func TestRouteGroupMiddlewareContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	insertContractUser(t, repo, 2, "normal_user", 1)
+	userToken, err := contractToken(repo, 2, "normal_user", 1, secret)
+	if err != nil {
+		t.Fatalf("generate user token: %v", err)
+	}
+	send := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(`{}`))
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("user routes require JWT", func(t *testing.T) {
+		assertCodeMsg(t, send("/api/v1/forward/list", ""), 401, "未登录或token已过期")
+		assertCodeMsg(t, send("/api/v1/user/package", "invalid.token.value"), 401, "无效的token或token已过期")
+		assertCode(t, send("/api/v1/forward/list", userToken), 0)
+	})
+
+	t.Run("flow test is public", func(t *testing.T) {
+		rec := send("/flow/test", "")
+		if rec.Body.String() != "test" {
+			t.Fatalf("expected /flow/test to bypass JWT, got %q", rec.Body.String())
+		}
+	})
+
+	t.Run("public API routes skip JWT", func(t *testing.T) {
+		rec := send("/api/v1/config/get", "")
+		if strings.Contains(rec.Body.String(), "token") {
+			t.Fatalf("expected /api/v1/config/get to bypass JWT, got %s", rec.Body.String())
+		}
+	})
+
+	t.Run("admin routes require admin role", func(t *testing.T) {
+		assertCodeMsg(t, send("/api/v1/admin/tunnel/metrics", userToken), 403, "权限不足，仅管理员可操作")
+		assertCodeMsg(t, send("/api/v1/node/list", userToken), 403, "权限不足，仅管理员可操作")
+		assertCodeMsg(t, send("/api/v1/admin/tunnel/metrics", ""), 401, "未登录或token已过期")
+		assertCode(t, send("/api/v1/admin/tunnel/metrics", adminToken), 0)
+	})
+}
+
+func TestRouteGroupChainsMiddlewareInOrder(t *testing.T) {
+	var calls []string
+	mark := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	mux := http.NewServeMux()
+	api := handler.NewRouteGroup(mux, "/api", mark("api"))
+	admin := api.Group("/admin", mark("admin"))
+	admin.Use(mark("late"))
+	admin.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	})
+	api.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	})
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/admin/ping", nil))
+	if got := strings.Join(calls, ","); got != "api,admin,late,handler" {
+		t.Fatalf("unexpected admin chain %q", got)
+	}
+
+	calls = nil
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/ping", nil))
+	if got := strings.Join(calls, ","); got != "api,handler" {
+		t.Fatalf("child middleware leaked into parent group: %q", got)
+	}
+}
@@ -0,0 +1,150 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestAdminSearchAllContracts(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	now := time.Now().UnixMilli()
+
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, "hongkong-edge", 1.0, 1, "tls", 99999, now, now, 1, "203.0.113.10", 0)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, err := res.LastInsertId()
+	if err != nil {
+		t.Fatalf("get tunnel id: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("generic-%d", i)
+		remote := fmt.Sprintf("10.1.0.%d:80", i)
+		if i%4 == 0 {
+			name = fmt.Sprintf("gamefast-%d", i)
+		}
+		if i == 7 {
+			remote = "gamefast.example.com:443"
+		}
+		if _, err := repo.DB().Exec(`
+			INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+			VALUES(1, 'admin_user', ?, ?, ?, 'fifo', 0, 0, ?, ?, 1, ?)
+		`, name, tunnelID, remote, now, now, i); err != nil {
+			t.Fatalf("insert forward %d: %v", i, err)
+		}
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	insertContractUser(t, repo, 2, "normal_user", 1)
+	userToken, err := contractToken(repo, 2, "normal_user", 1, secret)
+	if err != nil {
+		t.Fatalf("generate user token: %v", err)
+	}
+
+	search := func(token, body string) response.R {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/search", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	t.Run("non-admin is rejected", func(t *testing.T) {
+		out := search(userToken, `{"query":"gamefast"}`)
+		if out.Code != 403 {
+			t.Fatalf("expected code 403, got %d (%s)", out.Code, out.Msg)
+		}
+	})
+
+	t.Run("forward search returns only matching rows", func(t *testing.T) {
+		out := search(adminToken, `{"query":"gamefast","types":["forward"],"page":1}`)
+		if out.Code != 0 {
+			t.Fatalf("expected code 0, got %d (%s)", out.Code, out.Msg)
+		}
+		data, ok := out.Data.(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected object data, got %T", out.Data)
+		}
+		forwards, ok := data["forwards"].([]interface{})
+		if !ok {
+			t.Fatalf("expected forwards array, got %T", data["forwards"])
+		}
+		if len(forwards) != 6 {
+			t.Fatalf("expected 6 matching forwards, got %d", len(forwards))
+		}
+		if _, ok := data["tunnels"]; ok {
+			t.Fatalf("expected tunnel results to be omitted")
+		}
+	})
+
+	t.Run("search covers tunnel ingress ip and tunnel names", func(t *testing.T) {
+		out := search(adminToken, `{"query":"hongkong"}`)
+		if out.Code != 0 {
+			t.Fatalf("expected code 0, got %d (%s)", out.Code, out.Msg)
+		}
+		data := out.Data.(map[string]interface{})
+		if tunnels, _ := data["tunnels"].([]interface{}); len(tunnels) != 1 {
+			t.Fatalf("expected 1 matching tunnel, got %v", data["tunnels"])
+		}
+
+		out = search(adminToken, `{"query":"203.0.113.10","types":["forward"]}`)
+		data = out.Data.(map[string]interface{})
+		if forwards, _ := data["forwards"].([]interface{}); len(forwards) != 20 {
+			t.Fatalf("expected all 20 forwards by ingress ip, got %d", len(forwards))
+		}
+	})
+
+	t.Run("index follows updates and deletes", func(t *testing.T) {
+		if _, err := repo.DB().Exec(`UPDATE forward SET name = 'renamed' WHERE name = 'gamefast-0'`); err != nil {
+			t.Fatalf("rename forward: %v", err)
+		}
+		if _, err := repo.DB().Exec(`DELETE FROM forward WHERE name = 'gamefast-4'`); err != nil {
+			t.Fatalf("delete forward: %v", err)
+		}
+		out := search(adminToken, `{"query":"gamefast","types":["forward"]}`)
+		data := out.Data.(map[string]interface{})
+		if forwards, _ := data["forwards"].([]interface{}); len(forwards) != 4 {
+			t.Fatalf("expected 4 matching forwards after update, got %d", len(forwards))
+		}
+	})
+
+	t.Run("index only follows searched columns", func(t *testing.T) {
+		for _, trigger := range []string{"forward_fts_au", "tunnel_fts_au"} {
+			var ddl string
+			if err := repo.DB().QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'trigger' AND name = ?`, trigger).Scan(&ddl); err != nil {
+				t.Fatalf("load trigger %s: %v", trigger, err)
+			}
+			if !strings.Contains(ddl, "AFTER UPDATE OF") {
+				t.Fatalf("expected %s to be scoped to searched columns, got %s", trigger, ddl)
+			}
+		}
+		if _, err := repo.DB().Exec(`UPDATE tunnel SET in_ip = '198.51.100.20' WHERE id = ?`, tunnelID); err != nil {
+			t.Fatalf("update tunnel in_ip: %v", err)
+		}
+		out := search(adminToken, `{"query":"198.51.100.20","types":["forward"]}`)
+		data := out.Data.(map[string]interface{})
+		if forwards, _ := data["forwards"].([]interface{}); len(forwards) != 19 {
+			t.Fatalf("expected 19 forwards by new ingress ip, got %d", len(forwards))
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/mail/mailtest"
+	"go-backend/internal/security"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestSecurityEventNotificationContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	relay := mailtest.NewServer()
+	defer relay.Close()
+
+	hooks := make(chan map[string]interface{}, 16)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var payload map[string]interface{}
+		if err := json.Unmarshal(raw, &payload); err == nil {
+			hooks <- payload
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	now := time.Now().UnixMilli()
+	for name, value := range map[string]string{
+		"smtp_host": relay.Host,
+		"smtp_port": strconv.Itoa(relay.Port),
+		"smtp_from": "panel@example.com",
+	} {
+		if err := repo.UpsertConfig(name, value, now); err != nil {
+			t.Fatalf("set %s: %v", name, err)
+		}
+	}
+	hash, err := security.HashPassword("member-pass")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	userID, err := repo.CreateUser(&sqlite.User{
+		User:          "watched",
+		Pwd:           hash,
+		RoleID:        1,
+		ExpTime:       time.Now().Add(24 * time.Hour).UnixMilli(),
+		Flow:          99999,
+		Num:           99999,
+		FlowResetTime: 1,
+		Status:        1,
+		CreatedTime:   now,
+	})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := repo.UpdateUserEmail(userID, "watched@example.com", now); err != nil {
+		t.Fatalf("set email: %v", err)
+	}
+	userToken, err := contractToken(repo, userID, "watched", 1, secret)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+
+	post := func(path, token string, body interface{}) response.R {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+	nextHook := func(want string) map[string]interface{} {
+		t.Helper()
+		select {
+		case payload := <-hooks:
+			if payload["type"] != want {
+				t.Fatalf("expected a %s webhook, got %v", want, payload["type"])
+			}
+			return payload["data"].(map[string]interface{})
+		case <-time.After(3 * time.Second):
+			t.Fatalf("no %s webhook delivered", want)
+		}
+		return nil
+	}
+
+	if out := post("/api/v1/user/notification-pref/update", userToken, map[string]interface{}{"securityWebhookUrl": "ftp://example.com/hook"}); out.Code == 0 {
+		t.Fatalf("expected a non-http webhook to be rejected")
+	}
+	if out := post("/api/v1/user/notification-pref/update", userToken, map[string]interface{}{
+		"securityEmailEnabled": true,
+		"securityWebhookUrl":   receiver.URL,
+	}); out.Code != 0 {
+		t.Fatalf("update prefs: %d (%s)", out.Code, out.Msg)
+	}
+
+	// The receiver listens on loopback, which is refused until allowed.
+	if out := post("/api/v1/user/login", "", map[string]interface{}{"username": "watched", "password": "member-pass"}); out.Code != 0 {
+		t.Fatalf("login: %d (%s)", out.Code, out.Msg)
+	}
+	select {
+	case payload := <-hooks:
+		t.Fatalf("expected a loopback webhook to be blocked, got %v", payload)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if err := repo.UpsertConfig("webhook_allow_private", "true", now); err != nil {
+		t.Fatalf("allow private webhooks: %v", err)
+	}
+	if out := post("/api/v1/user/login", "", map[string]interface{}{"username": "watched", "password": "member-pass"}); out.Code != 0 {
+		t.Fatalf("login: %d (%s)", out.Code, out.Msg)
+	}
+	data := nextHook("security_login")
+	if data["username"] != "watched" || data["ip"] == "" {
+		t.Fatalf("unexpected login event %v", data)
+	}
+
+	if out := post("/api/v1/user/login", "", map[string]interface{}{"username": "watched", "password": "wrong-pass"}); out.Code == 0 {
+		t.Fatalf("expected the wrong password to fail")
+	}
+	nextHook("security_login_failed")
+	// Further failures right after are not sent again.
+	if out := post("/api/v1/user/login", "", map[string]interface{}{"username": "watched", "password": "wrong-again"}); out.Code == 0 {
+		t.Fatalf("expected the wrong password to fail")
+	}
+	select {
+	case payload := <-hooks:
+		t.Fatalf("expected repeated login failures to be throttled, got %v", payload)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if out := post("/api/v1/user/apikey/create", userToken, map[string]interface{}{"name": "ci"}); out.Code != 0 {
+		t.Fatalf("create api key: %d (%s)", out.Code, out.Msg)
+	}
+	if data := nextHook("security_apikey_created"); !strings.HasPrefix(valueAsString(data["detail"]), "ci (") {
+		t.Fatalf("expected the key name in the event, got %v", data["detail"])
+	}
+
+	// Two logins, the first failure and the key: one mail each.
+	deadline := time.Now().Add(3 * time.Second)
+	for len(relay.Messages()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	msgs := relay.Messages()
+	if len(msgs) != 4 {
+		t.Fatalf("expected 4 security mails, got %d", len(msgs))
+	}
+	for _, msg := range msgs {
+		if len(msg.To) != 1 || msg.To[0] != "watched@example.com" {
+			t.Fatalf("unexpected recipient %v", msg.To)
+		}
+	}
+}
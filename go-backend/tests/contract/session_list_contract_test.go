@@ -0,0 +1,132 @@
+package contract_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestUserSessionListContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	post := func(path, token, userAgent string, payload interface{}) response.R {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+	expectCode := func(out response.R, want int) {
+		t.Helper()
+		if out.Code != want {
+			t.Fatalf("expected code %d, got %d (%s)", want, out.Code, out.Msg)
+		}
+	}
+	const (
+		desktopUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36"
+		phoneUA   = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1"
+	)
+	login := func(username, userAgent string) string {
+		t.Helper()
+		out := post("/api/v1/user/login", "", userAgent, map[string]interface{}{"username": username, "password": "member-pass"})
+		expectCode(out, 0)
+		return valueAsString(out.Data.(map[string]interface{})["token"])
+	}
+	list := func(token string) []map[string]interface{} {
+		t.Helper()
+		out := post("/api/v1/user/session/list", token, desktopUA, map[string]interface{}{})
+		expectCode(out, 0)
+		raw, _ := json.Marshal(out.Data)
+		var items []map[string]interface{}
+		if err := json.Unmarshal(raw, &items); err != nil {
+			t.Fatalf("decode sessions: %v", err)
+		}
+		return items
+	}
+
+	hash, err := security.HashPassword("member-pass")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	now := time.Now().UnixMilli()
+	for _, name := range []string{"member", "other"} {
+		if _, err := repo.CreateUser(&sqlite.User{
+			User:          name,
+			Pwd:           hash,
+			RoleID:        1,
+			ExpTime:       time.Now().Add(24 * time.Hour).UnixMilli(),
+			Flow:          100,
+			FlowResetTime: 1,
+			Num:           10,
+			CreatedTime:   now,
+			UpdatedTime:   sql.NullInt64{Int64: now, Valid: true},
+			Status:        1,
+		}); err != nil {
+			t.Fatalf("create user %s: %v", name, err)
+		}
+	}
+
+	desktop := login("member", desktopUA)
+	phone := login("member", phoneUA)
+	otherToken := login("other", desktopUA)
+
+	sessions := list(desktop)
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+	var phoneID int64
+	for _, s := range sessions {
+		switch s["device"] {
+		case "Chrome / Windows":
+			if s["current"] != true {
+				t.Fatalf("expected the desktop session to be current, got %v", s)
+			}
+		case "Safari / iOS":
+			if s["current"] != false || s["ip"] == "" {
+				t.Fatalf("unexpected phone session %v", s)
+			}
+			phoneID = int64(s["id"].(float64))
+		default:
+			t.Fatalf("unexpected device %v", s["device"])
+		}
+	}
+
+	// Requests keep last-seen current.
+	if _, err := repo.DB().Exec(`UPDATE refresh_token SET last_seen_time = 0 WHERE id = ?`, phoneID); err != nil {
+		t.Fatalf("reset last seen: %v", err)
+	}
+	expectCode(post("/api/v1/user/package", phone, phoneUA, map[string]interface{}{}), 0)
+	assertCount(t, repo, `SELECT COUNT(1) FROM refresh_token WHERE id = ? AND last_seen_time > 0`, phoneID, 1)
+
+	// Another user cannot end the session; its owner can.
+	if out := post("/api/v1/user/session/revoke", otherToken, desktopUA, map[string]interface{}{"id": phoneID}); out.Code == 0 {
+		t.Fatalf("expected another user's session to be refused")
+	}
+	expectCode(post("/api/v1/user/package", phone, phoneUA, map[string]interface{}{}), 0)
+	expectCode(post("/api/v1/user/session/revoke", desktop, desktopUA, map[string]interface{}{"id": phoneID}), 0)
+	expectCode(post("/api/v1/user/package", phone, phoneUA, map[string]interface{}{}), 401)
+	expectCode(post("/api/v1/user/package", desktop, desktopUA, map[string]interface{}{}), 0)
+	if sessions := list(desktop); len(sessions) != 1 {
+		t.Fatalf("expected 1 session after revoking, got %d", len(sessions))
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM audit_log WHERE action = ?`, "session_revoke", 1)
+}
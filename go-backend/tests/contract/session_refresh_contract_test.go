@@ -0,0 +1,118 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+)
+
+func TestRefreshTokenSessionContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	post := func(path, token string, payload interface{}) response.R {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+	expectCode := func(out response.R, want int) {
+		t.Helper()
+		if out.Code != want {
+			t.Fatalf("expected code %d, got %d (%s)", want, out.Code, out.Msg)
+		}
+	}
+	tokensOf := func(out response.R) (string, string) {
+		t.Helper()
+		if out.Code != 0 {
+			t.Fatalf("expected success, got %d (%s)", out.Code, out.Msg)
+		}
+		data := out.Data.(map[string]interface{})
+		return valueAsString(data["token"]), valueAsString(data["refreshToken"])
+	}
+
+	access, refresh := tokensOf(post("/api/v1/user/login", "", map[string]interface{}{"username": "admin_user", "password": "admin_user"}))
+	if refresh == "" {
+		t.Fatalf("expected login to return a refresh token")
+	}
+	claims, err := auth.ParseClaims(access, secret)
+	if err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+	if claims.Sid <= 0 || claims.Exp-claims.Iat != int64(auth.AccessTokenTTL.Seconds()) {
+		t.Fatalf("expected a short-lived session token, got %+v", claims)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM refresh_token WHERE revoked_time = 0 AND id = ?`, claims.Sid, 1)
+
+	newAccess, newRefresh := tokensOf(post("/api/v1/user/refresh", "", map[string]interface{}{"refreshToken": refresh}))
+	if newRefresh == refresh {
+		t.Fatalf("expected the refresh token to rotate")
+	}
+	newClaims, err := auth.ParseClaims(newAccess, secret)
+	if err != nil || newClaims.Sid != claims.Sid {
+		t.Fatalf("expected the refreshed token to stay in session %d, got %+v (%v)", claims.Sid, newClaims, err)
+	}
+
+	t.Run("a rotated refresh token is refused", func(t *testing.T) {
+		if out := post("/api/v1/user/refresh", "", map[string]interface{}{"refreshToken": refresh}); out.Code != 401 {
+			t.Fatalf("expected 401, got %d (%s)", out.Code, out.Msg)
+		}
+	})
+
+	t.Run("access tokens of the session work", func(t *testing.T) {
+		expectCode(post("/api/v1/user/package", newAccess, map[string]interface{}{}), 0)
+		expectCode(post("/api/v1/user/package", access, map[string]interface{}{}), 0)
+	})
+
+	t.Run("logout revokes the session", func(t *testing.T) {
+		expectCode(post("/api/v1/user/logout", newAccess, map[string]interface{}{}), 0)
+		if out := post("/api/v1/user/package", newAccess, map[string]interface{}{}); out.Code != 401 {
+			t.Fatalf("expected revoked access token to be refused, got %d (%s)", out.Code, out.Msg)
+		}
+		if out := post("/api/v1/user/refresh", "", map[string]interface{}{"refreshToken": newRefresh}); out.Code != 401 {
+			t.Fatalf("expected revoked refresh token to be refused, got %d (%s)", out.Code, out.Msg)
+		}
+	})
+
+	t.Run("tokens without a session are refused", func(t *testing.T) {
+		legacy, err := auth.GenerateToken(1, "admin_user", 0, secret)
+		if err != nil {
+			t.Fatalf("generate token: %v", err)
+		}
+		if out := post("/api/v1/user/package", legacy, map[string]interface{}{}); out.Code != 401 {
+			t.Fatalf("expected a token without a session to be refused, got %d (%s)", out.Code, out.Msg)
+		}
+	})
+
+	t.Run("password change revokes every session", func(t *testing.T) {
+		first, _ := tokensOf(post("/api/v1/user/login", "", map[string]interface{}{"username": "admin_user", "password": "admin_user"}))
+		_, secondRefresh := tokensOf(post("/api/v1/user/login", "", map[string]interface{}{"username": "admin_user", "password": "admin_user"}))
+		expectCode(post("/api/v1/user/updatePassword", first, map[string]interface{}{
+			"newUsername":     "admin_user",
+			"currentPassword": "admin_user",
+			"newPassword":     "changed-pass",
+			"confirmPassword": "changed-pass",
+		}), 0)
+		if out := post("/api/v1/user/package", first, map[string]interface{}{}); out.Code != 401 {
+			t.Fatalf("expected access token to be refused after password change, got %d (%s)", out.Code, out.Msg)
+		}
+		if out := post("/api/v1/user/refresh", "", map[string]interface{}{"refreshToken": secondRefresh}); out.Code != 401 {
+			t.Fatalf("expected other sessions to be revoked, got %d (%s)", out.Code, out.Msg)
+		}
+	})
+}
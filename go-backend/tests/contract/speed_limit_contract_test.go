@@ -0,0 +1,139 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestSpeedLimitCRUDPushesLimitersContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	nodeID := insertContractNode(t, repo, "limit-node", "10.60.0.1", "46000-46010", "limit-node-secret", 0)
+	var mu sync.Mutex
+	commands := make([]string, 0)
+	stop := startMockNodeSessionWithPayloadHook(t, server.URL, "limit-node-secret", func(cmdType string, data json.RawMessage) {
+		if strings.Contains(cmdType, "Limiters") {
+			mu.Lock()
+			commands = append(commands, cmdType+" "+string(data))
+			mu.Unlock()
+		}
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+	takeCommands := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		out := commands
+		commands = make([]string, 0)
+		return out
+	}
+
+	now := time.Now().UnixMilli()
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('limit-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, body string) response.R {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	for _, body := range []string{
+		fmt.Sprintf(`{"name":"bad","speed":0,"tunnelId":%d}`, tunnelID),
+		fmt.Sprintf(`{"name":"","speed":10,"tunnelId":%d}`, tunnelID),
+		`{"name":"bad","speed":10,"tunnelId":99999}`,
+	} {
+		if out := post("/api/v1/speed-limit/create", body); out.Code == 0 {
+			t.Fatalf("expected %s to be rejected", body)
+		}
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM speed_limit WHERE name = ?`, "bad", 0)
+
+	out := post("/api/v1/speed-limit/create", fmt.Sprintf(`{"name":"limit-off","speed":80,"tunnelId":%d,"tunnelName":"limit-tunnel","status":0}`, tunnelID))
+	if out.Code != 0 {
+		t.Fatalf("create disabled speed limit: code %d (%s)", out.Code, out.Msg)
+	}
+	if got := takeCommands(); len(got) != 0 {
+		t.Fatalf("expected a disabled rule not to be pushed, got %v", got)
+	}
+
+	out = post("/api/v1/speed-limit/create", fmt.Sprintf(`{"name":"limit-80","speed":80,"tunnelId":%d,"tunnelName":"limit-tunnel","status":1}`, tunnelID))
+	if out.Code != 0 {
+		t.Fatalf("create speed limit: code %d (%s)", out.Code, out.Msg)
+	}
+	limitID := valueAsInt(out.Data.(map[string]interface{})["id"])
+	if got := takeCommands(); len(got) != 1 || !strings.Contains(got[0], "10.0MB") {
+		t.Fatalf("expected the limiter to be pushed to the entry node, got %v", got)
+	}
+
+	if out := post("/api/v1/speed-limit/update", fmt.Sprintf(`{"id":%d,"name":"limit-160","speed":160,"tunnelId":%d}`, limitID, tunnelID)); out.Code != 0 {
+		t.Fatalf("update speed limit: code %d (%s)", out.Code, out.Msg)
+	}
+	if got := takeCommands(); len(got) != 1 || !strings.HasPrefix(got[0], "UpdateLimiters") || !strings.Contains(got[0], "20.0MB") {
+		t.Fatalf("expected the new rate to replace the limiter, got %v", got)
+	}
+	if out := post("/api/v1/speed-limit/update", `{"id":99999,"name":"x","speed":1,"tunnelId":1}`); out.Code == 0 {
+		t.Fatalf("expected updating an unknown rule to fail")
+	}
+
+	// The panel form posts tunnelName next to the rule fields.
+	if out := post("/api/v1/speed-limit/update", fmt.Sprintf(`{"id":%d,"name":"limit-160","speed":160,"tunnelId":%d,"tunnelName":"limit-tunnel","status":0}`, limitID, tunnelID)); out.Code != 0 {
+		t.Fatalf("disable speed limit: code %d (%s)", out.Code, out.Msg)
+	}
+	if got := takeCommands(); len(got) != 1 || !strings.HasPrefix(got[0], "DeleteLimiters") {
+		t.Fatalf("expected disabling to remove the limiter, got %v", got)
+	}
+	if out := post("/api/v1/speed-limit/update", fmt.Sprintf(`{"id":%d,"name":"limit-160","speed":160,"tunnelId":%d,"tunnelName":"limit-tunnel","status":1}`, limitID, tunnelID)); out.Code != 0 {
+		t.Fatalf("enable speed limit: code %d (%s)", out.Code, out.Msg)
+	}
+	if got := takeCommands(); len(got) != 1 || !strings.Contains(got[0], "20.0MB") {
+		t.Fatalf("expected enabling to push the limiter again, got %v", got)
+	}
+
+	if _, err := repo.DB().Exec(`INSERT INTO user_tunnel(user_id, tunnel_id, num, flow, flow_reset_time, exp_time, status, speed_id) VALUES(1, ?, 1, 1, 1, ?, 1, ?)`, tunnelID, now, limitID); err != nil {
+		t.Fatalf("insert user_tunnel: %v", err)
+	}
+	if out := post("/api/v1/speed-limit/delete", fmt.Sprintf(`{"id":%d}`, limitID)); out.Code != 0 {
+		t.Fatalf("delete speed limit: code %d (%s)", out.Code, out.Msg)
+	}
+	if got := takeCommands(); len(got) != 1 || !strings.HasPrefix(got[0], "DeleteLimiters") {
+		t.Fatalf("expected the limiter to be removed from the node, got %v", got)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM user_tunnel WHERE speed_id = ?`, limitID, 0)
+	assertCount(t, repo, `SELECT COUNT(1) FROM speed_limit WHERE id = ?`, limitID, 0)
+	if out := post("/api/v1/speed-limit/delete", fmt.Sprintf(`{"id":%d}`, limitID)); out.Msg != "限速规则不存在" {
+		t.Fatalf("expected deleting it again to report a missing rule, got code %d (%s)", out.Code, out.Msg)
+	}
+}
@@ -0,0 +1,105 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/store/sqlite"
+)
+
+func TestSystemBackupContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	now := time.Now().UnixMilli()
+	nodeID := insertContractNode(t, repo, "backup-node", "10.70.0.1", "47000-47010", "backup-node-secret", 0)
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('backup-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	if _, err := repo.DB().Exec(`INSERT INTO chain_tunnel(tunnel_id, chain_type, node_id, port, strategy, inx, protocol) VALUES(?, 1, ?, NULL, 'round', 0, 'tls')`, tunnelID, nodeID); err != nil {
+		t.Fatalf("insert chain_tunnel: %v", err)
+	}
+	groupID, err := repo.DB().ExecReturningID(`INSERT INTO user_group(name, created_time, updated_time, status) VALUES('backup-group', ?, ?, 1)`, now, now)
+	if err != nil {
+		t.Fatalf("insert user group: %v", err)
+	}
+	if _, err := repo.DB().Exec(`INSERT INTO user_group_user(user_group_id, user_id, created_time) VALUES(?, 1, ?)`, groupID, now); err != nil {
+		t.Fatalf("insert user group member: %v", err)
+	}
+	if err := repo.CreatePeerShare(&sqlite.PeerShare{Name: "backup-share", NodeID: nodeID, Token: "backup-share-token", IsActive: 1, CreatedTime: now, UpdatedTime: now}); err != nil {
+		t.Fatalf("create peer share: %v", err)
+	}
+
+	backup := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/system/backup", bytes.NewBufferString(`{}`))
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("admin only", func(t *testing.T) {
+		userToken, err := contractToken(repo, 2, "backup_user", 1, secret)
+		if err != nil {
+			t.Fatalf("generate user token: %v", err)
+		}
+		if rec := backup(userToken); rec.Header().Get("X-Backup-Version") != "" {
+			t.Fatalf("expected a non-admin to be refused, got %s", rec.Body.String())
+		}
+	})
+
+	t.Run("archive holds a full snapshot", func(t *testing.T) {
+		adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+		if err != nil {
+			t.Fatalf("generate admin token: %v", err)
+		}
+		rec := backup(adminToken)
+		if got := rec.Header().Get("X-Backup-Version"); got != sqlite.BackupSnapshotVersion {
+			t.Fatalf("expected version header %q, got %q: %s", sqlite.BackupSnapshotVersion, got, rec.Body.String())
+		}
+		var archive sqlite.BackupData
+		if err := json.NewDecoder(rec.Body).Decode(&archive); err != nil {
+			t.Fatalf("decode archive: %v", err)
+		}
+		if archive.Version != sqlite.BackupSnapshotVersion || archive.ExportedAt <= 0 {
+			t.Fatalf("unexpected archive header %q/%d", archive.Version, archive.ExportedAt)
+		}
+		if len(archive.Users) == 0 || len(archive.Nodes) != 1 || archive.Nodes[0].Secret != "backup-node-secret" {
+			t.Fatalf("unexpected users/nodes in archive: %+v %+v", archive.Users, archive.Nodes)
+		}
+		if len(archive.Tunnels) != 1 || len(archive.Tunnels[0].ChainTunnels) != 1 {
+			t.Fatalf("expected the tunnel with its hop, got %+v", archive.Tunnels)
+		}
+		if len(archive.UserGroups) != 1 || len(archive.UserGroups[0].Users) != 1 {
+			t.Fatalf("expected the user group with its member, got %+v", archive.UserGroups)
+		}
+		if len(archive.PeerShares) != 1 || archive.PeerShares[0].Token != "backup-share-token" {
+			t.Fatalf("expected the federation share with its token, got %+v", archive.PeerShares)
+		}
+		if len(archive.Configs) == 0 {
+			t.Fatalf("expected configs in archive")
+		}
+
+		// The archive restores through /backup/import, which skips peerShares.
+		var body map[string]interface{}
+		raw, _ := json.Marshal(archive)
+		_ = json.Unmarshal(raw, &body)
+		body["types"] = []string{"configs"}
+		raw, _ = json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/backup/import", bytes.NewReader(raw))
+		req.Header.Set("Authorization", adminToken)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assertCode(t, rec, 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM peer_share WHERE token = ?`, "backup-share-token", 1)
+	})
+}
@@ -0,0 +1,101 @@
+package contract_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestTokenRevocationContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+
+	post := func(path, token string, payload interface{}) response.R {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+	expectCode := func(out response.R, want int) {
+		t.Helper()
+		if out.Code != want {
+			t.Fatalf("expected code %d, got %d (%s)", want, out.Code, out.Msg)
+		}
+	}
+	login := func(username string) (string, string) {
+		t.Helper()
+		out := post("/api/v1/user/login", "", map[string]interface{}{"username": username, "password": "member-pass"})
+		expectCode(out, 0)
+		data := out.Data.(map[string]interface{})
+		return valueAsString(data["token"]), valueAsString(data["refreshToken"])
+	}
+
+	hash, err := security.HashPassword("member-pass")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	now := time.Now().UnixMilli()
+	userID, err := repo.CreateUser(&sqlite.User{
+		User:          "member",
+		Pwd:           hash,
+		RoleID:        1,
+		ExpTime:       time.Now().Add(24 * time.Hour).UnixMilli(),
+		Flow:          100,
+		FlowResetTime: 1,
+		Num:           10,
+		CreatedTime:   now,
+		UpdatedTime:   sql.NullInt64{Int64: now, Valid: true},
+		Status:        1,
+	})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	t.Run("logout-all ends every session of the caller", func(t *testing.T) {
+		first, firstRefresh := login("member")
+		second, _ := login("member")
+		expectCode(post("/api/v1/user/package", first, map[string]interface{}{}), 0)
+
+		expectCode(post("/api/v1/user/logout-all", second, map[string]interface{}{}), 0)
+		expectCode(post("/api/v1/user/package", first, map[string]interface{}{}), 401)
+		expectCode(post("/api/v1/user/package", second, map[string]interface{}{}), 401)
+		expectCode(post("/api/v1/user/refresh", "", map[string]interface{}{"refreshToken": firstRefresh}), 401)
+	})
+
+	t.Run("disabling a user invalidates their tokens", func(t *testing.T) {
+		access, refresh := login("member")
+		expectCode(post("/api/v1/user/update", adminToken, map[string]interface{}{"id": userID, "user": "member", "status": 0}), 0)
+		expectCode(post("/api/v1/user/package", access, map[string]interface{}{}), 401)
+		expectCode(post("/api/v1/user/refresh", "", map[string]interface{}{"refreshToken": refresh}), 401)
+	})
+
+	t.Run("deleting a user removes their sessions", func(t *testing.T) {
+		expectCode(post("/api/v1/user/update", adminToken, map[string]interface{}{"id": userID, "user": "member", "status": 1}), 0)
+		access, _ := login("member")
+		expectCode(post("/api/v1/user/delete", adminToken, map[string]interface{}{"id": userID}), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM refresh_token WHERE user_id = ?`, userID, 0)
+		expectCode(post("/api/v1/user/package", access, map[string]interface{}{}), 401)
+	})
+}
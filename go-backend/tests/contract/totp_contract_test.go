@@ -0,0 +1,111 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/security/totp"
+)
+
+func TestTOTPEnrollmentAndLoginContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	post := func(path, token string, payload interface{}) response.R {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+	expectCode := func(out response.R, want int) response.R {
+		t.Helper()
+		if out.Code != want {
+			t.Fatalf("expected code %d, got %d (%s)", want, out.Code, out.Msg)
+		}
+		return out
+	}
+	login := func(code string) response.R {
+		return post("/api/v1/user/login", "", map[string]interface{}{"username": "admin_user", "password": "admin_user", "totpCode": code})
+	}
+
+	token := valueAsString(expectCode(login(""), 0).Data.(map[string]interface{})["token"])
+
+	setup := expectCode(post("/api/v1/user/totp/setup", token, map[string]interface{}{}), 0).Data.(map[string]interface{})
+	totpSecret := valueAsString(setup["secret"])
+	if totpSecret == "" || valueAsString(setup["uri"]) == "" {
+		t.Fatalf("expected a secret and provisioning uri, got %v", setup)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE id = 1 AND totp_enabled = 0 AND totp_secret <> ''`, nil, 1)
+
+	t.Run("pending secret is not enforced", func(t *testing.T) {
+		expectCode(login(""), 0)
+	})
+
+	t.Run("confirm rejects a wrong code", func(t *testing.T) {
+		stale, _ := totp.GenerateCode(totpSecret, time.Now().Add(-10*totp.Period))
+		expectCode(post("/api/v1/user/totp/confirm", token, map[string]interface{}{"code": stale}), 403)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE id = 1 AND totp_enabled = 1`, nil, 0)
+	})
+
+	code, _ := totp.GenerateCode(totpSecret, time.Now())
+	confirmed := expectCode(post("/api/v1/user/totp/confirm", token, map[string]interface{}{"code": code}), 0).Data.(map[string]interface{})
+	recoveryCodes := confirmed["recoveryCodes"].([]interface{})
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %v", confirmed)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM user_recovery_code WHERE user_id = 1 AND used_time = 0 AND code_hash <> ?`, valueAsString(recoveryCodes[0]), 10)
+
+	t.Run("login asks for the second step", func(t *testing.T) {
+		out := expectCode(login(""), 403)
+		if data, ok := out.Data.(map[string]interface{}); !ok || data["totpRequired"] != true {
+			t.Fatalf("expected totpRequired in %v", out.Data)
+		}
+		if out.Data.(map[string]interface{})["token"] != nil {
+			t.Fatalf("expected no token before the second step")
+		}
+		expectCode(login("000000"), 403)
+	})
+
+	t.Run("login accepts the current code", func(t *testing.T) {
+		code, _ := totp.GenerateCode(totpSecret, time.Now())
+		expectCode(login(code), 0)
+	})
+
+	t.Run("recovery codes work once", func(t *testing.T) {
+		recovery := valueAsString(recoveryCodes[0])
+		expectCode(login(recovery), 0)
+		expectCode(login(recovery), 403)
+		status := expectCode(post("/api/v1/user/totp/status", token, map[string]interface{}{}), 0).Data.(map[string]interface{})
+		if status["enabled"] != true || valueAsInt(status["recoveryCodesLeft"]) != 9 {
+			t.Fatalf("unexpected status %v", status)
+		}
+	})
+
+	t.Run("setup is refused while enabled", func(t *testing.T) {
+		expectCode(post("/api/v1/user/totp/setup", token, map[string]interface{}{}), -1)
+	})
+
+	t.Run("disable needs password and second factor", func(t *testing.T) {
+		code, _ := totp.GenerateCode(totpSecret, time.Now())
+		expectCode(post("/api/v1/user/totp/disable", token, map[string]interface{}{"password": "wrong", "code": code}), -1)
+		expectCode(post("/api/v1/user/totp/disable", token, map[string]interface{}{"password": "admin_user", "code": "000000"}), 403)
+		expectCode(post("/api/v1/user/totp/disable", token, map[string]interface{}{"password": "admin_user", "code": code}), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user_recovery_code WHERE user_id = ?`, 1, 0)
+		expectCode(login(""), 0)
+	})
+}
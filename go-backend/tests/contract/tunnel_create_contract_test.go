@@ -8,10 +8,10 @@ import (
 	"net/http/httptest"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"go-backend/internal/auth"
 	"go-backend/internal/http/response"
 )
 
@@ -20,7 +20,7 @@ func TestTunnelCreateRuntimeRollbackContract(t *testing.T) {
 	router, repo := setupContractRouter(t, secret)
 	now := time.Now().UnixMilli()
 
-	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
@@ -85,7 +85,7 @@ func TestTunnelUpdateAssignsChainPortsContract(t *testing.T) {
 	router, repo := setupContractRouter(t, secret)
 	now := time.Now().UnixMilli()
 
-	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
@@ -161,3 +161,213 @@ func TestTunnelUpdateAssignsChainPortsContract(t *testing.T) {
 func jsonInt(v int64) string {
 	return strconv.FormatInt(v, 10)
 }
+
+func TestDirectTunnelCreateContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	nodeID := insertContractNode(t, repo, "direct-node", "10.40.0.1", "43000-43010", "direct-node-secret", 0)
+	otherID := insertContractNode(t, repo, "direct-other", "10.40.0.2", "44000-44010", "direct-other-secret", 1)
+
+	var mu sync.Mutex
+	addServices := make([]json.RawMessage, 0)
+	stop := startMockNodeSessionWithPayloadHook(t, server.URL, "direct-node-secret", func(cmdType string, data json.RawMessage) {
+		if cmdType == "AddService" {
+			mu.Lock()
+			addServices = append(addServices, data)
+			mu.Unlock()
+		}
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, payload string) response.R {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(payload))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	t.Run("rejects a different exit node", func(t *testing.T) {
+		out := post("/api/v1/tunnel/create", `{"name":"direct-bad","type":3,"flow":99999,"status":1,"inNodeId":[{"nodeId":`+jsonInt(nodeID)+`}],"outNodeId":[{"nodeId":`+jsonInt(otherID)+`}]}`)
+		if out.Code == 0 {
+			t.Fatalf("expected direct tunnel with a separate exit to be rejected")
+		}
+	})
+
+	t.Run("rejects chain hops", func(t *testing.T) {
+		out := post("/api/v1/tunnel/create", `{"name":"direct-chain","type":3,"flow":99999,"status":1,"inNodeId":[{"nodeId":`+jsonInt(nodeID)+`}],"chainNodes":[[{"nodeId":`+jsonInt(otherID)+`}]]}`)
+		if out.Code == 0 {
+			t.Fatalf("expected direct tunnel with chain hops to be rejected")
+		}
+	})
+
+	out := post("/api/v1/tunnel/create", `{"name":"direct-tunnel","type":3,"flow":99999,"status":1,"inNodeId":[{"nodeId":`+jsonInt(nodeID)+`,"protocol":"tls"}],"outNodeId":[{"nodeId":`+jsonInt(nodeID)+`}]}`)
+	if out.Code != 0 {
+		t.Fatalf("create direct tunnel: code %d (%s)", out.Code, out.Msg)
+	}
+	var tunnelID int64
+	if err := repo.DB().QueryRow(`SELECT id FROM tunnel WHERE name = 'direct-tunnel'`).Scan(&tunnelID); err != nil {
+		t.Fatalf("query tunnel: %v", err)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM chain_tunnel WHERE tunnel_id = ?`, tunnelID, 1)
+	assertCount(t, repo, `SELECT COUNT(1) FROM chain_tunnel WHERE tunnel_id = ? AND chain_type = '0'`, tunnelID, 1)
+
+	out = post("/api/v1/forward/create", `{"name":"direct-forward","tunnelId":`+jsonInt(tunnelID)+`,"remoteAddr":"1.1.1.1:443"}`)
+	if out.Code != 0 {
+		t.Fatalf("create forward: code %d (%s)", out.Code, out.Msg)
+	}
+	assertCount(t, repo, `SELECT COUNT(1) FROM forward_port WHERE node_id = ?`, nodeID, 1)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(addServices) != 1 {
+		t.Fatalf("expected one AddService, got %d", len(addServices))
+	}
+	var services []map[string]interface{}
+	if err := json.Unmarshal(addServices[0], &services); err != nil {
+		t.Fatalf("decode AddService payload: %v", err)
+	}
+	if len(services) == 0 {
+		t.Fatalf("expected services in AddService payload")
+	}
+	for _, svc := range services {
+		handler, _ := svc["handler"].(map[string]interface{})
+		if _, ok := handler["chain"]; ok {
+			t.Fatalf("expected direct tunnel service without chain hop, got %v", svc)
+		}
+	}
+}
+
+func TestTunnelDSCPMarkContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	nodeID := insertContractNode(t, repo, "dscp-node", "10.41.0.1", "45000-45010", "dscp-node-secret", 0)
+
+	var mu sync.Mutex
+	addServices := make([]json.RawMessage, 0)
+	updateServices := make([]json.RawMessage, 0)
+	stop := startMockNodeSessionWithPayloadHook(t, server.URL, "dscp-node-secret", func(cmdType string, data json.RawMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch cmdType {
+		case "AddService":
+			addServices = append(addServices, data)
+		case "UpdateService":
+			updateServices = append(updateServices, data)
+		}
+	})
+	defer stop()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, payload string) response.R {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(payload))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	t.Run("rejects out of range mark", func(t *testing.T) {
+		out := post("/api/v1/tunnel/create", `{"name":"dscp-bad","type":1,"flow":99999,"status":1,"dscpMark":64,"inNodeId":[{"nodeId":`+jsonInt(nodeID)+`}]}`)
+		if out.Code == 0 {
+			t.Fatalf("expected dscpMark 64 to be rejected")
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM tunnel WHERE name = ?`, "dscp-bad", 0)
+	})
+
+	out := post("/api/v1/tunnel/create", `{"name":"dscp-tunnel","type":1,"flow":99999,"status":1,"dscpMark":46,"inNodeId":[{"nodeId":`+jsonInt(nodeID)+`}]}`)
+	if out.Code != 0 {
+		t.Fatalf("create tunnel: code %d (%s)", out.Code, out.Msg)
+	}
+	var tunnelID int64
+	if err := repo.DB().QueryRow(`SELECT id FROM tunnel WHERE name = 'dscp-tunnel'`).Scan(&tunnelID); err != nil {
+		t.Fatalf("query tunnel: %v", err)
+	}
+
+	listOut := post("/api/v1/tunnel/list", `{}`)
+	tunnels, _ := listOut.Data.([]interface{})
+	if len(tunnels) != 1 || valueAsInt(tunnels[0].(map[string]interface{})["dscpMark"]) != 46 {
+		t.Fatalf("expected tunnel list to expose dscpMark 46, got %v", listOut.Data)
+	}
+
+	out = post("/api/v1/forward/create", `{"name":"dscp-forward","tunnelId":`+jsonInt(tunnelID)+`,"remoteAddr":"1.1.1.1:443"}`)
+	if out.Code != 0 {
+		t.Fatalf("create forward: code %d (%s)", out.Code, out.Msg)
+	}
+
+	expectMark := func(t *testing.T, payload json.RawMessage, mark int) {
+		t.Helper()
+		var services []map[string]interface{}
+		if err := json.Unmarshal(payload, &services); err != nil {
+			t.Fatalf("decode service payload: %v", err)
+		}
+		if len(services) == 0 {
+			t.Fatalf("expected services in payload")
+		}
+		for _, svc := range services {
+			if valueAsInt(svc["dscpMark"]) != mark {
+				t.Fatalf("expected dscpMark %d in service %v", mark, svc)
+			}
+		}
+	}
+
+	mu.Lock()
+	if len(addServices) != 1 {
+		mu.Unlock()
+		t.Fatalf("expected one AddService, got %d", len(addServices))
+	}
+	expectMark(t, addServices[0], 46)
+	mu.Unlock()
+
+	updateTunnel := func(extra string) {
+		t.Helper()
+		out := post("/api/v1/tunnel/update", `{"id":`+jsonInt(tunnelID)+`,"name":"dscp-tunnel","type":1,"flow":99999,"status":1`+extra+`,"inNodeId":[{"nodeId":`+jsonInt(nodeID)+`}]}`)
+		if out.Code != 0 {
+			t.Fatalf("update tunnel: code %d (%s)", out.Code, out.Msg)
+		}
+	}
+
+	t.Run("update without dscpMark keeps the mark", func(t *testing.T) {
+		updateTunnel("")
+		assertCount(t, repo, `SELECT COUNT(1) FROM tunnel WHERE dscp_mark = 46 AND id = ?`, tunnelID, 1)
+		mu.Lock()
+		defer mu.Unlock()
+		if len(updateServices) != 0 {
+			t.Fatalf("expected no forward re-sync, got %d UpdateService", len(updateServices))
+		}
+	})
+
+	t.Run("changing the mark re-syncs forwards", func(t *testing.T) {
+		updateTunnel(`,"dscpMark":10`)
+		assertCount(t, repo, `SELECT COUNT(1) FROM tunnel WHERE dscp_mark = 10 AND id = ?`, tunnelID, 1)
+		mu.Lock()
+		defer mu.Unlock()
+		if len(updateServices) != 1 {
+			t.Fatalf("expected one UpdateService, got %d", len(updateServices))
+		}
+		expectMark(t, updateServices[0], 10)
+	})
+}
@@ -0,0 +1,109 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"go-backend/internal/http/response"
+)
+
+func TestTunnelUpdateAppliesChangedHopsContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	var mu sync.Mutex
+	commands := make([]string, 0)
+	nodes := map[string]int64{}
+	for i, name := range []string{"entry", "relay", "exit", "exit2"} {
+		nodeSecret := "diff-" + name + "-secret"
+		nodes[name] = insertContractNode(t, repo, "diff-"+name, fmt.Sprintf("10.50.0.%d", i+1), fmt.Sprintf("%d-%d", 45000+i*100, 45010+i*100), nodeSecret, 0)
+		name := name
+		stop := startMockNodeSessionWithPayloadHook(t, server.URL, nodeSecret, func(cmdType string, _ json.RawMessage) {
+			mu.Lock()
+			commands = append(commands, name+":"+cmdType)
+			mu.Unlock()
+		})
+		defer stop()
+		waitNodeStatus(t, repo, nodes[name], 1)
+	}
+	takeCommands := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		out := commands
+		commands = make([]string, 0)
+		sort.Strings(out)
+		return out
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, payload string) response.R {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(payload))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+	layout := func(name, exit string) string {
+		return fmt.Sprintf(`"name":%q,"type":2,"flow":99999,"trafficRatio":1.0,"status":1,"inNodeId":[{"nodeId":%d,"protocol":"tls"}],"chainNodes":[[{"nodeId":%d,"protocol":"tls","strategy":"round"}]],"outNodeId":[{"nodeId":%d,"protocol":"tls"}]`,
+			name, nodes["entry"], nodes["relay"], nodes[exit])
+	}
+
+	if out := post("/api/v1/tunnel/create", "{"+layout("diff-tunnel", "exit")+"}"); out.Code != 0 {
+		t.Fatalf("create tunnel: code %d (%s)", out.Code, out.Msg)
+	}
+	var tunnelID int64
+	if err := repo.DB().QueryRow(`SELECT id FROM tunnel WHERE name = 'diff-tunnel'`).Scan(&tunnelID); err != nil {
+		t.Fatalf("query tunnel id: %v", err)
+	}
+	var relayPort int
+	if err := repo.DB().QueryRow(`SELECT port FROM chain_tunnel WHERE tunnel_id = ? AND node_id = ?`, tunnelID, nodes["relay"]).Scan(&relayPort); err != nil {
+		t.Fatalf("query relay port: %v", err)
+	}
+	takeCommands()
+
+	t.Run("a rename leaves the runtime alone", func(t *testing.T) {
+		if out := post("/api/v1/tunnel/update", fmt.Sprintf(`{"id":%d,%s}`, tunnelID, layout("diff-renamed", "exit"))); out.Code != 0 {
+			t.Fatalf("update tunnel: code %d (%s)", out.Code, out.Msg)
+		}
+		if got := takeCommands(); len(got) != 0 {
+			t.Fatalf("expected no node commands, got %v", got)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM tunnel WHERE name = 'diff-renamed' AND id = ?`, tunnelID, 1)
+	})
+
+	t.Run("swapping the exit touches only the affected hops", func(t *testing.T) {
+		if out := post("/api/v1/tunnel/update", fmt.Sprintf(`{"id":%d,%s}`, tunnelID, layout("diff-renamed", "exit2"))); out.Code != 0 {
+			t.Fatalf("update tunnel: code %d (%s)", out.Code, out.Msg)
+		}
+		got := strings.Join(takeCommands(), " ")
+		want := "exit2:AddService exit:DeleteService relay:AddChains relay:AddService relay:DeleteChains relay:DeleteService"
+		if got != want {
+			t.Fatalf("expected commands %q, got %q", want, got)
+		}
+		var port int
+		if err := repo.DB().QueryRow(`SELECT port FROM chain_tunnel WHERE tunnel_id = ? AND node_id = ?`, tunnelID, nodes["relay"]).Scan(&port); err != nil {
+			t.Fatalf("query relay port: %v", err)
+		}
+		if port != relayPort {
+			t.Fatalf("expected the relay to keep port %d, got %d", relayPort, port)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM chain_tunnel WHERE node_id = ?`, nodes["exit"], 0)
+	})
+}
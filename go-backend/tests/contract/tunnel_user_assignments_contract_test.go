@@ -0,0 +1,180 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestAdminTunnelUserAssignmentsContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	now := time.Now().UnixMilli()
+	const gb = int64(1024 * 1024 * 1024)
+
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('assign-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+	res, err = repo.DB().Exec(`
+		INSERT INTO speed_limit(name, speed, tunnel_id, tunnel_name, created_time, updated_time, status)
+		VALUES('100M', 100, ?, 'assign-tunnel', ?, ?, 1)
+	`, tunnelID, now, now)
+	if err != nil {
+		t.Fatalf("insert speed_limit: %v", err)
+	}
+	speedID, _ := res.LastInsertId()
+
+	seed := []struct {
+		userID  int64
+		name    string
+		flowGB  int64
+		used    int64
+		speedID interface{}
+	}{
+		{2, "fresh_user", 10, 0, speedID},
+		{3, "exhausted_user", 1, gb + 1, nil},
+		{4, "unlimited_user", 0, 5 * gb, nil},
+	}
+	for _, s := range seed {
+		if _, err := repo.DB().Exec(`
+			INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+			VALUES(?, ?, '3c85cdebade1c51cf64ca9f3c09d182d', 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+		`, s.userID, s.name, now, now); err != nil {
+			t.Fatalf("insert user: %v", err)
+		}
+		if _, err := repo.DB().Exec(`
+			INSERT INTO user_tunnel(user_id, tunnel_id, speed_id, num, flow, in_flow, out_flow, flow_reset_time, exp_time, status)
+			VALUES(?, ?, ?, 10, ?, ?, 0, 1, 2727251700000, 1)
+		`, s.userID, tunnelID, s.speedID, s.flowGB, s.used); err != nil {
+			t.Fatalf("insert user_tunnel: %v", err)
+		}
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/tunnel/user-assignments", bytes.NewBufferString(fmt.Sprintf(`{"tunnelId":%d,"page":1,"pageSize":50}`, tunnelID)))
+	req.Header.Set("Authorization", adminToken)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var out response.R
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Code != 0 {
+		t.Fatalf("user assignments: code %d (%s)", out.Code, out.Msg)
+	}
+	data, _ := out.Data.(map[string]interface{})
+	if valueAsInt(data["total"]) != 3 {
+		t.Fatalf("expected total 3, got %v", data["total"])
+	}
+	items, _ := data["list"].([]interface{})
+	if len(items) != 3 {
+		t.Fatalf("expected 3 assignments, got %d", len(items))
+	}
+
+	byUser := make(map[string]map[string]interface{})
+	for _, raw := range items {
+		item := raw.(map[string]interface{})
+		byUser[item["username"].(string)] = item
+	}
+	for name, want := range map[string]bool{"fresh_user": false, "exhausted_user": true, "unlimited_user": false} {
+		if got := byUser[name]["overQuota"]; got != want {
+			t.Fatalf("expected %s overQuota=%v, got %v", name, want, got)
+		}
+	}
+	if byUser["fresh_user"]["speedName"] != "100M" {
+		t.Fatalf("expected speed name for fresh_user, got %v", byUser["fresh_user"]["speedName"])
+	}
+	if valueAsInt(byUser["exhausted_user"]["remainingFlow"]) != 0 {
+		t.Fatalf("expected no remaining flow for exhausted_user, got %v", byUser["exhausted_user"]["remainingFlow"])
+	}
+}
+
+func TestAdminTunnelBulkExtendContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	now := time.Now().UnixMilli()
+	const day = int64(24 * time.Hour / time.Millisecond)
+
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('extend-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+
+	seed := []struct {
+		userID int64
+		expiry int64
+		status int
+	}{
+		{2, now + 3*day, 0},
+		{3, now + 20*day, 1},
+		{4, now + 60*day, 1},
+	}
+	for _, s := range seed {
+		if _, err := repo.DB().Exec(`
+			INSERT INTO user_tunnel(user_id, tunnel_id, speed_id, num, flow, in_flow, out_flow, flow_reset_time, exp_time, status)
+			VALUES(?, ?, NULL, 10, 10, 0, 0, 1, ?, ?)
+		`, s.userID, tunnelID, s.expiry, s.status); err != nil {
+			t.Fatalf("insert user_tunnel: %v", err)
+		}
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	body := fmt.Sprintf(`{"tunnelId":%d,"extendDays":30,"onlyExpiringSoon":true,"withinDays":7}`, tunnelID)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/tunnel/bulk-extend", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", adminToken)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var out response.R
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Code != 0 {
+		t.Fatalf("expected code 0, got %d (%s)", out.Code, out.Msg)
+	}
+	data, _ := out.Data.(map[string]interface{})
+	if updated := valueAsInt(data["updated"]); updated != 1 {
+		t.Fatalf("expected 1 updated permission, got %d", updated)
+	}
+
+	for _, s := range seed {
+		var expiry int64
+		var status int
+		if err := repo.DB().QueryRow(`SELECT exp_time, status FROM user_tunnel WHERE user_id = ? AND tunnel_id = ?`, s.userID, tunnelID).Scan(&expiry, &status); err != nil {
+			t.Fatalf("query user_tunnel %d: %v", s.userID, err)
+		}
+		wantExpiry, wantStatus := s.expiry, s.status
+		if s.userID == 2 {
+			wantExpiry, wantStatus = s.expiry+30*day, 1
+		}
+		if expiry != wantExpiry || status != wantStatus {
+			t.Fatalf("user %d: expected exp_time=%d status=%d, got exp_time=%d status=%d", s.userID, wantExpiry, wantStatus, expiry, status)
+		}
+	}
+
+	assertCount(t, repo, `SELECT COUNT(1) FROM audit_log WHERE action = ?`, "tunnel_bulk_extend", 1)
+}
@@ -7,7 +7,6 @@ import (
 	"testing"
 	"time"
 
-	"go-backend/internal/auth"
 	"go-backend/internal/http/response"
 )
 
@@ -61,11 +60,11 @@ func TestUserTunnelVisibleListContracts(t *testing.T) {
 		t.Fatalf("insert user_tunnel disabledC: %v", err)
 	}
 
-	adminToken, err := auth.GenerateToken(1, "admin_user", 0, secret)
+	adminToken, err := diagnosisContractToken(repo, 1, "admin_user", 0, secret)
 	if err != nil {
 		t.Fatalf("generate admin token: %v", err)
 	}
-	userToken, err := auth.GenerateToken(2, "normal_user", 1, secret)
+	userToken, err := diagnosisContractToken(repo, 2, "normal_user", 1, secret)
 	if err != nil {
 		t.Fatalf("generate user token: %v", err)
 	}
@@ -0,0 +1,87 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+	"go-backend/internal/security/totp"
+)
+
+func TestUpdatePasswordRequiresTOTPWhenEnabled(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	const totpSecret = "JBSWY3DPEHPK3PXP"
+	crypto, err := security.NewAESCrypto(secret)
+	if err != nil {
+		t.Fatalf("new crypto: %v", err)
+	}
+	encrypted, err := crypto.Encrypt([]byte(totpSecret))
+	if err != nil {
+		t.Fatalf("encrypt totp secret: %v", err)
+	}
+
+	now := time.Now().UnixMilli()
+	if _, err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status, totp_enabled, totp_secret)
+		VALUES(2, 'totp_user', ?, 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1, 1, ?)
+	`, security.MD5("old-password"), now, now, encrypted); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+
+	userToken, err := contractToken(repo, 2, "totp_user", 1, secret)
+	if err != nil {
+		t.Fatalf("generate user token: %v", err)
+	}
+	update := func(totpCode string) response.R {
+		body := fmt.Sprintf(`{"newUsername":"totp_user","currentPassword":"old-password","newPassword":"new-password","confirmPassword":"new-password","totpCode":%q}`, totpCode)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/user/updatePassword", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", userToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	t.Run("missing code is rejected", func(t *testing.T) {
+		if out := update(""); out.Code != 403 {
+			t.Fatalf("expected 403, got %d (%s)", out.Code, out.Msg)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE id = 2 AND pwd = ?`, security.MD5("old-password"), 1)
+	})
+
+	t.Run("wrong code is rejected", func(t *testing.T) {
+		code, _ := totp.GenerateCode(totpSecret, time.Now().Add(-10*totp.Period))
+		if out := update(code); out.Code != 403 {
+			t.Fatalf("expected 403, got %d (%s)", out.Code, out.Msg)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE id = 2 AND pwd = ?`, security.MD5("old-password"), 1)
+	})
+
+	t.Run("correct code updates password", func(t *testing.T) {
+		code, err := totp.GenerateCode(totpSecret, time.Now())
+		if err != nil {
+			t.Fatalf("generate code: %v", err)
+		}
+		if out := update(code); out.Code != 0 {
+			t.Fatalf("expected success, got %d (%s)", out.Code, out.Msg)
+		}
+		var stored string
+		if err := repo.DB().QueryRow(`SELECT pwd FROM user WHERE id = 2`).Scan(&stored); err != nil {
+			t.Fatalf("query password hash: %v", err)
+		}
+		if ok, _ := security.VerifyPassword(stored, "new-password"); !ok {
+			t.Fatalf("expected stored hash to verify the new password")
+		}
+	})
+}
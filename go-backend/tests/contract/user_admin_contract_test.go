@@ -0,0 +1,151 @@
+package contract_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestUserAdminContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+
+	post := func(path, token string, payload interface{}) response.R {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+	expectCode := func(out response.R, want int) {
+		t.Helper()
+		if out.Code != want {
+			t.Fatalf("expected code %d, got %d (%s)", want, out.Code, out.Msg)
+		}
+	}
+	login := func(password string) response.R {
+		t.Helper()
+		return post("/api/v1/user/login", "", map[string]interface{}{"username": "member", "password": password})
+	}
+
+	hash, err := security.HashPassword("member-pass")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	now := time.Now().UnixMilli()
+	userID, err := repo.CreateUser(&sqlite.User{
+		User:          "member",
+		Pwd:           hash,
+		RoleID:        1,
+		ExpTime:       time.Now().Add(24 * time.Hour).UnixMilli(),
+		Flow:          100,
+		FlowResetTime: 1,
+		Num:           10,
+		CreatedTime:   now,
+		UpdatedTime:   sql.NullInt64{Int64: now, Valid: true},
+		Status:        1,
+	})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	exec := func(query string, args ...interface{}) int64 {
+		t.Helper()
+		res, err := repo.DB().Exec(query, args...)
+		if err != nil {
+			t.Fatalf("exec %s: %v", query, err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+	tunnelID := exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('member-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	exec(`INSERT INTO user_tunnel(user_id, tunnel_id, num, flow, flow_reset_time, exp_time, status) VALUES(?, ?, 1, 1, 1, ?, 1)`, userID, tunnelID, now)
+	forwardID := exec(`
+		INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+		VALUES(?, 'member', 'member-forward', ?, '1.1.1.1:443', 'fifo', 0, 0, ?, ?, 1, 0)
+	`, userID, tunnelID, now, now)
+
+	t.Run("reset password replaces the password and ends sessions", func(t *testing.T) {
+		out := login("member-pass")
+		expectCode(out, 0)
+		oldToken := valueAsString(out.Data.(map[string]interface{})["token"])
+
+		expectCode(post("/api/v1/user/reset-password", adminToken, map[string]interface{}{"id": userID, "password": ""}), -1)
+		expectCode(post("/api/v1/user/reset-password", adminToken, map[string]interface{}{"id": userID, "password": "fresh-pass-1"}), 0)
+
+		if login("member-pass").Code == 0 {
+			t.Fatalf("expected the old password to stop working")
+		}
+		expectCode(login("fresh-pass-1"), 0)
+		if post("/api/v1/user/package", oldToken, map[string]interface{}{}).Code == 0 {
+			t.Fatalf("expected the old session to be revoked")
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM audit_log WHERE action = 'user_password_reset' AND target_id = ?`, userID, 1)
+	})
+
+	t.Run("toggle status disables the user and pauses forwards", func(t *testing.T) {
+		out := post("/api/v1/user/toggle-status", adminToken, map[string]interface{}{"id": userID})
+		expectCode(out, 0)
+		if got := valueAsInt(out.Data.(map[string]interface{})["status"]); got != 0 {
+			t.Fatalf("expected the user to be disabled, got status %d", got)
+		}
+		if login("fresh-pass-1").Code == 0 {
+			t.Fatalf("expected a disabled user to be refused")
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE status = 0 AND id = ?`, forwardID, 1)
+
+		expectCode(post("/api/v1/user/toggle-status", adminToken, map[string]interface{}{"id": userID, "status": 1}), 0)
+		expectCode(login("fresh-pass-1"), 0)
+		expectCode(post("/api/v1/user/toggle-status", adminToken, map[string]interface{}{"id": userID, "status": 2}), -1)
+	})
+
+	t.Run("disabling through user update pauses forwards", func(t *testing.T) {
+		exec(`UPDATE forward SET status = 1 WHERE id = ?`, forwardID)
+		expectCode(post("/api/v1/user/update", adminToken, map[string]interface{}{"id": userID, "user": "member", "status": 0}), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE status = 0 AND id = ?`, forwardID, 1)
+		if login("fresh-pass-1").Code == 0 {
+			t.Fatalf("expected a disabled user to be refused")
+		}
+	})
+
+	t.Run("the admin account cannot be changed", func(t *testing.T) {
+		admin, err := repo.CreateUser(&sqlite.User{User: "root", Pwd: hash, RoleID: 0, ExpTime: now, Flow: 1, FlowResetTime: 1, Num: 1, CreatedTime: now, Status: 1})
+		if err != nil {
+			t.Fatalf("create admin: %v", err)
+		}
+		expectCode(post("/api/v1/user/toggle-status", adminToken, map[string]interface{}{"id": admin}), -1)
+		expectCode(post("/api/v1/user/reset-password", adminToken, map[string]interface{}{"id": admin, "password": "fresh-pass-1"}), -1)
+		expectCode(post("/api/v1/user/toggle-status", adminToken, map[string]interface{}{"id": 99999}), -1)
+	})
+
+	t.Run("delete removes forwards and tunnel assignments", func(t *testing.T) {
+		expectCode(post("/api/v1/user/delete", adminToken, map[string]interface{}{"id": userID}), 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE id = ?`, userID, 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE user_id = ?`, userID, 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user_tunnel WHERE user_id = ?`, userID, 0)
+		assertCount(t, repo, `SELECT COUNT(1) FROM audit_log WHERE action = 'user_delete' AND target_id = ?`, userID, 1)
+	})
+}
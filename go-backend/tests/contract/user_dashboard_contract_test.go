@@ -0,0 +1,155 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestUserDashboardContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	now := time.Now()
+	nowMs := now.UnixMilli()
+	const gb = int64(1024 * 1024 * 1024)
+
+	if _, err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(2, 'dashboard_user', '3c85cdebade1c51cf64ca9f3c09d182d', 1, 2727251700000, 10, ?, ?, 1, 99999, ?, ?, 1)
+	`, gb, 2*gb, nowMs, nowMs); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+
+	tunnelIDs := make([]int64, 0, 3)
+	for _, name := range []string{"dash-a", "dash-b", "dash-c"} {
+		res, err := repo.DB().Exec(`
+			INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+			VALUES(?, 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+		`, name, nowMs, nowMs)
+		if err != nil {
+			t.Fatalf("insert tunnel: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		tunnelIDs = append(tunnelIDs, id)
+	}
+	expTimes := []int64{
+		now.Add(3 * 24 * time.Hour).UnixMilli(),
+		now.Add(30 * 24 * time.Hour).UnixMilli(),
+		now.Add(-time.Hour).UnixMilli(),
+	}
+	for i, tunnelID := range tunnelIDs {
+		if _, err := repo.DB().Exec(`
+			INSERT INTO user_tunnel(user_id, tunnel_id, speed_id, num, flow, in_flow, out_flow, flow_reset_time, exp_time, status)
+			VALUES(2, ?, NULL, 10, 100, 0, 0, 1, ?, 1)
+		`, tunnelID, expTimes[i]); err != nil {
+			t.Fatalf("insert user_tunnel: %v", err)
+		}
+	}
+
+	insertForward := func(name string) {
+		t.Helper()
+		if _, err := repo.DB().Exec(`
+			INSERT INTO forward(user_id, user_name, name, tunnel_id, remote_addr, strategy, in_flow, out_flow, created_time, updated_time, status, inx)
+			VALUES(2, 'dashboard_user', ?, ?, '1.1.1.1:443', 'fifo', 0, 0, ?, ?, 1, 0)
+		`, name, tunnelIDs[0], nowMs, nowMs); err != nil {
+			t.Fatalf("insert forward: %v", err)
+		}
+	}
+	insertForward("dash-forward-1")
+	insertForward("dash-forward-2")
+
+	hour := now.Truncate(time.Hour)
+	for _, s := range []struct {
+		at   time.Time
+		flow int64
+	}{
+		{hour, 500},
+		{hour.Add(-2 * time.Hour), 300},
+		{hour.Add(-30 * time.Hour), 999},
+	} {
+		if _, err := repo.DB().Exec(`
+			INSERT INTO statistics_flow(user_id, flow, total_flow, time, created_time)
+			VALUES(2, ?, 0, ?, ?)
+		`, s.flow, s.at.Format("15:04"), s.at.UnixMilli()); err != nil {
+			t.Fatalf("insert statistics_flow: %v", err)
+		}
+	}
+
+	userToken, err := contractToken(repo, 2, "dashboard_user", 1, secret)
+	if err != nil {
+		t.Fatalf("generate user token: %v", err)
+	}
+	dashboard := func() map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/user/dashboard", bytes.NewBufferString(`{}`))
+		req.Header.Set("Authorization", userToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if out.Code != 0 {
+			t.Fatalf("dashboard: code %d (%s)", out.Code, out.Msg)
+		}
+		data, _ := out.Data.(map[string]interface{})
+		return data
+	}
+
+	first := dashboard()
+
+	t.Run("fields reflect seeded data", func(t *testing.T) {
+		checks := map[string]int64{
+			"totalFlow":       10 * gb,
+			"usedFlow":        3 * gb,
+			"remainingFlow":   7 * gb,
+			"activeTunnels":   2,
+			"activeForwards":  2,
+			"expiringIn7Days": 1,
+		}
+		for key, want := range checks {
+			if got := int64(first[key].(float64)); got != want {
+				t.Fatalf("expected %s=%d, got %d", key, want, got)
+			}
+		}
+
+		graph, _ := first["recentFlowGraph"].([]interface{})
+		if len(graph) != 24 {
+			t.Fatalf("expected 24 hourly buckets, got %d", len(graph))
+		}
+		last := graph[23].(map[string]interface{})
+		if last["time"] != hour.Format("15:04") || valueAsInt(last["flow"]) != 500 {
+			t.Fatalf("unexpected current-hour bucket %v", last)
+		}
+		if valueAsInt(graph[21].(map[string]interface{})["flow"]) != 300 {
+			t.Fatalf("unexpected bucket %v", graph[21])
+		}
+		var total int
+		for _, b := range graph {
+			total += valueAsInt(b.(map[string]interface{})["flow"])
+		}
+		if total != 800 {
+			t.Fatalf("expected samples older than 24h to be excluded, total %d", total)
+		}
+	})
+
+	t.Run("second call within ttl is served from cache", func(t *testing.T) {
+		insertForward("dash-forward-3")
+		if _, err := repo.DB().Exec(`UPDATE user SET in_flow = in_flow + ? WHERE id = 2`, gb); err != nil {
+			t.Fatalf("update user flow: %v", err)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM forward WHERE user_id = ?`, 2, 3)
+
+		second := dashboard()
+		if !reflect.DeepEqual(first, second) {
+			t.Fatalf("expected cached dashboard %v, got %v", first, second)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+)
+
+func TestUserImportContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	now := time.Now().UnixMilli()
+	res, err := repo.DB().Exec(`
+		INSERT INTO tunnel(name, traffic_ratio, type, protocol, flow, created_time, updated_time, status, in_ip, inx)
+		VALUES('import-tunnel', 1.0, 1, 'tls', 99999, ?, ?, 1, NULL, 0)
+	`, now, now)
+	if err != nil {
+		t.Fatalf("insert tunnel: %v", err)
+	}
+	tunnelID, _ := res.LastInsertId()
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(contentType, body string) response.R {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/user/import", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return out
+	}
+
+	t.Run("dry run validates without writing", func(t *testing.T) {
+		body := fmt.Sprintf(`{"dryRun":true,"users":[
+			{"user":"import-a","pwd":"import-pass-1","flow":50,"num":3,"tunnels":[%d]},
+			{"user":"import-a","pwd":"import-pass-2"},
+			{"user":"import-b","pwd":"import-pass-3","tunnels":"99999"}
+		]}`, tunnelID)
+		out := post("application/json", body)
+		if out.Code != 0 {
+			t.Fatalf("dry run: code %d (%s)", out.Code, out.Msg)
+		}
+		data := out.Data.(map[string]interface{})
+		if users := data["users"].([]interface{}); len(users) != 1 {
+			t.Fatalf("expected one valid user in the preview, got %v", users)
+		}
+		errs := data["errors"].([]interface{})
+		if len(errs) != 2 {
+			t.Fatalf("expected two row errors, got %v", errs)
+		}
+		if row := valueAsInt(errs[0].(map[string]interface{})["row"]); row != 2 {
+			t.Fatalf("expected the duplicate to be reported on row 2, got %d", row)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user LIKE ?`, "import-%", 0)
+	})
+
+	t.Run("any invalid row rejects the whole import", func(t *testing.T) {
+		out := post("application/json", `{"users":[{"user":"import-c","pwd":"import-pass-4"},{"user":"admin_user","pwd":"import-pass-5"}]}`)
+		if out.Code == 0 {
+			t.Fatalf("expected the import to be rejected")
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = ?`, "import-c", 0)
+	})
+
+	t.Run("json rows create users and tunnel permissions", func(t *testing.T) {
+		body := fmt.Sprintf(`{"users":[{"user":"import-json","pwd":"import-pass-6","flow":20,"num":2,"expTime":"2030-01-02","tunnels":[%d]}]}`, tunnelID)
+		out := post("application/json", body)
+		if out.Code != 0 {
+			t.Fatalf("import: code %d (%s)", out.Code, out.Msg)
+		}
+		if created := valueAsInt(out.Data.(map[string]interface{})["created"]); created != 1 {
+			t.Fatalf("expected one created user, got %d", created)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = ? AND flow = 20 AND num = 2 AND role_id = 1`, "import-json", 1)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user_tunnel ut JOIN user u ON u.id = ut.user_id WHERE u.user = ?`, "import-json", 1)
+	})
+
+	t.Run("csv body creates users", func(t *testing.T) {
+		csv := fmt.Sprintf("user,pwd,flow,num,tunnels\nimport-csv-1,import-pass-7,30,4,%d\nimport-csv-2,import-pass-8,,,\n", tunnelID)
+		out := post("text/csv", csv)
+		if out.Code != 0 {
+			t.Fatalf("import csv: code %d (%s)", out.Code, out.Msg)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = ? AND flow = 30 AND num = 4`, "import-csv-1", 1)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE user = ? AND flow = 100 AND num = 10`, "import-csv-2", 1)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user_tunnel ut JOIN user u ON u.id = ut.user_id WHERE u.user = ?`, "import-csv-1", 1)
+	})
+}
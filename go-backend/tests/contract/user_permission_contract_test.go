@@ -0,0 +1,152 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/auth"
+	"go-backend/internal/http/response"
+	"go-backend/internal/security"
+)
+
+func TestUserPermissionMaskContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	now := time.Now().UnixMilli()
+
+	if _, err := repo.DB().Exec(`
+		INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status)
+		VALUES(2, 'auditor', ?, 1, 2727251700000, 99999, 0, 0, 1, 99999, ?, ?, 1)
+	`, security.MD5("auditor-pass"), now, now); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, token string, body interface{}) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("rejects bits outside the known permissions", func(t *testing.T) {
+		rec := post("/api/v1/admin/user/permissions", adminToken, map[string]interface{}{"id": 2, "permissionMask": int64(1 << 10)})
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if out.Code == 0 {
+			t.Fatalf("expected unknown permission bit to be rejected")
+		}
+	})
+
+	assertCode(t, post("/api/v1/admin/user/permissions", adminToken, map[string]interface{}{"id": 2, "permissionMask": int64(auth.PermViewLogs)}), 0)
+	assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE id = ? AND permission_mask = 8`, 2, 1)
+
+	loginRec := post("/api/v1/user/login", "", map[string]interface{}{"username": "auditor", "password": "auditor-pass"})
+	var login response.R
+	if err := json.NewDecoder(loginRec.Body).Decode(&login); err != nil {
+		t.Fatalf("decode login: %v", err)
+	}
+	if login.Code != 0 {
+		t.Fatalf("login: code %d (%s)", login.Code, login.Msg)
+	}
+	userToken := valueAsString(login.Data.(map[string]interface{})["token"])
+	claims, err := auth.ParseClaims(userToken, secret)
+	if err != nil {
+		t.Fatalf("parse login token: %v", err)
+	}
+	if auth.Permission(claims.Permissions) != auth.PermViewLogs {
+		t.Fatalf("expected token to carry the view logs bit, got %d", claims.Permissions)
+	}
+
+	t.Run("view logs permission opens audit log", func(t *testing.T) {
+		assertCode(t, post("/api/v1/admin/audit-log/list", userToken, map[string]interface{}{}), 0)
+	})
+
+	t.Run("view logs permission does not open node create", func(t *testing.T) {
+		assertCodeMsg(t, post("/api/v1/node/create", userToken, map[string]interface{}{"name": "n"}), 403, "权限不足，仅管理员可操作")
+		assertCount(t, repo, `SELECT COUNT(1) FROM node WHERE name = ?`, "n", 0)
+	})
+
+	t.Run("delegated user cannot grant permissions", func(t *testing.T) {
+		assertCode(t, post("/api/v1/admin/user/permissions", userToken, map[string]interface{}{"id": 2, "permissionMask": int64(auth.PermAll)}), 403)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE id = ? AND permission_mask = 8`, 2, 1)
+	})
+
+	t.Run("changing the mask logs the user out and is audited", func(t *testing.T) {
+		assertCode(t, post("/api/v1/admin/user/permissions", adminToken, map[string]interface{}{"id": 2, "permissionMask": 0}), 0)
+		assertCode(t, post("/api/v1/admin/audit-log/list", userToken, map[string]interface{}{}), 401)
+		assertCount(t, repo, `SELECT COUNT(1) FROM audit_log WHERE action = 'user_permissions_update' AND target_id = ?`, 2, 2)
+	})
+}
+
+func TestUserWriteDelegateCannotManageStrongerUsersContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	now := time.Now().UnixMilli()
+
+	for _, u := range []struct {
+		id   int64
+		name string
+		mask auth.Permission
+	}{
+		{2, "delegate", auth.PermManageUsers},
+		{3, "stronger", auth.PermManageUsers | auth.PermManageConfig},
+		{4, "peer", auth.PermManageUsers},
+	} {
+		if _, err := repo.DB().Exec(`
+			INSERT INTO user(id, user, pwd, role_id, exp_time, flow, in_flow, out_flow, flow_reset_time, num, created_time, updated_time, status, permission_mask)
+			VALUES(?, ?, ?, 1, 2727251700000, 99999, 5, 5, 1, 99999, ?, ?, 1, ?)
+		`, u.id, u.name, security.MD5(u.name+"-pass"), now, now, int64(u.mask)); err != nil {
+			t.Fatalf("insert user %s: %v", u.name, err)
+		}
+	}
+	delegateToken, err := contractTokenWithPermissions(repo, 2, "delegate", 1, int64(auth.PermManageUsers), secret)
+	if err != nil {
+		t.Fatalf("generate delegate token: %v", err)
+	}
+	post := func(path string, body interface{}) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+		req.Header.Set("Authorization", delegateToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	const outranked = "不能管理权限高于自身的用户"
+
+	t.Run("password reset of a stronger user is rejected", func(t *testing.T) {
+		assertCodeMsg(t, post("/api/v1/user/update", map[string]interface{}{"id": 3, "user": "stronger", "pwd": "taken-over-pass-1"}), -1, outranked)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE id = ? AND pwd = '`+security.MD5("stronger-pass")+`'`, 3, 1)
+	})
+
+	t.Run("delete and flow reset of a stronger user are rejected", func(t *testing.T) {
+		assertCodeMsg(t, post("/api/v1/user/delete", map[string]interface{}{"id": 3}), -1, outranked)
+		assertCodeMsg(t, post("/api/v1/user/reset", map[string]interface{}{"id": 3, "type": 1}), -1, outranked)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE id = ? AND in_flow = 5`, 3, 1)
+	})
+
+	t.Run("admin password reset and status toggle of a stronger user are rejected", func(t *testing.T) {
+		assertCodeMsg(t, post("/api/v1/user/reset-password", map[string]interface{}{"id": 3, "password": "taken-over-pass-2"}), -1, outranked)
+		assertCodeMsg(t, post("/api/v1/user/toggle-status", map[string]interface{}{"id": 3, "status": 0}), -1, outranked)
+		assertCount(t, repo, `SELECT COUNT(1) FROM user WHERE id = ? AND status = 1 AND pwd = '`+security.MD5("stronger-pass")+`'`, 3, 1)
+	})
+
+	t.Run("users with the same rights stay manageable", func(t *testing.T) {
+		assertCode(t, post("/api/v1/user/update", map[string]interface{}{"id": 4, "user": "peer", "pwd": "peer-new-pass-1"}), 0)
+		assertCode(t, post("/api/v1/user/reset", map[string]interface{}{"id": 4, "type": 1}), 0)
+		assertCode(t, post("/api/v1/user/reset-password", map[string]interface{}{"id": 4, "password": "peer-new-pass-2"}), 0)
+	})
+}
@@ -0,0 +1,95 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-backend/internal/http/response"
+	"go-backend/internal/store/sqlite"
+)
+
+func TestUserListSearchContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+
+	now := time.Now().UnixMilli()
+	for _, name := range []string{"alice", "bob", "ALICE2", "charlie", "alicex"} {
+		status := 1
+		if name == "alicex" {
+			status = 0
+		}
+		user := &sqlite.User{User: name, Pwd: "x", RoleID: 1, ExpTime: now, Flow: 1, FlowResetTime: 1, Num: 1, CreatedTime: now, Status: status}
+		if _, err := repo.CreateUser(user); err != nil {
+			t.Fatalf("create user %s: %v", name, err)
+		}
+	}
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	list := func(t *testing.T, body string) interface{} {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/user/list", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if out.Code != 0 {
+			t.Fatalf("user list: code %d (%s)", out.Code, out.Msg)
+		}
+		return out.Data
+	}
+	page := func(t *testing.T, body string) (int, []string) {
+		t.Helper()
+		data, ok := list(t, body).(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected a paged result for %s", body)
+		}
+		items, _ := data["items"].([]interface{})
+		names := make([]string, 0, len(items))
+		for _, item := range items {
+			names = append(names, valueAsString(item.(map[string]interface{})["user"]))
+		}
+		return valueAsInt(data["total"]), names
+	}
+
+	t.Run("search is a case-insensitive substring match", func(t *testing.T) {
+		total, names := page(t, `{"search":"alice"}`)
+		if total != 3 || len(names) != 3 {
+			t.Fatalf("expected 3 matches, got total %d items %v", total, names)
+		}
+		for i, want := range []string{"alice", "ALICE2", "alicex"} {
+			if names[i] != want {
+				t.Fatalf("expected %v in id order, got %v", want, names)
+			}
+		}
+	})
+
+	t.Run("pages and filters by status", func(t *testing.T) {
+		total, names := page(t, `{"search":"alice","page":2,"pageSize":2}`)
+		if total != 3 || len(names) != 1 || names[0] != "alicex" {
+			t.Fatalf("expected second page [alicex] of 3, got total %d items %v", total, names)
+		}
+		total, names = page(t, `{"search":"alice","status":1}`)
+		if total != 2 || len(names) != 2 {
+			t.Fatalf("expected 2 enabled matches, got total %d items %v", total, names)
+		}
+	})
+
+	t.Run("empty body returns all users", func(t *testing.T) {
+		for _, body := range []string{"", "{}"} {
+			items, ok := list(t, body).([]interface{})
+			if !ok || len(items) != 5 {
+				t.Fatalf("expected all 5 users for body %q, got %v", body, items)
+			}
+		}
+	})
+}
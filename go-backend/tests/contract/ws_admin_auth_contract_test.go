@@ -0,0 +1,77 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"go-backend/internal/http/response"
+)
+
+func TestAdminWebSocketAuthContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	dial := func(token string) (int, error) {
+		u := "ws" + strings.TrimPrefix(server.URL, "http") + "/system-info?" + url.Values{"type": {"0"}, "secret": {token}}.Encode()
+		conn, resp, err := websocket.DefaultDialer.Dial(u, nil)
+		if conn != nil {
+			_ = conn.Close()
+		}
+		if resp == nil {
+			return 0, err
+		}
+		return resp.StatusCode, err
+	}
+	post := func(path, token string) response.R {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(`{"username":"admin_user","password":"admin_user"}`))
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out response.R
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+		return out
+	}
+
+	out := post("/api/v1/user/login", "")
+	if out.Code != 0 {
+		t.Fatalf("login: %d (%s)", out.Code, out.Msg)
+	}
+	token := valueAsString(out.Data.(map[string]interface{})["token"])
+
+	if status, err := dial(token); err != nil || status != http.StatusSwitchingProtocols {
+		t.Fatalf("expected a valid session token to connect, got %d (%v)", status, err)
+	}
+
+	t.Run("token for another audience is refused", func(t *testing.T) {
+		other, err := contractTokenForAudience(repo, 1, "admin_user", 0, 0, "other-service", secret)
+		if err != nil {
+			t.Fatalf("generate token: %v", err)
+		}
+		if status, _ := dial(other); status != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d", status)
+		}
+	})
+
+	t.Run("token of a revoked session is refused", func(t *testing.T) {
+		if out := post("/api/v1/user/logout", token); out.Code != 0 {
+			t.Fatalf("logout: %d (%s)", out.Code, out.Msg)
+		}
+		if status, _ := dial(token); status != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d", status)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package contract_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	httpserver "go-backend/internal/http"
+	"go-backend/internal/http/handler"
+	"go-backend/internal/store/sqlite"
+	"go-backend/internal/ws"
+)
+
+func TestCommandRegistrySkipsNodesBelowMinVersionContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	repo, err := sqlite.Open(filepath.Join(t.TempDir(), "contract.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	h := handler.New(repo, secret)
+	wsServer, ok := h.WebSocketHandler().(*ws.Server)
+	if !ok {
+		t.Fatalf("expected *ws.Server websocket handler")
+	}
+	for _, cmdType := range []string{"AddService", "DeleteService", "Ping", "AddLimiters", "RotateSecret", "ThrottleService"} {
+		if _, ok := wsServer.Commands().MinVersion(cmdType); !ok {
+			t.Fatalf("expected %s to be registered by NewServer", cmdType)
+		}
+	}
+	wsServer.Commands().Register("ThrottleService", "v1.5.0")
+	server := httptest.NewServer(httpserver.NewRouter(h))
+	defer server.Close()
+
+	var mu sync.Mutex
+	received := map[string]int{}
+	recorder := func(label string) func(string, json.RawMessage) []interface{} {
+		return func(cmdType string, _ json.RawMessage) []interface{} {
+			mu.Lock()
+			received[label+":"+cmdType]++
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	oldID := insertContractNode(t, repo, "old-node", "10.0.0.81", "5000-5010", "old-node-secret", 0)
+	newID := insertContractNode(t, repo, "new-node", "10.0.0.82", "5000-5010", "new-node-secret", 0)
+	stopOld := startMockNodeSessionAtVersion(t, server.URL, "old-node-secret", "v1.4.0", recorder("old"))
+	defer stopOld()
+	stopNew := startMockNodeSessionAtVersion(t, server.URL, "new-node-secret", "v1.5.0", recorder("new"))
+	defer stopNew()
+	waitNodeStatus(t, repo, oldID, 1)
+	waitNodeStatus(t, repo, newID, 1)
+
+	if _, err := wsServer.SendCommand(oldID, "ThrottleService", map[string]interface{}{"name": "svc"}, time.Second); !errors.Is(err, ws.ErrCommandNotSupported) {
+		t.Fatalf("expected ErrCommandNotSupported for v1.4.0 node, got %v", err)
+	}
+	if _, err := wsServer.SendCommand(newID, "ThrottleService", map[string]interface{}{"name": "svc"}, time.Second); err != nil {
+		t.Fatalf("send ThrottleService to v1.5.0 node: %v", err)
+	}
+	// Commands every released agent understands still reach old nodes.
+	if _, err := wsServer.SendCommand(oldID, "AddService", []interface{}{}, time.Second); err != nil {
+		t.Fatalf("send AddService to v1.4.0 node: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if received["old:ThrottleService"] != 0 {
+		t.Fatalf("expected v1.4.0 node not to receive ThrottleService, got %d", received["old:ThrottleService"])
+	}
+	if received["new:ThrottleService"] != 1 {
+		t.Fatalf("expected v1.5.0 node to receive ThrottleService once, got %d", received["new:ThrottleService"])
+	}
+	if received["old:AddService"] != 1 {
+		t.Fatalf("expected v1.4.0 node to receive AddService once, got %d", received["old:AddService"])
+	}
+}
+
+func TestProbeLatencyRespectsCommandRegistryContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	repo, err := sqlite.Open(filepath.Join(t.TempDir(), "contract.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	h := handler.New(repo, secret)
+	wsServer, ok := h.WebSocketHandler().(*ws.Server)
+	if !ok {
+		t.Fatalf("expected *ws.Server websocket handler")
+	}
+	server := httptest.NewServer(httpserver.NewRouter(h))
+	defer server.Close()
+
+	var mu sync.Mutex
+	received := map[string]int{}
+	measurer := func(label string) func(string, json.RawMessage) []interface{} {
+		return func(cmdType string, data json.RawMessage) []interface{} {
+			mu.Lock()
+			received[label+":"+cmdType]++
+			mu.Unlock()
+			if cmdType != "MeasureLatency" {
+				return nil
+			}
+			var req struct {
+				Targets []struct {
+					NodeID int64 `json:"nodeId"`
+					Port   int   `json:"port"`
+				} `json:"targets"`
+			}
+			if err := json.Unmarshal(data, &req); err != nil {
+				return nil
+			}
+			results := make([]map[string]interface{}, 0, len(req.Targets))
+			for _, target := range req.Targets {
+				results = append(results, map[string]interface{}{"nodeId": target.NodeID, "latencyMs": float64(target.Port - 5000), "success": true})
+			}
+			return []interface{}{map[string]interface{}{"type": "LatencyResult", "results": results}}
+		}
+	}
+
+	oldID := insertContractNode(t, repo, "old-node", "10.0.0.81", "5001-5010", "old-node-secret", 0)
+	newID := insertContractNode(t, repo, "new-node", "10.0.0.82", "5002-5010", "new-node-secret", 0)
+	stopOld := startMockNodeSessionAtVersion(t, server.URL, "old-node-secret", "2.1.0", measurer("old"))
+	defer stopOld()
+	stopNew := startMockNodeSessionAtVersion(t, server.URL, "new-node-secret", "2.2.0", measurer("new"))
+	defer stopNew()
+	waitNodeStatus(t, repo, oldID, 1)
+	waitNodeStatus(t, repo, newID, 1)
+
+	wsServer.ProbeLatency()
+
+	var latencies []sqlite.NodeLatency
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if latencies, err = repo.ListNodeLatencies(); err == nil && len(latencies) > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(latencies) != 1 || latencies[0].From != newID || latencies[0].To != oldID || latencies[0].LatencyMs != 1 {
+		t.Fatalf("expected one new->old measurement of 1ms (dialled on the first port), got %+v", latencies)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if received["old:MeasureLatency"] != 0 {
+		t.Fatalf("expected 2.1.0 node not to receive MeasureLatency, got %d", received["old:MeasureLatency"])
+	}
+	if received["new:MeasureLatency"] != 1 {
+		t.Fatalf("expected 2.2.0 node to receive MeasureLatency once, got %d", received["new:MeasureLatency"])
+	}
+}
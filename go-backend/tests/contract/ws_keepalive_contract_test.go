@@ -0,0 +1,87 @@
+package contract_test
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	httpserver "go-backend/internal/http"
+	"go-backend/internal/http/handler"
+	"go-backend/internal/store/sqlite"
+	"go-backend/internal/ws"
+)
+
+func TestWebSocketKeepaliveClosesSilentNodeContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	repo, err := sqlite.Open(filepath.Join(t.TempDir(), "contract.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	h := handler.New(repo, secret)
+	wsServer, ok := h.WebSocketHandler().(*ws.Server)
+	if !ok {
+		t.Fatalf("expected *ws.Server websocket handler")
+	}
+	wsServer.SetKeepaliveConfig(100*time.Millisecond, 100*time.Millisecond)
+	server := httptest.NewServer(httpserver.NewRouter(h))
+	defer server.Close()
+
+	nodeID := insertContractNode(t, repo, "keepalive-node", "10.0.0.71", "5000-5010", "keepalive-node-secret", 0)
+
+	u, _ := url.Parse(server.URL)
+	u.Scheme = "ws"
+	u.Path = "/system-info"
+	q := u.Query()
+	q.Set("type", "1")
+	q.Set("secret", "keepalive-node-secret")
+	q.Set("version", "v1")
+	u.RawQuery = q.Encode()
+
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatalf("dial websocket: %v", err)
+	}
+	defer conn.Close()
+	// Swallow pings instead of answering them with pongs.
+	pings := make(chan struct{}, 8)
+	conn.SetPingHandler(func(string) error {
+		select {
+		case pings <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+
+	waitNodeStatus(t, repo, nodeID, 1)
+	start := time.Now()
+	closed := make(chan time.Duration, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				closed <- time.Since(start)
+				return
+			}
+		}
+	}()
+
+	select {
+	case elapsed := <-closed:
+		if elapsed > 300*time.Millisecond {
+			t.Fatalf("expected session to close within 300ms, took %s", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected silent node session to be closed")
+	}
+	select {
+	case <-pings:
+	default:
+		t.Fatalf("expected the server to send a ping before closing")
+	}
+	waitNodeStatus(t, repo, nodeID, 0)
+}
@@ -0,0 +1,146 @@
+package contract_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"go-backend/internal/security"
+)
+
+func TestNodeMultiplexedChannelsContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	nodeSecret := "mux-node-secret"
+	nodeID := insertContractNode(t, repo, "mux-node", "10.0.0.81", "36000-36010", nodeSecret, 0)
+
+	u, _ := url.Parse(server.URL)
+	u.Scheme = "ws"
+	u.Path = "/system-info"
+	q := url.Values{}
+	q.Set("type", "1")
+	q.Set("secret", nodeSecret)
+	q.Set("version", "v1")
+	u.RawQuery = q.Encode()
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatalf("dial websocket: %v", err)
+	}
+	defer conn.Close()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	write := func(msg interface{}) {
+		t.Helper()
+		payload, _ := json.Marshal(msg)
+		if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+			t.Fatalf("write message: %v", err)
+		}
+	}
+	requestChannels := func(n int) []interface{} {
+		t.Helper()
+		write(map[string]interface{}{"type": "MultiplexRequest", "channels": n})
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+		for {
+			_, raw, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("read multiplex response: %v", err)
+			}
+			var wrap struct {
+				Encrypted bool   `json:"encrypted"`
+				Data      string `json:"data"`
+			}
+			if err := json.Unmarshal(raw, &wrap); err == nil && wrap.Encrypted && strings.TrimSpace(wrap.Data) != "" {
+				crypto, _ := security.NewAESCrypto(nodeSecret)
+				dec, err := crypto.Decrypt(wrap.Data)
+				if err != nil {
+					t.Fatalf("decrypt response: %v", err)
+				}
+				raw = []byte(dec)
+			}
+			var resp struct {
+				Type     string        `json:"type"`
+				Channels []interface{} `json:"channels"`
+			}
+			if json.Unmarshal(raw, &resp) == nil && resp.Type == "MultiplexResponse" {
+				return resp.Channels
+			}
+		}
+	}
+	hopReport := func(chanID int, tunnelID int64, latency float64) map[string]interface{} {
+		return map[string]interface{}{
+			"type": "HopStats",
+			"chan": chanID,
+			"hops": []map[string]interface{}{
+				{"tunnelId": tunnelID, "chainType": 1, "avgLatencyMs": latency, "bytesIn": 1, "bytesOut": 1},
+			},
+		}
+	}
+
+	t.Run("grants the requested channels up to the configured maximum", func(t *testing.T) {
+		if err := repo.UpsertConfig("ws_max_multiplexed_channels", "3", time.Now().UnixMilli()); err != nil {
+			t.Fatalf("set max channels: %v", err)
+		}
+		if got := requestChannels(10); len(got) != 3 {
+			t.Fatalf("expected 3 granted channels, got %v", got)
+		}
+	})
+
+	t.Run("handles messages on both channels", func(t *testing.T) {
+		got := requestChannels(2)
+		if len(got) != 2 || valueAsInt(got[0]) != 1 || valueAsInt(got[1]) != 2 {
+			t.Fatalf("expected channels [1 2], got %v", got)
+		}
+
+		const perChannel = 50
+		for i := int64(0); i < perChannel; i++ {
+			// A stale report then the current one: each channel keeps order.
+			write(hopReport(1, 1000+i, 999))
+			write(hopReport(2, 2000+i, 999))
+			write(hopReport(1, 1000+i, 1))
+			write(hopReport(2, 2000+i, 2))
+		}
+
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			var settled int
+			if err := repo.DB().QueryRow(`SELECT COUNT(1) FROM chain_hop_stats WHERE node_id = ? AND avg_latency_ms < ?`, nodeID, 999).Scan(&settled); err != nil {
+				t.Fatalf("count hop stats: %v", err)
+			}
+			if settled == 2*perChannel {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d settled hop reports, got %d", 2*perChannel, settled)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		assertCount(t, repo, `SELECT COUNT(1) FROM chain_hop_stats WHERE tunnel_id >= 2000 AND avg_latency_ms = ?`, 2, perChannel)
+	})
+
+	t.Run("untagged messages still use the connection", func(t *testing.T) {
+		write(hopReport(0, 3000, 5))
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			stats, err := repo.ListChainHopStats(3000)
+			if err != nil {
+				t.Fatalf("list hop stats: %v", err)
+			}
+			if len(stats) == 1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected untagged hop report to be stored")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,236 @@
+package contract_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	httpserver "go-backend/internal/http"
+	"go-backend/internal/http/handler"
+	"go-backend/internal/store/sqlite"
+	"go-backend/internal/ws"
+)
+
+func TestAdminWSSessionsContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	repo, err := sqlite.Open(filepath.Join(t.TempDir(), "contract.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	h := handler.New(repo, secret)
+	wsServer, ok := h.WebSocketHandler().(*ws.Server)
+	if !ok {
+		t.Fatalf("expected *ws.Server websocket handler")
+	}
+	router := httpserver.NewRouter(h)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	listSessions := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/ws/sessions", bytes.NewBufferString(`{}`))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("empty list is an array", func(t *testing.T) {
+		rec := listSessions()
+		if !strings.Contains(rec.Body.String(), `"data":[]`) {
+			t.Fatalf("expected empty session array, got %s", rec.Body.String())
+		}
+	})
+
+	nodeSecrets := map[int64]string{}
+	for _, spec := range []struct{ name, ip, secret string }{
+		{"session-node-a", "10.0.0.81", "session-node-a-secret"},
+		{"session-node-b", "10.0.0.82", "session-node-b-secret"},
+	} {
+		nodeID := insertContractNode(t, repo, spec.name, spec.ip, "5000-5010", spec.secret, 0)
+		stop := startMockNodeSession(t, server.URL, spec.secret)
+		defer stop()
+		waitNodeStatus(t, repo, nodeID, 1)
+		nodeSecrets[nodeID] = spec.secret
+	}
+	for nodeID := range nodeSecrets {
+		if _, err := wsServer.SendCommand(nodeID, "Ping", map[string]interface{}{}, time.Second); err != nil {
+			t.Fatalf("send command to node %d: %v", nodeID, err)
+		}
+	}
+
+	var out struct {
+		Code int                  `json:"code"`
+		Data []ws.NodeSessionStat `json:"data"`
+	}
+	if err := json.NewDecoder(listSessions().Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Code != 0 || len(out.Data) != 2 {
+		t.Fatalf("expected two sessions, got code %d data %+v", out.Code, out.Data)
+	}
+	for _, stat := range out.Data {
+		nodeSecret, ok := nodeSecrets[stat.NodeID]
+		if !ok {
+			t.Fatalf("unexpected session for node %d", stat.NodeID)
+		}
+		if stat.MessagesOut < 1 || stat.MessagesIn < 1 || stat.BytesIn <= 0 || stat.BytesOut <= 0 {
+			t.Fatalf("expected traffic counters on node %d, got %+v", stat.NodeID, stat)
+		}
+		if want := "****" + nodeSecret[len(nodeSecret)-4:]; stat.Secret != want {
+			t.Fatalf("expected masked secret %q, got %q", want, stat.Secret)
+		}
+		if !strings.HasPrefix(stat.NodeName, "session-node-") || stat.ConnectedAt <= 0 {
+			t.Fatalf("expected node name and connect time, got %+v", stat)
+		}
+	}
+}
+
+func TestAdminNodeDisconnectContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	nodeID := insertContractNode(t, repo, "disconnect-node", "10.0.0.91", "5000-5010", "disconnect-node-secret", 0)
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("offline node is reported", func(t *testing.T) {
+		assertCodeMsg(t, post("/api/v1/admin/node/disconnect", `{"nodeId":`+jsonInt(nodeID)+`}`), -1, "node not connected")
+	})
+
+	u, _ := url.Parse(server.URL)
+	u.Scheme = "ws"
+	u.Path = "/system-info"
+	q := u.Query()
+	q.Set("type", "1")
+	q.Set("secret", "disconnect-node-secret")
+	q.Set("version", "v1")
+	u.RawQuery = q.Encode()
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatalf("dial websocket: %v", err)
+	}
+	defer conn.Close()
+	waitNodeStatus(t, repo, nodeID, 1)
+
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	assertCode(t, post("/api/v1/admin/node/disconnect", `{"nodeId":`+jsonInt(nodeID)+`,"reason":"spamming"}`), 0)
+
+	select {
+	case err := <-closed:
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "spamming" {
+			t.Fatalf("expected policy violation close frame with reason, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected close frame within 1s")
+	}
+	waitNodeStatus(t, repo, nodeID, 0)
+	assertCount(t, repo, `SELECT COUNT(1) FROM audit_log WHERE action = 'node_force_disconnect' AND target_id = ?`, nodeID, 1)
+
+	rec := post("/api/v1/admin/ws/sessions", `{}`)
+	if !strings.Contains(rec.Body.String(), `"data":[]`) {
+		t.Fatalf("expected no sessions after disconnect, got %s", rec.Body.String())
+	}
+}
+
+func TestAdminNodeOnlineListContract(t *testing.T) {
+	secret := "contract-jwt-secret"
+	router, repo := setupContractRouter(t, secret)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	adminToken, err := contractToken(repo, 1, "admin_user", 0, secret)
+	if err != nil {
+		t.Fatalf("generate admin token: %v", err)
+	}
+	listOnline := func() []map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/node/online-list", bytes.NewBufferString(`{}`))
+		req.Header.Set("Authorization", adminToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var out struct {
+			Code int                      `json:"code"`
+			Data []map[string]interface{} `json:"data"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode online list: %v", err)
+		}
+		if out.Code != 0 || out.Data == nil {
+			t.Fatalf("expected code 0 with a list, got code %d", out.Code)
+		}
+		return out.Data
+	}
+	onlineIDs := func(items []map[string]interface{}) []int {
+		ids := make([]int, 0, len(items))
+		for _, item := range items {
+			if valueAsInt(item["connectedAt"]) <= 0 {
+				t.Fatalf("expected connectedAt on online node, got %v", item)
+			}
+			ids = append(ids, valueAsInt(item["id"]))
+		}
+		return ids
+	}
+
+	if items := listOnline(); len(items) != 0 {
+		t.Fatalf("expected no online nodes, got %d", len(items))
+	}
+
+	nodeA := insertContractNode(t, repo, "online-node-a", "10.0.0.91", "5000-5010", "online-node-a-secret", 0)
+	nodeB := insertContractNode(t, repo, "online-node-b", "10.0.0.92", "5000-5010", "online-node-b-secret", 0)
+	insertContractNode(t, repo, "offline-node-c", "10.0.0.93", "5000-5010", "offline-node-c-secret", 0)
+	stopA := startMockNodeSession(t, server.URL, "online-node-a-secret")
+	defer stopA()
+	stopB := startMockNodeSession(t, server.URL, "online-node-b-secret")
+	defer stopB()
+	waitNodeStatus(t, repo, nodeA, 1)
+	waitNodeStatus(t, repo, nodeB, 1)
+
+	ids := onlineIDs(listOnline())
+	if len(ids) != 2 || ids[0] != int(nodeA) || ids[1] != int(nodeB) {
+		t.Fatalf("expected online nodes [%d %d], got %v", nodeA, nodeB, ids)
+	}
+
+	stopB()
+	waitNodeStatus(t, repo, nodeB, 0)
+	ids = onlineIDs(listOnline())
+	if len(ids) != 1 || ids[0] != int(nodeA) {
+		t.Fatalf("expected only node %d online, got %v", nodeA, ids)
+	}
+}
@@ -13,6 +13,12 @@ type Config struct {
 	Http   int    `json:"http"`
 	Tls    int    `json:"tls"`
 	Socks  int    `json:"socks"`
+
+	// 双向TLS：面板节点监听地址及面板签发的证书，留空则使用 addr 明文连接
+	MTLSAddr string `json:"mtls_addr"`
+	MTLSCert string `json:"mtls_cert"`
+	MTLSKey  string `json:"mtls_key"`
+	MTLSCA   string `json:"mtls_ca"`
 }
 
 // LoadConfig 加载配置文件
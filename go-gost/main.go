@@ -119,9 +119,18 @@ func main() {
 	log := xlogger.NewLogger()
 	logger.SetDefault(log)
 
-	wsReporter := socket.StartWebSocketReporterWithConfig(config.Addr, config.Secret, config.Http, config.Tls, config.Socks, version)
+	addr := config.Addr
+	if config.MTLSAddr != "" {
+		if err := service.SetNodeTLS(config.MTLSCert, config.MTLSKey, config.MTLSCA); err != nil {
+			fmt.Printf("❌ 双向TLS证书加载失败: %v\n", err)
+			os.Exit(1)
+		}
+		addr = config.MTLSAddr
+	}
+
+	wsReporter := socket.StartWebSocketReporterWithConfig(addr, config.Secret, config.Http, config.Tls, config.Socks, version)
 	defer wsReporter.Stop()
-	service.SetHTTPReportURL(config.Addr, config.Secret)
+	service.SetHTTPReportURL(addr, config.Secret)
 
 	p := &program{}
 	if err := svc.Run(p); err != nil {
@@ -470,6 +470,12 @@ type ServiceConfig struct {
 	Listener   *ListenerConfig   `yaml:",omitempty" json:"listener,omitempty"`
 	Forwarder  *ForwarderConfig  `yaml:",omitempty" json:"forwarder,omitempty"`
 	Metadata   map[string]any    `yaml:",omitempty" json:"metadata,omitempty"`
+	// DNSServer 面板下发的转发专用解析服务器（ip:port），优先于 Resolver
+	DNSServer string `yaml:"dnsServer,omitempty" json:"dnsServer,omitempty"`
+	// IdleTimeoutSec 面板下发的连接空闲超时（秒），双向均无数据超过该时长即关闭连接，0 表示不限制
+	IdleTimeoutSec int `yaml:"idleTimeoutSec,omitempty" json:"idleTimeoutSec,omitempty"`
+	// DSCPMark 面板下发的 DSCP 标记（0-63），写入客户端连接与出站连接的 TOS 字节，0 表示不标记
+	DSCPMark int `yaml:"dscpMark,omitempty" json:"dscpMark,omitempty"`
 	// service status, read-only
 	Status *ServiceStatus `yaml:",omitempty" json:"status,omitempty"`
 }
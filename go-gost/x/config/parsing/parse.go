@@ -29,4 +29,7 @@ const (
 	MDKeyNetnsOut = "netns.out"
 
 	MDKeyDialTimeout = "dialTimeout"
+
+	MDKeyIdleTimeout = "idleTimeout"
+	MDKeyDSCP        = "dscp"
 )
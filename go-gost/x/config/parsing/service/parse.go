@@ -29,6 +29,7 @@ import (
 	bypass_parser "github.com/go-gost/x/config/parsing/bypass"
 	hop_parser "github.com/go-gost/x/config/parsing/hop"
 	logger_parser "github.com/go-gost/x/config/parsing/logger"
+	resolver_parser "github.com/go-gost/x/config/parsing/resolver"
 	selector_parser "github.com/go-gost/x/config/parsing/selector"
 	tls_util "github.com/go-gost/x/internal/util/tls"
 	xtraffic "github.com/go-gost/x/limiter/traffic"
@@ -95,6 +96,18 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 
 	admissions := admission_parser.List(cfg.Admission, cfg.Admissions...)
 
+	serviceResolver := registry.ResolverRegistry().Get(cfg.Resolver)
+	if dns := strings.TrimSpace(cfg.DNSServer); dns != "" {
+		serviceResolver, err = resolver_parser.ParseResolver(&config.ResolverConfig{
+			Name:        cfg.Name,
+			Nameservers: []*config.NameserverConfig{{Addr: dns}},
+		})
+		if err != nil {
+			serviceLogger.Error(err)
+			return nil, err
+		}
+	}
+
 	var sockOpts *chain.SockOpts
 	if cfg.SockOpts != nil {
 		sockOpts = &chain.SockOpts{
@@ -173,7 +186,7 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 		chain.InterfaceRouterOption(ifce),
 		chain.NetnsRouterOption(netnsOut),
 		chain.SockOptsRouterOption(sockOpts),
-		chain.ResolverRouterOption(registry.ResolverRegistry().Get(cfg.Resolver)),
+		chain.ResolverRouterOption(serviceResolver),
 		chain.HostMapperRouterOption(registry.HostsRegistry().Get(cfg.Hosts)),
 		chain.LoggerRouterOption(listenerLogger),
 	}
@@ -322,7 +335,7 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 		chain.InterfaceRouterOption(ifce),
 		chain.NetnsRouterOption(netnsOut),
 		chain.SockOptsRouterOption(sockOpts),
-		chain.ResolverRouterOption(registry.ResolverRegistry().Get(cfg.Resolver)),
+		chain.ResolverRouterOption(serviceResolver),
 		chain.HostMapperRouterOption(registry.HostsRegistry().Get(cfg.Hosts)),
 		chain.RecordersRouterOption(recorders...),
 		chain.LoggerRouterOption(handlerLogger),
@@ -364,8 +377,22 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 	if cfg.Handler.Metadata == nil {
 		cfg.Handler.Metadata = make(map[string]any)
 	}
-	handlerLogger.Debugf("metadata: %v", cfg.Handler.Metadata)
-	if err := h.Init(metadata.NewMetadata(cfg.Handler.Metadata)); err != nil {
+	handlerMetadata := cfg.Handler.Metadata
+	if cfg.IdleTimeoutSec > 0 || cfg.DSCPMark > 0 {
+		// 复制一份再注入，避免写回 gost.json
+		handlerMetadata = make(map[string]any, len(cfg.Handler.Metadata)+2)
+		for k, v := range cfg.Handler.Metadata {
+			handlerMetadata[k] = v
+		}
+		if cfg.IdleTimeoutSec > 0 {
+			handlerMetadata[parsing.MDKeyIdleTimeout] = cfg.IdleTimeoutSec
+		}
+		if cfg.DSCPMark > 0 {
+			handlerMetadata[parsing.MDKeyDSCP] = cfg.DSCPMark
+		}
+	}
+	handlerLogger.Debugf("metadata: %v", handlerMetadata)
+	if err := h.Init(metadata.NewMetadata(handlerMetadata)); err != nil {
 		handlerLogger.Error("init: ", err)
 		return nil, err
 	}
@@ -129,3 +129,22 @@ func ExcludeNodesFromContext(ctx context.Context) []string {
 	v, _ := ctx.Value(keyExcludeNodes).([]string)
 	return v
 }
+
+// dscpKey saves the DSCP value to mark outbound connections with.
+type dscpKey struct{}
+
+var (
+	keyDSCP = &dscpKey{}
+)
+
+// ContextWithDSCP returns a context whose dialed connections carry dscp in
+// the IP TOS / traffic class byte.
+func ContextWithDSCP(ctx context.Context, dscp int) context.Context {
+	return context.WithValue(ctx, keyDSCP, dscp)
+}
+
+// DSCPFromContext returns the DSCP value set by ContextWithDSCP, or 0.
+func DSCPFromContext(ctx context.Context) int {
+	v, _ := ctx.Value(keyDSCP).(int)
+	return v
+}
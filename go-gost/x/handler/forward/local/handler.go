@@ -106,6 +106,13 @@ func (h *forwardHandler) Handle(ctx context.Context, conn net.Conn, opts ...hand
 	}
 	ro.Network = network
 
+	if h.md.dscp > 0 {
+		if err := xnet.SetConnDSCP(conn, h.md.dscp); err != nil {
+			h.options.Logger.Warnf("set dscp: %v", err)
+		}
+		ctx = ctxvalue.ContextWithDSCP(ctx, h.md.dscp)
+	}
+
 	pStats := xstats.Stats{}
 	conn = stats_wrapper.WrapConn(conn, &pStats)
 
@@ -252,7 +259,7 @@ func (h *forwardHandler) Handle(ctx context.Context, conn net.Conn, opts ...hand
 		}
 		defer cc.Close()
 
-		if err := xnet.Transport(conn, cc); err != nil {
+		if err := xnet.TransportWithIdleTimeout(conn, cc, h.md.idleTimeout); err != nil {
 			if marker := target.Marker(); marker != nil {
 				marker.Mark()
 				h.options.Logger.Debugf("[handler.transport] transport failed, marked node=%s count=%d err=%v",
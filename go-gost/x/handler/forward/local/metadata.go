@@ -31,6 +31,14 @@ type metadata struct {
 	// 0 means use the total number of available nodes (try all nodes once).
 	// Default: 0 (try all available nodes)
 	maxRetries int
+
+	// idleTimeout closes a forwarded connection once neither side has sent
+	// anything for this long. 0 disables it.
+	idleTimeout time.Duration
+
+	// dscp marks both the client connection and the dialed connection.
+	// 0 leaves them unmarked.
+	dscp int
 }
 
 func (h *forwardHandler) parseMetadata(md mdata.Metadata) (err error) {
@@ -65,5 +73,12 @@ func (h *forwardHandler) parseMetadata(md mdata.Metadata) (err error) {
 	// maxRetries: 0 means try all available nodes (default behavior)
 	h.md.maxRetries = mdutil.GetInt(md, "maxRetries", "retry.max")
 
+	h.md.idleTimeout = mdutil.GetDuration(md, "idleTimeout")
+
+	h.md.dscp = mdutil.GetInt(md, "dscp")
+	if h.md.dscp < 0 || h.md.dscp > 63 {
+		h.md.dscp = 0
+	}
+
 	return
 }
@@ -116,6 +116,8 @@ func (d *Dialer) dialOnce(ctx context.Context, network, addr, ifceName string, i
 		log.Debugf("interface: %s %v/%s", ifceName, ifAddr, network)
 	}
 
+	dscp := ctxvalue.DSCPFromContext(ctx)
+
 	switch network {
 	case "udp", "udp4", "udp6":
 		if addr == "" {
@@ -144,6 +146,11 @@ func (d *Dialer) dialOnce(ctx context.Context, network, addr, ifceName string, i
 						log.Warnf("set mark: %v", err)
 					}
 				}
+				if dscp != 0 {
+					if err := xnet.SetSockDSCP(fd, dscp); err != nil {
+						log.Warnf("set dscp: %v", err)
+					}
+				}
 			})
 			if err != nil {
 				log.Error(err)
@@ -168,6 +175,11 @@ func (d *Dialer) dialOnce(ctx context.Context, network, addr, ifceName string, i
 						log.Warnf("set mark: %v", err)
 					}
 				}
+				if dscp != 0 {
+					if err := xnet.SetSockDSCP(fd, dscp); err != nil {
+						log.Warnf("set dscp: %v", err)
+					}
+				}
 			})
 		},
 	}
@@ -0,0 +1,21 @@
+package net
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// SetSockDSCP sets the DSCP bits of the IP TOS byte (IPv4) and the traffic
+// class (IPv6) on the socket. Only one of the two applies to a given socket,
+// so it fails only when neither can be set.
+func SetSockDSCP(fd uintptr, dscp int) error {
+	if dscp <= 0 {
+		return nil
+	}
+	tos := dscp << 2
+	err4 := unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+	err6 := unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos)
+	if err4 != nil && err6 != nil {
+		return err4
+	}
+	return nil
+}
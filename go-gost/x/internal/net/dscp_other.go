@@ -0,0 +1,7 @@
+//go:build !linux
+
+package net
+
+func SetSockDSCP(fd uintptr, dscp int) error {
+	return nil
+}
@@ -30,6 +30,29 @@ type SetDSCP interface {
 	SetDSCP(int) error
 }
 
+// SetConnDSCP marks conn's outgoing packets with dscp. Connections that do
+// not expose their socket are left unmarked.
+func SetConnDSCP(conn net.Conn, dscp int) error {
+	if dscp <= 0 {
+		return nil
+	}
+	sc, ok := conn.(SyscallConn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = SetSockDSCP(fd, dscp)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
 func IsIPv4(address string) bool {
 	return address != "" && address[0] != ':' && address[0] != '['
 }
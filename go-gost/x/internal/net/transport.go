@@ -2,6 +2,8 @@ package net
 
 import (
 	"io"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-gost/core/common/bufpool"
 )
@@ -27,6 +29,63 @@ func Transport(rw1, rw2 io.ReadWriter) error {
 	return nil
 }
 
+// TransportWithIdleTimeout is Transport that closes both connections once
+// neither direction has carried data for idle. A connection closed for being
+// idle is not an error. An idle of 0 disables the timeout.
+func TransportWithIdleTimeout(c1, c2 io.ReadWriteCloser, idle time.Duration) error {
+	if idle <= 0 {
+		return Transport(c1, c2)
+	}
+
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+	var idled atomic.Bool
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		interval := idle / 2
+		if interval < time.Second {
+			interval = time.Second
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if time.Since(time.Unix(0, lastActive.Load())) >= idle {
+					idled.Store(true)
+					c1.Close()
+					c2.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	err := Transport(&activityReadWriter{c1, &lastActive}, &activityReadWriter{c2, &lastActive})
+	if idled.Load() {
+		return nil
+	}
+	return err
+}
+
+// activityReadWriter records the time of every successful read.
+type activityReadWriter struct {
+	io.ReadWriter
+	lastActive *atomic.Int64
+}
+
+func (rw *activityReadWriter) Read(p []byte) (int, error) {
+	n, err := rw.ReadWriter.Read(p)
+	if n > 0 {
+		rw.lastActive.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
 func CopyBuffer(dst io.Writer, src io.Reader, bufSize int) error {
 	buf := bufpool.Get(bufSize)
 	defer bufpool.Put(buf)
@@ -33,6 +33,12 @@ func NewAESCrypto(secret string) (*AESCrypto, error) {
 // data: 要加密的原始数据
 // 返回: base64编码的加密数据
 func (a *AESCrypto) Encrypt(data []byte) (string, error) {
+	return a.EncryptWithAAD(data, nil)
+}
+
+// EncryptWithAAD 加密数据并认证附加数据
+// aad 不包含在输出中，解密时必须提供相同的内容
+func (a *AESCrypto) EncryptWithAAD(data, aad []byte) (string, error) {
 	if len(data) == 0 {
 		return "", fmt.Errorf("待加密数据不能为空")
 	}
@@ -56,7 +62,7 @@ func (a *AESCrypto) Encrypt(data []byte) (string, error) {
 	}
 
 	// 加密数据
-	ciphertext := gcm.Seal(nil, nonce, data, nil)
+	ciphertext := gcm.Seal(nil, nonce, data, aad)
 
 	// 组合 nonce + ciphertext
 	encrypted := append(nonce, ciphertext...)
@@ -0,0 +1,57 @@
+// Package nodetls 保存节点连接面板双向TLS监听时使用的客户端证书
+package nodetls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+)
+
+var (
+	mu     sync.RWMutex
+	config *tls.Config
+)
+
+// Load 加载面板签发的客户端证书、私钥以及面板CA证书
+func Load(certFile, keyFile, caFile string) error {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return errors.New("无效的CA证书")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	config = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{pair},
+		RootCAs:      roots,
+	}
+	return nil
+}
+
+// Config 返回客户端TLS配置，未启用双向TLS时返回nil
+func Config() *tls.Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	if config == nil {
+		return nil
+	}
+	return config.Clone()
+}
+
+// Enabled 是否启用了双向TLS
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return config != nil
+}
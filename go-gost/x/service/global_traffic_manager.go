@@ -11,6 +11,7 @@ import (
 type GlobalTrafficManager struct {
 	mu            sync.RWMutex
 	serviceTraffic map[string]*ServiceTraffic // key: 服务名, value: 流量数据
+	hopTraffic    map[string]*HopTraffic     // key: 服务名, value: 跳点统计流量
 	ctx           context.Context
 	cancel        context.CancelFunc
 	reportTicker  *time.Ticker
@@ -22,6 +23,26 @@ type ServiceTraffic struct {
 	ServiceName string
 	UpBytes     int64 // 上行流量（累积）
 	DownBytes   int64 // 下行流量（累积）
+	Opened      int64 // 新建连接数（累积）
+	Closed      int64 // 关闭连接数（累积）
+}
+
+// HopTraffic 服务自上次跳点统计以来的流量（与流量上报分开计数）
+type HopTraffic struct {
+	Up   int64 // 上行流量
+	Down int64 // 下行流量
+}
+
+// reportedTraffic 一次上报中单个服务的数据
+type reportedTraffic struct {
+	up     int64
+	down   int64
+	opened int64
+	closed int64
+}
+
+func (t *ServiceTraffic) empty() bool {
+	return t.UpBytes <= 0 && t.DownBytes <= 0 && t.Opened <= 0 && t.Closed <= 0
 }
 
 var (
@@ -35,6 +56,7 @@ func GetGlobalTrafficManager() *GlobalTrafficManager {
 		ctx, cancel := context.WithCancel(context.Background())
 		globalManager = &GlobalTrafficManager{
 			serviceTraffic: make(map[string]*ServiceTraffic),
+			hopTraffic:     make(map[string]*HopTraffic),
 			ctx:            ctx,
 			cancel:         cancel,
 			reportTicker:   time.NewTicker(5 * time.Second),
@@ -54,7 +76,57 @@ func (m *GlobalTrafficManager) AddTraffic(serviceName string, upBytes, downBytes
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// 获取或创建服务流量记录
+	// 累加流量
+	traffic := m.serviceEntry(serviceName)
+	traffic.mu.Lock()
+	traffic.UpBytes += upBytes
+	traffic.DownBytes += downBytes
+	traffic.mu.Unlock()
+
+	hop, exists := m.hopTraffic[serviceName]
+	if !exists {
+		hop = &HopTraffic{}
+		m.hopTraffic[serviceName] = hop
+	}
+	hop.Up += upBytes
+	hop.Down += downBytes
+}
+
+// TakeHopTraffic 取出各服务自上次调用以来的流量并清零（用于跳点统计上报）
+func (m *GlobalTrafficManager) TakeHopTraffic() map[string]HopTraffic {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	taken := make(map[string]HopTraffic, len(m.hopTraffic))
+	for name, hop := range m.hopTraffic {
+		taken[name] = *hop
+	}
+	m.hopTraffic = make(map[string]*HopTraffic)
+	return taken
+}
+
+// AddConnections 记录服务自上次统计以来新建和关闭的连接数（由各服务调用）
+func (m *GlobalTrafficManager) AddConnections(serviceName string, opened, closed int64) {
+	if opened <= 0 && closed <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	traffic := m.serviceEntry(serviceName)
+	traffic.mu.Lock()
+	if opened > 0 {
+		traffic.Opened += opened
+	}
+	if closed > 0 {
+		traffic.Closed += closed
+	}
+	traffic.mu.Unlock()
+}
+
+// serviceEntry 获取或创建服务流量记录，调用方需持有 m.mu
+func (m *GlobalTrafficManager) serviceEntry(serviceName string) *ServiceTraffic {
 	traffic, exists := m.serviceTraffic[serviceName]
 	if !exists {
 		traffic = &ServiceTraffic{
@@ -62,12 +134,7 @@ func (m *GlobalTrafficManager) AddTraffic(serviceName string, upBytes, downBytes
 		}
 		m.serviceTraffic[serviceName] = traffic
 	}
-
-	// 累加流量
-	traffic.mu.Lock()
-	traffic.UpBytes += upBytes
-	traffic.DownBytes += downBytes
-	traffic.mu.Unlock()
+	return traffic
 }
 
 // startReporting 启动定时上报协程（每5秒执行一次）
@@ -96,22 +163,16 @@ func (m *GlobalTrafficManager) collectAndReport() {
 	}
 
 	// 复制当前所有流量数据（避免长时间持锁）
-	trafficSnapshot := make(map[string]*ServiceTraffic)
-	reportData := make(map[string]struct {
-		up   int64
-		down int64
-	})
+	reportData := make(map[string]reportedTraffic)
 
 	for name, traffic := range m.serviceTraffic {
 		traffic.mu.Lock()
-		if traffic.UpBytes > 0 || traffic.DownBytes > 0 {
-			trafficSnapshot[name] = traffic
-			reportData[name] = struct {
-				up   int64
-				down int64
-			}{
-				up:   traffic.UpBytes,
-				down: traffic.DownBytes,
+		if !traffic.empty() {
+			reportData[name] = reportedTraffic{
+				up:     traffic.UpBytes,
+				down:   traffic.DownBytes,
+				opened: traffic.Opened,
+				closed: traffic.Closed,
 			}
 		}
 		traffic.mu.Unlock()
@@ -132,6 +193,8 @@ func (m *GlobalTrafficManager) collectAndReport() {
 			N: serviceName, // 保持服务名不变
 			U: data.up,
 			D: data.down,
+			O: data.opened,
+			C: data.closed,
 		})
 		totalUp += data.up
 		totalDown += data.down
@@ -154,10 +217,7 @@ func (m *GlobalTrafficManager) collectAndReport() {
 }
 
 // clearReportedTraffic 清空已成功上报的流量
-func (m *GlobalTrafficManager) clearReportedTraffic(reportedData map[string]struct {
-	up   int64
-	down int64
-}) {
+func (m *GlobalTrafficManager) clearReportedTraffic(reportedData map[string]reportedTraffic) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -167,9 +227,11 @@ func (m *GlobalTrafficManager) clearReportedTraffic(reportedData map[string]stru
 			// 减去已上报的流量
 			traffic.UpBytes -= reported.up
 			traffic.DownBytes -= reported.down
+			traffic.Opened -= reported.opened
+			traffic.Closed -= reported.closed
 
 			// 如果流量归零，从map中删除该服务记录（避免内存泄漏）
-			if traffic.UpBytes <= 0 && traffic.DownBytes <= 0 {
+			if traffic.empty() {
 				traffic.mu.Unlock()
 				delete(m.serviceTraffic, serviceName)
 			} else {
@@ -368,6 +368,8 @@ func (s *defaultService) observeStats(ctx context.Context) {
 	}
 
 	var events []observer.Event
+	// 上次统计时的连接计数，用于计算期间新建和关闭的连接数
+	var lastTotalConns, lastCurrentConns uint64
 
 	ticker := time.NewTicker(d)
 	defer ticker.Stop()
@@ -417,6 +419,17 @@ func (s *defaultService) observeStats(ctx context.Context) {
 					}
 				}
 
+				totalConns := st.Get(stats.KindTotalConns)
+				currentConns := st.Get(stats.KindCurrentConns)
+				if totalConns < lastTotalConns {
+					lastTotalConns = 0
+				}
+				opened := int64(totalConns - lastTotalConns)
+				// 关闭数 = 新建数 - 当前连接数的增量
+				closed := opened + int64(lastCurrentConns) - int64(currentConns)
+				lastTotalConns, lastCurrentConns = totalConns, currentConns
+				GetGlobalTrafficManager().AddConnections(s.name, opened, closed)
+
 				if err := s.options.observer.Observe(ctx, evs); err != nil {
 					fmt.Printf("发送观察器事件失败: %v", err)
 					events = evs
@@ -3,32 +3,51 @@ package service
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-gost/core/observer/stats"
 	"github.com/go-gost/x/config"
 	"github.com/go-gost/x/internal/util/crypto"
+	"github.com/go-gost/x/internal/util/nodetls"
 	"github.com/go-gost/x/registry"
 )
 
 var httpReportURL string
 var configReportURL string
 var httpAESCrypto *crypto.AESCrypto // 新增：HTTP上报加密器
+var httpReportSecret string         // 上报签名密钥
 
 // TrafficReportItem 流量报告项（压缩格式）
 type TrafficReportItem struct {
-	N string `json:"n"` // 服务名（name缩写）
-	U int64  `json:"u"` // 上行流量（up缩写）
-	D int64  `json:"d"` // 下行流量（down缩写）
+	N string `json:"n"`           // 服务名（name缩写）
+	U int64  `json:"u"`           // 上行流量（up缩写）
+	D int64  `json:"d"`           // 下行流量（down缩写）
+	O int64  `json:"o,omitempty"` // 新建连接数（opened缩写）
+	C int64  `json:"c,omitempty"` // 关闭连接数（closed缩写）
+}
+
+// SetNodeTLS 启用双向TLS，之后的上报都使用面板签发的客户端证书
+func SetNodeTLS(certFile, keyFile, caFile string) error {
+	return nodetls.Load(certFile, keyFile, caFile)
 }
 
 func SetHTTPReportURL(addr string, secret string) {
-	httpReportURL = "http://" + addr + "/flow/upload?secret=" + secret
-	configReportURL = "http://" + addr + "/flow/config?secret=" + secret
+	scheme := "http://"
+	if nodetls.Enabled() {
+		scheme = "https://"
+	}
+	httpReportURL = scheme + addr + "/flow/upload?secret=" + secret
+	configReportURL = scheme + addr + "/flow/config?secret=" + secret
+	httpReportSecret = secret
 
 	// 创建 AES 加密器
 	var err error
@@ -41,6 +60,44 @@ func SetHTTPReportURL(addr string, secret string) {
 	}
 }
 
+// reportEnvelopeVersion 加密上报包装器的协议版本
+// 版本2将版本号和时间戳作为AES-GCM附加数据一并认证，面板据此拒绝篡改和重放
+const reportEnvelopeVersion = 2
+
+// sealReport 加密上报数据并生成包装器
+func sealReport(data []byte) ([]byte, error) {
+	timestamp := time.Now().Unix()
+	aad := fmt.Sprintf("flvx-flow:v%d:%d", reportEnvelopeVersion, timestamp)
+	encryptedData, err := httpAESCrypto.EncryptWithAAD(data, []byte(aad))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"encrypted": true,
+		"v":         reportEnvelopeVersion,
+		"data":      encryptedData,
+		"timestamp": timestamp,
+	})
+}
+
+// signReport 为上报请求添加 HMAC-SHA256 签名头（时间戳 + 随机数 + 请求体），
+// 面板据此校验请求完整性并拒绝重放
+func signReport(req *http.Request, body []byte) error {
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(nonceBytes)
+	mac := hmac.New(sha256.New, []byte(httpReportSecret))
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	req.Header.Set("X-Flvx-Timestamp", timestamp)
+	req.Header.Set("X-Flvx-Nonce", nonce)
+	req.Header.Set("X-Flvx-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
 // sendBatchTrafficReport 批量发送多个服务的流量报告到HTTP接口
 func sendBatchTrafficReport(ctx context.Context, reportItems []TrafficReportItem) (bool, error) {
 	jsonData, err := json.Marshal(reportItems)
@@ -52,22 +109,10 @@ func sendBatchTrafficReport(ctx context.Context, reportItems []TrafficReportItem
 
 	// 如果有加密器，则加密数据
 	if httpAESCrypto != nil {
-		encryptedData, err := httpAESCrypto.Encrypt(jsonData)
+		requestBody, err = sealReport(jsonData)
 		if err != nil {
 			fmt.Printf("⚠️ 加密流量报告失败，发送原始数据: %v\n", err)
 			requestBody = jsonData
-		} else {
-			// 创建加密消息包装器
-			encryptedMessage := map[string]interface{}{
-				"encrypted": true,
-				"data":      encryptedData,
-				"timestamp": time.Now().Unix(),
-			}
-			requestBody, err = json.Marshal(encryptedMessage)
-			if err != nil {
-				fmt.Printf("⚠️ 序列化加密流量报告失败，发送原始数据: %v\n", err)
-				requestBody = jsonData
-			}
 		}
 	} else {
 		requestBody = jsonData
@@ -80,9 +125,13 @@ func sendBatchTrafficReport(ctx context.Context, reportItems []TrafficReportItem
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "GOST-Traffic-Reporter/1.0")
+	if err := signReport(req, requestBody); err != nil {
+		return false, fmt.Errorf("签名上报请求失败: %v", err)
+	}
 
 	client := &http.Client{
-		Timeout: 5 * time.Second,
+		Timeout:   5 * time.Second,
+		Transport: reportTransport(),
 	}
 
 	resp, err := client.Do(req)
@@ -104,12 +153,17 @@ func sendBatchTrafficReport(ctx context.Context, reportItems []TrafficReportItem
 
 	responseText := strings.TrimSpace(responseBytes.String())
 
-	// 检查响应是否为"ok"
+	// 检查响应是否为"ok"，新版面板会返回加密的期望配置
 	if responseText == "ok" {
 		return true, nil
-	} else {
-		return false, fmt.Errorf("服务器响应: %s (期望: ok)", responseText)
 	}
+	var envelope struct {
+		Encrypted bool `json:"encrypted"`
+	}
+	if json.Unmarshal([]byte(responseText), &envelope) == nil && envelope.Encrypted {
+		return true, nil
+	}
+	return false, fmt.Errorf("服务器响应: %s (期望: ok)", responseText)
 }
 
 
@@ -129,22 +183,10 @@ func sendConfigReport(ctx context.Context) (bool, error) {
 
 	// 如果有加密器，则加密数据
 	if httpAESCrypto != nil {
-		encryptedData, err := httpAESCrypto.Encrypt(configData)
+		requestBody, err = sealReport(configData)
 		if err != nil {
 			fmt.Printf("⚠️ 加密配置报告失败，发送原始数据: %v\n", err)
 			requestBody = configData
-		} else {
-			// 创建加密消息包装器
-			encryptedMessage := map[string]interface{}{
-				"encrypted": true,
-				"data":      encryptedData,
-				"timestamp": time.Now().Unix(),
-			}
-			requestBody, err = json.Marshal(encryptedMessage)
-			if err != nil {
-				fmt.Printf("⚠️ 序列化加密配置报告失败，发送原始数据: %v\n", err)
-				requestBody = configData
-			}
 		}
 	} else {
 		requestBody = configData
@@ -157,9 +199,13 @@ func sendConfigReport(ctx context.Context) (bool, error) {
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "Config-Reporter/1.0")
+	if err := signReport(req, requestBody); err != nil {
+		return false, fmt.Errorf("签名上报请求失败: %v", err)
+	}
 
 	client := &http.Client{
-		Timeout: 10 * time.Second, // 配置上报可以稍长一些
+		Timeout:   10 * time.Second, // 配置上报可以稍长一些
+		Transport: reportTransport(),
 	}
 
 	resp, err := client.Do(req)
@@ -181,12 +227,17 @@ func sendConfigReport(ctx context.Context) (bool, error) {
 
 	responseText := strings.TrimSpace(responseBytes.String())
 
-	// 检查响应是否为"ok"
+	// 检查响应是否为"ok"，新版面板会返回加密的期望配置
 	if responseText == "ok" {
 		return true, nil
-	} else {
-		return false, fmt.Errorf("服务器响应: %s (期望: ok)", responseText)
 	}
+	var envelope struct {
+		Encrypted bool `json:"encrypted"`
+	}
+	if json.Unmarshal([]byte(responseText), &envelope) == nil && envelope.Encrypted {
+		return true, nil
+	}
+	return false, fmt.Errorf("服务器响应: %s (期望: ok)", responseText)
 }
 
 // StartConfigReporter 启动配置定时上报器（每10分钟上报一次）
@@ -286,3 +337,12 @@ func getConfigData() ([]byte, error) {
 	resp.Config.Write(buf, "json")
 	return buf.Bytes(), nil
 }
+
+// reportTransport 启用双向TLS时带上客户端证书，否则使用默认传输
+func reportTransport() http.RoundTripper {
+	tlsConfig := nodetls.Config()
+	if tlsConfig == nil {
+		return http.DefaultTransport
+	}
+	return &http.Transport{TLSClientConfig: tlsConfig}
+}
@@ -0,0 +1,163 @@
+package socket
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-gost/x/config"
+	"github.com/go-gost/x/service"
+)
+
+// hopStatsInterval 跳点统计上报间隔
+const hopStatsInterval = 30 * time.Second
+
+// 隧道跳点位置，与面板 chain_tunnel.chain_type 一致
+const (
+	hopChainEntry = 1
+	hopChainRelay = 2
+	hopChainExit  = 3
+)
+
+// HopStat 本节点在某个隧道中所处跳点的统计
+type HopStat struct {
+	TunnelID     int64   `json:"tunnelId"`
+	ChainType    int     `json:"chainType"`
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+	BytesIn      int64   `json:"bytesIn"`
+	BytesOut     int64   `json:"bytesOut"`
+}
+
+// HopStatsMessage 跳点统计上报消息，字节数为自上次上报以来的增量
+type HopStatsMessage struct {
+	Type string    `json:"type"`
+	Hops []HopStat `json:"hops"`
+}
+
+// reportHopStats 定时上报跳点统计，done 关闭时退出
+func (w *WebSocketReporter) reportHopStats(done <-chan struct{}) {
+	ticker := time.NewTicker(hopStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			hops := collectHopStats()
+			if len(hops) == 0 {
+				continue
+			}
+			if err := w.sendMessage(HopStatsMessage{Type: "HopStats", Hops: hops}); err != nil {
+				fmt.Printf("❌ 发送跳点统计失败: %v\n", err)
+			}
+		}
+	}
+}
+
+// collectHopStats 根据当前配置识别本节点承担的隧道跳点：
+// 服务 <id>_tls 在存在 chains_<id> 时为中转，否则为出口；
+// 其余使用 chains_<id> 的服务（转发）或没有 <id>_tls 服务的链为入口。
+func collectHopStats() []HopStat {
+	cfg := config.Global()
+	traffic := service.GetGlobalTrafficManager().TakeHopTraffic()
+
+	chains := make(map[int64]*config.ChainConfig)
+	for _, c := range cfg.Chains {
+		if c == nil {
+			continue
+		}
+		if id, ok := parseTunnelName(c.Name, "chains_", ""); ok {
+			chains[id] = c
+		}
+	}
+
+	hops := make(map[int64]*HopStat)
+	hopFor := func(tunnelID int64, chainType int) *HopStat {
+		hop, ok := hops[tunnelID]
+		if !ok {
+			hop = &HopStat{TunnelID: tunnelID, ChainType: chainType}
+			hops[tunnelID] = hop
+		}
+		return hop
+	}
+
+	for _, svc := range cfg.Services {
+		if svc == nil {
+			continue
+		}
+		var hop *HopStat
+		if id, ok := parseTunnelName(svc.Name, "", "_tls"); ok {
+			chainType := hopChainExit
+			if _, hasChain := chains[id]; hasChain {
+				chainType = hopChainRelay
+			}
+			hop = hopFor(id, chainType)
+			hop.ChainType = chainType
+		} else if svc.Handler != nil {
+			if id, ok := parseTunnelName(svc.Handler.Chain, "chains_", ""); ok {
+				hop = hopFor(id, hopChainEntry)
+			}
+		}
+		if hop == nil {
+			continue
+		}
+		t := traffic[svc.Name]
+		hop.BytesIn += t.Down
+		hop.BytesOut += t.Up
+	}
+	for id := range chains {
+		hopFor(id, hopChainEntry)
+	}
+
+	result := make([]HopStat, 0, len(hops))
+	for id, hop := range hops {
+		if hop.ChainType != hopChainExit {
+			hop.AvgLatencyMs = chainLatency(chains[id])
+		}
+		result = append(result, *hop)
+	}
+	return result
+}
+
+// parseTunnelName 从 prefix<id>suffix 形式的名称中解析隧道ID
+func parseTunnelName(name, prefix, suffix string) (int64, bool) {
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// chainLatency 测量到链路下一跳各节点的平均TCP连接延迟（毫秒），全部不可达时为0
+func chainLatency(chain *config.ChainConfig) float64 {
+	if chain == nil || len(chain.Hops) == 0 || chain.Hops[0] == nil {
+		return 0
+	}
+	var total float64
+	var count int
+	for _, node := range chain.Hops[0].Nodes {
+		if node == nil || node.Addr == "" {
+			continue
+		}
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", node.Addr, 3*time.Second)
+		if err != nil {
+			continue
+		}
+		total += float64(time.Since(start).Microseconds()) / 1000
+		count++
+		conn.Close()
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
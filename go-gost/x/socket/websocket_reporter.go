@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -18,10 +19,12 @@ import (
 	"strconv"
 	"strings"
 	"sync" // 新增：用于管理连接状态的互斥锁
+	"syscall"
 	"time"
 
 	"github.com/go-gost/x/config"
 	"github.com/go-gost/x/internal/util/crypto"
+	"github.com/go-gost/x/internal/util/nodetls"
 	"github.com/go-gost/x/service"
 	"github.com/gorilla/websocket"
 	"github.com/shirou/gopsutil/v3/cpu"
@@ -91,9 +94,27 @@ type TcpPingResponse struct {
 	RequestId    string  `json:"requestId,omitempty"`
 }
 
+// LatencyTarget 面板下发的延迟测量目标节点
+type LatencyTarget struct {
+	NodeID int64  `json:"nodeId"`
+	IP     string `json:"ip"`
+	Port   int    `json:"port"`
+}
+
+// LatencyResultItem 单个目标节点的测量结果
+type LatencyResultItem struct {
+	NodeID    int64   `json:"nodeId"`
+	LatencyMs float64 `json:"latencyMs"`
+	Success   bool    `json:"success"`
+}
+
 const (
 	reporterReadWait  = 60 * time.Second
 	reporterWriteWait = 5 * time.Second
+
+	// reporterMultiplexChannels 向面板申请的逻辑通道数：命令应答与异步上报各占一个，
+	// 互不阻塞
+	reporterMultiplexChannels = 2
 )
 
 type WebSocketReporter struct {
@@ -111,6 +132,8 @@ type WebSocketReporter struct {
 	connecting     bool              // 新增：正在连接状态
 	connMutex      sync.Mutex        // 新增：连接状态锁
 	aesCrypto      *crypto.AESCrypto // 新增：AES加密器
+	prevCrypto     *crypto.AESCrypto // 更换密钥后仍用旧密钥解密在途消息
+	muxChannels    []int             // 面板分配的逻辑通道，未启用多路复用时为空
 }
 
 // NewWebSocketReporter 创建一个新的WebSocket报告器
@@ -224,7 +247,7 @@ func (w *WebSocketReporter) connect() error {
 	}
 
 	// 使用最新的配置重新构建 URL
-	currentURL := "ws://" + w.addr + "/system-info?type=1&secret=" + w.secret + "&version=" + w.version +
+	currentURL := wsScheme() + w.addr + "/system-info?type=1&secret=" + w.secret + "&version=" + w.version +
 		"&http=" + strconv.Itoa(cfg.Http) + "&tls=" + strconv.Itoa(cfg.Tls) + "&socks=" + strconv.Itoa(cfg.Socks)
 
 	u, err := url.Parse(currentURL)
@@ -234,6 +257,7 @@ func (w *WebSocketReporter) connect() error {
 
 	dialer := websocket.DefaultDialer
 	dialer.HandshakeTimeout = 10 * time.Second
+	dialer.TLSClientConfig = nodetls.Config()
 
 	conn, _, err := dialer.Dial(u.String(), nil)
 	if err != nil {
@@ -248,6 +272,7 @@ func (w *WebSocketReporter) connect() error {
 
 	w.conn = conn
 	w.connected = true
+	w.muxChannels = nil
 	_ = conn.SetReadDeadline(time.Now().Add(reporterReadWait))
 	conn.SetPingHandler(func(appData string) error {
 		_ = conn.SetReadDeadline(time.Now().Add(reporterReadWait))
@@ -285,6 +310,19 @@ func (w *WebSocketReporter) handleConnection() {
 	// 启动消息接收goroutine
 	go w.receiveMessages()
 
+	// 申请逻辑通道，面板不支持时没有应答，消息照常不带通道号发送
+	if err := w.sendMessage(map[string]interface{}{
+		"type":     "MultiplexRequest",
+		"channels": reporterMultiplexChannels,
+	}); err != nil {
+		fmt.Printf("⚠️ 申请多路复用通道失败: %v\n", err)
+	}
+
+	// 启动跳点统计上报，连接关闭时随之停止
+	hopDone := make(chan struct{})
+	defer close(hopDone)
+	go w.reportHopStats(hopDone)
+
 	// 主发送循环
 	ticker := time.NewTicker(w.pingInterval)
 	defer ticker.Stop()
@@ -431,6 +469,9 @@ func (w *WebSocketReporter) handleReceivedMessage(messageType int, message []byt
 			if w.aesCrypto != nil {
 				// 解密数据
 				decryptedData, err := w.aesCrypto.Decrypt(encryptedWrapper.Data)
+				if err != nil && w.prevCrypto != nil {
+					decryptedData, err = w.prevCrypto.Decrypt(encryptedWrapper.Data)
+				}
 				if err != nil {
 					fmt.Printf("❌ 解密失败: %v\n", err)
 					w.sendErrorResponse("DecryptError", fmt.Sprintf("解密失败: %v", err))
@@ -500,6 +541,10 @@ func (w *WebSocketReporter) handleReceivedMessage(messageType int, message []byt
 				w.sendErrorResponse("ParseError", fmt.Sprintf("解析命令失败: %v", err))
 				return
 			}
+			if cmdMsg.Type == "MultiplexResponse" {
+				w.handleMultiplexResponse(message)
+				return
+			}
 			if cmdMsg.Type != "call" {
 				// 其他状态变更命令保持同步，确保顺序执行
 				if cmdMsg.Type == "TcpPing" || cmdMsg.Type == "UpgradeAgent" || cmdMsg.Type == "RollbackAgent" {
@@ -526,7 +571,8 @@ func (w *WebSocketReporter) routeCommand(cmd CommandMessage) {
 	fmt.Println("🔔 收到命令: ", string(jsonBytes))
 	var err error
 	var response CommandResponse
-	var needSaveConfig bool // 标记是否需要保存配置（只有状态变更命令才需要）
+	var needSaveConfig bool  // 标记是否需要保存配置（只有状态变更命令才需要）
+	var afterResponse func() // 响应发送后执行，用于必须在应答之后生效的变更
 
 	// 传递 requestId
 	response.RequestId = cmd.RequestId
@@ -534,9 +580,12 @@ func (w *WebSocketReporter) routeCommand(cmd CommandMessage) {
 	switch cmd.Type {
 	// Service 相关命令
 	case "AddService":
-		err = w.handleAddService(cmd.Data)
+		var ports []int
+		ports, err = w.handleAddService(cmd.Data)
 		response.Type = "AddServiceResponse"
 		needSaveConfig = true
+		addErr := err
+		afterResponse = func() { w.sendServiceAddedAcks(ports, addErr) }
 	case "UpdateService":
 		err = w.handleUpdateService(cmd.Data)
 		response.Type = "UpdateServiceResponse"
@@ -553,6 +602,10 @@ func (w *WebSocketReporter) routeCommand(cmd CommandMessage) {
 		err = w.handleResumeService(cmd.Data)
 		response.Type = "ResumeServiceResponse"
 		needSaveConfig = true
+	case "ThrottleService":
+		err = w.handleThrottleService(cmd.Data)
+		response.Type = "ThrottleServiceResponse"
+		needSaveConfig = true
 
 	// Chain 相关命令
 	case "AddChains":
@@ -608,6 +661,33 @@ func (w *WebSocketReporter) routeCommand(cmd CommandMessage) {
 		response.Type = "RollbackAgentResponse"
 		// needSaveConfig = false (默认值)
 
+	// 更换节点密钥：用旧密钥应答，应答发出后再切换（暂不持久化）
+	case "RotateSecret":
+		var newSecret string
+		newSecret, err = w.handleRotateSecret(cmd.Data)
+		response.Type = "RotateSecretResponse"
+		if err == nil {
+			afterResponse = func() { w.applySecret(newSecret) }
+		}
+
+	// 面板已保存新密钥，写入 config.json
+	case "CommitSecret":
+		err = w.handleCommitSecret(cmd.Data)
+		response.Type = "CommitSecretResponse"
+
+	// 节点间延迟测量：先应答，测量完成后异步上报 LatencyResult
+	case "MeasureLatency":
+		var targets []LatencyTarget
+		targets, err = parseMeasureLatency(cmd.Data)
+		response.Type = "MeasureLatencyResponse"
+		if err == nil {
+			afterResponse = func() { go w.measureLatency(targets) }
+		}
+
+	// 连通性测试，原样应答即可
+	case "Ping":
+		response.Type = "PingResponse"
+
 	default:
 		err = fmt.Errorf("未知命令类型: %s", cmd.Type)
 		response.Type = "UnknownCommandResponse"
@@ -637,29 +717,73 @@ func (w *WebSocketReporter) routeCommand(cmd CommandMessage) {
 	}
 
 	w.sendResponse(response)
+	if afterResponse != nil {
+		afterResponse()
+	}
 }
 
 // Service 命令处理函数
-func (w *WebSocketReporter) handleAddService(data interface{}) error {
+// handleAddService 返回本次涉及的监听端口，供发送 ServiceAddedAck 使用
+func (w *WebSocketReporter) handleAddService(data interface{}) ([]int, error) {
 	// 将 interface{} 转换为 JSON 再解析为具体类型
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("序列化数据失败: %v", err)
+		return nil, fmt.Errorf("序列化数据失败: %v", err)
 	}
 
 	// 预处理：将字符串格式的 duration 转换为纳秒数
 	processedData, err := w.preprocessDurationFields(jsonData)
 	if err != nil {
-		return fmt.Errorf("预处理duration字段失败: %v", err)
+		return nil, fmt.Errorf("预处理duration字段失败: %v", err)
 	}
 
 	var services []config.ServiceConfig
 	if err := json.Unmarshal(processedData, &services); err != nil {
-		return fmt.Errorf("解析服务配置失败: %v", err)
+		return nil, fmt.Errorf("解析服务配置失败: %v", err)
 	}
 
 	req := createServicesRequest{Data: services}
-	return createServices(req)
+	return serviceListenPorts(services), createServices(req)
+}
+
+// serviceListenPorts 提取服务监听端口并去重（同一转发的 tcp/udp 服务共用一个端口）
+func serviceListenPorts(services []config.ServiceConfig) []int {
+	seen := make(map[int]struct{})
+	var ports []int
+	for _, svc := range services {
+		_, portText, err := net.SplitHostPort(svc.Addr)
+		if err != nil {
+			continue
+		}
+		port, err := strconv.Atoi(portText)
+		if err != nil || port <= 0 {
+			continue
+		}
+		if _, ok := seen[port]; ok {
+			continue
+		}
+		seen[port] = struct{}{}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// sendServiceAddedAcks 逐个端口上报服务是否真正启动，面板据此标记转发状态。
+// 端口被其他进程占用时监听失败，createServices 会返回错误
+func (w *WebSocketReporter) sendServiceAddedAcks(ports []int, addErr error) {
+	for _, port := range ports {
+		ack := map[string]interface{}{
+			"type":    "ServiceAddedAck",
+			"port":    port,
+			"success": addErr == nil,
+		}
+		if addErr != nil {
+			ack["error"] = addErr.Error()
+		}
+		if err := w.sendMessage(ack); err != nil {
+			fmt.Printf("❌ 发送 ServiceAddedAck 失败: %v\n", err)
+		}
+	}
 }
 
 func (w *WebSocketReporter) handleUpdateService(data interface{}) error {
@@ -725,6 +849,28 @@ func (w *WebSocketReporter) handleResumeService(data interface{}) error {
 	return resumeServices(req)
 }
 
+// handleThrottleService 限制服务带宽。目前只支持 0 bps，即停止服务转发，
+// 面板手动失效联邦运行时绑定时使用。
+func (w *WebSocketReporter) handleThrottleService(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化数据失败: %v", err)
+	}
+
+	var req struct {
+		Services []string `json:"services"`
+		Bps      int64    `json:"bps"`
+	}
+	if err := json.Unmarshal(jsonData, &req); err != nil {
+		return fmt.Errorf("解析限速请求失败: %v", err)
+	}
+	if req.Bps != 0 {
+		return fmt.Errorf("暂不支持 %d bps 限速，仅支持 0 bps", req.Bps)
+	}
+
+	return pauseServices(pauseServicesRequest{Services: req.Services})
+}
+
 // Chain 命令处理函数
 func (w *WebSocketReporter) handleAddChain(data interface{}) error {
 	jsonData, err := json.Marshal(data)
@@ -1090,6 +1236,90 @@ func (w *WebSocketReporter) handleRollbackAgent(data interface{}) error {
 	return nil
 }
 
+// handleRotateSecret 校验面板下发的新密钥。此时只在内存中切换，
+// 面板保存成功后会再下发 CommitSecret，届时才写入 config.json
+func (w *WebSocketReporter) handleRotateSecret(data interface{}) (string, error) {
+	secret, err := parseSecretCommand(data)
+	if err != nil {
+		return "", err
+	}
+	if _, err := crypto.NewAESCrypto(secret); err != nil {
+		return "", fmt.Errorf("新密钥无效: %v", err)
+	}
+	return secret, nil
+}
+
+// handleCommitSecret 将已切换的密钥写入 config.json，重启后仍然生效
+func (w *WebSocketReporter) handleCommitSecret(data interface{}) error {
+	secret, err := parseSecretCommand(data)
+	if err != nil {
+		return err
+	}
+	w.connMutex.Lock()
+	current := w.secret
+	w.connMutex.Unlock()
+	if secret != current {
+		return fmt.Errorf("待保存的密钥与当前密钥不一致")
+	}
+	if err := updateLocalConfigSecret(secret); err != nil {
+		return fmt.Errorf("保存新密钥失败: %v", err)
+	}
+	return nil
+}
+
+// parseSecretCommand 解析 RotateSecret / CommitSecret 命令中的密钥
+func parseSecretCommand(data interface{}) (string, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("序列化密钥数据失败: %v", err)
+	}
+	var req struct {
+		Secret string `json:"secret"`
+	}
+	if err := json.Unmarshal(jsonData, &req); err != nil {
+		return "", fmt.Errorf("解析密钥数据失败: %v", err)
+	}
+	secret := strings.TrimSpace(req.Secret)
+	if secret == "" {
+		return "", fmt.Errorf("新密钥不能为空")
+	}
+	return secret, nil
+}
+
+// applySecret 切换到新密钥：之后的上报、重连和加密都使用新密钥，
+// 旧密钥仅保留用于解密切换前已发出的消息
+func (w *WebSocketReporter) applySecret(secret string) {
+	aesCrypto, err := crypto.NewAESCrypto(secret)
+	if err != nil {
+		fmt.Printf("❌ 创建 AES 加密器失败: %v\n", err)
+		return
+	}
+	w.connMutex.Lock()
+	w.prevCrypto = w.aesCrypto
+	w.aesCrypto = aesCrypto
+	w.secret = secret
+	w.connMutex.Unlock()
+	service.SetHTTPReportURL(w.addr, secret)
+	fmt.Println("🔑 节点密钥已更换")
+}
+
+// updateLocalConfigSecret 只替换 config.json 中的 secret，保留其余字段
+func updateLocalConfigSecret(secret string) error {
+	path := "config.json"
+	cfg := map[string]interface{}{}
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return err
+		}
+	}
+	cfg["secret"] = secret
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
 // updateLocalConfigJSON 将 http/tls/socks 写入工作目录下的 config.json
 func updateLocalConfigJSON(httpVal int, tlsVal int, socksVal int) error {
 	path := "config.json"
@@ -1159,18 +1389,26 @@ func (w *WebSocketReporter) handleCall(data interface{}) error {
 
 // sendResponse 发送响应消息到服务端
 func (w *WebSocketReporter) sendResponse(response CommandResponse) {
+	if err := w.sendMessage(response); err != nil {
+		fmt.Printf("❌ 发送响应失败: %v\n", err)
+	}
+}
+
+// sendMessage 序列化（并在有加密器时加密）消息后发送给面板
+func (w *WebSocketReporter) sendMessage(message interface{}) error {
 	w.connMutex.Lock()
 	defer w.connMutex.Unlock()
 
 	if w.conn == nil || !w.connected {
-		fmt.Printf("❌ 无法发送响应：连接未建立\n")
-		return
+		return fmt.Errorf("连接未建立")
 	}
 
-	jsonData, err := json.Marshal(response)
+	jsonData, err := json.Marshal(message)
 	if err != nil {
-		fmt.Printf("❌ 序列化响应失败: %v\n", err)
-		return
+		return fmt.Errorf("序列化消息失败: %v", err)
+	}
+	if jsonData, err = w.tagChannel(message, jsonData); err != nil {
+		return fmt.Errorf("序列化消息失败: %v", err)
 	}
 
 	var messageData []byte
@@ -1179,7 +1417,7 @@ func (w *WebSocketReporter) sendResponse(response CommandResponse) {
 	if w.aesCrypto != nil {
 		encryptedData, err := w.aesCrypto.Encrypt(jsonData)
 		if err != nil {
-			fmt.Printf("⚠️ 加密响应失败，发送原始数据: %v\n", err)
+			fmt.Printf("⚠️ 加密消息失败，发送原始数据: %v\n", err)
 			messageData = jsonData
 		} else {
 			// 创建加密消息包装器
@@ -1190,7 +1428,7 @@ func (w *WebSocketReporter) sendResponse(response CommandResponse) {
 			}
 			messageData, err = json.Marshal(encryptedMessage)
 			if err != nil {
-				fmt.Printf("⚠️ 序列化加密响应失败，发送原始数据: %v\n", err)
+				fmt.Printf("⚠️ 序列化加密消息失败，发送原始数据: %v\n", err)
 				messageData = jsonData
 			}
 		}
@@ -1200,7 +1438,7 @@ func (w *WebSocketReporter) sendResponse(response CommandResponse) {
 
 	// 检查消息大小，如果超过10MB则记录警告
 	if len(messageData) > 10*1024*1024 {
-		fmt.Printf("⚠️ 响应消息过大 (%.2f MB)，可能会被拒绝\n", float64(len(messageData))/(1024*1024))
+		fmt.Printf("⚠️ 消息过大 (%.2f MB)，可能会被拒绝\n", float64(len(messageData))/(1024*1024))
 	}
 
 	// 设置较长的写入超时，以应对大消息
@@ -1211,9 +1449,43 @@ func (w *WebSocketReporter) sendResponse(response CommandResponse) {
 
 	w.conn.SetWriteDeadline(time.Now().Add(timeout))
 	if err := w.conn.WriteMessage(websocket.TextMessage, messageData); err != nil {
-		fmt.Printf("❌ 发送响应失败: %v\n", err)
 		w.connected = false
+		return err
+	}
+	return nil
+}
+
+// handleMultiplexResponse 记录面板分配的逻辑通道
+func (w *WebSocketReporter) handleMultiplexResponse(message []byte) {
+	var resp struct {
+		Channels []int `json:"channels"`
+	}
+	if err := json.Unmarshal(message, &resp); err != nil {
+		fmt.Printf("❌ 解析多路复用应答失败: %v\n", err)
+		return
 	}
+	w.connMutex.Lock()
+	w.muxChannels = resp.Channels
+	w.connMutex.Unlock()
+	fmt.Printf("🔀 已启用多路复用通道: %v\n", resp.Channels)
+}
+
+// tagChannel 为消息加上通道号：命令应答走第一个通道，其余异步上报走最后一个通道。
+// 调用方需持有 connMutex
+func (w *WebSocketReporter) tagChannel(message interface{}, jsonData []byte) ([]byte, error) {
+	if len(w.muxChannels) == 0 {
+		return jsonData, nil
+	}
+	chanID := w.muxChannels[len(w.muxChannels)-1]
+	if _, ok := message.(CommandResponse); ok {
+		chanID = w.muxChannels[0]
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(jsonData, &fields); err != nil {
+		return nil, err
+	}
+	fields["chan"], _ = json.Marshal(chanID)
+	return json.Marshal(fields)
 }
 
 // sendErrorResponse 发送错误响应
@@ -1290,7 +1562,7 @@ func getMemoryInfo() MemoryInfo {
 func StartWebSocketReporterWithConfig(addr string, secret string, http int, tls int, socks int, version string) *WebSocketReporter {
 
 	// 构建初始 WebSocket URL
-	fullURL := "ws://" + addr + "/system-info?type=1&secret=" + secret + "&version=" + version + "&http=" + strconv.Itoa(http) + "&tls=" + strconv.Itoa(tls) + "&socks=" + strconv.Itoa(socks)
+	fullURL := wsScheme() + addr + "/system-info?type=1&secret=" + secret + "&version=" + version + "&http=" + strconv.Itoa(http) + "&tls=" + strconv.Itoa(tls) + "&socks=" + strconv.Itoa(socks)
 
 	fmt.Printf("🔗 WebSocket连接URL: %s\n", fullURL)
 
@@ -1366,6 +1638,66 @@ func (w *WebSocketReporter) handleTcpPing(data interface{}) (TcpPingResponse, er
 	return response, nil
 }
 
+func parseMeasureLatency(data interface{}) ([]LatencyTarget, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("序列化延迟测量数据失败: %v", err)
+	}
+	var req struct {
+		Targets []LatencyTarget `json:"targets"`
+	}
+	if err := json.Unmarshal(jsonData, &req); err != nil {
+		return nil, fmt.Errorf("解析延迟测量请求失败: %v", err)
+	}
+	return req.Targets, nil
+}
+
+// measureLatency 逐个测量到目标节点的 TCP 握手耗时，完成后上报 LatencyResult
+func (w *WebSocketReporter) measureLatency(targets []LatencyTarget) {
+	results := make([]LatencyResultItem, 0, len(targets))
+	for _, target := range targets {
+		item := LatencyResultItem{NodeID: target.NodeID}
+		if net.ParseIP(target.IP) != nil || isValidHostname(target.IP) {
+			item.LatencyMs, item.Success = tcpHandshakeLatency(target.IP, target.Port, 3, 3*time.Second)
+		}
+		results = append(results, item)
+	}
+	message := map[string]interface{}{
+		"type":    "LatencyResult",
+		"results": results,
+	}
+	if err := w.sendMessage(message); err != nil {
+		fmt.Printf("❌ 发送 LatencyResult 失败: %v\n", err)
+	}
+}
+
+// tcpHandshakeLatency 返回多次 TCP 握手的平均耗时（毫秒）。
+// 端口未监听时对端回 RST，同样是一次完整往返，也计入结果
+func tcpHandshakeLatency(ip string, port int, count int, timeout time.Duration) (float64, bool) {
+	if port <= 0 || port > 65535 {
+		port = 443
+	}
+	target := net.JoinHostPort(ip, strconv.Itoa(port))
+	var total float64
+	var ok int
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", target, timeout)
+		elapsed := time.Since(start)
+		if err == nil {
+			conn.Close()
+		} else if !errors.Is(err, syscall.ECONNREFUSED) {
+			continue
+		}
+		total += elapsed.Seconds() * 1000
+		ok++
+	}
+	if ok == 0 {
+		return 0, false
+	}
+	return total / float64(ok), true
+}
+
 // tcpPingHost 执行TCP连接测试，返回平均连接时间和失败率
 func tcpPingHost(ip string, port int, count int, timeoutMs int) (float64, float64, error) {
 	var totalTime float64
@@ -1502,3 +1834,11 @@ func (w *WebSocketReporter) processDurationInData(data interface{}) interface{}
 		return v
 	}
 }
+
+// wsScheme 启用双向TLS时使用 wss
+func wsScheme() string {
+	if nodetls.Enabled() {
+		return "wss://"
+	}
+	return "ws://"
+}
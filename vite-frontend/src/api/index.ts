@@ -7,29 +7,92 @@ export interface LoginData {
   username: string;
   password: string;
   captchaId: string;
+  totpCode?: string;
 }
 
 export interface LoginResponse {
   token: string;
+  refreshToken: string;
+  expiresIn: number;
   role_id: number;
   name: string;
   requirePasswordChange?: boolean;
+  totpRequired?: boolean;
 }
 
 export const login = (data: LoginData) =>
   Network.post<LoginResponse>("/user/login", data);
 
+// TOTP两步验证
+export const getTotpStatus = () => Network.post("/user/totp/status");
+export const setupTotp = () => Network.post("/user/totp/setup");
+export const confirmTotp = (code: string) =>
+  Network.post("/user/totp/confirm", { code });
+export const disableTotp = (data: { password: string; code: string }) =>
+  Network.post("/user/totp/disable", data);
+
+// 通行密钥(WebAuthn)
+export const passkeyRegisterBegin = () =>
+  Network.post("/user/passkey/register/begin");
+export const passkeyRegisterFinish = (data: {
+  name: string;
+  clientDataJSON: string;
+  attestationObject: string;
+}) => Network.post("/user/passkey/register/finish", data);
+export const getPasskeyList = () => Network.post("/user/passkey/list");
+export const deletePasskey = (id: number) =>
+  Network.post("/user/passkey/delete", { id });
+export const passkeyLoginBegin = (username?: string) =>
+  Network.post("/user/passkey/login/begin", { username: username ?? "" });
+export const passkeyLoginFinish = (data: {
+  credentialId: string;
+  clientDataJSON: string;
+  authenticatorData: string;
+  signature: string;
+}) => Network.post<LoginResponse>("/user/passkey/login/finish", data);
+
 // 用户CRUD操作 - 全部使用POST请求
 export const createUser = (data: any) => Network.post("/user/create", data);
 export const getAllUsers = (pageData: any = {}) =>
   Network.post("/user/list", pageData);
 export const updateUser = (data: any) => Network.post("/user/update", data);
 export const deleteUser = (id: number) => Network.post("/user/delete", { id });
+export const resetUserPassword = (id: number, password: string) =>
+  Network.post("/user/reset-password", { id, password });
+export const toggleUserStatus = (id: number, status?: number) =>
+  Network.post("/user/toggle-status", { id, status });
+export const importUsers = (data: any) => Network.post("/user/import", data);
 export const getUserPackageInfo = () => Network.post("/user/package");
+export const impersonateUser = (id: number) =>
+  Network.post("/user/impersonate", { id });
+
+// 登录安全 - 自动封禁的IP
+export const getIPBanList = () => Network.post("/security/ban/list");
+export const removeIPBan = (ip: string) =>
+  Network.post("/security/ban/remove", { ip });
+
+// 角色权限
+export const getRoleList = () => Network.post("/role/list");
+export const getRoleActions = () => Network.post("/role/actions");
+export const createRole = (data: {
+  name: string;
+  description?: string;
+  actions: string[];
+}) => Network.post("/role/create", data);
+export const updateRole = (data: {
+  id: number;
+  name: string;
+  description?: string;
+  actions: string[];
+}) => Network.post("/role/update", data);
+export const deleteRole = (id: number) => Network.post("/role/delete", { id });
+export const assignUserRole = (data: { userId: number; roleId: number }) =>
+  Network.post("/role/assign", data);
 
 // 节点CRUD操作 - 全部使用POST请求
 export const createNode = (data: any) => Network.post("/node/create", data);
-export const getNodeList = () => Network.post("/node/list");
+export const getNodeList = (pageData?: any) =>
+  Network.post("/node/list", pageData);
 export const updateNode = (data: any) => Network.post("/node/update", data);
 export const deleteNode = (id: number) => Network.post("/node/delete", { id });
 export const getNodeInstallCommand = (id: number) =>
@@ -58,10 +121,13 @@ export const batchUpgradeNodes = (ids: number[], version?: string) =>
 export const getNodeReleases = () => Network.post("/node/releases");
 export const rollbackNode = (id: number) =>
   Network.post("/node/rollback", { id });
+export const rotateNodeSecret = (id: number) =>
+  Network.post("/node/rotate-secret", { id });
 
 // 隧道CRUD操作 - 全部使用POST请求
 export const createTunnel = (data: any) => Network.post("/tunnel/create", data);
-export const getTunnelList = () => Network.post("/tunnel/list");
+export const getTunnelList = (pageData?: any) =>
+  Network.post("/tunnel/list", pageData);
 export const getTunnelById = (id: number) =>
   Network.post("/tunnel/get", { id });
 export const updateTunnel = (data: any) => Network.post("/tunnel/update", data);
@@ -91,7 +157,8 @@ export const userTunnel = () => Network.post("/tunnel/user/tunnel");
 // 转发CRUD操作 - 全部使用POST请求
 export const createForward = (data: any) =>
   Network.post("/forward/create", data);
-export const getForwardList = () => Network.post("/forward/list");
+export const getForwardList = (pageData?: any) =>
+  Network.post("/forward/list", pageData);
 export const updateForward = (data: any) =>
   Network.post("/forward/update", data);
 export const deleteForward = (id: number) =>
@@ -117,7 +184,8 @@ export const updateForwardOrder = (data: {
 // 限速规则CRUD操作 - 全部使用POST请求
 export const createSpeedLimit = (data: any) =>
   Network.post("/speed-limit/create", data);
-export const getSpeedLimitList = () => Network.post("/speed-limit/list");
+export const getSpeedLimitList = (pageData?: any) =>
+  Network.post("/speed-limit/list", pageData);
 export const updateSpeedLimit = (data: any) =>
   Network.post("/speed-limit/update", data);
 export const deleteSpeedLimit = (id: number) =>
@@ -126,6 +194,21 @@ export const deleteSpeedLimit = (id: number) =>
 // 修改密码接口
 export const updatePassword = (data: any) =>
   Network.post("/user/updatePassword", data);
+export const requestPasswordReset = (data: { username?: string; email?: string }) =>
+  Network.post("/user/password-reset/request", data);
+export const confirmPasswordReset = (data: {
+  token: string;
+  newPassword: string;
+  confirmPassword: string;
+}) => Network.post("/user/password-reset/confirm", data);
+export const getUserEmail = () => Network.post("/user/email/get");
+export const updateUserEmail = (data: { email: string; password: string }) =>
+  Network.post("/user/email/update", data);
+
+// 登录会话
+export const getUserSessions = () => Network.post("/user/session/list");
+export const revokeUserSession = (id: number) =>
+  Network.post("/user/session/revoke", { id });
 
 // 重置流量接口
 export const resetUserFlow = (data: { id: number; type: number }) =>
@@ -151,6 +234,8 @@ export const verifyCaptcha = (data: { captchaId: string; trackData: string }) =>
   Network.post("/captcha/verify", data);
 
 // 批量操作接口
+export const batchCreateForwards = (forwards: any[]) =>
+  Network.post("/forward/batch-create", { forwards });
 export const batchDeleteForwards = (ids: number[]) =>
   Network.post("/forward/batch-delete", { ids });
 export const batchPauseForwards = (ids: number[]) =>
@@ -283,5 +368,29 @@ export const exportBackup = async (types: string[] = []) => {
   window.URL.revokeObjectURL(url);
 };
 
+export const downloadSystemBackup = async () => {
+  const token = window.localStorage.getItem("token");
+  const baseURL = axios.defaults.baseURL || "/api/v1/";
+
+  const response = await axios.post(`${baseURL}/system/backup`, null, {
+    headers: {
+      Authorization: token,
+    },
+    responseType: "blob",
+  });
+
+  const url = window.URL.createObjectURL(new Blob([response.data]));
+  const link = document.createElement("a");
+
+  link.href = url;
+  const timestamp = new Date().toISOString().slice(0, 19).replace(/[:-]/g, "");
+
+  link.setAttribute("download", `flvx_backup_${timestamp}.json`);
+  document.body.appendChild(link);
+  link.click();
+  document.body.removeChild(link);
+  window.URL.revokeObjectURL(url);
+};
+
 export const importBackup = (data: { types: string[]; [key: string]: any }) =>
   Network.post("/backup/import", data);
@@ -51,6 +51,7 @@ interface RequestOptions {
 function handleTokenExpired() {
   // 清除localStorage中的token
   window.localStorage.removeItem("token");
+  window.localStorage.removeItem("refresh_token");
   window.localStorage.removeItem("role_id");
   window.localStorage.removeItem("name");
 
@@ -71,6 +72,104 @@ function isTokenExpired(response: ApiResponse) {
   );
 }
 
+// 后端开启Cookie登录模式时，需要把CSRF Cookie的值放到请求头中回传
+function csrfHeaders(): Record<string, string> {
+  const match = document.cookie.match(/(?:^|;\s*)flvx_csrf=([^;]*)/);
+
+  return match ? { "X-CSRF-Token": decodeURIComponent(match[1]) } : {};
+}
+
+let refreshing: Promise<boolean> | null = null;
+
+// 用refresh token换取新的token，多个请求同时失效时只刷新一次
+function refreshAccessToken(): Promise<boolean> {
+  const refreshToken = window.localStorage.getItem("refresh_token");
+
+  // Cookie登录模式下refresh token在HttpOnly Cookie中，由浏览器自动携带
+  if (!refreshToken && !csrfHeaders()["X-CSRF-Token"]) {
+    return Promise.resolve(false);
+  }
+  if (!refreshing) {
+    refreshing = axios
+      .post<ApiResponse<{ token: string; refreshToken: string }>>(
+        "/user/refresh",
+        { refreshToken: refreshToken ?? "" },
+        {
+          timeout: 30000,
+          headers: { "Content-Type": "application/json", ...csrfHeaders() },
+        },
+      )
+      .then(function (response) {
+        if (response.data.code !== 0 || !response.data.data?.token) {
+          return false;
+        }
+        window.localStorage.setItem("token", response.data.data.token);
+        window.localStorage.setItem(
+          "refresh_token",
+          response.data.data.refreshToken,
+        );
+
+        return true;
+      })
+      .catch(function () {
+        return false;
+      })
+      .finally(function () {
+        refreshing = null;
+      });
+  }
+
+  return refreshing;
+}
+
+// 发送请求；token失效时先尝试刷新并重试一次，刷新失败再跳转登录页
+function send<T>(
+  request: () => Promise<AxiosResponse<ApiResponse<T>>>,
+  resolve: (value: ApiResponse<T>) => void,
+  retried: boolean = false,
+) {
+  const onExpired = function () {
+    if (retried) {
+      handleTokenExpired();
+
+      return;
+    }
+    refreshAccessToken().then(function (ok) {
+      if (ok) {
+        send(request, resolve, true);
+
+        return;
+      }
+      handleTokenExpired();
+    });
+  };
+
+  request()
+    .then(function (response: AxiosResponse<ApiResponse<T>>) {
+      // 检查是否token失效
+      if (isTokenExpired(response.data)) {
+        onExpired();
+
+        return;
+      }
+      resolve(response.data);
+    })
+    .catch(function (error: any) {
+      // 检查是否是401错误（token失效）
+      if (error.response && error.response.status === 401) {
+        onExpired();
+
+        return;
+      }
+
+      resolve({
+        code: -1,
+        msg: error.message || "网络请求失败",
+        data: null as T,
+      });
+    });
+}
+
 const Network = {
   get: function <T = any>(
     path: string = "",
@@ -85,37 +184,16 @@ const Network = {
         return;
       }
 
-      axios
-        .get(path, {
+      send<T>(function () {
+        return axios.get(path, {
           params: data,
           timeout: options.timeout ?? 30000,
           headers: {
             Authorization: window.localStorage.getItem("token"),
+            ...csrfHeaders(),
           },
-        })
-        .then(function (response: AxiosResponse<ApiResponse<T>>) {
-          // 检查是否token失效
-          if (isTokenExpired(response.data)) {
-            handleTokenExpired();
-
-            return;
-          }
-          resolve(response.data);
-        })
-        .catch(function (error: any) {
-          // 检查是否是401错误（token失效）
-          if (error.response && error.response.status === 401) {
-            handleTokenExpired();
-
-            return;
-          }
-
-          resolve({
-            code: -1,
-            msg: error.message || "网络请求失败",
-            data: null as T,
-          });
         });
+      }, resolve);
     });
   },
 
@@ -132,37 +210,16 @@ const Network = {
         return;
       }
 
-      axios
-        .post(path, data, {
+      send<T>(function () {
+        return axios.post(path, data, {
           timeout: options.timeout ?? 30000,
           headers: {
             Authorization: window.localStorage.getItem("token"),
             "Content-Type": "application/json",
+            ...csrfHeaders(),
           },
-        })
-        .then(function (response: AxiosResponse<ApiResponse<T>>) {
-          // 检查是否token失效
-          if (isTokenExpired(response.data)) {
-            handleTokenExpired();
-
-            return;
-          }
-          resolve(response.data);
-        })
-        .catch(function (error: any) {
-          // 检查是否是401错误（token失效）
-          if (error.response && error.response.status === 401) {
-            handleTokenExpired();
-
-            return;
-          }
-
-          resolve({
-            code: -1,
-            msg: error.message || "网络请求失败",
-            data: null as T,
-          });
         });
+      }, resolve);
     });
   },
 };
@@ -0,0 +1,92 @@
+import { useEffect, useRef } from "react";
+
+interface HCaptchaApi {
+  render: (
+    container: HTMLElement,
+    params: {
+      sitekey: string;
+      theme?: "light" | "dark";
+      callback: (token: string) => void;
+      "expired-callback"?: () => void;
+      "error-callback"?: () => void;
+    },
+  ) => string;
+  remove: (widgetId: string) => void;
+}
+
+declare global {
+  interface Window {
+    hcaptcha?: HCaptchaApi;
+  }
+}
+
+const SCRIPT_SRC = "https://js.hcaptcha.com/1/api.js?render=explicit";
+
+let scriptPromise: Promise<HCaptchaApi> | null = null;
+
+// 按需加载 hCaptcha 脚本，只加载一次
+const loadHCaptcha = (): Promise<HCaptchaApi> => {
+  if (window.hcaptcha) return Promise.resolve(window.hcaptcha);
+  if (!scriptPromise) {
+    scriptPromise = new Promise((resolve, reject) => {
+      const script = document.createElement("script");
+
+      script.src = SCRIPT_SRC;
+      script.async = true;
+      script.onload = () =>
+        window.hcaptcha
+          ? resolve(window.hcaptcha)
+          : reject(new Error("hCaptcha 加载失败"));
+      script.onerror = () => {
+        scriptPromise = null;
+        reject(new Error("hCaptcha 加载失败"));
+      };
+      document.head.appendChild(script);
+    });
+  }
+
+  return scriptPromise;
+};
+
+interface HCaptchaProps {
+  siteKey: string;
+  theme?: "light" | "dark";
+  onSuccess: (token: string) => void;
+  onExpire?: () => void;
+  onError?: () => void;
+}
+
+export function HCaptcha({
+  siteKey,
+  theme,
+  onSuccess,
+  onExpire,
+  onError,
+}: HCaptchaProps) {
+  const containerRef = useRef<HTMLDivElement>(null);
+
+  useEffect(() => {
+    let widgetId: string | null = null;
+    let cancelled = false;
+
+    loadHCaptcha()
+      .then((api) => {
+        if (cancelled || !containerRef.current) return;
+        widgetId = api.render(containerRef.current, {
+          sitekey: siteKey,
+          theme,
+          callback: onSuccess,
+          "expired-callback": onExpire,
+          "error-callback": onError,
+        });
+      })
+      .catch(() => onError?.());
+
+    return () => {
+      cancelled = true;
+      if (widgetId !== null) window.hcaptcha?.remove(widgetId);
+    };
+  }, [siteKey]);
+
+  return <div ref={containerRef} />;
+}
@@ -77,14 +77,31 @@ const CONFIG_ITEMS: ConfigItem[] = [
     description: "开启后，用户登录时需要完成验证码验证",
     type: "switch",
   },
+  {
+    key: "captcha_provider",
+    label: "验证码类型",
+    description: "登录时使用的验证码服务，由后端校验",
+    type: "select",
+    options: [
+      { label: "Cloudflare Turnstile", value: "turnstile" },
+      { label: "hCaptcha", value: "hcaptcha" },
+      {
+        label: "图形验证码",
+        value: "image",
+        description: "内置数字图片验证码，无需外部服务",
+      },
+    ],
+    dependsOn: "captcha_enabled",
+    dependsValue: "true",
+  },
   {
     key: "cloudflare_site_key",
     label: "Cloudflare Site Key",
     placeholder: "请输入 Cloudflare Site Key",
     description: "Cloudflare Turnstile 站点密钥",
     type: "input",
-    dependsOn: "captcha_enabled",
-    dependsValue: "true",
+    dependsOn: "captcha_provider",
+    dependsValue: "turnstile",
   },
   {
     key: "cloudflare_secret_key",
@@ -92,8 +109,26 @@ const CONFIG_ITEMS: ConfigItem[] = [
     placeholder: "请输入 Cloudflare Secret Key",
     description: "Cloudflare Turnstile 密钥",
     type: "input",
-    dependsOn: "captcha_enabled",
-    dependsValue: "true",
+    dependsOn: "captcha_provider",
+    dependsValue: "turnstile",
+  },
+  {
+    key: "hcaptcha_site_key",
+    label: "hCaptcha Site Key",
+    placeholder: "请输入 hCaptcha Site Key",
+    description: "hCaptcha 站点密钥",
+    type: "input",
+    dependsOn: "captcha_provider",
+    dependsValue: "hcaptcha",
+  },
+  {
+    key: "hcaptcha_secret_key",
+    label: "hCaptcha Secret Key",
+    placeholder: "请输入 hCaptcha Secret Key",
+    description: "hCaptcha 密钥",
+    type: "input",
+    dependsOn: "captcha_provider",
+    dependsValue: "hcaptcha",
   },
 ];
 
@@ -104,8 +139,11 @@ const getInitialConfigs = (): Record<string, string> => {
   const configKeys = [
     "app_name",
     "captcha_enabled",
+    "captcha_provider",
     "cloudflare_site_key",
     "cloudflare_secret_key",
+    "hcaptcha_site_key",
+    "hcaptcha_secret_key",
     "ip",
     "panel_domain",
   ];
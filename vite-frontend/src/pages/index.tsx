@@ -3,19 +3,31 @@ import { Input } from "@heroui/input";
 import { Card, CardBody, CardHeader } from "@heroui/card";
 import { useState, useEffect } from "react";
 import { useNavigate } from "react-router-dom";
+import axios from "axios";
 import toast from "react-hot-toast";
 import { Turnstile } from "@marsidev/react-turnstile";
 
+import { HCaptcha } from "@/components/hcaptcha";
 import { isWebViewFunc } from "@/utils/panel";
 import { siteConfig } from "@/config/site";
 import { title } from "@/components/primitives";
 import DefaultLayout from "@/layouts/default";
-import { login, LoginData, checkCaptcha, getConfigByName } from "@/api";
+import {
+  login,
+  LoginData,
+  checkCaptcha,
+  generateCaptcha,
+  getConfigByName,
+  passkeyLoginBegin,
+  passkeyLoginFinish,
+} from "@/api";
+import { getPasskeyAssertion, passkeySupported } from "@/utils/passkey";
 
 interface LoginForm {
   username: string;
   password: string;
   captchaId: string;
+  totpCode: string;
 }
 
 export default function IndexPage() {
@@ -23,11 +35,21 @@ export default function IndexPage() {
     username: "",
     password: "",
     captchaId: "",
+    totpCode: "",
   });
   const [loading, setLoading] = useState(false);
   const [errors, setErrors] = useState<Partial<LoginForm>>({});
   const [showCaptcha, setShowCaptcha] = useState(false);
+  const [showTotp, setShowTotp] = useState(false);
+  const [oidcEnabled, setOidcEnabled] = useState(false);
+  const [passwordLoginEnabled, setPasswordLoginEnabled] = useState(true);
   const [siteKey, setSiteKey] = useState("");
+  const [captchaProvider, setCaptchaProvider] = useState("turnstile");
+  const [imageCaptcha, setImageCaptcha] = useState<{
+    id: string;
+    image: string;
+  } | null>(null);
+  const [imageAnswer, setImageAnswer] = useState("");
   const navigate = useNavigate();
   const [isWebView, setIsWebView] = useState(false);
 
@@ -36,6 +58,89 @@ export default function IndexPage() {
     setIsWebView(isWebViewFunc());
   }, []);
 
+  // 单点登录回调会把token放在URL的#片段中
+  useEffect(() => {
+    const fragment = new URLSearchParams(window.location.hash.slice(1));
+    const oidcError = fragment.get("oidcError");
+    const token = fragment.get("token");
+
+    if (!oidcError && !token) return;
+    window.history.replaceState(null, "", window.location.pathname);
+    if (oidcError) {
+      toast.error(oidcError);
+
+      return;
+    }
+    const roleId = fragment.get("role_id") ?? "";
+
+    localStorage.setItem("token", token ?? "");
+    localStorage.setItem("refresh_token", fragment.get("refreshToken") ?? "");
+    localStorage.setItem("role_id", roleId);
+    localStorage.setItem("name", fragment.get("name") ?? "");
+    localStorage.setItem("admin", (roleId === "0").toString());
+    toast.success("登录成功");
+    navigate("/dashboard");
+  }, []);
+
+  // 查询是否启用了单点登录
+  useEffect(() => {
+    getConfigByName("oidc_enabled").then((resp) => {
+      const enabled = resp.code === 0 && resp.data?.value === "true";
+
+      setOidcEnabled(enabled);
+      if (!enabled) return;
+      getConfigByName("password_login_enabled").then((pwResp) => {
+        setPasswordLoginEnabled(
+          !(pwResp.code === 0 && pwResp.data?.value === "false"),
+        );
+      });
+    });
+  }, []);
+
+  const handleOidcLogin = () => {
+    window.location.href = `${axios.defaults.baseURL ?? "/api/v1/"}user/oidc/login`;
+  };
+
+  // 通行密钥登录：填写了用户名时只使用该用户的通行密钥
+  const handlePasskeyLogin = async () => {
+    setLoading(true);
+    try {
+      const beginResp = await passkeyLoginBegin(form.username.trim());
+
+      if (beginResp.code !== 0) {
+        toast.error(beginResp.msg || "登录失败");
+
+        return;
+      }
+      if (!beginResp.data?.available) {
+        toast.error("该账号未设置通行密钥，请使用密码登录");
+
+        return;
+      }
+      const assertion = await getPasskeyAssertion(beginResp.data);
+
+      if (!assertion) return;
+      const response = await passkeyLoginFinish(assertion);
+
+      if (response.code !== 0) {
+        toast.error(response.msg || "登录失败");
+
+        return;
+      }
+      localStorage.setItem("token", response.data.token);
+      localStorage.setItem("refresh_token", response.data.refreshToken);
+      localStorage.setItem("role_id", response.data.role_id.toString());
+      localStorage.setItem("name", response.data.name);
+      localStorage.setItem("admin", (response.data.role_id === 0).toString());
+      toast.success("登录成功");
+      navigate("/dashboard");
+    } catch {
+      toast.error("通行密钥验证已取消或失败");
+    } finally {
+      setLoading(false);
+    }
+  };
+
   // 验证表单
   const validateForm = (): boolean => {
     const newErrors: Partial<LoginForm> = {};
@@ -76,10 +181,22 @@ export default function IndexPage() {
         username: form.username.trim(),
         password: form.password,
         captchaId: finalCaptchaId,
+        totpCode: form.totpCode.trim(),
       };
 
       const response = await login(loginData);
 
+      // 账号开启了TOTP，需要输入验证码后再次登录
+      if (response.code === 403 && response.data?.totpRequired) {
+        setShowTotp(true);
+        toast(response.msg);
+        if (showCaptcha) {
+          setForm((prev) => ({ ...prev, captchaId: "" }));
+        }
+
+        return;
+      }
+
       if (response.code !== 0) {
         toast.error(response.msg || "登录失败");
         if (showCaptcha) {
@@ -92,6 +209,7 @@ export default function IndexPage() {
       // 检查是否需要强制修改密码
       if (response.data.requirePasswordChange) {
         localStorage.setItem("token", response.data.token);
+        localStorage.setItem("refresh_token", response.data.refreshToken);
         localStorage.setItem("role_id", response.data.role_id.toString());
         localStorage.setItem("name", response.data.name);
         localStorage.setItem("admin", (response.data.role_id === 0).toString());
@@ -103,6 +221,7 @@ export default function IndexPage() {
 
       // 保存登录信息
       localStorage.setItem("token", response.data.token);
+      localStorage.setItem("refresh_token", response.data.refreshToken);
       localStorage.setItem("role_id", response.data.role_id.toString());
       localStorage.setItem("name", response.data.name);
       localStorage.setItem("admin", (response.data.role_id === 0).toString());
@@ -137,13 +256,30 @@ export default function IndexPage() {
       if (checkResponse.data === 0) {
         await performLogin();
       } else {
-        const configResp = await getConfigByName("cloudflare_site_key");
+        const providerResp = await getConfigByName("captcha_provider");
+        const provider =
+          (providerResp.code === 0 && providerResp.data?.value) || "turnstile";
+
+        setCaptchaProvider(provider);
+        if (provider === "image") {
+          await refreshImageCaptcha();
+          setShowCaptcha(true);
+
+          return;
+        }
+        const configResp = await getConfigByName(
+          provider === "hcaptcha" ? "hcaptcha_site_key" : "cloudflare_site_key",
+        );
 
         if (configResp.code === 0 && configResp.data && configResp.data.value) {
           setSiteKey(configResp.data.value);
           setShowCaptcha(true);
         } else {
-          toast.error("未配置Cloudflare Site Key，请联系管理员");
+          toast.error(
+            provider === "hcaptcha"
+              ? "未配置hCaptcha Site Key，请联系管理员"
+              : "未配置Cloudflare Site Key，请联系管理员",
+          );
           setLoading(false);
         }
       }
@@ -153,6 +289,37 @@ export default function IndexPage() {
     }
   };
 
+  // 获取新的图形验证码
+  const refreshImageCaptcha = async () => {
+    setImageAnswer("");
+    const resp = await generateCaptcha();
+
+    if (resp.code === 0 && resp.data) {
+      setImageCaptcha(resp.data);
+    } else {
+      setImageCaptcha(null);
+      toast.error(resp.msg || "获取验证码失败");
+      setLoading(false);
+    }
+  };
+
+  const submitImageCaptcha = () => {
+    if (!imageCaptcha || !imageAnswer.trim()) return;
+    const token = `${imageCaptcha.id}:${imageAnswer.trim()}`;
+
+    setShowCaptcha(false);
+    setImageCaptcha(null);
+    void performLogin(token);
+  };
+
+  const captchaTheme = (
+    document.documentElement.classList.contains("dark") ||
+    document.documentElement.getAttribute("data-theme") === "dark" ||
+    window.matchMedia("(prefers-color-scheme: dark)").matches
+      ? "dark"
+      : "light"
+  ) as "light" | "dark";
+
   const handleKeyPress = (e: React.KeyboardEvent) => {
     if (e.key === "Enter" && !loading) {
       handleLogin();
@@ -172,44 +339,90 @@ export default function IndexPage() {
             </CardHeader>
             <CardBody className="px-6 py-6">
               <div className="flex flex-col gap-4">
-                <Input
-                  errorMessage={errors.username}
-                  isDisabled={loading}
-                  isInvalid={!!errors.username}
-                  label="用户名"
-                  placeholder="请输入用户名"
-                  value={form.username}
-                  variant="bordered"
-                  onChange={(e) =>
-                    handleInputChange("username", e.target.value)
-                  }
-                  onKeyDown={handleKeyPress}
-                />
-
-                <Input
-                  isDisabled={loading}
-                  isInvalid={!!errors.password}
-                  label="密码"
-                  placeholder="请输入密码"
-                  type="password"
-                  value={form.password}
-                  variant="bordered"
-                  onChange={(e) =>
-                    handleInputChange("password", e.target.value)
-                  }
-                  onKeyDown={handleKeyPress}
-                />
-
-                <Button
-                  className="mt-2"
-                  color="primary"
-                  disabled={loading}
-                  isLoading={loading}
-                  size="lg"
-                  onClick={handleLogin}
-                >
-                  {loading ? (showCaptcha ? "验证中..." : "登录中...") : "登录"}
-                </Button>
+                {passwordLoginEnabled && (
+                  <>
+                    <Input
+                      errorMessage={errors.username}
+                      isDisabled={loading}
+                      isInvalid={!!errors.username}
+                      label="用户名"
+                      placeholder="请输入用户名"
+                      value={form.username}
+                      variant="bordered"
+                      onChange={(e) =>
+                        handleInputChange("username", e.target.value)
+                      }
+                      onKeyDown={handleKeyPress}
+                    />
+
+                    <Input
+                      isDisabled={loading}
+                      isInvalid={!!errors.password}
+                      label="密码"
+                      placeholder="请输入密码"
+                      type="password"
+                      value={form.password}
+                      variant="bordered"
+                      onChange={(e) =>
+                        handleInputChange("password", e.target.value)
+                      }
+                      onKeyDown={handleKeyPress}
+                    />
+
+                    {showTotp && (
+                      <Input
+                        autoFocus
+                        description="也可以输入恢复码"
+                        isDisabled={loading}
+                        label="TOTP验证码"
+                        placeholder="请输入6位验证码"
+                        value={form.totpCode}
+                        variant="bordered"
+                        onChange={(e) =>
+                          handleInputChange("totpCode", e.target.value)
+                        }
+                        onKeyDown={handleKeyPress}
+                      />
+                    )}
+
+                    <Button
+                      className="mt-2"
+                      color="primary"
+                      disabled={loading}
+                      isLoading={loading}
+                      size="lg"
+                      onClick={handleLogin}
+                    >
+                      {loading
+                        ? showCaptcha
+                          ? "验证中..."
+                          : "登录中..."
+                        : "登录"}
+                    </Button>
+                  </>
+                )}
+
+                {passkeySupported() && (
+                  <Button
+                    disabled={loading}
+                    size="lg"
+                    variant="bordered"
+                    onClick={handlePasskeyLogin}
+                  >
+                    使用通行密钥登录
+                  </Button>
+                )}
+
+                {oidcEnabled && (
+                  <Button
+                    disabled={loading}
+                    size="lg"
+                    variant="bordered"
+                    onClick={handleOidcLogin}
+                  >
+                    单点登录
+                  </Button>
+                )}
               </div>
             </CardBody>
           </Card>
@@ -235,7 +448,8 @@ export default function IndexPage() {
         </div>
 
         {/* 验证码弹层 */}
-        {showCaptcha && siteKey && (
+        {showCaptcha &&
+          (captchaProvider === "image" ? imageCaptcha : siteKey) && (
           <div className="fixed inset-0 z-50 flex items-center justify-center">
             {/* 背景遮罩层 - 模糊效果，暗黑模式下更深 */}
             <div
@@ -259,30 +473,68 @@ export default function IndexPage() {
                 请完成安全验证
               </div>
               <div className="flex justify-center">
-                <Turnstile
-                  options={{
-                    theme: (document.documentElement.classList.contains(
-                      "dark",
-                    ) ||
-                    document.documentElement.getAttribute("data-theme") ===
-                      "dark" ||
-                    window.matchMedia("(prefers-color-scheme: dark)").matches
-                      ? "dark"
-                      : "light") as "light" | "dark" | "auto",
-                  }}
-                  siteKey={siteKey}
-                  onError={() => {
-                    toast.error("验证失败，请刷新重试");
-                    setLoading(false);
-                  }}
-                  onExpire={() => {
-                    setForm((prev) => ({ ...prev, captchaId: "" }));
-                  }}
-                  onSuccess={(token) => {
-                    setForm((prev) => ({ ...prev, captchaId: token }));
-                    void performLogin(token);
-                  }}
-                />
+                {captchaProvider === "image" && imageCaptcha ? (
+                  <div className="flex flex-col items-center gap-3">
+                    <img
+                      alt="验证码"
+                      className="cursor-pointer rounded"
+                      role="presentation"
+                      src={imageCaptcha.image}
+                      title="看不清？点击刷新"
+                      onClick={() => void refreshImageCaptcha()}
+                    />
+                    <Input
+                      autoFocus
+                      placeholder="请输入图中数字"
+                      value={imageAnswer}
+                      variant="bordered"
+                      onChange={(e) => setImageAnswer(e.target.value)}
+                      onKeyDown={(e) => {
+                        if (e.key === "Enter") submitImageCaptcha();
+                      }}
+                    />
+                    <Button
+                      className="w-full"
+                      color="primary"
+                      isDisabled={!imageAnswer.trim()}
+                      onClick={submitImageCaptcha}
+                    >
+                      确定
+                    </Button>
+                  </div>
+                ) : captchaProvider === "hcaptcha" ? (
+                  <HCaptcha
+                    siteKey={siteKey}
+                    theme={captchaTheme}
+                    onError={() => {
+                      toast.error("验证失败，请刷新重试");
+                      setLoading(false);
+                    }}
+                    onExpire={() => {
+                      setForm((prev) => ({ ...prev, captchaId: "" }));
+                    }}
+                    onSuccess={(token) => {
+                      setForm((prev) => ({ ...prev, captchaId: token }));
+                      void performLogin(token);
+                    }}
+                  />
+                ) : (
+                  <Turnstile
+                    options={{ theme: captchaTheme }}
+                    siteKey={siteKey}
+                    onError={() => {
+                      toast.error("验证失败，请刷新重试");
+                      setLoading(false);
+                    }}
+                    onExpire={() => {
+                      setForm((prev) => ({ ...prev, captchaId: "" }));
+                    }}
+                    onSuccess={(token) => {
+                      setForm((prev) => ({ ...prev, captchaId: token }));
+                      void performLogin(token);
+                    }}
+                  />
+                )}
               </div>
             </div>
           </div>
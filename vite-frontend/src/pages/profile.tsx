@@ -15,7 +15,12 @@ import { useNavigate } from "react-router-dom";
 
 import { isWebViewFunc } from "@/utils/panel";
 import { siteConfig } from "@/config/site";
-import { updatePassword } from "@/api";
+import {
+  updatePassword,
+  passkeyRegisterBegin,
+  passkeyRegisterFinish,
+} from "@/api";
+import { createPasskey, passkeySupported } from "@/utils/passkey";
 import { safeLogout } from "@/utils/logout";
 interface PasswordForm {
   newUsername: string;
@@ -141,6 +146,41 @@ export default function ProfilePage() {
     navigate("/", { replace: true });
   };
 
+  // 退出所有设备上的登录
+  const handleLogoutAll = () => {
+    safeLogout(true);
+    navigate("/", { replace: true });
+  };
+
+  // 为当前账号添加通行密钥
+  const handleAddPasskey = async () => {
+    try {
+      const beginResp = await passkeyRegisterBegin();
+
+      if (beginResp.code !== 0) {
+        toast.error(beginResp.msg || "添加通行密钥失败");
+
+        return;
+      }
+      const attestation = await createPasskey(beginResp.data);
+
+      if (!attestation) return;
+      const finishResp = await passkeyRegisterFinish({
+        name: "",
+        ...attestation,
+      });
+
+      if (finishResp.code !== 0) {
+        toast.error(finishResp.msg || "添加通行密钥失败");
+
+        return;
+      }
+      toast.success("通行密钥已添加");
+    } catch {
+      toast.error("通行密钥创建已取消或失败");
+    }
+  };
+
   // 密码表单验证
   const validatePasswordForm = (): boolean => {
     if (!passwordForm.newUsername.trim()) {
@@ -298,6 +338,54 @@ export default function ProfilePage() {
                 </span>
               </button>
 
+              {/* 添加通行密钥 */}
+              {passkeySupported() && (
+                <button
+                  className="flex flex-col items-center p-3 rounded-2xl bg-gray-50 dark:bg-default-100 hover:bg-gray-100 dark:hover:bg-default-200 transition-colors duration-200"
+                  onClick={handleAddPasskey}
+                >
+                  <div className="w-10 h-10 bg-green-100 dark:bg-green-500/20 text-green-600 dark:text-green-400 rounded-full flex items-center justify-center mb-2">
+                    <svg
+                      className="w-5 h-5"
+                      fill="currentColor"
+                      viewBox="0 0 20 20"
+                    >
+                      <path
+                        clipRule="evenodd"
+                        d="M2.166 4.999A11.954 11.954 0 0010 1.944 11.954 11.954 0 0017.834 5c.11.65.166 1.32.166 2.001 0 5.225-3.34 9.67-8 11.317C5.34 16.67 2 12.225 2 7c0-.682.057-1.35.166-2.001zm11.541 3.708a1 1 0 00-1.414-1.414L9 10.586 7.707 9.293a1 1 0 00-1.414 1.414l2 2a1 1 0 001.414 0l4-4z"
+                        fillRule="evenodd"
+                      />
+                    </svg>
+                  </div>
+                  <span className="text-xs text-foreground text-center">
+                    添加通行密钥
+                  </span>
+                </button>
+              )}
+
+              {/* 退出所有设备 */}
+              <button
+                className="flex flex-col items-center p-3 rounded-2xl bg-gray-50 dark:bg-default-100 hover:bg-gray-100 dark:hover:bg-default-200 transition-colors duration-200"
+                onClick={handleLogoutAll}
+              >
+                <div className="w-10 h-10 bg-orange-100 dark:bg-orange-500/20 text-orange-600 dark:text-orange-400 rounded-full flex items-center justify-center mb-2">
+                  <svg
+                    className="w-5 h-5"
+                    fill="currentColor"
+                    viewBox="0 0 20 20"
+                  >
+                    <path
+                      clipRule="evenodd"
+                      d="M3 3a1 1 0 00-1 1v12a1 1 0 102 0V4a1 1 0 00-1-1zm10.293 9.293a1 1 0 001.414 1.414l3-3a1 1 0 000-1.414l-3-3a1 1 0 10-1.414 1.414L14.586 9H7a1 1 0 100 2h7.586l-1.293 1.293z"
+                      fillRule="evenodd"
+                    />
+                  </svg>
+                </div>
+                <span className="text-xs text-foreground text-center">
+                  退出所有设备
+                </span>
+              </button>
+
               {/* 退出登录 */}
               <button
                 className="flex flex-col items-center p-3 rounded-2xl bg-gray-50 dark:bg-default-100 hover:bg-gray-100 dark:hover:bg-default-200 transition-colors duration-200"
@@ -1,7 +1,18 @@
+import axios from "axios";
+
 /**
  * 安全退出登录函数
- * 清除登录相关数据，但保留用户偏好设置（如主题）
+ * 通知后端吊销当前会话，并清除登录相关数据，但保留用户偏好设置（如主题）
+ * everywhere 为 true 时吊销该用户在所有设备上的会话
  */
-export const safeLogout = () => {
+export const safeLogout = (everywhere = false) => {
+  const token = localStorage.getItem("token");
+
+  if (token) {
+    // 吊销失败不影响本地退出
+    axios
+      .post(everywhere ? "/user/logout-all" : "/user/logout", {}, { headers: { Authorization: token } })
+      .catch(() => {});
+  }
   localStorage.clear();
 };
@@ -0,0 +1,82 @@
+/**
+ * 通行密钥(WebAuthn)辅助函数
+ * 后端以无填充的base64url传递所有二进制字段
+ */
+
+const toBuffer = (value: string): ArrayBuffer => {
+  const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+  const padded = base64 + "=".repeat((4 - (base64.length % 4)) % 4);
+  const binary = atob(padded);
+  const bytes = new Uint8Array(binary.length);
+
+  for (let i = 0; i < binary.length; i++) {
+    bytes[i] = binary.charCodeAt(i);
+  }
+
+  return bytes.buffer;
+};
+
+const fromBuffer = (buffer: ArrayBuffer): string => {
+  const bytes = new Uint8Array(buffer);
+  let binary = "";
+
+  for (let i = 0; i < bytes.length; i++) {
+    binary += String.fromCharCode(bytes[i]);
+  }
+
+  return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+};
+
+export const passkeySupported = (): boolean =>
+  typeof window !== "undefined" &&
+  !!window.PublicKeyCredential &&
+  !!navigator.credentials;
+
+// 根据后端返回的选项创建通行密钥
+export const createPasskey = async (options: any) => {
+  const credential = (await navigator.credentials.create({
+    publicKey: {
+      ...options,
+      challenge: toBuffer(options.challenge),
+      user: { ...options.user, id: toBuffer(options.user.id) },
+      excludeCredentials: (options.excludeCredentials ?? []).map((c: any) => ({
+        ...c,
+        id: toBuffer(c.id),
+      })),
+    },
+  })) as PublicKeyCredential | null;
+
+  if (!credential) return null;
+  const response = credential.response as AuthenticatorAttestationResponse;
+
+  return {
+    clientDataJSON: fromBuffer(response.clientDataJSON),
+    attestationObject: fromBuffer(response.attestationObject),
+  };
+};
+
+// 根据后端返回的选项使用通行密钥签名
+export const getPasskeyAssertion = async (options: any) => {
+  const credential = (await navigator.credentials.get({
+    publicKey: {
+      challenge: toBuffer(options.challenge),
+      rpId: options.rpId,
+      timeout: options.timeout,
+      userVerification: options.userVerification,
+      allowCredentials: (options.allowCredentials ?? []).map((c: any) => ({
+        ...c,
+        id: toBuffer(c.id),
+      })),
+    },
+  })) as PublicKeyCredential | null;
+
+  if (!credential) return null;
+  const response = credential.response as AuthenticatorAssertionResponse;
+
+  return {
+    credentialId: fromBuffer(credential.rawId),
+    clientDataJSON: fromBuffer(response.clientDataJSON),
+    authenticatorData: fromBuffer(response.authenticatorData),
+    signature: fromBuffer(response.signature),
+  };
+};